@@ -311,20 +311,62 @@ leap_client = function() {
 
 	this._model = null;
 
+	// The binding of the document our versions belong to, used for resuming after a reconnect
+	this._epoch = null;
+
 	this._cursor_position = 0;
 
+	this._metadata = null;
+
+	// Protocol extensions offered to the server, and those the server agreed to
+	this._extensions = null;
+	this._agreed_extensions = [];
+
+	// Capabilities and limits advertised by the server on connect, if it advertises them
+	this._capabilities = null;
+
+	// The language to receive server messages in, and the code and structured form of the last error
+	// from the server
+	this._locale = null;
+	this._error_code = null;
+	this._error_info = null;
+
+	// Our user ID and the peers we share cursors with directly, when peer_assist is agreed
+	this._user_id = null;
+	this._ice_servers = [];
+	this._peers = {};
+	this._cursor_timer = null;
+	this._cursor_sent = 0;
+
+	// The metadata of the document (such as a title, language or tags)
+	this._document_metadata = {};
+
 	this.EVENT_TYPE = {
 		CONNECT: "connect",
+		HELLO: "hello",
 		DISCONNECT: "disconnect",
 		DOCUMENT: "document",
+		RESUME: "resume",
 		TRANSFORMS: "transforms",
 		USER: "user",
+		PRESENCE: "presence",
+		SHUTDOWN: "shutdown",
+		DEGRADED: "degraded",
+		DIGEST: "digest",
+		BANNER: "banner",
+		ANNOUNCEMENT: "announcement",
+		METADATA: "metadata",
+		COMMENT: "comment",
+		PEER: "peer",
 		ERROR: "error"
 	};
 
 	// Milliseconds period between cursor position updates to server
 	this._POSITION_POLL_PERIOD = 500;
 
+	// Milliseconds period between cursor position updates to server whilst sharing them with peers
+	this._PEER_CURSOR_PERIOD = 2000;
+
 	this._events = {};
 };
 
@@ -410,9 +452,38 @@ leap_client.prototype._process_message = function(message) {
 				this._document_id + " != " + message.leap_document.id;
 		}
 		this.document_id = message.leap_document.id;
+		this._agreed_extensions = ( message.extensions instanceof Array ) ? message.extensions : [];
+		this._epoch = ( typeof(message.epoch) === "string" ) ? message.epoch : null;
+		this._user_id = ( typeof(message.user_id) === "string" ) ? message.user_id : null;
+		this._ice_servers = ( message.ice_servers instanceof Array ) ? message.ice_servers : [];
+		this._document_metadata = ( typeof(message.leap_document.metadata) === "object" &&
+			message.leap_document.metadata !== null ) ? message.leap_document.metadata : {};
 		this._model = new leap_model(message.version);
 		this._dispatch_event(this.EVENT_TYPE.DOCUMENT, [ message.leap_document ]);
 		break;
+	case "resume":
+		if ( message.version <= 0 ) {
+			return "message resume received but without valid version";
+		}
+		this._agreed_extensions = ( message.extensions instanceof Array ) ? message.extensions : [];
+		this._epoch = ( typeof(message.epoch) === "string" ) ? message.epoch : null;
+		this._model = new leap_model(message.version);
+		this._dispatch_event(this.EVENT_TYPE.RESUME, [ message.version ]);
+
+		// The local document is kept, and so only the missed transforms need applying
+		if ( !(message.transforms instanceof Array) || message.transforms.length === 0 ) {
+			break;
+		}
+		validate_error = this._model._validate_transforms(message.transforms);
+		if ( validate_error !== undefined ) {
+			return "received missed transforms with error: " + validate_error;
+		}
+		action_obj = this._model.receive(message.transforms);
+		action_err = this._do_action(action_obj);
+		if ( action_err !== undefined ) {
+			return "failed to receive missed transforms: " + action_err;
+		}
+		break;
 	case "transforms":
 		if ( this._model === null ) {
 			return "transforms were received before initialization";
@@ -440,9 +511,40 @@ leap_client.prototype._process_message = function(message) {
 			return "received updatess with error: " + validate_error;
 		}
 		for ( var i = 0, l = message.user_updates.length; i < l; i++ ) {
+			if ( message.user_updates[i].active === false ) {
+				this._peer_close(message.user_updates[i].user_id);
+			} else {
+				this._peer_discovered(message.user_updates[i].user_id);
+			}
 			this._dispatch_event(this.EVENT_TYPE.USER, [ message.user_updates[i] ]);
 		}
 		break;
+	case "signal":
+		return this._peer_signal(message.signal);
+	case "comments":
+		if ( !(message.comments instanceof Array) ) {
+			return "message comments type contained invalid comments";
+		}
+		for ( var i = 0, l = message.comments.length; i < l; i++ ) {
+			this._dispatch_event(this.EVENT_TYPE.COMMENT, [ message.comments[i] ]);
+		}
+		break;
+	case "metadata":
+		if ( typeof(message.metadata) !== "object" || message.metadata === null ) {
+			return "message metadata type contained invalid metadata";
+		}
+		this._document_metadata = message.metadata;
+		this._dispatch_event(this.EVENT_TYPE.METADATA, [ message.metadata ]);
+		break;
+	case "presence":
+		if ( null === message.presence ||
+		   !(message.presence instanceof Array) ) {
+			return "message presence type contained invalid presence events";
+		}
+		for ( var i = 0, l = message.presence.length; i < l; i++ ) {
+			this._dispatch_event(this.EVENT_TYPE.PRESENCE, [ message.presence[i] ]);
+		}
+		break;
 	case "correction":
 		if ( this._model === null ) {
 			return "correction was received before initialization";
@@ -459,7 +561,45 @@ leap_client.prototype._process_message = function(message) {
 			return "model failed to correct: " + action_err;
 		}
 		break;
+	case "hello":
+		// The server is advertising what it supports, so that we can adapt before making requests
+		this._capabilities = message.capabilities || null;
+		this._dispatch_event(this.EVENT_TYPE.HELLO, [ this._capabilities ]);
+		break;
+	case "shutdown":
+		// The server is about to close the document, unsent changes should be flushed now
+		this._dispatch_event(this.EVENT_TYPE.SHUTDOWN, [ message.notice, message.code ]);
+		break;
+	case "degraded":
+		// The server is struggling to store the document, changes may take longer to persist
+		this._dispatch_event(this.EVENT_TYPE.DEGRADED, [ message.degraded === true, message.notice, message.code ]);
+		break;
+	case "ping":
+		// A heartbeat from the server, which measures our round trip time from the echoed ping
+		if ( this._socket !== null ) {
+			this._socket.send(JSON.stringify({
+				command : "pong",
+				ping : message.ping
+			}));
+		}
+		break;
+	case "digest":
+		// Activity held back by our notification preferences, summarised since the last digest
+		this._dispatch_event(this.EVENT_TYPE.DIGEST, [ message.digest || {} ]);
+		break;
+	case "banner":
+		// A banner for every client of the server, which is empty once it has been removed
+		this._dispatch_event(this.EVENT_TYPE.BANNER, [ message.banner || "" ]);
+		break;
+	case "announcement":
+		// A one-off message from the operators of the server, with a severity and optional expiry
+		if ( typeof(message.announcement) === "object" ) {
+			this._dispatch_event(this.EVENT_TYPE.ANNOUNCEMENT, [ message.announcement ]);
+		}
+		break;
 	case "error":
+		this._error_code = ( typeof(message.code) === "string" ) ? message.code : null;
+		this._error_info = ( typeof(message.error_info) === "object" ) ? message.error_info : null;
 		if ( this._socket !== null ) {
 			this._socket.close();
 		}
@@ -509,6 +649,65 @@ leap_client.prototype.send_message = function(message) {
 	}));
 };
 
+/* send_comment sends a chat message out to all other users of the document, or a comment on a span
+ * of the document when given the position (and optionally length) of the span as an anchor, for
+ * example { position: 10, length: 4 }. Anchored comments are kept by the server, and are sent to
+ * users that join later.
+ */
+leap_client.prototype.send_comment = function(text, anchor) {
+	if ( "string" !== typeof(text) || text.length === 0 ) {
+		return "must supply comment as a non-empty string value";
+	}
+	var comment = { text: text };
+	if ( anchor !== undefined && anchor !== null ) {
+		if ( "number" !== typeof(anchor.position) ) {
+			return "comment anchor must have a valid integer position";
+		}
+		comment.anchor = { position: anchor.position };
+		if ( "number" === typeof(anchor.length) ) {
+			comment.anchor.length = anchor.length;
+		}
+	}
+
+	this._socket.send(JSON.stringify({
+		command: "comment",
+		comment: comment
+	}));
+};
+
+/* update_document_metadata changes the metadata of the document (such as its title, language or
+ * tags) for all users of the document, a key set to an empty string is removed. Changes from read
+ * only clients are ignored by the server.
+ */
+leap_client.prototype.update_document_metadata = function(changes) {
+	if ( typeof(changes) !== "object" || changes === null ) {
+		return "metadata must be an object of string values";
+	}
+	for ( var key in changes ) {
+		if ( changes.hasOwnProperty(key) ) {
+			if ( typeof(changes[key]) !== "string" ) {
+				return "metadata must be an object of string values";
+			}
+			if ( changes[key].length === 0 ) {
+				delete this._document_metadata[key];
+			} else {
+				this._document_metadata[key] = changes[key];
+			}
+		}
+	}
+
+	this._socket.send(JSON.stringify({
+		command:  "metadata",
+		metadata: changes
+	}));
+};
+
+/* document_metadata returns the metadata of the document as last known to this client.
+ */
+leap_client.prototype.document_metadata = function() {
+	return this._document_metadata;
+};
+
 /* update_cursor is the function to call to send the server (and all other clients) an update to your
  * current cursor position in the document, this shows others where your point of interest is in the
  * shared document.
@@ -519,12 +718,287 @@ leap_client.prototype.update_cursor = function(position) {
 	}
 
 	this._cursor_position = position;
+
+	// Peers receive our cursor directly, and so the server only needs it occasionally
+	if ( this._peer_broadcast({ position: position }) ) {
+		this._schedule_cursor();
+		return;
+	}
+	this._send_cursor();
+};
+
+/* _send_cursor sends our current cursor position to the server.
+ */
+leap_client.prototype._send_cursor = function() {
+	this._cursor_sent = new Date().getTime();
 	this._socket.send(JSON.stringify({
-		command:  "update",
+		command:  "cursor",
 		position: this._cursor_position
 	}));
 };
 
+/* _schedule_cursor sends our cursor position to the server once _PEER_CURSOR_PERIOD has passed since
+ * it was last sent, for clients that we do not share cursors with directly.
+ */
+leap_client.prototype._schedule_cursor = function() {
+	if ( this._cursor_timer !== null ) {
+		return;
+	}
+	var leap_obj = this;
+	var delay = Math.max(0, this._cursor_sent + this._PEER_CURSOR_PERIOD - new Date().getTime());
+	this._cursor_timer = setTimeout(function() {
+		leap_obj._cursor_timer = null;
+		if ( leap_obj._socket !== null && leap_obj._socket.readyState === 1 ) {
+			leap_obj._send_cursor();
+		}
+	}, delay);
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/* _send_signal sends a signal to another client through the server.
+ */
+leap_client.prototype._send_signal = function(user_id, data) {
+	if ( this._socket === null || this._socket.readyState !== 1 ) {
+		return;
+	}
+	this._socket.send(JSON.stringify({
+		command: "signal",
+		signal:  { to: user_id, data: data }
+	}));
+};
+
+/* _peer_discovered is called for every user we hear of, and begins connecting to them directly when
+ * the peer_assist extension is agreed. Of each pair of clients the one with the lower user ID makes
+ * the offer, the other prompts it to with a hello.
+ */
+leap_client.prototype._peer_discovered = function(user_id) {
+	if ( !this.has_extension("peer_assist") || this._user_id === null ||
+	   typeof(user_id) !== "string" || user_id === this._user_id ||
+	   this._peers[user_id] !== undefined || typeof(RTCPeerConnection) === "undefined" ) {
+		return;
+	}
+	if ( this._user_id < user_id ) {
+		this._peer_open(user_id, true);
+	} else {
+		this._peers[user_id] = { connection: null, channel: null };
+		this._send_signal(user_id, { type: "hello" });
+	}
+};
+
+/* _peer_open creates a connection to a peer, and makes the offer when we are the initiator.
+ */
+leap_client.prototype._peer_open = function(user_id, initiator) {
+	var leap_obj = this;
+	var ice_servers = [];
+	for ( var i = 0, l = this._ice_servers.length; i < l; i++ ) {
+		ice_servers.push({ urls: this._ice_servers[i] });
+	}
+
+	var peer = {
+		connection: new RTCPeerConnection({ iceServers: ice_servers }),
+		channel: null
+	};
+	this._peers[user_id] = peer;
+
+	peer.connection.onicecandidate = function(e) {
+		if ( e.candidate ) {
+			leap_obj._send_signal(user_id, { type: "candidate", candidate: e.candidate });
+		}
+	};
+
+	var bind_channel = function(channel) {
+		peer.channel = channel;
+		channel.onopen = function() {
+			leap_obj._dispatch_event(leap_obj.EVENT_TYPE.PEER, [ user_id, true ]);
+		};
+		channel.onclose = function() {
+			leap_obj._peer_close(user_id);
+		};
+		channel.onmessage = function(e) {
+			leap_obj._peer_message(user_id, e.data);
+		};
+	};
+
+	if ( !initiator ) {
+		peer.connection.ondatachannel = function(e) {
+			bind_channel(e.channel);
+		};
+		return peer;
+	}
+
+	// Cursor positions are superseded by the next, and so are never retransmitted
+	bind_channel(peer.connection.createDataChannel("leaps", { ordered: false, maxRetransmits: 0 }));
+	peer.connection.createOffer().then(function(offer) {
+		return peer.connection.setLocalDescription(offer);
+	}).then(function() {
+		leap_obj._send_signal(user_id, { type: "offer", sdp: peer.connection.localDescription });
+	}).catch(function() {
+		leap_obj._peer_close(user_id);
+	});
+	return peer;
+};
+
+/* _peer_signal processes a signal sent by another client through the server.
+ */
+leap_client.prototype._peer_signal = function(signal) {
+	if ( null === signal || "object" !== typeof(signal) ||
+	   "string" !== typeof(signal.from) || null === signal.data || "object" !== typeof(signal.data) ) {
+		return "message signal type contained invalid signal";
+	}
+	if ( !this.has_extension("peer_assist") || typeof(RTCPeerConnection) === "undefined" ) {
+		return;
+	}
+
+	var leap_obj = this, from = signal.from, data = signal.data;
+	var peer = this._peers[from];
+	var fail = function() {
+		leap_obj._peer_close(from);
+	};
+
+	switch (data.type) {
+	case "hello":
+		if ( this._user_id !== null && this._user_id < from && ( peer === undefined || peer.connection === null ) ) {
+			this._peer_open(from, true);
+		}
+		break;
+	case "offer":
+		if ( peer === undefined || peer.connection === null ) {
+			peer = this._peer_open(from, false);
+		}
+		peer.connection.setRemoteDescription(data.sdp).then(function() {
+			return peer.connection.createAnswer();
+		}).then(function(answer) {
+			return peer.connection.setLocalDescription(answer);
+		}).then(function() {
+			leap_obj._send_signal(from, { type: "answer", sdp: peer.connection.localDescription });
+		}).catch(fail);
+		break;
+	case "answer":
+		if ( peer !== undefined && peer.connection !== null ) {
+			peer.connection.setRemoteDescription(data.sdp).catch(fail);
+		}
+		break;
+	case "candidate":
+		if ( peer !== undefined && peer.connection !== null ) {
+			peer.connection.addIceCandidate(data.candidate).catch(function() {});
+		}
+		break;
+	}
+};
+
+/* _peer_message dispatches the cursor position sent to us directly by a peer as a user update.
+ */
+leap_client.prototype._peer_message = function(user_id, text) {
+	var update;
+	try {
+		update = JSON.parse(text);
+	} catch (e) {
+		return;
+	}
+	if ( null === update || "object" !== typeof(update) || "number" !== typeof(update.position) ) {
+		return;
+	}
+	this._dispatch_event(this.EVENT_TYPE.USER, [ {
+		user_id: user_id,
+		position: update.position,
+		active: true
+	} ]);
+};
+
+/* _peer_broadcast sends an object to every peer we are connected to directly, returns whether there
+ * were any.
+ */
+leap_client.prototype._peer_broadcast = function(obj) {
+	var sent = false, text = JSON.stringify(obj);
+	for ( var user_id in this._peers ) {
+		var peer = this._peers[user_id];
+		if ( this._peers.hasOwnProperty(user_id) && peer.channel !== null && peer.channel.readyState === "open" ) {
+			peer.channel.send(text);
+			sent = true;
+		}
+	}
+	return sent;
+};
+
+/* _peer_close closes the connection to a peer, if we have one.
+ */
+leap_client.prototype._peer_close = function(user_id) {
+	var peer = this._peers[user_id];
+	if ( peer === undefined ) {
+		return;
+	}
+	delete this._peers[user_id];
+	if ( peer.connection !== null ) {
+		peer.connection.close();
+		this._dispatch_event(this.EVENT_TYPE.PEER, [ user_id, false ]);
+	}
+};
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/* set_metadata attaches details about this user (such as a display name or colour) which are shared
+ * with other users through presence events. Must be called before joining or creating a document.
+ */
+leap_client.prototype.set_metadata = function(metadata) {
+	if ( typeof(metadata) !== "object" || metadata === null ) {
+		return "metadata must be an object of string values";
+	}
+	this._metadata = metadata;
+};
+
+/* set_extensions lists the optional protocol extensions (such as "presence" or "diagnostics") to
+ * offer the server. Must be called before joining or creating a document, once joined use
+ * has_extension to check which of them the server agreed to.
+ */
+leap_client.prototype.set_extensions = function(extensions) {
+	if ( !(extensions instanceof Array) ) {
+		return "extensions must be an array of strings";
+	}
+	this._extensions = extensions;
+};
+
+/* has_extension returns whether the server agreed to a protocol extension when we joined.
+ */
+leap_client.prototype.has_extension = function(extension) {
+	return this._agreed_extensions.indexOf(extension) !== -1;
+};
+
+/* set_locale sets the language (such as "fr" or "pt-BR") that the server should send its messages
+ * in, rather than that of the browser. Must be called before joining or creating a document.
+ */
+leap_client.prototype.set_locale = function(locale) {
+	if ( typeof(locale) !== "string" ) {
+		return "locale must be a string";
+	}
+	this._locale = locale;
+};
+
+/* error_code returns the code of the last error sent by the server (such as "kicked"), for frontends
+ * that render messages in their own language, or null if the server did not send one.
+ */
+leap_client.prototype.error_code = function() {
+	return this._error_code;
+};
+
+/* error_info returns the structured form of the last error sent by the server, an object with a
+ * stable code (such as "AUTH_FAILED", "DOC_NOT_FOUND", "VERSION_MISMATCH" or "RATE_LIMITED") and a
+ * retryable flag hinting whether the request may succeed if repeated, or null if the server did not
+ * send one.
+ */
+leap_client.prototype.error_info = function() {
+	return this._error_info;
+};
+
+/* capabilities returns the capabilities and limits advertised by the server when we connected, or
+ * null if the server did not advertise any.
+ */
+leap_client.prototype.capabilities = function() {
+	return this._capabilities;
+};
+
 /* join_document prompts the client to request to join a document from the server. It will return an
  * error message if there is a problem with the request.
  */
@@ -546,10 +1020,193 @@ leap_client.prototype.join_document = function(id, token) {
 	this._socket.send(JSON.stringify({
 		command : "find",
 		token : token,
+		presence : true,
+		metadata : this._metadata,
+		extensions : this._offered_extensions(),
+		snapshot_codecs : this._snapshot_codecs(),
+		locale : this._locale,
 		document_id : this._document_id
 	}));
 };
 
+/* _offered_extensions returns the protocol extensions to offer the server, which are those set with
+ * set_extensions along with "heartbeat", as we always answer the pings of the server, and "deflate"
+ * where the browser is able to inflate compressed messages.
+ */
+leap_client.prototype._offered_extensions = function() {
+	var extensions = ( this._extensions instanceof Array ) ? this._extensions.slice() : [];
+	if ( extensions.indexOf("heartbeat") === -1 ) {
+		extensions.push("heartbeat");
+	}
+	if ( typeof(DecompressionStream) !== "undefined" && extensions.indexOf("deflate") === -1 ) {
+		extensions.push("deflate");
+	}
+	return extensions;
+};
+
+/* _receive handles a message from the server. Messages compressed by the deflate extension arrive as
+ * binary frames, and messages may carry a payload encoded with a snapshot codec, both of which are
+ * decoded asynchronously. Until they are handled any messages that follow them are queued behind
+ * them in order to preserve the order of transforms.
+ */
+leap_client.prototype._receive = function(data) {
+	if ( typeof(data) === "string" && this._inflating === null ) {
+		data = this._parse_message(data);
+		if ( data === null ) {
+			return;
+		}
+		if ( typeof(data.payload) !== "object" || data.payload === null ) {
+			this._handle_message(data);
+			return;
+		}
+	}
+
+	var leap_obj = this;
+	var queued = ( this._inflating || Promise.resolve() ).then(function() {
+		return ( data instanceof ArrayBuffer ) ? leap_obj._inflate(data) : data;
+	}).then(function(message) {
+		var message_obj = ( typeof(message) === "string" ) ? leap_obj._parse_message(message) : message;
+		if ( message_obj !== null && typeof(message_obj.payload) === "object" && message_obj.payload !== null ) {
+			return leap_obj._decode_payload(message_obj);
+		}
+		return message_obj;
+	}).then(function(message_obj) {
+		if ( message_obj !== null ) {
+			leap_obj._handle_message(message_obj);
+		}
+	}, function(e) {
+		leap_obj._dispatch_event(leap_obj.EVENT_TYPE.ERROR, [ "failed to decode message: " + e ]);
+	});
+
+	this._inflating = queued;
+	queued.then(function() {
+		if ( leap_obj._inflating === queued ) {
+			leap_obj._inflating = null;
+		}
+	});
+};
+
+/* _inflate returns a promise of the text of a message compressed with raw deflate.
+ */
+leap_client.prototype._inflate = function(buffer) {
+	var stream = new Blob([ buffer ]).stream().pipeThrough(new DecompressionStream("deflate-raw"));
+	return new Response(stream).text();
+};
+
+/* _snapshot_codecs returns the snapshot codecs we are able to decode, the server compresses large
+ * documents and resyncs with one of them.
+ */
+leap_client.prototype._snapshot_codecs = function() {
+	if ( typeof(DecompressionStream) === "undefined" ) {
+		return undefined;
+	}
+	return [ "gzip" ];
+};
+
+/* _decode_payload returns a promise of a message with its encoded payload decoded back into the
+ * document of a "document" message, or the transforms of a "resume" message.
+ */
+leap_client.prototype._decode_payload = function(message_obj) {
+	var payload = message_obj.payload;
+	if ( payload.codec !== "gzip" || typeof(payload.data) !== "string" ) {
+		return Promise.reject("unsupported payload codec: " + payload.codec);
+	}
+
+	var binary = atob(payload.data);
+	var bytes = new Uint8Array(binary.length);
+	for ( var i = 0; i < binary.length; i++ ) {
+		bytes[i] = binary.charCodeAt(i);
+	}
+	var stream = new Blob([ bytes ]).stream().pipeThrough(new DecompressionStream("gzip"));
+
+	return new Response(stream).text().then(function(text) {
+		var decoded = JSON.parse(text);
+		if ( message_obj.response_type === "document" ) {
+			message_obj.leap_document = decoded;
+		} else {
+			message_obj.transforms = decoded;
+		}
+		delete message_obj.payload;
+		return message_obj;
+	});
+};
+
+/* _parse_message parses the text of a message from the server, returning null if it is not valid.
+ */
+leap_client.prototype._parse_message = function(message_text) {
+	try {
+		return JSON.parse(message_text);
+	} catch (e) {
+		this._dispatch_event(this.EVENT_TYPE.ERROR,
+			[ JSON.stringify(e.message) + " (" + e.lineNumber + "): " + message_text ]);
+	}
+	return null;
+};
+
+/* _handle_message processes a parsed message from the server.
+ */
+leap_client.prototype._handle_message = function(message_obj) {
+	var err = this._process_message(message_obj);
+	if ( typeof(err) === "string" ) {
+		this._dispatch_event(this.EVENT_TYPE.ERROR, [ err ]);
+	}
+};
+
+/* resume_point returns the epoch and version of the local document, which can be given to
+ * resume_document after a reconnect. Returns null if the server did not agree to the "resume"
+ * extension, or if local changes are still awaiting confirmation from the server.
+ */
+leap_client.prototype.resume_point = function() {
+	if ( !this._model || this._epoch === null || this._model._leap_state !== this._model.READY ||
+		this._model._unsent.length > 0 ) {
+		return null;
+	}
+	return { epoch : this._epoch, version : this._model._version };
+};
+
+/* resume_document is join_document for a client that already holds the document at a resume_point,
+ * which receives only the transforms it missed if the server still has them. Otherwise the full
+ * document is sent as with join_document.
+ */
+leap_client.prototype.resume_document = function(id, token, point) {
+	if ( this._socket === null || this._socket.readyState !== 1 ) {
+		return "leap_client is not currently connected";
+	}
+
+	if ( typeof(id) !== "string" ) {
+		return "document id was not a string type";
+	}
+
+	if ( point === null || "object" !== typeof(point) || "string" !== typeof(point.epoch) ||
+		"number" !== typeof(point.version) ) {
+		return "resume point was not valid";
+	}
+
+	if ( this._document_id !== null ) {
+		return "a leap_client can only join a single document";
+	}
+
+	this._document_id = id;
+
+	var extensions = this._offered_extensions();
+	if ( extensions.indexOf("resume") === -1 ) {
+		extensions.push("resume");
+	}
+
+	this._socket.send(JSON.stringify({
+		command : "find",
+		token : token,
+		presence : true,
+		metadata : this._metadata,
+		extensions : extensions,
+		snapshot_codecs : this._snapshot_codecs(),
+		locale : this._locale,
+		document_id : this._document_id,
+		resume_epoch : point.epoch,
+		resume_version : point.version
+	}));
+};
+
 /* create_document submits content to be created into a fresh document and then binds to that
  * document.
  */
@@ -569,6 +1226,11 @@ leap_client.prototype.create_document = function(content, token) {
 	this._socket.send(JSON.stringify({
 		command : "create",
 		token : token,
+		presence : true,
+		metadata : this._metadata,
+		extensions : this._offered_extensions(),
+		snapshot_codecs : this._snapshot_codecs(),
+		locale : this._locale,
 		leap_document : {
 			content : content
 		}
@@ -580,6 +1242,8 @@ leap_client.prototype.create_document = function(content, token) {
  * document.
  */
 leap_client.prototype.connect = function(address, _websocket) {
+	this._capabilities = null;
+
 	try {
 		if ( _websocket !== undefined ) {
 				this._socket = _websocket;
@@ -594,23 +1258,12 @@ leap_client.prototype.connect = function(address, _websocket) {
 
 	var leap_obj = this;
 
-	this._socket.onmessage = function(message) {
-		var message_text = message.data;
-		var message_obj;
-
-		try {
-			message_obj = JSON.parse(message_text);
-		} catch (e) {
-			leap_obj._dispatch_event.apply(leap_obj,
-				[ leap_obj.EVENT_TYPE.ERROR,
-					[ JSON.stringify(e.message) + " (" + e.lineNumber + "): " + message_text ] ]);
-			return;
-		}
+	// Messages compressed by the deflate extension arrive as binary frames
+	this._socket.binaryType = "arraybuffer";
+	this._inflating = null;
 
-		var err = leap_obj._process_message.apply(leap_obj, [ message_obj ]);
-		if ( typeof(err) === "string" ) {
-			leap_obj._dispatch_event.apply(leap_obj, [ leap_obj.EVENT_TYPE.ERROR, [ err ] ]);
-		}
+	this._socket.onmessage = function(message) {
+		leap_obj._receive(message.data);
 	};
 
 	this._socket.onclose = function() {
@@ -643,6 +1296,15 @@ leap_client.prototype.close = function() {
 	if ( undefined !== this._heartbeat ) {
 		clearTimeout(this._heartbeat);
 	}
+	if ( this._cursor_timer !== null ) {
+		clearTimeout(this._cursor_timer);
+		this._cursor_timer = null;
+	}
+	for ( var user_id in this._peers ) {
+		if ( this._peers.hasOwnProperty(user_id) ) {
+			this._peer_close(user_id);
+		}
+	}
 	if ( this._socket !== null && this._socket.readyState === 1 ) {
 		this._socket.close();
 		this._socket = null;
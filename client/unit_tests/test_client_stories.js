@@ -29,7 +29,7 @@ var fs   = require('fs'),
     la   = require('../leapclient').apply;
 
 var client_stories_text = fs.readFileSync(
-		path.resolve(__dirname, "./../../test/stories/", "./client_stories.js"), "utf8");
+		path.resolve(__dirname, "./../../test/conformance/v1/", "./client_stories.json"), "utf8");
 
 var stories = JSON.parse(client_stories_text).client_stories;
 
@@ -32,12 +32,14 @@ import (
 	"path/filepath"
 	"runtime"
 	"syscall"
+	"time"
 
 	"github.com/jeffail/leaps/lib"
 	"github.com/jeffail/leaps/lib/auth"
 	"github.com/jeffail/leaps/lib/register"
 	"github.com/jeffail/leaps/lib/store"
 	"github.com/jeffail/leaps/net"
+	"github.com/jeffail/leaps/net/grpc"
 	"github.com/jeffail/util"
 	"github.com/jeffail/util/log"
 	"github.com/jeffail/util/path"
@@ -48,8 +50,8 @@ import (
 
 /*
 LeapsConfig - The all encompassing leaps configuration. Contains configurations for individual leaps
-components, which determine the role of this leaps instance. Currently a stand alone leaps server is
-the only supported role.
+components, which determine the role of this leaps instance. A leaps instance either serves documents
+itself, or routes its clients to a set of upstream leaps instances when the router has upstreams.
 */
 type LeapsConfig struct {
 	NumProcesses         int                      `json:"num_processes" yaml:"num_processes"`
@@ -58,10 +60,17 @@ type LeapsConfig struct {
 	RiemannConfig        log.RiemannClientConfig  `json:"riemann" yaml:"riemann"`
 	StoreConfig          store.Config             `json:"storage" yaml:"storage"`
 	AuthenticatorConfig  auth.Config              `json:"authenticator" yaml:"authenticator"`
+	OIDCConfig           net.OIDCConfig           `json:"oidc" yaml:"oidc"`
 	CuratorConfig        lib.CuratorConfig        `json:"curator" yaml:"curator"`
+	SchedulerConfig      lib.SchedulerConfig      `json:"scheduler" yaml:"scheduler"`
+	NamespaceConfig      lib.NamespaceConfig      `json:"namespace" yaml:"namespace"`
 	HTTPServerConfig     net.HTTPServerConfig     `json:"http_server" yaml:"http_server"`
+	RouterConfig         net.RouterConfig         `json:"router" yaml:"router"`
+	GRPCServerConfig     grpc.Config              `json:"grpc_server" yaml:"grpc_server"`
 	InternalServerConfig net.InternalServerConfig `json:"admin_server" yaml:"admin_server"`
 	StatsServerConfig    log.StatsServerConfig    `json:"stats_server" yaml:"stats_server"`
+	MigrateConfig        MigrateConfig            `json:"migration" yaml:"migration"`
+	ReplayConfig         ReplayConfig             `json:"replay" yaml:"replay"`
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -124,12 +133,22 @@ func main() {
 		RiemannConfig:        log.NewRiemannClientConfig(),
 		StoreConfig:          store.NewConfig(),
 		AuthenticatorConfig:  auth.NewConfig(),
+		OIDCConfig:           net.NewOIDCConfig(),
 		CuratorConfig:        lib.DefaultCuratorConfig(),
+		SchedulerConfig:      lib.DefaultSchedulerConfig(),
+		NamespaceConfig:      lib.NamespaceConfig{},
 		HTTPServerConfig:     net.DefaultHTTPServerConfig(),
+		RouterConfig:         net.NewRouterConfig(),
+		GRPCServerConfig:     grpc.NewConfig(),
 		InternalServerConfig: net.NewInternalServerConfig(),
 		StatsServerConfig:    log.DefaultStatsServerConfig(),
+		MigrateConfig:        NewMigrateConfig(),
+		ReplayConfig:         NewReplayConfig(),
 	}
 
+	// Defaults derived from container limits, which the loaded config may still override
+	resourceLimits := applyResourceDefaults(&leapsConfig)
+
 	// A list of default config paths to check for if not explicitly defined
 	defaultPaths := []string{}
 
@@ -153,6 +172,9 @@ func main() {
 		"/etc/leaps/config.json",
 	}...)
 
+	migrate := isMigrateCommand()
+	replay := isReplayCommand()
+
 	// Load configuration etc
 	if !util.Bootstrap(&leapsConfig, defaultPaths...) {
 		return
@@ -163,6 +185,13 @@ func main() {
 		leapsConfig.StoreConfig.StoreDirectory = *sharePathOverride
 	}
 
+	if migrate {
+		os.Exit(runMigration(leapsConfig))
+	}
+	if replay {
+		os.Exit(runReplay(leapsConfig))
+	}
+
 	runtime.GOMAXPROCS(leapsConfig.NumProcesses)
 
 	// Logging and stats aggregation
@@ -182,15 +211,56 @@ func main() {
 
 	fmt.Printf("Launching a leaps instance, use CTRL+C to close.\n\n")
 
+	if resourceLimits.Limited() {
+		logger.NewModule(":main").Infof(
+			"Detected container limits of %v CPUs and %v bytes of memory, running with %v processes and %v max open binders\n",
+			resourceLimits.CPUs, resourceLimits.Memory, leapsConfig.NumProcesses, leapsConfig.CuratorConfig.MaxOpenBinders,
+		)
+	}
+
 	// Document storage engine
 	documentStore, err := store.Factory(leapsConfig.StoreConfig)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, fmt.Sprintf("Document store error: %v\n", err))
 		return
 	}
+	documentStore = store.Instrument(documentStore, leapsConfig.StoreConfig.Metrics, logger, stats)
+	defer store.Close(documentStore)
+
+	// Provision an empty store with the documents of the seed directory
+	if len(leapsConfig.StoreConfig.Seed.Directory) > 0 {
+		// Seeded documents are subject to the same ID policy as documents created by the admin API
+		idPolicy, err := lib.NewIDPolicy(leapsConfig.CuratorConfig.IDPolicy)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Document ID policy error: %v\n", err))
+			return
+		}
+		report, err := store.Seed(documentStore, leapsConfig.StoreConfig.Seed, idPolicy.Validate)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Document seed error: %v\n", err))
+			return
+		}
+		seedLogger := logger.NewModule(":main")
+		if report.Skipped {
+			seedLogger.Infoln("Document store is not empty, skipping seed")
+		} else {
+			seedLogger.Infof("Seeded document store with %v documents\n", report.Seeded)
+		}
+		for _, issue := range report.Issues {
+			seedLogger.Warnf("Failed to seed document %v: %v\n", issue.ID, issue.Issue)
+		}
+	}
 
-	// Authenticator
+	// Authenticator, logins through an OpenID Connect provider wrap the configured one
 	authenticator, err := auth.Factory(leapsConfig.AuthenticatorConfig, logger, stats)
+	if err == nil && leapsConfig.OIDCConfig.Enabled {
+		// Anarchy would let anyone in without logging in, and so only a configured one is wrapped
+		var fallback auth.Authenticator
+		if leapsConfig.AuthenticatorConfig.Type != "none" {
+			fallback = authenticator
+		}
+		authenticator, err = net.NewOIDC(leapsConfig.OIDCConfig, fallback, logger, stats)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, fmt.Sprintf("Authenticator error: %v\n", err))
 		return
@@ -204,14 +274,43 @@ func main() {
 	}
 	defer curator.Close()
 
+	// Flushes and broadcasts are only scheduled when the namespace of the curator has caps
+	if leapsConfig.NamespaceConfig != (lib.NamespaceConfig{}) {
+		scheduler := lib.NewScheduler(leapsConfig.SchedulerConfig)
+		curator.UseNamespace(scheduler.NewNamespace("default", leapsConfig.NamespaceConfig))
+	}
+
+	// Bind to hot documents before accepting clients
+	curator.Preload()
+
+	// Clients are either served by the curator or routed to upstream nodes
+	var locator net.LeapLocator = curator
+	if 0 < len(leapsConfig.RouterConfig.Upstreams) {
+		router, err := net.NewRouter(leapsConfig.RouterConfig, logger, stats)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Router error: %v\n", err))
+			return
+		}
+		defer router.Close()
+		if err = router.UseAuthenticator(authenticator); err == nil {
+			logger.NewModule(":main").Infoln("Router is issuing its own tokens for upstream connections")
+		}
+		locator = router
+	}
+
 	// HTTP API
-	leapHTTP, err := net.CreateHTTPServer(curator, leapsConfig.HTTPServerConfig, logger, stats)
+	leapHTTP, err := net.CreateHTTPServer(locator, leapsConfig.HTTPServerConfig, logger, stats)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, fmt.Sprintf("HTTP error: %v\n", err))
 		return
 	}
 	defer leapHTTP.Stop()
 
+	// Clients with a verified certificate are identified by it when the authenticator supports it
+	if certAuth, ok := authenticator.(net.CertificateAuthenticator); ok {
+		leapHTTP.SetCertificateAuthenticator(certAuth)
+	}
+
 	go func() {
 		if httperr := leapHTTP.Listen(); httperr != nil {
 			fmt.Fprintln(os.Stderr, fmt.Sprintf("Http listen error: %v\n", httperr))
@@ -219,6 +318,25 @@ func main() {
 		closeChan <- true
 	}()
 
+	// gRPC API
+	if 0 < len(leapsConfig.GRPCServerConfig.Address) {
+		leapGRPC := grpc.NewServer(leapsConfig.GRPCServerConfig, logger, stats)
+		defer leapGRPC.Stop()
+
+		// Streams are served by the HTTP server, sharing its binder config, join limits and messages
+		go leapHTTP.ServeTransport(leapGRPC)
+
+		// Connections are screened by the IP guard of the HTTP server, sharing its per IP limits
+		leapGRPC.SetListenerWrapper(leapHTTP)
+
+		go func() {
+			if grpcerr := leapGRPC.Listen(); grpcerr != nil {
+				fmt.Fprintln(os.Stderr, fmt.Sprintf("gRPC listen error: %v\n", grpcerr))
+			}
+			closeChan <- true
+		}()
+	}
+
 	var adminRegister register.EndpointRegister
 
 	// Internal admin HTTP API
@@ -264,6 +382,19 @@ func main() {
 	// Wait for termination signal
 	select {
 	case <-sigChan:
+		// Give connected clients a chance to leave before their documents are closed
+		if err = leapHTTP.Drain(); err != nil {
+			fmt.Fprintln(os.Stderr, fmt.Sprintf("Drain error: %v\n", err))
+		}
+
+		// Keep the admin server up for long enough to fetch the shutdown report
+		if linger := leapsConfig.InternalServerConfig.ShutdownReportLinger; linger > 0 && adminRegister != nil {
+			logger.Infof("Serving the shutdown report for %vs before exiting\n", linger)
+			select {
+			case <-sigChan:
+			case <-time.After(time.Duration(linger) * time.Second):
+			}
+		}
 	case <-closeChan:
 	}
 }
@@ -0,0 +1,231 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AliasesConfig - Holds configuration options for vanity aliases of documents. Aliases are kept in
+memory only unless Path is set, in which case they are persisted to a JSON file there.
+*/
+type AliasesConfig struct {
+	Path string `json:"path" yaml:"path"`
+}
+
+/*
+NewAliasesConfig - Returns a default configuration for aliases.
+*/
+func NewAliasesConfig() AliasesConfig {
+	return AliasesConfig{
+		Path: "",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Aliases type.
+var (
+	ErrAliasNotExist = errors.New("alias does not exist")
+	ErrAliasTaken    = errors.New("alias is the ID of an existing document")
+)
+
+/*
+Aliases - Vanity names of documents, by which clients may join a document in place of its ID.
+*/
+type Aliases struct {
+	config  AliasesConfig
+	aliases map[string]string
+	mutex   sync.RWMutex
+}
+
+/*
+NewAliases - Creates a set of aliases, reading back any previously persisted to the configured path.
+*/
+func NewAliases(config AliasesConfig) (*Aliases, error) {
+	a := &Aliases{
+		config:  config,
+		aliases: map[string]string{},
+	}
+	if len(config.Path) == 0 {
+		return a, nil
+	}
+	data, err := ioutil.ReadFile(config.Path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &a.aliases); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+/*
+Resolve - Returns the ID of the document an alias points to, or the ID itself if it is not an alias.
+*/
+func (a *Aliases) Resolve(id string) string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if target, exists := a.aliases[id]; exists {
+		return target
+	}
+	return id
+}
+
+/*
+List - Returns every alias along with the ID of the document it points to.
+*/
+func (a *Aliases) List() map[string]string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	aliases := make(map[string]string, len(a.aliases))
+	for alias, id := range a.aliases {
+		aliases[alias] = id
+	}
+	return aliases
+}
+
+/*
+Set - Point an alias at a document, replacing any document it pointed to before, and persist all
+aliases when configured to.
+*/
+func (a *Aliases) Set(alias, id string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.aliases[alias] = id
+	return a.save()
+}
+
+/*
+Remove - Remove an alias, and persist all aliases when configured to.
+*/
+func (a *Aliases) Remove(alias string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.aliases[alias]; !exists {
+		return ErrAliasNotExist
+	}
+	delete(a.aliases, alias)
+	return a.save()
+}
+
+/*
+save - Write all aliases to the configured path, the file is replaced atomically. Must be called
+whilst holding the lock.
+*/
+func (a *Aliases) save() error {
+	if len(a.config.Path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(a.aliases, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(a.config.Path), ".aliases")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), a.config.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+SetAlias - Point a vanity alias at an existing document, after which clients may join the document
+by the alias in place of its ID. Aliases must follow the ID policy and may not be the ID of an
+existing document, which would otherwise be hidden by it.
+*/
+func (c *Curator) SetAlias(alias, id string) error {
+	if err := c.ids.Validate(alias); err != nil {
+		c.stats.Incr("curator.set_alias.rejected", 1)
+		return err
+	}
+	if _, err := c.store.Read(alias); err == nil {
+		c.stats.Incr("curator.set_alias.rejected", 1)
+		return ErrAliasTaken
+	}
+	if _, err := c.store.Read(id); err != nil {
+		c.stats.Incr("curator.set_alias.error", 1)
+		return err
+	}
+	if err := c.aliases.Set(alias, id); err != nil {
+		c.stats.Incr("curator.set_alias.error", 1)
+		return err
+	}
+	c.stats.Incr("curator.set_alias.success", 1)
+	return nil
+}
+
+/*
+RemoveAlias - Remove a vanity alias, the document it pointed to is left untouched.
+*/
+func (c *Curator) RemoveAlias(alias string) error {
+	return c.aliases.Remove(alias)
+}
+
+/*
+ListAliases - Returns every vanity alias along with the ID of the document it points to.
+*/
+func (c *Curator) ListAliases() map[string]string {
+	return c.aliases.List()
+}
+
+/*
+resolveAlias - Returns the ID of the document an alias points to, or the ID itself if it is not an
+alias.
+*/
+func (c *Curator) resolveAlias(id string) string {
+	return c.aliases.Resolve(id)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestCuratorAliases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.IDPolicy.ReservedPrefixes = []string{"admin/"}
+	config.Aliases.Path = filepath.Join(dir, "aliases.json")
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	portal, err := curator.CreateDocument("", "", store.Document{Content: "hello world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	docID := portal.Document.ID
+	portal.Exit(time.Second)
+
+	if err = curator.SetAlias("admin/notes", docID); err != ErrIDReservedRange {
+		t.Errorf("Wrong error for reserved alias: %v", err)
+	}
+	if err = curator.SetAlias(docID, docID); err != ErrAliasTaken {
+		t.Errorf("Wrong error for alias of a document ID: %v", err)
+	}
+	if err = curator.SetAlias("notes", "nope"); err != store.ErrDocumentNotExist {
+		t.Errorf("Wrong error for alias of a missing document: %v", err)
+	}
+	if err = curator.SetAlias("notes", docID); err != nil {
+		t.Fatal(err)
+	}
+
+	joined, err := curator.EditDocument("", "notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined.Document.ID != docID || joined.Document.Content != "hello world" {
+		t.Errorf("Wrong document joined by alias: %+v", joined.Document)
+	}
+	joined.Exit(time.Second)
+
+	// Aliases are read back from the configured path
+	aliases, err := NewAliases(config.Aliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := aliases.Resolve("notes"); id != docID {
+		t.Errorf("Wrong persisted alias: %v != %v", id, docID)
+	}
+
+	if err = curator.RemoveAlias("notes"); err != nil {
+		t.Fatal(err)
+	}
+	if err = curator.RemoveAlias("notes"); err != ErrAliasNotExist {
+		t.Errorf("Wrong error for removed alias: %v", err)
+	}
+	if len(curator.ListAliases()) != 0 {
+		t.Errorf("Expected no aliases: %v", curator.ListAliases())
+	}
+}
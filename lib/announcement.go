@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Severities of announcements.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Errors for announcements.
+var (
+	ErrEmptyAnnouncement   = errors.New("announcement has no message")
+	ErrInvalidSeverity     = errors.New("announcement severity must be info, warning or critical")
+	ErrAnnouncementExpired = errors.New("announcement has already expired")
+)
+
+/*
+Announcement - A one-off message from the operators of the server, such as a maintenance notice, sent
+to every connected client. Severity is one of info, warning or critical, and Expires is the unix time
+after which clients should stop showing it, zero for never.
+*/
+type Announcement struct {
+	Message  string `json:"message" yaml:"message"`
+	Severity string `json:"severity" yaml:"severity"`
+	Expires  int64  `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+}
+
+/*
+validate - Check the announcement, filling in the default severity when it is left empty.
+*/
+func (a *Announcement) validate() error {
+	if len(a.Message) == 0 {
+		return ErrEmptyAnnouncement
+	}
+	switch a.Severity {
+	case "":
+		a.Severity = SeverityInfo
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return ErrInvalidSeverity
+	}
+	if a.Expires > 0 && a.Expires <= time.Now().Unix() {
+		return ErrAnnouncementExpired
+	}
+	return nil
+}
+
+/*
+Announce - Send an announcement to every client of every open document. Clients that join afterwards
+do not receive it. Returns the first error encountered, although every binder is notified
+regardless.
+*/
+func (c *Curator) Announce(announcement Announcement, timeout time.Duration) error {
+	if err := announcement.validate(); err != nil {
+		c.stats.Incr("curator.announce.rejected", 1)
+		return err
+	}
+	c.log.Infof("Broadcasting %v announcement: %q\n", announcement.Severity, announcement.Message)
+	c.stats.Incr("curator.announce.sent", 1)
+	return c.Broadcast(ClientMessage{Announcement: &announcement}, timeout)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
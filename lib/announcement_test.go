@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestCuratorAnnounce(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	portal, err := curator.EditDocument("test", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, bad := range []struct {
+		announcement Announcement
+		err          error
+	}{
+		{Announcement{}, ErrEmptyAnnouncement},
+		{Announcement{Message: "hi", Severity: "apocalyptic"}, ErrInvalidSeverity},
+		{Announcement{Message: "hi", Expires: time.Now().Unix() - 10}, ErrAnnouncementExpired},
+	} {
+		if err = curator.Announce(bad.announcement, time.Second); err != bad.err {
+			t.Errorf("Wrong error for %+v: %v != %v", bad.announcement, err, bad.err)
+		}
+	}
+
+	if err = curator.Announce(Announcement{Message: "Maintenance at 5pm"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-portal.MessageRcvChan:
+		if msg.Announcement == nil {
+			t.Fatalf("Expected announcement: %+v", msg)
+		}
+		exp := Announcement{Message: "Maintenance at 5pm", Severity: SeverityInfo}
+		if *msg.Announcement != exp {
+			t.Errorf("Wrong announcement: %+v != %+v", *msg.Announcement, exp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Announcement was not received")
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AuditSyslogConfig - Holds configuration options for writing audit events to syslog. An empty Network
+and Address writes to the local syslog daemon.
+*/
+type AuditSyslogConfig struct {
+	Network string `json:"network" yaml:"network"`
+	Address string `json:"address" yaml:"address"`
+	Tag     string `json:"tag" yaml:"tag"`
+}
+
+/*
+NewAuditSyslogConfig - Returns a default syslog configuration, which writes to the local daemon.
+*/
+func NewAuditSyslogConfig() AuditSyslogConfig {
+	return AuditSyslogConfig{
+		Network: "",
+		Address: "",
+		Tag:     "leaps",
+	}
+}
+
+/*
+AuditConfig - Holds configuration options for the audit log, which records who did what to which
+document as structured events. Type is the sink events are written to, either 'none', 'file' (JSON
+lines appended to FilePath), 'syslog' or 'webhook'.
+*/
+type AuditConfig struct {
+	Type     string            `json:"type" yaml:"type"`
+	FilePath string            `json:"file_path" yaml:"file_path"`
+	Syslog   AuditSyslogConfig `json:"syslog" yaml:"syslog"`
+	Webhook  WebhookConfig     `json:"webhook" yaml:"webhook"`
+}
+
+/*
+NewAuditConfig - Returns a default audit configuration, the audit log is disabled.
+*/
+func NewAuditConfig() AuditConfig {
+	return AuditConfig{
+		Type:     "none",
+		FilePath: "",
+		Syslog:   NewAuditSyslogConfig(),
+		Webhook:  NewWebhookConfig(),
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the audit log.
+var (
+	ErrInvalidAuditType       = errors.New("invalid audit sink type")
+	ErrAuditPathMissing       = errors.New("audit file sink requires a file_path")
+	ErrAuditURLMissing        = errors.New("audit webhook sink requires a url")
+	ErrAuditSyslogUnsupported = errors.New("syslog is not supported on this platform")
+)
+
+// Types of audit events.
+const (
+	AuditDocumentCreated = "document_created"
+	AuditDocumentFlushed = "document_flushed"
+	AuditClientJoined    = "client_joined"
+	AuditClientLeft      = "client_left"
+	AuditClientKicked    = "client_kicked"
+	AuditAuthDenied      = "auth_denied"
+)
+
+/*
+AuditEvent - A single entry of the audit log. UserID is set where the user is known, such as the
+creator of a document, otherwise clients are identified by TokenHash, the SHA-256 of their token,
+so that the log never holds credentials. Version is the version of the document after a flush,
+Action is the action that was denied for an auth_denied event, and Reason why a client was kicked.
+*/
+type AuditEvent struct {
+	Time       string `json:"time"`
+	Event      string `json:"event"`
+	DocumentID string `json:"doc_id,omitempty"`
+	UserID     string `json:"user_id,omitempty"`
+	TokenHash  string `json:"token_hash,omitempty"`
+	ReadOnly   bool   `json:"read_only,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	Action     string `json:"action,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+/*
+auditTokenHash - Returns the hash identifying a client token in the audit log.
+*/
+func auditTokenHash(token string) string {
+	if len(token) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+/*
+AuditSink - Receives the events of the audit log. Write is called from a single goroutine of the
+auditor, in the order events were recorded.
+*/
+type AuditSink interface {
+	Write(event AuditEvent) error
+	Close() error
+}
+
+/*
+AuditSinkFactory - Returns an audit sink based on a configuration object, or nil when the audit log
+is disabled.
+*/
+func AuditSinkFactory(config AuditConfig, logger *log.Logger, stats *log.Stats) (AuditSink, error) {
+	switch config.Type {
+	case "none", "":
+		return nil, nil
+	case "file":
+		return newFileAuditSink(config.FilePath)
+	case "syslog":
+		return newSyslogAuditSink(config.Syslog)
+	case "webhook":
+		if len(config.Webhook.URL) == 0 {
+			return nil, ErrAuditURLMissing
+		}
+		webhook, err := NewWebhook(config.Webhook, logger, stats)
+		if err != nil {
+			return nil, err
+		}
+		return webhookAuditSink{webhook}, nil
+	}
+	return nil, ErrInvalidAuditType
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+fileAuditSink - Appends audit events as JSON lines to a file.
+*/
+type fileAuditSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func newFileAuditSink(path string) (AuditSink, error) {
+	if len(path) == 0 {
+		return nil, ErrAuditPathMissing
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileAuditSink{file: file}, nil
+}
+
+func (f *fileAuditSink) Write(event AuditEvent) error {
+	lineBytes, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	_, err = f.file.Write(append(lineBytes, '\n'))
+	return err
+}
+
+func (f *fileAuditSink) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
+
+/*
+webhookAuditSink - Posts each audit event to a webhook in the background.
+*/
+type webhookAuditSink struct {
+	webhook *Webhook
+}
+
+func (w webhookAuditSink) Write(event AuditEvent) error {
+	w.webhook.Post(event)
+	return nil
+}
+
+func (w webhookAuditSink) Close() error {
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// auditQueueSize - The most events an auditor holds while earlier events are being written.
+const auditQueueSize = 1000
+
+/*
+Auditor - Stamps audit events and writes them to a sink in the background, so that binder loops
+never wait on a file or syslog. Failures are logged rather than returned so that auditing never
+interrupts the document, and events recorded whilst the queue is full are dropped. A nil Auditor
+records nothing.
+*/
+type Auditor struct {
+	sink   AuditSink
+	queue  chan AuditEvent
+	done   chan struct{}
+	mutex  sync.RWMutex
+	closed bool
+	log    *log.Logger
+	stats  *log.Stats
+}
+
+/*
+NewAuditor - Creates an auditor writing to a sink, returns nil when the sink is nil.
+*/
+func NewAuditor(sink AuditSink, logger *log.Logger, stats *log.Stats) *Auditor {
+	if sink == nil {
+		return nil
+	}
+	a := &Auditor{
+		sink:  sink,
+		queue: make(chan AuditEvent, auditQueueSize),
+		done:  make(chan struct{}),
+		log:   logger.NewModule(":audit"),
+		stats: stats,
+	}
+	go a.loop()
+	return a
+}
+
+/*
+record - Stamp an event with the current time and queue it for the sink.
+*/
+func (a *Auditor) record(event AuditEvent) {
+	if a == nil {
+		return
+	}
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if a.closed {
+		return
+	}
+	select {
+	case a.queue <- event:
+	default:
+		a.stats.Incr("audit.write.dropped", 1)
+		a.log.Errorf("Dropped audit event %v, too many events are queued\n", event.Event)
+	}
+}
+
+/*
+loop - Write queued events to the sink until the auditor is closed.
+*/
+func (a *Auditor) loop() {
+	defer close(a.done)
+	for event := range a.queue {
+		if err := a.sink.Write(event); err != nil {
+			a.stats.Incr("audit.write.error", 1)
+			a.log.Errorf("Failed to write audit event: %v\n", err)
+			continue
+		}
+		a.stats.Incr("audit.write.success", 1)
+	}
+}
+
+/*
+close - Write any queued events and close the sink of the auditor.
+*/
+func (a *Auditor) close() {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return
+	}
+	a.closed = true
+	close(a.queue)
+	a.mutex.Unlock()
+
+	<-a.done
+	if err := a.sink.Close(); err != nil {
+		a.log.Errorf("Failed to close audit sink: %v\n", err)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+type memAuditSink struct {
+	mutex  sync.Mutex
+	events []AuditEvent
+}
+
+func (m *memAuditSink) Write(event AuditEvent) error {
+	m.mutex.Lock()
+	m.events = append(m.events, event)
+	m.mutex.Unlock()
+	return nil
+}
+
+func (m *memAuditSink) Close() error {
+	return nil
+}
+
+func (m *memAuditSink) await(t *testing.T, exp AuditEvent) {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		m.mutex.Lock()
+		for _, event := range m.events {
+			if len(event.Time) > 0 {
+				event.Time = ""
+			}
+			if event == exp {
+				m.mutex.Unlock()
+				return
+			}
+		}
+		m.mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Errorf("Audit event was not recorded: %+v", exp)
+}
+
+func TestAuditFileSink(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	config := NewAuditConfig()
+	config.Type = "file"
+	config.FilePath = path
+
+	sink, err := AuditSinkFactory(config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditor := NewAuditor(sink, log, stats)
+	auditor.record(AuditEvent{Event: AuditClientJoined, DocumentID: "foo", UserID: "bar"})
+	auditor.record(AuditEvent{Event: AuditDocumentFlushed, DocumentID: "foo", Version: 3})
+	auditor.close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	events := []AuditEvent{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err = json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatal(err)
+		}
+		if len(event.Time) == 0 {
+			t.Error("Audit event was not stamped")
+		}
+		events = append(events, event)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Wrong number of audit events: %v", len(events))
+	}
+	if events[0].Event != AuditClientJoined || events[0].UserID != "bar" {
+		t.Errorf("Wrong audit event: %+v", events[0])
+	}
+	if events[1].Event != AuditDocumentFlushed || events[1].Version != 3 {
+		t.Errorf("Wrong audit event: %+v", events[1])
+	}
+
+	config.Type = "carrier_pigeon"
+	if _, err = AuditSinkFactory(config, log, stats); err != ErrInvalidAuditType {
+		t.Errorf("Wrong error for invalid sink type: %v", err)
+	}
+	config.Type = "file"
+	config.FilePath = ""
+	if _, err = AuditSinkFactory(config, log, stats); err != ErrAuditPathMissing {
+		t.Errorf("Wrong error for missing path: %v", err)
+	}
+}
+
+func TestCuratorAudit(t *testing.T) {
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.FlushPeriod = 10
+
+	levels := levelAuth{"writer": auth.AccessWrite, "reader": auth.AccessRead}
+	curator, err := NewCurator(config, log, stats, levels, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	sink := &memAuditSink{}
+	curator.UseAuditSink(sink)
+
+	if _, err = curator.EditDocument("reader", doc.ID); err != ErrUnauthorised {
+		t.Errorf("Wrong error for editing with read access: %v", err)
+	}
+	sink.await(t, AuditEvent{Event: AuditAuthDenied, DocumentID: doc.ID, TokenHash: auditTokenHash("reader"), Action: "edit"})
+
+	portal, err := curator.EditDocument("writer", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.await(t, AuditEvent{Event: AuditClientJoined, DocumentID: doc.ID, TokenHash: auditTokenHash("writer")})
+
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "well, ", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sink.await(t, AuditEvent{Event: AuditDocumentFlushed, DocumentID: doc.ID, Version: 2})
+
+	viewer, err := curator.ReadDocument("reader", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.await(t, AuditEvent{Event: AuditClientJoined, DocumentID: doc.ID, TokenHash: auditTokenHash("reader"), ReadOnly: true})
+	viewer.Exit(time.Second)
+	sink.await(t, AuditEvent{Event: AuditClientLeft, DocumentID: doc.ID, TokenHash: auditTokenHash("reader")})
+
+	if err = curator.KickUser(doc.ID, "writer", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sink.await(t, AuditEvent{Event: AuditClientKicked, DocumentID: doc.ID, TokenHash: auditTokenHash("writer"), Reason: "admin"})
+
+	if _, err = curator.EditDocument("writer", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err = curator.BanUser(doc.ID, "writer", time.Minute, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	sink.await(t, AuditEvent{Event: AuditClientKicked, DocumentID: doc.ID, TokenHash: auditTokenHash("writer"), Reason: "banned"})
+
+	if _, err = curator.EditDocument("writer", doc.ID); err != ErrClientBanned {
+		t.Errorf("Wrong error for banned client: %v", err)
+	}
+	sink.await(t, AuditEvent{
+		Event:      AuditAuthDenied,
+		DocumentID: doc.ID,
+		TokenHash:  auditTokenHash("writer"),
+		Action:     "join",
+		Reason:     "banned",
+	})
+}
@@ -34,11 +34,16 @@ import (
 Config - Holds generic configuration options for a token based authentication solution.
 */
 type Config struct {
-	Type        string      `json:"type" yaml:"type"`
-	AllowCreate bool        `json:"allow_creation" yaml:"allow_creation"`
-	RedisConfig RedisConfig `json:"redis_config" yaml:"redis_config"`
-	FileConfig  FileConfig  `json:"file_config" yaml:"file_config"`
-	HTTPConfig  HTTPConfig  `json:"http_config" yaml:"http_config"`
+	Type        string            `json:"type" yaml:"type"`
+	AllowCreate bool              `json:"allow_creation" yaml:"allow_creation"`
+	RedisConfig RedisConfig       `json:"redis_config" yaml:"redis_config"`
+	FileConfig  FileConfig        `json:"file_config" yaml:"file_config"`
+	HTTPConfig  HTTPConfig        `json:"http_config" yaml:"http_config"`
+	JWTConfig   JWTConfig         `json:"jwt_config" yaml:"jwt_config"`
+	LDAPConfig  LDAPConfig        `json:"ldap_config" yaml:"ldap_config"`
+	MTLSConfig  MTLSConfig        `json:"mtls_config" yaml:"mtls_config"`
+	IssueConfig IssueConfig       `json:"issue" yaml:"issue"`
+	TokenPolicy TokenPolicyConfig `json:"token_policy" yaml:"token_policy"`
 }
 
 /*
@@ -51,6 +56,11 @@ func NewConfig() Config {
 		RedisConfig: NewRedisConfig(),
 		FileConfig:  NewFileConfig(),
 		HTTPConfig:  NewHTTPConfig(),
+		JWTConfig:   NewJWTConfig(),
+		LDAPConfig:  NewLDAPConfig(),
+		MTLSConfig:  NewMTLSConfig(),
+		IssueConfig: NewIssueConfig(),
+		TokenPolicy: NewTokenPolicyConfig(),
 	}
 }
 
@@ -72,6 +82,12 @@ func Factory(
 		return NewRedis(config, logger), nil
 	case "http":
 		return NewHTTP(config, logger, stats), nil
+	case "jwt":
+		return NewJWT(config, logger)
+	case "ldap":
+		return NewLDAP(config, logger, stats)
+	case "mtls":
+		return NewMTLS(config, logger, stats)
 	}
 	return nil, ErrInvalidAuthType
 }
@@ -97,17 +113,11 @@ func (a *Anarchy) AuthoriseCreate(_, _ string) bool {
 }
 
 /*
-AuthoriseJoin - Always returns true, because anarchy.
+Authorise - Always grants write access, because anarchy. Administration is still left to the admin
+API.
 */
-func (a *Anarchy) AuthoriseJoin(_, _ string) bool {
-	return true
-}
-
-/*
-AuthoriseReadOnly - Always returns true, because anarchy.
-*/
-func (a *Anarchy) AuthoriseReadOnly(_, _ string) bool {
-	return true
+func (a *Anarchy) Authorise(_, _ string) AccessLevel {
+	return AccessWrite
 }
 
 /*
@@ -182,27 +182,20 @@ func (f *File) AuthoriseCreate(token, userID string) bool {
 }
 
 /*
-AuthoriseJoin - Checks whether the documentID file exists, returns true if it does, otherwise false.
+Authorise - Checks whether the documentID file exists, granting write access if it does. This
+authenticator only validates that the file exists and so never grants admin access.
 */
-func (f *File) AuthoriseJoin(token, documentID string) bool {
+func (f *File) Authorise(token, documentID string) AccessLevel {
 	f.mutex.RLock()
 	defer f.mutex.RUnlock()
 
 	cleanPath := path.Clean(documentID)
 	for _, p := range f.paths {
 		if cleanPath == p {
-			return true
+			return AccessWrite
 		}
 	}
-	return false
-}
-
-/*
-AuthoriseReadOnly - Performs the same action as AuthoriseJoin since this authenticator only
-validates that the file exists.
-*/
-func (f *File) AuthoriseReadOnly(token, documentID string) bool {
-	return f.AuthoriseJoin(token, documentID)
+	return AccessNone
 }
 
 /*
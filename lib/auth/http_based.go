@@ -142,11 +142,13 @@ type HTTP struct {
 	tokensCreate   tokensMap
 	tokensJoin     tokensMap
 	tokensReadOnly tokensMap
+	tokensAdmin    tokensMap
 
 	// HTTP handlers for various actions
 	createHandler   http.HandlerFunc
 	joinHandler     http.HandlerFunc
 	readOnlyHandler http.HandlerFunc
+	adminHandler    http.HandlerFunc
 }
 
 /*
@@ -162,11 +164,13 @@ func NewHTTP(config Config, logger *log.Logger, stats *log.Stats) *HTTP {
 		tokensCreate:   tokensMap{},
 		tokensJoin:     tokensMap{},
 		tokensReadOnly: tokensMap{},
+		tokensAdmin:    tokensMap{},
 	}
 
 	authorizer.createHandler = authorizer.createGenerateTokenHandler(authorizer.tokensCreate)
 	authorizer.joinHandler = authorizer.createGenerateTokenHandler(authorizer.tokensJoin)
 	authorizer.readOnlyHandler = authorizer.createGenerateTokenHandler(authorizer.tokensReadOnly)
+	authorizer.adminHandler = authorizer.createGenerateTokenHandler(authorizer.tokensAdmin)
 
 	return &authorizer
 }
@@ -209,56 +213,93 @@ func (h *HTTP) AuthoriseCreate(token, userID string) bool {
 		return false
 	}
 
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 
-	if tObj, ok := h.tokensCreate[token]; ok {
-		if tObj.value == userID {
-			delete(h.tokensCreate, token)
-			return true
-		}
+	if tObj, ok := h.tokensCreate[token]; ok && tObj.value == userID && tObj.expires.After(time.Now()) {
+		h.consumeToken(h.tokensCreate, token)
+		return true
 	}
 	return false
 }
 
 /*
-AuthoriseJoin - Checks whether a specific token has been generated for a document through the HTTP
-authentication endpoint for joining that aforementioned document.
+Authorise - Checks whether a specific token has been generated for a document through one of the
+HTTP authentication endpoints, the endpoint used determines the level of access granted.
 */
-func (h *HTTP) AuthoriseJoin(token, documentID string) bool {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-
-	if tObj, ok := h.tokensJoin[token]; ok {
-		if tObj.value == documentID {
-			delete(h.tokensJoin, token)
-			return true
+func (h *HTTP) Authorise(token, documentID string) AccessLevel {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, grant := range []struct {
+		tokens tokensMap
+		level  AccessLevel
+	}{
+		{h.tokensAdmin, AccessAdmin},
+		{h.tokensJoin, AccessWrite},
+		{h.tokensReadOnly, AccessRead},
+	} {
+		if tObj, ok := grant.tokens[token]; ok && tObj.value == documentID && tObj.expires.After(time.Now()) {
+			h.consumeToken(grant.tokens, token)
+			return grant.level
 		}
 	}
-	return false
+	return AccessNone
 }
 
 /*
-AuthoriseReadOnly - Checks whether a specific token has been generated for a document through the HTTP
-authentication endpoint for joining that aforementioned document in read only mode.
+consumeToken - Apply the token policy to a token that has just been used, which either deletes it or
+ensures that it expires within the reuse period. Must be called whilst locked.
 */
-func (h *HTTP) AuthoriseReadOnly(token, documentID string) bool {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-
-	if tObj, ok := h.tokensReadOnly[token]; ok {
-		if tObj.value == documentID {
-			delete(h.tokensReadOnly, token)
-			return true
+func (h *HTTP) consumeToken(tokens tokensMap, token string) {
+	if h.config.TokenPolicy.SingleUse {
+		delete(tokens, token)
+		return
+	}
+	if reuse := h.config.TokenPolicy.reuseExpiry(); reuse > 0 {
+		tObj := tokens[token]
+		if limit := time.Now().Add(reuse); tObj.expires.After(limit) {
+			tObj.expires = limit
+			tokens[token] = tObj
 		}
 	}
-	return false
+}
+
+/*
+IssueToken - Store a new token for an action that expires after ttl, as the endpoint of the action
+would.
+*/
+func (h *HTTP) IssueToken(action, key string, ttl time.Duration) (string, error) {
+	var tokens tokensMap
+	switch action {
+	case TokenActionCreate:
+		tokens = h.tokensCreate
+	case TokenActionJoin:
+		tokens = h.tokensJoin
+	case TokenActionRead:
+		tokens = h.tokensReadOnly
+	case TokenActionAdmin:
+		tokens = h.tokensAdmin
+	default:
+		return "", ErrInvalidTokenAction
+	}
+
+	token := util.GenerateStampedUUID()
+	h.mutex.Lock()
+	tokens[token] = tokenMapValue{value: key, expires: time.Now().Add(ttl)}
+	h.mutex.Unlock()
+
+	h.clearExpiredTokens(tokens)
+	return token, nil
 }
 
 /*
 RegisterHandlers - Register endpoints for adding new auth tokens.
 */
 func (h *HTTP) RegisterHandlers(register register.PubPrivEndpointRegister) error {
+	if err := registerIssueHandler(register, h.config.IssueConfig, h, h.logger); err != nil {
+		return err
+	}
 	if err := register.RegisterPrivate(
 		path.Join(h.config.HTTPConfig.Path, "create"),
 		`Generate an authentication token for creating a new document, POST: {"key_value":"<user_id>"}`,
@@ -273,6 +314,13 @@ func (h *HTTP) RegisterHandlers(register register.PubPrivEndpointRegister) error
 	); err != nil {
 		return err
 	}
+	if err := register.RegisterPrivate(
+		path.Join(h.config.HTTPConfig.Path, "admin"),
+		`Generate an authentication token for joining an existing document as an administrator, POST: {"key_value":"<document_id>"}`,
+		h.adminHandler,
+	); err != nil {
+		return err
+	}
 	return register.RegisterPrivate(
 		path.Join(h.config.HTTPConfig.Path, "join"),
 		`Generate an authentication token for joining an existing document, POST: {"key_value":"<document_id>"}`,
@@ -67,6 +67,8 @@ func (d *dummyRegister) RegisterPrivate(endpoint, description string, handler ht
 		d.joinHandler = handler
 	} else if endpoint == "/test/read" {
 		d.joinHandler = handler
+	} else if endpoint == "/test/admin" {
+		d.joinHandler = handler
 	} else {
 		err := fmt.Errorf("unrecognised endpoint: %v", endpoint)
 		d.errors = append(d.errors, err)
@@ -165,7 +167,7 @@ func TestTokens(t *testing.T) {
 	}
 
 	for i, key := range testKeys {
-		if !httpAuth.AuthoriseJoin(testTokens[i], key) {
+		if level := httpAuth.Authorise(testTokens[i], key); level != AccessWrite {
 			t.Errorf("Failed to authorise: %v, %v", testTokens[i], key)
 		}
 	}
@@ -252,9 +254,9 @@ func TestBadKeys(t *testing.T) {
 	for token, key := range httpAuth.tokensJoin {
 		randomKey := util.GenerateStampedUUID()
 
-		if httpAuth.AuthoriseJoin(token, randomKey) {
+		if httpAuth.Authorise(token, randomKey) != AccessNone {
 			if key.value != randomKey {
-				t.Errorf("Authorised join random key: %v %v", token, randomKey)
+				t.Errorf("Authorised random key: %v %v", token, randomKey)
 			}
 		}
 		if httpAuth.AuthoriseCreate(token, randomKey) {
@@ -262,11 +264,6 @@ func TestBadKeys(t *testing.T) {
 				t.Errorf("Authorised create random key: %v %v", token, randomKey)
 			}
 		}
-		if httpAuth.AuthoriseReadOnly(token, randomKey) {
-			if key.value != randomKey {
-				t.Errorf("Authorised create random key: %v %v", token, randomKey)
-			}
-		}
 	}
 
 	// Check random tokens and values
@@ -274,14 +271,11 @@ func TestBadKeys(t *testing.T) {
 		randomToken := util.GenerateStampedUUID()
 		randomKey := util.GenerateStampedUUID()
 
-		if httpAuth.AuthoriseJoin(randomToken, randomKey) {
-			t.Errorf("Authorised join random key/token: %v %v", randomToken, randomKey)
+		if httpAuth.Authorise(randomToken, randomKey) != AccessNone {
+			t.Errorf("Authorised random key/token: %v %v", randomToken, randomKey)
 		}
 		if httpAuth.AuthoriseCreate(randomToken, randomKey) {
 			t.Errorf("Authorised create random key/token: %v %v", randomToken, randomKey)
 		}
-		if httpAuth.AuthoriseReadOnly(randomToken, randomKey) {
-			t.Errorf("Authorised create random key/token: %v %v", randomToken, randomKey)
-		}
 	}
 }
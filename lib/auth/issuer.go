@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jeffail/leaps/lib/register"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+IssueConfig - Options for the token issuance endpoint, which is registered as a private endpoint at
+Path by authenticators able to issue tokens, an empty Path disables it. Tokens live for DefaultTTL
+seconds unless a request asks otherwise, and never longer than MaxTTL seconds.
+*/
+type IssueConfig struct {
+	Path       string `json:"path" yaml:"path"`
+	DefaultTTL int64  `json:"default_ttl_s" yaml:"default_ttl_s"`
+	MaxTTL     int64  `json:"max_ttl_s" yaml:"max_ttl_s"`
+}
+
+/*
+NewIssueConfig - Returns a default config object for the token issuance endpoint, which is disabled.
+*/
+func NewIssueConfig() IssueConfig {
+	return IssueConfig{
+		Path:       "",
+		DefaultTTL: 60,
+		MaxTTL:     86400,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for issuing tokens.
+var (
+	ErrInvalidTokenAction = errors.New("token action must be one of create, join, read or admin")
+	ErrInvalidTokenTTL    = errors.New("token ttl exceeds the maximum")
+	ErrIssueNotSupported  = errors.New("authenticator configuration cannot issue tokens")
+)
+
+/*
+Actions that tokens may be issued for. The key of a create token is a user ID, and the key of the
+remaining actions is a document ID.
+*/
+const (
+	TokenActionCreate = "create"
+	TokenActionJoin   = "join"
+	TokenActionRead   = "read"
+	TokenActionAdmin  = "admin"
+)
+
+/*
+TokenIssuer - Implemented by authenticators able to issue their own tokens, which are then accepted
+by the authenticator until they expire.
+*/
+type TokenIssuer interface {
+	// IssueToken - Create a token for an action on a key that expires after ttl.
+	IssueToken(action, key string, ttl time.Duration) (string, error)
+}
+
+/*
+validTokenAction - Returns whether an action is one that tokens may be issued for.
+*/
+func validTokenAction(action string) bool {
+	switch action {
+	case TokenActionCreate, TokenActionJoin, TokenActionRead, TokenActionAdmin:
+		return true
+	}
+	return false
+}
+
+/*
+registerIssueHandler - Register the token issuance endpoint of an issuer as a private endpoint,
+provided it is enabled. Private endpoints are served by the admin API, which guards them with its
+own authentication.
+*/
+func registerIssueHandler(
+	reg register.PubPrivEndpointRegister, config IssueConfig, issuer TokenIssuer, logger *log.Logger,
+) error {
+	if len(config.Path) == 0 {
+		return nil
+	}
+	return reg.RegisterPrivate(
+		config.Path,
+		`Issue an authentication token with a ttl in seconds, POST: {"action":"<create|join|read|admin>","key_value":"<user_or_document_id>","ttl_s":60}`,
+		issueHandler(config, issuer, logger),
+	)
+}
+
+/*
+issueHandler - Returns a handler that issues tokens and responds with them as JSON.
+*/
+func issueHandler(config IssueConfig, issuer TokenIssuer, logger *log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "POST endpoint only", http.StatusMethodNotAllowed)
+			return
+		}
+
+		bytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			logger.Errorf("Failed to read request body: %v\n", err)
+			http.Error(w, "Bad request: could not read body", http.StatusBadRequest)
+			return
+		}
+
+		var bodyObj struct {
+			Action string `json:"action"`
+			Key    string `json:"key_value"`
+			TTL    int64  `json:"ttl_s"`
+		}
+		if err = json.Unmarshal(bytes, &bodyObj); err != nil {
+			logger.Errorf("Failed to parse request body: %v\n", err)
+			http.Error(w, "Bad request: could not parse body", http.StatusBadRequest)
+			return
+		}
+		if !validTokenAction(bodyObj.Action) {
+			http.Error(w, ErrInvalidTokenAction.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(bodyObj.Key) == 0 {
+			http.Error(w, "Bad request: no key_value found", http.StatusBadRequest)
+			return
+		}
+		if bodyObj.TTL <= 0 {
+			bodyObj.TTL = config.DefaultTTL
+		}
+		if config.MaxTTL > 0 && bodyObj.TTL > config.MaxTTL {
+			http.Error(w, ErrInvalidTokenTTL.Error(), http.StatusBadRequest)
+			return
+		}
+
+		ttl := time.Duration(bodyObj.TTL) * time.Second
+		token, err := issuer.IssueToken(bodyObj.Action, bodyObj.Key, ttl)
+		if err != nil {
+			logger.Errorf("Failed to issue %v token: %v\n", bodyObj.Action, err)
+			http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+			return
+		}
+
+		resBytes, err := json.Marshal(struct {
+			Token     string `json:"token"`
+			ExpiresAt int64  `json:"expires_at"`
+		}{
+			Token:     token,
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+		})
+		if err != nil {
+			logger.Errorf("Failed to generate JSON response: %v\n", err)
+			http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resBytes)
+	}
+}
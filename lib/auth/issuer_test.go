@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func issueRequest(t *testing.T, handler http.HandlerFunc, body string) (int, string) {
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest("POST", "/issue", bytes.NewBufferString(body)))
+	if rec.Code != http.StatusOK {
+		return rec.Code, ""
+	}
+	var res struct {
+		Token     string `json:"token"`
+		ExpiresAt int64  `json:"expires_at"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.ExpiresAt <= time.Now().Unix() {
+		t.Errorf("Token already expired: %v", res.ExpiresAt)
+	}
+	return rec.Code, res.Token
+}
+
+func TestIssueHTTPTokens(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewConfig()
+	config.IssueConfig.MaxTTL = 600
+	httpAuth := NewHTTP(config, logger, stats)
+	handler := issueHandler(config.IssueConfig, httpAuth, logger)
+
+	for action, level := range map[string]AccessLevel{
+		"join":  AccessWrite,
+		"read":  AccessRead,
+		"admin": AccessAdmin,
+	} {
+		code, token := issueRequest(t, handler, `{"action":"`+action+`","key_value":"doc1"}`)
+		if code != http.StatusOK {
+			t.Fatalf("Failed to issue %v token: %v", action, code)
+		}
+		if act := httpAuth.Authorise(token, "doc1"); act != level {
+			t.Errorf("Wrong access for %v token: %v != %v", action, act, level)
+		}
+	}
+
+	_, token := issueRequest(t, handler, `{"action":"create","key_value":"user1","ttl_s":30}`)
+	if !httpAuth.AuthoriseCreate(token, "user1") {
+		t.Error("Issued create token was rejected")
+	}
+
+	for _, body := range []string{
+		`{"action":"destroy","key_value":"doc1"}`,
+		`{"action":"join"}`,
+		`{"action":"join","key_value":"doc1","ttl_s":601}`,
+		`not json`,
+	} {
+		if code, _ := issueRequest(t, handler, body); code != http.StatusBadRequest {
+			t.Errorf("Wrong status for %v: %v", body, code)
+		}
+	}
+}
+
+func TestIssueJWTTokens(t *testing.T) {
+	logger, _ := loggerAndStats()
+
+	config := NewConfig()
+	config.JWTConfig.Secret = "super secret"
+	config.JWTConfig.Issuer = "leaps-test"
+	jwt, err := NewJWT(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := jwt.IssueToken(TokenActionRead, "doc1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := jwt.Authorise(token, "doc1"); level != AccessRead {
+		t.Errorf("Wrong access for issued token: %v", level)
+	}
+	if level := jwt.Authorise(token, "doc2"); level != AccessNone {
+		t.Errorf("Issued token granted %v to wrong document", level)
+	}
+
+	expired, err := jwt.IssueToken(TokenActionJoin, "doc1", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := jwt.Authorise(expired, "doc1"); level != AccessNone {
+		t.Errorf("Expired token granted %v", level)
+	}
+}
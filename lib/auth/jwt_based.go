@@ -0,0 +1,342 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/jeffail/leaps/lib/register"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+JWTConfig - A config object for the JWT authentication object. Algorithm is either HS256, which
+verifies tokens with Secret, or RS256, which verifies tokens with the PEM encoded public key at
+PublicKeyPath. When Issuer is set tokens must carry a matching iss claim. Leeway is the number of
+seconds of clock skew tolerated when checking the exp and nbf claims.
+*/
+type JWTConfig struct {
+	Algorithm     string `json:"algorithm" yaml:"algorithm"`
+	Secret        string `json:"secret" yaml:"secret"`
+	PublicKeyPath string `json:"public_key_path" yaml:"public_key_path"`
+	Issuer        string `json:"issuer" yaml:"issuer"`
+	Leeway        int64  `json:"leeway_s" yaml:"leeway_s"`
+}
+
+/*
+NewJWTConfig - Returns a default config object for a JWT.
+*/
+func NewJWTConfig() JWTConfig {
+	return JWTConfig{
+		Algorithm:     "HS256",
+		Secret:        "",
+		PublicKeyPath: "",
+		Issuer:        "",
+		Leeway:        5,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the JWT type.
+var (
+	ErrJWTMalformed      = errors.New("token is not a well formed JWT")
+	ErrJWTAlgorithm      = errors.New("token algorithm does not match the configured algorithm")
+	ErrJWTSignature      = errors.New("token signature is invalid")
+	ErrJWTExpired        = errors.New("token has expired or is not yet valid")
+	ErrJWTIssuer         = errors.New("token issuer does not match")
+	ErrJWTNoKey          = errors.New("no key configured for the JWT algorithm")
+	ErrJWTInvalidRSAKey  = errors.New("public key file did not contain an RSA public key")
+	ErrJWTUnsupportedAlg = errors.New("unsupported JWT algorithm, must be HS256 or RS256")
+)
+
+/*
+JWTClaims - The claims expected of a leaps JWT. The subject is the user ID, Document is the ID of
+the document the token grants access to and Permissions lists any of 'create', 'edit' and 'read'.
+The edit permission implies read.
+*/
+type JWTClaims struct {
+	Subject     string   `json:"sub"`
+	Document    string   `json:"doc"`
+	Permissions []string `json:"perms"`
+	Issuer      string   `json:"iss,omitempty"`
+	ExpiresAt   int64    `json:"exp,omitempty"`
+	NotBefore   int64    `json:"nbf,omitempty"`
+}
+
+/*
+hasPermission - Check whether the claims grant a permission.
+*/
+func (c JWTClaims) hasPermission(perm string) bool {
+	for _, p := range c.Permissions {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+JWT - An authenticator that validates stateless, signed JSON Web Tokens. Tokens are minted by a
+separate service sharing either the HMAC secret or the RSA key pair, which removes the need for a
+shared token store.
+*/
+type JWT struct {
+	logger    *log.Logger
+	config    Config
+	publicKey *rsa.PublicKey
+}
+
+/*
+NewJWT - Creates a JWT authenticator using the provided configuration.
+*/
+func NewJWT(config Config, logger *log.Logger) (*JWT, error) {
+	j := JWT{
+		logger: logger.NewModule(":jwt_auth"),
+		config: config,
+	}
+
+	switch config.JWTConfig.Algorithm {
+	case "HS256":
+		if len(config.JWTConfig.Secret) == 0 {
+			return nil, ErrJWTNoKey
+		}
+	case "RS256":
+		if len(config.JWTConfig.PublicKeyPath) == 0 {
+			return nil, ErrJWTNoKey
+		}
+		pemBytes, err := ioutil.ReadFile(config.JWTConfig.PublicKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(pemBytes)
+		if block == nil {
+			return nil, ErrJWTInvalidRSAKey
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if j.publicKey, ok = parsed.(*rsa.PublicKey); !ok {
+			return nil, ErrJWTInvalidRSAKey
+		}
+	default:
+		return nil, ErrJWTUnsupportedAlg
+	}
+	return &j, nil
+}
+
+/*
+Validate - Verify the signature and time bounds of a token and return its claims.
+*/
+func (j *JWT) Validate(token string) (JWTClaims, error) {
+	var claims JWTClaims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, ErrJWTMalformed
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return claims, ErrJWTMalformed
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return claims, ErrJWTMalformed
+	}
+	// Never let the token choose how it is verified.
+	if header.Algorithm != j.config.JWTConfig.Algorithm {
+		return claims, ErrJWTAlgorithm
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, ErrJWTMalformed
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Algorithm {
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte(j.config.JWTConfig.Secret))
+		mac.Write(signed)
+		if subtle.ConstantTimeCompare(mac.Sum(nil), signature) != 1 {
+			return claims, ErrJWTSignature
+		}
+	case "RS256":
+		digest := sha256.Sum256(signed)
+		if err = rsa.VerifyPKCS1v15(j.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return claims, ErrJWTSignature
+		}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return claims, ErrJWTMalformed
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrJWTMalformed
+	}
+
+	now, leeway := time.Now().Unix(), j.config.JWTConfig.Leeway
+	if claims.ExpiresAt != 0 && now > claims.ExpiresAt+leeway {
+		return claims, ErrJWTExpired
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore-leeway {
+		return claims, ErrJWTExpired
+	}
+	if len(j.config.JWTConfig.Issuer) > 0 && claims.Issuer != j.config.JWTConfig.Issuer {
+		return claims, ErrJWTIssuer
+	}
+	return claims, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AuthoriseCreate - Checks that the token grants the create permission to the user.
+*/
+func (j *JWT) AuthoriseCreate(token, userID string) bool {
+	if !j.config.AllowCreate {
+		return false
+	}
+	claims, err := j.Validate(token)
+	if err != nil {
+		j.logger.Warnf("Rejected create token: %v\n", err)
+		return false
+	}
+	return claims.hasPermission("create") && claims.Subject == userID
+}
+
+/*
+Authorise - Checks that the token is for the document and returns the highest level of access
+granted by its admin, edit and read permissions.
+*/
+func (j *JWT) Authorise(token, documentID string) AccessLevel {
+	claims, err := j.Validate(token)
+	if err != nil {
+		j.logger.Warnf("Rejected token: %v\n", err)
+		return AccessNone
+	}
+	if claims.Document != documentID {
+		return AccessNone
+	}
+	switch {
+	case claims.hasPermission("admin"):
+		return AccessAdmin
+	case claims.hasPermission("edit"):
+		return AccessWrite
+	case claims.hasPermission("read"):
+		return AccessRead
+	}
+	return AccessNone
+}
+
+/*
+IdentifyUser - Returns the subject of a valid token.
+*/
+func (j *JWT) IdentifyUser(token string) (string, bool) {
+	claims, err := j.Validate(token)
+	if err != nil || len(claims.Subject) == 0 {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+/*
+IssueToken - Mint a token for an action that expires after ttl. Only tokens of the HS256 algorithm
+can be minted, as RS256 tokens require a private key that leaps does not hold.
+*/
+func (j *JWT) IssueToken(action, key string, ttl time.Duration) (string, error) {
+	if j.config.JWTConfig.Algorithm != "HS256" {
+		return "", ErrIssueNotSupported
+	}
+	claims := JWTClaims{
+		Issuer:    j.config.JWTConfig.Issuer,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+	switch action {
+	case TokenActionCreate:
+		claims.Subject, claims.Permissions = key, []string{"create"}
+	case TokenActionJoin:
+		claims.Document, claims.Permissions = key, []string{"edit"}
+	case TokenActionRead:
+		claims.Document, claims.Permissions = key, []string{"read"}
+	case TokenActionAdmin:
+		claims.Document, claims.Permissions = key, []string{"admin"}
+	default:
+		return "", ErrInvalidTokenAction
+	}
+
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(j.config.JWTConfig.Secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+/*
+RegisterHandlers - Register the token issuance endpoint, if enabled. Tokens are otherwise minted
+elsewhere.
+*/
+func (j *JWT) RegisterHandlers(register register.PubPrivEndpointRegister) error {
+	if j.config.JWTConfig.Algorithm != "HS256" {
+		return nil
+	}
+	return registerIssueHandler(register, j.config.IssueConfig, j, j.logger)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
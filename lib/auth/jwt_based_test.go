@@ -0,0 +1,216 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func mintJWT(t *testing.T, alg string, claims JWTClaims, sign func([]byte) []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func TestJWTHS256(t *testing.T) {
+	logger, _ := loggerAndStats()
+
+	config := NewConfig()
+	config.AllowCreate = true
+	config.JWTConfig.Secret = "super secret"
+	config.JWTConfig.Issuer = "leaps-test"
+
+	jwt, err := NewJWT(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := func(secret string) func([]byte) []byte {
+		return func(b []byte) []byte {
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(b)
+			return mac.Sum(nil)
+		}
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	editToken := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"edit"},
+		Issuer: "leaps-test", ExpiresAt: exp,
+	}, hs("super secret"))
+	if level := jwt.Authorise(editToken, "doc1"); level != AccessWrite {
+		t.Errorf("Wrong access for edit token: %v", level)
+	}
+	if level := jwt.Authorise(editToken, "doc2"); level != AccessNone {
+		t.Errorf("Edit token granted %v for wrong document", level)
+	}
+	if jwt.AuthoriseCreate(editToken, "user1") {
+		t.Error("Edit token accepted for create")
+	}
+
+	readToken := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"read"},
+		Issuer: "leaps-test", ExpiresAt: exp,
+	}, hs("super secret"))
+	if level := jwt.Authorise(readToken, "doc1"); level != AccessRead {
+		t.Errorf("Wrong access for read token: %v", level)
+	}
+
+	adminToken := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"read", "admin"},
+		Issuer: "leaps-test", ExpiresAt: exp,
+	}, hs("super secret"))
+	if level := jwt.Authorise(adminToken, "doc1"); level != AccessAdmin {
+		t.Errorf("Wrong access for admin token: %v", level)
+	}
+
+	createToken := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Permissions: []string{"create"}, Issuer: "leaps-test", ExpiresAt: exp,
+	}, hs("super secret"))
+	if !jwt.AuthoriseCreate(createToken, "user1") {
+		t.Error("Create token rejected")
+	}
+	if jwt.AuthoriseCreate(createToken, "user2") {
+		t.Error("Create token accepted for wrong user")
+	}
+
+	badSecret := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"edit"},
+		Issuer: "leaps-test", ExpiresAt: exp,
+	}, hs("wrong secret"))
+	if _, err = jwt.Validate(badSecret); err != ErrJWTSignature {
+		t.Errorf("Wrong error for bad signature: %v", err)
+	}
+
+	expired := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"edit"},
+		Issuer: "leaps-test", ExpiresAt: time.Now().Add(-time.Hour).Unix(),
+	}, hs("super secret"))
+	if _, err = jwt.Validate(expired); err != ErrJWTExpired {
+		t.Errorf("Wrong error for expired token: %v", err)
+	}
+
+	wrongIssuer := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"edit"},
+		Issuer: "someone-else", ExpiresAt: exp,
+	}, hs("super secret"))
+	if _, err = jwt.Validate(wrongIssuer); err != ErrJWTIssuer {
+		t.Errorf("Wrong error for wrong issuer: %v", err)
+	}
+
+	noneAlg := mintJWT(t, "none", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"edit"}, Issuer: "leaps-test",
+	}, func([]byte) []byte { return nil })
+	if _, err = jwt.Validate(noneAlg); err != ErrJWTAlgorithm {
+		t.Errorf("Wrong error for none algorithm: %v", err)
+	}
+
+	if _, err = jwt.Validate("not.a-token"); err != ErrJWTMalformed {
+		t.Errorf("Wrong error for malformed token: %v", err)
+	}
+}
+
+func TestJWTRS256(t *testing.T) {
+	logger, _ := loggerAndStats()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "leaps_jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyPath := filepath.Join(dir, "public.pem")
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+	if err = ioutil.WriteFile(keyPath, pemBytes, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.JWTConfig.Algorithm = "RS256"
+	config.JWTConfig.PublicKeyPath = keyPath
+
+	jwt, err := NewJWT(config, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	token := mintJWT(t, "RS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"edit"},
+	}, rs)
+	if !jwt.Authorise(token, "doc1").Grants(AccessWrite) {
+		t.Error("RS256 token rejected")
+	}
+
+	// A token signed with the public key as an HMAC secret must not pass.
+	confused := mintJWT(t, "HS256", JWTClaims{
+		Subject: "user1", Document: "doc1", Permissions: []string{"edit"},
+	}, func(b []byte) []byte {
+		mac := hmac.New(sha256.New, pemBytes)
+		mac.Write(b)
+		return mac.Sum(nil)
+	})
+	if jwt.Authorise(confused, "doc1") != AccessNone {
+		t.Error("Algorithm confusion token accepted")
+	}
+
+	config.JWTConfig.Algorithm = "ES256"
+	if _, err = NewJWT(config, logger); err != ErrJWTUnsupportedAlg {
+		t.Errorf("Wrong error for unsupported algorithm: %v", err)
+	}
+}
@@ -0,0 +1,552 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/jeffail/leaps/lib/register"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+LDAPRule - Grants members of an LDAP group a level of access ('read', 'write' or 'admin') to the
+documents whose IDs match a glob pattern, an empty pattern matches every document. Group is matched
+case insensitively against either the full DN of a group or the value of its first RDN, so both
+"cn=editors,ou=groups,dc=example,dc=com" and "editors" are accepted.
+*/
+type LDAPRule struct {
+	Group     string `json:"group" yaml:"group"`
+	Documents string `json:"documents" yaml:"documents"`
+	Access    string `json:"access" yaml:"access"`
+}
+
+/*
+LDAPConfig - A config object for the LDAP authentication object. Users are located by searching
+BaseDN with UserFilter, where %s is replaced with the escaped username, whilst bound as BindDN. The
+password is then verified by binding as the user, and their groups are read from GroupAttribute.
+A successful login sets the SessionCookie cookie, which lasts SessionTTL seconds, and responds with
+a token for the client to join documents with. The cookie can later be exchanged for a new token,
+each exchange issuing a different one so that the cookie itself never leaves the browser. Tokens
+expire a minute after being issued and are bound to one document when requested with a document_id
+query parameter, and other clients know the client by its username rather than its token. Sessions
+and tokens are signed with SessionSecret, and so survive restarts and are accepted by every node
+sharing the secret, a random secret is used when it is empty. CookieSecure marks the cookie as only
+to be sent over HTTPS. CreateGroup, when set, restricts document creation to the members of that
+group.
+*/
+type LDAPConfig struct {
+	URL            string     `json:"url" yaml:"url"`
+	StartTLS       bool       `json:"start_tls" yaml:"start_tls"`
+	BindDN         string     `json:"bind_dn" yaml:"bind_dn"`
+	BindPassword   string     `json:"bind_password" yaml:"bind_password"`
+	BaseDN         string     `json:"base_dn" yaml:"base_dn"`
+	UserFilter     string     `json:"user_filter" yaml:"user_filter"`
+	GroupAttribute string     `json:"group_attribute" yaml:"group_attribute"`
+	TimeoutMS      int64      `json:"timeout_ms" yaml:"timeout_ms"`
+	Path           string     `json:"path" yaml:"path"`
+	SessionCookie  string     `json:"session_cookie" yaml:"session_cookie"`
+	SessionSecret  string     `json:"session_secret" yaml:"session_secret"`
+	SessionTTL     int64      `json:"session_ttl_s" yaml:"session_ttl_s"`
+	CookieSecure   bool       `json:"cookie_secure" yaml:"cookie_secure"`
+	CreateGroup    string     `json:"create_group" yaml:"create_group"`
+	Rules          []LDAPRule `json:"rules" yaml:"rules"`
+}
+
+/*
+NewLDAPConfig - Returns a default config object for an LDAP.
+*/
+func NewLDAPConfig() LDAPConfig {
+	return LDAPConfig{
+		URL:            "ldap://localhost:389",
+		StartTLS:       false,
+		BindDN:         "",
+		BindPassword:   "",
+		BaseDN:         "",
+		UserFilter:     "(uid=%s)",
+		GroupAttribute: "memberOf",
+		TimeoutMS:      5000,
+		Path:           "ldap",
+		SessionCookie:  "leaps_session",
+		SessionSecret:  "",
+		SessionTTL:     3600,
+		CookieSecure:   true,
+		CreateGroup:    "",
+		Rules:          []LDAPRule{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the LDAP type.
+var (
+	ErrLDAPNoURL         = errors.New("no LDAP server URL configured")
+	ErrLDAPInvalidAccess = errors.New("LDAP rule access must be one of 'read', 'write' or 'admin'")
+	ErrLDAPInvalidRule   = errors.New("LDAP rule has an invalid document pattern")
+	ErrLDAPBadLogin      = errors.New("invalid username or password")
+	ErrLDAPUserNotUnique = errors.New("LDAP search did not return exactly one user")
+)
+
+// ldapTokenTTL - The longest a client token may be held before it is used to join a document.
+const ldapTokenTTL = time.Minute
+
+/*
+ldapDirectory - Verifies user credentials against a directory, returning the groups of the user.
+*/
+type ldapDirectory interface {
+	Authenticate(username, password string) ([]string, error)
+}
+
+/*
+ldapServer - The ldapDirectory implementation that talks to a real LDAP server, a new connection is
+opened for each login.
+*/
+type ldapServer struct {
+	config LDAPConfig
+}
+
+/*
+Authenticate - Locate the user, verify their password by binding as them and return their groups.
+*/
+func (s ldapServer) Authenticate(username, password string) ([]string, error) {
+	// An empty password would be treated by most servers as an unauthenticated bind.
+	if len(username) == 0 || len(password) == 0 {
+		return nil, ErrLDAPBadLogin
+	}
+
+	conn, err := ldap.DialURL(s.config.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetTimeout(time.Duration(s.config.TimeoutMS) * time.Millisecond)
+
+	if s.config.StartTLS {
+		u, err := url.Parse(s.config.URL)
+		if err != nil {
+			return nil, err
+		}
+		if err = conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.config.BindDN) > 0 {
+		if err = conn.Bind(s.config.BindDN, s.config.BindPassword); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		s.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(s.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"dn", s.config.GroupAttribute}, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrLDAPUserNotUnique
+	}
+
+	entry := result.Entries[0]
+	if err = conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPBadLogin
+		}
+		return nil, err
+	}
+	return entry.GetAttributeValues(s.config.GroupAttribute), nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ldapSession - The signed contents of both the session cookie and the tokens issued for it. Kind
+prevents one from being presented in place of the other, Nonce makes each token unique and Document
+is the only document a token may be used with when set.
+*/
+type ldapSession struct {
+	Kind     string   `json:"k"`
+	User     string   `json:"u"`
+	Groups   []string `json:"g,omitempty"`
+	Document string   `json:"d,omitempty"`
+	Nonce    string   `json:"n,omitempty"`
+	Expires  int64    `json:"e"`
+}
+
+type ldapRule struct {
+	group     string
+	documents string
+	access    AccessLevel
+}
+
+/*
+LDAP - An authenticator that exchanges a username and password for a session token after verifying
+them against an LDAP directory. The access a session has to a document is determined by matching
+the groups of the user against a list of rules, with the highest level granted winning.
+*/
+type LDAP struct {
+	logger *log.Logger
+	stats  *log.Stats
+	config Config
+
+	directory ldapDirectory
+	rules     []ldapRule
+	secret    []byte
+}
+
+/*
+NewLDAP - Creates an LDAP authenticator using the provided configuration.
+*/
+func NewLDAP(config Config, logger *log.Logger, stats *log.Stats) (*LDAP, error) {
+	if len(config.LDAPConfig.URL) == 0 {
+		return nil, ErrLDAPNoURL
+	}
+	return newLDAP(config, ldapServer{config.LDAPConfig}, logger, stats)
+}
+
+func newLDAP(
+	config Config, directory ldapDirectory, logger *log.Logger, stats *log.Stats,
+) (*LDAP, error) {
+	l := LDAP{
+		logger:    logger.NewModule(":ldap_auth"),
+		stats:     stats,
+		config:    config,
+		directory: directory,
+		secret:    []byte(config.LDAPConfig.SessionSecret),
+	}
+	if len(l.secret) == 0 {
+		l.secret = make([]byte, 32)
+		if _, err := rand.Read(l.secret); err != nil {
+			return nil, err
+		}
+	}
+	for _, rule := range config.LDAPConfig.Rules {
+		var access AccessLevel
+		switch rule.Access {
+		case "read":
+			access = AccessRead
+		case "write":
+			access = AccessWrite
+		case "admin":
+			access = AccessAdmin
+		default:
+			return nil, ErrLDAPInvalidAccess
+		}
+		if _, err := path.Match(rule.Documents, ""); err != nil {
+			return nil, ErrLDAPInvalidRule
+		}
+		l.rules = append(l.rules, ldapRule{
+			group:     strings.ToLower(rule.Group),
+			documents: rule.Documents,
+			access:    access,
+		})
+	}
+	return &l, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+inGroup - Checks whether a list of group DNs contains a group, either by its full DN or by the value
+of its first RDN.
+*/
+func inGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		g = strings.ToLower(g)
+		if g == group {
+			return true
+		}
+		if dn, err := ldap.ParseDN(g); err == nil && len(dn.RDNs) > 0 && len(dn.RDNs[0].Attributes) > 0 {
+			if dn.RDNs[0].Attributes[0].Value == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/*
+sign - Encode and sign a session or token.
+*/
+func (l *LDAP) sign(s ldapSession) string {
+	payload, _ := json.Marshal(s)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/*
+read - Verify the signature, kind and expiry of a signed session or token and return its contents.
+*/
+func (l *LDAP) read(value, kind string) (ldapSession, bool) {
+	var s ldapSession
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return s, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return s, false
+	}
+	mac := hmac.New(sha256.New, l.secret)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return s, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return s, false
+	}
+	if err = json.Unmarshal(payload, &s); err != nil {
+		return s, false
+	}
+	if s.Kind != kind || time.Now().Unix() >= s.Expires {
+		return s, false
+	}
+	return s, true
+}
+
+/*
+session - Returns the contents of a client token if it is valid and has not expired.
+*/
+func (l *LDAP) session(token string) (ldapSession, bool) {
+	return l.read(token, "token")
+}
+
+/*
+issue - Returns a new client token for a session bound to a document, or any document when empty,
+which expires after ldapTokenTTL or along with the session if sooner.
+*/
+func (l *LDAP) issue(s ldapSession, documentID string) (string, int64, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", 0, err
+	}
+	if expires := time.Now().Add(ldapTokenTTL).Unix(); expires < s.Expires {
+		s.Expires = expires
+	}
+	s.Kind, s.Document, s.Nonce = "token", documentID, base64.RawURLEncoding.EncodeToString(nonce)
+	return l.sign(s), s.Expires, nil
+}
+
+/*
+AuthoriseCreate - Checks that the token belongs to a session of the user and is not bound to a
+document and, when a create group is configured, that the user is a member of it.
+*/
+func (l *LDAP) AuthoriseCreate(token, userID string) bool {
+	if !l.config.AllowCreate {
+		return false
+	}
+	s, ok := l.session(token)
+	if !ok || s.User != userID || len(s.Document) > 0 {
+		return false
+	}
+	if group := l.config.LDAPConfig.CreateGroup; len(group) > 0 {
+		return inGroup(s.Groups, strings.ToLower(group))
+	}
+	return true
+}
+
+/*
+Authorise - Returns the highest level of access granted to a document by the rules matching the
+groups of the session, tokens bound to another document are granted none.
+*/
+func (l *LDAP) Authorise(token, documentID string) AccessLevel {
+	s, ok := l.session(token)
+	if !ok || (len(s.Document) > 0 && s.Document != documentID) {
+		return AccessNone
+	}
+
+	level := AccessNone
+	for _, rule := range l.rules {
+		if rule.access <= level || !inGroup(s.Groups, rule.group) {
+			continue
+		}
+		if len(rule.documents) > 0 {
+			if match, _ := path.Match(rule.documents, documentID); !match {
+				continue
+			}
+		}
+		level = rule.access
+	}
+	return level
+}
+
+/*
+IdentifyUser - Returns the user of the session a token belongs to.
+*/
+func (l *LDAP) IdentifyUser(token string) (string, bool) {
+	s, ok := l.session(token)
+	if !ok {
+		return "", false
+	}
+	return s.User, true
+}
+
+/*
+login - Verifies credentials with the directory and returns a new session for the user.
+*/
+func (l *LDAP) login(username, password string) (ldapSession, error) {
+	groups, err := l.directory.Authenticate(username, password)
+	if err != nil {
+		return ldapSession{}, err
+	}
+	return ldapSession{
+		Kind:    "session",
+		User:    username,
+		Groups:  groups,
+		Expires: time.Now().Add(time.Second * time.Duration(l.config.LDAPConfig.SessionTTL)).Unix(),
+	}, nil
+}
+
+/*
+loginHandler - POST exchanges a username and password for a session cookie and a client token, GET
+exchanges the session cookie for a new client token.
+*/
+func (l *LDAP) loginHandler(w http.ResponseWriter, r *http.Request) {
+	var s ldapSession
+
+	switch r.Method {
+	case "GET":
+		cookie, err := r.Cookie(l.config.LDAPConfig.SessionCookie)
+		if err != nil {
+			http.Error(w, "No session", http.StatusUnauthorized)
+			return
+		}
+		var ok bool
+		if s, ok = l.read(cookie.Value, "session"); !ok {
+			http.Error(w, "Session expired", http.StatusUnauthorized)
+			return
+		}
+	case "POST":
+		bytes, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			l.logger.Errorf("Failed to read request body: %v\n", err)
+			http.Error(w, "Bad request: could not read body", http.StatusBadRequest)
+			return
+		}
+
+		var bodyObj struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}
+		if err = json.Unmarshal(bytes, &bodyObj); err != nil {
+			l.logger.Errorf("Failed to parse request body: %v\n", err)
+			http.Error(w, "Bad request: could not parse body", http.StatusBadRequest)
+			return
+		}
+
+		if s, err = l.login(bodyObj.Username, bodyObj.Password); err != nil {
+			if err == ErrLDAPBadLogin || err == ErrLDAPUserNotUnique {
+				l.stats.Incr("auth.ldap.login.rejected", 1)
+				http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+				return
+			}
+			l.logger.Errorf("LDAP login failed: %v\n", err)
+			l.stats.Incr("auth.ldap.login.error", 1)
+			http.Error(w, "Failed to contact directory", http.StatusBadGateway)
+			return
+		}
+		l.stats.Incr("auth.ldap.login.success", 1)
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     l.config.LDAPConfig.SessionCookie,
+			Value:    l.sign(s),
+			Path:     "/",
+			Expires:  time.Unix(s.Expires, 0),
+			HttpOnly: true,
+			Secure:   l.config.LDAPConfig.CookieSecure,
+			SameSite: http.SameSiteStrictMode,
+		})
+	default:
+		http.Error(w, "GET or POST endpoint only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, expires, err := l.issue(s, r.URL.Query().Get("document_id"))
+	if err != nil {
+		l.logger.Errorf("Failed to issue token: %v\n", err)
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	resBytes, err := json.Marshal(struct {
+		Token     string `json:"token"`
+		UserID    string `json:"user_id"`
+		ExpiresAt int64  `json:"expires_at"`
+	}{
+		Token:     token,
+		UserID:    s.User,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		l.logger.Errorf("Failed to generate JSON response: %v\n", err)
+		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(resBytes)
+}
+
+/*
+RegisterHandlers - Register the public login endpoint.
+*/
+func (l *LDAP) RegisterHandlers(register register.PubPrivEndpointRegister) error {
+	return register.RegisterPublic(
+		path.Join(l.config.LDAPConfig.Path, "login"),
+		"Log in with directory credentials to receive a session cookie and a token, POST: "+
+			`{"username":"<user>","password":"<password>"}, or GET with the session cookie to `+
+			"receive a new token, either with ?document_id=<id> to bind the token to one document",
+		l.loginHandler,
+	)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,266 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeDirectory map[string]struct {
+	password string
+	groups   []string
+}
+
+func (f fakeDirectory) Authenticate(username, password string) ([]string, error) {
+	if user, ok := f[username]; ok && len(password) > 0 && user.password == password {
+		return user.groups, nil
+	}
+	return nil, ErrLDAPBadLogin
+}
+
+type ldapRegister struct {
+	loginHandler http.HandlerFunc
+}
+
+func (l *ldapRegister) RegisterPublic(endpoint, description string, handler http.HandlerFunc) error {
+	if endpoint == "ldap/login" {
+		l.loginHandler = handler
+	}
+	return nil
+}
+
+func (l *ldapRegister) RegisterPrivate(endpoint, description string, handler http.HandlerFunc) error {
+	return nil
+}
+
+func TestLDAPAccessRules(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewConfig()
+	config.LDAPConfig.CreateGroup = "authors"
+	config.LDAPConfig.Rules = []LDAPRule{
+		{Group: "staff", Documents: "", Access: "read"},
+		{Group: "cn=editors,ou=groups,dc=example,dc=com", Documents: "team-*", Access: "write"},
+		{Group: "Admins", Documents: "", Access: "admin"},
+	}
+
+	directory := fakeDirectory{
+		"alice": {"a", []string{"cn=staff,ou=groups,dc=example,dc=com", "CN=Editors,OU=Groups,DC=example,DC=com"}},
+		"bob":   {"b", []string{"cn=staff,ou=groups,dc=example,dc=com", "cn=authors,ou=groups,dc=example,dc=com"}},
+		"carol": {"c", []string{"cn=admins,ou=groups,dc=example,dc=com"}},
+	}
+
+	l, err := newLDAP(config, directory, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = l.login("alice", "wrong"); err != ErrLDAPBadLogin {
+		t.Errorf("Wrong error from bad password: %v", err)
+	}
+
+	tokens := map[string]string{}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		session, err := l.login(user, directory[user].password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tokens[user], _, err = l.issue(session, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		user, doc string
+		level     AccessLevel
+	}{
+		{"alice", "team-a", AccessWrite},
+		{"alice", "other", AccessRead},
+		{"bob", "team-a", AccessRead},
+		{"carol", "team-a", AccessAdmin},
+	} {
+		if level := l.Authorise(tokens[test.user], test.doc); level != test.level {
+			t.Errorf("Wrong access for %v to %v: %v != %v", test.user, test.doc, level, test.level)
+		}
+	}
+
+	if level := l.Authorise("unknown", "team-a"); level != AccessNone {
+		t.Errorf("Unknown token granted access: %v", level)
+	}
+
+	// Tokens are signed, and so are accepted by every authenticator sharing the secret.
+	config.LDAPConfig.SessionSecret = "shared"
+	first, _ := newLDAP(config, directory, logger, stats)
+	second, _ := newLDAP(config, directory, logger, stats)
+	session, _ := first.login("carol", "c")
+	token, _, _ := first.issue(session, "")
+	if level := second.Authorise(token, "team-a"); level != AccessAdmin {
+		t.Errorf("Token not accepted with a shared secret: %v", level)
+	}
+	if level := l.Authorise(token, "team-a"); level != AccessNone {
+		t.Errorf("Token accepted with a different secret: %v", level)
+	}
+	if level := second.Authorise(second.sign(session), "team-a"); level != AccessNone {
+		t.Errorf("Session cookie accepted as a token: %v", level)
+	}
+
+	if l.AuthoriseCreate(tokens["alice"], "alice") {
+		t.Error("User outside of create group allowed to create")
+	}
+	if !l.AuthoriseCreate(tokens["bob"], "bob") {
+		t.Error("User in create group not allowed to create")
+	}
+	if l.AuthoriseCreate(tokens["bob"], "alice") {
+		t.Error("Token allowed to create for a different user")
+	}
+
+	config.LDAPConfig.Rules = []LDAPRule{{Group: "staff", Access: "owner"}}
+	if _, err = newLDAP(config, directory, logger, stats); err != ErrLDAPInvalidAccess {
+		t.Errorf("Wrong error from invalid access: %v", err)
+	}
+}
+
+func TestLDAPLoginEndpoint(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewConfig()
+	config.LDAPConfig.Rules = []LDAPRule{{Group: "staff", Access: "write"}}
+
+	l, err := newLDAP(config, fakeDirectory{
+		"alice": {"a", []string{"cn=staff,ou=groups,dc=example,dc=com"}},
+	}, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := ldapRegister{}
+	if err = l.RegisterHandlers(&reg); err != nil {
+		t.Fatal(err)
+	}
+	if reg.loginHandler == nil {
+		t.Fatal("Login handler was not registered")
+	}
+
+	w := httptest.NewRecorder()
+	reg.loginHandler(w, httptest.NewRequest("POST", "/ldap/login",
+		bytes.NewBufferString(`{"username":"alice","password":"nope"}`)))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status from bad login: %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	reg.loginHandler(w, httptest.NewRequest("POST", "/ldap/login",
+		bytes.NewBufferString(`{"username":"alice","password":"a"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status from login: %v", w.Code)
+	}
+
+	var res struct {
+		Token  string `json:"token"`
+		UserID string `json:"user_id"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.UserID != "alice" {
+		t.Errorf("Wrong user ID: %v", res.UserID)
+	}
+	if level := l.Authorise(res.Token, "doc"); level != AccessWrite {
+		t.Errorf("Wrong access from session token: %v", level)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value == res.Token {
+		t.Fatalf("Wrong session cookie: %v", cookies)
+	}
+	if !cookies[0].Secure || !cookies[0].HttpOnly || cookies[0].SameSite != http.SameSiteStrictMode {
+		t.Errorf("Session cookie is missing attributes: %+v", cookies[0])
+	}
+	if level := l.Authorise(cookies[0].Value, "doc"); level != AccessNone {
+		t.Errorf("Session cookie granted access: %v", level)
+	}
+
+	req := httptest.NewRequest("GET", "/ldap/login", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	reg.loginHandler(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Wrong status from cookie exchange: %v", w.Code)
+	}
+
+	var exchanged struct {
+		Token string `json:"token"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &exchanged); err != nil {
+		t.Fatal(err)
+	}
+	if exchanged.Token == res.Token {
+		t.Error("Cookie exchange returned the same token")
+	}
+	if level := l.Authorise(exchanged.Token, "doc"); level != AccessWrite {
+		t.Errorf("Wrong access from exchanged token: %v", level)
+	}
+	if !l.AuthoriseCreate(exchanged.Token, "alice") {
+		t.Error("Token not allowed to create")
+	}
+	if userID, ok := l.IdentifyUser(exchanged.Token); !ok || userID != "alice" {
+		t.Errorf("Wrong user identified: %v", userID)
+	}
+
+	// Tokens requested for a document can only join that document.
+	req = httptest.NewRequest("GET", "/ldap/login?document_id=mydoc", nil)
+	req.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	reg.loginHandler(w, req)
+
+	var bound struct {
+		Token     string `json:"token"`
+		ExpiresAt int64  `json:"expires_at"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &bound); err != nil {
+		t.Fatal(err)
+	}
+	if bound.ExpiresAt > time.Now().Add(ldapTokenTTL).Unix() {
+		t.Errorf("Token outlives its TTL: %v", bound.ExpiresAt)
+	}
+	if level := l.Authorise(bound.Token, "mydoc"); level != AccessWrite {
+		t.Errorf("Wrong access from bound token: %v", level)
+	}
+	if level := l.Authorise(bound.Token, "doc"); level != AccessNone {
+		t.Errorf("Bound token granted access to another document: %v", level)
+	}
+	if l.AuthoriseCreate(bound.Token, "alice") {
+		t.Error("Bound token allowed to create")
+	}
+
+	w = httptest.NewRecorder()
+	reg.loginHandler(w, httptest.NewRequest("GET", "/ldap/login", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status from missing cookie: %v", w.Code)
+	}
+}
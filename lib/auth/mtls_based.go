@@ -0,0 +1,296 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"path"
+	"sync"
+
+	"github.com/jeffail/leaps/lib/register"
+	"github.com/jeffail/leaps/lib/util"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+MTLSRule - Grants the identities matching a glob pattern a level of access ('read', 'write' or
+'admin') to the documents whose IDs match another, an empty pattern matches everything.
+*/
+type MTLSRule struct {
+	Identity  string `json:"identity" yaml:"identity"`
+	Documents string `json:"documents" yaml:"documents"`
+	Access    string `json:"access" yaml:"access"`
+}
+
+/*
+MTLSConfig - A config object for the mutual TLS authentication object. The identity of a client is
+read from the IdentityField of its verified certificate, being one of 'common_name', 'dns_san',
+'email_san' or 'uri_san', where the first name of a SAN field is used. The access of an identity is
+the highest granted by the Rules matching it.
+*/
+type MTLSConfig struct {
+	IdentityField string     `json:"identity_field" yaml:"identity_field"`
+	Rules         []MTLSRule `json:"rules" yaml:"rules"`
+}
+
+/*
+NewMTLSConfig - Returns a default config object for an MTLS.
+*/
+func NewMTLSConfig() MTLSConfig {
+	return MTLSConfig{
+		IdentityField: "common_name",
+		Rules:         []MTLSRule{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the MTLS type.
+var (
+	ErrMTLSInvalidField  = errors.New("mTLS identity field must be one of 'common_name', 'dns_san', 'email_san' or 'uri_san'")
+	ErrMTLSInvalidAccess = errors.New("mTLS rule access must be one of 'read', 'write' or 'admin'")
+	ErrMTLSInvalidRule   = errors.New("mTLS rule has an invalid pattern")
+	ErrMTLSNoIdentity    = errors.New("client certificate does not carry the identity field")
+)
+
+type mtlsRule struct {
+	identity  string
+	documents string
+	access    AccessLevel
+}
+
+/*
+mtlsToken - The identity of the connection a token was issued to, along with the fingerprint of the
+certificate it presented.
+*/
+type mtlsToken struct {
+	identity    string
+	fingerprint [sha256.Size]byte
+}
+
+/*
+MTLS - An authenticator for clients that connect with a client certificate verified by the TLS
+config of the server. Rather than tokens being handed out ahead of time, the server exchanges the
+certificate of each connection for a token that lasts until the connection is released, and which
+is refused from connections presenting any other certificate.
+*/
+type MTLS struct {
+	logger *log.Logger
+	stats  *log.Stats
+	config Config
+	rules  []mtlsRule
+
+	mutex  sync.RWMutex
+	tokens map[string]mtlsToken
+}
+
+/*
+NewMTLS - Creates an MTLS authenticator using the provided configuration.
+*/
+func NewMTLS(config Config, logger *log.Logger, stats *log.Stats) (*MTLS, error) {
+	switch config.MTLSConfig.IdentityField {
+	case "common_name", "dns_san", "email_san", "uri_san":
+	default:
+		return nil, ErrMTLSInvalidField
+	}
+
+	m := MTLS{
+		logger: logger.NewModule(":mtls_auth"),
+		stats:  stats,
+		config: config,
+		tokens: map[string]mtlsToken{},
+	}
+	for _, rule := range config.MTLSConfig.Rules {
+		var access AccessLevel
+		switch rule.Access {
+		case "read":
+			access = AccessRead
+		case "write":
+			access = AccessWrite
+		case "admin":
+			access = AccessAdmin
+		default:
+			return nil, ErrMTLSInvalidAccess
+		}
+		if _, err := path.Match(rule.Identity, ""); err != nil {
+			return nil, ErrMTLSInvalidRule
+		}
+		if _, err := path.Match(rule.Documents, ""); err != nil {
+			return nil, ErrMTLSInvalidRule
+		}
+		m.rules = append(m.rules, mtlsRule{
+			identity:  rule.Identity,
+			documents: rule.Documents,
+			access:    access,
+		})
+	}
+	return &m, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+identity - Read the identity of a certificate from the configured field.
+*/
+func (m *MTLS) identity(cert *x509.Certificate) string {
+	switch m.config.MTLSConfig.IdentityField {
+	case "common_name":
+		return cert.Subject.CommonName
+	case "dns_san":
+		if len(cert.DNSNames) > 0 {
+			return cert.DNSNames[0]
+		}
+	case "email_san":
+		if len(cert.EmailAddresses) > 0 {
+			return cert.EmailAddresses[0]
+		}
+	case "uri_san":
+		if len(cert.URIs) > 0 {
+			return cert.URIs[0].String()
+		}
+	}
+	return ""
+}
+
+/*
+AuthenticateCertificate - Exchange the verified certificate of a connection for a token that
+identifies it, along with the identity of the client. The token must be released once the
+connection closes.
+*/
+func (m *MTLS) AuthenticateCertificate(cert *x509.Certificate) (string, string, error) {
+	identity := m.identity(cert)
+	if len(identity) == 0 {
+		m.stats.Incr("auth.mtls.rejected", 1)
+		return "", "", ErrMTLSNoIdentity
+	}
+
+	token := util.GenerateStampedUUID()
+
+	m.mutex.Lock()
+	m.tokens[token] = mtlsToken{
+		identity:    identity,
+		fingerprint: sha256.Sum256(cert.Raw),
+	}
+	m.mutex.Unlock()
+
+	m.stats.Incr("auth.mtls.accepted", 1)
+	return token, identity, nil
+}
+
+/*
+ReleaseToken - Forget the token of a closed connection.
+*/
+func (m *MTLS) ReleaseToken(token string) {
+	m.mutex.Lock()
+	delete(m.tokens, token)
+	m.mutex.Unlock()
+}
+
+/*
+VerifyToken - Checks that a token may be presented by a connection with a certificate, which is nil
+for connections without one. Tokens issued for a certificate are refused from connections without
+that same certificate, other tokens are left to Authorise.
+*/
+func (m *MTLS) VerifyToken(token string, cert *x509.Certificate) bool {
+	m.mutex.RLock()
+	issued, ok := m.tokens[token]
+	m.mutex.RUnlock()
+	if !ok {
+		return true
+	}
+	if cert == nil || sha256.Sum256(cert.Raw) != issued.fingerprint {
+		m.stats.Incr("auth.mtls.token_misused", 1)
+		return false
+	}
+	return true
+}
+
+/*
+AuthoriseCreate - Checks that the token belongs to a connection of the user.
+*/
+func (m *MTLS) AuthoriseCreate(token, userID string) bool {
+	if !m.config.AllowCreate {
+		return false
+	}
+
+	m.mutex.RLock()
+	issued, ok := m.tokens[token]
+	m.mutex.RUnlock()
+
+	return ok && issued.identity == userID
+}
+
+/*
+IdentifyUser - Returns the identity of the connection a token belongs to.
+*/
+func (m *MTLS) IdentifyUser(token string) (string, bool) {
+	m.mutex.RLock()
+	issued, ok := m.tokens[token]
+	m.mutex.RUnlock()
+	return issued.identity, ok
+}
+
+/*
+Authorise - Returns the highest level of access granted to a document by the rules matching the
+identity of the connection.
+*/
+func (m *MTLS) Authorise(token, documentID string) AccessLevel {
+	m.mutex.RLock()
+	issued, ok := m.tokens[token]
+	m.mutex.RUnlock()
+	if !ok {
+		return AccessNone
+	}
+
+	level := AccessNone
+	for _, rule := range m.rules {
+		if rule.access <= level {
+			continue
+		}
+		if match, _ := path.Match(rule.identity, issued.identity); len(rule.identity) > 0 && !match {
+			continue
+		}
+		if match, _ := path.Match(rule.documents, documentID); len(rule.documents) > 0 && !match {
+			continue
+		}
+		level = rule.access
+	}
+	return level
+}
+
+/*
+RegisterHandlers - Nothing to register.
+*/
+func (m *MTLS) RegisterHandlers(register.PubPrivEndpointRegister) error {
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
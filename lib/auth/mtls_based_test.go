@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/url"
+	"testing"
+)
+
+func TestMTLSRules(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewConfig()
+	config.MTLSConfig.Rules = []MTLSRule{
+		{Identity: "", Documents: "", Access: "read"},
+		{Identity: "*.tools.internal", Documents: "build-*", Access: "write"},
+		{Identity: "ops", Documents: "", Access: "admin"},
+	}
+
+	m, err := NewMTLS(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens := map[string]string{}
+	for _, cn := range []string{"ci.tools.internal", "ops"} {
+		token, identity, err := m.AuthenticateCertificate(&x509.Certificate{Subject: pkix.Name{CommonName: cn}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if identity != cn {
+			t.Errorf("Wrong identity: %v != %v", identity, cn)
+		}
+		tokens[cn] = token
+	}
+
+	for _, test := range []struct {
+		identity, doc string
+		level         AccessLevel
+	}{
+		{"ci.tools.internal", "build-1", AccessWrite},
+		{"ci.tools.internal", "notes", AccessRead},
+		{"ops", "build-1", AccessAdmin},
+	} {
+		if level := m.Authorise(tokens[test.identity], test.doc); level != test.level {
+			t.Errorf("Wrong access for %v to %v: %v != %v", test.identity, test.doc, level, test.level)
+		}
+	}
+
+	if !m.AuthoriseCreate(tokens["ops"], "ops") || m.AuthoriseCreate(tokens["ops"], "ci.tools.internal") {
+		t.Error("Wrong create authorisation")
+	}
+
+	// Tokens are only accepted from connections presenting the certificate they were issued for.
+	opsCert := &x509.Certificate{Raw: []byte("ops"), Subject: pkix.Name{CommonName: "ops"}}
+	opsToken, _, _ := m.AuthenticateCertificate(opsCert)
+	if !m.VerifyToken(opsToken, opsCert) {
+		t.Error("Token refused from its own certificate")
+	}
+	if m.VerifyToken(opsToken, nil) {
+		t.Error("Token accepted from a connection without a certificate")
+	}
+	if m.VerifyToken(opsToken, &x509.Certificate{Raw: []byte("other"), Subject: pkix.Name{CommonName: "ops"}}) {
+		t.Error("Token accepted from another certificate of the same identity")
+	}
+	if !m.VerifyToken("unknown", nil) {
+		t.Error("Token not issued for a certificate was refused")
+	}
+
+	m.ReleaseToken(tokens["ops"])
+	if level := m.Authorise(tokens["ops"], "build-1"); level != AccessNone {
+		t.Errorf("Released token granted access: %v", level)
+	}
+
+	if _, _, err = m.AuthenticateCertificate(&x509.Certificate{}); err != ErrMTLSNoIdentity {
+		t.Errorf("Wrong error for certificate without identity: %v", err)
+	}
+
+	config.MTLSConfig.IdentityField = "uri_san"
+	if m, err = NewMTLS(config, logger, stats); err != nil {
+		t.Fatal(err)
+	}
+	spiffe, _ := url.Parse("spiffe://example.org/ops")
+	if _, identity, _ := m.AuthenticateCertificate(&x509.Certificate{URIs: []*url.URL{spiffe}}); identity != spiffe.String() {
+		t.Errorf("Wrong URI identity: %v", identity)
+	}
+
+	config.MTLSConfig.IdentityField = "serial"
+	if _, err = NewMTLS(config, logger, stats); err != ErrMTLSInvalidField {
+		t.Errorf("Wrong error for invalid field: %v", err)
+	}
+}
@@ -37,6 +37,42 @@ var (
 	ErrInvalidAuthType = errors.New("invalid token authenticator type")
 )
 
+/*
+AccessLevel - The level of access a token grants to a document. Levels are ordered such that each
+one includes the access of those beneath it.
+*/
+type AccessLevel int
+
+// Levels of access to a document.
+const (
+	AccessNone AccessLevel = iota
+	AccessRead
+	AccessWrite
+	AccessAdmin
+)
+
+/*
+Grants - Returns whether this level of access includes another.
+*/
+func (a AccessLevel) Grants(level AccessLevel) bool {
+	return a >= level
+}
+
+/*
+String - Returns a human readable name of the access level.
+*/
+func (a AccessLevel) String() string {
+	switch a {
+	case AccessRead:
+		return "read"
+	case AccessWrite:
+		return "write"
+	case AccessAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
 /*
 Authenticator - Implemented by types able to validate tokens for editing or creating documents.
 This is abstracted in order to accommodate for multiple authentication strategies.
@@ -45,12 +81,20 @@ type Authenticator interface {
 	// AuthoriseCreate - Validate that a `create action` token corresponds to a particular user.
 	AuthoriseCreate(token, userID string) bool
 
-	// AuthoriseJoin - Validate that a `join action` token corresponds to a particular document.
-	AuthoriseJoin(token, documentID string) bool
-
-	// AuthoriseReadOnly - Validate that a `read only` token corresponds to a particular document.
-	AuthoriseReadOnly(token, documentID string) bool
+	// Authorise - Return the level of access a token grants to a particular document, tokens that
+	// do not correspond to the document are granted AccessNone.
+	Authorise(token, documentID string) AccessLevel
 
 	// RegisterHandlers - Allow the Auth to register any API endpoints it needs.
 	RegisterHandlers(register register.PubPrivEndpointRegister) error
 }
+
+/*
+UserIdentifier - Implemented by authenticators whose tokens belong to users, which allows state such
+as notification preferences to follow a user across their tokens.
+*/
+type UserIdentifier interface {
+	// IdentifyUser - Return the ID of the user a token belongs to without using the token up, or
+	// false when the token is invalid or belongs to no user.
+	IdentifyUser(token string) (string, bool)
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/jeffail/leaps/lib/register"
+	"github.com/jeffail/leaps/lib/util"
 	"github.com/jeffail/util/log"
 )
 
@@ -39,10 +40,11 @@ import (
 RedisConfig - A config object for the redis authentication object.
 */
 type RedisConfig struct {
-	URL          string `json:"url" yaml:"url"`
-	Password     string `json:"password" yaml:"password"`
-	PoolIdleTOut int64  `json:"pool_idle_s" yaml:"pool_idle_s"`
-	PoolMaxIdle  int    `json:"pool_max_idle" yaml:"pool_max_idle"`
+	URL          string            `json:"url" yaml:"url"`
+	Password     string            `json:"password" yaml:"password"`
+	PoolIdleTOut int64             `json:"pool_idle_s" yaml:"pool_idle_s"`
+	PoolMaxIdle  int               `json:"pool_max_idle" yaml:"pool_max_idle"`
+	Outbound     util.ClientConfig `json:"outbound" yaml:"outbound"`
 }
 
 /*
@@ -54,6 +56,7 @@ func NewRedisConfig() RedisConfig {
 		Password:     "",
 		PoolIdleTOut: 240,
 		PoolMaxIdle:  3,
+		Outbound:     util.NewClientConfig(),
 	}
 }
 
@@ -65,7 +68,19 @@ func newPool(config RedisConfig) *redis.Pool {
 		MaxIdle:     config.PoolMaxIdle,
 		IdleTimeout: time.Duration(config.PoolIdleTOut) * time.Second,
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", config.URL)
+			dial, err := util.NewDialer(config.Outbound)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig, err := util.NewClientTLS(config.Outbound)
+			if err != nil {
+				return nil, err
+			}
+			c, err := redis.Dial("tcp", config.URL,
+				redis.DialNetDial(dial),
+				redis.DialUseTLS(config.Outbound.TLS.Enabled),
+				redis.DialTLSConfig(tlsConfig),
+			)
 			if err != nil {
 				return nil, err
 			}
@@ -132,61 +147,83 @@ func (s *Redis) AuthoriseCreate(token, userID string) bool {
 		s.logger.Warnf("create token invalid, provided: %v, actual: %v\n", userID, userKey)
 		return false
 	}
-	err = s.DeleteKey(token)
-	if err != nil {
-		s.logger.Errorf("failed to delete key: %v\n", token)
-	}
+	s.consumeKey(token)
 	return true
 }
 
 /*
-AuthoriseJoin - Checks whether a specific key exists in Redis and that the value matches a document
-ID.
+Authorise - Checks whether a specific key exists in Redis and that the value matches a document ID.
+The value is prefixed with READ-ONLY: for read access and ADMIN: for admin access, an unprefixed
+value grants write access. Tokens are removed once used.
 */
-func (s *Redis) AuthoriseJoin(token, documentID string) bool {
+func (s *Redis) Authorise(token, documentID string) AccessLevel {
 	docKey, err := s.ReadKey(token)
 	if err != nil {
-		s.logger.Errorf("failed to get authorise join token: %v\n", err)
-		return false
-	}
-	if docKey != documentID {
-		s.logger.Warnf("join token invalid, provided: %v, actual: %v\n", documentID, docKey)
-		return false
+		s.logger.Errorf("failed to get authorise token: %v\n", err)
+		return AccessNone
 	}
-	err = s.DeleteKey(token)
-	if err != nil {
-		s.logger.Errorf("failed to delete key: %v\n", token)
+
+	level := AccessNone
+	switch docKey {
+	case documentID:
+		level = AccessWrite
+	case fmt.Sprintf("%v:%v", "READ-ONLY", documentID):
+		level = AccessRead
+	case fmt.Sprintf("%v:%v", "ADMIN", documentID):
+		level = AccessAdmin
+	default:
+		s.logger.Warnf("token invalid, provided: %v, actual: %v\n", documentID, docKey)
+		return AccessNone
 	}
-	return true
+	s.consumeKey(token)
+	return level
 }
 
 /*
-AuthoriseReadOnly - Checks whether a specific key exists in Redis and that the value matches a
-document ID.
+IssueToken - Write a new token to Redis with a value following the key conventions of Authorise,
+Redis expires the token after ttl.
 */
-func (s *Redis) AuthoriseReadOnly(token, documentID string) bool {
-	docKey, err := s.ReadKey(token)
-	if err != nil {
-		s.logger.Errorf("failed to get authorise join token: %v\n", err)
-		return false
+func (s *Redis) IssueToken(action, key string, ttl time.Duration) (string, error) {
+	value := key
+	switch action {
+	case TokenActionCreate, TokenActionJoin:
+	case TokenActionRead:
+		value = fmt.Sprintf("%v:%v", "READ-ONLY", key)
+	case TokenActionAdmin:
+		value = fmt.Sprintf("%v:%v", "ADMIN", key)
+	default:
+		return "", ErrInvalidTokenAction
 	}
-	expectedKey := fmt.Sprintf("%v:%v", "READ-ONLY", documentID)
-	if docKey != expectedKey {
-		s.logger.Warnf("join token invalid, provided: %v, actual: %v\n", expectedKey, docKey)
-		return false
+	token := util.GenerateStampedUUID()
+	if err := s.WriteKey(token, value, ttl); err != nil {
+		return "", err
 	}
-	err = s.DeleteKey(token)
-	if err != nil {
-		s.logger.Errorf("failed to delete key: %v\n", token)
+	return token, nil
+}
+
+/*
+consumeKey - Apply the token policy to a token that has just been used, which either deletes it or
+ensures that it expires within the reuse period.
+*/
+func (s *Redis) consumeKey(token string) {
+	if s.config.TokenPolicy.SingleUse {
+		if err := s.DeleteKey(token); err != nil {
+			s.logger.Errorf("failed to delete key: %v\n", token)
+		}
+		return
+	}
+	if reuse := s.config.TokenPolicy.reuseExpiry(); reuse > 0 {
+		if err := s.CapKeyExpiry(token, reuse); err != nil {
+			s.logger.Errorf("failed to set expiry of key: %v\n", token)
+		}
 	}
-	return true
 }
 
 /*
-RegisterHandlers - Nothing to register.
+RegisterHandlers - Register the token issuance endpoint, if enabled.
 */
-func (s *Redis) RegisterHandlers(register.PubPrivEndpointRegister) error {
-	return nil
+func (s *Redis) RegisterHandlers(register register.PubPrivEndpointRegister) error {
+	return registerIssueHandler(register, s.config.IssueConfig, s, s.logger)
 }
 
 /*
@@ -203,6 +240,39 @@ func (s *Redis) ReadKey(key string) (string, error) {
 	return reply, nil
 }
 
+/*
+WriteKey - Set the value of a key that expires after ttl, which is rounded up to whole seconds.
+*/
+func (s *Redis) WriteKey(key, value string, ttl time.Duration) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	_, err := conn.Do("SET", key, value, "EX", seconds)
+	return err
+}
+
+/*
+CapKeyExpiry - Ensure that a key expires within a period, keys that already expire sooner are left
+alone.
+*/
+func (s *Redis) CapKeyExpiry(key string, period time.Duration) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	seconds := int64((period + time.Second - 1) / time.Second)
+	remaining, err := redis.Int64(conn.Do("TTL", key))
+	if err != nil {
+		return err
+	}
+	// A negative TTL means the key has no expiry, or no longer exists.
+	if remaining >= 0 && remaining <= seconds {
+		return nil
+	}
+	_, err = conn.Do("EXPIRE", key, seconds)
+	return err
+}
+
 /*
 DeleteKey - Deletes an existing key.
 */
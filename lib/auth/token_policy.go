@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+TokenPolicyConfig - Options for how tokens held by an authenticator, such as Redis and HTTP, are
+consumed. Single use tokens are removed once used. Otherwise tokens remain valid until they expire,
+allowing a user to open a document in several tabs with one token, and when ReuseExpiry is set a
+used token expires within that many seconds of its first use.
+*/
+type TokenPolicyConfig struct {
+	SingleUse   bool  `json:"single_use" yaml:"single_use"`
+	ReuseExpiry int64 `json:"reuse_expiry_s" yaml:"reuse_expiry_s"`
+}
+
+/*
+NewTokenPolicyConfig - Returns a default config object for token policies, where tokens are single
+use.
+*/
+func NewTokenPolicyConfig() TokenPolicyConfig {
+	return TokenPolicyConfig{
+		SingleUse:   true,
+		ReuseExpiry: 300,
+	}
+}
+
+/*
+reuseExpiry - The period within which a used token must expire, or zero if its expiry is left as is.
+*/
+func (t TokenPolicyConfig) reuseExpiry() time.Duration {
+	if t.ReuseExpiry <= 0 {
+		return 0
+	}
+	return time.Duration(t.ReuseExpiry) * time.Second
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
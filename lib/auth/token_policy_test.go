@@ -0,0 +1,69 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHTTPTokenPolicy(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewConfig()
+	httpAuth := NewHTTP(config, logger, stats)
+
+	token, err := httpAuth.IssueToken(TokenActionJoin, "doc1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level := httpAuth.Authorise(token, "doc1"); level != AccessWrite {
+		t.Errorf("Wrong access for first use: %v", level)
+	}
+	if level := httpAuth.Authorise(token, "doc1"); level != AccessNone {
+		t.Errorf("Single use token was reused: %v", level)
+	}
+
+	config.TokenPolicy.SingleUse = false
+	config.TokenPolicy.ReuseExpiry = 60
+	httpAuth = NewHTTP(config, logger, stats)
+
+	if token, err = httpAuth.IssueToken(TokenActionJoin, "doc1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if level := httpAuth.Authorise(token, "doc1"); level != AccessWrite {
+			t.Errorf("Wrong access for use %v: %v", i, level)
+		}
+	}
+	if expires := httpAuth.tokensJoin[token].expires; expires.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Used token expiry was not capped: %v", expires)
+	}
+
+	if token, err = httpAuth.IssueToken(TokenActionRead, "doc1", -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if level := httpAuth.Authorise(token, "doc1"); level != AccessNone {
+		t.Errorf("Expired token granted %v", level)
+	}
+}
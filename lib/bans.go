@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Bans - The users banned from subscribing to documents, and when each of their bans expire. A curator
+keeps one set of bans for all of its documents so that bans outlive the binders they were made on,
+and apply again when a closed or evicted document is next opened.
+*/
+type Bans struct {
+	bans  map[string]map[string]time.Time
+	mutex sync.Mutex
+}
+
+/*
+NewBans - Creates an empty set of bans.
+*/
+func NewBans() *Bans {
+	return &Bans{
+		bans: map[string]map[string]time.Time{},
+	}
+}
+
+/*
+Ban - Ban a user from a document for a duration, a duration of zero or less lifts an existing ban
+instead. Expired bans of every document are removed at the same time.
+*/
+func (b *Bans) Ban(documentID, userID string, duration time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.prune(time.Now())
+	if duration <= 0 {
+		if users, ok := b.bans[documentID]; ok {
+			delete(users, userID)
+			if len(users) == 0 {
+				delete(b.bans, documentID)
+			}
+		}
+		return
+	}
+	users, ok := b.bans[documentID]
+	if !ok {
+		users = map[string]time.Time{}
+		b.bans[documentID] = users
+	}
+	users[userID] = time.Now().Add(duration)
+}
+
+/*
+Banned - Returns whether a user is currently banned from a document.
+*/
+func (b *Bans) Banned(documentID, userID string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	until, ok := b.bans[documentID][userID]
+	return ok && time.Now().Before(until)
+}
+
+/*
+prune - Remove all expired bans. Must be called whilst holding the lock.
+*/
+func (b *Bans) prune(now time.Time) {
+	for documentID, users := range b.bans {
+		for userID, until := range users {
+			if !now.Before(until) {
+				delete(users, userID)
+			}
+		}
+		if len(users) == 0 {
+			delete(b.bans, documentID)
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
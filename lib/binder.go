@@ -25,6 +25,8 @@ package lib
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jeffail/leaps/lib/store"
@@ -36,14 +38,69 @@ import (
  */
 
 /*
-BinderConfig - Holds configuration options for a binder.
+BinderConfig - Holds configuration options for a binder. The transform history of a binder retains
+at most HistoryLength versions, and when HistoryPeriod is set only those received within that many
+seconds, which is enforced by compacting the history every CompactionPeriod seconds.
+
+When SpectatorPeriod is set the presence of read only clients is not announced to other clients,
+instead the number of read only clients is sent out whenever it changes, at most once every
+SpectatorPeriod milliseconds.
+
+When EvictionPeriod is set a binder that has had no clients for that many seconds flushes and asks
+its curator to release it, the curator then emits an EvictionEvent to its eviction hooks.
+
+When a Recorder directory is set the binder records its full transform stream, see RecorderConfig.
+
+Validators are keyed by document type (text when empty) or by the extension of document IDs such as
+'.yaml', and are run against the content of the document each time it is flushed. A document that
+fails validation is still flushed, but its clients are sent diagnostics and the curator emits a
+DiagnosticEvent to its diagnostic hooks.
+
+Formatters are keyed in the same way, although a formatter configured for the extension of a
+document takes precedence over one configured for its type, and only text documents are formatted.
+Each periodic flush that changes the document is followed by formatting the document in the
+background, any changes are then applied as a transform authored by FormatterAuthor against the
+version that was formatted. Formatters that run external commands are sandboxed as per
+CommandSandbox, see ExecutorConfig.
+
+When BroadcastWindow is set to a number of milliseconds transforms are not broadcast as they arrive,
+instead those arriving within the window are sent to each client as a single batch through the
+BatchRcvChan of its portal once the window closes. This reduces the cost of fanning out bursts of
+small transforms to many clients.
+
+When FlushVersions is set the binder also flushes as soon as that many transforms have been applied
+since its last flush, whichever comes first of that and FlushPeriod. This bounds the unflushed state
+of busy documents, which can otherwise grow large between flushes.
+
+When the store is slow to flush the binder may switch into a degraded mode, see DegradationConfig.
+
+When WatchStore is set the binder notices when its document has been modified in the store by
+something other than leaps, and merges the changes into the document as transforms authored by
+StoreAuthor. Changes are noticed at the next flush, or straight away with stores able to watch for
+them, see store.Watcher.
 */
 type BinderConfig struct {
-	FlushPeriod           int64       `json:"flush_period_ms" yaml:"flush_period_ms"`
-	RetentionPeriod       int64       `json:"retention_period_s" yaml:"retention_period_s"`
-	ClientKickPeriod      int64       `json:"kick_period_ms" yaml:"kick_period_ms"`
-	CloseInactivityPeriod int64       `json:"close_inactivity_period_s" yaml:"close_inactivity_period_s"`
-	ModelConfig           ModelConfig `json:"transform_model" yaml:"transform_model"`
+	FlushPeriod           int64                        `json:"flush_period_ms" yaml:"flush_period_ms"`
+	FlushVersions         int                          `json:"flush_versions" yaml:"flush_versions"`
+	RetentionPeriod       int64                        `json:"retention_period_s" yaml:"retention_period_s"`
+	ClientKickPeriod      int64                        `json:"kick_period_ms" yaml:"kick_period_ms"`
+	CloseInactivityPeriod int64                        `json:"close_inactivity_period_s" yaml:"close_inactivity_period_s"`
+	HistoryLength         int                          `json:"history_length" yaml:"history_length"`
+	HistoryPeriod         int64                        `json:"history_period_s" yaml:"history_period_s"`
+	CompactionPeriod      int64                        `json:"compaction_period_s" yaml:"compaction_period_s"`
+	SpectatorPeriod       int64                        `json:"spectator_period_ms" yaml:"spectator_period_ms"`
+	EvictionPeriod        int64                        `json:"eviction_period_s" yaml:"eviction_period_s"`
+	MaxDocumentSize       uint64                       `json:"max_document_size" yaml:"max_document_size"`
+	MaxTransformSize      uint64                       `json:"max_transform_size" yaml:"max_transform_size"`
+	RateLimit             RateLimitConfig              `json:"rate_limit" yaml:"rate_limit"`
+	ModelConfig           ModelConfig                  `json:"transform_model" yaml:"transform_model"`
+	Recorder              RecorderConfig               `json:"recorder" yaml:"recorder"`
+	Validators            map[string][]ValidatorConfig `json:"validators" yaml:"validators"`
+	Formatters            map[string]FormatterConfig   `json:"formatters" yaml:"formatters"`
+	CommandSandbox        ExecutorConfig               `json:"command_sandbox" yaml:"command_sandbox"`
+	BroadcastWindow       int64                        `json:"broadcast_window_ms" yaml:"broadcast_window_ms"`
+	Degradation           DegradationConfig            `json:"degradation" yaml:"degradation"`
+	WatchStore            bool                         `json:"watch_store" yaml:"watch_store"`
 }
 
 /*
@@ -53,10 +110,26 @@ field.
 func DefaultBinderConfig() BinderConfig {
 	return BinderConfig{
 		FlushPeriod:           500,
+		FlushVersions:         0,
 		RetentionPeriod:       60,
 		ClientKickPeriod:      200,
 		CloseInactivityPeriod: 300,
+		HistoryLength:         1000,
+		HistoryPeriod:         0,
+		CompactionPeriod:      60,
+		SpectatorPeriod:       0,
+		EvictionPeriod:        0,
+		MaxDocumentSize:       50000000, // ~50MB
+		MaxTransformSize:      50000,    // ~50KB
+		RateLimit:             NewRateLimitConfig(),
 		ModelConfig:           DefaultModelConfig(),
+		Recorder:              NewRecorderConfig(),
+		Validators:            map[string][]ValidatorConfig{},
+		Formatters:            map[string]FormatterConfig{},
+		CommandSandbox:        NewExecutorConfig(),
+		BroadcastWindow:       0,
+		Degradation:           NewDegradationConfig(),
+		WatchStore:            false,
 	}
 }
 
@@ -65,32 +138,139 @@ func DefaultBinderConfig() BinderConfig {
 
 // Errors for the Binder type.
 var (
-	ErrDuplicateClientToken = errors.New("duplicate client token")
+	ErrRateLimited       = errors.New("client exceeded its rate limit")
+	ErrDocumentTooLarge  = errors.New("transform would exceed the document size limit")
+	ErrTransformTooLarge = errors.New("transform exceeded the transform size limit")
+	ErrEpochMismatch     = errors.New("client holds a version from a previous binding of the document")
+	ErrPortalClosed      = errors.New("portal is no longer subscribed to the binder")
+	ErrBinderClosed      = errors.New("document was closed whilst subscribing")
 )
 
 /*
 Binder - Contains a single document and acts as a broker between multiple readers, writers and the
-storage strategy.
+storage strategy. The versions of a document begin again each time it is bound, and so Epoch
+uniquely identifies this binding of the document.
 */
 type Binder struct {
 	ID     string
+	Epoch  string
 	config BinderConfig
 	model  Model
 	block  store.Store
 	log    *log.Logger
 	stats  *log.Stats
 
+	// Durable log of transforms not yet flushed to the store, may be nil
+	transforms TransformStore
+
+	// Synchronises the document with its binders on other nodes, nil unless clustered
+	relay *binderRelay
+
+	// How users wish to be notified of activity on the document, may be nil
+	preferences *Preferences
+
+	// Persists anchored comments, may be nil, and the recent comments held for new subscribers.
+	// Comments are queued for a goroutine of their own so that the loop never waits on the store.
+	comments     CommentStore
+	commentQueue chan Comment
+	commentsDone chan struct{}
+	heldComments []Comment
+
+	// Resources shared with other binders of the same namespace, may be nil
+	namespace *Namespace
+	latency   *LatencyTracker
+	metrics   *Metrics
+
+	// Records who did what to the document, may be nil
+	audit *Auditor
+
+	// Called with the lifecycle events of the document, may be nil
+	lifecycleHook LifecycleHook
+
+	// Whether transforms have been pushed since our last flush, and how many
+	dirty     bool
+	unflushed int
+
+	// The version, digest and time of the document as last flushed, and whether it had clients when
+	// its lifecycle was last reported
+	flushedVersion int
+	flushedDigest  string
+	flushedAt      time.Time
+	occupied       bool
+
+	// Upper bound of the document size in bytes once pending transforms are flushed
+	size uint64
+
+	// The metadata of the document, and whether it has changed since our last flush
+	metadata      map[string]string
+	metadataDirty bool
+
+	// The last number of spectators sent to clients
+	spectators int
+
+	// When the binder last became empty of clients, zero while clients are subscribed
+	emptySince time.Time
+
+	// Transforms retained for reconstructing past versions
+	history binderHistory
+
+	// Records the transform stream of the document, may be nil
+	recorder *Recorder
+
+	// Validators run against the content of each flush
+	validators []namedValidator
+
+	// Formats the content after each periodic flush, may be nil. Formatters run outside of the loop,
+	// one at a time, and return their results on formatChan.
+	formatter  Formatter
+	formatChan chan formatResult
+	formatting bool
+
+	// Users banned from subscribing to the document
+	bans *Bans
+
+	// Set once the binder is draining, after which new subscribers are refused, drainedChan is closed
+	// once the last client of a draining binder has left
+	draining    bool
+	drainedChan chan struct{}
+
+	// Transforms waiting for the broadcast window to close, and when it closes
+	broadcasts   []queuedBroadcast
+	broadcastDue time.Time
+
+	// Whether the next periodic flush has already been held back by an injected fault
+	flushDelayed bool
+
+	// Recent flush latency, and whether the binder is degraded because of it
+	health binderHealth
+
+	// The document as last read or written by this binder, when watching the store for changes
+	stored storeState
+
 	// Clients
 	clients       map[string]BinderClient
 	subscribeChan chan BinderSubscribeBundle
 
 	// Control channels
-	transformChan    chan TransformSubmission
-	messageChan      chan MessageSubmission
-	usersRequestChan chan usersRequestObj
-	exitChan         chan string
-	errorChan        chan<- BinderError
-	closedChan       chan struct{}
+	transformChan       chan TransformSubmission
+	messageChan         chan MessageSubmission
+	usersRequestChan    chan usersRequestObj
+	versionRequestChan  chan versionRequestObj
+	snapshotRequestChan chan snapshotRequestObj
+	inspectRequestChan  chan inspectRequestObj
+	exitChan            chan string
+	kickChan            chan kickRequestObj
+	drainChan           chan drainRequestObj
+	noticeChan          chan noticeRequestObj
+	storeChangedChan    chan struct{}
+	releaseChan         chan chan bool
+	errorChan           chan<- BinderError
+	closeChan           chan struct{}
+	closeOnce           sync.Once
+	closedChan          chan struct{}
+
+	// The error of the final flush, set before closedChan is closed
+	closeErr error
 }
 
 /*
@@ -105,29 +285,140 @@ func NewBinder(
 	log *log.Logger,
 	stats *log.Stats,
 ) (*Binder, error) {
+	return newBinder(id, block, nil, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, errorChan, log, stats)
+}
+
+/*
+newBinder - Creates a binder that draws flush slots and broadcast bandwidth from a namespace, logs
+applied transforms to a transform store, synchronises with the binders of other nodes through a
+relay, honours the notification preferences of users, persists anchored comments to a comment store,
+reports transform latencies to a tracker and its activity to metrics, records joins, departures,
+kicks and flushes in the audit log, reports its lifecycle events to a hook and checks subscribers
+against a set of bans shared with other binders. The namespace, transform store, relay, preferences,
+comment store, latency tracker, metrics, auditor, lifecycle hook and bans may all be nil, in which
+case bans are kept by the binder alone.
+*/
+func newBinder(
+	id string,
+	block store.Store,
+	transforms TransformStore,
+	relay Relay,
+	preferences *Preferences,
+	comments CommentStore,
+	config BinderConfig,
+	namespace *Namespace,
+	latency *LatencyTracker,
+	metrics *Metrics,
+	audit *Auditor,
+	lifecycle LifecycleHook,
+	bans *Bans,
+	errorChan chan<- BinderError,
+	log *log.Logger,
+	stats *log.Stats,
+) (*Binder, error) {
 
 	binder := Binder{
-		ID:               id,
-		config:           config,
-		model:            CreateTextModel(config.ModelConfig),
-		block:            block,
-		log:              log.NewModule(":binder"),
-		stats:            stats,
-		clients:          make(map[string]BinderClient),
-		subscribeChan:    make(chan BinderSubscribeBundle),
-		transformChan:    make(chan TransformSubmission),
-		messageChan:      make(chan MessageSubmission),
-		usersRequestChan: make(chan usersRequestObj),
-		exitChan:         make(chan string),
-		errorChan:        errorChan,
-		closedChan:       make(chan struct{}),
+		ID:                  id,
+		Epoch:               util.GenerateStampedUUID(),
+		config:              config,
+		model:               CreateTextModel(config.ModelConfig),
+		block:               block,
+		transforms:          transforms,
+		preferences:         preferences,
+		comments:            comments,
+		log:                 log.NewModule(":binder"),
+		stats:               stats,
+		namespace:           namespace,
+		latency:             latency,
+		metrics:             metrics,
+		audit:               audit,
+		lifecycleHook:       lifecycle,
+		clients:             make(map[string]BinderClient),
+		subscribeChan:       make(chan BinderSubscribeBundle),
+		transformChan:       make(chan TransformSubmission),
+		messageChan:         make(chan MessageSubmission),
+		usersRequestChan:    make(chan usersRequestObj),
+		versionRequestChan:  make(chan versionRequestObj),
+		snapshotRequestChan: make(chan snapshotRequestObj),
+		inspectRequestChan:  make(chan inspectRequestObj),
+		exitChan:            make(chan string),
+		kickChan:            make(chan kickRequestObj),
+		drainChan:           make(chan drainRequestObj),
+		drainedChan:         make(chan struct{}),
+		noticeChan:          make(chan noticeRequestObj),
+		storeChangedChan:    make(chan struct{}, 1),
+		formatChan:          make(chan formatResult, 1),
+		releaseChan:         make(chan chan bool),
+		errorChan:           errorChan,
+		bans:                bans,
+		closeChan:           make(chan struct{}),
+		closedChan:          make(chan struct{}),
+		emptySince:          time.Now(),
+	}
+	if binder.bans == nil {
+		binder.bans = NewBans()
 	}
 	binder.log.Debugln("Bound to document, attempting flush")
 
-	if _, err := binder.flush(); err != nil {
+	doc, err := binder.flush()
+	if err != nil {
+		stats.Incr("binder.new.error", 1)
+		return nil, err
+	}
+	// The initial flush had nothing to apply, from here on the model of the document type is used.
+	if binder.model, err = CreateModel(doc.Type, config.ModelConfig); err != nil {
+		stats.Incr("binder.new.error", 1)
+		return nil, err
+	}
+	if binder.validators, err = createValidators(config.Validators, id, doc.Type); err != nil {
 		stats.Incr("binder.new.error", 1)
 		return nil, err
 	}
+	executor, err := NewExecutor(config.CommandSandbox, log, stats)
+	if err != nil {
+		stats.Incr("binder.new.error", 1)
+		return nil, err
+	}
+	if binder.formatter, err = createFormatter(config.Formatters, executor, id, doc.Type); err != nil {
+		stats.Incr("binder.new.error", 1)
+		return nil, err
+	}
+	if relay != nil {
+		if doc, err = binder.joinRelay(relay, doc); err != nil {
+			stats.Incr("binder.new.error", 1)
+			return nil, err
+		}
+	}
+	// Followers never write to the store, the leader of the document has already recovered it.
+	if !binder.relay.following() {
+		if doc, err = binder.recover(doc); err != nil {
+			binder.leaveRelay()
+			stats.Incr("binder.new.error", 1)
+			return nil, err
+		}
+	}
+	if binder.relay != nil && binder.relay.leader {
+		binder.relay.content, binder.relay.docType = doc.Content, doc.Type
+	}
+	binder.size = uint64(len(doc.Content))
+	binder.metadata = doc.Metadata
+	binder.flushedVersion, binder.flushedAt = binder.model.GetVersion(), time.Now()
+	binder.flushedDigest = contentDigest(doc.Content)
+	binder.trackStore(doc.Content)
+	binder.history, err = newBinderHistory(
+		doc.Type, config.HistoryLength, config.HistoryPeriod, doc.Content, binder.model.GetVersion(),
+	)
+	if err != nil {
+		binder.leaveRelay()
+		stats.Incr("binder.new.error", 1)
+		return nil, err
+	}
+	if len(config.Recorder.Directory) > 0 {
+		binder.startRecording(doc)
+	}
+	binder.loadComments()
+	binder.startCommentWriter()
+	metrics.binderOpened(&binder)
 	go binder.loop()
 
 	stats.Incr("binder.new.success", 1)
@@ -139,23 +430,65 @@ func NewBinder(
 
 /*
 ClientMessage - A struct containing various updates to a clients' state and an optional message to
-be distributed out to all other clients of a binder.
+be distributed out to all other clients of a binder. UserID is the public identity of the client a
+message concerns, which the binder sets on the messages of its clients so that their tokens are
+never seen by other clients. Presence is set to 'join' or 'leave' when the
+message announces a client arriving or departing, and Metadata optionally describes the client (such
+as a display name or colour). Spectators carries the number of read only clients of the document
+when the binder counts them, and is sent by read replicas to report their number of viewers.
+Diagnostics lists the validation problems of the document when a flush fails validation. Shutdown is
+set on the notice sent to all clients when the binder begins draining ahead of being closed.
+Degraded is set on the warning sent to all clients when the binder enters or leaves degraded mode.
+Kicked is set on the notice sent to a client that is being removed from the binder by a moderator.
+Digest summarises the activity held back from a client that prefers digests of activity. Signal
+carries a PeerSignal between two clients, and is only sent to the client it is addressed to.
+DocumentMetadata changes the metadata of the document, where an empty value removes a key, and is
+sent out to clients with the full metadata of the document after the change. Comment carries a chat
+message or anchored comment, which travels alongside rather than through the transform stream.
+Banner carries the server wide banner to show to every client, which is empty once it is removed.
+Announcement carries a one-off message from the operators of the server to every client.
 */
 type ClientMessage struct {
-	Message  string `json:"message,omitempty"`
-	Position *int64 `json:"position,omitempty"`
-	Active   bool   `json:"active"`
-	Token    string `json:"user_id"`
+	Message     string            `json:"message,omitempty"`
+	Position    *int64            `json:"position,omitempty"`
+	Active      bool              `json:"active"`
+	UserID      string            `json:"user_id"`
+	Presence    string            `json:"presence,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Spectators  *int              `json:"spectators,omitempty"`
+	Diagnostics []string          `json:"diagnostics,omitempty"`
+	Shutdown    bool              `json:"shutdown,omitempty"`
+	Degraded    *bool             `json:"degraded,omitempty"`
+	Kicked      bool              `json:"kicked,omitempty"`
+	Digest      *ActivityDigest   `json:"digest,omitempty"`
+	Signal      *PeerSignal       `json:"signal,omitempty"`
+	Banner      *string           `json:"banner,omitempty"`
+
+	DocumentMetadata map[string]string `json:"document_metadata,omitempty"`
+	Comment          *Comment          `json:"comment,omitempty"`
+	Announcement     *Announcement     `json:"announcement,omitempty"`
 }
 
 /*
 BinderClient - A struct containing information about a connected client and channels used by the
-binder to push transforms and user updates out.
+binder to push transforms and user updates out. The token of a client is its credential and is kept
+from other clients, who know the client by its UserID instead.
 */
 type BinderClient struct {
 	Token         string
+	UserID        string
+	ReadOnly      bool
+	Position      *int64
+	Metadata      map[string]string
 	TransformChan chan<- OTransform
+	BatchChan     chan<- []OTransform
 	MessageChan   chan<- ClientMessage
+
+	limiter    *portalLimiter
+	throttle   *portalThrottle
+	spectators int
+	digest     *ActivityDigest
+	user       string
 }
 
 /*
@@ -165,23 +498,45 @@ our error message and our ID over an error channel. A BinderError with the Err s
 used as a graceful shutdown request.
 */
 type BinderError struct {
-	ID  string
-	Err error
+	ID         string
+	Err        error
+	Eviction   *EvictionEvent
+	Diagnostic *DiagnosticEvent
 }
 
 /*--------------------------------------------------------------------------------------------------
  */
 
 type usersRequestObj struct {
+	tokens       bool
 	responseChan chan<- []string
 }
 
 /*
-GetUsers - Get a list of user id's connected to this binder.
+GetUsers - Get a list of the user IDs of the clients connected to this binder.
 */
 func (b *Binder) GetUsers(timeout time.Duration) ([]string, error) {
+	return b.getUsers(false, timeout)
+}
+
+/*
+getTokens - Get a list of the tokens of the clients connected to this binder, which are credentials
+and so are never handed out beyond the curator.
+*/
+func (b *Binder) getTokens(timeout time.Duration) ([]string, error) {
+	return b.getUsers(true, timeout)
+}
+
+/*
+getUsers - Get a list of either the user IDs or the tokens of the clients connected to this binder.
+*/
+func (b *Binder) getUsers(tokens bool, timeout time.Duration) ([]string, error) {
 	resChan := make(chan []string)
-	b.usersRequestChan <- usersRequestObj{resChan}
+	select {
+	case b.usersRequestChan <- usersRequestObj{tokens: tokens, responseChan: resChan}:
+	case <-time.After(timeout):
+		return []string{}, ErrTimeout
+	}
 
 	select {
 	case result := <-resChan:
@@ -192,16 +547,11 @@ func (b *Binder) GetUsers(timeout time.Duration) ([]string, error) {
 }
 
 /*
-KickUser - Signals the binder to remove a particular user. Currently doesn't confirm removal, this
-ought to be a blocking call until the removal is validated.
+KickUser - Remove a particular user, blocking until the removal is confirmed. Users are identified by
+their user IDs or the tokens of their portals, and so this is equivalent to Kick.
 */
 func (b *Binder) KickUser(userID string, timeout time.Duration) error {
-	select {
-	case b.exitChan <- userID:
-	case <-time.After(timeout):
-		return ErrTimeout
-	}
-	return nil
+	return b.Kick(userID, timeout)
 }
 
 /*
@@ -212,14 +562,36 @@ func (b *Binder) Subscribe(token string) BinderPortal {
 	if len(token) == 0 {
 		token = util.GenerateStampedUUID()
 	}
-	retChan := make(chan BinderPortal, 1)
-	bundle := BinderSubscribeBundle{
-		PortalRcvChan: retChan,
-		Token:         token,
+	return b.subscribe(BinderSubscribeBundle{Token: token})
+}
+
+/*
+SubscribeFrom - Returns a BinderPortal for a client that reconnects already holding the document at
+a particular version of an epoch. When the epoch is that of this binder and the transforms since
+that version are still held in the history of the binder they are returned in the Missed field of
+the portal, otherwise the client must make do with the full document as with Subscribe.
+*/
+func (b *Binder) SubscribeFrom(token, epoch string, version int) BinderPortal {
+	if len(token) == 0 {
+		token = util.GenerateStampedUUID()
 	}
-	b.subscribeChan <- bundle
+	return b.subscribe(BinderSubscribeBundle{
+		Token:       token,
+		ResumeEpoch: epoch,
+		Resume:      version,
+	})
+}
 
-	return <-retChan
+/*
+SubscribeWith - Returns a BinderPortal for a subscription described by a bundle, which allows any
+combination of the options of the other subscribe calls. The portal channel of the bundle is
+provided by the binder.
+*/
+func (b *Binder) SubscribeWith(bundle BinderSubscribeBundle) BinderPortal {
+	if len(bundle.Token) == 0 {
+		bundle.Token = util.GenerateStampedUUID()
+	}
+	return b.subscribe(bundle)
 }
 
 /*
@@ -231,28 +603,65 @@ func (b *Binder) SubscribeReadOnly(token string) BinderPortal {
 	if len(token) == 0 {
 		token = util.GenerateStampedUUID()
 	}
+	return b.subscribe(BinderSubscribeBundle{Token: token, ReadOnly: true})
+}
+
+/*
+subscribe - Hand a subscription to the binder loop and wait for its portal. Binders that close
+before the subscription is processed return a portal with ErrBinderClosed, after which the document
+may be bound again.
+*/
+func (b *Binder) subscribe(bundle BinderSubscribeBundle) BinderPortal {
 	retChan := make(chan BinderPortal, 1)
-	bundle := BinderSubscribeBundle{
-		PortalRcvChan: retChan,
-		Token:         token,
+	bundle.PortalRcvChan = retChan
+	select {
+	case b.subscribeChan <- bundle:
+	case <-b.closeChan:
+		return BinderPortal{Token: bundle.Token, Error: ErrBinderClosed}
+	case <-b.closedChan:
+		return BinderPortal{Token: bundle.Token, Error: ErrBinderClosed}
+	}
+	select {
+	case portal := <-retChan:
+		return portal
+	case <-b.closedChan:
+		return BinderPortal{Token: bundle.Token, Error: ErrBinderClosed}
 	}
-	b.subscribeChan <- bundle
-
-	portal := <-retChan
-	portal.TransformSndChan = nil
-
-	return portal
 }
 
 /*
 Close - Close the binder, before closing the client channels the binder will flush changes and
-store the document.
+store the document. Subscriptions that have not yet been processed fail with ErrBinderClosed. This
+is safe to call more than once.
 */
 func (b *Binder) Close() {
-	close(b.subscribeChan)
+	b.closeOnce.Do(func() {
+		close(b.closeChan)
+	})
 	<-b.closedChan
 }
 
+/*
+releaseIdle - Shut the binder down if it still has no clients, which lets its curator evict it
+without dropping clients that subscribed after the eviction was requested. Returns false, leaving
+the binder open, if it has clients or the binder loop is not free before the timeout.
+*/
+func (b *Binder) releaseIdle(timeout time.Duration) bool {
+	resultChan := make(chan bool, 1)
+	select {
+	case b.releaseChan <- resultChan:
+	case <-b.closedChan:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+	if released := <-resultChan; !released {
+		return false
+	}
+	<-b.closedChan
+	return true
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
 
@@ -262,39 +671,146 @@ involves flushing the model in order to obtain a clean version of the document,
 we return false to flag the binder loop that we should shut down.
 */
 func (b *Binder) processSubscriber(request BinderSubscribeBundle) error {
-	if _, ok := b.clients[request.Token]; ok {
+	if !request.Admin && b.bannedClient(request.Token, request.UserID) {
+		b.stats.Incr("binder.rejected_client", 1)
+		b.log.Infof("Rejected banned client: %v\n", request.Token)
+		b.audit.record(AuditEvent{
+			Event:      AuditAuthDenied,
+			DocumentID: b.ID,
+			TokenHash:  auditTokenHash(request.Token),
+			Action:     "join",
+			Reason:     "banned",
+		})
+		// The portal channel of a subscription is buffered.
+		request.PortalRcvChan <- BinderPortal{Token: request.Token, Error: ErrClientBanned}
+		return nil
+	}
+	if b.draining {
+		b.stats.Incr("binder.rejected_client", 1)
+		request.PortalRcvChan <- BinderPortal{Token: request.Token, Error: ErrBinderDraining}
+		return nil
+	}
+	if request.Throttle > 0 && !request.ReadOnly {
 		b.stats.Incr("binder.rejected_client", 1)
-		b.log.Warnf("Rejected client due to duplicate token: %v\n", request.Token)
-		return ErrDuplicateClientToken
+		request.PortalRcvChan <- BinderPortal{Token: request.Token, Error: ErrThrottleNotSupported}
+		return nil
+	}
+
+	// Clients are told apart by their portals, as many clients may share the token of a user
+	clientID := util.GenerateStampedUUID()
+
+	// Clients that cannot be identified as a user are known to others by their portal instead
+	userID := request.UserID
+	if len(userID) == 0 {
+		userID = clientID
 	}
 
 	transformSndChan := make(chan OTransform, 1)
+	batchSndChan := make(chan []OTransform, 1)
 	messageSndChan := make(chan ClientMessage, 1)
 
+	// The document we send already includes queued transforms, so they go out to everyone else first.
+	b.deliverBroadcasts()
+
 	// We need to read the full document here anyway, so might as well flush.
 	doc, err := b.flush()
 	if err != nil {
 		return err
 	}
-	select {
-	case request.PortalRcvChan <- BinderPortal{
+
+	// Let the new client know who else is here and where they currently are
+	cursors, present := []ClientMessage{}, []ClientMessage{}
+	for _, c := range b.clients {
+		if c.ReadOnly && b.config.SpectatorPeriod > 0 {
+			continue
+		}
+		present = append(present, ClientMessage{
+			Active:   true,
+			UserID:   c.UserID,
+			Presence: "join",
+			Metadata: c.Metadata,
+		})
+		if c.Position != nil {
+			cursors = append(cursors, ClientMessage{
+				Position: c.Position,
+				Active:   true,
+				UserID:   c.UserID,
+			})
+		}
+	}
+
+	portal := BinderPortal{
 		Token:            request.Token,
+		ClientID:         clientID,
+		UserID:           userID,
 		Version:          b.model.GetVersion(),
+		Epoch:            b.Epoch,
+		Degraded:         b.health.degraded,
 		Document:         doc,
+		Cursors:          cursors,
+		Present:          present,
+		Spectators:       b.spectators,
+		Comments:         append([]Comment{}, b.heldComments...),
 		Error:            nil,
 		TransformRcvChan: transformSndChan,
+		BatchRcvChan:     batchSndChan,
 		MessageRcvChan:   messageSndChan,
 		TransformSndChan: b.transformChan,
 		MessageSndChan:   b.messageChan,
 		ExitChan:         b.exitChan,
-	}:
+	}
+	if request.ReadOnly {
+		portal.TransformSndChan = nil
+	}
+	if request.Resume > 0 {
+		missed, err := b.history.since(request.Resume)
+		if request.ResumeEpoch != b.Epoch {
+			err = ErrEpochMismatch
+		}
+		if err == nil {
+			b.stats.Incr("binder.resume.success", 1)
+			portal.ResumedFrom, portal.Missed = request.Resume, missed
+		} else {
+			b.stats.Incr("binder.resume.fallback", 1)
+			b.log.Debugf("Client %v could not resume from version %v: %v\n", request.Token, request.Resume, err)
+		}
+	}
+
+	limiter := newPortalLimiter(b.config.RateLimit, b.config.ModelConfig.MaxTransformLength)
+	if b.config.RateLimit.Action == "throttle" {
+		portal.limiter = limiter
+	}
+
+	select {
+	case request.PortalRcvChan <- portal:
 		b.stats.Incr("binder.subscribed_clients", 1)
 		b.log.Debugf("Subscribed new client %v\n", request.Token)
-		b.clients[request.Token] = BinderClient{
+		client := BinderClient{
 			Token:         request.Token,
+			UserID:        userID,
+			ReadOnly:      request.ReadOnly,
 			TransformChan: transformSndChan,
+			BatchChan:     batchSndChan,
 			MessageChan:   messageSndChan,
+			limiter:       limiter,
+			user:          b.preferences.User(request.Token),
+		}
+		if request.ReadOnly {
+			client.spectators = 1
+		}
+		if request.Throttle > 0 {
+			client.throttle = &portalThrottle{
+				period: request.Throttle,
+				due:    time.Now(),
+			}
 		}
+		b.clients[clientID] = client
+		b.audit.record(AuditEvent{
+			Event:      AuditClientJoined,
+			DocumentID: b.ID,
+			TokenHash:  auditTokenHash(request.Token),
+			ReadOnly:   request.ReadOnly,
+		})
 	case <-time.After(time.Duration(b.config.ClientKickPeriod) * time.Millisecond):
 		/* We're not bothered if you suck, you just don't get enrolled, and this isn't
 		 * considered an error. Deal with it.
@@ -324,8 +840,16 @@ processUsersRequest - Processes a request for the list of connected clients.
 */
 func (b *Binder) processUsersRequest(request usersRequestObj) {
 	var clients []string
-	for k := range b.clients {
-		clients = append(clients, k)
+	seen := map[string]struct{}{}
+	for _, c := range b.clients {
+		user := c.UserID
+		if request.tokens {
+			user = c.Token
+		}
+		if _, ok := seen[user]; !ok {
+			seen[user] = struct{}{}
+			clients = append(clients, user)
+		}
 	}
 	select {
 	case request.responseChan <- clients:
@@ -348,6 +872,65 @@ func (b *Binder) processTransform(request TransformSubmission) {
 	var version int
 
 	b.log.Debugf("Received transform: %q\n", fmt.Sprintf("%v", request.Transform))
+
+	request.Transform.Author = request.author
+
+	client, ok := b.clients[request.ClientID]
+
+	// Only the binder submits on behalf of nobody, clients that have left or were kicked are refused.
+	if !ok && request.author == "" && request.origin == "" {
+		b.stats.Incr("binder.process_job.unknown_client", 1)
+		b.log.Warnf("Rejected transform from unsubscribed client %v\n", request.Token)
+		b.sendClientError(request.ErrorChan, ErrPortalClosed)
+		return
+	}
+
+	// The portal hides our transform channel from read only clients, but it can still be reached.
+	if ok && client.ReadOnly {
+		b.stats.Incr("binder.process_job.read_only", 1)
+		b.log.Warnf("Rejected transform from read only client %v\n", request.Token)
+		b.sendClientError(request.ErrorChan, ErrReadOnlyPortal)
+		return
+	}
+
+	// Throttled portals wait for their limit themselves, everyone else is kicked for exceeding it.
+	var (
+		retry   time.Duration
+		allowed = true
+	)
+	if ok && b.config.RateLimit.Action != "throttle" {
+		retry, allowed = client.limiter.allow(request.Transform)
+	}
+	if !allowed {
+		b.stats.Incr("binder.rate_limit.kicked", 1)
+		b.metrics.clientKicked("rate_limit")
+		b.log.Warnf("Kicking client (%v) for exceeding its rate limit\n", request.Token)
+
+		b.sendClientError(request.ErrorChan, &RateLimitedError{RetryAfter: retry})
+
+		b.removeClient(request.ClientID)
+		b.audit.record(AuditEvent{
+			Event:      AuditClientKicked,
+			DocumentID: b.ID,
+			TokenHash:  auditTokenHash(request.Token),
+			Reason:     "rate_limit",
+		})
+		return
+	}
+
+	if err = b.checkSize(request.Transform); err != nil {
+		b.stats.Incr("binder.size_limit.rejected", 1)
+		b.log.Warnf("Rejected transform from client %v: %v\n", request.Token, err)
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
+
+	// Followers leave the transform to the leader of the document, and apply it once relayed back
+	if b.relay.following() {
+		b.forwardTransform(request)
+		return
+	}
+
 	dispatch, version, err = b.model.PushTransform(request.Transform)
 
 	if err != nil {
@@ -355,6 +938,19 @@ func (b *Binder) processTransform(request TransformSubmission) {
 		b.sendClientError(request.ErrorChan, err)
 		return
 	}
+
+	// Transforms are logged before anything else sees them, those that cannot be logged are refused
+	if b.transforms != nil {
+		if err = b.transforms.Append(b.ID, dispatch); err != nil {
+			b.model.DropTransform()
+			b.stats.Incr("binder.transform_log.error", 1)
+			b.log.Errorf("Failed to log transform: %v\n", err)
+			b.sendClientError(request.ErrorChan, err)
+			return
+		}
+	}
+	b.publishTransform(request, dispatch)
+	b.size = projectSize(b.size, dispatch)
 	select {
 	case request.VersionChan <- version:
 	default:
@@ -362,59 +958,248 @@ func (b *Binder) processTransform(request TransformSubmission) {
 		b.stats.Incr("binder.send_client_version.blocked", 1)
 	}
 	b.stats.Incr("binder.process_job.success", 1)
+	b.metrics.transformApplied()
+	b.dirty = true
+	b.unflushed++
 
+	b.recordTransform(dispatch)
+	b.broadcastTransform(request.ClientID, dispatch)
+
+	if !request.Submitted.IsZero() {
+		latency := time.Since(request.Submitted)
+		b.latency.record(b.ID, latency)
+		b.metrics.transformProcessed(latency)
+		b.stats.Timing("binder.transform_latency", latency.Seconds())
+	}
+}
+
+/*
+recordTransform - Record an applied transform in the history and recording of the document.
+*/
+func (b *Binder) recordTransform(dispatch OTransform) {
+	if err := b.history.record(dispatch); err != nil {
+		b.log.Errorf("Failed to record transform history: %v\n", err)
+	}
+	if b.recorder != nil {
+		if err := b.recorder.Record(dispatch); err != nil {
+			b.stats.Incr("binder.recorder.error", 1)
+			b.log.Errorf("Failed to record transform: %v\n", err)
+		}
+	}
+}
+
+/*
+broadcastTransform - Send an applied transform out to all clients other than the one that submitted
+it, kicking any clients that are unable to receive it in time.
+*/
+func (b *Binder) broadcastTransform(clientID string, dispatch OTransform) {
 	clientKickPeriod := (time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
 
+	recipients := len(b.clients)
+	if _, ok := b.clients[clientID]; ok {
+		recipients--
+	}
+
+	// Broadcasts over the bandwidth of our namespace are held back on the broadcast queue until it
+	// is available again, as are any that follow them so that clients receive transforms in order.
+	delay := b.namespace.broadcastDelay(len(dispatch.Insert) * recipients)
+	batched := b.config.BroadcastWindow > 0 || delay > 0 || len(b.broadcasts) > 0
 	for key, c := range b.clients {
-		// Skip sends for clients with matching tokens
-		if key == request.Token {
+		// Skip sends for the client that submitted the transform
+		if key == clientID {
+			continue
+		}
+		// Throttled clients receive their transforms once they are due
+		if c.throttle != nil {
+			b.queueThrottled(key, c, dispatch)
+			continue
+		}
+		// Batched transforms are sent to everyone once the broadcast window closes
+		if batched {
+			continue
+		}
+		if chaosDropBroadcast() {
+			b.stats.Incr("binder.chaos.dropped_broadcast", 1)
 			continue
 		}
 		select {
 		case c.TransformChan <- dispatch:
 		case <-time.After(clientKickPeriod):
-			/* The client may have stopped listening, or is just being slow.
-			 * Either way, we have a strict policy here of no time wasters.
-			 */
-			b.stats.Decr("binder.subscribed_clients", 1)
-			b.stats.Incr("binder.clients_kicked", 1)
+			b.kickBlockedClient(key, "transform")
+		}
+	}
 
-			b.log.Debugf("Kicking client (%v) for blocked transform send\n", key)
+	if batched {
+		b.queueBroadcast(clientID, dispatch)
+	}
+	if delay > 0 {
+		b.stats.Incr("binder.broadcast.throttled", 1)
+		b.delayBroadcasts(delay)
+	}
+}
 
-			delete(b.clients, key)
-			close(c.TransformChan)
-			close(c.MessageChan)
-		}
+/*
+processClientMessage - Processes a message submitted through the portal of a client, which is sent
+out under the user ID of the client. Messages of portals that are no longer subscribed are dropped.
+*/
+func (b *Binder) processClientMessage(request MessageSubmission) {
+	c, ok := b.clients[request.ClientID]
+	if !ok {
+		b.stats.Incr("binder.process_message.unknown_client", 1)
+		b.log.Debugf("Dropped message from unsubscribed client %v\n", request.Token)
+		return
 	}
+	request.Message.UserID = c.UserID
+	b.processMessage(request)
 }
 
 /*
-processMessage - Sends a clients message out to other clients.
+processMessage - Sends a clients message out to other clients, if the message contains a cursor
+position then it is also stored for the benefit of future subscribers.
 */
 func (b *Binder) processMessage(request MessageSubmission) {
+	// Signals are meant for a single client rather than broadcast
+	if request.Message.Signal != nil {
+		b.processSignal(request)
+		return
+	}
+	if request.Message.DocumentMetadata != nil && !b.processDocumentMetadata(&request) {
+		return
+	}
+	if request.Message.Comment != nil && !b.processComment(&request) {
+		return
+	}
+
+	// Spectators are counted rather than announced, and only the binder sends out their number
+	if c, ok := b.clients[request.ClientID]; ok {
+		if request.Message.Spectators != nil {
+			if c.ReadOnly {
+				c.spectators = *request.Message.Spectators
+				b.clients[request.ClientID] = c
+			}
+			return
+		}
+		if c.ReadOnly && len(request.Message.Presence) > 0 && b.config.SpectatorPeriod > 0 {
+			return
+		}
+	}
+
+	if c, ok := b.clients[request.ClientID]; ok {
+		if request.Message.Position != nil {
+			position := *request.Message.Position
+			c.Position = &position
+		}
+		if request.Message.Metadata != nil {
+			c.Metadata = request.Message.Metadata
+		}
+		b.clients[request.ClientID] = c
+	}
+
+	b.publishMessage(request)
+
+	// Cursor positions may refer to queued transforms, so those are sent out first
+	b.deliverBroadcasts()
+
 	clientKickPeriod := (time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
 
 	for key, c := range b.clients {
-		// Skip sends for clients with matching tokens
-		if key == request.Token {
+		// Skip sends for the client that sent the message
+		if key == request.ClientID {
+			continue
+		}
+		if chaosDropBroadcast() {
+			b.stats.Incr("binder.chaos.dropped_broadcast", 1)
+			continue
+		}
+		message, held := b.holdActivity(key, request.Message)
+		if held {
 			continue
 		}
 		select {
-		case c.MessageChan <- request.Message:
+		case c.MessageChan <- message:
 		case <-time.After(clientKickPeriod):
-			/* The client may have stopped listening, or is just being slow.
-			 * Either way, we have a strict policy here of no time wasters.
-			 */
-			b.stats.Decr("binder.subscribed_clients", 1)
-			b.stats.Incr("binder.clients_kicked", 1)
+			b.kickBlockedClient(key, "message")
+		}
+	}
+}
+
+/*
+sendSpectators - Send the number of spectators out to all clients if it changed since last sent.
+*/
+func (b *Binder) sendSpectators() {
+	count := 0
+	for _, c := range b.clients {
+		if c.ReadOnly {
+			count += c.spectators
+		}
+	}
+	if count == b.spectators {
+		return
+	}
+	b.spectators = count
+	b.stats.Incr("binder.spectators.sent", 1)
+	b.processMessage(MessageSubmission{Message: ClientMessage{Spectators: &count}})
+}
+
+/*
+startRecording - Open the recording of the document and record its current state, failing to do so
+is logged but does not prevent the document from being edited.
+*/
+func (b *Binder) startRecording(doc store.Document) {
+	recorder, err := NewRecorder(b.config.Recorder, b.ID)
+	if err == nil {
+		if err = recorder.Start(doc, b.Epoch, b.model.GetVersion()); err != nil {
+			recorder.Close()
+		}
+	}
+	if err != nil {
+		b.stats.Incr("binder.recorder.error", 1)
+		b.log.Errorf("Failed to start recording: %v\n", err)
+		return
+	}
+	b.recorder = recorder
+}
 
-			b.log.Debugf("Kicking client (%v) for blocked message send\n", key)
+/*
+trackIdle - Record the moment the binder became empty of clients, for the purpose of eviction.
+*/
+func (b *Binder) trackIdle() {
+	if len(b.clients) > 0 || b.relay.remoteActive() {
+		b.emptySince = time.Time{}
+	} else if b.emptySince.IsZero() {
+		b.emptySince = time.Now()
+	}
+}
 
-			delete(b.clients, key)
-			close(c.TransformChan)
-			close(c.MessageChan)
+/*
+evict - Flush any pending changes and ask the curator to release this binder, returns the time
+remaining until eviction when the binder is not yet due.
+*/
+func (b *Binder) evict() (time.Duration, error) {
+	evictionPeriod := time.Duration(b.config.EvictionPeriod) * time.Second
+	if len(b.clients) > 0 || b.relay.remoteActive() {
+		return evictionPeriod, nil
+	}
+	idle := time.Since(b.emptySince)
+	if idle < evictionPeriod {
+		return evictionPeriod - idle, nil
+	}
+	if b.dirty {
+		if _, err := b.flush(); err != nil {
+			return evictionPeriod, err
 		}
 	}
+
+	b.stats.Incr("binder.evicted", 1)
+	b.log.Infof("Binder idle for %v, requesting eviction\n", idle)
+
+	b.errorChan <- BinderError{ID: b.ID, Eviction: &EvictionEvent{
+		ID:      b.ID,
+		Version: b.model.GetVersion(),
+		Idle:    idle,
+		Evicted: time.Now(),
+	}}
+	return evictionPeriod, nil
 }
 
 /*
@@ -427,14 +1212,39 @@ func (b *Binder) flush() (store.Document, error) {
 		changed            bool
 		doc                store.Document
 	)
+	if b.relay.following() {
+		return b.flushFollower()
+	}
+
+	b.namespace.acquireFlush()
+	defer b.namespace.releaseFlush()
+	started := time.Now()
+
 	doc, errStore = b.block.Read(b.ID)
 	if errStore != nil {
 		b.stats.Incr("binder.block_fetch.error", 1)
 		return doc, errStore
 	}
+	doc.Content = b.mergeStoreChange(doc.Type, doc.Content)
 	changed, errFlush = b.model.FlushTransforms(&doc.Content, b.config.RetentionPeriod)
+	if errFlush == nil && b.relay != nil {
+		b.relay.content, b.relay.docType = doc.Content, doc.Type
+	}
+	// Stores that drop metadata would otherwise erase ours once it has been flushed.
+	if b.metadataDirty {
+		doc.Metadata = b.metadata
+		changed = true
+	} else if store.KeepsMetadata(b.block) {
+		b.metadata = doc.Metadata
+	} else {
+		doc.Metadata = b.metadata
+	}
 	if changed {
-		errStore = b.block.Update(doc)
+		b.validate(doc.Content)
+		b.checkpoint(b.model.GetVersion(), doc.Content)
+		if errStore = b.block.Update(doc); errStore == nil {
+			b.trackStore(doc.Content)
+		}
 	}
 	if errStore != nil || errFlush != nil {
 		b.stats.Incr("binder.flush.error", 1)
@@ -442,10 +1252,255 @@ func (b *Binder) flush() (store.Document, error) {
 	}
 	if changed {
 		b.stats.Incr("binder.flush.success", 1)
+		b.metrics.flushed(time.Since(started))
+		b.observeFlush(time.Since(started))
+		b.audit.record(AuditEvent{
+			Event:      AuditDocumentFlushed,
+			DocumentID: b.ID,
+			Version:    b.model.GetVersion(),
+		})
+		if b.transforms != nil {
+			if err := b.transforms.Clear(b.ID); err != nil {
+				b.stats.Incr("binder.transform_log.error", 1)
+				b.log.Errorf("Failed to clear transform log: %v\n", err)
+			}
+		}
+	}
+	b.dirty = false
+	b.metadataDirty = false
+	b.unflushed = 0
+	b.size = uint64(len(doc.Content))
+	b.flushedVersion = b.model.GetVersion()
+	b.flushedDigest = contentDigest(doc.Content)
+	b.flushedAt = time.Now()
+	if changed {
+		b.lifecycle(LifecycleFlushed)
 	}
 	return doc, nil
 }
 
+/*
+delayFlush - Returns how long to hold back a periodic flush when chaos testing injects flush delays,
+the loop flushes once the delay has passed. Zero is returned for a flush already held back.
+*/
+func (b *Binder) delayFlush() time.Duration {
+	if b.flushDelayed {
+		b.flushDelayed = false
+		return 0
+	}
+	delay := chaosFlushDelay()
+	if delay > 0 {
+		b.stats.Incr("binder.chaos.delayed_flush", 1)
+		b.flushDelayed = true
+	}
+	return delay
+}
+
+/*
+flushDirty - Flush the document if transforms have been pushed since our last flush, and then format
+the flushed content.
+*/
+func (b *Binder) flushDirty() error {
+	/* Idle binders skip the flush entirely, this avoids reading documents back from the
+	 * store (and decompressing them) when there is nothing to apply.
+	 */
+	if !b.dirty {
+		return nil
+	}
+	doc, err := b.flush()
+	if err != nil {
+		return err
+	}
+	if b.formatter != nil && !b.relay.following() {
+		// Followers leave formatting to the leader, which relays the outcome
+		b.format(doc.Content)
+	}
+	return nil
+}
+
+/*
+formatResult - The outcome of formatting the content of a document at a version.
+*/
+type formatResult struct {
+	content   string
+	formatted string
+	version   int
+	err       error
+}
+
+/*
+format - Start formatting the flushed content of the document outside of the loop, the result is
+applied by applyFormat. A flush made whilst the previous is still being formatted is skipped, as its
+changes are formatted along with the next.
+*/
+func (b *Binder) format(content string) {
+	if b.formatting {
+		b.stats.Incr("binder.formatter.skipped", 1)
+		return
+	}
+	b.formatting = true
+
+	formatter, version := b.formatter, b.model.GetVersion()
+	go func() {
+		formatted, err := formatter(content)
+		b.formatChan <- formatResult{content: content, formatted: formatted, version: version, err: err}
+	}()
+}
+
+/*
+applyFormat - Apply any changes made by a formatter as a transform sent out to all clients. The
+transform is made against the version that was formatted, and so edits made in the meantime are
+kept. Content that fails to format is left as it is.
+*/
+func (b *Binder) applyFormat(result formatResult) {
+	b.formatting = false
+	if result.err != nil {
+		b.stats.Incr("binder.formatter.error", 1)
+		b.log.Warnf("Failed to format document: %v\n", result.err)
+		return
+	}
+	if result.formatted == result.content || b.relay.following() {
+		return
+	}
+	b.stats.Incr("binder.formatter.changed", 1)
+
+	ot := formatTransform(result.content, result.formatted)
+	ot.Version = result.version + 1
+
+	// Nobody waits on the outcome, so the channels are buffered to keep processTransform from blocking
+	errChan := make(chan error, 1)
+	b.processTransform(TransformSubmission{
+		Token:       FormatterAuthor,
+		Transform:   ot,
+		VersionChan: make(chan int, 1),
+		ErrorChan:   errChan,
+		author:      FormatterAuthor,
+	})
+	select {
+	case err := <-errChan:
+		b.stats.Incr("binder.formatter.error", 1)
+		b.log.Warnf("Failed to apply formatting: %v\n", err)
+	default:
+	}
+}
+
+/*
+validate - Run the validators of the document against its content. Problems are sent to clients and
+the curator, but never prevent the content from being flushed.
+*/
+func (b *Binder) validate(content string) {
+	if len(b.validators) == 0 {
+		return
+	}
+	problems := runValidators(b.validators, content)
+	if len(problems) == 0 {
+		return
+	}
+	b.stats.Incr("binder.validation.failed", 1)
+	b.log.Warnf("Document failed validation: %v\n", strings.Join(problems, ", "))
+	b.diagnose(problems)
+}
+
+/*
+diagnose - Send problems with the document to clients and the curator.
+*/
+func (b *Binder) diagnose(problems []string) {
+	b.processMessage(MessageSubmission{Message: ClientMessage{Diagnostics: problems}})
+
+	// Diagnostics are not worth blocking for, the curator may be busy shutting us down
+	select {
+	case b.errorChan <- BinderError{ID: b.ID, Diagnostic: &DiagnosticEvent{
+		ID:       b.ID,
+		Version:  b.model.GetVersion(),
+		Problems: problems,
+		Flushed:  time.Now(),
+	}}:
+	default:
+		b.stats.Incr("binder.validation.dropped", 1)
+	}
+}
+
+/*
+checkSize - Returns an error if a transform exceeds the transform size limit, or if applying it
+could push the document past the document size limit.
+*/
+func (b *Binder) checkSize(ot OTransform) error {
+	if b.config.MaxTransformSize > 0 && uint64(len(ot.Insert)+len(ot.Value)) > b.config.MaxTransformSize {
+		return ErrTransformTooLarge
+	}
+	if b.config.MaxDocumentSize > 0 && projectSize(b.size, ot) > b.config.MaxDocumentSize {
+		return ErrDocumentTooLarge
+	}
+	return nil
+}
+
+/*
+projectSize - Returns an upper bound of a document size in bytes after a transform is applied.
+Deletes count characters, each of which is at least one byte, so only a byte is subtracted for each,
+and JSON values are assumed to replace nothing.
+*/
+func projectSize(size uint64, ot OTransform) uint64 {
+	if ot.Delete > 0 {
+		if uint64(ot.Delete) < size {
+			size -= uint64(ot.Delete)
+		} else {
+			size = 0
+		}
+	}
+	return size + uint64(len(ot.Insert)+len(ot.Value))
+}
+
+/*
+recover - Replay any transforms left in the transform log onto a freshly read document, these are
+transforms that were accepted before a previous binder of the document failed to flush them. Those
+that a checkpoint shows were flushed without the log being cleared are skipped.
+*/
+func (b *Binder) recover(doc store.Document) (store.Document, error) {
+	if b.transforms == nil {
+		return doc, nil
+	}
+	pending, err := b.transforms.Read(b.ID)
+	if err != nil || len(pending) == 0 {
+		return doc, err
+	}
+
+	checkpoints, err := b.transforms.ReadCheckpoints(b.ID)
+	if err != nil {
+		return doc, err
+	}
+	if pending = unflushedTransforms(doc.Content, pending, checkpoints); len(pending) == 0 {
+		return doc, b.transforms.Clear(b.ID)
+	}
+
+	b.log.Infof("Recovering %v unflushed transforms for %v\n", len(pending), b.ID)
+	if doc.Content, err = replayTransforms(doc.Type, doc.Content, pending); err != nil {
+		b.stats.Incr("binder.recover.error", 1)
+		return doc, err
+	}
+	b.checkpoint(pending[len(pending)-1].Version, doc.Content)
+	if err = b.block.Update(doc); err != nil {
+		b.stats.Incr("binder.recover.error", 1)
+		return doc, err
+	}
+	b.stats.Incr("binder.recover.success", 1)
+	return doc, b.transforms.Clear(b.ID)
+}
+
+/*
+checkpoint - Log that the transforms up to a version are about to be flushed as content, so that they
+are not replayed again should the log fail to be cleared afterwards.
+*/
+func (b *Binder) checkpoint(version int, content string) {
+	if b.transforms == nil {
+		return
+	}
+	checkpoint := TransformCheckpoint{Version: version, Digest: contentDigest(content)}
+	if err := b.transforms.Checkpoint(b.ID, checkpoint); err != nil {
+		b.stats.Incr("binder.transform_log.error", 1)
+		b.log.Errorf("Failed to log transform checkpoint: %v\n", err)
+	}
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
 
@@ -460,26 +1515,86 @@ following:
 - Intermittently checking for active clients, and shutting down when unused
 */
 func (b *Binder) loop() {
-	flushPeriod := (time.Duration(b.config.FlushPeriod) * time.Millisecond)
 	closePeriod := (time.Duration(b.config.CloseInactivityPeriod) * time.Second)
 
-	flushTimer := time.NewTimer(flushPeriod)
+	flushTimer := time.NewTimer(b.flushPeriod())
 	closeTimer := time.NewTimer(closePeriod)
+
+	var spectatorChan <-chan time.Time
+	if b.config.SpectatorPeriod > 0 {
+		spectatorTicker := time.NewTicker(time.Duration(b.config.SpectatorPeriod) * time.Millisecond)
+		defer spectatorTicker.Stop()
+		spectatorChan = spectatorTicker.C
+	}
+
+	// Binders are only evicted when they have an eviction period
+	var (
+		evictTimer *time.Timer
+		evictChan  <-chan time.Time
+	)
+	if b.config.EvictionPeriod > 0 {
+		evictTimer = time.NewTimer(time.Duration(b.config.EvictionPeriod) * time.Second)
+		defer evictTimer.Stop()
+		evictChan = evictTimer.C
+	}
+
+	// History is only compacted when it has a retention period
+	var compactChan <-chan time.Time
+	if b.config.HistoryPeriod > 0 && b.config.CompactionPeriod > 0 {
+		compactTicker := time.NewTicker(time.Duration(b.config.CompactionPeriod) * time.Second)
+		defer compactTicker.Stop()
+		compactChan = compactTicker.C
+	}
+
+	// Fires whenever a throttled client is due its pending transforms
+	throttleTimer := time.NewTimer(time.Hour)
+	throttleTimer.Stop()
+	defer throttleTimer.Stop()
+
+	// Fires whenever the broadcast window of queued transforms closes
+	broadcastTimer := time.NewTimer(time.Hour)
+	broadcastTimer.Stop()
+	defer broadcastTimer.Stop()
+
+	// Activity held back from clients that prefer digests is sent to them periodically
+	var digestChan <-chan time.Time
+	if period := b.preferences.digestPeriod(); period > 0 {
+		digestTicker := time.NewTicker(time.Duration(period) * time.Second)
+		defer digestTicker.Stop()
+		digestChan = digestTicker.C
+	}
+
+	// Clustered binders receive from their relay and check on the lease of the document
+	var (
+		relayChan <-chan RelayMessage
+		leaseChan <-chan time.Time
+	)
+	if b.relay != nil {
+		leaseTicker := time.NewTicker(b.relay.relay.LeaseTTL() / 3)
+		defer leaseTicker.Stop()
+		relayChan, leaseChan = b.relay.messages, leaseTicker.C
+	}
+
 	for {
 		running := true
 		select {
-		case clientBundle, open := <-b.subscribeChan:
-			if running && open {
-				if err := b.processSubscriber(clientBundle); err != nil {
-					b.errorChan <- BinderError{ID: b.ID, Err: err}
-					b.log.Errorf("Flush error: %v, shutting down\n", err)
-					running = false
-				} else {
-					flushTimer.Reset(flushPeriod)
-					closeTimer.Reset(closePeriod)
-				}
+		case clientBundle := <-b.subscribeChan:
+			if err := b.processSubscriber(clientBundle); err != nil {
+				b.errorChan <- BinderError{ID: b.ID, Err: err}
+				b.log.Errorf("Flush error: %v, shutting down\n", err)
+				running = false
 			} else {
-				b.log.Infoln("Subscribe channel closed, shutting down")
+				flushTimer.Reset(b.flushPeriod())
+				closeTimer.Reset(closePeriod)
+			}
+		case <-b.closeChan:
+			b.log.Infoln("Close requested, shutting down")
+			running = false
+		case resultChan := <-b.releaseChan:
+			idle := len(b.clients) == 0 && !b.relay.remoteActive()
+			resultChan <- idle
+			if idle {
+				b.log.Infoln("Released whilst idle, shutting down")
 				running = false
 			}
 		case tform, open := <-b.transformChan:
@@ -492,7 +1607,7 @@ func (b *Binder) loop() {
 			}
 		case message, open := <-b.messageChan:
 			if running && open {
-				b.processMessage(message)
+				b.processClientMessage(message)
 				closeTimer.Reset(closePeriod)
 			} else {
 				b.log.Infoln("Messages channel closed, shutting down")
@@ -505,35 +1620,119 @@ func (b *Binder) loop() {
 				b.log.Infoln("Users request channel closed, shutting down")
 				running = false
 			}
+		case versionRequest, open := <-b.versionRequestChan:
+			if running && open {
+				b.processVersionRequest(versionRequest)
+			} else {
+				b.log.Infoln("Version request channel closed, shutting down")
+				running = false
+			}
+		case snapshotRequest, open := <-b.snapshotRequestChan:
+			if running && open {
+				b.processSnapshotRequest(snapshotRequest)
+			} else {
+				b.log.Infoln("Snapshot request channel closed, shutting down")
+				running = false
+			}
+		case inspectRequest := <-b.inspectRequestChan:
+			b.processInspectRequest(inspectRequest)
+		case <-throttleTimer.C:
+			b.deliverThrottled(time.Now())
+		case <-broadcastTimer.C:
+			b.deliverBroadcasts()
+		case kickRequest := <-b.kickChan:
+			b.processKickRequest(kickRequest)
+		case drainRequest := <-b.drainChan:
+			b.processDrainRequest(drainRequest)
+		case noticeRequest := <-b.noticeChan:
+			b.processNoticeRequest(noticeRequest)
+		case <-b.storeChangedChan:
+			if _, err := b.flush(); err != nil {
+				b.log.Errorf("Flush error: %v, shutting down\n", err)
+				b.errorChan <- BinderError{ID: b.ID, Err: err}
+				running = false
+			}
+		case msg, open := <-relayChan:
+			if !open {
+				b.log.Errorln("Relay closed, shutting down")
+				b.errorChan <- BinderError{ID: b.ID, Err: ErrRelayClosed}
+				running = false
+			} else if err := b.processRelay(msg); err != nil {
+				b.log.Errorf("Relay error: %v, shutting down\n", err)
+				b.errorChan <- BinderError{ID: b.ID, Err: err}
+				running = false
+			}
+		case <-leaseChan:
+			if err := b.checkLease(); err != nil {
+				b.log.Errorf("Lease error: %v, shutting down\n", err)
+				b.errorChan <- BinderError{ID: b.ID, Err: err}
+				running = false
+			}
+		case result := <-b.formatChan:
+			b.applyFormat(result)
+		case <-compactChan:
+			b.compactHistory()
+		case <-spectatorChan:
+			b.sendSpectators()
+		case <-digestChan:
+			b.sendDigests()
+		case <-evictChan:
+			next, err := b.evict()
+			if err != nil {
+				b.log.Errorf("Flush error: %v, shutting down\n", err)
+				b.errorChan <- BinderError{ID: b.ID, Err: err}
+				running = false
+			}
+			evictTimer.Reset(next)
 		case exitKey, open := <-b.exitChan:
 			if running && open {
 				b.log.Debugf("Received exit request for: %v\n", exitKey)
 				if c, ok := b.clients[exitKey]; ok {
-					b.stats.Decr("binder.subscribed_clients", 1)
-
-					delete(b.clients, exitKey)
-					close(c.TransformChan)
-					close(c.MessageChan)
+					b.removeClient(exitKey)
+					b.audit.record(AuditEvent{
+						Event:      AuditClientLeft,
+						DocumentID: b.ID,
+						TokenHash:  auditTokenHash(c.Token),
+					})
 				}
 			} else {
 				b.log.Infoln("Exit channel closed, shutting down")
 				running = false
 			}
 		case <-flushTimer.C:
-			if _, err := b.flush(); err != nil {
+			if delay := b.delayFlush(); delay > 0 {
+				flushTimer.Reset(delay)
+				break
+			}
+			if err := b.flushDirty(); err != nil {
 				b.log.Errorf("Flush error: %v, shutting down\n", err)
 				b.errorChan <- BinderError{ID: b.ID, Err: err}
 				running = false
 			}
-			flushTimer.Reset(flushPeriod)
+			flushTimer.Reset(b.flushPeriod())
 		case <-closeTimer.C:
-			if 0 == len(b.clients) {
+			if 0 == len(b.clients) && !b.relay.remoteActive() {
 				b.log.Infoln("Binder inactive, requesting shutdown")
 				// Send graceful close request
 				b.errorChan <- BinderError{ID: b.ID, Err: nil}
 			}
 			closeTimer.Reset(closePeriod)
 		}
+		if running && b.flushDue() {
+			b.stats.Incr("binder.flush.version_interval", 1)
+			if err := b.flushDirty(); err != nil {
+				b.log.Errorf("Flush error: %v, shutting down\n", err)
+				b.errorChan <- BinderError{ID: b.ID, Err: err}
+				running = false
+			}
+			flushTimer.Reset(b.flushPeriod())
+		}
+		b.trackIdle()
+		b.trackDrained()
+		b.trackLifecycle()
+		b.scheduleThrottled(throttleTimer)
+		b.scheduleBroadcast(broadcastTimer)
+		b.metrics.setSubscribers(b, len(b.clients))
 		if !running {
 			flushTimer.Stop()
 			closeTimer.Stop()
@@ -546,10 +1745,18 @@ func (b *Binder) loop() {
 				close(client.TransformChan)
 				close(client.MessageChan)
 			}
+			b.trackLifecycle()
 			b.log.Infof("Attempting final flush of %v\n", b.ID)
 			if _, err := b.flush(); err != nil {
+				b.closeErr = err
 				b.errorChan <- BinderError{ID: b.ID, Err: err}
 			}
+			b.leaveRelay()
+			if b.recorder != nil {
+				b.recorder.Close()
+			}
+			b.stopCommentWriter()
+			b.metrics.binderClosed(b)
 			close(b.closedChan)
 			return
 		}
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+queuedBroadcast - A transform waiting for the broadcast window to close, along with the portal ID of
+the client that submitted it, which does not receive it back.
+*/
+type queuedBroadcast struct {
+	clientID  string
+	transform OTransform
+}
+
+/*
+queueBroadcast - Queue a transform to be broadcast once the current window closes, the window opens
+with the first transform queued.
+*/
+func (b *Binder) queueBroadcast(clientID string, ot OTransform) {
+	if len(b.broadcasts) == 0 {
+		b.broadcastDue = time.Now().Add(time.Duration(b.config.BroadcastWindow) * time.Millisecond)
+	}
+	b.broadcasts = append(b.broadcasts, queuedBroadcast{clientID: clientID, transform: ot})
+}
+
+/*
+delayBroadcasts - Hold back the queued transforms until at least a duration from now.
+*/
+func (b *Binder) delayBroadcasts(delay time.Duration) {
+	if due := time.Now().Add(delay); due.After(b.broadcastDue) {
+		b.broadcastDue = due
+	}
+}
+
+/*
+scheduleBroadcast - Set a timer to fire when the queued transforms are due to be broadcast.
+*/
+func (b *Binder) scheduleBroadcast(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	if len(b.broadcasts) > 0 {
+		timer.Reset(time.Until(b.broadcastDue))
+	}
+}
+
+/*
+deliverBroadcasts - Send each client all queued transforms that it did not submit itself as a single
+batch. Throttled clients are skipped as they queue their own transforms. Clients that cannot keep up
+are kicked as they would be for any broadcast.
+*/
+func (b *Binder) deliverBroadcasts() {
+	if len(b.broadcasts) == 0 {
+		return
+	}
+	queued := b.broadcasts
+	b.broadcasts = nil
+
+	all := make([]OTransform, len(queued))
+	for i, q := range queued {
+		all[i] = q.transform
+	}
+
+	clientKickPeriod := time.Duration(b.config.ClientKickPeriod) * time.Millisecond
+
+	for key, c := range b.clients {
+		if c.throttle != nil {
+			continue
+		}
+		// Batches are shared between clients unless they submitted some of the transforms
+		batch := all
+		if submittedBy(queued, key) {
+			batch = make([]OTransform, 0, len(queued))
+			for _, q := range queued {
+				if q.clientID != key {
+					batch = append(batch, q.transform)
+				}
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+		if chaosDropBroadcast() {
+			b.stats.Incr("binder.chaos.dropped_broadcast", 1)
+			continue
+		}
+		select {
+		case c.BatchChan <- batch:
+		case <-time.After(clientKickPeriod):
+			b.kickBlockedClient(key, "transform batch")
+		}
+	}
+	b.stats.Incr("binder.broadcast.batches", 1)
+	b.stats.Incr("binder.broadcast.batched", int64(len(queued)))
+}
+
+/*
+submittedBy - Returns whether any of the queued transforms were submitted by a client.
+*/
+func submittedBy(queued []queuedBroadcast, clientID string) bool {
+	for _, q := range queued {
+		if q.clientID == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
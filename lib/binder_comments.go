@@ -0,0 +1,171 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+const (
+	// maxCommentLength - The longest text of a chat message or comment in bytes.
+	maxCommentLength = 4096
+
+	// maxHeldComments - The number of recent comments a binder holds for new subscribers.
+	maxHeldComments = 200
+
+	// commentQueueSize - The most anchored comments a binder queues whilst earlier ones are written.
+	commentQueueSize = 100
+)
+
+/*
+CommentAnchor - The span of the document that a comment refers to, as it was when the comment was
+made.
+*/
+type CommentAnchor struct {
+	Position int64 `json:"position"`
+	Length   int64 `json:"length,omitempty"`
+}
+
+/*
+Comment - A chat message or comment sent between the clients of a document outside of the
+transform stream. Comments with an Anchor refer to a span of the document, and are persisted by the
+comment store of the binder when it has one, whereas chat messages without one are ephemeral. The
+ID, UserID and Sent (in unix milliseconds) are set by the binder.
+*/
+type Comment struct {
+	ID     string         `json:"id,omitempty"`
+	UserID string         `json:"user_id,omitempty"`
+	Text   string         `json:"text"`
+	Anchor *CommentAnchor `json:"anchor,omitempty"`
+	Sent   int64          `json:"sent,omitempty"`
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+loadComments - Read the persisted comments of the document, keeping the most recent for new
+subscribers. Failing to read them is not fatal to the binder.
+*/
+func (b *Binder) loadComments() {
+	if b.comments == nil {
+		return
+	}
+	comments, err := b.comments.Read(b.ID)
+	if err != nil {
+		b.stats.Incr("binder.comments.read_error", 1)
+		b.log.Errorf("Failed to read comments: %v\n", err)
+		return
+	}
+	b.holdComments(comments...)
+}
+
+/*
+startCommentWriter - Start writing the anchored comments queued by the binder loop to the comment
+store, when the binder has one.
+*/
+func (b *Binder) startCommentWriter() {
+	if b.comments == nil {
+		return
+	}
+	b.commentQueue = make(chan Comment, commentQueueSize)
+	b.commentsDone = make(chan struct{})
+	go func() {
+		defer close(b.commentsDone)
+		for comment := range b.commentQueue {
+			if err := b.comments.Append(b.ID, comment); err != nil {
+				b.stats.Incr("binder.comments.write_error", 1)
+				b.log.Errorf("Failed to persist comment: %v\n", err)
+			}
+		}
+	}()
+}
+
+/*
+stopCommentWriter - Wait for any queued comments to be written to the comment store.
+*/
+func (b *Binder) stopCommentWriter() {
+	if b.commentQueue == nil {
+		return
+	}
+	close(b.commentQueue)
+	<-b.commentsDone
+}
+
+/*
+holdComments - Hold comments for new subscribers, dropping the oldest beyond our limit.
+*/
+func (b *Binder) holdComments(comments ...Comment) {
+	b.heldComments = append(b.heldComments, comments...)
+	if over := len(b.heldComments) - maxHeldComments; over > 0 {
+		b.heldComments = append([]Comment{}, b.heldComments[over:]...)
+	}
+}
+
+/*
+processComment - Stamp the comment of a message from one of our clients, and queue it to be
+persisted when it is anchored. Comments relayed from other nodes are already stamped, and are only
+persisted by the node leading the document. Comments from read only clients are refused. Returns
+false when the comment is refused and should not be sent out.
+*/
+func (b *Binder) processComment(request *MessageSubmission) bool {
+	comment := *request.Message.Comment
+	if !request.relayed {
+		if c, ok := b.clients[request.ClientID]; !ok || c.ReadOnly {
+			b.stats.Incr("binder.comments.refused", 1)
+			return false
+		}
+		if len(comment.Text) == 0 || len(comment.Text) > maxCommentLength {
+			b.stats.Incr("binder.comments.refused", 1)
+			return false
+		}
+		comment.ID = util.GenerateStampedUUID()
+		comment.UserID = request.Message.UserID
+		comment.Sent = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	request.Message.Comment = &comment
+
+	if comment.Anchor == nil {
+		b.stats.Incr("binder.comments.chat", 1)
+		return true
+	}
+	b.stats.Incr("binder.comments.anchored", 1)
+	b.holdComments(comment)
+	if b.commentQueue != nil && !b.relay.following() {
+		select {
+		case b.commentQueue <- comment:
+		default:
+			b.stats.Incr("binder.comments.dropped", 1)
+			b.log.Errorf("Dropped comment %v, too many comments are queued\n", comment.ID)
+		}
+	}
+	return true
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
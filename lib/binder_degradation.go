@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+DegradationConfig - Thresholds for switching a binder into a degraded mode when its store is slow. A
+flush that takes longer than LatencyThreshold milliseconds counts as slow, a zero threshold disables
+degraded mode. After SlowFlushes consecutive slow flushes the binder becomes degraded, where it
+flushes FlushPeriodFactor times less often and warns its clients, until RecoverFlushes consecutive
+flushes are back within the threshold.
+*/
+type DegradationConfig struct {
+	LatencyThreshold  int64 `json:"flush_latency_threshold_ms" yaml:"flush_latency_threshold_ms"`
+	SlowFlushes       int   `json:"slow_flushes" yaml:"slow_flushes"`
+	RecoverFlushes    int   `json:"recover_flushes" yaml:"recover_flushes"`
+	FlushPeriodFactor int64 `json:"flush_period_factor" yaml:"flush_period_factor"`
+}
+
+/*
+NewDegradationConfig - Returns a default DegradationConfig, where degraded mode is disabled.
+*/
+func NewDegradationConfig() DegradationConfig {
+	return DegradationConfig{
+		LatencyThreshold:  0,
+		SlowFlushes:       3,
+		RecoverFlushes:    3,
+		FlushPeriodFactor: 4,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+binderHealth - Tracks the recent flush latency of a binder against its degradation thresholds.
+*/
+type binderHealth struct {
+	degraded bool
+	slow     int
+	fast     int
+}
+
+/*
+observeFlush - Record the duration of a flush that wrote to the store, and enter or leave degraded
+mode when the thresholds are crossed.
+*/
+func (b *Binder) observeFlush(duration time.Duration) {
+	config := b.config.Degradation
+	if config.LatencyThreshold <= 0 {
+		return
+	}
+	if duration > time.Duration(config.LatencyThreshold)*time.Millisecond {
+		b.health.slow, b.health.fast = b.health.slow+1, 0
+		b.stats.Incr("binder.flush.slow", 1)
+	} else {
+		b.health.slow, b.health.fast = 0, b.health.fast+1
+	}
+
+	switch {
+	case !b.health.degraded && b.health.slow >= config.SlowFlushes:
+		b.log.Warnf("Flushes of %v are taking longer than %vms, entering degraded mode\n",
+			b.ID, config.LatencyThreshold)
+		b.setDegraded(true)
+	case b.health.degraded && b.health.fast >= config.RecoverFlushes:
+		b.log.Infof("Flushes of %v have recovered, leaving degraded mode\n", b.ID)
+		b.setDegraded(false)
+	}
+}
+
+/*
+setDegraded - Switch degraded mode on or off and notify clients and metrics of the change.
+*/
+func (b *Binder) setDegraded(degraded bool) {
+	b.health.degraded = degraded
+	if degraded {
+		b.stats.Incr("binder.degraded.enter", 1)
+	} else {
+		b.stats.Incr("binder.degraded.exit", 1)
+	}
+	b.metrics.setDegraded(b, degraded)
+	b.processMessage(MessageSubmission{Message: ClientMessage{Degraded: &degraded}})
+}
+
+/*
+flushPeriod - The current period between flushes, which is extended while degraded.
+*/
+func (b *Binder) flushPeriod() time.Duration {
+	period := time.Duration(b.config.FlushPeriod) * time.Millisecond
+	if b.health.degraded && b.config.Degradation.FlushPeriodFactor > 1 {
+		period *= time.Duration(b.config.Degradation.FlushPeriodFactor)
+	}
+	return period
+}
+
+/*
+flushDue - Whether enough transforms have been applied since the last flush to flush before the
+flush period ends, the number needed is extended while degraded along with the period.
+*/
+func (b *Binder) flushDue() bool {
+	versions := b.config.FlushVersions
+	if versions <= 0 {
+		return false
+	}
+	if b.health.degraded && b.config.Degradation.FlushPeriodFactor > 1 {
+		versions *= int(b.config.Degradation.FlushPeriodFactor)
+	}
+	return b.unflushed >= versions
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,137 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for draining a binder.
+var (
+	ErrBinderDraining = errors.New("document is shutting down")
+)
+
+type drainRequestObj struct {
+	responseChan chan<- struct{}
+}
+
+/*
+Drain - Prepare the binder for closing by refusing any new subscribers and notifying all connected
+clients of the impending shut down with a ClientMessage that has Shutdown set. Clients remain
+connected and may continue to submit transforms until the binder is closed.
+*/
+func (b *Binder) Drain(timeout time.Duration) error {
+	resChan := make(chan struct{}, 1)
+	select {
+	case b.drainChan <- drainRequestObj{responseChan: resChan}:
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+
+	select {
+	case <-resChan:
+		return nil
+	case <-time.After(timeout):
+	}
+	return ErrTimeout
+}
+
+/*
+isClosed - Returns whether the loop of the binder has finished.
+*/
+func (b *Binder) isClosed() bool {
+	select {
+	case <-b.closedChan:
+		return true
+	default:
+	}
+	return false
+}
+
+/*
+awaitDrained - Block until the binder is draining and its last client has left, or until it has
+closed. Returns false if the timeout channel fires first.
+*/
+func (b *Binder) awaitDrained(timeout <-chan time.Time) bool {
+	select {
+	case <-b.drainedChan:
+	case <-b.closedChan:
+	case <-timeout:
+		return false
+	}
+	return true
+}
+
+/*
+trackDrained - Signal anyone awaiting the drain once a draining binder has no clients left. Draining
+binders refuse new subscribers, and so remain empty from then on.
+*/
+func (b *Binder) trackDrained() {
+	if !b.draining || len(b.clients) > 0 {
+		return
+	}
+	select {
+	case <-b.drainedChan:
+	default:
+		close(b.drainedChan)
+	}
+}
+
+/*
+finalFlushError - Returns the error of the final flush of the binder, or nil if it succeeded. Must
+only be called once isClosed returns true.
+*/
+func (b *Binder) finalFlushError() error {
+	return b.closeErr
+}
+
+/*
+processDrainRequest - Flag the binder as draining and send the shut down notice to all clients.
+*/
+func (b *Binder) processDrainRequest(request drainRequestObj) {
+	if !b.draining {
+		b.draining = true
+		b.stats.Incr("binder.draining", 1)
+		b.log.Infoln("Draining, notifying clients of shut down")
+		b.deliverBroadcasts()
+
+		clientKickPeriod := (time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
+		notice := ClientMessage{Shutdown: true}
+		for key, c := range b.clients {
+			select {
+			case c.MessageChan <- notice:
+			case <-time.After(clientKickPeriod):
+				b.kickBlockedClient(key, "message")
+			}
+		}
+	}
+	// The response channel is buffered and only ever written to once.
+	request.responseChan <- struct{}{}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,356 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the binder history.
+var (
+	ErrVersionNotRetained = errors.New("requested version is no longer retained in history")
+	ErrVersionNotExist    = errors.New("requested version does not yet exist")
+)
+
+/*
+binderHistory - The transforms applied to a document since it was bound, along with a compressed
+snapshot of the content of the document prior to the oldest retained transform. The most recent
+limit transforms are retained, older transforms are folded into the snapshot in batches once twice
+the limit have built up, and transforms older than the retention period (in seconds) are folded
+into it whenever the history is compacted. A history without a limit retains nothing.
+*/
+type binderHistory struct {
+	docType     string
+	limit       int
+	period      int64
+	base        []byte
+	baseVersion int
+	transforms  []OTransform
+}
+
+/*
+newBinderHistory - Begin the history of a document from its content at a version.
+*/
+func newBinderHistory(docType string, limit int, period int64, content string, version int) (binderHistory, error) {
+	history := binderHistory{docType: docType, limit: limit, period: period, baseVersion: version}
+	if limit <= 0 {
+		return history, nil
+	}
+	var err error
+	history.base, err = store.CompressContent(content)
+	return history, err
+}
+
+/*
+fold - Fold the oldest n transforms into the base content, the history is left untouched when this
+fails.
+*/
+func (h *binderHistory) fold(n int) error {
+	base, err := store.DecompressContent(h.base)
+	if err != nil {
+		return err
+	}
+	if base, err = replayTransforms(h.docType, base, h.transforms[:n]); err != nil {
+		return err
+	}
+	compressed, err := store.CompressContent(base)
+	if err != nil {
+		return err
+	}
+	h.base = compressed
+	h.baseVersion = h.transforms[n-1].Version
+	h.transforms = h.transforms[n:]
+	return nil
+}
+
+/*
+record - Append a transform to the history, folding all but the most recent limit transforms into
+the base content once twice the limit is reached. A transform that cannot be folded is dropped, so
+that the history ends at the last version it is able to reconstruct.
+*/
+func (h *binderHistory) record(ot OTransform) error {
+	if h.limit <= 0 {
+		return nil
+	}
+	h.transforms = append(h.transforms, ot)
+	if len(h.transforms) < 2*h.limit {
+		return nil
+	}
+	if err := h.fold(len(h.transforms) - h.limit); err != nil {
+		h.transforms = h.transforms[:len(h.transforms)-1]
+		return err
+	}
+	return nil
+}
+
+/*
+compact - Fold all transforms received before the retention period into the base content, returns
+the number of transforms pruned.
+*/
+func (h *binderHistory) compact(now time.Time) (int, error) {
+	if h.period <= 0 {
+		return 0, nil
+	}
+	upto := now.Unix() - h.period
+	expired := 0
+	for expired < len(h.transforms) && h.transforms[expired].TReceived <= upto {
+		expired++
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	return expired, h.fold(expired)
+}
+
+/*
+oldest - The oldest version retained, transforms awaiting the next batch to be folded are already
+beyond the limit and are not counted.
+*/
+func (h *binderHistory) oldest() int {
+	if overflow := len(h.transforms) - h.limit; overflow > 0 {
+		return h.baseVersion + overflow
+	}
+	return h.baseVersion
+}
+
+/*
+contentAt - Reconstruct the content of the document at a particular version by replaying the
+retained transforms on top of the base content.
+*/
+func (h *binderHistory) contentAt(version int) (string, error) {
+	if h.limit <= 0 || version < h.oldest() {
+		return "", ErrVersionNotRetained
+	}
+	// Transforms are recorded in order with contiguous versions.
+	upto := version - h.baseVersion
+	if upto > len(h.transforms) {
+		return "", ErrVersionNotExist
+	}
+	base, err := store.DecompressContent(h.base)
+	if err != nil {
+		return "", err
+	}
+	return replayTransforms(h.docType, base, h.transforms[:upto])
+}
+
+/*
+since - Returns the retained transforms applied after a particular version, in order.
+*/
+func (h *binderHistory) since(version int) ([]OTransform, error) {
+	if h.limit <= 0 || version < h.oldest() {
+		return nil, ErrVersionNotRetained
+	}
+	from := version - h.baseVersion
+	if from > len(h.transforms) {
+		return nil, ErrVersionNotExist
+	}
+	return append([]OTransform{}, h.transforms[from:]...), nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+BinderSnapshot - The full content of a document at a particular version.
+*/
+type BinderSnapshot struct {
+	Document store.Document `json:"document" yaml:"document"`
+	Version  int            `json:"version" yaml:"version"`
+	Taken    time.Time      `json:"taken" yaml:"taken"`
+}
+
+type snapshotResponse struct {
+	snapshot BinderSnapshot
+	err      error
+}
+
+type snapshotRequestObj struct {
+	peek         bool
+	responseChan chan<- snapshotResponse
+}
+
+/*
+Snapshot - Flush the document and return its full content along with the current version. The
+snapshot is also written to the document store as part of the flush.
+*/
+func (b *Binder) Snapshot(timeout time.Duration) (BinderSnapshot, error) {
+	return b.snapshot(false, timeout)
+}
+
+/*
+Peek - Return the full content of the document along with the current version without flushing it,
+the unflushed transforms of the binder are applied to a copy of the content last written to the
+store.
+*/
+func (b *Binder) Peek(timeout time.Duration) (BinderSnapshot, error) {
+	return b.snapshot(true, timeout)
+}
+
+/*
+snapshot - Request a snapshot of the document from the binder, flushing it unless peeking.
+*/
+func (b *Binder) snapshot(peek bool, timeout time.Duration) (BinderSnapshot, error) {
+	resChan := make(chan snapshotResponse, 1)
+	select {
+	case b.snapshotRequestChan <- snapshotRequestObj{peek: peek, responseChan: resChan}:
+	case <-time.After(timeout):
+		return BinderSnapshot{}, ErrTimeout
+	}
+
+	select {
+	case res := <-resChan:
+		return res.snapshot, res.err
+	case <-time.After(timeout):
+	}
+	return BinderSnapshot{}, ErrTimeout
+}
+
+/*
+processSnapshotRequest - Flush or peek at the document and send the result back to the requester.
+*/
+func (b *Binder) processSnapshotRequest(request snapshotRequestObj) {
+	var doc store.Document
+	var err error
+	if request.peek {
+		doc, err = b.peek()
+	} else {
+		doc, err = b.flush()
+	}
+	if err != nil {
+		b.stats.Incr("binder.snapshot.error", 1)
+	} else {
+		b.stats.Incr("binder.snapshot.success", 1)
+	}
+
+	// The response channel is buffered and only ever written to once.
+	request.responseChan <- snapshotResponse{
+		snapshot: BinderSnapshot{Document: doc, Version: b.model.GetVersion(), Taken: time.Now()},
+		err:      err,
+	}
+}
+
+/*
+peek - Apply the unflushed transforms of the model to a copy of the content they were pushed against,
+which is held by the binders of a clustered document and is otherwise the content last written to
+the store.
+*/
+func (b *Binder) peek() (store.Document, error) {
+	var doc store.Document
+	switch {
+	case b.relay != nil:
+		doc = store.Document{ID: b.ID, Type: b.relay.docType, Content: b.relay.content}
+	case b.stored.tracking:
+		doc = store.Document{ID: b.ID, Type: b.history.docType, Content: b.stored.content}
+	default:
+		var err error
+		if doc, err = b.block.Read(b.ID); err != nil {
+			b.stats.Incr("binder.block_fetch.error", 1)
+			return doc, err
+		}
+	}
+	doc.Metadata = b.metadata
+
+	content, err := replayTransforms(doc.Type, doc.Content, b.model.GetUnapplied())
+	if err != nil {
+		return doc, err
+	}
+	doc.Content = content
+	return doc, nil
+}
+
+/*
+compactHistory - Prune the history of transforms beyond the retention period.
+*/
+func (b *Binder) compactHistory() {
+	pruned, err := b.history.compact(time.Now())
+	if err != nil {
+		b.stats.Incr("binder.compaction.error", 1)
+		b.log.Errorf("Failed to compact transform history: %v\n", err)
+		return
+	}
+	if pruned > 0 {
+		b.stats.Incr("binder.compaction.pruned", int64(pruned))
+		b.log.Debugf("Pruned %v transforms from history\n", pruned)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+type versionResponse struct {
+	doc store.Document
+	err error
+}
+
+type versionRequestObj struct {
+	version      int
+	responseChan chan<- versionResponse
+}
+
+/*
+GetVersion - Reconstruct the document as it was at a past version by replaying the transforms
+retained by this binder. Versions only span the lifetime of the binder, and how far back they go
+is determined by the HistoryLength of the binder config.
+*/
+func (b *Binder) GetVersion(version int, timeout time.Duration) (store.Document, error) {
+	resChan := make(chan versionResponse, 1)
+	select {
+	case b.versionRequestChan <- versionRequestObj{version: version, responseChan: resChan}:
+	case <-time.After(timeout):
+		return store.Document{}, ErrTimeout
+	}
+
+	select {
+	case res := <-resChan:
+		return res.doc, res.err
+	case <-time.After(timeout):
+	}
+	return store.Document{}, ErrTimeout
+}
+
+/*
+processVersionRequest - Reconstruct the requested version and send it back to the requester.
+*/
+func (b *Binder) processVersionRequest(request versionRequestObj) {
+	content, err := b.history.contentAt(request.version)
+	if err != nil {
+		b.stats.Incr("binder.get_version.error", 1)
+	} else {
+		b.stats.Incr("binder.get_version.success", 1)
+	}
+
+	// The response channel is buffered and only ever written to once.
+	request.responseChan <- versionResponse{
+		doc: store.Document{ID: b.ID, Content: content, Type: b.history.docType},
+		err: err,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
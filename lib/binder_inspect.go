@@ -0,0 +1,134 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+BinderInfo - A summary of the live state of a binder, intended for operators. MemoryEstimate is a
+rough number of bytes held by the binder: the flushed document, the history retained for past
+versions, held comments and a fixed overhead per transform and client. It does not account for the
+buffers of the Go runtime and should only be compared against other estimates. FlushedVersion and
+LastFlush describe when the binder was last in sync with the store, which is when it bound to the
+document until its first flush. Error is set, and the other fields left empty, for a binder that
+failed to respond in time when listing every binder.
+*/
+type BinderInfo struct {
+	ID             string    `json:"id" yaml:"id"`
+	Epoch          string    `json:"epoch" yaml:"epoch"`
+	Version        int       `json:"version" yaml:"version"`
+	FlushedVersion int       `json:"flushed_version" yaml:"flushed_version"`
+	LastFlush      time.Time `json:"last_flush" yaml:"last_flush"`
+	Unflushed      int       `json:"unflushed" yaml:"unflushed"`
+	Subscribers    int       `json:"subscribers" yaml:"subscribers"`
+	ReadOnly       int       `json:"read_only" yaml:"read_only"`
+	Degraded       bool      `json:"degraded" yaml:"degraded"`
+	Draining       bool      `json:"draining" yaml:"draining"`
+	Size           uint64    `json:"size_bytes" yaml:"size_bytes"`
+	History        int       `json:"history_transforms" yaml:"history_transforms"`
+	MemoryEstimate uint64    `json:"memory_estimate_bytes" yaml:"memory_estimate_bytes"`
+	Error          string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// The approximate cost in bytes of a retained transform and a subscribed client, beyond their content.
+const (
+	transformOverhead = 96
+	clientOverhead    = 512
+)
+
+type inspectRequestObj struct {
+	responseChan chan<- BinderInfo
+}
+
+/*
+Inspect - Returns a summary of the live state of the binder.
+*/
+func (b *Binder) Inspect(timeout time.Duration) (BinderInfo, error) {
+	resChan := make(chan BinderInfo, 1)
+	select {
+	case b.inspectRequestChan <- inspectRequestObj{responseChan: resChan}:
+	case <-time.After(timeout):
+		return BinderInfo{}, ErrTimeout
+	}
+
+	select {
+	case info := <-resChan:
+		return info, nil
+	case <-time.After(timeout):
+	}
+	return BinderInfo{}, ErrTimeout
+}
+
+/*
+processInspectRequest - Summarise the state of the binder and send it back to the requester.
+*/
+func (b *Binder) processInspectRequest(request inspectRequestObj) {
+	info := BinderInfo{
+		ID:             b.ID,
+		Epoch:          b.Epoch,
+		Version:        b.model.GetVersion(),
+		FlushedVersion: b.flushedVersion,
+		LastFlush:      b.flushedAt,
+		Unflushed:      b.unflushed,
+		Subscribers:    len(b.clients),
+		Degraded:       b.health.degraded,
+		Draining:       b.draining,
+		Size:           b.size,
+		History:        len(b.history.transforms),
+	}
+	for _, client := range b.clients {
+		if client.ReadOnly {
+			info.ReadOnly++
+		}
+	}
+	info.MemoryEstimate = b.memoryEstimate()
+
+	b.stats.Incr("binder.inspect.success", 1)
+
+	// The response channel is buffered and only ever written to once.
+	request.responseChan <- info
+}
+
+/*
+memoryEstimate - Approximate the number of bytes held by the binder.
+*/
+func (b *Binder) memoryEstimate() uint64 {
+	estimate := b.size + uint64(len(b.history.base))
+	for _, ot := range b.history.transforms {
+		estimate += uint64(transformOverhead + len(ot.Insert) + len(ot.Value))
+	}
+	for _, comment := range b.heldComments {
+		estimate += uint64(len(comment.Text))
+	}
+	estimate += uint64(transformOverhead * b.unflushed)
+	estimate += uint64(clientOverhead * len(b.clients))
+	return estimate
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+maxDocumentMetadataBytes - The largest combined size of the keys and values in the metadata of a
+document, changes that would grow it beyond this are refused.
+*/
+const maxDocumentMetadataBytes = 16384
+
+/*
+mergeMetadata - Returns a copy of the metadata of a document with changes applied on top, where a
+change with an empty value removes the key. The current map is never modified as it may be shared
+with the store.
+*/
+func mergeMetadata(current, changes map[string]string) map[string]string {
+	merged := make(map[string]string, len(current)+len(changes))
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range changes {
+		if len(v) == 0 {
+			delete(merged, k)
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+/*
+metadataSize - Returns the combined size of the keys and values of a metadata map.
+*/
+func metadataSize(metadata map[string]string) int {
+	size := 0
+	for k, v := range metadata {
+		size += len(k) + len(v)
+	}
+	return size
+}
+
+/*
+processDocumentMetadata - Apply a change to the metadata of the document from a message, which is
+then sent out to all other clients carrying the full metadata of the document. Changes from read
+only clients, and those that would grow the metadata beyond its limit, are refused. Messages relayed
+from other nodes already carry the full metadata, and only the node leading the document marks it
+for flushing to the store. Returns false when the message should not be sent out.
+*/
+func (b *Binder) processDocumentMetadata(request *MessageSubmission) bool {
+	var metadata map[string]string
+	if request.relayed {
+		metadata = mergeMetadata(nil, request.Message.DocumentMetadata)
+	} else {
+		if c, ok := b.clients[request.ClientID]; !ok || c.ReadOnly {
+			b.stats.Incr("binder.metadata.refused", 1)
+			return false
+		}
+		metadata = mergeMetadata(b.metadata, request.Message.DocumentMetadata)
+		if metadataSize(metadata) > maxDocumentMetadataBytes {
+			b.stats.Incr("binder.metadata.refused", 1)
+			b.log.Warnf("Refused metadata change of client %v: too large\n", request.Token)
+			return false
+		}
+	}
+	b.metadata = metadata
+	if !b.relay.following() {
+		b.metadataDirty = true
+		b.dirty = true
+	}
+
+	// Clients receive a copy so that later changes are not shared with them
+	request.Message.DocumentMetadata = mergeMetadata(nil, metadata)
+	b.stats.Incr("binder.metadata.changed", 1)
+	return true
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
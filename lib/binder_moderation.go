@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for moderating the clients of a binder.
+var (
+	ErrClientNotFound = errors.New("client was not found")
+	ErrClientBanned   = errors.New("client is banned from this document")
+)
+
+type kickRequestObj struct {
+	token        string
+	reason       string
+	responseChan chan<- error
+}
+
+/*
+Kick - Forcibly disconnect every client subscribed with a token or of a user ID, blocking until the
+clients have been removed. Returns ErrClientNotFound if no such client is subscribed. The clients are
+free to subscribe again, use Ban to prevent that.
+*/
+func (b *Binder) Kick(token string, timeout time.Duration) error {
+	return b.kick(token, "admin", timeout)
+}
+
+/*
+kick - Disconnect every client subscribed with a token, recording the reason in the audit log.
+*/
+func (b *Binder) kick(token, reason string, timeout time.Duration) error {
+	resChan := make(chan error, 1)
+	select {
+	case b.kickChan <- kickRequestObj{token: token, reason: reason, responseChan: resChan}:
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+
+	select {
+	case err := <-resChan:
+		return err
+	case <-time.After(timeout):
+	}
+	return ErrTimeout
+}
+
+/*
+Ban - Disconnect a user and refuse their subscriptions for a duration, a duration of zero or less
+lifts an existing ban instead. Users are identified by their user IDs or the tokens of their
+portals, and the user is kicked if currently subscribed, although it is not an error for them to be
+absent.
+*/
+func (b *Binder) Ban(userID string, duration, timeout time.Duration) error {
+	b.bans.Ban(b.ID, userID, duration)
+	if duration <= 0 {
+		return nil
+	}
+	b.stats.Incr("binder.banned_users", 1)
+	if err := b.kick(userID, "banned", timeout); err != nil && err != ErrClientNotFound {
+		return err
+	}
+	return nil
+}
+
+/*
+Banned - Returns whether a user is currently banned from subscribing to this binder.
+*/
+func (b *Binder) Banned(userID string) bool {
+	return b.bans.Banned(b.ID, userID)
+}
+
+/*
+bannedClient - Returns whether a client is banned by either its token or its user ID.
+*/
+func (b *Binder) bannedClient(token, userID string) bool {
+	return b.Banned(token) || (len(userID) > 0 && b.Banned(userID))
+}
+
+/*
+processKickRequest - Remove the clients of the token or user ID of a kick request and close their
+channels.
+*/
+func (b *Binder) processKickRequest(request kickRequestObj) {
+	kicked := false
+	for key, client := range b.clients {
+		if client.Token != request.token && client.UserID != request.token {
+			continue
+		}
+		kicked = true
+
+		b.stats.Incr("binder.moderation.kicked", 1)
+		b.metrics.clientKicked(request.reason)
+		b.log.Infof("Kicking client (%v) on request\n", client.UserID)
+
+		// The notice is best effort, a client with a full message buffer is simply disconnected.
+		select {
+		case client.MessageChan <- ClientMessage{UserID: client.UserID, Kicked: true}:
+		default:
+		}
+
+		b.removeClient(key)
+		b.audit.record(AuditEvent{
+			Event:      AuditClientKicked,
+			DocumentID: b.ID,
+			TokenHash:  auditTokenHash(client.Token),
+			Reason:     request.reason,
+		})
+	}
+
+	// The response channel is buffered and only ever written to once.
+	if !kicked {
+		request.responseChan <- ErrClientNotFound
+		return
+	}
+	request.responseChan <- nil
+}
+
+/*
+removeClient - Remove a client by the ID of its portal and close its channels.
+*/
+func (b *Binder) removeClient(key string) {
+	if c, ok := b.clients[key]; ok {
+		b.stats.Decr("binder.subscribed_clients", 1)
+		delete(b.clients, key)
+		close(c.TransformChan)
+		close(c.MessageChan)
+	}
+}
+
+/*
+kickBlockedClient - Remove a client that failed to receive a send within the client kick period, the
+client may have stopped listening or is just being slow. Either way, we have a strict policy here of
+no time wasters.
+*/
+func (b *Binder) kickBlockedClient(key, send string) {
+	b.stats.Incr("binder.clients_kicked", 1)
+	b.metrics.clientKicked("blocked")
+	b.log.Debugf("Kicking client (%v) for blocked %v send\n", key, send)
+	b.audit.record(AuditEvent{
+		Event:      AuditClientKicked,
+		DocumentID: b.ID,
+		TokenHash:  auditTokenHash(b.clients[key].Token),
+		Reason:     "blocked",
+	})
+	b.removeClient(key)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+type noticeRequestObj struct {
+	notice       ClientMessage
+	responseChan chan<- struct{}
+}
+
+/*
+Notify - Send a notice from the server, such as a banner, to every client of the binder. Clients that
+are blocked for longer than the client kick period are kicked, as with any other message.
+*/
+func (b *Binder) Notify(notice ClientMessage, timeout time.Duration) error {
+	resChan := make(chan struct{}, 1)
+	select {
+	case b.noticeChan <- noticeRequestObj{notice: notice, responseChan: resChan}:
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+
+	select {
+	case <-resChan:
+		return nil
+	case <-time.After(timeout):
+	}
+	return ErrTimeout
+}
+
+/*
+processNoticeRequest - Send a notice to all clients.
+*/
+func (b *Binder) processNoticeRequest(request noticeRequestObj) {
+	clientKickPeriod := (time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
+	for key, c := range b.clients {
+		select {
+		case c.MessageChan <- request.notice:
+		case <-time.After(clientKickPeriod):
+			b.kickBlockedClient(key, "message")
+		}
+	}
+	b.stats.Incr("binder.notices", 1)
+
+	// The response channel is buffered and only ever written to once.
+	request.responseChan <- struct{}{}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+PeerSignal - A signalling message brokered by the binder between two clients of a document, which
+clients use to set up WebRTC data channels between each other. Data is opaque to the binder, and
+carries the offers, answers and ICE candidates of the clients. To is the user ID of the receiving
+client and From is set by the binder to that of the sending client, and so clients address each
+other without ever learning their tokens.
+*/
+type PeerSignal struct {
+	From string          `json:"from,omitempty"`
+	To   string          `json:"to,omitempty"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+/*
+processSignal - Deliver a signal to the clients of the user ID it is addressed to. Signals addressed
+to users we do not hold are relayed to the binders of other nodes, where the client may be
+connected. Signals are best effort, and are dropped rather than blocking on slow clients.
+*/
+func (b *Binder) processSignal(request MessageSubmission) {
+	signal := *request.Message.Signal
+	signal.From = request.Message.UserID
+
+	if signal.To == signal.From {
+		b.stats.Incr("binder.peer_signal.unknown_peer", 1)
+		return
+	}
+	delivered := false
+	for _, c := range b.clients {
+		if c.UserID != signal.To {
+			continue
+		}
+		delivered = true
+		select {
+		case c.MessageChan <- ClientMessage{UserID: signal.From, Signal: &signal}:
+			b.stats.Incr("binder.peer_signal.delivered", 1)
+		default:
+			b.stats.Incr("binder.peer_signal.dropped", 1)
+		}
+	}
+	if !delivered {
+		b.publishMessage(request)
+		b.stats.Incr("binder.peer_signal.unknown_peer", 1)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -34,32 +34,57 @@ import (
 
 /*
 TransformSubmission - A struct used to submit a transform to a binder. The submission must contain
-the token of the client, as well as two channels for returning either the corrected version of the
-transform if successful, or an error if the submit was unsuccessful.
+the token of the client and the ID of its portal, as well as two channels for returning either the
+corrected version of the transform if successful, or an error if the submit was unsuccessful.
+Submitted is the time the transform was sent, from which the binder measures its latency when set.
 */
 type TransformSubmission struct {
 	Token       string
+	ClientID    string
 	Transform   OTransform
+	Submitted   time.Time
 	VersionChan chan<- int
 	ErrorChan   chan<- error
+
+	// The author of transforms submitted by the binder itself, clients cannot set it
+	author string
+
+	// The node and sequence number of transforms forwarded by a follower of a clustered document
+	origin string
+	seq    int
 }
 
 /*
 MessageSubmission - A struct used to submit a message to a binder. The submission must contain the
-token of the client in order to avoid the message being sent back to the same client.
+token of the client, and the ID of its portal in order to avoid the message being sent back to the
+same client.
 */
 type MessageSubmission struct {
-	Token   string
-	Message ClientMessage
+	Token    string
+	ClientID string
+	Message  ClientMessage
+
+	// Set on messages received from other nodes, which are not relayed again
+	relayed bool
 }
 
 /*
 BinderSubscribeBundle - A container that holds all data necessary to provide a binder that you
-wish to subscribe to. Contains a user token for identifying the client and a channel for
-receiving the resultant BinderPortal.
+wish to subscribe to. Contains a user token for authenticating the client, the public ID of the
+user it belongs to when known, a channel for receiving the resultant BinderPortal and whether the
+client should be barred from submitting transforms.
+When Throttle is set the client receives at most one batch of transforms per period, which requires
+it to be read only. When Resume is set the client already holds the document at that version of
+the binding identified by ResumeEpoch. Admin clients are exempt from bans.
 */
 type BinderSubscribeBundle struct {
 	Token         string
+	UserID        string
+	ReadOnly      bool
+	Admin         bool
+	Throttle      time.Duration
+	ResumeEpoch   string
+	Resume        int
 	PortalRcvChan chan<- BinderPortal
 }
 
@@ -77,35 +102,65 @@ var (
 /*
 BinderPortal - A container that holds all data necessary to begin an open portal with the binder,
 allowing fresh transforms to be submitted and returned as they come. Also carries the token of the
-client.
+client, the ID of the portal which tells apart clients that share a token, the user ID that other
+clients know the client by, the other clients present and their last known cursor positions at the
+time of subscribing, as well as the last known number of spectators. When the binder batches its
+broadcasts transforms arrive through BatchRcvChan rather than TransformRcvChan, although closure of
+the portal is only ever signalled by closing TransformRcvChan.
+
+Epoch identifies the binding of the document that Version belongs to. A client that resumed from a
+version still held in the history of the binder has ResumedFrom set to that version, and Missed
+holds the transforms applied since, which bring the client up to Version. Degraded is set when the
+binder is in degraded mode at the time of subscribing. Comments holds the most recent anchored
+comments made on the document.
 */
 type BinderPortal struct {
 	Token            string
+	ClientID         string
+	UserID           string
 	Document         store.Document
 	Version          int
+	Epoch            string
+	Cursors          []ClientMessage
+	Present          []ClientMessage
+	Spectators       int
+	Comments         []Comment
+	Degraded         bool
+	ResumedFrom      int
+	Missed           []OTransform
 	Error            error
 	TransformRcvChan <-chan OTransform
+	BatchRcvChan     <-chan []OTransform
 	MessageRcvChan   <-chan ClientMessage
 	TransformSndChan chan<- TransformSubmission
 	MessageSndChan   chan<- MessageSubmission
 	ExitChan         chan<- string
+
+	limiter *portalLimiter
 }
 
 /*
 SendTransform - Submits a transform to the binder. The binder responds with either an error or a
-corrected version number for the transform. This is safe to call from any goroutine.
+corrected version number for the transform. If the binder throttles its clients then this call
+blocks until the transform is within the rate limit of the portal. This is safe to call from any
+goroutine.
 */
 func (p *BinderPortal) SendTransform(ot OTransform, timeout time.Duration) (int, error) {
 	// Check if we are READ ONLY
 	if nil == p.TransformSndChan {
 		return 0, ErrReadOnlyPortal
 	}
+	if delay := p.limiter.reserve(ot); delay > 0 {
+		time.Sleep(delay)
+	}
 	// Buffered channels because the server skips blocked sends
 	errChan := make(chan error, 1)
 	verChan := make(chan int, 1)
 	p.TransformSndChan <- TransformSubmission{
 		Token:       p.Token,
+		ClientID:    p.ClientID,
 		Transform:   ot,
+		Submitted:   time.Now(),
 		VersionChan: verChan,
 		ErrorChan:   errChan,
 	}
@@ -125,17 +180,52 @@ This is safe to call from any goroutine.
 */
 func (p *BinderPortal) SendMessage(message ClientMessage) {
 	p.MessageSndChan <- MessageSubmission{
-		Token:   p.Token,
-		Message: message,
+		Token:    p.Token,
+		ClientID: p.ClientID,
+		Message:  message,
 	}
 }
 
+/*
+SendCursor - Sends an update of the clients cursor position to the binder, which is subsequently
+sent out to all other clients and remembered for any clients that subscribe later. This is safe to
+call from any goroutine.
+*/
+func (p *BinderPortal) SendCursor(position int64) {
+	p.SendMessage(ClientMessage{
+		Position: &position,
+		Active:   true,
+	})
+}
+
+/*
+SendDocumentMetadata - Sends a change to the metadata of the document to the binder, which persists
+it and sends the full metadata out to all other clients. Keys with an empty value are removed. This
+is safe to call from any goroutine.
+*/
+func (p *BinderPortal) SendDocumentMetadata(metadata map[string]string) {
+	p.SendMessage(ClientMessage{
+		DocumentMetadata: metadata,
+	})
+}
+
+/*
+SendComment - Sends a chat message, or a comment when anchored to a span of the document, to the
+binder, which is subsequently sent out to all other clients. This is safe to call from any
+goroutine.
+*/
+func (p *BinderPortal) SendComment(comment Comment) {
+	p.SendMessage(ClientMessage{
+		Comment: &comment,
+	})
+}
+
 /*
 Exit - Inform the binder that this client is shutting down.
 */
 func (p *BinderPortal) Exit(timeout time.Duration) {
 	select {
-	case p.ExitChan <- p.Token:
+	case p.ExitChan <- p.ClientID:
 	case <-time.After(timeout):
 	}
 }
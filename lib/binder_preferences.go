@@ -0,0 +1,122 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ActivityDigest - A summary of the activity on a document held back from a client since its last
+digest, which lists the users that joined and left and counts the messages sent.
+*/
+type ActivityDigest struct {
+	Joined   []string `json:"joined,omitempty"`
+	Left     []string `json:"left,omitempty"`
+	Messages int      `json:"messages,omitempty"`
+}
+
+/*
+isActivity - Returns whether a message is activity that users may choose not to be notified of.
+*/
+func isActivity(message ClientMessage) bool {
+	return len(message.Presence) > 0 || len(message.Message) > 0
+}
+
+/*
+holdActivity - Returns whether a message should be held back from a client, identified by the ID of
+its portal, according to the notification preferences of its user, adding it to the digest of the
+client when it prefers digests. Cursor positions are not activity, so a held back message carrying
+one is returned without its text.
+*/
+func (b *Binder) holdActivity(key string, message ClientMessage) (ClientMessage, bool) {
+	if !isActivity(message) {
+		return message, false
+	}
+	switch b.preferences.Get(b.clients[key].user, b.ID).Mode {
+	case NotifyMute:
+		b.stats.Incr("binder.notifications.muted", 1)
+	case NotifyDigest:
+		b.stats.Incr("binder.notifications.digested", 1)
+		b.digestActivity(key, message)
+	default:
+		return message, false
+	}
+	if message.Position != nil && len(message.Presence) == 0 {
+		message.Message = ""
+		return message, false
+	}
+	return message, true
+}
+
+/*
+digestActivity - Add a message to the digest of a client.
+*/
+func (b *Binder) digestActivity(key string, message ClientMessage) {
+	c, ok := b.clients[key]
+	if !ok || b.preferences.digestPeriod() == 0 {
+		return
+	}
+	if c.digest == nil {
+		c.digest = &ActivityDigest{}
+		b.clients[key] = c
+	}
+	switch message.Presence {
+	case "join":
+		c.digest.Joined = append(c.digest.Joined, message.UserID)
+	case "leave":
+		c.digest.Left = append(c.digest.Left, message.UserID)
+	default:
+		c.digest.Messages++
+	}
+}
+
+/*
+sendDigests - Send each client its digest of the activity held back since its last, clients with
+nothing held back are skipped.
+*/
+func (b *Binder) sendDigests() {
+	clientKickPeriod := (time.Duration(b.config.ClientKickPeriod) * time.Millisecond)
+
+	for key, c := range b.clients {
+		if c.digest == nil {
+			continue
+		}
+		digest := c.digest
+		c.digest = nil
+		b.clients[key] = c
+
+		select {
+		case c.MessageChan <- ClientMessage{UserID: c.UserID, Digest: digest}:
+			b.stats.Incr("binder.notifications.digest_sent", 1)
+		case <-time.After(clientKickPeriod):
+			b.kickBlockedClient(key, "digest")
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,528 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the relay of a Binder.
+var (
+	ErrRelayClosed      = errors.New("relay of the document was closed")
+	ErrRelayNoLeader    = errors.New("no node was able to lead the document")
+	ErrRelaySyncTimeout = errors.New("timed out waiting for a snapshot from the leader of the document")
+	ErrRelayLeaderLost  = errors.New("leader of the document has changed")
+	ErrRelayLeaseLost   = errors.New("lease over the document was lost")
+	ErrRelayOutOfSync   = errors.New("relayed transform does not follow the current version")
+)
+
+/*
+binderRelay - The state of a binder within a cluster. Only the binder of the node holding the lease
+over the document, the leader, applies transforms and flushes them to the store. Followers forward
+the transforms of their clients to the leader, and apply the transforms the leader relays back in the
+order it applied them. The versions of a follower are offset from those of the leader, as each
+binder begins its versions afresh.
+*/
+type binderRelay struct {
+	relay       Relay
+	leader      bool
+	leaderNode  string
+	messages    <-chan RelayMessage
+	unsubscribe func()
+
+	// When a message was last received from another node
+	remoteSeen time.Time
+
+	// The leader version of the first version of a follower, minus one
+	offset int
+
+	// The content and type of the document as last flushed, which unflushed transforms apply to
+	content string
+	docType string
+
+	// Transforms forwarded to the leader and awaiting its verdict, by sequence number
+	seq     int
+	pending map[int]TransformSubmission
+
+	// Nodes of an incompatible protocol that we have already warned about
+	incompatible map[string]struct{}
+}
+
+/*
+following - Returns whether the binder follows the leader of a clustered document.
+*/
+func (r *binderRelay) following() bool {
+	return r != nil && !r.leader
+}
+
+/*
+remoteActive - Returns whether the leader of a document has heard from another node recently, in
+which case clients are likely connected elsewhere and the binder should remain open.
+*/
+func (r *binderRelay) remoteActive() bool {
+	return r != nil && r.leader && time.Since(r.remoteSeen) < r.relay.LeaseTTL()
+}
+
+/*
+admit - Returns whether we interoperate with the node that sent a message, the relay of a curator
+also records the node as a peer.
+*/
+func (r *binderRelay) admit(msg RelayMessage) bool {
+	if observer, ok := r.relay.(relayObserver); ok {
+		return observer.observe(msg)
+	}
+	return relayCompatible(relayProtocol(msg), true)
+}
+
+/*
+ignoreIncompatible - Count a message from a node that we do not interoperate with, warning about the
+node the first time it is heard from.
+*/
+func (b *Binder) ignoreIncompatible(msg RelayMessage) {
+	b.stats.Incr("binder.relay.incompatible", 1)
+	b.metrics.relayIncompatible(relayProtocol(msg))
+	if _, warned := b.relay.incompatible[msg.Node]; warned {
+		return
+	}
+	b.relay.incompatible[msg.Node] = struct{}{}
+	b.log.Warnf(
+		"Ignoring node %v of document %v, it speaks cluster protocol %v and we speak %v\n",
+		msg.Node, b.ID, relayProtocol(msg), RelayProtocol,
+	)
+}
+
+/*
+publish - Send a message to the binders of the document on other nodes.
+*/
+func (b *Binder) publish(msg RelayMessage) {
+	msg.Node, msg.Protocol = b.relay.relay.Node(), RelayProtocol
+	if err := b.relay.relay.Publish(b.ID, msg); err != nil {
+		b.stats.Incr("binder.relay.publish.error", 1)
+		b.log.Errorf("Failed to relay %v message: %v\n", msg.Kind, err)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+joinRelay - Subscribe to the relay of the document and attempt to lead it. When another node already
+leads the document we follow it instead, which means taking a snapshot of the document from the
+leader in place of the content of the store. Transforms relayed whilst waiting for the snapshot are
+applied on top of it.
+*/
+func (b *Binder) joinRelay(relay Relay, doc store.Document) (store.Document, error) {
+	messages, unsubscribe, err := relay.Subscribe(b.ID)
+	if err != nil {
+		return doc, err
+	}
+	r := &binderRelay{
+		relay:        relay,
+		messages:     messages,
+		unsubscribe:  unsubscribe,
+		pending:      map[int]TransformSubmission{},
+		incompatible: map[string]struct{}{},
+	}
+
+	// The lease may be released between us failing to take it and asking who holds it.
+	for attempt := 0; attempt < 3 && len(r.leaderNode) == 0; attempt++ {
+		if r.leader, err = relay.Lead(b.ID); err == nil && !r.leader {
+			r.leaderNode, err = relay.Leader(b.ID)
+		}
+		if err != nil {
+			unsubscribe()
+			return doc, err
+		}
+		if r.leader {
+			r.leaderNode = relay.Node()
+		}
+	}
+	if len(r.leaderNode) == 0 {
+		unsubscribe()
+		return doc, ErrRelayNoLeader
+	}
+	b.relay = r
+
+	if r.leader {
+		b.stats.Incr("binder.relay.leading", 1)
+		b.log.Infof("Leading document %v\n", b.ID)
+		return doc, nil
+	}
+	if doc, err = b.followLeader(doc); err != nil {
+		b.leaveRelay()
+		return doc, err
+	}
+	b.stats.Incr("binder.relay.following", 1)
+	b.log.Infof("Following document %v, led by node %v\n", b.ID, r.leaderNode)
+	return doc, nil
+}
+
+/*
+followLeader - Request a snapshot of the document from its leader, and bring the model in line with
+it.
+*/
+func (b *Binder) followLeader(doc store.Document) (store.Document, error) {
+	r := b.relay
+	b.publish(RelayMessage{Kind: relaySync, To: r.leaderNode})
+
+	timeout := time.After(r.relay.LeaseTTL())
+	buffered := []OTransform{}
+	for {
+		select {
+		case msg, open := <-r.messages:
+			if !open {
+				return doc, ErrRelayClosed
+			}
+			if msg.Node != r.leaderNode {
+				continue
+			}
+			if !r.admit(msg) {
+				b.ignoreIncompatible(msg)
+				return doc, ErrRelayIncompatible
+			}
+			switch msg.Kind {
+			case relayTransform:
+				if msg.Transform != nil {
+					buffered = append(buffered, *msg.Transform)
+				}
+			case relayClosed:
+				return doc, ErrRelayLeaderLost
+			case relayRefused:
+				if msg.To == r.relay.Node() {
+					return doc, ErrRelayIncompatible
+				}
+			case relaySnapshot:
+				if msg.To != r.relay.Node() {
+					continue
+				}
+				r.content, r.docType = msg.Content, msg.Type
+				b.metadata = msg.Metadata
+				r.offset = msg.Version - b.model.GetVersion()
+				for _, ot := range buffered {
+					if ot.Version <= msg.Version {
+						continue
+					}
+					if ot.Version != r.offset+b.model.GetVersion()+1 {
+						return doc, ErrRelayOutOfSync
+					}
+					ot.Version -= r.offset
+					if _, _, err := b.model.PushTransform(ot); err != nil {
+						return doc, err
+					}
+				}
+				return b.flushFollower()
+			}
+		case <-timeout:
+			return doc, ErrRelaySyncTimeout
+		}
+	}
+}
+
+/*
+leaveRelay - Unsubscribe from the relay of the document, when leading the document the lease is
+released and followers are told to close.
+*/
+func (b *Binder) leaveRelay() {
+	r := b.relay
+	if r == nil {
+		return
+	}
+	if r.leader {
+		b.publish(RelayMessage{Kind: relayClosed})
+		if err := r.relay.Release(b.ID); err != nil {
+			b.stats.Incr("binder.relay.release.error", 1)
+			b.log.Errorf("Failed to release lease: %v\n", err)
+		}
+	}
+	r.unsubscribe()
+}
+
+/*
+checkLease - Renew our lease when leading the document, or make sure the leader still holds its
+lease when following. Followers with clients also let the leader know they are active.
+*/
+func (b *Binder) checkLease() error {
+	r := b.relay
+	if r.leader {
+		renewed, err := r.relay.Renew(b.ID)
+		if err != nil {
+			return err
+		}
+		if !renewed {
+			b.stats.Incr("binder.relay.lease_lost", 1)
+			return ErrRelayLeaseLost
+		}
+		return nil
+	}
+	leader, err := r.relay.Leader(b.ID)
+	if err != nil {
+		return err
+	}
+	if leader != r.leaderNode {
+		b.stats.Incr("binder.relay.leader_lost", 1)
+		return ErrRelayLeaderLost
+	}
+	if len(b.clients) > 0 {
+		b.publish(RelayMessage{Kind: relayAlive})
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+processRelay - Process a message received from the binder of the document on another node, returns
+an error when the binder can no longer stay in sync with the cluster and needs to shut down.
+*/
+func (b *Binder) processRelay(msg RelayMessage) error {
+	r := b.relay
+	if msg.Node == r.relay.Node() || (len(msg.To) > 0 && msg.To != r.relay.Node()) {
+		return nil
+	}
+
+	// Nodes we do not interoperate with are refused a snapshot, and are otherwise ignored
+	if !r.admit(msg) {
+		b.ignoreIncompatible(msg)
+		if r.leader && msg.Kind == relaySync {
+			b.log.Warnf("Refusing node %v of protocol %v\n", msg.Node, relayProtocol(msg))
+			b.publish(RelayMessage{Kind: relayRefused, To: msg.Node})
+		}
+		return nil
+	}
+	r.remoteSeen = time.Now()
+
+	switch msg.Kind {
+	case relayMessage:
+		if msg.Message != nil {
+			b.processMessage(MessageSubmission{Message: *msg.Message, relayed: true})
+		}
+		return nil
+	case relayClosed:
+		if r.leader || msg.Node != r.leaderNode {
+			return nil
+		}
+		return ErrRelayLeaderLost
+	}
+
+	if r.leader {
+		switch msg.Kind {
+		case relaySubmit:
+			b.processSubmit(msg)
+		case relaySync:
+			return b.sendSnapshot(msg.Node)
+		}
+		return nil
+	}
+	if msg.Node != r.leaderNode {
+		return nil
+	}
+	switch msg.Kind {
+	case relayTransform:
+		return b.applyRelayed(msg)
+	case relayError:
+		if request, ok := r.pending[msg.Seq]; ok {
+			delete(r.pending, msg.Seq)
+			b.sendClientError(request.ErrorChan, errors.New(msg.Error))
+		}
+	}
+	return nil
+}
+
+/*
+forwardTransform - Forward the transform of a client to the leader of the document, translating its
+version to that of the leader.
+*/
+func (b *Binder) forwardTransform(request TransformSubmission) {
+	r := b.relay
+	r.seq++
+	r.pending[r.seq] = request
+
+	ot := request.Transform
+	ot.Version += r.offset
+
+	msg := RelayMessage{Kind: relaySubmit, To: r.leaderNode, Seq: r.seq, Token: request.Token, Transform: &ot}
+	msg.Node, msg.Protocol = r.relay.Node(), RelayProtocol
+	if err := r.relay.Publish(b.ID, msg); err != nil {
+		b.stats.Incr("binder.relay.forward.error", 1)
+		delete(r.pending, r.seq)
+		b.sendClientError(request.ErrorChan, err)
+		return
+	}
+	b.stats.Incr("binder.relay.forwarded", 1)
+}
+
+/*
+processSubmit - Apply a transform forwarded by a follower as if it were submitted by a client of our
+own, and let the follower know if it was rejected.
+*/
+func (b *Binder) processSubmit(msg RelayMessage) {
+	if msg.Transform == nil {
+		return
+	}
+
+	// Nobody waits on the outcome, so the channels are buffered to keep processTransform from blocking
+	errChan := make(chan error, 1)
+	b.processTransform(TransformSubmission{
+		Token:       msg.Token,
+		Transform:   *msg.Transform,
+		VersionChan: make(chan int, 1),
+		ErrorChan:   errChan,
+		author:      msg.Transform.Author,
+		origin:      msg.Node,
+		seq:         msg.Seq,
+	})
+	select {
+	case err := <-errChan:
+		b.stats.Incr("binder.relay.submit.error", 1)
+		b.publish(RelayMessage{Kind: relayError, To: msg.Node, Seq: msg.Seq, Error: err.Error()})
+	default:
+	}
+}
+
+/*
+publishTransform - Relay a transform applied by the leader to its followers.
+*/
+func (b *Binder) publishTransform(request TransformSubmission, dispatch OTransform) {
+	if b.relay == nil {
+		return
+	}
+	b.publish(RelayMessage{
+		Kind:      relayTransform,
+		Origin:    request.origin,
+		Seq:       request.seq,
+		Token:     request.Token,
+		Transform: &dispatch,
+	})
+}
+
+/*
+publishMessage - Relay the message of a client to the other nodes.
+*/
+func (b *Binder) publishMessage(request MessageSubmission) {
+	// Spectators are counted by each node, and notices from the binder itself are sent by each node
+	if b.relay == nil || request.relayed || len(request.Token) == 0 || request.Message.Spectators != nil {
+		return
+	}
+	message := request.Message
+	b.publish(RelayMessage{Kind: relayMessage, Message: &message})
+}
+
+/*
+applyRelayed - Apply a transform relayed by the leader, which must directly follow our current
+version. Once applied it is sent to our clients, and to the client that submitted it the new version.
+*/
+func (b *Binder) applyRelayed(msg RelayMessage) error {
+	if msg.Transform == nil {
+		return nil
+	}
+	r := b.relay
+	ot := *msg.Transform
+	if ot.Version != r.offset+b.model.GetVersion()+1 {
+		b.stats.Incr("binder.relay.out_of_sync", 1)
+		return ErrRelayOutOfSync
+	}
+	ot.Version -= r.offset
+
+	dispatch, version, err := b.model.PushTransform(ot)
+	if err != nil {
+		return err
+	}
+	b.size = projectSize(b.size, dispatch)
+	b.dirty = true
+	b.unflushed++
+	b.stats.Incr("binder.relay.applied", 1)
+
+	// Only the client that submitted the transform through us is spared it
+	clientID := ""
+	if msg.Origin == r.relay.Node() {
+		if request, ok := r.pending[msg.Seq]; ok {
+			clientID = request.ClientID
+			delete(r.pending, msg.Seq)
+			select {
+			case request.VersionChan <- version:
+			default:
+				b.stats.Incr("binder.send_client_version.blocked", 1)
+			}
+			if !request.Submitted.IsZero() {
+				latency := time.Since(request.Submitted)
+				b.latency.record(b.ID, latency)
+				b.metrics.transformProcessed(latency)
+				b.stats.Timing("binder.transform_latency", latency.Seconds())
+			}
+		}
+	}
+	b.metrics.transformApplied()
+	b.recordTransform(dispatch)
+	b.broadcastTransform(clientID, dispatch)
+	return nil
+}
+
+/*
+sendSnapshot - Send the current content of the document to a follower, along with the version it is
+at, transforms relayed from here on follow the snapshot. The content is read without flushing, so
+that followers joining together do not each cost a write to the store.
+*/
+func (b *Binder) sendSnapshot(node string) error {
+	doc, err := b.peek()
+	if err != nil {
+		return err
+	}
+	b.stats.Incr("binder.relay.snapshot", 1)
+	b.publish(RelayMessage{
+		Kind:     relaySnapshot,
+		To:       node,
+		Content:  doc.Content,
+		Type:     doc.Type,
+		Metadata: doc.Metadata,
+		Version:  b.model.GetVersion(),
+	})
+	return nil
+}
+
+/*
+flushFollower - Apply pending transforms to the content held by a follower, the store is left to the
+leader of the document.
+*/
+func (b *Binder) flushFollower() (store.Document, error) {
+	r := b.relay
+	content := r.content
+	if _, err := b.model.FlushTransforms(&content, b.config.RetentionPeriod); err != nil {
+		b.stats.Incr("binder.flush.error", 1)
+		return store.Document{ID: b.ID, Type: r.docType, Content: r.content, Metadata: b.metadata}, err
+	}
+	r.content = content
+	b.dirty = false
+	b.unflushed = 0
+	b.size = uint64(len(content))
+	return store.Document{ID: b.ID, Type: r.docType, Content: content, Metadata: b.metadata}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
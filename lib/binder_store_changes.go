@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"fmt"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+StoreAuthor - The author of transforms that carry changes made to a document outside of leaps, such
+as a file edited by another tool.
+*/
+const StoreAuthor = "store"
+
+/*
+storeState - The content of the document as this binder last read or wrote it in the store, and the
+version of the document at that point. Content found in the store that differs from it was written by
+something else.
+*/
+type storeState struct {
+	tracking bool
+	content  string
+	version  int
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+StoreChanged - Notify the binder that its document may have been modified in the store by something
+other than leaps. The binder flushes straight away, merging any such changes into the document. When
+notifications arrive faster than they are handled they are coalesced.
+*/
+func (b *Binder) StoreChanged() {
+	select {
+	case b.storeChangedChan <- struct{}{}:
+	default:
+	}
+}
+
+/*
+trackStore - Record content that has just been read from or written to the store, only used when
+WatchStore is enabled.
+*/
+func (b *Binder) trackStore(content string) {
+	if !b.config.WatchStore {
+		return
+	}
+	b.stored = storeState{
+		tracking: true,
+		content:  content,
+		version:  b.model.GetVersion(),
+	}
+}
+
+/*
+mergeStoreChange - Submit the difference between the content the binder last stored and the content
+now found in the store as transforms against the version it was stored at, which transforms them past
+any changes clients have made since. Returns the content that unflushed transforms apply to.
+
+A change that cannot be merged is overwritten by the next flush, and so is reported to clients and
+the curator as a diagnostic rather than only being logged.
+*/
+func (b *Binder) mergeStoreChange(docType, content string) string {
+	if !b.stored.tracking || content == b.stored.content {
+		return content
+	}
+	if !isTextType(docType) {
+		b.stats.Incr("binder.store_change.unsupported", 1)
+		b.log.Warnf("Document %v was modified in the store, only text documents can be merged\n", b.ID)
+		b.diagnose([]string{"change made outside of leaps was discarded: only text documents can be merged"})
+		return b.stored.content
+	}
+
+	// Transforms are in reverse order of position, so each one is unaffected by those before it.
+	transforms := mergeTransforms(b.stored.content, content)
+	if err := checkMergeSize(b.config, content, transforms); err != nil {
+		b.rejectStoreChange(err)
+		return b.stored.content
+	}
+	b.stats.Incr("binder.store_change.merged", 1)
+	b.log.Infof("Document %v was modified in the store, merging changes\n", b.ID)
+
+	for i, ot := range transforms {
+		ot.Version = b.stored.version + 1
+
+		// Nobody waits on the outcome, so the channels are buffered to keep processTransform from blocking
+		errChan := make(chan error, 1)
+		b.processTransform(TransformSubmission{
+			Token:       StoreAuthor,
+			Transform:   ot,
+			VersionChan: make(chan int, 1),
+			ErrorChan:   errChan,
+			author:      StoreAuthor,
+		})
+		select {
+		case err := <-errChan:
+			b.rejectStoreChange(fmt.Errorf("%v of %v changes were merged: %v", i, len(transforms), err))
+			return b.stored.content
+		default:
+		}
+	}
+	return b.stored.content
+}
+
+/*
+rejectStoreChange - Report a change made in the store that could not be merged into the document.
+*/
+func (b *Binder) rejectStoreChange(err error) {
+	b.stats.Incr("binder.store_change.error", 1)
+	b.log.Errorf("Failed to merge store change: %v\n", err)
+	b.diagnose([]string{fmt.Sprintf("change made outside of leaps could not be merged: %v", err)})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
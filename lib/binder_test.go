@@ -23,14 +23,18 @@ THE SOFTWARE.
 package lib
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/test/conformance"
 )
 
 /*
@@ -97,6 +101,51 @@ func TestGracefullShutdown(t *testing.T) {
 	binder.Close()
 }
 
+func TestBinderSubscribeClosed(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	binder.Close()
+
+	// Subscribing to, or closing, a binder that has closed fails cleanly
+	if late := binder.Subscribe(""); late.Error != ErrBinderClosed {
+		t.Errorf("Wrong error subscribing to a closed binder: %v", late.Error)
+	}
+	binder.Close()
+}
+
+func TestBinderReleaseIdle(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+	if binder.releaseIdle(time.Second) {
+		t.Error("Binder with a client was released")
+	}
+	portal.Exit(time.Second)
+	if !binder.releaseIdle(time.Second) {
+		t.Error("Idle binder was not released")
+	}
+	if late := binder.Subscribe(""); late.Error != ErrBinderClosed {
+		t.Errorf("Wrong error subscribing to a released binder: %v", late.Error)
+	}
+}
+
 func TestClientAdminTasks(t *testing.T) {
 	errChan := make(chan BinderError, 10)
 
@@ -124,7 +173,7 @@ func TestClientAdminTasks(t *testing.T) {
 			t.Errorf("Subscribe error: %v\n", portals[i].Error)
 			return
 		}
-		clientIDs[i] = portals[i].Token
+		clientIDs[i] = portals[i].UserID
 	}
 
 	for i := 0; i < nClients; i++ {
@@ -188,23 +237,71 @@ func TestUpdates(t *testing.T) {
 	}()
 
 	portal1, portal2 := binder.Subscribe(""), binder.Subscribe("")
+	if portal1.UserID == portal1.Token || portal1.UserID == portal2.UserID {
+		t.Errorf("Wrong user IDs: %v, %v", portal1.UserID, portal2.UserID)
+	}
 	for i := 0; i < 100; i++ {
-		portal1.SendMessage(ClientMessage{Token: portal1.Token})
+		// Clients cannot pass themselves off as somebody else
+		portal1.SendMessage(ClientMessage{UserID: portal2.UserID})
 
 		message := <-portal2.MessageRcvChan
-		if message.Token != portal1.Token {
-			t.Errorf("Received incorrect token: %v", message.Token)
+		if message.UserID != portal1.UserID {
+			t.Errorf("Received incorrect user ID: %v", message.UserID)
 		}
 
-		portal2.SendMessage(ClientMessage{Token: portal2.Token})
+		portal2.SendMessage(ClientMessage{})
 
 		message2 := <-portal1.MessageRcvChan
-		if message2.Token != portal2.Token {
-			t.Errorf("Received incorrect token: %v", message2.Token)
+		if message2.UserID != portal2.UserID {
+			t.Errorf("Received incorrect user ID: %v", message2.UserID)
 		}
 	}
 }
 
+func TestCursors(t *testing.T) {
+	errChan := make(chan BinderError)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	binder, err := NewBinder(
+		doc.ID,
+		&testStore{documents: map[string]store.Document{doc.ID: *doc}},
+		DefaultBinderConfig(),
+		errChan,
+		logger,
+		stats,
+	)
+	if err != nil {
+		t.Errorf("error: %v", err)
+		return
+	}
+
+	portal1, portal2 := binder.Subscribe(""), binder.Subscribe("")
+	portal1.SendCursor(5)
+
+	message := <-portal2.MessageRcvChan
+	if message.UserID != portal1.UserID {
+		t.Errorf("Received incorrect user ID: %v", message.UserID)
+	}
+	if message.Position == nil || *message.Position != 5 {
+		t.Errorf("Received incorrect position: %v", message.Position)
+	}
+
+	portal3 := binder.Subscribe("")
+	if len(portal3.Cursors) != 1 {
+		t.Errorf("Wrong count of cursors: %v != %v", len(portal3.Cursors), 1)
+		return
+	}
+	if portal3.Cursors[0].UserID != portal1.UserID {
+		t.Errorf("Received incorrect user ID: %v", portal3.Cursors[0].UserID)
+	}
+	if *portal3.Cursors[0].Position != 5 {
+		t.Errorf("Received incorrect position: %v", *portal3.Cursors[0].Position)
+	}
+
+	binder.Close()
+}
+
 func TestNewBinder(t *testing.T) {
 	errChan := make(chan BinderError)
 	doc, _ := store.NewDocument("hello world")
@@ -320,6 +417,36 @@ func TestReadOnlyPortals(t *testing.T) {
 		t.Errorf("Read only portal unexpected result: %v", err)
 	}
 
+	// Craft a submission with a read only token through a writable channel
+	crafted := binder.SubscribeReadOnly("")
+	crafted.TransformSndChan = portal1.TransformSndChan
+	if _, err := crafted.SendTransform(
+		OTransform{
+			Position: 0,
+			Version:  4,
+			Delete:   0,
+			Insert:   "evil ",
+		},
+		time.Second,
+	); err != ErrReadOnlyPortal {
+		t.Errorf("Crafted read only submission unexpected result: %v", err)
+	}
+
+	// The same again with the ID of a read only portal that has since exited
+	portalReadOnly.Exit(time.Second)
+	portalReadOnly.TransformSndChan = portal1.TransformSndChan
+	if _, err := portalReadOnly.SendTransform(
+		OTransform{
+			Position: 0,
+			Version:  4,
+			Delete:   0,
+			Insert:   "evil ",
+		},
+		time.Second,
+	); err != ErrPortalClosed {
+		t.Errorf("Crafted exited read only submission unexpected result: %v", err)
+	}
+
 	portal3 := binder.Subscribe("")
 	if exp, rec := "super hello universe", portal3.Document.Content; exp != rec {
 		t.Errorf("Wrong content, expected %v, received %v", exp, rec)
@@ -356,6 +483,136 @@ func goodClient(b BinderPortal, expecting int, t *testing.T, wg *sync.WaitGroup)
 	wg.Done()
 }
 
+func TestHistory(t *testing.T) {
+	errChan := make(chan BinderError)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.HistoryLength = 2
+
+	binder, err := NewBinder(
+		doc.ID,
+		&testStore{documents: map[string]store.Document{doc.ID: *doc}},
+		config,
+		errChan,
+		logger,
+		stats,
+	)
+	if err != nil {
+		t.Errorf("error: %v", err)
+		return
+	}
+
+	go func() {
+		for err := range errChan {
+			t.Errorf("From error channel: %v", err.Err)
+		}
+	}()
+
+	portal := binder.Subscribe("")
+
+	transforms := []OTransform{
+		{Position: 5, Version: 2, Insert: ","},
+		{Position: 7, Version: 3, Delete: 5, Insert: "universe"},
+		{Position: 0, Version: 4, Delete: 1, Insert: "H"},
+	}
+	for _, ot := range transforms {
+		if _, err := portal.SendTransform(ot, time.Second); err != nil {
+			t.Errorf("Send Transform error: %v", err)
+		}
+	}
+
+	if _, err := binder.GetVersion(1, time.Second); err != ErrVersionNotRetained {
+		t.Errorf("Expected version 1 to be folded out of history, received: %v", err)
+	}
+	if _, err := binder.GetVersion(5, time.Second); err != ErrVersionNotExist {
+		t.Errorf("Expected version 5 not to exist, received: %v", err)
+	}
+
+	expected := map[int]string{
+		2: "hello, world",
+		3: "hello, universe",
+		4: "Hello, universe",
+	}
+	for version, exp := range expected {
+		doc, err := binder.GetVersion(version, time.Second)
+		if err != nil {
+			t.Errorf("Get version %v error: %v", version, err)
+		} else if exp != doc.Content {
+			t.Errorf("Wrong content at version %v, expected %v, received %v", version, exp, doc.Content)
+		}
+	}
+}
+
+func TestBinderResume(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.HistoryLength = 2
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+	if portal.Epoch != binder.Epoch || len(portal.Epoch) == 0 {
+		t.Errorf("Wrong portal epoch: %v != %v", portal.Epoch, binder.Epoch)
+	}
+	for i, char := range "!!!" {
+		ot := OTransform{Position: 11 + i, Insert: string(char), Version: 2 + i}
+		if _, err = portal.SendTransform(ot, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		epoch           string
+		version, missed int
+	}{
+		{binder.Epoch, 2, 2},
+		{binder.Epoch, 3, 1},
+		{binder.Epoch, 4, 0},
+	} {
+		resumed := binder.SubscribeFrom("", test.epoch, test.version)
+		if exp, act := test.version, resumed.ResumedFrom; exp != act {
+			t.Errorf("Wrong resumed version: %v != %v", exp, act)
+		}
+		if exp, act := test.missed, len(resumed.Missed); exp != act {
+			t.Errorf("Wrong count of missed transforms from %v: %v != %v", test.version, exp, act)
+		}
+		if len(resumed.Missed) > 0 && resumed.Missed[0].Version != test.version+1 {
+			t.Errorf("Wrong first missed transform from %v: %v", test.version, resumed.Missed[0])
+		}
+		if exp, act := 4, resumed.Version; exp != act {
+			t.Errorf("Wrong version: %v != %v", exp, act)
+		}
+	}
+
+	// Versions folded out of history, from the future, or of another epoch fall back
+	for _, test := range []struct {
+		epoch   string
+		version int
+	}{
+		{binder.Epoch, 1},
+		{binder.Epoch, 5},
+		{"previous", 3},
+	} {
+		resumed := binder.SubscribeFrom("", test.epoch, test.version)
+		if resumed.ResumedFrom != 0 || resumed.Missed != nil {
+			t.Errorf("Expected fallback for %v at %v: %v %v", test.epoch, test.version, resumed.ResumedFrom, resumed.Missed)
+		}
+		if exp, act := "hello world!!!", resumed.Document.Content; exp != act {
+			t.Errorf("Wrong fallback content: %v != %v", exp, act)
+		}
+	}
+}
+
 func TestClients(t *testing.T) {
 	errChan := make(chan BinderError)
 	doc, _ := store.NewDocument("hello world")
@@ -423,26 +680,16 @@ func TestClients(t *testing.T) {
 	wg.Wait()
 }
 
-type binderStory struct {
-	Content    string       `json:"content" yaml:"content"`
-	Transforms []OTransform `json:"transforms" yaml:"transforms"`
-	TCorrected []OTransform `json:"corrected_transforms" yaml:"corrected_transforms"`
-	Result     string       `json:"result" yaml:"result"`
-}
-
-type binderStoriesContainer struct {
-	Stories []binderStory `json:"binder_stories" yaml:"binder_stories"`
-}
-
-func goodStoryClient(b BinderPortal, bstory *binderStory, wg *sync.WaitGroup, t *testing.T) {
-	tformIndex, lenCorrected := 0, len(bstory.TCorrected)
+func goodStoryClient(b BinderPortal, bstory *conformance.BinderStory, wg *sync.WaitGroup, t *testing.T) {
+	tformIndex, lenCorrected := 0, len(bstory.CorrectedTransforms)
 	go func() {
 		for tform := range b.TransformRcvChan {
-			if tform.Version != bstory.TCorrected[tformIndex].Version ||
-				tform.Insert != bstory.TCorrected[tformIndex].Insert ||
-				tform.Delete != bstory.TCorrected[tformIndex].Delete ||
-				tform.Position != bstory.TCorrected[tformIndex].Position {
-				t.Errorf("Transform not expected, %v != %v", tform, bstory.TCorrected[tformIndex])
+			expected := bstory.CorrectedTransforms[tformIndex]
+			if tform.Version != expected.Version ||
+				tform.Insert != expected.Insert ||
+				tform.Delete != expected.Delete ||
+				tform.Position != expected.Position {
+				t.Errorf("Transform not expected, %v != %v", tform, expected)
 			}
 			tformIndex++
 			if tformIndex == lenCorrected {
@@ -460,19 +707,13 @@ func TestBinderStories(t *testing.T) {
 	nClients := 10
 	logger, stats := loggerAndStats()
 
-	bytes, err := ioutil.ReadFile("../test/stories/binder_stories.js")
+	stories, err := conformance.BinderStories()
 	if err != nil {
-		t.Errorf("Read file error: %v", err)
+		t.Errorf("Story load error: %v", err)
 		return
 	}
 
-	var scont binderStoriesContainer
-	if err := json.Unmarshal(bytes, &scont); err != nil {
-		t.Errorf("Story parse error: %v", err)
-		return
-	}
-
-	for _, story := range scont.Stories {
+	for _, story := range stories {
 		doc, err := store.NewDocument(story.Content)
 		if err != nil {
 			t.Errorf("error: %v", err)
@@ -518,7 +759,7 @@ func TestBinderStories(t *testing.T) {
 		}()
 
 		for j := 0; j < len(story.Transforms); j++ {
-			if _, err = bp.SendTransform(story.Transforms[j], time.Second); err != nil {
+			if _, err = bp.SendTransform(fixtureTransform(story.Transforms[j]), time.Second); err != nil {
 				t.Errorf("Send issue %v", err)
 			}
 		}
@@ -533,3 +774,1085 @@ func TestBinderStories(t *testing.T) {
 		binder.Close()
 	}
 }
+
+func TestBinderRateLimitKick(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.RateLimit.TransformsPerSecond = 2
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	runaway := binder.Subscribe("")
+	other := binder.Subscribe("")
+	go func() {
+		for _ = range other.TransformRcvChan {
+		}
+	}()
+
+	for i := 0; i < 2; i++ {
+		if _, err = runaway.SendTransform(OTransform{Position: 0, Insert: "a", Version: i + 2}, time.Second); err != nil {
+			t.Errorf("Transform within limit rejected: %v", err)
+		}
+	}
+	_, err = runaway.SendTransform(OTransform{Position: 0, Insert: "a", Version: 4}, time.Second)
+	if limited, ok := err.(*RateLimitedError); !ok || limited.RetryAfter <= 0 || limited.RetryAfter > time.Second {
+		t.Errorf("Expected rate limit error, received: %v", err)
+	} else if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Rate limit error does not wrap ErrRateLimited: %v", err)
+	}
+	if _, open := <-runaway.TransformRcvChan; open {
+		t.Error("Expected runaway client to be kicked")
+	}
+	if _, err = runaway.SendTransform(OTransform{Position: 0, Insert: "a", Version: 4}, time.Second); err != ErrPortalClosed {
+		t.Errorf("Kicked runaway submission unexpected result: %v", err)
+	}
+
+	if _, err = other.SendTransform(OTransform{Position: 0, Insert: "b", Version: 4}, time.Second); err != nil {
+		t.Errorf("Other client was affected by the runaway: %v", err)
+	}
+}
+
+func TestBinderRateLimitThrottle(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.RateLimit.BytesPerSecond = 20
+	config.RateLimit.Action = "throttle"
+	config.ModelConfig.MaxTransformLength = 10
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		ot := OTransform{Position: 0, Insert: strings.Repeat("a", 10), Version: i + 2}
+		if _, err = portal.SendTransform(ot, time.Second); err != nil {
+			t.Errorf("Throttled transform rejected: %v", err)
+		}
+	}
+	// The first twenty bytes are the burst, the remaining ten take 500ms at 20 bytes per second.
+	if elapsed := time.Since(started); elapsed < 400*time.Millisecond {
+		t.Errorf("Transforms were not throttled: %v", elapsed)
+	}
+}
+
+func TestLatencyTrackerPercentiles(t *testing.T) {
+	tracker := NewLatencyTracker(100)
+	for i := 1; i <= 200; i++ {
+		tracker.record("doc", time.Duration(i)*time.Millisecond)
+	}
+
+	report := tracker.Report()
+	expected := LatencyPercentiles{Samples: 100, P50: 150, P95: 195, P99: 199}
+	if report.Global != expected {
+		t.Errorf("Wrong global percentiles: %+v != %+v", report.Global, expected)
+	}
+	if report.Documents["doc"] != expected {
+		t.Errorf("Wrong document percentiles: %+v != %+v", report.Documents["doc"], expected)
+	}
+
+	tracker.forget("doc")
+	if _, exists := tracker.Report().Documents["doc"]; exists {
+		t.Error("Document percentiles remained after forget")
+	}
+}
+
+func TestBinderLatency(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	tracker := NewLatencyTracker(10)
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, nil, DefaultBinderConfig(), nil, tracker, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+	for i := 0; i < 3; i++ {
+		if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "a", Version: i + 2}, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The latency is recorded after the version is returned, so allow the binder to catch up.
+	report := tracker.Report()
+	for deadline := time.Now().Add(time.Second); report.Global.Samples < 3 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		report = tracker.Report()
+	}
+	if report.Global.Samples != 3 {
+		t.Errorf("Wrong number of global samples: %v != 3", report.Global.Samples)
+	}
+	if report.Documents[doc.ID].Samples != 3 {
+		t.Errorf("Wrong number of document samples: %v != 3", report.Documents[doc.ID].Samples)
+	}
+}
+
+func TestBinderSizeLimits(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.MaxDocumentSize = 20
+	config.MaxTransformSize = 5
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "abcdef", Version: 2}, time.Second); err != ErrTransformTooLarge {
+		t.Errorf("Expected transform size error, received: %v", err)
+	}
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "abcde", Version: 2}, time.Second); err != nil {
+		t.Errorf("Transform within limits rejected: %v", err)
+	}
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "abcde", Version: 3}, time.Second); err != ErrDocumentTooLarge {
+		t.Errorf("Expected document size error, received: %v", err)
+	}
+
+	// Deleting makes room for the insert.
+	if _, err = portal.SendTransform(OTransform{Position: 0, Delete: 5, Insert: "abcde", Version: 3}, time.Second); err != nil {
+		t.Errorf("Transform within limits rejected: %v", err)
+	}
+}
+
+func TestHistoryCompaction(t *testing.T) {
+	now := time.Now()
+	history, err := newBinderHistory("text", 10, 60, "hello world", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ot := range []OTransform{
+		{Position: 5, Version: 2, Insert: ",", TReceived: now.Add(-2 * time.Minute).Unix()},
+		{Position: 0, Version: 3, Delete: 1, Insert: "H", TReceived: now.Unix()},
+	} {
+		if err := history.record(ot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := history.compact(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 || history.baseVersion != 2 {
+		t.Errorf("Wrong compaction: %v pruned, base at %v", pruned, history.baseVersion)
+	}
+	if content, _ := history.contentAt(2); content != "hello, world" {
+		t.Errorf("Wrong content at version 2: %v", content)
+	}
+	if _, err = history.contentAt(1); err != ErrVersionNotRetained {
+		t.Errorf("Expected version 1 to be pruned, received: %v", err)
+	}
+	if content, _ := history.contentAt(3); content != "Hello, world" {
+		t.Errorf("Wrong content at version 3: %v", content)
+	}
+}
+
+func TestHistoryFolding(t *testing.T) {
+	history, err := newBinderHistory("text", 2, 0, "hello world", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, char := range "!!!" {
+		if err = history.record(OTransform{Position: 11 + i, Insert: string(char), Version: 2 + i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Folding waits for a full batch, but versions beyond the limit are no longer served
+	if history.baseVersion != 1 || len(history.transforms) != 3 {
+		t.Errorf("Expected no fold yet: base at %v with %v transforms", history.baseVersion, len(history.transforms))
+	}
+	if _, err = history.contentAt(1); err != ErrVersionNotRetained {
+		t.Errorf("Expected version 1 to be beyond the limit, received: %v", err)
+	}
+	if _, err = history.since(1); err != ErrVersionNotRetained {
+		t.Errorf("Expected version 1 to be beyond the limit, received: %v", err)
+	}
+
+	if err = history.record(OTransform{Position: 14, Insert: "!", Version: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if history.baseVersion != 3 || len(history.transforms) != 2 {
+		t.Errorf("Wrong fold: base at %v with %v transforms", history.baseVersion, len(history.transforms))
+	}
+	if content, _ := history.contentAt(5); content != "hello world!!!!" {
+		t.Errorf("Wrong content at version 5: %v", content)
+	}
+
+	// A transform that cannot be folded is dropped
+	broken, err := newBinderHistory("json", 1, 0, "not json", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = broken.record(OTransform{Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err = broken.record(OTransform{Version: 3}); err == nil {
+		t.Error("Expected fold to fail")
+	}
+	if exp, act := 1, len(broken.transforms); exp != act {
+		t.Errorf("Wrong count of transforms after failed fold: %v != %v", exp, act)
+	}
+}
+
+func TestBinderSnapshot(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+	if _, err = portal.SendTransform(OTransform{Position: 5, Insert: ",", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, err := binder.Snapshot(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Version != 2 || snapshot.Document.Content != "hello, world" {
+		t.Errorf("Wrong snapshot: %v at %v", snapshot.Document.Content, snapshot.Version)
+	}
+	if stored, _ := docStore.Read(doc.ID); stored.Content != "hello, world" {
+		t.Errorf("Snapshot was not written to the store: %v", stored.Content)
+	}
+}
+
+func TestBinderSpectators(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.SpectatorPeriod = 10
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer := binder.Subscribe("")
+	viewerOne := binder.SubscribeReadOnly("")
+	binder.SubscribeReadOnly("")
+
+	// Presence of read only clients is counted rather than forwarded
+	viewerOne.SendMessage(ClientMessage{Presence: "join"})
+
+	select {
+	case msg := <-writer.MessageRcvChan:
+		if msg.Spectators == nil {
+			t.Fatalf("Expected a spectator count, received: %v", msg)
+		}
+		if *msg.Spectators != 2 {
+			t.Errorf("Wrong spectator count: %v != %v", *msg.Spectators, 2)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for spectator count")
+	}
+}
+
+func TestBinderRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_recording")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.Recorder.Directory = dir
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+
+	// The document is bound twice, and versions begin again with the second binding
+	epochs := []string{}
+	for _, ots := range [][]OTransform{
+		{{Position: 5, Insert: ",", Version: 2}, {Position: 12, Insert: "!", Version: 3}},
+		{{Position: 0, Insert: "oh ", Version: 2}},
+	} {
+		binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		portal := binder.Subscribe("")
+		for i, ot := range ots {
+			if _, err = portal.SendTransform(ot, time.Second); err != nil {
+				t.Fatalf("Transform %v: %v", i, err)
+			}
+		}
+		epochs = append(epochs, binder.Epoch)
+		binder.Close()
+	}
+
+	path, err := RecordingPath(dir, doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recording, err := ReadRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Bindings) != 2 {
+		t.Fatalf("Wrong count of recorded bindings: %v", len(recording.Bindings))
+	}
+	for i, exp := range []string{"hello, world!", "oh hello, world!"} {
+		binding := recording.Bindings[i]
+		if binding.Epoch != epochs[i] || binding.Version != 1 {
+			t.Errorf("Wrong binding %v: %v at %v", i, binding.Epoch, binding.Version)
+		}
+		transforms := []OTransform{}
+		for _, entry := range binding.Transforms {
+			if entry.Time.Before(binding.Started) {
+				t.Errorf("Transform recorded before the document: %v", entry.Time)
+			}
+			transforms = append(transforms, *entry.Transform)
+		}
+		content, err := replayTextTransforms(binding.Document.Content, transforms)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if content != exp {
+			t.Errorf("Wrong replayed content of binding %v: %v != %v", i, content, exp)
+		}
+	}
+}
+
+func TestBinderKickAndBan(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("troll")
+	if err = binder.Kick("troll", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, open := <-portal.TransformRcvChan; open {
+		t.Error("Kicked portal was left open")
+	}
+	if msg := <-portal.MessageRcvChan; !msg.Kicked {
+		t.Errorf("Kicked portal was not notified: %v", msg)
+	}
+	if _, open := <-portal.MessageRcvChan; open {
+		t.Error("Kicked portal messages were left open")
+	}
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "spam", Version: 2}, time.Second); err != ErrPortalClosed {
+		t.Errorf("Wrong error for transform of kicked portal: %v", err)
+	}
+	if err = binder.Kick("troll", time.Second); err != ErrClientNotFound {
+		t.Errorf("Wrong error for kicking absent client: %v", err)
+	}
+
+	// Kicked clients may return, banned clients may not.
+	portal = binder.Subscribe("troll")
+	if portal.Error != nil {
+		t.Fatalf("Kicked client could not return: %v", portal.Error)
+	}
+	if err = binder.Ban("troll", time.Minute, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, open := <-portal.TransformRcvChan; open {
+		t.Error("Banned portal was left open")
+	}
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "spam", Version: 2}, time.Second); err != ErrPortalClosed {
+		t.Errorf("Wrong error for transform of banned portal: %v", err)
+	}
+	if portal = binder.Subscribe("troll"); portal.Error != ErrClientBanned {
+		t.Errorf("Wrong error for banned client: %v", portal.Error)
+	}
+	if portal = binder.SubscribeReadOnly("troll"); portal.Error != ErrClientBanned {
+		t.Errorf("Wrong error for banned read only client: %v", portal.Error)
+	}
+	if portal = binder.Subscribe("friend"); portal.Error != nil {
+		t.Errorf("Ban affected another client: %v", portal.Error)
+	}
+	if exp, rec := "hello world", portal.Document.Content; exp != rec {
+		t.Errorf("Transforms of removed portals were applied: %v != %v", exp, rec)
+	}
+
+	if err = binder.Ban("troll", 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if portal = binder.Subscribe("troll"); portal.Error != nil {
+		t.Errorf("Lifted ban still applied: %v", portal.Error)
+	}
+
+	if err = binder.Ban("brief", time.Millisecond, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if binder.Banned("brief") {
+		t.Error("Expired ban still applied")
+	}
+}
+
+func TestBinderSharedToken(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	// Two tabs of the same user share a token, but not a portal.
+	first, second := binder.Subscribe("tabs"), binder.Subscribe("tabs")
+	if first.Error != nil || second.Error != nil {
+		t.Fatalf("Shared token was refused: %v, %v", first.Error, second.Error)
+	}
+	if first.ClientID == second.ClientID {
+		t.Fatal("Portals of a shared token have the same ID")
+	}
+
+	if _, err = first.SendTransform(OTransform{Position: 0, Insert: "x", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ot := <-second.TransformRcvChan:
+		if ot.Insert != "x" {
+			t.Errorf("Wrong transform: %v", ot)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Second tab did not receive the transform of the first")
+	}
+
+	if tokens, err := binder.getTokens(time.Second); err != nil || len(tokens) != 1 {
+		t.Errorf("Wrong tokens of a shared token: %v, %v", tokens, err)
+	}
+
+	// Without an authenticator to identify the user each tab is known to others by its portal.
+	if users, err := binder.GetUsers(time.Second); err != nil || len(users) != 2 {
+		t.Errorf("Wrong users of a shared token: %v, %v", users, err)
+	}
+
+	// One tab leaving does not disconnect the other.
+	first.Exit(time.Second)
+	if _, open := <-first.TransformRcvChan; open {
+		t.Error("Exited portal was left open")
+	}
+	if _, err = second.SendTransform(OTransform{Position: 0, Insert: "y", Version: 3}, time.Second); err != nil {
+		t.Errorf("Remaining tab was disconnected: %v", err)
+	}
+
+	select {
+	case err := <-errChan:
+		t.Errorf("Binder failed: %v", err.Err)
+	default:
+	}
+}
+
+func TestBinderThrottled(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	editor := binder.Subscribe("")
+	dashboard := binder.SubscribeThrottled("", 200*time.Millisecond)
+	if dashboard.Error != nil {
+		t.Fatal(dashboard.Error)
+	}
+
+	started := time.Now()
+	for i, char := range "!!!!!!" {
+		ot := OTransform{Position: 11 + i, Insert: string(char), Version: 2 + i}
+		if _, err = editor.SendTransform(ot, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	content, received := dashboard.Document.Content, 0
+	for version := 1; version < 7; {
+		select {
+		case ots := <-dashboard.BatchRcvChan:
+			received++
+			for _, ot := range ots {
+				if version++; ot.Version != version {
+					t.Fatalf("Wrong version: %v != %v", ot.Version, version)
+				}
+			}
+			if content, err = replayTextTransforms(content, ots); err != nil {
+				t.Fatal(err)
+			}
+		case <-dashboard.TransformRcvChan:
+			t.Fatal("Throttled client received an unbatched transform")
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for throttled transforms, at version %v", version)
+		}
+	}
+	if content != "hello world!!!!!!" {
+		t.Errorf("Wrong throttled content: %v", content)
+	}
+	if elapsed := time.Since(started); received > 1+int(elapsed/(200*time.Millisecond)) {
+		t.Errorf("Received %v batches over %v", received, elapsed)
+	}
+
+	// A client that falls too far behind within a period is sent its transforms early
+	slow := binder.SubscribeThrottled("", time.Hour)
+	if slow.Error != nil {
+		t.Fatal(slow.Error)
+	}
+	for i := 0; i <= maxThrottlePending; i++ {
+		ot := OTransform{Position: 0, Insert: "a", Version: 8 + i}
+		if _, err = editor.SendTransform(ot, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			continue
+		}
+		// The first transform is due straight away
+		select {
+		case <-slow.BatchRcvChan:
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for throttled transforms")
+		}
+	}
+	select {
+	case ots := <-slow.BatchRcvChan:
+		if len(ots) != maxThrottlePending {
+			t.Errorf("Wrong count of early transforms: %v", len(ots))
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for early throttled transforms")
+	}
+
+	portalChan := make(chan BinderPortal, 1)
+	binder.subscribeChan <- BinderSubscribeBundle{
+		PortalRcvChan: portalChan,
+		Token:         "writer",
+		Throttle:      time.Second,
+	}
+	if portal := <-portalChan; portal.Error != ErrThrottleNotSupported {
+		t.Errorf("Wrong error for throttled writable portal: %v", portal.Error)
+	}
+}
+
+func TestBinderBroadcastWindow(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.BroadcastWindow = 200
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	first, second, viewer := binder.Subscribe(""), binder.Subscribe(""), binder.SubscribeReadOnly("")
+
+	for i, char := range "!!!" {
+		ot := OTransform{Position: 11 + i, Insert: string(char), Version: 2 + i}
+		if _, err = first.SendTransform(ot, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = second.SendTransform(OTransform{Position: 0, Insert: ">", Version: 5}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(portal BinderPortal) []OTransform {
+		select {
+		case ots := <-portal.BatchRcvChan:
+			return ots
+		case ot := <-portal.TransformRcvChan:
+			t.Errorf("Received unbatched transform: %v", ot)
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for batch")
+		}
+		return nil
+	}
+
+	if exp, act := 4, len(receive(viewer)); exp != act {
+		t.Errorf("Wrong viewer batch size: %v != %v", exp, act)
+	}
+	if batch := receive(first); len(batch) != 1 || batch[0].Insert != ">" {
+		t.Errorf("Wrong batch for first editor: %v", batch)
+	}
+	batch := receive(second)
+	if len(batch) != 3 {
+		t.Fatalf("Wrong batch for second editor: %v", batch)
+	}
+	for i, ot := range batch {
+		if exp, act := 2+i, ot.Version; exp != act {
+			t.Errorf("Wrong version in batch: %v != %v", exp, act)
+		}
+	}
+
+	// Subscribers receive queued transforms as part of their document instead
+	if _, err = first.SendTransform(OTransform{Position: 1, Insert: "?", Version: 6}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	late := binder.Subscribe("")
+	if exp, act := ">?hello world!!!", late.Document.Content; exp != act {
+		t.Errorf("Wrong late subscriber content: %v != %v", exp, act)
+	}
+	if ot := receive(viewer); len(ot) != 1 {
+		t.Errorf("Queued transform was not delivered before subscribe: %v", ot)
+	}
+	select {
+	case ots := <-late.BatchRcvChan:
+		t.Errorf("Late subscriber received queued transforms: %v", ots)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+type slowStore struct {
+	*testStore
+	delay int64
+}
+
+func (s *slowStore) Update(doc store.Document) error {
+	time.Sleep(time.Duration(atomic.LoadInt64(&s.delay)))
+	return s.testStore.Update(doc)
+}
+
+func TestBinderDegradation(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 10
+	config.Degradation.LatencyThreshold = 20
+	config.Degradation.SlowFlushes = 2
+	config.Degradation.RecoverFlushes = 1
+	config.Degradation.FlushPeriodFactor = 2
+
+	docStore := &slowStore{
+		testStore: &testStore{documents: map[string]store.Document{doc.ID: *doc}},
+		delay:     int64(50 * time.Millisecond),
+	}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer, reader := binder.Subscribe(""), binder.Subscribe("")
+	go func() {
+		for range reader.TransformRcvChan {
+		}
+	}()
+	go func() {
+		for range writer.MessageRcvChan {
+		}
+	}()
+
+	version := 2
+	awaitDegraded := func(exp bool) {
+		deadline := time.After(5 * time.Second)
+		for {
+			if _, err := writer.SendTransform(OTransform{Position: 0, Insert: "x", Version: version}, time.Second); err != nil {
+				t.Fatal(err)
+			}
+			version++
+			select {
+			case msg := <-reader.MessageRcvChan:
+				if msg.Degraded != nil {
+					if *msg.Degraded != exp {
+						t.Fatalf("Wrong degraded notice: %v != %v", *msg.Degraded, exp)
+					}
+					return
+				}
+			case <-time.After(30 * time.Millisecond):
+			case <-deadline:
+				t.Fatalf("Timed out waiting for degraded notice: %v", exp)
+			}
+		}
+	}
+
+	awaitDegraded(true)
+	if late := binder.Subscribe(""); !late.Degraded {
+		t.Error("Late subscriber was not told of degraded mode")
+	} else {
+		late.Exit(time.Second)
+	}
+
+	atomic.StoreInt64(&docStore.delay, 0)
+	awaitDegraded(false)
+}
+
+func TestBinderStoreChanges(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("first line\nsecond line\n")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 60000
+	config.WatchStore = true
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer, reader := binder.Subscribe(""), binder.Subscribe("")
+	if _, err = writer.SendTransform(OTransform{Position: 0, Insert: "!", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reader.TransformRcvChan:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for client transform")
+	}
+
+	// Another tool appends a line to the stored document before the client edit is flushed
+	docStore.Update(store.Document{ID: doc.ID, Content: "first line\nsecond line\nthird line\n"})
+	binder.StoreChanged()
+
+	select {
+	case ot := <-reader.TransformRcvChan:
+		if ot.Position != 24 || ot.Insert != "third line\n" || ot.Version != 3 || ot.Author != StoreAuthor {
+			t.Errorf("Wrong store change transform: %+v", ot)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for store change transform")
+	}
+
+	exp := "!first line\nsecond line\nthird line\n"
+	snapshot, err := binder.Snapshot(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Document.Content != exp {
+		t.Errorf("Wrong merged content: %q != %q", snapshot.Document.Content, exp)
+	}
+	if stored, _ := docStore.Read(doc.ID); stored.Content != exp {
+		t.Errorf("Wrong stored content: %q != %q", stored.Content, exp)
+	}
+
+	// Our own flushes are not mistaken for changes made elsewhere
+	binder.StoreChanged()
+	select {
+	case ot := <-reader.TransformRcvChan:
+		t.Errorf("Unexpected transform: %+v", ot)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBinderStoreChangeRejected(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 60000
+	config.WatchStore = true
+	config.MaxTransformSize = 10
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	reader := binder.Subscribe("")
+
+	// Another tool makes a change too large to merge, which must not vanish unnoticed
+	docStore.Update(store.Document{ID: doc.ID, Content: "hello world, and a whole lot more"})
+	binder.StoreChanged()
+
+	select {
+	case msg := <-reader.MessageRcvChan:
+		if len(msg.Diagnostics) != 1 || !strings.Contains(msg.Diagnostics[0], ErrTransformTooLarge.Error()) {
+			t.Errorf("Wrong diagnostics: %v", msg.Diagnostics)
+		}
+	case ot := <-reader.TransformRcvChan:
+		t.Errorf("Unexpected transform: %+v", ot)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for diagnostics")
+	}
+	select {
+	case e := <-errChan:
+		if e.Diagnostic == nil || len(e.Diagnostic.Problems) != 1 {
+			t.Errorf("Wrong binder error: %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for diagnostic event")
+	}
+}
+
+func TestBinderFlushVersions(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 60000
+	config.FlushVersions = 3
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+	for i, insert := range []string{"a", "b", "c", "d"} {
+		if _, err = portal.SendTransform(OTransform{Position: i, Insert: insert, Version: i + 2}, time.Second); err != nil {
+			t.Fatal(err)
+		}
+		// Listing users waits for the binder to finish with the transform
+		if _, err = binder.GetUsers(time.Second); err != nil {
+			t.Fatal(err)
+		}
+		exp := ""
+		if i >= 2 {
+			exp = "abc"
+		}
+		if stored, _ := docStore.Read(doc.ID); stored.Content != exp {
+			t.Errorf("Wrong stored content after %v transforms: %q != %q", i+1, stored.Content, exp)
+		}
+	}
+}
+
+func TestBinderNotificationPreferences(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	prefs, err := NewPreferences(PreferencesConfig{DigestPeriod: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs.Set(prefs.User("muted"), doc.ID, NotificationPreference{Mode: NotifyMute})
+	prefs.Set(prefs.User("digest"), doc.ID, NotificationPreference{Mode: NotifyDigest})
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, prefs, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	sender := binder.Subscribe("sender")
+	muted := binder.Subscribe("muted")
+	digest := binder.Subscribe("digest")
+	normal := binder.Subscribe("normal")
+
+	sender.SendMessage(ClientMessage{Message: "hello"})
+	select {
+	case msg := <-normal.MessageRcvChan:
+		if msg.Message != "hello" {
+			t.Errorf("Wrong message: %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for message")
+	}
+
+	// Held back messages still carry cursor positions, without their text
+	position := int64(3)
+	sender.SendMessage(ClientMessage{Message: "again", Position: &position})
+	for _, portal := range []BinderPortal{muted, digest} {
+		select {
+		case msg := <-portal.MessageRcvChan:
+			if msg.Message != "" || msg.Position == nil || *msg.Position != position {
+				t.Errorf("Wrong held back message: %v", msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for cursor")
+		}
+	}
+	<-normal.MessageRcvChan
+
+	select {
+	case msg := <-digest.MessageRcvChan:
+		if msg.Digest == nil || msg.Digest.Messages != 2 {
+			t.Errorf("Wrong digest: %v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for digest")
+	}
+	select {
+	case msg := <-muted.MessageRcvChan:
+		t.Errorf("Muted client received: %v", msg)
+	default:
+	}
+}
+
+func TestBinderDocumentMetadata(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	doc.Metadata = map[string]string{"language": "en"}
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer, reader := binder.Subscribe(""), binder.SubscribeReadOnly("")
+	if reader.Document.Metadata["language"] != "en" {
+		t.Errorf("Wrong metadata of subscribed document: %v", reader.Document.Metadata)
+	}
+
+	writer.SendDocumentMetadata(map[string]string{"title": "Greeting", "language": ""})
+	select {
+	case msg := <-reader.MessageRcvChan:
+		if exp, act := map[string]string{"title": "Greeting"}, msg.DocumentMetadata; len(act) != 1 || act["title"] != exp["title"] {
+			t.Errorf("Wrong metadata: %v != %v", act, exp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for metadata")
+	}
+
+	// Read only clients may not change the metadata
+	reader.SendDocumentMetadata(map[string]string{"title": "Vandalised"})
+	select {
+	case msg := <-writer.MessageRcvChan:
+		t.Errorf("Unexpected message: %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Metadata is flushed along with the content, and included for new subscribers
+	late := binder.Subscribe("")
+	if late.Document.Metadata["title"] != "Greeting" || len(late.Document.Metadata) != 1 {
+		t.Errorf("Wrong metadata of subscribed document: %v", late.Document.Metadata)
+	}
+	stored, _ := docStore.Read(doc.ID)
+	if stored.Metadata["title"] != "Greeting" || len(stored.Metadata) != 1 {
+		t.Errorf("Wrong metadata of stored document: %v", stored.Metadata)
+	}
+}
+
+/*
+metadataDroppingStore - Stores documents in memory without their metadata, like the raw codec.
+*/
+type metadataDroppingStore struct {
+	testStore
+}
+
+func (s *metadataDroppingStore) Update(doc store.Document) error {
+	doc.Metadata = nil
+	return s.testStore.Update(doc)
+}
+
+func (s *metadataDroppingStore) KeepsMetadata() bool {
+	return false
+}
+
+func TestBinderMetadataNotKeptByStore(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &metadataDroppingStore{testStore{documents: map[string]store.Document{doc.ID: *doc}}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer := binder.Subscribe("")
+	writer.SendDocumentMetadata(map[string]string{"title": "Greeting"})
+
+	// Each subscription flushes, the metadata must survive flushes after the first.
+	for i := 0; i < 3; i++ {
+		late := binder.Subscribe("")
+		if late.Document.Metadata["title"] != "Greeting" {
+			t.Errorf("Wrong metadata of subscribed document %v: %v", i, late.Document.Metadata)
+		}
+	}
+	if stored, _ := docStore.Read(doc.ID); len(stored.Metadata) != 0 {
+		t.Errorf("Metadata should not be stored: %v", stored.Metadata)
+	}
+}
+
+type failingStore struct {
+	*testStore
+	fail int32
+}
+
+func (s *failingStore) Update(doc store.Document) error {
+	if atomic.LoadInt32(&s.fail) == 1 {
+		return errors.New("store unavailable")
+	}
+	return s.testStore.Update(doc)
+}
+
+func TestBinderFinalFlushError(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &failingStore{testStore: &testStore{documents: map[string]store.Document{doc.ID: *doc}}}
+	binder, err := NewBinder(doc.ID, docStore, DefaultBinderConfig(), errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portal := binder.Subscribe("")
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "x", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&docStore.fail, 1)
+	binder.Close()
+
+	if err = binder.finalFlushError(); err == nil {
+		t.Error("Expected the failed final flush to be reported")
+	}
+}
@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for throttled portals.
+var (
+	ErrThrottleNotSupported = errors.New("throttled delivery is not supported for this client")
+)
+
+// maxThrottlePending - The most transforms held for a throttled client before they are sent early.
+const maxThrottlePending = 1000
+
+/*
+portalThrottle - The state of a client that receives at most one batch of transforms per period.
+*/
+type portalThrottle struct {
+	period  time.Duration
+	pending []OTransform
+	due     time.Time
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+SubscribeThrottled - Returns a read only BinderPortal that receives at most one batch of transforms
+per period through its BatchRcvChan, which holds every transform applied since the last in order.
+This suits lightweight viewers such as status screens and bots. A client that falls a long way
+behind within a period is sent its transforms early rather than holding them indefinitely. User
+updates are delivered as usual.
+*/
+func (b *Binder) SubscribeThrottled(token string, period time.Duration) BinderPortal {
+	if len(token) == 0 {
+		token = util.GenerateStampedUUID()
+	}
+	return b.subscribe(BinderSubscribeBundle{
+		Token:    token,
+		ReadOnly: true,
+		Throttle: period,
+	})
+}
+
+/*
+throttleDue - Returns when the next throttled client is due a transform, or false when no throttled
+clients have transforms pending.
+*/
+func (b *Binder) throttleDue() (time.Time, bool) {
+	var due time.Time
+	for _, c := range b.clients {
+		if c.throttle == nil || len(c.throttle.pending) == 0 {
+			continue
+		}
+		if due.IsZero() || c.throttle.due.Before(due) {
+			due = c.throttle.due
+		}
+	}
+	return due, !due.IsZero()
+}
+
+/*
+scheduleThrottled - Set a timer to fire when the next throttled client is due a transform.
+*/
+func (b *Binder) scheduleThrottled(timer *time.Timer) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	if due, ok := b.throttleDue(); ok {
+		timer.Reset(time.Until(due))
+	}
+}
+
+/*
+queueThrottled - Hold a transform for a throttled client until it is due, sending the held
+transforms straight away once there are too many of them.
+*/
+func (b *Binder) queueThrottled(key string, c BinderClient, ot OTransform) {
+	c.throttle.pending = append(c.throttle.pending, ot)
+	if len(c.throttle.pending) >= maxThrottlePending {
+		b.stats.Incr("binder.throttle.overflow", 1)
+		b.sendThrottled(key, c, time.Now())
+	}
+}
+
+/*
+deliverThrottled - Send each throttled client that is due its pending transforms.
+*/
+func (b *Binder) deliverThrottled(now time.Time) {
+	for key, c := range b.clients {
+		if c.throttle == nil || len(c.throttle.pending) == 0 || now.Before(c.throttle.due) {
+			continue
+		}
+		b.sendThrottled(key, c, now)
+	}
+}
+
+/*
+sendThrottled - Send the pending transforms of a throttled client as a single batch. Clients that
+cannot keep up are kicked as they would be for any broadcast.
+*/
+func (b *Binder) sendThrottled(key string, c BinderClient, now time.Time) {
+	clientKickPeriod := time.Duration(b.config.ClientKickPeriod) * time.Millisecond
+
+	select {
+	case c.BatchChan <- c.throttle.pending:
+		b.stats.Incr("binder.throttle.delivered", 1)
+		b.stats.Incr("binder.throttle.batched", int64(len(c.throttle.pending)))
+		c.throttle.pending = nil
+		c.throttle.due = now.Add(c.throttle.period)
+	case <-time.After(clientKickPeriod):
+		b.kickBlockedClient(key, "throttled batch")
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Capabilities - The document features and limits of a curator, which servers advertise to clients so
+that they can adapt rather than fail on unsupported operations. MaxDocumentSize and MaxTransformSize
+are in bytes, and HistoryLength is the number of past versions of a document that are retained for
+fetching old versions and resuming clients, where zero means none are. Size limits of zero mean
+there is no limit. Presence is whether clients may learn who else is editing a document, binders
+always track it but servers may not offer it.
+*/
+type Capabilities struct {
+	DocumentTypes    []string `json:"document_types" yaml:"document_types"`
+	MaxDocumentSize  uint64   `json:"max_document_size" yaml:"max_document_size"`
+	MaxTransformSize uint64   `json:"max_transform_size" yaml:"max_transform_size"`
+	HistoryLength    int      `json:"history_length" yaml:"history_length"`
+	Presence         bool     `json:"presence" yaml:"presence"`
+}
+
+/*
+documentTypes - The types of document for which a transform model exists.
+*/
+var documentTypes = []string{"text", "json", "rich"}
+
+/*
+lowestLimit - Returns the lowest of two size limits, where zero means unlimited.
+*/
+func lowestLimit(a, b uint64) uint64 {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+/*
+binderCapabilities - The capabilities of binders created with a config, a document size or transform
+size limit applies when either the binder or the transform model sets it, and so the lowest of the
+two is advertised.
+*/
+func binderCapabilities(config BinderConfig) Capabilities {
+	return Capabilities{
+		DocumentTypes:    append([]string{}, documentTypes...),
+		MaxDocumentSize:  lowestLimit(config.MaxDocumentSize, config.ModelConfig.MaxDocumentSize),
+		MaxTransformSize: lowestLimit(config.MaxTransformSize, config.ModelConfig.MaxTransformLength),
+		HistoryLength:    config.HistoryLength,
+		Presence:         true,
+	}
+}
+
+/*
+Restrict - Returns the capabilities that two sets of capabilities have in common, with the lowest of
+each of their limits. Size limits of zero are unlimited.
+*/
+func (c Capabilities) Restrict(other Capabilities) Capabilities {
+	types := []string{}
+	for _, t := range c.DocumentTypes {
+		for _, o := range other.DocumentTypes {
+			if t == o {
+				types = append(types, t)
+				break
+			}
+		}
+	}
+	c.DocumentTypes = types
+	c.MaxDocumentSize = lowestLimit(c.MaxDocumentSize, other.MaxDocumentSize)
+	c.MaxTransformSize = lowestLimit(c.MaxTransformSize, other.MaxTransformSize)
+	if other.HistoryLength < c.HistoryLength {
+		c.HistoryLength = other.HistoryLength
+	}
+	c.Presence = c.Presence && other.Presence
+	return c
+}
+
+/*
+Capabilities - Returns the document features and limits of this curator.
+*/
+func (c *Curator) Capabilities() Capabilities {
+	return binderCapabilities(c.config.BinderConfig)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
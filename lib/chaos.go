@@ -0,0 +1,126 @@
+//go:build chaos
+// +build chaos
+
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ChaosEnabled - True when leaps is built with the chaos tag, which enables fault injection for
+rehearsing incidents. Never run a chaos build in production.
+*/
+const ChaosEnabled = true
+
+/*
+chaosState - The currently injected faults, shared by every binder of the process.
+*/
+var chaosState = struct {
+	flushDelay time.Duration
+	dropRate   float64
+	mutex      sync.RWMutex
+}{}
+
+/*
+SetChaosFlushDelay - Delay every periodic document flush by the given duration, zero disables the
+fault.
+*/
+func SetChaosFlushDelay(delay time.Duration) {
+	chaosState.mutex.Lock()
+	chaosState.flushDelay = delay
+	chaosState.mutex.Unlock()
+}
+
+/*
+SetChaosDropRate - Drop broadcasts to clients with a probability between 0 and 1, zero disables the
+fault.
+*/
+func SetChaosDropRate(rate float64) {
+	chaosState.mutex.Lock()
+	chaosState.dropRate = rate
+	chaosState.mutex.Unlock()
+}
+
+/*
+chaosFlushDelay - The delay to inject before a flush.
+*/
+func chaosFlushDelay() time.Duration {
+	chaosState.mutex.RLock()
+	defer chaosState.mutex.RUnlock()
+	return chaosState.flushDelay
+}
+
+/*
+chaosDropBroadcast - Whether a broadcast to a client should be dropped.
+*/
+func chaosDropBroadcast() bool {
+	chaosState.mutex.RLock()
+	rate := chaosState.dropRate
+	chaosState.mutex.RUnlock()
+	return rate > 0 && rand.Float64() < rate
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+KillRandomSessions - Kick up to count randomly chosen users from the open documents of the curator,
+returns the kicked sessions in the form <document_id>:<user_id>.
+*/
+func (c *Curator) KillRandomSessions(count int, timeout time.Duration) ([]string, error) {
+	users, err := c.GetUsers(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []struct{ doc, user string }{}
+	for doc, docUsers := range users {
+		for _, user := range docUsers {
+			sessions = append(sessions, struct{ doc, user string }{doc, user})
+		}
+	}
+
+	killed := []string{}
+	for _, i := range rand.Perm(len(sessions)) {
+		if len(killed) >= count {
+			break
+		}
+		if err = c.KickUser(sessions[i].doc, sessions[i].user, timeout); err != nil {
+			return killed, err
+		}
+		c.stats.Incr("curator.chaos.killed_session", 1)
+		killed = append(killed, sessions[i].doc+":"+sessions[i].user)
+	}
+	return killed, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,106 @@
+//go:build chaos
+// +build chaos
+
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestChaosDropBroadcasts(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	sender := binder.Subscribe("")
+	receiver := binder.Subscribe("")
+
+	SetChaosDropRate(1)
+	if _, err = sender.SendTransform(OTransform{Position: 0, Insert: "a", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-receiver.TransformRcvChan:
+		t.Error("Broadcast was not dropped")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	SetChaosDropRate(0)
+	if _, err = sender.SendTransform(OTransform{Position: 0, Insert: "b", Version: 3}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-receiver.TransformRcvChan:
+	case <-time.After(time.Second):
+		t.Error("Broadcast was dropped with chaos disabled")
+	}
+}
+
+func TestChaosFlushDelay(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 10
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, nil, config, nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	SetChaosFlushDelay(300 * time.Millisecond)
+	defer SetChaosFlushDelay(0)
+
+	// The binder keeps serving clients whilst its flush is held back
+	portal := binder.Subscribe("")
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "a", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = binder.GetUsers(100 * time.Millisecond); err != nil {
+		t.Errorf("Binder was blocked by the flush delay: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if stored, _ := docStore.Read(doc.ID); stored.Content != "hello world" {
+		t.Errorf("Flush was not delayed: %v", stored.Content)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if stored, _ := docStore.Read(doc.ID); stored.Content != "ahello world" {
+		t.Errorf("Delayed flush did not happen: %v", stored.Content)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+CommentFileConfig - Holds configuration options specific to the file based comment store.
+*/
+type CommentFileConfig struct {
+	Directory string `json:"directory" yaml:"directory"`
+}
+
+/*
+CommentStoreConfig - Holds generic configuration options for a comment store. The default type of
+none leaves anchored comments unpersisted, so that they only reach the clients connected at the
+time.
+*/
+type CommentStoreConfig struct {
+	Type       string            `json:"type" yaml:"type"`
+	FileConfig CommentFileConfig `json:"file" yaml:"file"`
+}
+
+/*
+DefaultCommentStoreConfig - Returns a default comment store configuration, which is disabled.
+*/
+func DefaultCommentStoreConfig() CommentStoreConfig {
+	return CommentStoreConfig{
+		Type: "none",
+		FileConfig: CommentFileConfig{
+			Directory: "",
+		},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the CommentStore type.
+var (
+	ErrInvalidCommentStoreType = errors.New("invalid comment store type")
+	ErrNoCommentDirectory      = errors.New("comment store directory was not specified")
+)
+
+/*
+CommentStore - Implemented by types able to persist the anchored comments made on documents. Chat
+messages are ephemeral and are never stored.
+*/
+type CommentStore interface {
+	// Append - Append a comment to those of a document.
+	Append(id string, comment Comment) error
+	// Read - Read all comments of a document in the order they were appended.
+	Read(id string) ([]Comment, error)
+}
+
+/*
+CommentStoreFactory - Returns a comment store based on a configuration object, or nil if comments
+are not persisted.
+*/
+func CommentStoreFactory(config CommentStoreConfig) (CommentStore, error) {
+	switch config.Type {
+	case "none", "":
+		return nil, nil
+	case "memory":
+		return NewMemoryCommentStore(), nil
+	case "file":
+		return NewFileCommentStore(config.FileConfig)
+	}
+	return nil, ErrInvalidCommentStoreType
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+MemoryCommentStore - Keeps comments in memory, which does not survive the process. Mostly useful for
+testing.
+*/
+type MemoryCommentStore struct {
+	comments map[string][]Comment
+	mutex    sync.Mutex
+}
+
+/*
+NewMemoryCommentStore - Returns an empty MemoryCommentStore.
+*/
+func NewMemoryCommentStore() *MemoryCommentStore {
+	return &MemoryCommentStore{
+		comments: map[string][]Comment{},
+	}
+}
+
+/*
+Append - Append a comment to those of a document.
+*/
+func (m *MemoryCommentStore) Append(id string, comment Comment) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.comments[id] = append(m.comments[id], comment)
+	return nil
+}
+
+/*
+Read - Read the comments of a document.
+*/
+func (m *MemoryCommentStore) Read(id string) ([]Comment, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	comments := make([]Comment, len(m.comments[id]))
+	copy(comments, m.comments[id])
+	return comments, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+FileCommentStore - Stores comments in files within a directory, one file per document holding a
+JSON encoded comment per line.
+*/
+type FileCommentStore struct {
+	config CommentFileConfig
+	mutex  sync.Mutex
+}
+
+/*
+NewFileCommentStore - Returns a FileCommentStore writing to the configured directory.
+*/
+func NewFileCommentStore(config CommentFileConfig) (*FileCommentStore, error) {
+	if len(config.Directory) == 0 {
+		return nil, ErrNoCommentDirectory
+	}
+	if err := os.MkdirAll(config.Directory, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileCommentStore{config: config}, nil
+}
+
+/*
+commentsPath - The path of the comments file for a document.
+*/
+func (f *FileCommentStore) commentsPath(id string) string {
+	return filepath.Join(f.config.Directory, id+".comments")
+}
+
+/*
+Append - Append a comment to the comments file of a document.
+*/
+func (f *FileCommentStore) Append(id string, comment Comment) error {
+	line, err := json.Marshal(comment)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	path := f.commentsPath(id)
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return fmt.Errorf("cannot create comments path for document: %v, err: %v", id, err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+/*
+Read - Read the comments of a document. A partially written final line is ignored.
+*/
+func (f *FileCommentStore) Read(id string) ([]Comment, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	file, err := os.Open(f.commentsPath(id))
+	if os.IsNotExist(err) {
+		return []Comment{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	comments := []Comment{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var comment Comment
+		if err = json.Unmarshal(scanner.Bytes(), &comment); err != nil {
+			break
+		}
+		comments = append(comments, comment)
+	}
+	return comments, scanner.Err()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
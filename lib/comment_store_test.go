@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestFileCommentStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_comments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cStore, err := NewFileCommentStore(CommentFileConfig{Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	comments := []Comment{
+		{ID: "1", UserID: "alice", Text: "typo here", Anchor: &CommentAnchor{Position: 3, Length: 4}},
+		{ID: "2", UserID: "bob", Text: "fixed", Anchor: &CommentAnchor{Position: 3}},
+	}
+	for _, c := range comments {
+		if err = cStore.Append("nested/doc", c); err != nil {
+			t.Errorf("Append error: %v", err)
+		}
+	}
+	read, err := cStore.Read("nested/doc")
+	if err != nil {
+		t.Errorf("Read error: %v", err)
+	}
+	if !reflect.DeepEqual(comments, read) {
+		t.Errorf("Wrong comments: %v != %v", comments, read)
+	}
+	if read, err = cStore.Read("missing"); err != nil || len(read) != 0 {
+		t.Errorf("Expected no comments: %v, %v", read, err)
+	}
+
+	if _, err = CommentStoreFactory(CommentStoreConfig{Type: "carrier pigeon"}); err != ErrInvalidCommentStoreType {
+		t.Errorf("Wrong error: %v != %v", err, ErrInvalidCommentStoreType)
+	}
+}
+
+func TestBinderComments(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	cStore := NewMemoryCommentStore()
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, cStore, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer, reader := binder.Subscribe(""), binder.SubscribeReadOnly("")
+	writer.SendComment(Comment{Text: "hi all"})
+	writer.SendComment(Comment{Text: "should be world", Anchor: &CommentAnchor{Position: 6, Length: 5}})
+
+	for _, exp := range []string{"hi all", "should be world"} {
+		select {
+		case msg := <-reader.MessageRcvChan:
+			if msg.Comment == nil || msg.Comment.Text != exp {
+				t.Fatalf("Wrong comment: %v != %v", msg.Comment, exp)
+			}
+			if msg.Comment.UserID != writer.UserID || len(msg.Comment.ID) == 0 || msg.Comment.Sent == 0 {
+				t.Errorf("Comment was not stamped: %v", msg.Comment)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for comment")
+		}
+	}
+
+	// Empty comments and comments of read only clients are refused
+	writer.SendComment(Comment{Text: ""})
+	reader.SendComment(Comment{Text: "spectating", Anchor: &CommentAnchor{Position: 0, Length: 5}})
+	select {
+	case msg := <-reader.MessageRcvChan:
+		t.Errorf("Unexpected message: %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Only anchored comments are persisted and held for new subscribers
+	late := binder.Subscribe("")
+	if len(late.Comments) != 1 || late.Comments[0].Text != "should be world" {
+		t.Errorf("Wrong held comments: %v", late.Comments)
+	}
+
+	// Comments are written in the background, closing waits for them
+	binder.Close()
+	if stored, _ := cStore.Read(doc.ID); len(stored) != 1 {
+		t.Errorf("Wrong stored comments: %v", stored)
+	}
+
+	reopened, err := newBinder(doc.ID, docStore, nil, nil, nil, cStore, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if portal := reopened.Subscribe(""); len(portal.Comments) != 1 {
+		t.Errorf("Wrong comments after reopening: %v", portal.Comments)
+	}
+}
@@ -25,7 +25,10 @@ package lib
 import (
 	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jeffail/leaps/lib/auth"
@@ -38,10 +41,37 @@ import (
  */
 
 /*
-CuratorConfig - Holds configuration options for a curator.
+CuratorConfig - Holds configuration options for a curator. PreloadDocuments lists the IDs of
+documents to bind to when Preload is called, usually at startup. Replica determines whether read
+only clients are served by read replicas of documents. DiagnosticWebhook receives a DiagnosticEvent
+each time a flushed document fails validation, and LifecycleWebhook receives a LifecycleEvent each
+time a document is created, gains its first client, loses its last client or is flushed. When
+ShutdownReportPath is set the ShutdownReport of draining the curator is written there as JSON. Open
+binders are partitioned by document ID across Shards, each with its own lock and loop, so that
+joining and creating documents is not serialised across every document of the curator.
+SystemDocuments configures the documents that hold state consumed by the server, such as the banner
+shown to every client. Audit configures the structured log of document events kept for compliance,
+and Statsd optionally emits the operational metrics of the curator to a statsd server.
 */
 type CuratorConfig struct {
-	BinderConfig BinderConfig `json:"binder" yaml:"binder"`
+	BinderConfig         BinderConfig          `json:"binder" yaml:"binder"`
+	TransformStoreConfig TransformStoreConfig  `json:"transform_log" yaml:"transform_log"`
+	LatencyWindow        int                   `json:"latency_window" yaml:"latency_window"`
+	PreloadDocuments     []string              `json:"preload_documents" yaml:"preload_documents"`
+	Replica              ReplicaConfig         `json:"replica" yaml:"replica"`
+	DiagnosticWebhook    WebhookConfig         `json:"diagnostic_webhook" yaml:"diagnostic_webhook"`
+	LifecycleWebhook     WebhookConfig         `json:"lifecycle_webhook" yaml:"lifecycle_webhook"`
+	ShutdownReportPath   string                `json:"shutdown_report_path" yaml:"shutdown_report_path"`
+	MaxOpenBinders       int                   `json:"max_open_binders" yaml:"max_open_binders"`
+	Shards               int                   `json:"shards" yaml:"shards"`
+	Cluster              RelayConfig           `json:"cluster" yaml:"cluster"`
+	Preferences          PreferencesConfig     `json:"notification_preferences" yaml:"notification_preferences"`
+	CommentStoreConfig   CommentStoreConfig    `json:"comment_store" yaml:"comment_store"`
+	IDPolicy             IDPolicyConfig        `json:"id_policy" yaml:"id_policy"`
+	Aliases              AliasesConfig         `json:"aliases" yaml:"aliases"`
+	SystemDocuments      SystemDocumentsConfig `json:"system_documents" yaml:"system_documents"`
+	Audit                AuditConfig           `json:"audit" yaml:"audit"`
+	Statsd               StatsdConfig          `json:"statsd" yaml:"statsd"`
 }
 
 /*
@@ -50,7 +80,24 @@ each field.
 */
 func DefaultCuratorConfig() CuratorConfig {
 	return CuratorConfig{
-		BinderConfig: DefaultBinderConfig(),
+		BinderConfig:         DefaultBinderConfig(),
+		TransformStoreConfig: DefaultTransformStoreConfig(),
+		LatencyWindow:        1000,
+		PreloadDocuments:     []string{},
+		Replica:              NewReplicaConfig(),
+		DiagnosticWebhook:    NewWebhookConfig(),
+		LifecycleWebhook:     newLifecycleWebhookConfig(),
+		ShutdownReportPath:   "",
+		MaxOpenBinders:       0,
+		Shards:               16,
+		Cluster:              DefaultRelayConfig(),
+		Preferences:          NewPreferencesConfig(),
+		CommentStoreConfig:   DefaultCommentStoreConfig(),
+		IDPolicy:             NewIDPolicyConfig(),
+		Aliases:              NewAliasesConfig(),
+		SystemDocuments:      NewSystemDocumentsConfig(),
+		Audit:                NewAuditConfig(),
+		Statsd:               NewStatsdConfig(),
 	}
 }
 
@@ -59,9 +106,35 @@ func DefaultCuratorConfig() CuratorConfig {
 
 // Errors for the Curator type.
 var (
-	ErrBinderNotFound = errors.New("binder was not found")
+	ErrBinderNotFound    = errors.New("binder was not found")
+	ErrDocumentOpen      = errors.New("document is open for editing")
+	ErrStoreNotDeletable = errors.New("document store is unable to delete documents")
+	ErrMergeNotSupported = errors.New("only text documents can be merged")
+	ErrCuratorDraining   = errors.New("curator is draining ahead of shutting down")
+	ErrTooManyBinders    = errors.New("curator has reached its limit of open documents")
+	ErrUnauthorised      = errors.New("token is not authorised for the document")
+	ErrStoreNotListable  = errors.New("document store is unable to list documents")
 )
 
+// bindAttempts - The most binders a client is subscribed to when each closes before it can join.
+const bindAttempts = 3
+
+/*
+EvictionEvent - Describes a binder that was released from memory after being idle, Version is the
+version of the document at the time it was flushed and Idle is how long it had been without clients.
+*/
+type EvictionEvent struct {
+	ID      string        `json:"id" yaml:"id"`
+	Version int           `json:"version" yaml:"version"`
+	Idle    time.Duration `json:"idle" yaml:"idle"`
+	Evicted time.Time     `json:"evicted" yaml:"evicted"`
+}
+
+/*
+EvictionHook - A function called by a curator each time it evicts a binder.
+*/
+type EvictionHook func(event EvictionEvent)
+
 /*
 Curator - A structure designed to keep track of a live collection of Binders. Assists prospective
 clients in locating their target Binders, and when necessary creates new Binders.
@@ -71,18 +144,47 @@ The curator is fully in control of the binders, and manages their life cycles in
 type Curator struct {
 	config        CuratorConfig
 	store         store.Store
+	binderStore   store.Store
+	transforms    TransformStore
+	relay         Relay
+	preferences   *Preferences
+	bans          *Bans
+	comments      CommentStore
+	ids           *IDPolicy
+	aliases       *Aliases
 	log           *log.Logger
 	stats         *log.Stats
 	authenticator auth.Authenticator
+	namespace     *Namespace
+	recovery      RecoveryReport
+	latency       *LatencyTracker
+	metrics       *Metrics
+	statsd        *Statsd
+	audit         *Auditor
+	evictionHooks []EvictionHook
+
+	diagnosticHooks []DiagnosticHook
+	lifecycleHooks  []LifecycleHook
+
+	// The banner shown to every client, read from the announcements system document
+	banner string
+
+	// Binders and their read replicas, partitioned across shards, openCount and draining are
+	// accessed atomically
+	shards    []*curatorShard
+	openCount int64
+	draining  int32
+	shutdown  *ShutdownReport
+	mutex     sync.RWMutex
 
-	// Binders
-	openBinders map[string]*Binder
-	binderMutex sync.RWMutex
+	// Leases suspected of being stale, and since when, for breaking them automatically
+	suspects     map[string]suspectLease
+	suspectMutex sync.Mutex
 
 	// Control channels
-	errorChan  chan BinderError
 	closeChan  chan struct{}
 	closedChan chan struct{}
+	closeOnce  sync.Once
 }
 
 /*
@@ -96,72 +198,390 @@ func NewCurator(
 	store store.Store,
 ) (*Curator, error) {
 
+	transforms, err := TransformStoreFactory(config.TransformStoreConfig)
+	if err != nil {
+		return nil, err
+	}
+	relay, err := RelayFactory(config.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	preferences, err := NewPreferences(config.Preferences)
+	if err != nil {
+		return nil, err
+	}
+	preferences.identifyUsers(auth)
+	comments, err := CommentStoreFactory(config.CommentStoreConfig)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := NewIDPolicy(config.IDPolicy)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := NewAliases(config.Aliases)
+	if err != nil {
+		return nil, err
+	}
+	auditSink, err := AuditSinkFactory(config.Audit, log, stats)
+	if err != nil {
+		return nil, err
+	}
+	metrics := NewMetrics()
+	var statsd *Statsd
+	if len(config.Statsd.Address) > 0 {
+		if statsd, err = NewStatsd(config.Statsd); err != nil {
+			return nil, err
+		}
+		metrics.EmitTo(statsd)
+	}
+
 	curator := Curator{
 		config:        config,
 		store:         store,
+		binderStore:   store,
+		transforms:    transforms,
+		relay:         trackRelay(relay, config.Cluster.UpgradeCompatibility),
+		preferences:   preferences,
+		bans:          NewBans(),
+		comments:      comments,
+		ids:           ids,
+		aliases:       aliases,
 		log:           log.NewModule(":curator"),
 		stats:         stats,
 		authenticator: auth,
-		openBinders:   make(map[string]*Binder),
-		errorChan:     make(chan BinderError, 10),
+		latency:       NewLatencyTracker(config.LatencyWindow),
+		metrics:       metrics,
+		statsd:        statsd,
+		audit:         NewAuditor(auditSink, log, stats),
+		shards:        newCuratorShards(config.Shards),
+		suspects:      map[string]suspectLease{},
 		closeChan:     make(chan struct{}),
 		closedChan:    make(chan struct{}),
 	}
-	go curator.loop()
+	if curator.relay != nil {
+		curator.binderStore = fencedStore{Store: store, source: curator.relay}
+	}
+	if transforms != nil {
+		curator.recovery = recoverTransformLogs(store, transforms, curator.log, stats)
+	}
+	if len(config.DiagnosticWebhook.URL) > 0 {
+		webhook, err := NewWebhook(config.DiagnosticWebhook, log, stats)
+		if err != nil {
+			return nil, err
+		}
+		curator.diagnosticHooks = append(curator.diagnosticHooks, func(event DiagnosticEvent) {
+			webhook.Post(event)
+		})
+	}
+	if len(config.LifecycleWebhook.URL) > 0 {
+		webhook, err := NewWebhook(config.LifecycleWebhook, log, stats)
+		if err != nil {
+			return nil, err
+		}
+		curator.lifecycleHooks = append(curator.lifecycleHooks, func(event LifecycleEvent) {
+			webhook.Post(event)
+		})
+	}
+	changes, err := curator.watchStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range curator.shards {
+		go curator.shardLoop(s)
+	}
+	go curator.loop(changes)
+	if len(config.SystemDocuments.Prefix) > 0 && config.SystemDocuments.PollPeriod > 0 {
+		go curator.systemLoop()
+	}
 
 	return &curator, nil
 }
 
+/*
+GetRecoveryReport - Returns the outcome of the transform log recovery scan performed when the curator
+was created, which lists any documents that could not be recovered.
+*/
+func (c *Curator) GetRecoveryReport() RecoveryReport {
+	return c.recovery
+}
+
+/*
+GetLatencyReport - Returns the rolling percentiles of transform latencies, across all documents and
+for each open document.
+*/
+func (c *Curator) GetLatencyReport() LatencyReport {
+	return c.latency.Report()
+}
+
+/*
+WriteMetrics - Write the operational metrics of this curator and its binders in the Prometheus text
+exposition format.
+*/
+func (c *Curator) WriteMetrics(w io.Writer) error {
+	return c.metrics.WritePrometheus(w)
+}
+
+/*
+UseNamespace - Set the namespace from which the binders of this curator draw flush slots and
+broadcast bandwidth. This allows multiple curators (usually routed to by a net.Mux) to fairly share
+the resources of a single process. Must be called before the curator is used.
+*/
+func (c *Curator) UseNamespace(namespace *Namespace) {
+	c.mutex.Lock()
+	c.namespace = namespace
+	c.mutex.Unlock()
+}
+
+/*
+UseFencing - Set the source of fencing tokens for the flushes of the binders of this curator, usually
+the distributed lock that grants leases over documents when multiple nodes share a store. Stores that
+support conditional writes then reject flushes carrying a stale token, which shuts the offending
+binder down. The leases of a relay are used as the source by default. Must be called before the
+curator is used, and after UseRelay.
+*/
+func (c *Curator) UseFencing(source FencingSource) {
+	c.mutex.Lock()
+	c.binderStore = fencedStore{Store: c.store, source: source}
+	c.mutex.Unlock()
+}
+
+/*
+UseRelay - Set the relay through which the binders of this curator synchronise with the binders of
+the same documents on other nodes, replacing any relay created from the cluster configuration. The
+leases of the relay then fence the flushes of binders. Must be called before the curator is used.
+*/
+func (c *Curator) UseRelay(relay Relay) {
+	c.mutex.Lock()
+	c.relay = trackRelay(relay, c.config.Cluster.UpgradeCompatibility)
+	if c.relay != nil {
+		c.binderStore = fencedStore{Store: c.store, source: c.relay}
+	}
+	c.mutex.Unlock()
+}
+
+/*
+UseAuditSink - Set the sink of the audit log, replacing any sink created from the audit
+configuration. Must be called before the curator is used.
+*/
+func (c *Curator) UseAuditSink(sink AuditSink) {
+	c.mutex.Lock()
+	c.audit.close()
+	c.audit = NewAuditor(sink, c.log, c.stats)
+	c.mutex.Unlock()
+}
+
+/*
+AddEvictionHook - Register a function to be called each time an idle binder is evicted. Hooks are
+called in order from the curator loop and must therefore return quickly.
+*/
+func (c *Curator) AddEvictionHook(hook EvictionHook) {
+	c.mutex.Lock()
+	c.evictionHooks = append(c.evictionHooks, hook)
+	c.mutex.Unlock()
+}
+
+/*
+AddDiagnosticHook - Register a function to be called each time a flushed document fails validation.
+Hooks are called in order from the curator loop and must therefore return quickly.
+*/
+func (c *Curator) AddDiagnosticHook(hook DiagnosticHook) {
+	c.mutex.Lock()
+	c.diagnosticHooks = append(c.diagnosticHooks, hook)
+	c.mutex.Unlock()
+}
+
+/*
+Preload - Bind to each document of the PreloadDocuments config ahead of any client, so that the first
+clients after a restart do not wait on the document store. Preloaded binders that nobody joins are
+closed after the usual period of inactivity. Must be called after UseNamespace, if used at all.
+*/
+func (c *Curator) Preload() {
+	for _, id := range c.config.PreloadDocuments {
+		if _, ok := c.openBinder(id); ok {
+			continue
+		}
+		if _, err := c.bindExisting(id); err == ErrTooManyBinders {
+			c.stats.Incr("curator.preload.rejected_capacity", 1)
+			c.log.Warnf("Skipping preload of document %v: %v\n", id, err)
+			continue
+		} else if err != nil {
+			c.stats.Incr("curator.preload.failed", 1)
+			c.log.Errorf("Failed to preload document %v: %v\n", id, err)
+			continue
+		}
+		c.stats.Incr("curator.preload.success", 1)
+	}
+}
+
 /*
 Close - Shut the curator and all subsequent binders down. This call blocks until the shut down is
-finished, and you must ensure that this curator cannot be accessed after closing.
+finished, and you must ensure that this curator cannot be accessed after closing. Calls after the
+first have no effect.
 */
 func (c *Curator) Close() {
 	c.log.Debugln("Close called")
-	c.closeChan <- struct{}{}
+	c.closeOnce.Do(func() {
+		c.closeChan <- struct{}{}
+	})
 	<-c.closedChan
 }
 
 /*
-loop - The main loop of the curator. Two channels are listened to:
+Drain - Gracefully shut the curator down. New documents and subscriptions are refused with
+ErrCuratorDraining, and the clients of every open binder are notified of the impending shut down.
+Clients are then given until the grace period expires to leave, after which every binder is flushed
+and the curator is closed. Returns the first flush error encountered, although the curator is closed
+regardless, and so the same rules apply after draining as after Close. The outcome is recorded in a
+ShutdownReport, see GetShutdownReport.
+*/
+func (c *Curator) Drain(grace time.Duration) error {
+	c.log.Infoln("Draining curator")
+	report := newShutdownReport()
+
+	// Binders are only opened under the lock of their shard after checking for draining, and so
+	// every binder opened after this point is refused.
+	atomic.StoreInt32(&c.draining, 1)
+	binders := c.allBinders()
+
+	deadline := time.Now().Add(grace)
+	for _, b := range binders {
+		if b.isClosed() {
+			continue
+		}
+		if err := b.Drain(grace); err != nil {
+			c.log.Errorf("Failed to drain binder (%v): %v\n", b.ID, err)
+		}
+	}
+
+	// Wait for clients to leave of their own accord, draining binders signal once they are empty
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	for _, b := range binders {
+		if !b.awaitDrained(timeout.C) {
+			break
+		}
+	}
 
-- Error channel, used by active binders to request a shut down, either due to inactivity or an error
-having occurred. The curator then calls close on it and removes it from the list of binders.
+	var flushErr error
+	for _, b := range binders {
+		// Binders that closed in the meantime performed their final flush already
+		if b.isClosed() {
+			if err := b.finalFlushError(); err != nil {
+				report.Unflushed = append(report.Unflushed, UnflushedDocument{ID: b.ID, Error: err.Error()})
+				if flushErr == nil {
+					flushErr = err
+				}
+			} else {
+				report.Flushed = append(report.Flushed, b.ID)
+			}
+			continue
+		}
+		if users, err := b.GetUsers(grace); err == nil && len(users) > 0 {
+			report.DisconnectedClients[b.ID] = users
+		}
+		if _, err := b.Snapshot(grace); err != nil {
+			c.stats.Incr("curator.drain.flush_error", 1)
+			c.log.Errorf("Failed to flush binder (%v) while draining: %v\n", b.ID, err)
+			report.Unflushed = append(report.Unflushed, UnflushedDocument{ID: b.ID, Error: err.Error()})
+			if flushErr == nil {
+				flushErr = err
+			}
+		} else {
+			report.Flushed = append(report.Flushed, b.ID)
+		}
+	}
+
+	c.Close()
+	report.finish()
+
+	c.mutex.Lock()
+	c.shutdown = &report
+	c.mutex.Unlock()
+
+	c.log.Infof("Drained in %vms, %v documents flushed, %v unflushed, clients of %v documents disconnected\n",
+		report.DrainDuration, len(report.Flushed), len(report.Unflushed), len(report.DisconnectedClients))
+	if path := c.config.ShutdownReportPath; len(path) > 0 {
+		if err := report.WriteFile(path); err != nil {
+			c.stats.Incr("curator.drain.report_error", 1)
+			c.log.Errorf("Failed to write shutdown report: %v\n", err)
+		}
+	}
+
+	c.stats.Incr("curator.drain.success", 1)
+	return flushErr
+}
+
+/*
+GetShutdownReport - Returns the outcome of draining the curator, the boolean is false if the curator
+has not finished draining.
+*/
+func (c *Curator) GetShutdownReport() (ShutdownReport, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.shutdown == nil {
+		return ShutdownReport{}, false
+	}
+	return *c.shutdown, true
+}
+
+/*
+isDraining - Returns whether the curator has started draining.
+*/
+func (c *Curator) isDraining() bool {
+	return atomic.LoadInt32(&c.draining) == 1
+}
+
+/*
+watchStore - Begin watching the store for documents modified outside of leaps when binders are
+configured to merge such changes and the store is able to watch for them. Watching stops once the
+curator is closed.
+*/
+func (c *Curator) watchStore() (<-chan string, error) {
+	watcher, ok := c.store.(store.Watcher)
+	if !c.config.BinderConfig.WatchStore || !ok {
+		return nil, nil
+	}
+	changes, err := watcher.Watch(c.closedChan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch store: %v", err)
+	}
+	c.log.Infoln("Watching store for changes made outside of leaps")
+	return changes, nil
+}
+
+/*
+loop - The main loop of the curator, shut down requests of binders are handled by the loops of their
+shards, see shardLoop. Two channels are listened to:
+
+- Store changes channel, which carries the IDs of documents modified in the store outside of leaps
+when the store is watched. The binder of each such document, if open, is notified of the change.
 
 - Close channel, used by the owner of the curator to instigate a clean shut down. The curator then
-forwards to call to all binders and closes itself.
+forwards to call to all shards and closes itself.
 */
-func (c *Curator) loop() {
+func (c *Curator) loop(storeChanges <-chan string) {
 	c.log.Debugln("Loop called")
 	for {
 		select {
-		case err := <-c.errorChan:
-			if err.Err != nil {
-				c.stats.Incr("curator.binder_chan.error", 1)
-				c.log.Errorf("Binder (%v) %v\n", err.ID, err.Err)
-			} else {
-				c.log.Infof("Binder (%v) has requested shutdown\n", err.ID)
+		case id, open := <-storeChanges:
+			if !open {
+				storeChanges = nil
+				continue
 			}
-			c.binderMutex.Lock()
-			if b, ok := c.openBinders[err.ID]; ok {
-				b.Close()
-				delete(c.openBinders, err.ID)
-				c.log.Infof("Binder (%v) was closed\n", err.ID)
-				c.stats.Incr("curator.binder_shutdown.success", 1)
-				c.stats.Decr("curator.open_binders", 1)
-			} else {
-				c.log.Errorf("Binder (%v) was not located in map\n", err.ID)
-				c.stats.Incr("curator.binder_shutdown.error", 1)
+			if b, ok := c.openBinder(id); ok {
+				c.stats.Incr("curator.store_change.open", 1)
+				b.StoreChanged()
 			}
-			c.binderMutex.Unlock()
 		case <-c.closeChan:
-			c.log.Infoln("Received call to close, forwarding message to binders")
-			c.binderMutex.Lock()
-			for _, b := range c.openBinders {
-				b.Close()
-				c.stats.Decr("curator.open_binders", 1)
+			c.log.Infoln("Received call to close, forwarding message to shards")
+			for _, s := range c.shards {
+				close(s.closeChan)
+				<-s.closedChan
 			}
-			c.binderMutex.Unlock()
+			c.audit.close()
+			c.statsd.Close()
 			close(c.closedChan)
 			return
 		}
@@ -177,13 +597,7 @@ KickUser - Remove a particular user from a document, requires the respective use
 func (c *Curator) KickUser(documentID, userID string, timeout time.Duration) error {
 	c.log.Debugf("attempting to kick user %v from document %v\n", documentID, userID)
 
-	c.binderMutex.Lock()
-
-	// Check for existing binder
-	binder, ok := c.openBinders[documentID]
-
-	c.binderMutex.Unlock()
-
+	binder, ok := c.openBinder(documentID)
 	if !ok {
 		c.stats.Incr("curator.kick_user.error", 1)
 		c.log.Errorf("Failed to kick user %v from %v: Document was not open\n", userID, documentID)
@@ -200,17 +614,460 @@ func (c *Curator) KickUser(documentID, userID string, timeout time.Duration) err
 }
 
 /*
-GetUsers - Return a full list of all connected users of all open documents.
+BanUser - Disconnect a user from a document and refuse their subscriptions for a duration, including
+those of read replicas. A duration of zero or less lifts an existing ban. Bans are kept by the
+curator, and so documents that are not open may be banned from and bans survive the document being
+closed.
 */
-func (c *Curator) GetUsers(timeout time.Duration) (map[string][]string, error) {
-	openBinders := []*Binder{}
+func (c *Curator) BanUser(documentID, userID string, duration, timeout time.Duration) error {
+	s := c.shard(documentID)
+	s.mutex.RLock()
+	binder, ok := s.binders[documentID]
+	replicas := append([]*Replica{}, s.replicas[documentID]...)
+	s.mutex.RUnlock()
+
+	if !ok {
+		c.bans.Ban(documentID, userID, duration)
+		c.stats.Incr("curator.ban_user.success", 1)
+		return nil
+	}
+	if err := binder.Ban(userID, duration, timeout); err != nil {
+		c.stats.Incr("curator.ban_user.error", 1)
+		return err
+	}
+	if duration > 0 {
+		for _, r := range replicas {
+			if err := r.Kick(userID, timeout); err != nil {
+				c.stats.Incr("curator.ban_user.error", 1)
+				return err
+			}
+		}
+	}
+
+	c.stats.Incr("curator.ban_user.success", 1)
+	return nil
+}
+
+/*
+GetDocumentVersion - Reconstruct a document as it was at a past version of its latest binding,
+requires read only access to the document. Versions are served from the history of the binder of an
+open document, and otherwise from the recording of the document when recording is enabled, which
+also holds the versions that binders no longer retain. Documents that are neither open nor recorded
+result in ErrBinderNotFound.
+*/
+func (c *Curator) GetDocumentVersion(token, documentID string, version int, timeout time.Duration) (store.Document, error) {
+	if _, err := c.authorise(token, documentID, auth.AccessRead, "get_version"); err != nil {
+		return store.Document{}, err
+	}
+
+	doc, err := store.Document{}, ErrBinderNotFound
+	if binder, ok := c.openBinder(documentID); ok {
+		doc, err = binder.GetVersion(version, timeout)
+	}
+	if err == ErrBinderNotFound || err == ErrVersionNotRetained {
+		if recorded, rErr := c.recordedVersion(documentID, version); rErr != ErrRecordingDisabled {
+			doc, err = recorded, rErr
+		}
+	}
+	if err != nil {
+		c.stats.Incr("curator.get_version.error", 1)
+		return doc, err
+	}
+
+	c.stats.Incr("curator.get_version.success", 1)
+	return doc, nil
+}
+
+/*
+recordedVersion - Reconstruct a document at a version of its latest binding from its recording.
+*/
+func (c *Curator) recordedVersion(documentID string, version int) (store.Document, error) {
+	directory := c.config.BinderConfig.Recorder.Directory
+	if len(directory) == 0 {
+		return store.Document{}, ErrRecordingDisabled
+	}
+	path, err := RecordingPath(directory, documentID)
+	if err != nil {
+		return store.Document{}, err
+	}
+	recording, err := ReadRecording(path)
+	if err != nil {
+		return store.Document{}, err
+	}
+	doc, err := recording.DocumentAt(version)
+	doc.ID = documentID
+	return doc, err
+}
+
+/*
+GetDocument - Read the latest flushed content of a document from the store, changes made since the
+last flush of an open document are not included.
+*/
+func (c *Curator) GetDocument(documentID string) (store.Document, error) {
+	doc, err := c.store.Read(documentID)
+	if err != nil {
+		c.stats.Incr("curator.get_document.error", 1)
+		return doc, err
+	}
+	c.stats.Incr("curator.get_document.success", 1)
+	return doc, nil
+}
+
+/*
+GetLiveDocument - Read the latest content of a document. When the document is open the live content
+is read from its binder without flushing it, including transforms that have not been flushed yet,
+otherwise the document is read from the store as with GetDocument.
+*/
+func (c *Curator) GetLiveDocument(documentID string, timeout time.Duration) (store.Document, error) {
+	binder, ok := c.openBinder(documentID)
+	if !ok {
+		return c.GetDocument(documentID)
+	}
+	snapshot, err := binder.Peek(timeout)
+	if err != nil {
+		c.stats.Incr("curator.get_document.error", 1)
+		return store.Document{}, err
+	}
+	c.stats.Incr("curator.get_document.live", 1)
+	return snapshot.Document, nil
+}
+
+/*
+DocumentExport - The content of a document at a point in time. Versions are only counted by the
+binders of open documents, and so Version and Epoch are only set when Open is.
+*/
+type DocumentExport struct {
+	Document store.Document `json:"document"`
+	Version  int            `json:"version"`
+	Epoch    string         `json:"epoch,omitempty"`
+	Open     bool           `json:"open"`
+}
+
+/*
+ExportDocument - Read the latest content of a document along with its version, open documents are
+read from their binder without flushing them.
+*/
+func (c *Curator) ExportDocument(documentID string, timeout time.Duration) (DocumentExport, error) {
+	binder, ok := c.openBinder(documentID)
+	if !ok {
+		doc, err := c.GetDocument(documentID)
+		if err != nil {
+			c.stats.Incr("curator.export_document.error", 1)
+			return DocumentExport{}, err
+		}
+		c.stats.Incr("curator.export_document.success", 1)
+		return DocumentExport{Document: doc}, nil
+	}
+	snapshot, err := binder.Peek(timeout)
+	if err != nil {
+		c.stats.Incr("curator.export_document.error", 1)
+		return DocumentExport{}, err
+	}
+	c.stats.Incr("curator.export_document.success", 1)
+	return DocumentExport{
+		Document: snapshot.Document,
+		Version:  snapshot.Version,
+		Epoch:    binder.Epoch,
+		Open:     true,
+	}, nil
+}
+
+/*
+DocumentSummary - Describes a stored document in listings, Open is set when the document is open on
+this node.
+*/
+type DocumentSummary struct {
+	ID   string `json:"id"`
+	Open bool   `json:"open"`
+}
+
+/*
+ListDocuments - Return a summary of every document of the store, which must be able to list its
+documents.
+*/
+func (c *Curator) ListDocuments() ([]DocumentSummary, error) {
+	lister, ok := c.store.(store.Lister)
+	if !ok {
+		c.stats.Incr("curator.list_documents.error", 1)
+		return nil, ErrStoreNotListable
+	}
+	ids, err := lister.List()
+	if err != nil {
+		c.stats.Incr("curator.list_documents.error", 1)
+		return nil, err
+	}
+	summaries := make([]DocumentSummary, len(ids))
+	for i, id := range ids {
+		_, open := c.openBinder(id)
+		summaries[i] = DocumentSummary{ID: id, Open: open}
+	}
+	c.stats.Incr("curator.list_documents.success", 1)
+	return summaries, nil
+}
+
+/*
+PutDocument - Write a document to the store, creating it if it does not yet exist, in which case
+its ID must follow the ID policy. Documents that are open cannot be overwritten and result in
+ErrDocumentOpen.
+*/
+func (c *Curator) PutDocument(doc store.Document) error {
+	s := c.shard(doc.ID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	_, open := s.binders[doc.ID]
+	if _, opening := s.opening[doc.ID]; open || opening {
+		c.stats.Incr("curator.put_document.rejected", 1)
+		return ErrDocumentOpen
+	}
 
-	c.binderMutex.Lock()
-	for _, binder := range c.openBinders {
-		openBinders = append(openBinders, binder)
+	var err error
+	if _, readErr := c.store.Read(doc.ID); readErr == nil {
+		err = c.store.Update(doc)
+	} else if err = c.validateID(doc.ID); err == nil {
+		if err = c.store.Create(doc); err == nil {
+			c.audit.record(AuditEvent{Event: AuditDocumentCreated, DocumentID: doc.ID})
+			c.created(doc.ID, doc.Content)
+		}
+	}
+	if err != nil {
+		c.stats.Incr("curator.put_document.error", 1)
+		return err
 	}
-	c.binderMutex.Unlock()
+	c.stats.Incr("curator.put_document.success", 1)
+	return nil
+}
+
+/*
+MergeDocument - Write a document to the store, creating it if it does not yet exist, in which case
+its ID must follow the ID policy. When the document already exists the provided content is diffed
+against the current content and the difference is submitted as transforms, so that any connected
+clients receive the changes rather than having the document overwritten beneath them. Only text
+documents can be merged, other types result in ErrMergeNotSupported. Returns the document as flushed
+after the merge. A merge that exceeds the size limits of the document is refused before any of it
+is applied, and should a transform fail part way through then those already applied are undone.
+*/
+func (c *Curator) MergeDocument(doc store.Document, timeout time.Duration) (store.Document, error) {
+	if _, err := c.store.Read(doc.ID); err != nil {
+		if err != store.ErrDocumentNotExist {
+			c.stats.Incr("curator.merge_document.error", 1)
+			return store.Document{}, err
+		}
+		if err = c.validateID(doc.ID); err != nil {
+			c.stats.Incr("curator.merge_document.rejected", 1)
+			return store.Document{}, err
+		}
+		if err = c.store.Create(doc); err != nil {
+			c.stats.Incr("curator.merge_document.error", 1)
+			return store.Document{}, err
+		}
+		c.stats.Incr("curator.merge_document.created", 1)
+		c.audit.record(AuditEvent{Event: AuditDocumentCreated, DocumentID: doc.ID})
+		c.created(doc.ID, doc.Content)
+		return doc, nil
+	}
+
+	binder, portal, err := c.subscribeExisting(doc.ID, func(binder *Binder) BinderPortal {
+		return binder.Subscribe("")
+	})
+	if err != nil {
+		c.stats.Incr("curator.merge_document.error", 1)
+		return store.Document{}, err
+	}
+	defer portal.Exit(timeout)
+
+	if !isTextType(portal.Document.Type) || !isTextType(doc.Type) {
+		c.stats.Incr("curator.merge_document.rejected", 1)
+		return store.Document{}, ErrMergeNotSupported
+	}
+
+	transforms := mergeTransforms(portal.Document.Content, doc.Content)
+	if err = checkMergeSize(c.config.BinderConfig, doc.Content, transforms); err != nil {
+		c.stats.Incr("curator.merge_document.rejected", 1)
+		return store.Document{}, err
+	}
+
+	version := portal.Version
+	for i, ot := range transforms {
+		ot.Version = version + 1
+		applied, err := portal.SendTransform(ot, timeout)
+		if err != nil {
+			c.stats.Incr("curator.merge_document.error", 1)
+			c.log.Errorf("Failed to merge into document %v: %v\n", doc.ID, err)
+			c.undoMerge(&portal, version, invertMergeTransforms(portal.Document.Content, transforms[:i]), timeout)
+			return store.Document{}, err
+		}
+		version = applied
+	}
+
+	snapshot, err := binder.Snapshot(timeout)
+	if err != nil {
+		c.stats.Incr("curator.merge_document.error", 1)
+		return store.Document{}, err
+	}
+	c.stats.Incr("curator.merge_document.success", 1)
+	return snapshot.Document, nil
+}
+
+/*
+undoMerge - Submit the transforms that undo the part of a merge already applied, starting from the
+version of the last of them.
+*/
+func (c *Curator) undoMerge(portal *BinderPortal, version int, inverse []OTransform, timeout time.Duration) {
+	for _, ot := range inverse {
+		ot.Version = version + 1
+		applied, err := portal.SendTransform(ot, timeout)
+		if err != nil {
+			c.stats.Incr("curator.merge_document.undo_error", 1)
+			c.log.Errorf("Failed to undo partial merge into document %v: %v\n", portal.Document.ID, err)
+			return
+		}
+		version = applied
+	}
+}
+
+/*
+DeleteDocument - Remove a document from the store. An open document is closed first, which
+disconnects its clients.
+*/
+func (c *Curator) DeleteDocument(documentID string) error {
+	deleter, ok := c.store.(store.Deleter)
+	if !ok {
+		c.stats.Incr("curator.delete_document.error", 1)
+		return ErrStoreNotDeletable
+	}
+
+	s := c.shard(documentID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// The binder is closed before deleting as it flushes one final time
+	if s.remove(documentID) {
+		c.releaseBinder()
+		c.latency.forget(documentID)
+		c.stats.Decr("curator.open_binders", 1)
+	}
+
+	if err := deleter.Delete(documentID); err != nil {
+		c.stats.Incr("curator.delete_document.error", 1)
+		return err
+	}
+	c.stats.Incr("curator.delete_document.success", 1)
+	c.log.Infof("Document (%v) was deleted\n", documentID)
+	return nil
+}
 
+/*
+ReadRecording - Read the recording of a document, requires read only access to the document.
+*/
+func (c *Curator) ReadRecording(token, documentID string) (Recording, error) {
+	if _, err := c.authorise(token, documentID, auth.AccessRead, "read_recording"); err != nil {
+		return Recording{}, err
+	}
+	directory := c.config.BinderConfig.Recorder.Directory
+	if len(directory) == 0 {
+		return Recording{}, ErrRecordingDisabled
+	}
+
+	path, err := RecordingPath(directory, documentID)
+	if err != nil {
+		return Recording{}, err
+	}
+	recording, err := ReadRecording(path)
+	if err != nil {
+		c.stats.Incr("curator.read_recording.error", 1)
+		return recording, err
+	}
+	c.stats.Incr("curator.read_recording.success", 1)
+	return recording, nil
+}
+
+/*
+GetPreference - Returns the notification preference of a user for a document, the token identifies
+the user and must either be authorised to read the document or belong to one of its clients.
+*/
+func (c *Curator) GetPreference(token, documentID string) (NotificationPreference, error) {
+	if err := c.authorisePreference(token, documentID); err != nil {
+		return NotificationPreference{}, err
+	}
+	return c.preferences.Get(c.preferences.User(token), documentID), nil
+}
+
+/*
+SetPreference - Set the notification preference of a user for a document, the token identifies the
+user and must either be authorised to read the document or belong to one of its clients. The
+preference applies to open binders of the document straight away.
+*/
+func (c *Curator) SetPreference(token, documentID string, pref NotificationPreference) error {
+	if err := c.authorisePreference(token, documentID); err != nil {
+		return err
+	}
+	if err := c.preferences.Set(c.preferences.User(token), documentID, pref); err != nil {
+		c.stats.Incr("curator.set_preference.error", 1)
+		return err
+	}
+	c.stats.Incr("curator.set_preference.success", 1)
+	return nil
+}
+
+/*
+authorisePreference - Checks that a token may manage preferences of a document. Tokens of clients
+connected to the document are accepted without asking the authenticator, which would otherwise use
+up single use tokens.
+*/
+func (c *Curator) authorisePreference(token, documentID string) error {
+	if binder, ok := c.openBinder(documentID); ok && len(token) > 0 {
+		timeout := time.Duration(c.config.BinderConfig.ClientKickPeriod) * time.Millisecond
+		tokens, _ := binder.getTokens(timeout)
+		for _, t := range tokens {
+			if t == token {
+				return nil
+			}
+		}
+	}
+	_, err := c.authorise(token, documentID, auth.AccessRead, "preference")
+	return err
+}
+
+/*
+identify - Returns the public ID of the user a token belongs to, which other clients know the user
+by in place of their token. Returns an empty ID when the authenticator cannot identify users, in
+which case clients are known by their portals.
+*/
+func (c *Curator) identify(token string) string {
+	if identifier, ok := c.authenticator.(auth.UserIdentifier); ok {
+		if userID, ok := identifier.IdentifyUser(token); ok {
+			return userID
+		}
+	}
+	return ""
+}
+
+/*
+authorise - Obtain the level of access a token grants to a document and reject it with
+ErrUnauthorised when it falls short of the level required by an action.
+*/
+func (c *Curator) authorise(token, documentID string, required auth.AccessLevel, action string) (auth.AccessLevel, error) {
+	level := c.authenticator.Authorise(token, documentID)
+	if !level.Grants(required) {
+		c.stats.Incr("curator."+action+".rejected_client", 1)
+		c.metrics.authFailed(action)
+		c.audit.record(AuditEvent{
+			Event:      AuditAuthDenied,
+			DocumentID: documentID,
+			TokenHash:  auditTokenHash(token),
+			Action:     action,
+		})
+		return level, ErrUnauthorised
+	}
+	return level, nil
+}
+
+/*
+GetUsers - Return a full list of all connected users of all open documents.
+*/
+func (c *Curator) GetUsers(timeout time.Duration) (map[string][]string, error) {
+	openBinders := c.allBinders()
 	started := time.Now()
 
 	// TODO: make these calls asynchronous
@@ -232,39 +1089,141 @@ func (c *Curator) GetUsers(timeout time.Duration) (map[string][]string, error) {
 }
 
 /*
-EditDocument - Locates or creates a Binder for an existing document and returns that Binder for
-subscribing to. Returns an error if there was a problem locating the document.
+InspectBinders - Return a summary of the live state of every open binder, ordered by document ID.
+Binders are inspected concurrently, each within the timeout, and a binder that fails to respond is
+listed with the error rather than failing the whole list.
 */
-func (c *Curator) EditDocument(token, id string) (BinderPortal, error) {
-	c.log.Debugf("finding document %v, with token %v\n", id, token)
+func (c *Curator) InspectBinders(timeout time.Duration) ([]BinderInfo, error) {
+	openBinders := c.allBinders()
 
-	if !c.authenticator.AuthoriseJoin(token, id) {
-		c.stats.Incr("curator.edit.rejected_client", 1)
-		return BinderPortal{}, fmt.Errorf("failed to authorise join of document id: %v with token: %v\n", id, token)
+	infos := make([]BinderInfo, len(openBinders))
+	wg := sync.WaitGroup{}
+	wg.Add(len(openBinders))
+	for j, binder := range openBinders {
+		go func(j int, binder *Binder) {
+			defer wg.Done()
+			info, err := binder.Inspect(timeout)
+			if err != nil {
+				c.stats.Incr("curator.inspect.error", 1)
+				c.log.Errorf("Failed to inspect %v: %v\n", binder.ID, err)
+				info = BinderInfo{ID: binder.ID, Epoch: binder.Epoch, Error: err.Error()}
+			}
+			infos[j] = info
+		}(j, binder)
 	}
-	c.stats.Incr("curator.edit.accepted_client", 1)
+	wg.Wait()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
 
-	c.binderMutex.Lock()
+	c.stats.Incr("curator.inspect.success", 1)
+	return infos, nil
+}
 
-	// Check for existing binder
-	if binder, ok := c.openBinders[id]; ok {
-		c.binderMutex.Unlock()
+/*
+InspectBinder - Return a summary of the live state of the binder of an open document, documents
+that are not currently open result in ErrBinderNotFound.
+*/
+func (c *Curator) InspectBinder(documentID string, timeout time.Duration) (BinderInfo, error) {
+	binder, ok := c.openBinder(documentID)
+	if !ok {
+		c.stats.Incr("curator.inspect.error", 1)
+		return BinderInfo{}, ErrBinderNotFound
+	}
 
-		return binder.Subscribe(token), nil
+	info, err := binder.Inspect(timeout)
+	if err != nil {
+		c.stats.Incr("curator.inspect.error", 1)
+		return info, err
+	}
+
+	c.stats.Incr("curator.inspect.success", 1)
+	return info, nil
+}
+
+/*
+FlushDocument - Flush the pending changes of an open document to the store immediately, returns the
+version of the document as flushed. Documents that are not currently open have nothing to flush and
+result in ErrBinderNotFound.
+*/
+func (c *Curator) FlushDocument(documentID string, timeout time.Duration) (int, error) {
+	binder, ok := c.openBinder(documentID)
+	if !ok {
+		c.stats.Incr("curator.flush_document.error", 1)
+		return 0, ErrBinderNotFound
 	}
-	binder, err := NewBinder(id, c.store, c.config.BinderConfig, c.errorChan, c.log, c.stats)
+
+	snapshot, err := binder.Snapshot(timeout)
 	if err != nil {
-		c.binderMutex.Unlock()
+		c.stats.Incr("curator.flush_document.error", 1)
+		c.log.Errorf("Failed to flush %v: %v\n", documentID, err)
+		return 0, err
+	}
 
-		c.stats.Incr("curator.bind_existing.failed", 1)
-		c.log.Errorf("Failed to bind to document %v: %v\n", id, err)
+	c.stats.Incr("curator.flush_document.success", 1)
+	return snapshot.Version, nil
+}
+
+/*
+CloseDocument - Close the binder of an open document along with its read replicas, flushing any
+pending changes and disconnecting all of its clients. Clients may then reopen the document as usual.
+Documents that are not currently open result in ErrBinderNotFound.
+*/
+func (c *Curator) CloseDocument(documentID string) error {
+	s := c.shard(documentID)
+
+	s.mutex.Lock()
+	removed := s.remove(documentID)
+	s.mutex.Unlock()
+
+	if !removed {
+		c.stats.Incr("curator.close_document.error", 1)
+		return ErrBinderNotFound
+	}
+	c.releaseBinder()
+	c.latency.forget(documentID)
+	c.log.Infof("Binder (%v) was closed on request\n", documentID)
+	c.stats.Incr("curator.close_document.success", 1)
+	c.stats.Decr("curator.open_binders", 1)
+	return nil
+}
+
+/*
+EditDocument - Locates or creates a Binder for an existing document and returns that Binder for
+subscribing to. Returns an error if there was a problem locating the document.
+*/
+func (c *Curator) EditDocument(token, id string) (BinderPortal, error) {
+	return c.ResumeDocument(token, id, "", 0)
+}
+
+/*
+ResumeDocument - Locates or creates a Binder for an existing document and returns that Binder for
+subscribing to by a client that already holds the document at a version of an epoch, see
+Binder.SubscribeFrom. The document may be found by an alias in place of its ID. Returns an error if
+there was a problem locating the document.
+*/
+func (c *Curator) ResumeDocument(token, id, epoch string, version int) (BinderPortal, error) {
+	id = c.resolveAlias(id)
+	c.log.Debugf("finding document %v, with token %v\n", id, token)
+
+	required := auth.AccessWrite
+	if c.isSystemDocument(id) {
+		required = auth.AccessAdmin
+	}
+	level, err := c.authorise(token, id, required, "edit")
+	if err != nil {
 		return BinderPortal{}, err
 	}
-	c.openBinders[id] = binder
-	c.binderMutex.Unlock()
+	c.stats.Incr("curator.edit.accepted_client", 1)
 
-	c.stats.Incr("curator.open_binders", 1)
-	return binder.Subscribe(token), nil
+	_, portal, err := c.subscribeExisting(id, func(binder *Binder) BinderPortal {
+		return binder.SubscribeWith(BinderSubscribeBundle{
+			Token:       token,
+			UserID:      c.identify(token),
+			Admin:       level.Grants(auth.AccessAdmin),
+			ResumeEpoch: epoch,
+			Resume:      version,
+		})
+	})
+	return portal, err
 }
 
 /*
@@ -273,36 +1232,187 @@ subscribing to with read only privileges. Returns an error if there was a proble
 document.
 */
 func (c *Curator) ReadDocument(token, id string) (BinderPortal, error) {
+	return c.readDocument(token, id, func(binder *Binder, admin bool) BinderPortal {
+		return c.subscribeReadOnly(binder, token, admin)
+	})
+}
+
+/*
+ReadDocumentThrottled - Locates or creates a Binder for an existing document and returns a read only
+portal to it, which receives at most one batch of transforms per period. See
+Binder.SubscribeThrottled.
+*/
+func (c *Curator) ReadDocumentThrottled(token, id string, period time.Duration) (BinderPortal, error) {
+	return c.readDocument(token, id, func(binder *Binder, admin bool) BinderPortal {
+		// Throttled clients are cheap for a binder, and so never need a replica.
+		userID := c.identify(token)
+		if !admin && binder.bannedClient(token, userID) {
+			return BinderPortal{Token: token, Error: ErrClientBanned}
+		}
+		return binder.SubscribeWith(BinderSubscribeBundle{
+			Token:    token,
+			UserID:   userID,
+			ReadOnly: true,
+			Admin:    admin,
+			Throttle: period,
+		})
+	})
+}
+
+/*
+readDocument - Authorise a read only client, locate or create the binder of the document and then
+subscribe the client to it, administrators of the document are exempt from its bans. The document
+may be found by an alias in place of its ID.
+*/
+func (c *Curator) readDocument(token, id string, subscribe func(*Binder, bool) BinderPortal) (BinderPortal, error) {
+	id = c.resolveAlias(id)
 	c.log.Debugf("finding document %v, with token %v\n", id, token)
 
-	if !c.authenticator.AuthoriseReadOnly(token, id) {
-		c.stats.Incr("curator.read.rejected_client", 1)
-		return BinderPortal{},
-			fmt.Errorf("failed to authorise read only join of document id: %v with token: %v\n", id, token)
+	level, err := c.authorise(token, id, auth.AccessRead, "read")
+	if err != nil {
+		return BinderPortal{}, err
 	}
 	c.stats.Incr("curator.read.accepted_client", 1)
 
-	c.binderMutex.Lock()
+	_, portal, err := c.subscribeExisting(id, func(binder *Binder) BinderPortal {
+		return subscribe(binder, level.Grants(auth.AccessAdmin))
+	})
+	return portal, err
+}
+
+/*
+subscribeExisting - Locate or create the binder of an existing document and subscribe to it. A
+binder closed in between, by an admin, a deletion or an eviction, is bound again up to
+bindAttempts times in total, which opens a fresh binder unless the document is gone.
+*/
+func (c *Curator) subscribeExisting(id string, subscribe func(*Binder) BinderPortal) (*Binder, BinderPortal, error) {
+	for attempt := 1; ; attempt++ {
+		binder, err := c.bindExisting(id)
+		if err != nil {
+			return nil, BinderPortal{}, err
+		}
+		portal := subscribe(binder)
+		if portal.Error != ErrBinderClosed || attempt >= bindAttempts {
+			return binder, portal, portal.Error
+		}
+		c.stats.Incr("curator.bind_existing.closed", 1)
+	}
+}
+
+/*
+bindExisting - Locate the open binder of an existing document, or create one if it is not yet open.
+Opening a binder may wait on the store and on the leader of the document within a cluster, and so
+is done outside the lock of the shard, with concurrent callers sharing the outcome of a single open.
+*/
+func (c *Curator) bindExisting(id string) (*Binder, error) {
+	s := c.shard(id)
+	s.mutex.Lock()
+
+	if c.isDraining() {
+		s.mutex.Unlock()
+		c.stats.Incr("curator.bind_existing.rejected_draining", 1)
+		return nil, ErrCuratorDraining
+	}
 
 	// Check for existing binder
-	if binder, ok := c.openBinders[id]; ok {
-		c.binderMutex.Unlock()
+	if binder, ok := s.binders[id]; ok {
+		s.mutex.Unlock()
+		return binder, nil
+	}
+	if opening, ok := s.opening[id]; ok {
+		s.mutex.Unlock()
+		<-opening.done
+		return opening.binder, opening.err
+	}
+	if !c.reserveBinder() {
+		s.mutex.Unlock()
+		c.stats.Incr("curator.bind_existing.rejected_capacity", 1)
+		return nil, ErrTooManyBinders
+	}
+	opening := &binderOpening{done: make(chan struct{})}
+	s.opening[id] = opening
+	s.mutex.Unlock()
 
-		return binder.SubscribeReadOnly(token), nil
+	binder, err := newBinder(id, c.binderStore, c.transforms, c.relay, c.preferences, c.comments, c.config.BinderConfig, c.namespace, c.latency, c.metrics, c.audit, c.emitLifecycle, c.bans, s.errorChan, c.log, c.stats)
+	if err == ErrRelaySyncTimeout && c.breakIfStale(id) {
+		binder, err = newBinder(id, c.binderStore, c.transforms, c.relay, c.preferences, c.comments, c.config.BinderConfig, c.namespace, c.latency, c.metrics, c.audit, c.emitLifecycle, c.bans, s.errorChan, c.log, c.stats)
 	}
-	binder, err := NewBinder(id, c.store, c.config.BinderConfig, c.errorChan, c.log, c.stats)
-	if err != nil {
-		c.binderMutex.Unlock()
 
+	s.mutex.Lock()
+	delete(s.opening, id)
+	if err == nil && c.isDraining() {
+		binder.Close()
+		binder, err = nil, ErrCuratorDraining
+	}
+	if err == nil {
+		s.binders[id] = binder
+	}
+	s.mutex.Unlock()
+	opening.binder, opening.err = binder, err
+	close(opening.done)
+
+	if err == ErrCuratorDraining {
+		c.releaseBinder()
+		c.stats.Incr("curator.bind_existing.rejected_draining", 1)
+		return nil, err
+	}
+	if err != nil {
+		c.releaseBinder()
 		c.stats.Incr("curator.bind_existing.failed", 1)
 		c.log.Errorf("Failed to bind to document %v: %v\n", id, err)
-		return BinderPortal{}, err
+		return nil, err
 	}
-	c.openBinders[id] = binder
-	c.binderMutex.Unlock()
 
+	c.forgetSuspect(id)
 	c.stats.Incr("curator.open_binders", 1)
-	return binder.SubscribeReadOnly(token), nil
+	return binder, nil
+}
+
+/*
+subscribeReadOnly - Subscribe a read only client to a binder, or to one of its read replicas when
+replicas are enabled. Replicas are filled up to their viewer limit before a new one is created.
+*/
+func (c *Curator) subscribeReadOnly(binder *Binder, token string, admin bool) BinderPortal {
+	// Replicas know nothing of bans, so banned users are turned away here.
+	bundle := BinderSubscribeBundle{Token: token, UserID: c.identify(token), ReadOnly: true, Admin: admin}
+	if !admin && binder.bannedClient(bundle.Token, bundle.UserID) {
+		return BinderPortal{Token: token, Error: ErrClientBanned}
+	}
+	if c.config.Replica.ViewersPerReplica <= 0 {
+		return binder.SubscribeWith(bundle)
+	}
+
+	s := c.shard(binder.ID)
+	s.mutex.Lock()
+	open := []*Replica{}
+	for _, r := range s.replicas[binder.ID] {
+		if !r.Closed() {
+			open = append(open, r)
+		}
+	}
+	s.replicas[binder.ID] = open
+	s.mutex.Unlock()
+
+	for _, r := range open {
+		if r.Viewers() < c.config.Replica.ViewersPerReplica {
+			if portal := r.SubscribeWith(bundle); portal.Error != ErrReplicaClosed {
+				return portal
+			}
+		}
+	}
+
+	source := binder.SubscribeReadOnly("")
+	if source.Error != nil {
+		return source
+	}
+	replica := NewReplica(source, c.config.Replica, c.log, c.stats)
+
+	s.mutex.Lock()
+	s.replicas[binder.ID] = append(s.replicas[binder.ID], replica)
+	s.mutex.Unlock()
+
+	c.stats.Incr("curator.replica.created", 1)
+	return replica.SubscribeWith(bundle)
 }
 
 /*
@@ -315,30 +1425,59 @@ func (c *Curator) CreateDocument(token string, userID string, doc store.Document
 
 	if !c.authenticator.AuthoriseCreate(token, userID) {
 		c.stats.Incr("curator.create.rejected_client", 1)
-		return BinderPortal{}, fmt.Errorf("failed to gain permission to create with token: %v\n", token)
+		c.metrics.authFailed("create")
+		c.audit.record(AuditEvent{Event: AuditAuthDenied, UserID: userID, Action: "create"})
+		return BinderPortal{}, ErrUnauthorised
 	}
 	c.stats.Incr("curator.create.accepted_client", 1)
 
+	if c.isDraining() {
+		c.stats.Incr("curator.create.rejected_draining", 1)
+		return BinderPortal{}, ErrCuratorDraining
+	}
+
+	if !c.reserveBinder() {
+		c.stats.Incr("curator.create.rejected_capacity", 1)
+		return BinderPortal{}, ErrTooManyBinders
+	}
+
 	// Always generate a fresh ID
 	doc.ID = util.GenerateStampedUUID()
 
 	if err := c.store.Create(doc); err != nil {
+		c.releaseBinder()
 		c.stats.Incr("curator.create_new.failed", 1)
 		c.log.Errorf("Failed to create new document: %v\n", err)
 		return BinderPortal{}, err
 	}
-	binder, err := NewBinder(doc.ID, c.store, c.config.BinderConfig, c.errorChan, c.log, c.stats)
+	c.audit.record(AuditEvent{Event: AuditDocumentCreated, DocumentID: doc.ID, UserID: userID})
+	c.created(doc.ID, doc.Content)
+
+	s := c.shard(doc.ID)
+	binder, err := newBinder(doc.ID, c.binderStore, c.transforms, c.relay, c.preferences, c.comments, c.config.BinderConfig, c.namespace, c.latency, c.metrics, c.audit, c.emitLifecycle, c.bans, s.errorChan, c.log, c.stats)
 	if err != nil {
+		c.releaseBinder()
 		c.stats.Incr("curator.bind_new.failed", 1)
 		c.log.Errorf("Failed to bind to new document: %v\n", err)
 		return BinderPortal{}, err
 	}
-	c.binderMutex.Lock()
-	c.openBinders[doc.ID] = binder
-	c.binderMutex.Unlock()
+	s.mutex.Lock()
+	if c.isDraining() {
+		s.mutex.Unlock()
+		binder.Close()
+		c.releaseBinder()
+		c.stats.Incr("curator.create.rejected_draining", 1)
+		return BinderPortal{}, ErrCuratorDraining
+	}
+	s.binders[doc.ID] = binder
+	s.mutex.Unlock()
 	c.stats.Incr("curator.open_binders", 1)
 
-	return binder.Subscribe(token), nil
+	if len(userID) == 0 {
+		userID = c.identify(token)
+	}
+	portal := binder.SubscribeWith(BinderSubscribeBundle{Token: token, UserID: userID})
+	return portal, portal.Error
 }
 
 /*--------------------------------------------------------------------------------------------------
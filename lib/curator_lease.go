@@ -0,0 +1,235 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for breaking the leases of a Curator.
+var (
+	ErrClusterDisabled  = errors.New("curator is not part of a cluster")
+	ErrLeaseNotHeld     = errors.New("no node holds a lease over the document")
+	ErrLeaseHeldLocally = errors.New("lease over the document is held by this node")
+	ErrLeaseChanged     = errors.New("lease or document has changed since it was inspected")
+)
+
+/*
+LeaseInfo - Describes the lease over a document held by a node of the cluster. Fingerprint
+identifies the version of the document in the store, which changes whenever the holder of the lease
+flushes the document.
+*/
+type LeaseInfo struct {
+	DocumentID  string `json:"document_id"`
+	Node        string `json:"node"`
+	Local       bool   `json:"local"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+/*
+suspectLease - A lease whose holder failed to answer us, and when it was first suspected.
+*/
+type suspectLease struct {
+	info  LeaseInfo
+	since time.Time
+}
+
+/*
+storeFingerprint - Returns a digest of a document as stored.
+*/
+func storeFingerprint(doc store.Document) string {
+	sum := sha256.Sum256([]byte(doc.Type + "\x00" + doc.Content))
+	return hex.EncodeToString(sum[:16])
+}
+
+/*
+GetClusterStatus - Returns the protocol spoken by this node and by the peers it has recently heard
+from, which shows whether the cluster is part way through a rolling upgrade.
+*/
+func (c *Curator) GetClusterStatus() (ClusterStatus, error) {
+	c.mutex.RLock()
+	tracked, ok := c.relay.(*trackedRelay)
+	c.mutex.RUnlock()
+
+	if !ok {
+		return ClusterStatus{}, ErrClusterDisabled
+	}
+	return tracked.status(), nil
+}
+
+/*
+InspectLease - Returns the node holding the lease over a document along with the fingerprint of the
+stored document, which must be passed back to BreakLease.
+*/
+func (c *Curator) InspectLease(id string) (LeaseInfo, error) {
+	c.mutex.RLock()
+	relay := c.relay
+	c.mutex.RUnlock()
+
+	if relay == nil {
+		return LeaseInfo{}, ErrClusterDisabled
+	}
+	node, err := relay.Leader(id)
+	if err != nil {
+		return LeaseInfo{}, err
+	}
+	if len(node) == 0 {
+		return LeaseInfo{}, ErrLeaseNotHeld
+	}
+	doc, err := c.store.Read(id)
+	if err != nil {
+		return LeaseInfo{}, err
+	}
+	return LeaseInfo{
+		DocumentID:  id,
+		Node:        node,
+		Local:       node == relay.Node(),
+		Fingerprint: storeFingerprint(doc),
+	}, nil
+}
+
+/*
+BreakLease - Break the lease over a document held by a node that appears to have failed. The node and
+fingerprint must match those returned by an earlier call to InspectLease, the lease is only broken
+if the node still holds it and has not flushed the document since, otherwise ErrLeaseChanged is
+returned. Leases held by this node are never broken, the document should be closed instead.
+*/
+func (c *Curator) BreakLease(id, node, fingerprint string) error {
+	info, err := c.InspectLease(id)
+	if err != nil {
+		return err
+	}
+	if info.Local {
+		return ErrLeaseHeldLocally
+	}
+	if info.Node != node || info.Fingerprint != fingerprint {
+		c.stats.Incr("curator.break_lease.changed", 1)
+		return ErrLeaseChanged
+	}
+
+	c.mutex.RLock()
+	relay := c.relay
+	c.mutex.RUnlock()
+
+	broken, err := relay.Break(id, node)
+	if err != nil {
+		c.stats.Incr("curator.break_lease.error", 1)
+		return err
+	}
+	if !broken {
+		c.stats.Incr("curator.break_lease.changed", 1)
+		return ErrLeaseChanged
+	}
+	c.stats.Incr("curator.break_lease.success", 1)
+	c.log.Warnf("Broke lease of node %v over document %v\n", node, id)
+
+	if err = c.fenceBrokenLease(relay, id); err != nil {
+		c.stats.Incr("curator.break_lease.fence_error", 1)
+		c.log.Errorf("Failed to fence former holder of document %v: %v\n", id, err)
+	}
+	return nil
+}
+
+/*
+fenceBrokenLease - Stop the former holder of a broken lease from flushing a document, which it may
+still attempt if it has not noticed the break. We briefly take the lease ourselves and write the
+stored document back unchanged with our fencing token, after which the store rejects the smaller
+token of the former holder. Stores without conditional writes cannot be fenced.
+*/
+func (c *Curator) fenceBrokenLease(relay Relay, id string) error {
+	if _, ok := c.store.(store.FencedUpdater); !ok {
+		return nil
+	}
+	// Should another node have taken the lease already then its own flushes fence the document.
+	if led, err := relay.Lead(id); err != nil || !led {
+		return err
+	}
+	defer relay.Release(id)
+
+	doc, err := c.store.Read(id)
+	if err != nil {
+		return err
+	}
+	return c.binderStore.Update(doc)
+}
+
+/*
+breakIfStale - Called when the leader of a document failed to answer us. The first failure marks the
+lease as suspect, and once it has stayed suspect for the stale lease timeout, without the lease
+changing hands or the document being flushed, the lease is broken. Returns true when the lease was
+broken, in which case binding to the document may be attempted again.
+*/
+func (c *Curator) breakIfStale(id string) bool {
+	timeout := time.Duration(c.config.Cluster.StaleLeaseTimeout) * time.Millisecond
+	if timeout <= 0 {
+		return false
+	}
+	info, err := c.InspectLease(id)
+	if err != nil || info.Local {
+		return false
+	}
+
+	c.suspectMutex.Lock()
+	suspect, ok := c.suspects[id]
+	if !ok || suspect.info != info {
+		c.suspects[id] = suspectLease{info: info, since: time.Now()}
+		c.suspectMutex.Unlock()
+
+		c.stats.Incr("curator.stale_lease.suspected", 1)
+		c.log.Warnf("Lease of node %v over document %v is suspected stale\n", info.Node, id)
+		return false
+	}
+	if time.Since(suspect.since) < timeout {
+		c.suspectMutex.Unlock()
+		return false
+	}
+	delete(c.suspects, id)
+	c.suspectMutex.Unlock()
+
+	if err = c.BreakLease(id, info.Node, info.Fingerprint); err != nil {
+		c.log.Errorf("Failed to break stale lease over document %v: %v\n", id, err)
+		return false
+	}
+	c.stats.Incr("curator.stale_lease.broken", 1)
+	return true
+}
+
+/*
+forgetSuspect - Clear any suspicion over the lease of a document once we have bound to it.
+*/
+func (c *Curator) forgetSuspect(id string) {
+	c.suspectMutex.Lock()
+	delete(c.suspects, id)
+	c.suspectMutex.Unlock()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
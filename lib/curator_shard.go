@@ -0,0 +1,264 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+curatorShard - A partition of the binders of a curator. Each shard guards its binders and their read
+replicas with its own lock, and runs its own loop for handling the shut down requests of its
+binders, so that joining or creating documents in one shard never waits on another.
+*/
+type curatorShard struct {
+	binders  map[string]*Binder
+	replicas map[string][]*Replica
+	opening  map[string]*binderOpening
+	mutex    sync.RWMutex
+
+	// Control channels
+	errorChan  chan BinderError
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+/*
+binderOpening - A binder being opened outside the lock of its shard, those binding to the document
+in the meantime wait for it rather than opening a binder of their own.
+*/
+type binderOpening struct {
+	binder *Binder
+	err    error
+	done   chan struct{}
+}
+
+/*
+newCuratorShard - Creates an empty shard, its loop must be launched by the curator.
+*/
+func newCuratorShard() *curatorShard {
+	return &curatorShard{
+		binders:    make(map[string]*Binder),
+		replicas:   make(map[string][]*Replica),
+		opening:    make(map[string]*binderOpening),
+		errorChan:  make(chan BinderError, 10),
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+}
+
+/*
+get - Returns the open binder of a document within this shard.
+*/
+func (s *curatorShard) get(id string) (*Binder, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	binder, ok := s.binders[id]
+	return binder, ok
+}
+
+/*
+remove - Close the binder of a document along with its read replicas and forget them, callers must
+hold the shard mutex. Returns false if the document was not open.
+*/
+func (s *curatorShard) remove(id string) bool {
+	b, ok := s.binders[id]
+	if !ok {
+		return false
+	}
+	b.Close()
+	for _, r := range s.replicas[id] {
+		r.Close()
+	}
+	delete(s.replicas, id)
+	delete(s.binders, id)
+	return true
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+newCuratorShards - Creates the shards of a curator, there is always at least one.
+*/
+func newCuratorShards(count int) []*curatorShard {
+	if count < 1 {
+		count = 1
+	}
+	shards := make([]*curatorShard, count)
+	for i := range shards {
+		shards[i] = newCuratorShard()
+	}
+	return shards
+}
+
+/*
+shard - Returns the shard responsible for a document ID.
+*/
+func (c *Curator) shard(id string) *curatorShard {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(id))
+	return c.shards[hasher.Sum32()%uint32(len(c.shards))]
+}
+
+/*
+openBinder - Returns the open binder of a document, if there is one.
+*/
+func (c *Curator) openBinder(id string) (*Binder, bool) {
+	return c.shard(id).get(id)
+}
+
+/*
+allBinders - Returns every open binder of every shard.
+*/
+func (c *Curator) allBinders() []*Binder {
+	binders := []*Binder{}
+	for _, s := range c.shards {
+		s.mutex.RLock()
+		for _, b := range s.binders {
+			binders = append(binders, b)
+		}
+		s.mutex.RUnlock()
+	}
+	return binders
+}
+
+/*
+reserveBinder - Count a binder about to be opened against the MaxOpenBinders limit, returns false
+without counting it if the limit has been reached. Reservations of binders that then fail to open
+must be returned with releaseBinder.
+*/
+func (c *Curator) reserveBinder() bool {
+	for {
+		open := atomic.LoadInt64(&c.openCount)
+		if c.config.MaxOpenBinders > 0 && open >= int64(c.config.MaxOpenBinders) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&c.openCount, open, open+1) {
+			return true
+		}
+	}
+}
+
+/*
+releaseBinder - Stop counting a binder against the MaxOpenBinders limit.
+*/
+func (c *Curator) releaseBinder() {
+	atomic.AddInt64(&c.openCount, -1)
+}
+
+/*
+evictTimeout - The longest a shard waits on a binder to confirm that it is still idle.
+*/
+func (c *Curator) evictTimeout() time.Duration {
+	return time.Duration(c.config.BinderConfig.ClientKickPeriod) * time.Millisecond
+}
+
+/*
+shardLoop - The loop of a shard, which listens to two channels:
+
+- Error channel, used by the active binders of the shard to request a shut down, either due to
+inactivity or an error having occurred. The binder is then closed and removed from the shard.
+
+- Close channel, used by the curator loop to instigate a clean shut down. Every binder of the shard
+is closed before the loop exits.
+*/
+func (c *Curator) shardLoop(s *curatorShard) {
+	for {
+		select {
+		case err := <-s.errorChan:
+			// Diagnostics are only reported, the binder carries on as normal
+			if err.Diagnostic != nil {
+				c.stats.Incr("curator.diagnostics", 1)
+				c.mutex.RLock()
+				hooks := c.diagnosticHooks
+				c.mutex.RUnlock()
+				for _, hook := range hooks {
+					hook(*err.Diagnostic)
+				}
+				continue
+			}
+			if err.Err != nil {
+				c.stats.Incr("curator.binder_chan.error", 1)
+				c.log.Errorf("Binder (%v) %v\n", err.ID, err.Err)
+			} else if err.Eviction != nil {
+				c.log.Infof("Binder (%v) has been idle for %v, evicting\n", err.ID, err.Eviction.Idle)
+			} else {
+				c.log.Infof("Binder (%v) has requested shutdown\n", err.ID)
+			}
+
+			s.mutex.Lock()
+			// Clients may have joined since the eviction was requested, in which case it is dropped
+			if b, ok := s.binders[err.ID]; ok && err.Eviction != nil && !b.releaseIdle(c.evictTimeout()) {
+				s.mutex.Unlock()
+				c.log.Infof("Binder (%v) is no longer idle, eviction cancelled\n", err.ID)
+				c.stats.Incr("curator.evictions.cancelled", 1)
+				continue
+			}
+			removed := s.remove(err.ID)
+			s.mutex.Unlock()
+
+			if !removed {
+				c.log.Errorf("Binder (%v) was not located in map\n", err.ID)
+				c.stats.Incr("curator.binder_shutdown.error", 1)
+				continue
+			}
+			c.releaseBinder()
+			c.latency.forget(err.ID)
+			c.log.Infof("Binder (%v) was closed\n", err.ID)
+			c.stats.Incr("curator.binder_shutdown.success", 1)
+			c.stats.Decr("curator.open_binders", 1)
+
+			// Hooks are only told of evictions that actually released a binder
+			if err.Eviction != nil {
+				c.stats.Incr("curator.evictions", 1)
+				c.mutex.RLock()
+				hooks := c.evictionHooks
+				c.mutex.RUnlock()
+				for _, hook := range hooks {
+					hook(*err.Eviction)
+				}
+			}
+		case <-s.closeChan:
+			s.mutex.Lock()
+			for id := range s.binders {
+				s.remove(id)
+				c.releaseBinder()
+				c.stats.Decr("curator.open_binders", 1)
+			}
+			s.mutex.Unlock()
+			close(s.closedChan)
+			return
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
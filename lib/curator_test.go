@@ -23,13 +23,20 @@ THE SOFTWARE.
 package lib
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/register"
 	"github.com/jeffail/leaps/lib/store"
 	"github.com/jeffail/util/log"
 )
@@ -179,7 +186,7 @@ func TestCuratorClients(t *testing.T) {
 	go func() {
 		for {
 			select {
-			case err := <-curator.errorChan:
+			case err := <-curator.shard(doc.ID).errorChan:
 				t.Errorf("Curator received error: %v", err)
 			case <-time.After(50 * time.Millisecond):
 				return
@@ -204,3 +211,1296 @@ func TestCuratorClients(t *testing.T) {
 		t.Errorf("Timeout occured waiting for test finish.")
 	}
 }
+
+func TestCuratorPreload(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.PreloadDocuments = []string{doc.ID, "does not exist"}
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	curator.Preload()
+
+	_, loaded := curator.openBinder(doc.ID)
+	_, missing := curator.openBinder("does not exist")
+
+	if !loaded {
+		t.Error("Document was not preloaded")
+	}
+	if missing {
+		t.Error("Missing document was bound")
+	}
+}
+
+func TestCuratorMaxOpenBinders(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	existing, _ := store.NewDocument("hello world")
+	if err := storage.Create(*existing); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.MaxOpenBinders = 1
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	doc, _ := store.NewDocument("first")
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = curator.CreateDocument("", "", *doc); err != ErrTooManyBinders {
+		t.Errorf("Wrong error for create at capacity: %v", err)
+	}
+	if _, err = curator.EditDocument("", existing.ID); err != ErrTooManyBinders {
+		t.Errorf("Wrong error for join at capacity: %v", err)
+	}
+
+	// Documents that are already open may still be joined
+	if _, err = curator.EditDocument("", portal.Document.ID); err != nil {
+		t.Errorf("Failed to join open document at capacity: %v", err)
+	}
+}
+
+func TestCuratorShards(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.Shards = 4
+	config.MaxOpenBinders = 20
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	ids := make(chan string, 30)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			doc, _ := store.NewDocument("hello world")
+			if portal, err := curator.CreateDocument("", "", *doc); err == nil {
+				ids <- portal.Document.ID
+			} else if err != ErrTooManyBinders {
+				t.Errorf("Create error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+
+	if exp, act := config.MaxOpenBinders, len(ids); exp != act {
+		t.Errorf("Wrong count of created documents: %v != %v", exp, act)
+	}
+	used := map[*curatorShard]struct{}{}
+	for id := range ids {
+		s := curator.shard(id)
+		if _, ok := s.get(id); !ok {
+			t.Errorf("Document %v was not in its shard", id)
+		}
+		used[s] = struct{}{}
+		if err = curator.DeleteDocument(id); err != nil {
+			t.Error(err)
+		}
+	}
+	if len(used) < 2 {
+		t.Errorf("Documents were not distributed across shards: %v", len(used))
+	}
+	if binders := curator.allBinders(); len(binders) != 0 {
+		t.Errorf("Binders remain after deleting: %v", len(binders))
+	}
+
+	// Deleted binders no longer count towards the limit
+	doc, _ := store.NewDocument("hello world")
+	if _, err = curator.CreateDocument("", "", *doc); err != nil {
+		t.Errorf("Create after deleting error: %v", err)
+	}
+
+	if shards := newCuratorShards(0); len(shards) != 1 {
+		t.Errorf("Wrong shard count for zero: %v", len(shards))
+	}
+}
+
+/*
+blockingStore - Blocks reads of a single document until released.
+*/
+type blockingStore struct {
+	store.Store
+	blocked string
+	release chan struct{}
+}
+
+func (s blockingStore) Read(id string) (store.Document, error) {
+	if id == s.blocked {
+		<-s.release
+	}
+	return s.Store.Read(id)
+}
+
+func TestCuratorSlowOpen(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	slow, _ := store.NewDocument("slow")
+	fast, _ := store.NewDocument("fast")
+	storage.Create(*slow)
+	storage.Create(*fast)
+
+	config := DefaultCuratorConfig()
+	config.Shards = 1
+
+	blocking := blockingStore{Store: storage, blocked: slow.ID, release: make(chan struct{})}
+	curator, err := NewCurator(config, log, stats, auth, blocking)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	portals := make(chan BinderPortal, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			portal, err := curator.EditDocument("", slow.ID)
+			if err != nil {
+				t.Error(err)
+			}
+			portals <- portal
+		}()
+	}
+
+	// Opening the slow document must not hold up others of the same shard
+	<-time.After(50 * time.Millisecond)
+	if _, err = curator.EditDocument("", fast.ID); err != nil {
+		t.Fatal(err)
+	}
+	close(blocking.release)
+
+	first, second := <-portals, <-portals
+	if first.Document.Content != "slow" || second.Document.Content != "slow" {
+		t.Errorf("Wrong content: %v, %v", first.Document.Content, second.Document.Content)
+	}
+	if binders := curator.allBinders(); len(binders) != 2 {
+		t.Errorf("Wrong count of open binders: %v", len(binders))
+	}
+}
+
+func TestCuratorWatchStore(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, _ := authAndStore(log, stats)
+
+	dir, err := ioutil.TempDir("", "leaps_watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storeConfig := store.NewConfig()
+	storeConfig.Type = "directory"
+	storeConfig.StoreDirectory = dir
+	storage, err := store.Factory(storeConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.WatchStore = true
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	portal, err := curator.EditDocument("", "notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate another tool, such as git pull, rewriting the file
+	if err = ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello world\ngoodbye world\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ot := <-portal.TransformRcvChan:
+		if ot.Insert != "goodbye world\n" || ot.Author != StoreAuthor {
+			t.Errorf("Wrong transform for file change: %+v", ot)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for file change")
+	}
+}
+
+func TestCuratorReplicas(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.Replica.ViewersPerReplica = 2
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	editor, err := curator.EditDocument("", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	viewers := []BinderPortal{}
+	for i := 0; i < 3; i++ {
+		viewer, err := curator.ReadDocument("", editor.Document.ID)
+		if err != nil || viewer.Error != nil {
+			t.Fatalf("Failed to subscribe viewer: %v, %v", err, viewer.Error)
+		}
+		viewers = append(viewers, viewer)
+	}
+
+	// The editor and two replicas, rather than the editor and three viewers.
+	users, err := curator.GetUsers(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users[doc.ID]) != 3 {
+		t.Errorf("Wrong number of binder clients: %v", users[doc.ID])
+	}
+
+	if _, err = editor.SendTransform(OTransform{Position: 5, Insert: ",", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for _, viewer := range viewers {
+		select {
+		case ot := <-viewer.TransformRcvChan:
+			if ot.Insert != "," {
+				t.Errorf("Viewer received wrong transform: %v", ot)
+			}
+		case <-time.After(time.Second):
+			t.Error("Viewer did not receive transform")
+		}
+	}
+
+	if _, err = viewers[2].SendTransform(OTransform{Position: 0, Insert: "a", Version: 3}, time.Second); err != ErrReadOnlyPortal {
+		t.Errorf("Viewer was able to send transforms: %v", err)
+	}
+
+	late, _ := curator.ReadDocument("", doc.ID)
+	if late.Document.Content != "hello, world" || late.Version != 2 {
+		t.Errorf("Late viewer received stale document: %v at %v", late.Document.Content, late.Version)
+	}
+}
+
+func TestCuratorEviction(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.EvictionPeriod = 1
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	evictions := make(chan EvictionEvent, 1)
+	curator.AddEvictionHook(func(event EvictionEvent) {
+		evictions <- event
+	})
+
+	doc, _ := store.NewDocument("hello world")
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal.SendTransform(OTransform{Position: 5, Insert: ",", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	portal.Exit(time.Second)
+
+	select {
+	case event := <-evictions:
+		if event.ID != portal.Document.ID || event.Version != 2 {
+			t.Errorf("Wrong eviction event: %v", event)
+		}
+		if event.Idle < time.Second {
+			t.Errorf("Evicted too early: %v", event.Idle)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for eviction")
+	}
+
+	_, open := curator.openBinder(portal.Document.ID)
+	if open {
+		t.Error("Evicted binder is still open")
+	}
+	if stored, _ := storage.Read(portal.Document.ID); stored.Content != "hello, world" {
+		t.Errorf("Evicted binder was not flushed: %v", stored.Content)
+	}
+}
+
+func TestCuratorDocuments(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.FlushPeriod = 60000
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	if err = curator.PutDocument(store.Document{ID: "doc", Content: "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := curator.GetDocument("doc"); err != nil || doc.Content != "hello world" {
+		t.Errorf("Wrong document: %v, %v", doc.Content, err)
+	}
+
+	portal, err := curator.EditDocument("", "doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = curator.PutDocument(store.Document{ID: "doc", Content: "overwritten"}); err != ErrDocumentOpen {
+		t.Errorf("Wrong error for overwriting open document: %v", err)
+	}
+
+	if _, err = portal.SendTransform(OTransform{Position: 5, Insert: " there", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err := curator.GetDocument("doc"); err != nil || doc.Content != "hello world" {
+		t.Errorf("Wrong flushed document: %v, %v", doc.Content, err)
+	}
+	if doc, err := curator.GetLiveDocument("doc", time.Second); err != nil || doc.Content != "hello there world" {
+		t.Errorf("Wrong live document: %v, %v", doc.Content, err)
+	}
+	if doc, err := curator.GetDocument("doc"); err != nil || doc.Content != "hello world" {
+		t.Errorf("Reading the live document flushed it: %v, %v", doc.Content, err)
+	}
+
+	if err = curator.DeleteDocument("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, open := <-portal.TransformRcvChan; open {
+		t.Error("Client of deleted document was not disconnected")
+	}
+	if _, err = curator.GetDocument("doc"); err == nil {
+		t.Error("Document was not deleted")
+	}
+}
+
+func TestCuratorBanUser(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.Replica.ViewersPerReplica = 2
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	// Bans apply to documents that are not open, and survive their binders being closed.
+	if err = curator.BanUser(doc.ID, "early", time.Minute, time.Second); err != nil {
+		t.Errorf("Failed to ban from a closed document: %v", err)
+	}
+	if _, err = curator.EditDocument("early", doc.ID); err != ErrClientBanned {
+		t.Errorf("Wrong error for client banned before opening: %v", err)
+	}
+	if err = curator.CloseDocument(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = curator.ReadDocument("early", doc.ID); err != ErrClientBanned {
+		t.Errorf("Wrong error for client banned before closing: %v", err)
+	}
+
+	if _, err = curator.EditDocument("editor", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := curator.ReadDocument("troll", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = curator.BanUser(doc.ID, "troll", time.Minute, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, open := <-viewer.TransformRcvChan:
+		if open {
+			t.Error("Banned viewer received a transform")
+		}
+	case <-time.After(time.Second):
+		t.Error("Banned viewer was not kicked from its replica")
+	}
+
+	if _, err = curator.ReadDocument("troll", doc.ID); err != ErrClientBanned {
+		t.Errorf("Wrong error for banned viewer: %v", err)
+	}
+	if _, err = curator.EditDocument("troll", doc.ID); err != ErrClientBanned {
+		t.Errorf("Wrong error for banned editor: %v", err)
+	}
+}
+
+type levelAuth map[string]auth.AccessLevel
+
+func (l levelAuth) AuthoriseCreate(token, userID string) bool {
+	return false
+}
+
+func (l levelAuth) Authorise(token, documentID string) auth.AccessLevel {
+	return l[token]
+}
+
+func (l levelAuth) RegisterHandlers(register.PubPrivEndpointRegister) error {
+	return nil
+}
+
+func TestCuratorAccessLevels(t *testing.T) {
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	levels := levelAuth{
+		"reader": auth.AccessRead,
+		"writer": auth.AccessWrite,
+		"admin":  auth.AccessAdmin,
+		"viewer": auth.AccessAdmin,
+	}
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, levels, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	if _, err = curator.ReadDocument("stranger", doc.ID); err != ErrUnauthorised {
+		t.Errorf("Wrong error for reading without access: %v", err)
+	}
+	if _, err = curator.EditDocument("reader", doc.ID); err != ErrUnauthorised {
+		t.Errorf("Wrong error for editing with read access: %v", err)
+	}
+	if _, err = curator.ReadDocument("reader", doc.ID); err != nil {
+		t.Errorf("Reader was refused: %v", err)
+	}
+	if _, err = curator.EditDocument("writer", doc.ID); err != nil {
+		t.Errorf("Writer was refused: %v", err)
+	}
+
+	for _, user := range []string{"writer", "admin", "viewer"} {
+		if err = curator.BanUser(doc.ID, user, time.Minute, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = curator.EditDocument("writer", doc.ID); err != ErrClientBanned {
+		t.Errorf("Wrong error for banned writer: %v", err)
+	}
+	if _, err = curator.EditDocument("admin", doc.ID); err != nil {
+		t.Errorf("Banned admin was refused: %v", err)
+	}
+	if _, err = curator.ReadDocument("viewer", doc.ID); err != nil {
+		t.Errorf("Banned admin was refused read access: %v", err)
+	}
+}
+
+func TestCuratorGetDocumentVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err = storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.HistoryLength = 1
+	config.BinderConfig.Recorder.Directory = dir
+
+	levels := levelAuth{"reader": auth.AccessRead, "writer": auth.AccessWrite}
+	curator, err := NewCurator(config, log, stats, levels, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	portal, err := curator.EditDocument("writer", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ot := range []OTransform{
+		{Position: 5, Insert: ",", Version: 2},
+		{Position: 12, Insert: "!", Version: 3},
+		{Position: 0, Delete: 1, Insert: "H", Version: 4},
+	} {
+		if _, err = portal.SendTransform(ot, time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = curator.GetDocumentVersion("stranger", doc.ID, 4, time.Second); err != ErrUnauthorised {
+		t.Errorf("Wrong error for reading a version without access: %v", err)
+	}
+
+	// Versions no longer held by the binder, and those of closed documents, come from the recording
+	check := func(version int, exp string) {
+		past, err := curator.GetDocumentVersion("reader", doc.ID, version, time.Second)
+		if err != nil {
+			t.Errorf("Get version %v error: %v", version, err)
+		} else if exp != past.Content || doc.ID != past.ID {
+			t.Errorf("Wrong document at version %v: %v", version, past)
+		}
+	}
+	check(4, "Hello, world!")
+	check(2, "hello, world")
+
+	if err = curator.CloseDocument(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	check(3, "hello, world!")
+	if _, err = curator.GetDocumentVersion("reader", doc.ID, 5, time.Second); err != ErrVersionNotExist {
+		t.Errorf("Expected version 5 not to exist, received: %v", err)
+	}
+}
+
+type onceAuth levelAuth
+
+func (o onceAuth) AuthoriseCreate(token, userID string) bool {
+	return false
+}
+
+func (o onceAuth) Authorise(token, documentID string) auth.AccessLevel {
+	level := o[token]
+	delete(o, token)
+	return level
+}
+
+func (o onceAuth) RegisterHandlers(register.PubPrivEndpointRegister) error {
+	return nil
+}
+
+func TestCuratorPreferenceTokens(t *testing.T) {
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, onceAuth{"tab": auth.AccessWrite}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	if _, err = curator.EditDocument("tab", doc.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The single use token of a connected client still identifies it
+	if err = curator.SetPreference("tab", doc.ID, NotificationPreference{Mode: NotifyMute}); err != nil {
+		t.Fatal(err)
+	}
+	pref, err := curator.GetPreference("tab", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pref.Mode != NotifyMute {
+		t.Errorf("Wrong preference: %v", pref.Mode)
+	}
+	if _, err = curator.GetPreference("stranger", doc.ID); err != ErrUnauthorised {
+		t.Errorf("Wrong error for a stranger: %v", err)
+	}
+}
+
+func TestCuratorDrain(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := store.NewDocument("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drainErrChan := make(chan error, 1)
+	go func() {
+		drainErrChan <- curator.Drain(5 * time.Second)
+	}()
+
+	select {
+	case msg := <-portal.MessageRcvChan:
+		if !msg.Shutdown {
+			t.Errorf("Expected shutdown notice: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for shutdown notice")
+	}
+
+	if _, err = curator.EditDocument("", portal.Document.ID); err != ErrCuratorDraining {
+		t.Errorf("Wrong error for join while draining: %v", err)
+	}
+	if _, err = curator.CreateDocument("", "", *doc); err != ErrCuratorDraining {
+		t.Errorf("Wrong error for create while draining: %v", err)
+	}
+
+	// Clients may still submit their final changes before leaving
+	if _, err = portal.SendTransform(OTransform{Position: 5, Insert: ",", Version: portal.Version + 1}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	portal.Exit(time.Second)
+
+	select {
+	case err = <-drainErrChan:
+		if err != nil {
+			t.Errorf("Drain error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Drain did not finish after the last client left")
+	}
+
+	stored, err := storage.Read(portal.Document.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "hello, world", stored.Content; exp != act {
+		t.Errorf("Drained content was not flushed: %v != %v", exp, act)
+	}
+
+	// Closing a drained curator is a no-op
+	curator.Close()
+}
+
+func TestCuratorShutdownReport(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	dir, err := ioutil.TempDir("", "leaps_shutdown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := DefaultCuratorConfig()
+	config.ShutdownReportPath = filepath.Join(dir, "report.json")
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := curator.GetShutdownReport(); ok {
+		t.Error("Shutdown report was available before draining")
+	}
+
+	docA, _ := store.NewDocument("hello world")
+	docB, _ := store.NewDocument("hello world")
+	leaving, err := curator.CreateDocument("", "", *docA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staying, err := curator.CreateDocument("", "", *docB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range staying.MessageRcvChan {
+		}
+	}()
+	go func() {
+		<-leaving.MessageRcvChan
+		leaving.Exit(time.Second)
+	}()
+
+	if err = curator.Drain(200 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	report, ok := curator.GetShutdownReport()
+	if !ok {
+		t.Fatal("Shutdown report was not available after draining")
+	}
+	sort.Strings(report.Flushed)
+	exp := []string{leaving.Document.ID, staying.Document.ID}
+	sort.Strings(exp)
+	if !reflect.DeepEqual(exp, report.Flushed) {
+		t.Errorf("Wrong flushed documents: %v != %v", exp, report.Flushed)
+	}
+	if len(report.Unflushed) != 0 {
+		t.Errorf("Unexpected unflushed documents: %v", report.Unflushed)
+	}
+	if exp, act := map[string][]string{staying.Document.ID: {staying.UserID}}, report.DisconnectedClients; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong disconnected clients: %v != %v", exp, act)
+	}
+	if report.DrainDuration < 200 {
+		t.Errorf("Drain duration too short: %vms", report.DrainDuration)
+	}
+
+	data, err := ioutil.ReadFile(config.ShutdownReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written ShutdownReport
+	if err = json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written.DisconnectedClients, report.DisconnectedClients) || len(written.Flushed) != 2 {
+		t.Errorf("Wrong written report: %s", data)
+	}
+}
+
+type testFencingSource struct {
+	token uint64
+}
+
+func (f *testFencingSource) FencingToken(documentID string) (uint64, error) {
+	return f.token, nil
+}
+
+func TestCuratorFencing(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	curator.UseFencing(&testFencingSource{token: 5})
+
+	doc, err := store.NewDocument("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal.SendTransform(OTransform{Position: 5, Insert: ",", Version: portal.Version + 1}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = curator.MergeDocument(store.Document{ID: portal.Document.ID, Content: "hello, world!"}, time.Second); err != nil {
+		t.Fatalf("Fenced flush error: %v", err)
+	}
+
+	// Another node has since been granted the lease, and our flushes must no longer land
+	storage.(store.FencedUpdater).UpdateFenced(store.Document{ID: portal.Document.ID, Content: "taken over"}, 6)
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "!", Version: portal.Version + 3}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	binder, _ := curator.openBinder(portal.Document.ID)
+	if _, err = binder.Snapshot(time.Second); err == nil || !strings.Contains(err.Error(), store.ErrStaleFencingToken.Error()) {
+		t.Errorf("Wrong error for stale flush: %v", err)
+	}
+	if stored, _ := storage.Read(portal.Document.ID); stored.Content != "taken over" {
+		t.Errorf("Stale flush was written: %v", stored.Content)
+	}
+}
+
+func TestCuratorCapabilities(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.MaxDocumentSize = 0
+	config.BinderConfig.ModelConfig.MaxDocumentSize = 1000
+	config.BinderConfig.MaxTransformSize = 100
+	config.BinderConfig.ModelConfig.MaxTransformLength = 0
+	config.BinderConfig.HistoryLength = 50
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	caps := curator.Capabilities()
+	if exp, act := uint64(1000), caps.MaxDocumentSize; exp != act {
+		t.Errorf("Wrong max document size: %v != %v", exp, act)
+	}
+	if exp, act := uint64(100), caps.MaxTransformSize; exp != act {
+		t.Errorf("Wrong max transform size: %v != %v", exp, act)
+	}
+	if exp, act := 50, caps.HistoryLength; exp != act {
+		t.Errorf("Wrong history length: %v != %v", exp, act)
+	}
+	if !caps.Presence || len(caps.DocumentTypes) != len(documentTypes) {
+		t.Errorf("Wrong document features: %v", caps)
+	}
+
+	restricted := caps.Restrict(Capabilities{
+		DocumentTypes:   []string{"json", "xml"},
+		MaxDocumentSize: 500,
+		HistoryLength:   100,
+	})
+	if exp, act := "[json]", fmt.Sprintf("%v", restricted.DocumentTypes); exp != act {
+		t.Errorf("Wrong restricted document types: %v != %v", exp, act)
+	}
+	if restricted.MaxDocumentSize != 500 || restricted.MaxTransformSize != 100 || restricted.HistoryLength != 50 {
+		t.Errorf("Wrong restricted limits: %v", restricted)
+	}
+	if restricted.Presence {
+		t.Error("Presence should not survive restriction")
+	}
+}
+
+func TestCuratorBreakLease(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	doc, _ := store.NewDocument("hello world")
+	storage.Create(*doc)
+
+	if _, err = curator.InspectLease(doc.ID); err != ErrClusterDisabled {
+		t.Errorf("Expected cluster disabled error, received: %v", err)
+	}
+
+	relay := NewMemoryRelay()
+	curator.UseRelay(relay)
+
+	if _, err = curator.InspectLease(doc.ID); err != ErrLeaseNotHeld {
+		t.Errorf("Expected lease not held error, received: %v", err)
+	}
+
+	// A node that takes the lease and then fails
+	failed := relay.NewNode()
+	failed.Lead(doc.ID)
+
+	info, err := curator.InspectLease(doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Node != failed.Node() || info.Local {
+		t.Errorf("Wrong lease holder: %v", info)
+	}
+
+	// A flush after the inspection shows that the holder is still alive
+	doc.Content = "hello flushed world"
+	storage.Update(*doc)
+	if err = curator.BreakLease(doc.ID, info.Node, info.Fingerprint); err != ErrLeaseChanged {
+		t.Errorf("Expected lease changed error, received: %v", err)
+	}
+
+	if info, err = curator.InspectLease(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	staleToken, _ := failed.FencingToken(doc.ID)
+	if err = curator.BreakLease(doc.ID, info.Node, info.Fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if leader, _ := relay.Leader(doc.ID); leader != "" {
+		t.Errorf("Lease was not broken: %v", leader)
+	}
+
+	// The failed node may wake up unaware of the break, but can no longer flush
+	stale := *doc
+	stale.Content = "hello stale world"
+	if err = storage.(store.FencedUpdater).UpdateFenced(stale, staleToken); err != store.ErrStaleFencingToken {
+		t.Errorf("Expected stale fencing token error, received: %v", err)
+	}
+
+	// The document can now be led by the curator
+	portal, err := curator.EditDocument("", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "hello flushed world", portal.Document.Content; exp != act {
+		t.Errorf("Wrong content: %v != %v", exp, act)
+	}
+	if info, _ = curator.InspectLease(doc.ID); !info.Local {
+		t.Errorf("Expected lease to be held locally: %v", info)
+	}
+	if err = curator.BreakLease(doc.ID, info.Node, info.Fingerprint); err != ErrLeaseHeldLocally {
+		t.Errorf("Expected lease held locally error, received: %v", err)
+	}
+}
+
+func TestCuratorStaleLeaseTimeout(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.Cluster.StaleLeaseTimeout = 1
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	relay := NewMemoryRelay()
+	curator.UseRelay(relay)
+
+	doc, _ := store.NewDocument("hello world")
+	storage.Create(*doc)
+	relay.NewNode().Lead(doc.ID)
+
+	// The first failure to sync with the leader only marks its lease as suspect
+	if _, err = curator.EditDocument("", doc.ID); err != ErrRelaySyncTimeout {
+		t.Fatalf("Expected sync timeout, received: %v", err)
+	}
+	if _, err = curator.EditDocument("", doc.ID); err != nil {
+		t.Fatalf("Expected stale lease to be broken, received: %v", err)
+	}
+	if leader, _ := relay.Leader(doc.ID); leader != relay.Node() {
+		t.Errorf("Wrong leader after breaking stale lease: %v", leader)
+	}
+}
+
+func TestCuratorInspectBinders(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	if _, err = curator.InspectBinder("nope", time.Second); err != ErrBinderNotFound {
+		t.Errorf("Expected binder not found, received: %v", err)
+	}
+
+	doc, _ := store.NewDocument("hello world")
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = curator.ReadDocument("", portal.Document.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal.SendTransform(OTransform{Version: 2, Insert: "why "}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := curator.InspectBinders(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 {
+		t.Fatalf("Wrong number of binders: %v", len(infos))
+	}
+	info := infos[0]
+	if info.ID != portal.Document.ID || info.Epoch != portal.Epoch {
+		t.Errorf("Wrong binder: %+v", info)
+	}
+	if info.Version != 2 || info.FlushedVersion != 1 || info.Unflushed != 1 {
+		t.Errorf("Wrong versions: %+v", info)
+	}
+	if info.Subscribers != 2 || info.ReadOnly != 1 {
+		t.Errorf("Wrong subscribers: %+v", info)
+	}
+	if info.LastFlush.IsZero() {
+		t.Error("Expected a last flush time")
+	}
+	if info.MemoryEstimate <= info.Size {
+		t.Errorf("Memory estimate should exceed document size: %+v", info)
+	}
+
+	single, err := curator.InspectBinder(portal.Document.ID, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single.Version != info.Version {
+		t.Errorf("Wrong binder: %+v", single)
+	}
+}
+
+func TestCuratorFlushAndCloseDocument(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	if _, err = curator.FlushDocument("nope", time.Second); err != ErrBinderNotFound {
+		t.Errorf("Expected binder not found, received: %v", err)
+	}
+	if err = curator.CloseDocument("nope"); err != ErrBinderNotFound {
+		t.Errorf("Expected binder not found, received: %v", err)
+	}
+
+	doc, _ := store.NewDocument("hello world")
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := portal.Document.ID
+	if _, err = portal.SendTransform(OTransform{Version: 2, Insert: "why "}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := curator.FlushDocument(id, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("Wrong flushed version: %v", version)
+	}
+	if stored, _ := storage.Read(id); stored.Content != "why hello world" {
+		t.Errorf("Wrong stored content: %v", stored.Content)
+	}
+
+	if _, err = portal.SendTransform(OTransform{Version: 3, Insert: "oh "}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = curator.CloseDocument(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, open := curator.openBinder(id); open {
+		t.Error("Binder still open after closing")
+	}
+	if stored, _ := storage.Read(id); stored.Content != "oh why hello world" {
+		t.Errorf("Wrong stored content after close: %v", stored.Content)
+	}
+	select {
+	case _, open := <-portal.TransformRcvChan:
+		if open {
+			t.Error("Expected client to be disconnected")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for client to be disconnected")
+	}
+
+	// The document can be opened again
+	if _, err = curator.EditDocument("", id); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCuratorCloseWhileJoining(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	doc, _ := store.NewDocument("hello world")
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := portal.Document.ID
+
+	stop := make(chan struct{})
+	errs := make(chan error, 16)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				joined, err := curator.EditDocument("", id)
+				if err == ErrBinderClosed || err == store.ErrDocumentNotExist {
+					continue
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+				joined.Exit(time.Second)
+			}
+		}()
+	}
+
+	// Joins racing closes, and the deletion of the document, must fail cleanly rather than panic
+	for i := 0; i < 50; i++ {
+		curator.CloseDocument(id)
+	}
+	if err = curator.DeleteDocument(id); err != nil {
+		t.Error(err)
+	}
+	close(stop)
+	wg.Wait()
+
+	select {
+	case err := <-errs:
+		t.Errorf("Join failed: %v", err)
+	default:
+	}
+}
+
+func TestCuratorExportDocument(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	closed, _ := store.NewDocument("stored content")
+	storage.Create(*closed)
+
+	export, err := curator.ExportDocument(closed.ID, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if export.Open || export.Version != 0 || export.Document.Content != "stored content" {
+		t.Errorf("Wrong export of closed document: %+v", export)
+	}
+
+	doc, _ := store.NewDocument("hello world")
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal.SendTransform(OTransform{Version: 2, Insert: "why "}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if export, err = curator.ExportDocument(portal.Document.ID, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if !export.Open || export.Version != 2 || export.Epoch != portal.Epoch {
+		t.Errorf("Wrong export of open document: %+v", export)
+	}
+	if exp, act := "why hello world", export.Document.Content; exp != act {
+		t.Errorf("Wrong content: %v != %v", exp, act)
+	}
+
+	// Exports of open documents leave them unflushed
+	if stored, err := storage.Read(portal.Document.ID); err != nil || stored.Content != "hello world" {
+		t.Errorf("Export flushed the document: %v, %v", stored.Content, err)
+	}
+
+	if _, err = curator.ExportDocument("nope", time.Second); err != store.ErrDocumentNotExist {
+		t.Errorf("Expected document not exist, received: %v", err)
+	}
+}
+
+func TestCuratorDrainReplicas(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.Replica.ViewersPerReplica = 2
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := store.NewDocument("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	editor, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	viewer, err := curator.ReadDocument("", editor.Document.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drainErrChan := make(chan error, 1)
+	go func() {
+		drainErrChan <- curator.Drain(5 * time.Second)
+	}()
+
+	for _, portal := range []BinderPortal{editor, viewer} {
+		select {
+		case msg := <-portal.MessageRcvChan:
+			for !msg.Shutdown {
+				msg = <-portal.MessageRcvChan
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for shutdown notice")
+		}
+	}
+
+	// Viewers arriving at the replica after the notice would never hear of it, and so are refused.
+	s := curator.shard(editor.Document.ID)
+	s.mutex.Lock()
+	replica := s.replicas[editor.Document.ID][0]
+	s.mutex.Unlock()
+	if late := replica.Subscribe(""); late.Error != ErrBinderDraining {
+		t.Errorf("Wrong error for a late viewer: %v", late.Error)
+	}
+
+	editor.Exit(time.Second)
+	viewer.Exit(time.Second)
+
+	select {
+	case err = <-drainErrChan:
+		if err != nil {
+			t.Errorf("Drain error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not finish promptly after the last client left")
+	}
+}
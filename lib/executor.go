@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ExecutorConfig - Holds configuration options for the sandbox that hooks run external commands in.
+Only commands whose program exactly matches an entry of Allowed may run, and an empty list allows
+none. Timeout caps the milliseconds a command may run for regardless of what a hook asks for, and
+MaxOutput caps the bytes a command may write to stdout. MaxCPU (seconds of CPU time) and MaxMemory
+(megabytes of virtual memory) are enforced through the ulimit of a POSIX shell when set, and are not
+supported on windows.
+*/
+type ExecutorConfig struct {
+	Allowed   []string `json:"allowed_commands" yaml:"allowed_commands"`
+	Timeout   int64    `json:"timeout_ms" yaml:"timeout_ms"`
+	MaxOutput int64    `json:"max_output_bytes" yaml:"max_output_bytes"`
+	MaxCPU    int64    `json:"max_cpu_s" yaml:"max_cpu_s"`
+	MaxMemory int64    `json:"max_memory_mb" yaml:"max_memory_mb"`
+}
+
+/*
+NewExecutorConfig - Returns a default executor configuration, which allows no commands.
+*/
+func NewExecutorConfig() ExecutorConfig {
+	return ExecutorConfig{
+		Allowed:   []string{},
+		Timeout:   5000,
+		MaxOutput: 10000000, // ~10MB
+		MaxCPU:    0,
+		MaxMemory: 0,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Executor type.
+var (
+	ErrEmptyCommand        = errors.New("command was not specified")
+	ErrCommandNotAllowed   = errors.New("command is not in the allowed list")
+	ErrCommandTimeout      = errors.New("command timed out")
+	ErrOutputLimit         = errors.New("command exceeded the output size limit")
+	ErrLimitsNotSupported  = errors.New("command cpu and memory limits are not supported on this platform")
+	ErrCommandLimitReached = errors.New("command exceeded its cpu or memory limit")
+)
+
+/*
+executorWaitDelay - How long to wait for the output of a killed command to be closed before giving up
+on it, which matters when the command has spawned children that escaped being killed with it.
+*/
+const executorWaitDelay = 500 * time.Millisecond
+
+/*
+Executor - Runs external commands on behalf of hooks such as formatters, within the limits of its
+configuration so that a misconfigured hook cannot exhaust the resources of the server.
+*/
+type Executor struct {
+	config  ExecutorConfig
+	allowed map[string]struct{}
+	log     *log.Logger
+	stats   *log.Stats
+}
+
+/*
+NewExecutor - Create a new executor.
+*/
+func NewExecutor(config ExecutorConfig, logger *log.Logger, stats *log.Stats) (*Executor, error) {
+	if runtime.GOOS == "windows" && (config.MaxCPU > 0 || config.MaxMemory > 0) {
+		return nil, ErrLimitsNotSupported
+	}
+	allowed := map[string]struct{}{}
+	for _, command := range config.Allowed {
+		allowed[command] = struct{}{}
+	}
+	return &Executor{
+		config:  config,
+		allowed: allowed,
+		log:     logger.NewModule(":executor"),
+		stats:   stats,
+	}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+cappedBuffer - A buffer that stops accepting writes beyond a limit, and calls exceeded the first time
+the limit is reached. Writes always appear to succeed so that the command is not blocked on a full
+pipe while it is being killed.
+*/
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int64
+	over     bool
+	exceeded func()
+}
+
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	if c.over {
+		return len(p), nil
+	}
+	if c.limit > 0 && int64(c.buf.Len()+len(p)) > c.limit {
+		c.over = true
+		c.exceeded()
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+/*
+wrap - Returns the command wrapped in a shell that applies our cpu and memory limits.
+*/
+func (e *Executor) wrap(command []string) []string {
+	var limits []string
+	if e.config.MaxCPU > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -t %v", e.config.MaxCPU))
+	}
+	if e.config.MaxMemory > 0 {
+		limits = append(limits, fmt.Sprintf("ulimit -v %v", e.config.MaxMemory*1024))
+	}
+	if len(limits) == 0 {
+		return command
+	}
+	script := strings.Join(limits, " && ") + ` && exec "$@"`
+	return append([]string{"/bin/sh", "-c", script, "sh"}, command...)
+}
+
+/*
+Run - Run a command with stdin as its input and return what it writes to stdout. The command is
+killed once timeout (capped by the configured timeout, and zero for no timeout) has passed, or once
+its output exceeds the configured limit, along with any children it has spawned where the platform
+supports process groups.
+*/
+func (e *Executor) Run(command []string, stdin string, timeout time.Duration) (string, error) {
+	if len(command) == 0 {
+		return "", ErrEmptyCommand
+	}
+	if _, ok := e.allowed[command[0]]; !ok {
+		e.stats.Incr("executor.run.denied", 1)
+		e.log.Warnf("Refused to run command %v: not in the allowed list\n", command[0])
+		return "", fmt.Errorf("%v: %v", ErrCommandNotAllowed, command[0])
+	}
+	if max := time.Duration(e.config.Timeout) * time.Millisecond; max > 0 && (timeout <= 0 || timeout > max) {
+		timeout = max
+	}
+
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	args := e.wrap(command)
+	stdout := cappedBuffer{limit: e.config.MaxOutput, exceeded: cancel}
+	stderr := cappedBuffer{limit: 4096, exceeded: func() {}}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	cmd.WaitDelay = executorWaitDelay
+	isolateProcess(cmd)
+
+	err := cmd.Run()
+	switch {
+	case stdout.over:
+		e.stats.Incr("executor.run.output_limit", 1)
+		e.log.Warnf("Killed command %v for exceeding the output limit\n", command[0])
+		return "", ErrOutputLimit
+	case ctx.Err() == context.DeadlineExceeded:
+		e.stats.Incr("executor.run.timeout", 1)
+		e.log.Warnf("Killed command %v after timing out\n", command[0])
+		return "", ErrCommandTimeout
+	case err != nil:
+		e.stats.Incr("executor.run.error", 1)
+		if exitErr, ok := err.(*exec.ExitError); ok && !exitErr.Exited() &&
+			(e.config.MaxCPU > 0 || e.config.MaxMemory > 0) {
+			return "", fmt.Errorf("%v: %v", ErrCommandLimitReached, err)
+		}
+		return "", fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.buf.Bytes()))
+	}
+	e.stats.Incr("executor.run.success", 1)
+	return stdout.buf.String(), nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
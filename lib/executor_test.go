@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecutor(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewExecutorConfig()
+	config.Allowed = []string{"cat", "sleep", "yes"}
+	config.Timeout = 100
+	config.MaxOutput = 1000
+
+	executor, err := NewExecutor(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := executor.Run([]string{"cat"}, "hello world", time.Second); err != nil || out != "hello world" {
+		t.Errorf("Wrong output: %q, %v", out, err)
+	}
+	if _, err := executor.Run([]string{"/bin/cat"}, "hello world", time.Second); err == nil ||
+		!strings.HasPrefix(err.Error(), ErrCommandNotAllowed.Error()) {
+		t.Errorf("Wrong error for command outside of the allowed list: %v", err)
+	}
+	if _, err := executor.Run([]string{}, "", time.Second); err != ErrEmptyCommand {
+		t.Errorf("Wrong error for empty command: %v", err)
+	}
+
+	// The configured timeout caps the timeout asked for.
+	started := time.Now()
+	if _, err := executor.Run([]string{"sleep", "5"}, "", time.Minute); err != ErrCommandTimeout {
+		t.Errorf("Wrong error for slow command: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Slow command was not killed in time: %v", elapsed)
+	}
+
+	if _, err := executor.Run([]string{"yes"}, "", time.Second); err != ErrOutputLimit {
+		t.Errorf("Wrong error for noisy command: %v", err)
+	}
+}
+
+func TestExecutorKillsChildren(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewExecutorConfig()
+	config.Allowed = []string{"sh"}
+	config.Timeout = 100
+
+	executor, err := NewExecutor(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The child sleep holds on to stdout after its parent shell is killed.
+	started := time.Now()
+	if _, err := executor.Run([]string{"sh", "-c", "sleep 5; echo done"}, "", time.Minute); err != ErrCommandTimeout {
+		t.Errorf("Wrong error for slow command: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Children of slow command were not killed in time: %v", elapsed)
+	}
+}
+
+func TestExecutorLimits(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewExecutorConfig()
+	config.Allowed = []string{"cat"}
+	config.MaxCPU = 1
+	config.MaxMemory = 512
+
+	executor, err := NewExecutor(config, logger, stats)
+	if err == ErrLimitsNotSupported {
+		t.Skip(err)
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := executor.Run([]string{"cat"}, "hello world", time.Second); err != nil || out != "hello world" {
+		t.Errorf("Wrong output with limits: %q, %v", out, err)
+	}
+}
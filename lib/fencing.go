@@ -0,0 +1,76 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+FencingSource - Implemented by the distributed lock that grants this node its leases over documents
+when several nodes share a store. FencingToken returns the token of the lease currently held over a
+document, which must increase each time a lease over the document is granted, or an error when the
+lease is not held. Every Relay is a FencingSource, and a curator fences the flushes of its binders
+with the leases of its relay unless given another source with UseFencing.
+*/
+type FencingSource interface {
+	FencingToken(documentID string) (uint64, error)
+}
+
+/*
+fencedStore - Wraps the store written to by binders so that flushes carry the fencing token of the
+lease over the document, allowing stores that support conditional writes to reject flushes from a
+node that has lost its lease. Stores without conditional writes are written to as usual.
+*/
+type fencedStore struct {
+	store.Store
+	source FencingSource
+}
+
+/*
+Update - Update an existing document, fenced by the token of our current lease over it.
+*/
+func (f fencedStore) Update(doc store.Document) error {
+	fenced, ok := f.Store.(store.FencedUpdater)
+	if !ok {
+		return f.Store.Update(doc)
+	}
+	token, err := f.source.FencingToken(doc.ID)
+	if err != nil {
+		return err
+	}
+	return fenced.UpdateFenced(doc, token)
+}
+
+/*
+KeepsMetadata - Whether the wrapped store reads back the metadata of documents.
+*/
+func (f fencedStore) KeepsMetadata() bool {
+	return store.KeepsMetadata(f.Store)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,170 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"path"
+	"strings"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+FormatterConfig - Holds configuration options for a document formatter. Type can be 'gofmt' (format
+Go source), 'json' (pretty print JSON with Indent) or 'command' (pipe the document through Command,
+which must write the formatted document to stdout within Timeout milliseconds). Commands are run by
+an Executor, and so must be allowed by its configuration.
+*/
+type FormatterConfig struct {
+	Type    string   `json:"type" yaml:"type"`
+	Indent  string   `json:"indent" yaml:"indent"`
+	Command []string `json:"command" yaml:"command"`
+	Timeout int64    `json:"timeout_ms" yaml:"timeout_ms"`
+}
+
+/*
+NewFormatterConfig - Returns a default formatter configuration.
+*/
+func NewFormatterConfig() FormatterConfig {
+	return FormatterConfig{
+		Type:    "gofmt",
+		Indent:  "  ",
+		Command: []string{},
+		Timeout: 1000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for formatters.
+var (
+	ErrInvalidFormatterType = errors.New("invalid formatter type")
+	ErrNoFormatterCommand   = errors.New("formatter command was not specified")
+	ErrNoFormatterExecutor  = errors.New("command formatters require an executor")
+)
+
+/*
+FormatterAuthor - The author of transforms submitted by a formatter.
+*/
+const FormatterAuthor = "formatter"
+
+/*
+Formatter - Returns the formatted form of the content of a document.
+*/
+type Formatter func(content string) (string, error)
+
+/*
+FormatterFactory - Returns the formatter of a configuration, command formatters are run with the
+executor.
+*/
+func FormatterFactory(config FormatterConfig, executor *Executor) (Formatter, error) {
+	switch config.Type {
+	case "gofmt":
+		return formatGo, nil
+	case "json":
+		return func(content string) (string, error) {
+			return formatJSON(content, config.Indent)
+		}, nil
+	case "command":
+		if len(config.Command) == 0 {
+			return nil, ErrNoFormatterCommand
+		}
+		if executor == nil {
+			return nil, ErrNoFormatterExecutor
+		}
+		return func(content string) (string, error) {
+			return executor.Run(config.Command, content, time.Duration(config.Timeout)*time.Millisecond)
+		}, nil
+	}
+	return nil, fmt.Errorf("%v: %v", ErrInvalidFormatterType, config.Type)
+}
+
+/*
+createFormatter - Create the formatter of a document, a formatter configured for the extension of
+its ID takes precedence over one configured for its type. Only text documents are formatted, nil is
+returned when no formatter applies.
+*/
+func createFormatter(
+	configs map[string]FormatterConfig, executor *Executor, id, docType string,
+) (Formatter, error) {
+	if !isTextType(docType) {
+		return nil, nil
+	}
+	if ext := path.Ext(id); len(ext) > 0 {
+		if config, ok := configs[ext]; ok {
+			return FormatterFactory(config, executor)
+		}
+	}
+	if config, ok := configs["text"]; ok {
+		return FormatterFactory(config, executor)
+	}
+	return nil, nil
+}
+
+/*
+formatTransform - Returns the transform that turns content into its formatted form.
+*/
+func formatTransform(content, formatted string) OTransform {
+	ot := diffTextTransform(content, formatted)
+	ot.Author = FormatterAuthor
+	return ot
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+formatGo - Format Go source as gofmt would.
+*/
+func formatGo(content string) (string, error) {
+	formatted, err := format.Source([]byte(content))
+	if err != nil {
+		return "", err
+	}
+	return string(formatted), nil
+}
+
+/*
+formatJSON - Pretty print JSON, keeping the presence of a trailing newline.
+*/
+func formatJSON(content, indent string) (string, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(strings.TrimSpace(content)), "", indent); err != nil {
+		return "", err
+	}
+	if strings.HasSuffix(content, "\n") {
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestFormatters(t *testing.T) {
+	jsonConfig := NewFormatterConfig()
+	jsonConfig.Type = "json"
+
+	logger, stats := loggerAndStats()
+	sandbox := NewExecutorConfig()
+	sandbox.Allowed = []string{"tr"}
+	executor, err := NewExecutor(sandbox, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		config   FormatterConfig
+		content  string
+		expected string
+	}{
+		{NewFormatterConfig(), "package main\nfunc  main( ) {}\n", "package main\n\nfunc main() {}\n"},
+		{jsonConfig, `{"a":[1,2]}` + "\n", "{\n  \"a\": [\n    1,\n    2\n  ]\n}\n"},
+		{FormatterConfig{Type: "command", Command: []string{"tr", "a-z", "A-Z"}, Timeout: 1000}, "hello", "HELLO"},
+	} {
+		formatter, err := FormatterFactory(test.config, executor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatted, err := formatter(test.content)
+		if err != nil {
+			t.Errorf("Failed to %v format: %v", test.config.Type, err)
+		} else if formatted != test.expected {
+			t.Errorf("Wrong %v format: %q != %q", test.config.Type, formatted, test.expected)
+		}
+
+		ot := formatTransform(test.content, test.expected)
+		ot.Version = 2
+		if result, err := replayTextTransforms(test.content, []OTransform{ot}); err != nil || result != test.expected {
+			t.Errorf("Wrong format transform %v: %q, %v", ot, result, err)
+		}
+	}
+
+	denied := FormatterConfig{Type: "command", Command: []string{"rm", "-rf", "/"}, Timeout: 10}
+	formatter, _ := FormatterFactory(denied, executor)
+	if _, err := formatter("hello"); err == nil {
+		t.Error("Expected command outside of the allowed list to be refused")
+	}
+	if _, err := FormatterFactory(denied, nil); err != ErrNoFormatterExecutor {
+		t.Errorf("Wrong error for missing executor: %v", err)
+	}
+}
+
+func TestBinderFormatter(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc := store.Document{ID: "config.json", Content: `{"a":1}`}
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 10
+	config.Formatters = map[string]FormatterConfig{
+		".json": {Type: "json", Indent: " "},
+	}
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer := binder.Subscribe("")
+	if _, err = writer.SendTransform(OTransform{Position: 6, Insert: `,"b":2`, Version: 2, Author: "me"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ot := <-writer.TransformRcvChan:
+		if ot.Author != FormatterAuthor || ot.Version != 3 {
+			t.Errorf("Wrong formatter transform: %v", ot)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for formatter transform")
+	}
+
+	snapshot, err := binder.Snapshot(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "{\n \"a\": 1,\n \"b\": 2\n}"; snapshot.Document.Content != exp {
+		t.Errorf("Wrong formatted content: %q != %q", snapshot.Document.Content, exp)
+	}
+}
+
+func TestBinderSlowFormatter(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc := store.Document{ID: "notes.txt", Content: "hello"}
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 10
+	config.CommandSandbox.Allowed = []string{"sh"}
+	config.Formatters = map[string]FormatterConfig{
+		".txt": {Type: "command", Command: []string{"sh", "-c", "sleep 0.3; tr a-z A-Z"}, Timeout: 5000},
+	}
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer := binder.Subscribe("")
+	if _, err = writer.SendTransform(OTransform{Position: 5, Insert: " world", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// Edits are accepted whilst the document is being formatted
+	before := time.Now()
+	if _, err = writer.SendTransform(OTransform{Position: 11, Insert: "!", Version: 3}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(before); waited > 200*time.Millisecond {
+		t.Errorf("Transform waited on the formatter for %v", waited)
+	}
+
+	for {
+		select {
+		case ot := <-writer.TransformRcvChan:
+			if ot.Author != FormatterAuthor {
+				continue
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for formatter transform")
+		}
+		break
+	}
+
+	snapshot, err := binder.Peek(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "HELLO WORLD!"; snapshot.Document.Content != exp {
+		t.Errorf("Wrong formatted content: %q != %q", snapshot.Document.Content, exp)
+	}
+}
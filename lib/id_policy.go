@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+IDPolicyConfig - Rules that the IDs chosen for new documents and aliases must follow, allowing
+integrations to guarantee that IDs are safe to use within their URLs and do not collide with their
+own routes. Pattern is a regular expression the whole ID must match, MaxLength limits the number of
+bytes of an ID and IDs may not begin with any of the ReservedPrefixes. Empty or zero values disable
+a rule. The IDs that leaps generates itself are not subject to the policy.
+*/
+type IDPolicyConfig struct {
+	Pattern          string   `json:"pattern" yaml:"pattern"`
+	MaxLength        int      `json:"max_length" yaml:"max_length"`
+	ReservedPrefixes []string `json:"reserved_prefixes" yaml:"reserved_prefixes"`
+}
+
+/*
+NewIDPolicyConfig - Returns a default IDPolicyConfig, which accepts any ID.
+*/
+func NewIDPolicyConfig() IDPolicyConfig {
+	return IDPolicyConfig{
+		Pattern:          "",
+		MaxLength:        0,
+		ReservedPrefixes: []string{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the IDPolicy type, each rule broken is an ErrIDPolicy.
+var (
+	ErrIDPolicy        = errors.New("document ID breaks the ID policy")
+	ErrIDEmpty         = fmt.Errorf("%w: it is empty", ErrIDPolicy)
+	ErrIDTooLong       = fmt.Errorf("%w: it exceeds the maximum length", ErrIDPolicy)
+	ErrIDInvalid       = fmt.Errorf("%w: it does not match the required pattern", ErrIDPolicy)
+	ErrIDReservedRange = fmt.Errorf("%w: it begins with a reserved prefix", ErrIDPolicy)
+)
+
+/*
+IDPolicy - Validates the IDs chosen for new documents and aliases against the rules of an
+IDPolicyConfig.
+*/
+type IDPolicy struct {
+	config  IDPolicyConfig
+	pattern *regexp.Regexp
+}
+
+/*
+NewIDPolicy - Create an IDPolicy from a config, returns an error if the pattern cannot be compiled.
+The pattern is anchored so that it must match the whole ID.
+*/
+func NewIDPolicy(config IDPolicyConfig) (*IDPolicy, error) {
+	policy := IDPolicy{config: config}
+	if len(config.Pattern) > 0 {
+		var err error
+		if policy.pattern, err = regexp.Compile("^(?:" + config.Pattern + ")$"); err != nil {
+			return nil, err
+		}
+	}
+	return &policy, nil
+}
+
+/*
+Validate - Returns an error describing the first rule that an ID breaks, or nil if it follows them
+all.
+*/
+func (p *IDPolicy) Validate(id string) error {
+	if len(id) == 0 {
+		return ErrIDEmpty
+	}
+	if p.config.MaxLength > 0 && len(id) > p.config.MaxLength {
+		return ErrIDTooLong
+	}
+	for _, prefix := range p.config.ReservedPrefixes {
+		if len(prefix) > 0 && strings.HasPrefix(id, prefix) {
+			return ErrIDReservedRange
+		}
+	}
+	if p.pattern != nil && !p.pattern.MatchString(id) {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
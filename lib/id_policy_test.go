@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestIDPolicy(t *testing.T) {
+	config := NewIDPolicyConfig()
+	config.Pattern = "[a-z0-9-]+(/[a-z0-9-]+)*"
+	config.MaxLength = 16
+	config.ReservedPrefixes = []string{"api/", "_"}
+
+	policy, err := NewIDPolicy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, exp := range map[string]error{
+		"notes":             nil,
+		"team/notes-1":      nil,
+		"":                  ErrIDEmpty,
+		"a-very-long-id-ok": ErrIDTooLong,
+		"api/spec":          ErrIDReservedRange,
+		"_hidden":           ErrIDReservedRange,
+		"Notes":             ErrIDInvalid,
+		"notes?x=1":         ErrIDInvalid,
+		"prefix notes":      ErrIDInvalid,
+	} {
+		if act := policy.Validate(id); act != exp {
+			t.Errorf("Wrong result for %q: %v != %v", id, act, exp)
+		}
+	}
+
+	config.Pattern = "[unclosed"
+	if _, err = NewIDPolicy(config); err == nil {
+		t.Error("Expected error from bad pattern")
+	}
+}
+
+func TestCuratorIDPolicy(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.IDPolicy.Pattern = "[a-z/]+"
+	config.IDPolicy.ReservedPrefixes = []string{"admin/"}
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	if err = curator.PutDocument(store.Document{ID: "admin/settings", Content: "x"}); err != ErrIDReservedRange {
+		t.Errorf("Wrong error for reserved ID: %v", err)
+	}
+	if err = curator.PutDocument(store.Document{ID: "notes", Content: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = curator.MergeDocument(store.Document{ID: "admin/other", Content: "x"}, 0); err != ErrIDReservedRange {
+		t.Errorf("Wrong error for reserved merge ID: %v", err)
+	}
+	if _, err = curator.CreateDocument("", "", store.Document{Content: "x"}); err != nil {
+		t.Errorf("Generated ID was subject to the policy: %v", err)
+	}
+	if !errors.Is(ErrIDInvalid, ErrIDPolicy) {
+		t.Error("Expected rules of the policy to be an ErrIDPolicy")
+	}
+}
@@ -0,0 +1,184 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+LatencyPercentiles - Percentiles in milliseconds of the submit to broadcast latency of transforms,
+taken over a rolling window of the most recent samples.
+*/
+type LatencyPercentiles struct {
+	Samples int     `json:"samples" yaml:"samples"`
+	P50     float64 `json:"p50_ms" yaml:"p50_ms"`
+	P95     float64 `json:"p95_ms" yaml:"p95_ms"`
+	P99     float64 `json:"p99_ms" yaml:"p99_ms"`
+}
+
+/*
+LatencyReport - Latency percentiles across all documents and for each open document.
+*/
+type LatencyReport struct {
+	Global    LatencyPercentiles            `json:"global" yaml:"global"`
+	Documents map[string]LatencyPercentiles `json:"documents" yaml:"documents"`
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+latencyWindow - A ring buffer of the most recent latency samples.
+*/
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	full    bool
+}
+
+func newLatencyWindow(size int) *latencyWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &latencyWindow{samples: make([]time.Duration, size)}
+}
+
+func (l *latencyWindow) record(latency time.Duration) {
+	l.samples[l.next] = latency
+	if l.next++; l.next == len(l.samples) {
+		l.next, l.full = 0, true
+	}
+}
+
+func (l *latencyWindow) percentiles() LatencyPercentiles {
+	n := l.next
+	if l.full {
+		n = len(l.samples)
+	}
+	if n == 0 {
+		return LatencyPercentiles{}
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, l.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// Nearest rank percentile.
+	rank := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(n))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return float64(sorted[i]) / float64(time.Millisecond)
+	}
+	return LatencyPercentiles{
+		Samples: n,
+		P50:     rank(0.50),
+		P95:     rank(0.95),
+		P99:     rank(0.99),
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+LatencyTracker - Tracks the submit to broadcast latency of transforms globally and per document.
+Shared by all binders of a curator, and safe to use from any goroutine. A nil tracker ignores
+everything.
+*/
+type LatencyTracker struct {
+	size      int
+	global    *latencyWindow
+	documents map[string]*latencyWindow
+	mutex     sync.Mutex
+}
+
+/*
+NewLatencyTracker - Create a tracker that keeps windows of the given number of samples.
+*/
+func NewLatencyTracker(size int) *LatencyTracker {
+	return &LatencyTracker{
+		size:      size,
+		global:    newLatencyWindow(size),
+		documents: map[string]*latencyWindow{},
+	}
+}
+
+/*
+record - Add a latency sample for a document.
+*/
+func (t *LatencyTracker) record(documentID string, latency time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.global.record(latency)
+	window, ok := t.documents[documentID]
+	if !ok {
+		window = newLatencyWindow(t.size)
+		t.documents[documentID] = window
+	}
+	window.record(latency)
+}
+
+/*
+forget - Drop the samples of a document, called once its binder is closed.
+*/
+func (t *LatencyTracker) forget(documentID string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	delete(t.documents, documentID)
+	t.mutex.Unlock()
+}
+
+/*
+Report - Returns the current percentiles.
+*/
+func (t *LatencyTracker) Report() LatencyReport {
+	report := LatencyReport{Documents: map[string]LatencyPercentiles{}}
+	if t == nil {
+		return report
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	report.Global = t.global.percentiles()
+	for id, window := range t.documents {
+		report.Documents[id] = window.percentiles()
+	}
+	return report
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
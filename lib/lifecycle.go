@@ -0,0 +1,154 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Events in the lifecycle of a document.
+const (
+	LifecycleCreated   = "created"
+	LifecycleFirstJoin = "first_join"
+	LifecycleLastLeave = "last_leave"
+	LifecycleFlushed   = "flushed"
+)
+
+/*
+LifecycleEvent - Describes a change in the lifecycle of a document: its creation, the first client
+joining a binder with none, the last client leaving or a flush that changed the stored document.
+Version and Digest describe the document as last flushed to the store, where Digest is the SHA-256
+of its content.
+*/
+type LifecycleEvent struct {
+	Event   string    `json:"event" yaml:"event"`
+	ID      string    `json:"id" yaml:"id"`
+	Version int       `json:"version" yaml:"version"`
+	Digest  string    `json:"digest" yaml:"digest"`
+	Time    time.Time `json:"time" yaml:"time"`
+}
+
+/*
+newLifecycleWebhookConfig - Returns the default configuration of the lifecycle webhook, which unlike
+other webhooks retries failed posts as its events usually trigger pipelines.
+*/
+func newLifecycleWebhookConfig() WebhookConfig {
+	config := NewWebhookConfig()
+	config.Retries = 3
+	return config
+}
+
+/*
+LifecycleHook - A function called by a curator for each lifecycle event of its documents.
+*/
+type LifecycleHook func(event LifecycleEvent)
+
+/*
+contentDigest - Returns the digest of document content carried by lifecycle events.
+*/
+func contentDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AddLifecycleHook - Register a function to be called for each lifecycle event of the documents of
+this curator. Hooks are called in order from the binders of the documents, or from the curator as
+documents are created, and must therefore return quickly.
+*/
+func (c *Curator) AddLifecycleHook(hook LifecycleHook) {
+	c.mutex.Lock()
+	c.lifecycleHooks = append(c.lifecycleHooks, hook)
+	c.mutex.Unlock()
+}
+
+/*
+emitLifecycle - Call the lifecycle hooks with an event.
+*/
+func (c *Curator) emitLifecycle(event LifecycleEvent) {
+	c.stats.Incr("curator.lifecycle."+event.Event, 1)
+	c.mutex.RLock()
+	hooks := c.lifecycleHooks
+	c.mutex.RUnlock()
+	for _, hook := range hooks {
+		hook(event)
+	}
+}
+
+/*
+created - Emit the lifecycle event of a document created by the curator.
+*/
+func (c *Curator) created(id, content string) {
+	c.emitLifecycle(LifecycleEvent{
+		Event:   LifecycleCreated,
+		ID:      id,
+		Version: 1,
+		Digest:  contentDigest(content),
+		Time:    time.Now(),
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+trackLifecycle - Report the first client joining and the last client leaving the binder since the
+previous call.
+*/
+func (b *Binder) trackLifecycle() {
+	switch {
+	case len(b.clients) > 0 && !b.occupied:
+		b.occupied = true
+		b.lifecycle(LifecycleFirstJoin)
+	case len(b.clients) == 0 && b.occupied:
+		b.occupied = false
+		b.lifecycle(LifecycleLastLeave)
+	}
+}
+
+/*
+lifecycle - Report a lifecycle event of the document to the lifecycle hook, if there is one.
+*/
+func (b *Binder) lifecycle(event string) {
+	if b.lifecycleHook == nil {
+		return
+	}
+	b.lifecycleHook(LifecycleEvent{
+		Event:   event,
+		ID:      b.ID,
+		Version: b.flushedVersion,
+		Digest:  b.flushedDigest,
+		Time:    time.Now(),
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,130 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestCuratorLifecycle(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	var mutex sync.Mutex
+	var attempts int
+	posted := make(chan LifecycleEvent, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		first := attempts == 1
+		mutex.Unlock()
+		if first {
+			http.Error(w, "Not yet", http.StatusServiceUnavailable)
+			return
+		}
+		var event LifecycleEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		posted <- event
+	}))
+	defer server.Close()
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.FlushPeriod = 10
+	config.LifecycleWebhook.URL = server.URL
+	config.LifecycleWebhook.RetryBackoff = 1
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	events := make(chan LifecycleEvent, 10)
+	curator.AddLifecycleHook(func(event LifecycleEvent) {
+		events <- event
+	})
+
+	expect := func(event, content string, version int) string {
+		select {
+		case e := <-events:
+			if e.Event != event || e.Version != version || e.Digest != contentDigest(content) {
+				t.Errorf("Wrong lifecycle event: %+v != %v %v %q", e, event, version, content)
+			}
+			return e.ID
+		case <-time.After(time.Second):
+			t.Fatalf("Lifecycle event %v was not emitted", event)
+		}
+		return ""
+	}
+
+	portal, err := curator.CreateDocument("test", "", store.Document{Content: "hello world"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := expect(LifecycleCreated, "hello world", 1); id != portal.Document.ID {
+		t.Errorf("Wrong document ID: %v != %v", id, portal.Document.ID)
+	}
+	expect(LifecycleFirstJoin, "hello world", 1)
+
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "oh ", Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expect(LifecycleFlushed, "oh hello world", 2)
+
+	portal.Exit(time.Second)
+	expect(LifecycleLastLeave, "oh hello world", 2)
+
+	// Documents bound from the store report the digest of their stored content.
+	stored, _ := store.NewDocument("stored content")
+	if err = storage.Create(*stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = curator.EditDocument("test", stored.ID); err != nil {
+		t.Fatal(err)
+	}
+	expect(LifecycleFirstJoin, "stored content", 1)
+
+	// The first post of the webhook fails and is retried, after which every event arrives in order.
+	order := []string{
+		LifecycleCreated, LifecycleFirstJoin, LifecycleFlushed, LifecycleLastLeave, LifecycleFirstJoin,
+	}
+	for _, event := range order {
+		select {
+		case e := <-posted:
+			if e.Event != event {
+				t.Errorf("Wrong webhook event order: %v != %v", e.Event, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Webhook did not receive event: %v", event)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RateLimitConfig - Limits on the transforms submitted by each portal of a binder, a zero rate
+disables that limit. Bursts of up to one second worth of either limit are allowed. Clients that
+exceed a limit are either kicked from the binder, or, when Action is 'throttle', have their
+submissions delayed until they are back within it.
+*/
+type RateLimitConfig struct {
+	TransformsPerSecond float64 `json:"transforms_per_second" yaml:"transforms_per_second"`
+	BytesPerSecond      float64 `json:"bytes_per_second" yaml:"bytes_per_second"`
+	Action              string  `json:"action" yaml:"action"`
+}
+
+/*
+NewRateLimitConfig - Returns a default RateLimitConfig, where rate limiting is disabled.
+*/
+func NewRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		TransformsPerSecond: 0,
+		BytesPerSecond:      0,
+		Action:              "kick",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RateLimitedError - The error of a transform refused for exceeding the rate limit of its portal,
+which wraps ErrRateLimited. RetryAfter is how long until the transform would have been allowed.
+*/
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+/*
+Error - Returns the message of ErrRateLimited along with how long to wait.
+*/
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrRateLimited, e.RetryAfter)
+}
+
+/*
+Unwrap - Returns ErrRateLimited.
+*/
+func (e *RateLimitedError) Unwrap() error {
+	return ErrRateLimited
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+tokenBucket - A simple token bucket rate limiter. Tokens are replenished at a fixed rate per second
+up to a maximum burst size.
+*/
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+/*
+newTokenBucket - Create a full token bucket.
+*/
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+/*
+refill - Replenish tokens since the last call, must be called whilst locked.
+*/
+func (t *tokenBucket) refill() {
+	now := time.Now()
+	t.tokens += now.Sub(t.last).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.last = now
+}
+
+/*
+take - Takes n tokens if they are available, returns whether they were along with the state of the
+bucket afterwards.
+*/
+func (t *tokenBucket) take(n float64) (RateLimitState, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.refill()
+	allowed := t.tokens >= n
+	if allowed {
+		t.tokens -= n
+	}
+	state := RateLimitState{
+		Limit:     int(t.burst),
+		Remaining: int(math.Max(math.Floor(t.tokens), 0)),
+	}
+	if t.rate > 0 {
+		state.Reset = time.Duration((t.burst - t.tokens) / t.rate * float64(time.Second))
+		if !allowed {
+			state.RetryAfter = time.Duration((n - t.tokens) / t.rate * float64(time.Second))
+		}
+	}
+	return state, allowed
+}
+
+/*
+reserve - Takes n tokens regardless of availability, returns how long the caller must wait until
+the bucket is back in credit. Reserving nothing never adds tokens.
+*/
+func (t *tokenBucket) reserve(n float64) time.Duration {
+	if n <= 0 {
+		return 0
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.refill()
+	t.tokens -= n
+	if t.tokens >= 0 || t.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+portalLimiter - The rate limits of a single portal, a nil bucket means that limit is disabled.
+*/
+type portalLimiter struct {
+	transforms *tokenBucket
+	bytes      *tokenBucket
+}
+
+/*
+newPortalLimiter - Create a limiter from the config, returns nil if rate limiting is disabled. The
+byte burst is never smaller than the largest transform allowed by the model, otherwise such a
+transform could never be accepted.
+*/
+func newPortalLimiter(config RateLimitConfig, maxTransformLength uint64) *portalLimiter {
+	if config.TransformsPerSecond <= 0 && config.BytesPerSecond <= 0 {
+		return nil
+	}
+	limiter := &portalLimiter{}
+	if config.TransformsPerSecond > 0 {
+		limiter.transforms = newTokenBucket(config.TransformsPerSecond, math.Max(config.TransformsPerSecond, 1))
+	}
+	if config.BytesPerSecond > 0 {
+		limiter.bytes = newTokenBucket(config.BytesPerSecond, math.Max(config.BytesPerSecond, float64(maxTransformLength)))
+	}
+	return limiter
+}
+
+/*
+allow - Returns true if the transform is within the limits, and takes its tokens. Otherwise returns
+false along with how long until the transform would be within them.
+*/
+func (p *portalLimiter) allow(ot OTransform) (time.Duration, bool) {
+	if p == nil {
+		return 0, true
+	}
+	if p.transforms != nil {
+		if state, allowed := p.transforms.take(1); !allowed {
+			return state.RetryAfter, false
+		}
+	}
+	if p.bytes != nil {
+		if state, allowed := p.bytes.take(float64(len(ot.Insert))); !allowed {
+			return state.RetryAfter, false
+		}
+	}
+	return 0, true
+}
+
+/*
+reserve - Takes the tokens of a transform, returns how long to wait before submitting it.
+*/
+func (p *portalLimiter) reserve(ot OTransform) time.Duration {
+	var delay time.Duration
+	if p == nil {
+		return delay
+	}
+	if p.transforms != nil {
+		delay = p.transforms.reserve(1)
+	}
+	if p.bytes != nil {
+		if bytesDelay := p.bytes.reserve(float64(len(ot.Insert))); bytesDelay > delay {
+			delay = bytesDelay
+		}
+	}
+	return delay
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RateLimitState - The state of a rate limiter following a request. Limit is the burst size, Remaining
+is the number of further requests allowed right away and Reset is how long until the limiter has
+fully recovered. RetryAfter is how long a rejected request must wait before trying again.
+*/
+type RateLimitState struct {
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+/*
+KeyedRateLimiter - Rate limits requests separately for each key, such as the address of a client,
+with a token bucket per key. Buckets that have fully recovered are pruned periodically.
+*/
+type KeyedRateLimiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+	mutex     sync.Mutex
+}
+
+/*
+NewKeyedRateLimiter - Create a limiter allowing rate requests per second for each key, with bursts
+of up to burst requests, which is never less than one.
+*/
+func NewKeyedRateLimiter(rate, burst float64) *KeyedRateLimiter {
+	return &KeyedRateLimiter{
+		rate:      rate,
+		burst:     math.Max(burst, 1),
+		buckets:   map[string]*tokenBucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+/*
+Allow - Take a request for a key, returns whether it is allowed along with the state of the limiter
+of the key.
+*/
+func (k *KeyedRateLimiter) Allow(key string) (RateLimitState, bool) {
+	k.mutex.Lock()
+	k.prune()
+	bucket, exists := k.buckets[key]
+	if !exists {
+		bucket = newTokenBucket(k.rate, k.burst)
+		k.buckets[key] = bucket
+	}
+	k.mutex.Unlock()
+
+	return bucket.take(1)
+}
+
+/*
+prune - Remove the buckets that have fully recovered since they were last used, which are identical
+to fresh buckets. Runs at most once per recovery period, and must be called whilst locked.
+*/
+func (k *KeyedRateLimiter) prune() {
+	if k.rate <= 0 {
+		return
+	}
+	recovery := time.Duration(k.burst / k.rate * float64(time.Second))
+	if time.Since(k.lastPrune) < recovery {
+		return
+	}
+	k.lastPrune = time.Now()
+	for key, bucket := range k.buckets {
+		bucket.mutex.Lock()
+		idle := time.Since(bucket.last) >= recovery
+		bucket.mutex.Unlock()
+		if idle {
+			delete(k.buckets, key)
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
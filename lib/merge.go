@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// The largest number of line pairs compared when diffing, beyond which the changed span of a
+// document is replaced as a whole.
+const mergeDiffLimit = 1 << 22
+
+/*
+mergeHunk - A span of lines [aStart, aEnd) of the original content replaced by the lines
+[bStart, bEnd) of the changed content.
+*/
+type mergeHunk struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+/*
+diffLines - Returns the hunks that turn lines a into lines b, in order of their position, found
+from the longest common subsequence of the two.
+*/
+func diffLines(a, b []string) []mergeHunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	am, bm := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(am) == 0 && len(bm) == 0 {
+		return nil
+	}
+	if len(am) == 0 || len(bm) == 0 || len(am)*len(bm) > mergeDiffLimit {
+		return []mergeHunk{{prefix, prefix + len(am), prefix, prefix + len(bm)}}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of am[i:] and bm[j:]
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	hunks := []mergeHunk{}
+	open := false
+	var current mergeHunk
+	i, j := 0, 0
+	for i < len(am) || j < len(bm) {
+		if i < len(am) && j < len(bm) && am[i] == bm[j] {
+			if open {
+				current.aEnd, current.bEnd = prefix+i, prefix+j
+				hunks = append(hunks, current)
+				open = false
+			}
+			i++
+			j++
+			continue
+		}
+		if !open {
+			current = mergeHunk{aStart: prefix + i, bStart: prefix + j}
+			open = true
+		}
+		if j >= len(bm) || (i < len(am) && lcs[i+1][j] >= lcs[i][j+1]) {
+			i++
+		} else {
+			j++
+		}
+	}
+	if open {
+		current.aEnd, current.bEnd = prefix+i, prefix+j
+		hunks = append(hunks, current)
+	}
+	return hunks
+}
+
+/*
+mergeTransforms - Returns the transforms that turn content into its changed form, one for each
+changed span of lines and narrowed down to the characters that changed within it. The transforms
+are ordered from the end of the content to the start, so that each applies at its original
+position regardless of the others.
+*/
+func mergeTransforms(content, changed string) []OTransform {
+	a, b := strings.SplitAfter(content, "\n"), strings.SplitAfter(changed, "\n")
+
+	// The rune offset of each line of the original content
+	offsets := make([]int, len(a)+1)
+	for i, line := range a {
+		offsets[i+1] = offsets[i] + utf8.RuneCountInString(line)
+	}
+
+	hunks := diffLines(a, b)
+	transforms := make([]OTransform, 0, len(hunks))
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		ot := diffTextTransform(strings.Join(a[h.aStart:h.aEnd], ""), strings.Join(b[h.bStart:h.bEnd], ""))
+		ot.Position += offsets[h.aStart]
+		transforms = append(transforms, ot)
+	}
+	return transforms
+}
+
+/*
+invertMergeTransforms - Returns the transforms that undo those of mergeTransforms that were applied
+to content, in order, ordered such that each again applies at its original position.
+*/
+func invertMergeTransforms(content string, applied []OTransform) []OTransform {
+	runes := []rune(content)
+	inverse := make([]OTransform, 0, len(applied))
+	for i := len(applied) - 1; i >= 0; i-- {
+		ot := applied[i]
+		inverse = append(inverse, OTransform{
+			Position: ot.Position,
+			Delete:   utf8.RuneCountInString(ot.Insert),
+			Insert:   string(runes[ot.Position : ot.Position+ot.Delete]),
+		})
+	}
+	return inverse
+}
+
+/*
+checkMergeSize - Returns an error if a transform of a merge exceeds the transform size limit of
+binders created with a config, or if the merged content exceeds their document size limit, so that
+a merge the binder would refuse part way through is refused before any of it is applied.
+*/
+func checkMergeSize(config BinderConfig, changed string, transforms []OTransform) error {
+	maxTransform := lowestLimit(config.MaxTransformSize, config.ModelConfig.MaxTransformLength)
+	for _, ot := range transforms {
+		if maxTransform > 0 && uint64(len(ot.Insert)) > maxTransform {
+			return ErrTransformTooLarge
+		}
+	}
+	maxDocument := lowestLimit(config.MaxDocumentSize, config.ModelConfig.MaxDocumentSize)
+	if maxDocument > 0 && uint64(len(changed)) > maxDocument {
+		return ErrDocumentTooLarge
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
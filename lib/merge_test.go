@@ -0,0 +1,185 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+func TestMergeTransforms(t *testing.T) {
+	type mergeTest struct {
+		before, after string
+		transforms    int
+	}
+
+	tests := []mergeTest{
+		{"hello world", "hello world", 0},
+		{"", "hello world", 1},
+		{"hello world", "", 1},
+		{"hello world", "hello there world", 1},
+		{"one\ntwo\nthree\n", "one\n2\nthree\nfour\n", 2},
+		{"a\nb\nc\nd\ne\n", "a\nc\nd\nE\ne\nf\n", 3},
+		{"héllo\nwörld\n", "héllo\nwörld!\n", 1},
+		{"first\nsecond\n", "zeroth\nfirst\nsecond\nthird", 2},
+	}
+
+	for _, test := range tests {
+		transforms := mergeTransforms(test.before, test.after)
+		if exp, act := test.transforms, len(transforms); exp != act {
+			t.Errorf("Wrong count of transforms for %q -> %q: %v != %v", test.before, test.after, exp, act)
+		}
+		result, err := replayTransforms("text", test.before, transforms)
+		if err != nil {
+			t.Errorf("Failed to apply transforms: %v", err)
+			continue
+		}
+		if result != test.after {
+			t.Errorf("Wrong merge result: %q != %q", test.after, result)
+		}
+
+		// Any prefix of the transforms is undone by its inverse
+		for i := 0; i <= len(transforms); i++ {
+			partial, err := replayTransforms("text", test.before, transforms[:i])
+			if err != nil {
+				t.Fatal(err)
+			}
+			undone, err := replayTransforms("text", partial, invertMergeTransforms(test.before, transforms[:i]))
+			if err != nil {
+				t.Errorf("Failed to apply inverse transforms: %v", err)
+			} else if undone != test.before {
+				t.Errorf("Wrong undone result after %v transforms: %q != %q", i, test.before, undone)
+			}
+		}
+	}
+}
+
+type unreadableStore struct {
+	store.Store
+}
+
+func (u unreadableStore) Read(id string) (store.Document, error) {
+	return store.Document{}, errors.New("store unavailable")
+}
+
+func TestCuratorMergeDocumentFailures(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	if err := storage.Create(store.Document{ID: "limited", Content: "one\ntwo\n"}); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.MaxDocumentSize = 16
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	// Merges over the size limit are refused before any part is applied
+	if _, err = curator.MergeDocument(store.Document{ID: "limited", Content: "zero\none\ntwo\nthree\n"}, time.Second); err != ErrDocumentTooLarge {
+		t.Errorf("Wrong error for oversized merge: %v", err)
+	}
+	if doc, err := curator.GetLiveDocument("limited", time.Second); err != nil || doc.Content != "one\ntwo\n" {
+		t.Errorf("Refused merge was applied: %q, %v", doc.Content, err)
+	}
+
+	// Failing to read the store is not mistaken for the document being missing
+	broken, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, unreadableStore{storage})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer broken.Close()
+
+	if _, err = broken.MergeDocument(store.Document{ID: "limited", Content: "other"}, time.Second); err == nil {
+		t.Error("Expected merge to fail when the store cannot be read")
+	}
+	if doc, _ := storage.Read("limited"); doc.Content != "one\ntwo\n" {
+		t.Errorf("Unreadable document was overwritten: %q", doc.Content)
+	}
+}
+
+func TestCuratorMergeDocument(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	curator, err := NewCurator(DefaultCuratorConfig(), log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	// Merging into a document that does not exist creates it
+	doc, err := curator.MergeDocument(store.Document{ID: "merged", Content: "one\ntwo\n"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "one\ntwo\n", doc.Content; exp != act {
+		t.Errorf("Wrong created content: %q != %q", exp, act)
+	}
+
+	portal, err := curator.EditDocument("", "merged")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Connected clients receive the merge as transforms
+	resultChan := make(chan string, 1)
+	go func() {
+		content := portal.Document.Content
+		for i := 0; i < 2; i++ {
+			select {
+			case ot := <-portal.TransformRcvChan:
+				content, _ = replayTransforms("text", content, []OTransform{ot})
+			case <-time.After(time.Second):
+			}
+		}
+		resultChan <- content
+	}()
+
+	doc, err = curator.MergeDocument(store.Document{ID: "merged", Content: "zero\none\n2\n"}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "zero\none\n2\n", doc.Content; exp != act {
+		t.Errorf("Wrong merged content: %q != %q", exp, act)
+	}
+	if stored, _ := storage.Read("merged"); stored.Content != doc.Content {
+		t.Errorf("Merged content was not flushed: %q", stored.Content)
+	}
+
+	if content := <-resultChan; content != doc.Content {
+		t.Errorf("Client content diverged: %q != %q", doc.Content, content)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,370 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// The upper bounds in seconds of the flush duration histogram buckets.
+var flushBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// The number of seconds over which the transform rate is averaged.
+const metricsRateWindow = 10
+
+/*
+rateBucket - The number of transforms applied within a particular second.
+*/
+type rateBucket struct {
+	second int64
+	count  uint64
+}
+
+/*
+Metrics - Operational metrics of the binders of a curator, written in the Prometheus text exposition
+format and optionally emitted to statsd as they happen. Shared by all binders of a curator, and safe
+to use from any goroutine. A nil metrics ignores everything.
+*/
+type Metrics struct {
+	mutex sync.Mutex
+
+	// Receives counters and timings as they happen, may be nil
+	statsd *Statsd
+
+	// The number of subscribed clients of each open binder
+	subscribers map[*Binder]int
+
+	// The open binders currently degraded by a slow store
+	degraded map[*Binder]struct{}
+
+	transforms uint64
+	rate       [metricsRateWindow]rateBucket
+
+	flushCounts []uint64
+	flushCount  uint64
+	flushSum    float64
+
+	kicked       map[string]uint64
+	authFailures map[string]uint64
+
+	// Messages ignored from nodes of an incompatible cluster protocol, by their protocol
+	relayIncompatibles map[string]uint64
+}
+
+/*
+NewMetrics - Create an empty set of metrics.
+*/
+func NewMetrics() *Metrics {
+	return &Metrics{
+		subscribers:        map[*Binder]int{},
+		degraded:           map[*Binder]struct{}{},
+		flushCounts:        make([]uint64, len(flushBuckets)),
+		kicked:             map[string]uint64{},
+		authFailures:       map[string]uint64{},
+		relayIncompatibles: map[string]uint64{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+binderOpened - Start tracking an open binder.
+*/
+func (m *Metrics) binderOpened(b *Binder) {
+	m.setSubscribers(b, 0)
+}
+
+/*
+EmitTo - Emit counters and timings to a statsd server as they happen, in addition to collecting
+them. Must be called before the metrics are used.
+*/
+func (m *Metrics) EmitTo(statsd *Statsd) {
+	m.statsd = statsd
+}
+
+/*
+binderClosed - Stop tracking a binder, called once its loop has ended.
+*/
+func (m *Metrics) binderClosed(b *Binder) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	remaining := m.subscribers[b]
+	delete(m.subscribers, b)
+	delete(m.degraded, b)
+	m.mutex.Unlock()
+
+	if remaining > 0 {
+		m.statsd.Count("clients.left", int64(remaining))
+	}
+}
+
+/*
+setSubscribers - Set the current number of subscribed clients of a binder, the change since the last
+call is emitted as clients joining or leaving.
+*/
+func (m *Metrics) setSubscribers(b *Binder, subscribers int) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	change := subscribers - m.subscribers[b]
+	m.subscribers[b] = subscribers
+	m.mutex.Unlock()
+
+	if change > 0 {
+		m.statsd.Count("clients.joined", int64(change))
+	} else if change < 0 {
+		m.statsd.Count("clients.left", int64(-change))
+	}
+}
+
+/*
+setDegraded - Set whether an open binder is currently degraded.
+*/
+func (m *Metrics) setDegraded(b *Binder, degraded bool) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	if degraded {
+		m.degraded[b] = struct{}{}
+	} else {
+		delete(m.degraded, b)
+	}
+	m.mutex.Unlock()
+}
+
+/*
+transformApplied - Count a transform that was successfully applied by a binder.
+*/
+func (m *Metrics) transformApplied() {
+	if m == nil {
+		return
+	}
+	second := time.Now().Unix()
+
+	m.statsd.Count("transforms.applied", 1)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.transforms++
+	bucket := &m.rate[second%metricsRateWindow]
+	if bucket.second != second {
+		bucket.second, bucket.count = second, 0
+	}
+	bucket.count++
+}
+
+/*
+transformProcessed - Record the latency of a transform from its submission until it was applied.
+*/
+func (m *Metrics) transformProcessed(latency time.Duration) {
+	if m == nil {
+		return
+	}
+	m.statsd.Timing("transforms.latency", latency)
+}
+
+/*
+flushed - Record the duration of a flush that wrote changes to the store.
+*/
+func (m *Metrics) flushed(duration time.Duration) {
+	if m == nil {
+		return
+	}
+	seconds := duration.Seconds()
+	m.statsd.Timing("flush.duration", duration)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.flushCount++
+	m.flushSum += seconds
+	for i, bound := range flushBuckets {
+		if seconds <= bound {
+			m.flushCounts[i]++
+		}
+	}
+}
+
+/*
+clientKicked - Count a client that was kicked from a binder, reason is one of "blocked",
+"rate_limit", "admin" or "banned".
+*/
+func (m *Metrics) clientKicked(reason string) {
+	if m == nil {
+		return
+	}
+	m.statsd.Count("clients.kicked", 1, "reason:"+reason)
+
+	m.mutex.Lock()
+	m.kicked[reason]++
+	m.mutex.Unlock()
+}
+
+/*
+authFailed - Count a client that failed to authorise an action, which is one of "create", "edit",
+"read", "read_recording" or "preference".
+*/
+func (m *Metrics) authFailed(action string) {
+	if m == nil {
+		return
+	}
+	m.statsd.Count("auth.failures", 1, "action:"+action)
+
+	m.mutex.Lock()
+	m.authFailures[action]++
+	m.mutex.Unlock()
+}
+
+/*
+relayIncompatible - Count a message ignored from a node that speaks an incompatible cluster protocol.
+*/
+func (m *Metrics) relayIncompatible(protocol int) {
+	if m == nil {
+		return
+	}
+	m.statsd.Count("relay.incompatible", 1, fmt.Sprintf("protocol:%v", protocol))
+
+	m.mutex.Lock()
+	m.relayIncompatibles[fmt.Sprintf("%v", protocol)]++
+	m.mutex.Unlock()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/*
+writeCounts - Write a metric family of labelled values in a stable order.
+*/
+func writeCounts(w io.Writer, name, help, kind, label string, values map[string]uint64) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if _, err := fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(
+			w, "%v{%v=\"%v\"} %v\n", name, label, labelEscaper.Replace(key), values[key],
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+WritePrometheus - Write the current metrics in the Prometheus text exposition format.
+*/
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Binders of the same document may briefly overlap while one is closing.
+	subscribers := map[string]uint64{}
+	for b, count := range m.subscribers {
+		subscribers[b.ID] += uint64(count)
+	}
+
+	now := time.Now().Unix()
+	var recent uint64
+	for _, bucket := range m.rate {
+		if bucket.second < now && bucket.second >= now-metricsRateWindow {
+			recent += bucket.count
+		}
+	}
+
+	var cumulative []string
+	for i, bound := range flushBuckets {
+		cumulative = append(cumulative, fmt.Sprintf(
+			"leaps_flush_duration_seconds_bucket{le=\"%v\"} %v\n", bound, m.flushCounts[i],
+		))
+	}
+
+	if _, err := fmt.Fprintf(w, "# HELP leaps_active_binders Number of open binders.\n"+
+		"# TYPE leaps_active_binders gauge\nleaps_active_binders %v\n"+
+		"# HELP leaps_degraded_binders Number of open binders degraded by slow flushes.\n"+
+		"# TYPE leaps_degraded_binders gauge\nleaps_degraded_binders %v\n", len(m.subscribers), len(m.degraded),
+	); err != nil {
+		return err
+	}
+	if err := writeCounts(w, "leaps_binder_subscribers",
+		"Number of clients subscribed to each open binder.", "gauge", "document", subscribers,
+	); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "# HELP leaps_transforms_total Number of transforms applied.\n"+
+		"# TYPE leaps_transforms_total counter\nleaps_transforms_total %v\n"+
+		"# HELP leaps_transforms_per_second Transforms applied per second over the last %v seconds.\n"+
+		"# TYPE leaps_transforms_per_second gauge\nleaps_transforms_per_second %v\n",
+		m.transforms, metricsRateWindow, float64(recent)/metricsRateWindow,
+	); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "# HELP leaps_flush_duration_seconds Duration of flushes that wrote "+
+		"changes to the store.\n# TYPE leaps_flush_duration_seconds histogram\n%v"+
+		"leaps_flush_duration_seconds_bucket{le=\"+Inf\"} %v\n"+
+		"leaps_flush_duration_seconds_sum %v\nleaps_flush_duration_seconds_count %v\n",
+		strings.Join(cumulative, ""), m.flushCount, m.flushSum, m.flushCount,
+	); err != nil {
+		return err
+	}
+	if err := writeCounts(w, "leaps_kicked_clients_total",
+		"Number of clients kicked from binders.", "counter", "reason", m.kicked,
+	); err != nil {
+		return err
+	}
+	if err := writeCounts(w, "leaps_auth_failures_total",
+		"Number of clients that failed to authorise.", "counter", "action", m.authFailures,
+	); err != nil {
+		return err
+	}
+	return writeCounts(w, "leaps_relay_incompatible_messages_total",
+		"Number of relayed messages ignored from nodes of an incompatible cluster protocol.", "counter",
+		"protocol", m.relayIncompatibles,
+	)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,132 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics()
+
+	binder := &Binder{ID: `say "hi"`}
+	metrics.binderOpened(binder)
+	metrics.setSubscribers(binder, 3)
+	metrics.transformApplied()
+	metrics.transformApplied()
+	metrics.flushed(20 * time.Millisecond)
+	metrics.clientKicked("blocked")
+	metrics.authFailed("edit")
+	metrics.authFailed("edit")
+	metrics.setDegraded(binder, true)
+	metrics.relayIncompatible(4)
+
+	var buf bytes.Buffer
+	if err := metrics.WritePrometheus(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{
+		"leaps_active_binders 1\n",
+		"leaps_degraded_binders 1\n",
+		`leaps_binder_subscribers{document="say \"hi\""} 3` + "\n",
+		"leaps_transforms_total 2\n",
+		"# TYPE leaps_flush_duration_seconds histogram\n",
+		`leaps_flush_duration_seconds_bucket{le="0.01"} 0` + "\n",
+		`leaps_flush_duration_seconds_bucket{le="0.05"} 1` + "\n",
+		`leaps_flush_duration_seconds_bucket{le="+Inf"} 1` + "\n",
+		"leaps_flush_duration_seconds_count 1\n",
+		`leaps_kicked_clients_total{reason="blocked"} 1` + "\n",
+		`leaps_auth_failures_total{action="edit"} 2` + "\n",
+		`leaps_relay_incompatible_messages_total{protocol="4"} 1` + "\n",
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("Missing metric %q in:\n%v", exp, buf.String())
+		}
+	}
+
+	metrics.binderClosed(binder)
+	buf.Reset()
+	metrics.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), "leaps_active_binders 0\n") ||
+		!strings.Contains(buf.String(), "leaps_degraded_binders 0\n") {
+		t.Errorf("Binder was not closed:\n%v", buf.String())
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.transformApplied()
+	if err := nilMetrics.WritePrometheus(&buf); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCuratorMetrics(t *testing.T) {
+	log, stats := loggerAndStats()
+	auth, storage := authAndStore(log, stats)
+
+	config := DefaultCuratorConfig()
+	config.BinderConfig.FlushPeriod = 10
+
+	curator, err := NewCurator(config, log, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	doc, err := store.NewDocument("hello world")
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal, err := curator.CreateDocument("", "", *doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = portal.SendTransform(OTransform{Version: 2, Insert: "a"}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err = curator.KickUser(portal.Document.ID, portal.Token, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	<-time.After(50 * time.Millisecond)
+
+	var buf bytes.Buffer
+	if err = curator.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, exp := range []string{
+		"leaps_active_binders 1\n",
+		`leaps_binder_subscribers{document="` + portal.Document.ID + `"} 0` + "\n",
+		"leaps_transforms_total 1\n",
+		"leaps_flush_duration_seconds_count 1\n",
+		`leaps_kicked_clients_total{reason="admin"} 1` + "\n",
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("Missing metric %q in:\n%v", exp, buf.String())
+		}
+	}
+}
@@ -22,9 +22,19 @@ THE SOFTWARE.
 
 package lib
 
+import (
+	"errors"
+	"fmt"
+)
+
 /*--------------------------------------------------------------------------------------------------
  */
 
+// Errors for the Model types.
+var (
+	ErrInvalidModelType = errors.New("invalid document type")
+)
+
 /*
 ModelConfig - Holds configuration options for a transform model.
 */
@@ -43,6 +53,43 @@ func DefaultModelConfig() ModelConfig {
 	}
 }
 
+/*
+CreateModel - Returns a fresh transform model for a document type, which is either text (the default
+for an empty type), json or rich.
+*/
+func CreateModel(docType string, config ModelConfig) (Model, error) {
+	switch docType {
+	case "", "text":
+		return CreateTextModel(config), nil
+	case "json":
+		return CreateJSONModel(config), nil
+	case "rich":
+		return CreateRichTextModel(config), nil
+	}
+	return nil, fmt.Errorf("%v: %v", ErrInvalidModelType, docType)
+}
+
+/*
+isTextType - Whether a document type is modelled as plain text.
+*/
+func isTextType(docType string) bool {
+	return docType == "" || docType == "text"
+}
+
+/*
+replayTransforms - Apply a sequence of already corrected transforms to the content of a document of
+a particular type.
+*/
+func replayTransforms(docType, content string, transforms []OTransform) (string, error) {
+	switch docType {
+	case "json":
+		return replayJSONTransforms(content, transforms)
+	case "rich":
+		return replayRichTransforms(content, transforms)
+	}
+	return replayTextTransforms(content, transforms)
+}
+
 /*
 Model - an interface that represents an internal operation transform model of a particular type.
 Initially text is the only supported transform model, however, the plan will eventually be to have
@@ -63,6 +110,16 @@ type Model interface {
 	/* GetVersion - returns the current version of the document.
 	 */
 	GetVersion() int
+
+	/* GetUnapplied - returns the transforms pushed since the last flush, in order, which allows
+	 * the current content to be read without flushing.
+	 */
+	GetUnapplied() []OTransform
+
+	/* DropTransform - remove the transform most recently pushed, which must not yet have been
+	 * flushed, returning the document to the version before it. Returns false if there is none.
+	 */
+	DropTransform() bool
 }
 
 /*--------------------------------------------------------------------------------------------------
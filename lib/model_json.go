@@ -0,0 +1,551 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the JSON Operational Transform model.
+var (
+	ErrTransformInvalidOp    = errors.New("transform operation is not supported by the document type")
+	ErrTransformInvalidPath  = errors.New("transform path does not exist in the document")
+	ErrTransformInvalidValue = errors.New("transform value is not valid JSON")
+)
+
+/*
+JSONModel - A transform model for documents holding a JSON tree. Each transform carries an Op that
+is applied at a Path of object keys and array indexes:
+
+- set: Replace the value at Path with Value, or add it when Path names a missing object key
+- insert: Insert Value into an array, the last element of Path being the index to insert at
+- move: Move the array element at Path to the index To of the same array
+- delete: Remove the array element at Path
+- unset: Remove the object key at Path
+- text: Edit the string at Path with Position, Delete and Insert as in a text document
+
+Transforms are corrected against concurrent transforms they were unaware of by shifting their array
+indexes, turning them into a noop when a concurrent set replaced or a concurrent delete or unset
+removed the value they target, and by the text model rules for concurrent edits of the same string.
+*/
+type JSONModel struct {
+	config    ModelConfig
+	Version   int
+	Applied   []OTransform
+	Unapplied []OTransform
+}
+
+/*
+CreateJSONModel - Returns a fresh JSON transform model, with the version set to 1.
+*/
+func CreateJSONModel(config ModelConfig) Model {
+	return &JSONModel{
+		config:    config,
+		Version:   1,
+		Applied:   []OTransform{},
+		Unapplied: []OTransform{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+PushTransform - Validate a transform and correct it against those it was unaware of, then push it
+onto the unapplied stack and increment the version number of the document.
+*/
+func (m *JSONModel) PushTransform(ot OTransform) (OTransform, int, error) {
+	switch ot.Op {
+	case "set", "text", "noop":
+	case "insert", "move", "delete", "unset":
+		if len(ot.Path) == 0 {
+			return OTransform{}, 0, ErrTransformInvalidPath
+		}
+	default:
+		return OTransform{}, 0, ErrTransformInvalidOp
+	}
+	if ot.Delete < 0 {
+		return OTransform{}, 0, ErrTransformNegDelete
+	}
+	if uint64(len(ot.Insert)+len(ot.Value)) > m.config.MaxTransformLength {
+		return OTransform{}, 0, ErrTransformTooLong
+	}
+	if (ot.Op == "set" || ot.Op == "insert") && !json.Valid(ot.Value) {
+		return OTransform{}, 0, ErrTransformInvalidValue
+	}
+
+	lenApplied, lenUnapplied := len(m.Applied), len(m.Unapplied)
+
+	diff := (m.Version + 1) - ot.Version
+
+	if diff > lenApplied+lenUnapplied {
+		return OTransform{}, 0, ErrTransformTooOld
+	}
+	if diff < 0 {
+		return OTransform{}, 0, fmt.Errorf(
+			"transform version %v greater than expected doc version (%v), offender: %v",
+			ot.Version, (m.Version + 1), ot)
+	}
+
+	for j := lenApplied - (diff - lenUnapplied); j < lenApplied; j++ {
+		updateJSONTransform(&ot, &m.Applied[j])
+		diff--
+	}
+	for j := lenUnapplied - diff; j < lenUnapplied; j++ {
+		updateJSONTransform(&ot, &m.Unapplied[j])
+	}
+
+	m.Version++
+
+	ot.Version = m.Version
+	ot.TReceived = time.Now().Unix()
+
+	m.Unapplied = append(m.Unapplied, ot)
+
+	return ot, m.Version, nil
+}
+
+/*
+GetVersion - returns the current version of the document.
+*/
+func (m *JSONModel) GetVersion() int {
+	return m.Version
+}
+
+/*
+GetUnapplied - returns a copy of the transforms pushed since the last flush.
+*/
+func (m *JSONModel) GetUnapplied() []OTransform {
+	return append([]OTransform{}, m.Unapplied...)
+}
+
+/*
+DropTransform - removes the most recently pushed transform whilst it remains unapplied.
+*/
+func (m *JSONModel) DropTransform() bool {
+	if len(m.Unapplied) == 0 {
+		return false
+	}
+	m.Unapplied = m.Unapplied[:len(m.Unapplied)-1]
+	m.Version--
+	return true
+}
+
+/*
+FlushTransforms - apply all unapplied transforms to the JSON content and append them to the applied
+stack, then remove old entries from the applied stack. The content is written back as compact JSON,
+with object keys sorted. Returns a bool indicating whether any changes were applied.
+*/
+func (m *JSONModel) FlushTransforms(content *string, secondsRetention int64) (bool, error) {
+	transforms := m.Unapplied[:]
+	m.Unapplied = []OTransform{}
+
+	root, err := parseJSONContent(*content)
+
+	var i, j int
+	for i = 0; err == nil && i < len(transforms); i++ {
+		root, err = applyJSONTransform(root, &transforms[i])
+	}
+
+	if err == nil && i > 0 {
+		var result []byte
+		if result, err = json.Marshal(root); err == nil {
+			if uint64(len(result)) > m.config.MaxDocumentSize {
+				return true, ErrTransformTooLong
+			}
+			*content = string(result)
+		}
+	}
+
+	upto := time.Now().Unix() - secondsRetention
+	for j = 0; j < len(m.Applied); j++ {
+		if m.Applied[j].TReceived > upto {
+			break
+		}
+	}
+
+	applied := m.Applied[j:]
+	m.Applied = make([]OTransform, len(transforms)+len(applied))
+
+	copy(m.Applied[:], applied)
+	copy(m.Applied[len(applied):], transforms)
+
+	return i > 0, err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+parseJSONContent - Parse the content of a JSON document, numbers are kept in their original form.
+Empty content is treated as null.
+*/
+func parseJSONContent(content string) (interface{}, error) {
+	if len(content) == 0 {
+		return nil, nil
+	}
+	var root interface{}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(content)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+/*
+replayJSONTransforms - Apply a sequence of already corrected JSON transforms to some content.
+*/
+func replayJSONTransforms(content string, transforms []OTransform) (string, error) {
+	root, err := parseJSONContent(content)
+	if err != nil {
+		return "", err
+	}
+	for i := range transforms {
+		if root, err = applyJSONTransform(root, &transforms[i]); err != nil {
+			return "", err
+		}
+	}
+	result, err := json.Marshal(root)
+	return string(result), err
+}
+
+/*
+jsonIndex - Parse a path element as an index of an array of a particular length. Inserts may use the
+length itself as an index.
+*/
+func jsonIndex(key string, length int, inclusive bool) (int, error) {
+	i, err := strconv.Atoi(key)
+	if err != nil || i < 0 || i > length || (i == length && !inclusive) {
+		return 0, ErrTransformInvalidPath
+	}
+	return i, nil
+}
+
+/*
+jsonChild - Returns the value found under a key of an object or array.
+*/
+func jsonChild(node interface{}, key string) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if child, ok := n[key]; ok {
+			return child, nil
+		}
+	case []interface{}:
+		i, err := jsonIndex(key, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		return n[i], nil
+	}
+	return nil, ErrTransformInvalidPath
+}
+
+/*
+jsonSetChild - Sets the value under a key of an object or array, objects gain missing keys.
+*/
+func jsonSetChild(node interface{}, key string, value interface{}) (interface{}, error) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		n[key] = value
+		return n, nil
+	case []interface{}:
+		i, err := jsonIndex(key, len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = value
+		return n, nil
+	}
+	return nil, ErrTransformInvalidPath
+}
+
+/*
+modifyJSON - Walk down a path and replace the parent of its last element with the result of fn,
+which is called with the parent and the last element of the path. Returns the new root.
+*/
+func modifyJSON(
+	node interface{},
+	path []string,
+	fn func(parent interface{}, key string) (interface{}, error),
+) (interface{}, error) {
+	if len(path) == 1 {
+		return fn(node, path[0])
+	}
+	child, err := jsonChild(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = modifyJSON(child, path[1:], fn); err != nil {
+		return nil, err
+	}
+	return jsonSetChild(node, path[0], child)
+}
+
+/*
+editJSONString - Apply the text edit of a transform to a JSON string.
+*/
+func editJSONString(node interface{}, ot *OTransform) (interface{}, error) {
+	str, ok := node.(string)
+	if !ok {
+		return nil, ErrTransformInvalidPath
+	}
+	var m OModel
+	runes := bytes.Runes([]byte(str))
+	if err := m.applyTransform(&runes, ot); err != nil {
+		return nil, err
+	}
+	return string(runes), nil
+}
+
+/*
+applyJSONTransform - Apply a specific transform to a parsed JSON tree, returns the new root.
+*/
+func applyJSONTransform(root interface{}, ot *OTransform) (interface{}, error) {
+	var value interface{}
+	if ot.Op == "set" || ot.Op == "insert" {
+		decoder := json.NewDecoder(bytes.NewReader(ot.Value))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return nil, ErrTransformInvalidValue
+		}
+	}
+
+	if len(ot.Path) == 0 {
+		switch ot.Op {
+		case "set":
+			return value, nil
+		case "text":
+			return editJSONString(root, ot)
+		case "noop":
+			return root, nil
+		}
+		return nil, ErrTransformInvalidPath
+	}
+
+	return modifyJSON(root, ot.Path, func(parent interface{}, key string) (interface{}, error) {
+		switch ot.Op {
+		case "set":
+			return jsonSetChild(parent, key, value)
+		case "text":
+			child, err := jsonChild(parent, key)
+			if err != nil {
+				return nil, err
+			}
+			if child, err = editJSONString(child, ot); err != nil {
+				return nil, err
+			}
+			return jsonSetChild(parent, key, child)
+		case "noop":
+			return parent, nil
+		case "unset":
+			object, ok := parent.(map[string]interface{})
+			if !ok {
+				return nil, ErrTransformInvalidPath
+			}
+			if _, exists := object[key]; !exists {
+				return nil, ErrTransformInvalidPath
+			}
+			delete(object, key)
+			return object, nil
+		}
+
+		array, ok := parent.([]interface{})
+		if !ok {
+			return nil, ErrTransformInvalidPath
+		}
+		switch ot.Op {
+		case "delete":
+			i, err := jsonIndex(key, len(array), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(array[:i], array[i+1:]...), nil
+		case "insert":
+			i, err := jsonIndex(key, len(array), true)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, nil)
+			copy(array[i+1:], array[i:])
+			array[i] = value
+			return array, nil
+		case "move":
+			from, err := jsonIndex(key, len(array), false)
+			if err != nil {
+				return nil, err
+			}
+			if ot.To < 0 || ot.To >= len(array) {
+				return nil, ErrTransformInvalidPath
+			}
+			moved := array[from]
+			array = append(array[:from], array[from+1:]...)
+			array = append(array, nil)
+			copy(array[ot.To+1:], array[ot.To:])
+			array[ot.To] = moved
+			return array, nil
+		}
+		return nil, ErrTransformInvalidOp
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+jsonPathHasPrefix - Whether a path begins with (or equals) a prefix path.
+*/
+func jsonPathHasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+updateJSONTransform - Modify the transform sub, which was constructed without regard to the earlier
+transform pre, so that it preserves its intention once pre has been applied.
+*/
+func updateJSONTransform(sub *OTransform, pre *OTransform) {
+	if sub.Op == "noop" {
+		return
+	}
+	switch pre.Op {
+	case "set":
+		// Concurrent sets of the same value are resolved by the last one winning.
+		if sub.Op == "set" && len(sub.Path) == len(pre.Path) && jsonPathHasPrefix(sub.Path, pre.Path) {
+			return
+		}
+		// A set replaces everything beneath it, including the string of a text edit.
+		container := sub.Path
+		if sub.Op != "text" && len(container) > 0 {
+			container = container[:len(container)-1]
+		}
+		if jsonPathHasPrefix(container, pre.Path) {
+			*sub = OTransform{Op: "noop", Version: sub.Version}
+		}
+	case "text":
+		if sub.Op == "text" && len(sub.Path) == len(pre.Path) && jsonPathHasPrefix(sub.Path, pre.Path) {
+			updateTransform(sub, pre)
+		}
+	case "unset":
+		// A concurrent set of the removed key adds it back, anything else beneath it is lost.
+		if sub.Op == "set" && len(sub.Path) == len(pre.Path) {
+			return
+		}
+		if jsonPathHasPrefix(sub.Path, pre.Path) {
+			*sub = OTransform{Op: "noop", Version: sub.Version}
+		}
+	case "delete":
+		array := pre.Path[:len(pre.Path)-1]
+		if len(sub.Path) <= len(array) || !jsonPathHasPrefix(sub.Path, array) {
+			return
+		}
+		index, err := strconv.Atoi(sub.Path[len(array)])
+		if err != nil {
+			return
+		}
+		preIndex, _ := strconv.Atoi(pre.Path[len(array)])
+
+		// The removed element takes everything beneath it, insertion points before it remain.
+		isPoint := sub.Op == "insert" && len(sub.Path) == len(array)+1
+		if index == preIndex && !isPoint {
+			*sub = OTransform{Op: "noop", Version: sub.Version}
+			return
+		}
+		if index > preIndex {
+			index--
+		}
+		if sub.Op == "move" && len(sub.Path) == len(array)+1 && sub.To > preIndex {
+			sub.To--
+		}
+
+		path := make([]string, len(sub.Path))
+		copy(path, sub.Path)
+		path[len(array)] = strconv.Itoa(index)
+		sub.Path = path
+	case "insert", "move":
+		array := pre.Path[:len(pre.Path)-1]
+		if len(sub.Path) <= len(array) || !jsonPathHasPrefix(sub.Path, array) {
+			return
+		}
+		index, err := strconv.Atoi(sub.Path[len(array)])
+		if err != nil {
+			return
+		}
+		preIndex, _ := strconv.Atoi(pre.Path[len(array)])
+
+		// Only the last element of an insert or move path is an insertion point, the rest are
+		// elements that must follow their new position.
+		isPoint := sub.Op == "insert" && len(sub.Path) == len(array)+1
+		newIndex := shiftJSONIndex(index, isPoint, pre.Op, preIndex, pre.To)
+		if sub.Op == "move" && len(sub.Path) == len(array)+1 {
+			sub.To = shiftJSONIndex(sub.To, true, pre.Op, preIndex, pre.To)
+		}
+
+		path := make([]string, len(sub.Path))
+		copy(path, sub.Path)
+		path[len(array)] = strconv.Itoa(newIndex)
+		sub.Path = path
+	}
+}
+
+/*
+shiftJSONIndex - Returns the index an array element (or insertion point) has once an earlier insert
+at preIndex, or move from preIndex to preTo, has been applied to the array. Concurrent inserts at the
+same point place the earlier transform first.
+*/
+func shiftJSONIndex(index int, isPoint bool, preOp string, preIndex, preTo int) int {
+	if preOp == "insert" {
+		if index >= preIndex {
+			return index + 1
+		}
+		return index
+	}
+	if index == preIndex && !isPoint {
+		return preTo
+	}
+	if index > preIndex {
+		index--
+	}
+	if index >= preTo {
+		index++
+	}
+	return index
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
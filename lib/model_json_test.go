@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func pushJSON(t *testing.T, model Model, ot OTransform) OTransform {
+	corrected, _, err := model.PushTransform(ot)
+	if err != nil {
+		t.Fatalf("Error pushing %v: %v", ot, err)
+	}
+	return corrected
+}
+
+func TestJSONModelOperations(t *testing.T) {
+	content := `{"name":"leaps","tags":["a","b","c"]}`
+
+	model := CreateJSONModel(DefaultModelConfig())
+	for _, ot := range []OTransform{
+		{Op: "set", Path: []string{"port"}, Value: json.RawMessage(`8080`)},
+		{Op: "insert", Path: []string{"tags", "3"}, Value: json.RawMessage(`"d"`)},
+		{Op: "move", Path: []string{"tags", "0"}, To: 3},
+		{Op: "text", Path: []string{"name"}, Position: 0, Delete: 1, Insert: "L"},
+	} {
+		ot.Version = model.GetVersion() + 1
+		pushJSON(t, model, ot)
+	}
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"name":"Leaps","port":8080,"tags":["b","c","d","a"]}`
+	if content != expected {
+		t.Errorf("Expected %v, received %v", expected, content)
+	}
+
+	for _, ot := range []OTransform{
+		{Op: "delete", Path: []string{"tags", "1"}},
+		{Op: "unset", Path: []string{"port"}},
+	} {
+		ot.Version = model.GetVersion() + 1
+		pushJSON(t, model, ot)
+	}
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+	if expected = `{"name":"Leaps","tags":["b","d","a"]}`; content != expected {
+		t.Errorf("Expected %v, received %v", expected, content)
+	}
+}
+
+func TestJSONModelConcurrentRemovals(t *testing.T) {
+	content := `{"list":["a","b","c"],"obj":{"text":"hello","n":1}}`
+
+	model := CreateJSONModel(DefaultModelConfig())
+
+	// All of these transforms were written against version 1 of the document.
+	pushJSON(t, model, OTransform{Op: "delete", Path: []string{"list", "1"}, Version: 2})
+	if ot := pushJSON(t, model, OTransform{
+		Op: "set", Path: []string{"list", "2"}, Value: json.RawMessage(`"C"`), Version: 2,
+	}); ot.Path[1] != "1" {
+		t.Errorf("Set index was not shifted by concurrent delete: %v", ot.Path)
+	}
+	if ot := pushJSON(t, model, OTransform{
+		Op: "set", Path: []string{"list", "1"}, Value: json.RawMessage(`"B"`), Version: 2,
+	}); ot.Op != "noop" {
+		t.Errorf("Set of a deleted element was not dropped: %v", ot)
+	}
+	if ot := pushJSON(t, model, OTransform{
+		Op: "insert", Path: []string{"list", "1"}, Value: json.RawMessage(`"x"`), Version: 2,
+	}); ot.Op != "insert" || ot.Path[1] != "1" {
+		t.Errorf("Insert at a deleted element was changed: %v", ot)
+	}
+	if ot := pushJSON(t, model, OTransform{Op: "delete", Path: []string{"list", "1"}, Version: 2}); ot.Op != "noop" {
+		t.Errorf("Second delete of an element was not dropped: %v", ot)
+	}
+	pushJSON(t, model, OTransform{Op: "unset", Path: []string{"obj", "text"}, Version: 2})
+	if ot := pushJSON(t, model, OTransform{
+		Op: "text", Path: []string{"obj", "text"}, Position: 0, Insert: "oh ", Version: 2,
+	}); ot.Op != "noop" {
+		t.Errorf("Edit of an unset key was not dropped: %v", ot)
+	}
+	pushJSON(t, model, OTransform{Op: "set", Path: []string{"obj", "n"}, Value: json.RawMessage(`2`), Version: 2})
+
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"list":["a","x","C"],"obj":{"n":2}}`; content != expected {
+		t.Errorf("Expected %v, received %v", expected, content)
+	}
+
+	for _, ot := range []OTransform{
+		{Op: "unset", Path: []string{"list", "0"}},
+		{Op: "unset", Path: []string{"missing"}},
+		{Op: "delete", Path: []string{"obj", "n"}},
+		{Op: "delete", Path: []string{"list", "9"}},
+	} {
+		root, _ := parseJSONContent(content)
+		if _, err := applyJSONTransform(root, &ot); err != ErrTransformInvalidPath {
+			t.Errorf("Wrong error from invalid removal %v: %v", ot, err)
+		}
+	}
+}
+
+func TestJSONModelConcurrentTransforms(t *testing.T) {
+	content := `{"list":["a","b"],"obj":{"text":"hello"}}`
+
+	model := CreateJSONModel(DefaultModelConfig())
+
+	// All of these transforms were written against version 1 of the document.
+	pushJSON(t, model, OTransform{Op: "insert", Path: []string{"list", "0"}, Value: json.RawMessage(`"z"`), Version: 2})
+	if ot := pushJSON(t, model, OTransform{
+		Op: "set", Path: []string{"list", "1"}, Value: json.RawMessage(`"B"`), Version: 2,
+	}); ot.Path[1] != "2" {
+		t.Errorf("Set index was not shifted by concurrent insert: %v", ot.Path)
+	}
+	pushJSON(t, model, OTransform{Op: "text", Path: []string{"obj", "text"}, Position: 5, Insert: " world", Version: 2})
+	if ot := pushJSON(t, model, OTransform{
+		Op: "text", Path: []string{"obj", "text"}, Position: 0, Delete: 1, Insert: "H", Version: 2,
+	}); ot.Position != 0 {
+		t.Errorf("Unexpected correction of text edit: %v", ot)
+	}
+	pushJSON(t, model, OTransform{Op: "set", Path: []string{"obj"}, Value: json.RawMessage(`{"text":"gone"}`), Version: 2})
+	if ot := pushJSON(t, model, OTransform{
+		Op: "text", Path: []string{"obj", "text"}, Position: 0, Insert: "!", Version: 2,
+	}); ot.Op != "noop" {
+		t.Errorf("Edit of a replaced value was not dropped: %v", ot)
+	}
+
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"list":["z","a","B"],"obj":{"text":"gone"}}`
+	if content != expected {
+		t.Errorf("Expected %v, received %v", expected, content)
+	}
+}
+
+func TestJSONModelInvalidTransforms(t *testing.T) {
+	model := CreateJSONModel(DefaultModelConfig())
+	if _, _, err := model.PushTransform(OTransform{Position: 0, Insert: "a", Version: 2}); err != ErrTransformInvalidOp {
+		t.Errorf("Expected invalid op error, received: %v", err)
+	}
+	if _, _, err := model.PushTransform(OTransform{
+		Op: "set", Path: []string{"a"}, Value: json.RawMessage(`{`), Version: 2,
+	}); err != ErrTransformInvalidValue {
+		t.Errorf("Expected invalid value error, received: %v", err)
+	}
+
+	text := CreateTextModel(DefaultModelConfig())
+	if _, _, err := text.PushTransform(OTransform{Op: "set", Version: 2}); err != ErrTransformInvalidOp {
+		t.Errorf("Expected invalid op error from text model, received: %v", err)
+	}
+	if _, err := CreateModel("yaml", DefaultModelConfig()); err == nil {
+		t.Error("Expected error from unknown document type")
+	}
+
+	content := `{"list":[]}`
+	pushJSON(t, model, OTransform{Op: "move", Path: []string{"list", "3"}, Version: 2})
+	if _, err := model.FlushTransforms(&content, 60); err != ErrTransformInvalidPath {
+		t.Errorf("Expected invalid path error, received: %v", err)
+	}
+}
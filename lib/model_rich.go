@@ -0,0 +1,369 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RichTextModel - A transform model for rich text documents, where ranges of text carry attributes
+such as bold, italic or link. The content of a rich text document is a JSON array of runs of text
+along with their attributes:
+
+	[{"insert":"Hello ","attributes":{"bold":"true"}},{"insert":"world"}]
+
+Transforms without an Op insert and delete text as in a text document, where the inserted text is
+given the Attributes of the transform. Transforms with the Op format retain the Retain characters
+following Position and apply the Attributes of the transform to them, an attribute with an empty
+value is removed.
+
+Formatted ranges are corrected against concurrent inserts and deletes, text inserted strictly within
+a range is formatted along with it. Concurrent formats of the same attribute are resolved by the
+last one winning.
+*/
+type RichTextModel struct {
+	config    ModelConfig
+	Version   int
+	Applied   []OTransform
+	Unapplied []OTransform
+}
+
+/*
+CreateRichTextModel - Returns a fresh rich text transform model, with the version set to 1.
+*/
+func CreateRichTextModel(config ModelConfig) Model {
+	return &RichTextModel{
+		config:    config,
+		Version:   1,
+		Applied:   []OTransform{},
+		Unapplied: []OTransform{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+PushTransform - Inserts a transform onto the unapplied stack and increments the version number of
+the document, correcting it against the transforms it was unaware of.
+*/
+func (m *RichTextModel) PushTransform(ot OTransform) (OTransform, int, error) {
+	if ot.Op != "" && ot.Op != "format" {
+		return OTransform{}, 0, ErrTransformInvalidOp
+	}
+	if ot.Delete < 0 || ot.Retain < 0 {
+		return OTransform{}, 0, ErrTransformNegDelete
+	}
+	if uint64(len(ot.Insert)) > m.config.MaxTransformLength {
+		return OTransform{}, 0, ErrTransformTooLong
+	}
+
+	lenApplied, lenUnapplied := len(m.Applied), len(m.Unapplied)
+
+	diff := (m.Version + 1) - ot.Version
+
+	if diff > lenApplied+lenUnapplied {
+		return OTransform{}, 0, ErrTransformTooOld
+	}
+	if diff < 0 {
+		return OTransform{}, 0, fmt.Errorf(
+			"transform version %v greater than expected doc version (%v), offender: %v",
+			ot.Version, (m.Version + 1), ot)
+	}
+
+	for j := lenApplied - (diff - lenUnapplied); j < lenApplied; j++ {
+		updateRichTransform(&ot, &m.Applied[j])
+		diff--
+	}
+	for j := lenUnapplied - diff; j < lenUnapplied; j++ {
+		updateRichTransform(&ot, &m.Unapplied[j])
+	}
+
+	m.Version++
+
+	ot.Version = m.Version
+	ot.TReceived = time.Now().Unix()
+
+	m.Unapplied = append(m.Unapplied, ot)
+
+	return ot, m.Version, nil
+}
+
+/*
+GetVersion - returns the current version of the document.
+*/
+func (m *RichTextModel) GetVersion() int {
+	return m.Version
+}
+
+/*
+GetUnapplied - returns a copy of the transforms pushed since the last flush.
+*/
+func (m *RichTextModel) GetUnapplied() []OTransform {
+	return append([]OTransform{}, m.Unapplied...)
+}
+
+/*
+DropTransform - removes the most recently pushed transform whilst it remains unapplied.
+*/
+func (m *RichTextModel) DropTransform() bool {
+	if len(m.Unapplied) == 0 {
+		return false
+	}
+	m.Unapplied = m.Unapplied[:len(m.Unapplied)-1]
+	m.Version--
+	return true
+}
+
+/*
+FlushTransforms - apply all unapplied transforms to the runs of the content and append them to the
+applied stack, then remove old entries from the applied stack. Returns a bool indicating whether any
+changes were applied.
+*/
+func (m *RichTextModel) FlushTransforms(content *string, secondsRetention int64) (bool, error) {
+	transforms := m.Unapplied[:]
+	m.Unapplied = []OTransform{}
+
+	runs, err := parseRichContent(*content)
+
+	var i, j int
+	for i = 0; err == nil && i < len(transforms); i++ {
+		runs, err = applyRichTransform(runs, &transforms[i])
+	}
+
+	if err == nil && i > 0 {
+		var result []byte
+		if result, err = json.Marshal(runs); err == nil {
+			if uint64(len(result)) > m.config.MaxDocumentSize {
+				return true, ErrTransformTooLong
+			}
+			*content = string(result)
+		}
+	}
+
+	upto := time.Now().Unix() - secondsRetention
+	for j = 0; j < len(m.Applied); j++ {
+		if m.Applied[j].TReceived > upto {
+			break
+		}
+	}
+
+	applied := m.Applied[j:]
+	m.Applied = make([]OTransform, len(transforms)+len(applied))
+
+	copy(m.Applied[:], applied)
+	copy(m.Applied[len(applied):], transforms)
+
+	return i > 0, err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+richRun - A run of text sharing the same attributes.
+*/
+type richRun struct {
+	Insert     string            `json:"insert"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+/*
+parseRichContent - Parse the runs of a rich text document, empty content is an empty document.
+*/
+func parseRichContent(content string) ([]richRun, error) {
+	runs := []richRun{}
+	if len(content) == 0 {
+		return runs, nil
+	}
+	if err := json.Unmarshal([]byte(content), &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+/*
+replayRichTransforms - Apply a sequence of already corrected transforms to rich text content.
+*/
+func replayRichTransforms(content string, transforms []OTransform) (string, error) {
+	runs, err := parseRichContent(content)
+	if err != nil {
+		return "", err
+	}
+	for i := range transforms {
+		if runs, err = applyRichTransform(runs, &transforms[i]); err != nil {
+			return "", err
+		}
+	}
+	result, err := json.Marshal(runs)
+	return string(result), err
+}
+
+/*
+splitRuns - Split the runs at a character position, returning the index of the first run that
+begins at or after the position.
+*/
+func splitRuns(runs []richRun, position int) ([]richRun, int, error) {
+	for i := 0; i < len(runs); i++ {
+		length := utf8.RuneCountInString(runs[i].Insert)
+		if position == 0 {
+			return runs, i, nil
+		}
+		if position < length {
+			text := []rune(runs[i].Insert)
+			head := richRun{Insert: string(text[:position]), Attributes: runs[i].Attributes}
+			tail := richRun{Insert: string(text[position:]), Attributes: runs[i].Attributes}
+
+			runs = append(runs, richRun{})
+			copy(runs[i+2:], runs[i+1:])
+			runs[i], runs[i+1] = head, tail
+			return runs, i + 1, nil
+		}
+		position -= length
+	}
+	if position > 0 {
+		return nil, 0, ErrTransformInvalidPath
+	}
+	return runs, len(runs), nil
+}
+
+/*
+sameAttributes - Whether two attribute sets are equal.
+*/
+func sameAttributes(left, right map[string]string) bool {
+	if len(left) != len(right) {
+		return false
+	}
+	for k, v := range left {
+		if rv, ok := right[k]; !ok || rv != v {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+applyRichTransform - Apply a specific transform to the runs of a rich text document, returns the new
+runs with empty runs removed and neighbouring runs of the same attributes merged.
+*/
+func applyRichTransform(runs []richRun, ot *OTransform) ([]richRun, error) {
+	length := ot.Delete
+	if ot.Op == "format" {
+		length = ot.Retain
+	}
+
+	var start, end int
+	var err error
+	if runs, start, err = splitRuns(runs, ot.Position); err != nil {
+		return nil, err
+	}
+	if runs, end, err = splitRuns(runs, ot.Position+length); err != nil {
+		return nil, err
+	}
+
+	if ot.Op == "format" {
+		for i := start; i < end; i++ {
+			attributes := map[string]string{}
+			for k, v := range runs[i].Attributes {
+				attributes[k] = v
+			}
+			for k, v := range ot.Attributes {
+				if len(v) == 0 {
+					delete(attributes, k)
+				} else {
+					attributes[k] = v
+				}
+			}
+			runs[i].Attributes = attributes
+		}
+	} else {
+		tail := append([]richRun{}, runs[end:]...)
+		runs = append(runs[:start], richRun{Insert: ot.Insert, Attributes: ot.Attributes})
+		runs = append(runs, tail...)
+	}
+
+	merged := []richRun{}
+	for _, run := range runs {
+		if len(run.Insert) == 0 {
+			continue
+		}
+		if len(run.Attributes) == 0 {
+			run.Attributes = nil
+		}
+		if last := len(merged) - 1; last >= 0 && sameAttributes(merged[last].Attributes, run.Attributes) {
+			merged[last].Insert += run.Insert
+			continue
+		}
+		merged = append(merged, run)
+	}
+	return merged, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+shiftRichPosition - Returns where a position ends up once the text edit pre has been applied. Text
+inserted at the position itself ends up after it when after is set, and before it otherwise.
+*/
+func shiftRichPosition(position int, pre *OTransform, after bool) int {
+	switch {
+	case position >= pre.Position+pre.Delete:
+		position -= pre.Delete
+	case position > pre.Position:
+		position = pre.Position
+	}
+	if position > pre.Position || (after && position == pre.Position) {
+		position += utf8.RuneCountInString(pre.Insert)
+	}
+	return position
+}
+
+/*
+updateRichTransform - Modify the transform sub, which was constructed without regard to the earlier
+transform pre, so that it preserves its intention once pre has been applied. Formats never move
+text, so only text edits affect other transforms.
+*/
+func updateRichTransform(sub *OTransform, pre *OTransform) {
+	if pre.Op == "format" {
+		return
+	}
+	if sub.Op != "format" {
+		updateTransform(sub, pre)
+		return
+	}
+	// Text inserted at either edge of the range is left unformatted.
+	start := shiftRichPosition(sub.Position, pre, true)
+	end := shiftRichPosition(sub.Position+sub.Retain, pre, false)
+	sub.Position, sub.Retain = start, intMax(0, end-start)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import "testing"
+
+func TestRichTextModelFormatting(t *testing.T) {
+	content := `[{"insert":"hello world"}]`
+
+	model := CreateRichTextModel(DefaultModelConfig())
+	for _, ot := range []OTransform{
+		{Op: "format", Position: 0, Retain: 5, Attributes: map[string]string{"bold": "true"}},
+		{Position: 11, Insert: "!", Attributes: map[string]string{"italic": "true"}},
+		{Op: "format", Position: 2, Retain: 3, Attributes: map[string]string{"bold": ""}},
+	} {
+		ot.Version = model.GetVersion() + 1
+		if _, _, err := model.PushTransform(ot); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"insert":"he","attributes":{"bold":"true"}},{"insert":"llo world"},` +
+		`{"insert":"!","attributes":{"italic":"true"}}]`
+	if content != expected {
+		t.Errorf("Expected %v, received %v", expected, content)
+	}
+}
+
+func TestRichTextModelConcurrentFormat(t *testing.T) {
+	content := `[{"insert":"hello world"}]`
+
+	model := CreateRichTextModel(DefaultModelConfig())
+
+	// All transforms were written against version 1, the insert lands within the formatted range
+	// and the deletion removes the text before it.
+	if _, _, err := model.PushTransform(OTransform{Position: 8, Insert: "o", Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := model.PushTransform(OTransform{Position: 0, Delete: 6, Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	ot, _, err := model.PushTransform(OTransform{
+		Op: "format", Position: 6, Retain: 5, Attributes: map[string]string{"link": "http://a"}, Version: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ot.Position != 0 || ot.Retain != 6 {
+		t.Errorf("Wrong correction of format: %v", ot)
+	}
+
+	if _, err := model.FlushTransforms(&content, 60); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"insert":"woorld","attributes":{"link":"http://a"}}]`
+	if content != expected {
+		t.Errorf("Expected %v, received %v", expected, content)
+	}
+}
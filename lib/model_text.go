@@ -24,6 +24,7 @@ package lib
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
@@ -41,14 +42,24 @@ var (
 
 /*
 OTransform - A representation of a transformation relating to a leap document. This can either be a
-text addition, a text deletion, or both.
+text addition, a text deletion, or both. Transforms of JSON documents also carry an Op along with
+the Path it applies to, see JSONModel, and transforms of rich text documents may carry Attributes,
+see RichTextModel. Author is only set by the server, for transforms that did not come from a client
+such as those of a formatter.
 */
 type OTransform struct {
-	Position  int    `json:"position" yaml:"position"`
-	Delete    int    `json:"num_delete" yaml:"num_delete"`
-	Insert    string `json:"insert" yaml:"insert"`
-	Version   int    `json:"version" yaml:"version"`
-	TReceived int64  `json:"received,omitempty" yaml:"received,omitempty"`
+	Position   int               `json:"position" yaml:"position"`
+	Delete     int               `json:"num_delete" yaml:"num_delete"`
+	Insert     string            `json:"insert" yaml:"insert"`
+	Version    int               `json:"version" yaml:"version"`
+	TReceived  int64             `json:"received,omitempty" yaml:"received,omitempty"`
+	Op         string            `json:"op,omitempty" yaml:"op,omitempty"`
+	Path       []string          `json:"path,omitempty" yaml:"path,omitempty"`
+	Value      json.RawMessage   `json:"value,omitempty" yaml:"value,omitempty"`
+	To         int               `json:"to,omitempty" yaml:"to,omitempty"`
+	Retain     int               `json:"num_retain,omitempty" yaml:"num_retain,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty" yaml:"attributes,omitempty"`
+	Author     string            `json:"author,omitempty" yaml:"author,omitempty"`
 }
 
 /*
@@ -83,6 +94,9 @@ the document. Whilst doing so it fixes the transform in relation to earlier tran
 unaware of, this fixed version gets sent back for distributing across other clients.
 */
 func (m *OModel) PushTransform(ot OTransform) (OTransform, int, error) {
+	if len(ot.Op) > 0 {
+		return OTransform{}, 0, ErrTransformInvalidOp
+	}
 	if ot.Delete < 0 {
 		return OTransform{}, 0, ErrTransformNegDelete
 	}
@@ -131,6 +145,25 @@ func (m *OModel) GetVersion() int {
 	return m.Version
 }
 
+/*
+GetUnapplied - returns a copy of the transforms pushed since the last flush.
+*/
+func (m *OModel) GetUnapplied() []OTransform {
+	return append([]OTransform{}, m.Unapplied...)
+}
+
+/*
+DropTransform - removes the most recently pushed transform whilst it remains unapplied.
+*/
+func (m *OModel) DropTransform() bool {
+	if len(m.Unapplied) == 0 {
+		return false
+	}
+	m.Unapplied = m.Unapplied[:len(m.Unapplied)-1]
+	m.Version--
+	return true
+}
+
 /*
 FlushTransforms - apply all unapplied transforms and append them to the applied stack, then remove
 old entries from the applied stack. Accepts retention as an indicator for how many seconds applied
@@ -231,6 +264,44 @@ func updateTransform(sub *OTransform, pre *OTransform) {
 	}
 }
 
+/*
+diffTextTransform - Returns a single transform that turns content into its changed form, which
+replaces the span between the longest common prefix and suffix of the two.
+*/
+func diffTextTransform(content, changed string) OTransform {
+	before, after := []rune(content), []rune(changed)
+
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && before[prefix] == after[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		before[len(before)-1-suffix] == after[len(after)-1-suffix] {
+		suffix++
+	}
+
+	return OTransform{
+		Position: prefix,
+		Delete:   len(before) - prefix - suffix,
+		Insert:   string(after[prefix : len(after)-suffix]),
+	}
+}
+
+/*
+replayTextTransforms - Apply a sequence of already corrected transforms to some content.
+*/
+func replayTextTransforms(content string, transforms []OTransform) (string, error) {
+	var m OModel
+	runeContent := bytes.Runes([]byte(content))
+	for i := range transforms {
+		if err := m.applyTransform(&runeContent, &transforms[i]); err != nil {
+			return "", err
+		}
+	}
+	return string(runeContent), nil
+}
+
 /*
 applyTransform - Apply a specific transform to some content.
 */
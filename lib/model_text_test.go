@@ -23,12 +23,11 @@ THE SOFTWARE.
 package lib
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"testing"
 
 	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/test/conformance"
 )
 
 func TestTextModelSimpleTransforms(t *testing.T) {
@@ -106,33 +105,26 @@ func TestPushPullTransforms(t *testing.T) {
 	}
 }
 
-type tformStory struct {
-	Name       string       `json:"name" yaml:"name"`
-	Content    string       `json:"content" yaml:"content"`
-	Transforms []OTransform `json:"transforms" yaml:"transforms"`
-	TCorrected []OTransform `json:"corrected_transforms" yaml:"corrected_transforms"`
-	Result     string       `json:"result" yaml:"result"`
-	Flushes    []int        `json:"flushes" yaml:"flushes"`
-}
-
-type storiesContainer struct {
-	Stories []tformStory `json:"stories" yaml:"stories"`
+/*
+fixtureTransform - Converts a transform of the conformance fixtures into an OTransform.
+*/
+func fixtureTransform(tform conformance.Transform) OTransform {
+	return OTransform{
+		Position: tform.Position,
+		Delete:   tform.Delete,
+		Insert:   tform.Insert,
+		Version:  tform.Version,
+	}
 }
 
 func TestTransformStories(t *testing.T) {
-	bytes, err := ioutil.ReadFile("../test/stories/transform_stories.js")
+	stories, err := conformance.TransformStories()
 	if err != nil {
-		t.Errorf("Read file error: %v", err)
-		return
-	}
-
-	var scont storiesContainer
-	if err := json.Unmarshal(bytes, &scont); err != nil {
-		t.Errorf("Story parse error: %v", err)
+		t.Errorf("Story load error: %v", err)
 		return
 	}
 
-	for _, story := range scont.Stories {
+	for _, story := range stories {
 		stages := []byte("Stages of story:\n")
 
 		doc, err := store.NewDocument(story.Content)
@@ -146,16 +138,16 @@ func TestTransformStories(t *testing.T) {
 			[]byte(fmt.Sprintf("\tInitial : %v\n", doc.Content))...)
 
 		for j, change := range story.Transforms {
-			if ts, _, err := model.PushTransform(change); err != nil {
+			if ts, _, err := model.PushTransform(fixtureTransform(change)); err != nil {
 				t.Errorf("Failed to insert: %v", err)
 			} else {
-				if len(story.TCorrected) > j {
-					if story.TCorrected[j].Position != ts.Position ||
-						story.TCorrected[j].Version != ts.Version ||
-						story.TCorrected[j].Delete != ts.Delete ||
-						story.TCorrected[j].Insert != ts.Insert {
+				if len(story.CorrectedTransforms) > j {
+					if story.CorrectedTransforms[j].Position != ts.Position ||
+						story.CorrectedTransforms[j].Version != ts.Version ||
+						story.CorrectedTransforms[j].Delete != ts.Delete ||
+						story.CorrectedTransforms[j].Insert != ts.Insert {
 						t.Errorf("Tform does not match corrected form: %v != %v",
-							story.TCorrected[j], ts)
+							story.CorrectedTransforms[j], ts)
 					}
 				}
 			}
@@ -0,0 +1,301 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jeffail/leaps/lib/auth"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Modes of a NotificationPreference.
+const (
+	NotifyAll    = "all"
+	NotifyDigest = "digest"
+	NotifyMute   = "mute"
+)
+
+/*
+NotificationPreference - How a user wishes to be notified of activity on a document, which means
+other users joining and leaving and their chat messages. In the default mode of 'all' activity is
+sent as it happens, 'digest' summarises it periodically instead, and 'mute' holds it back entirely.
+*/
+type NotificationPreference struct {
+	Mode string `json:"mode" yaml:"mode"`
+}
+
+/*
+PreferencesConfig - Holds configuration options for notification preferences. Preferences are kept in
+memory only unless Path is set, in which case they are persisted to a JSON file there. DigestPeriod
+is the number of seconds between the digests of activity sent to users who prefer them. Preferences
+expire TTL seconds after they were last set, a TTL of zero keeps them forever.
+*/
+type PreferencesConfig struct {
+	Path         string `json:"path" yaml:"path"`
+	DigestPeriod int64  `json:"digest_period_s" yaml:"digest_period_s"`
+	TTL          int64  `json:"ttl_s" yaml:"ttl_s"`
+}
+
+/*
+NewPreferencesConfig - Returns a default configuration for notification preferences.
+*/
+func NewPreferencesConfig() PreferencesConfig {
+	return PreferencesConfig{
+		Path:         "",
+		DigestPeriod: 300,
+		TTL:          7776000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Preferences type.
+var (
+	ErrInvalidNotificationMode = errors.New("notification mode must be all, digest or mute")
+)
+
+/*
+storedPreference - A preference along with the unix time it expires at, zero if it never does.
+*/
+type storedPreference struct {
+	NotificationPreference
+	Expires int64 `json:"expires,omitempty"`
+}
+
+/*
+Preferences - The notification preferences of users, by document. Users are identified by their ID
+when the authenticator can resolve it from their tokens, and by a hash of their token otherwise.
+*/
+type Preferences struct {
+	config     PreferencesConfig
+	identifier auth.UserIdentifier
+	prefs      map[string]map[string]storedPreference
+	mutex      sync.RWMutex
+}
+
+/*
+NewPreferences - Creates a set of preferences, reading back any previously persisted to the
+configured path.
+*/
+func NewPreferences(config PreferencesConfig) (*Preferences, error) {
+	p := &Preferences{
+		config: config,
+		prefs:  map[string]map[string]storedPreference{},
+	}
+	if len(config.Path) == 0 {
+		return p, nil
+	}
+	data, err := ioutil.ReadFile(config.Path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, &p.prefs); err != nil {
+		return nil, err
+	}
+
+	// Preferences persisted before they expired start their TTL now
+	for _, users := range p.prefs {
+		for user, pref := range users {
+			if pref.Expires == 0 {
+				pref.Expires = p.expiry()
+				users[user] = pref
+			}
+		}
+	}
+	return p, nil
+}
+
+/*
+UseIdentifier - Identify users by the IDs an authenticator resolves from their tokens.
+*/
+func (p *Preferences) UseIdentifier(identifier auth.UserIdentifier) {
+	p.mutex.Lock()
+	p.identifier = identifier
+	p.mutex.Unlock()
+}
+
+/*
+identifyUsers - Identify users by their IDs when an authenticator is able to resolve them.
+*/
+func (p *Preferences) identifyUsers(authenticator auth.Authenticator) {
+	if identifier, ok := authenticator.(auth.UserIdentifier); ok {
+		p.UseIdentifier(identifier)
+	}
+}
+
+/*
+User - Returns the key that preferences of the user of a token are stored under, which is either the
+ID of the user or a hash of the token.
+*/
+func (p *Preferences) User(token string) string {
+	if p == nil {
+		return ""
+	}
+	p.mutex.RLock()
+	identifier := p.identifier
+	p.mutex.RUnlock()
+
+	if identifier != nil {
+		if userID, ok := identifier.IdentifyUser(token); ok {
+			return "user:" + userID
+		}
+	}
+	return "token:" + auditTokenHash(token)
+}
+
+/*
+Get - Returns the preference of a user for a document, the user is a key returned by User.
+*/
+func (p *Preferences) Get(user, id string) NotificationPreference {
+	if p == nil {
+		return NotificationPreference{Mode: NotifyAll}
+	}
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	if pref, ok := p.prefs[id][user]; ok && !pref.expired(time.Now()) {
+		return pref.NotificationPreference
+	}
+	return NotificationPreference{Mode: NotifyAll}
+}
+
+/*
+Set - Store the preference of a user for a document, and persist all preferences when configured to.
+Expired preferences are removed at the same time.
+*/
+func (p *Preferences) Set(user, id string, pref NotificationPreference) error {
+	switch pref.Mode {
+	case NotifyAll, NotifyDigest, NotifyMute:
+	default:
+		return ErrInvalidNotificationMode
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.prune()
+
+	// The default needs no storing
+	if pref.Mode == NotifyAll {
+		delete(p.prefs[id], user)
+		if len(p.prefs[id]) == 0 {
+			delete(p.prefs, id)
+		}
+	} else {
+		if _, ok := p.prefs[id]; !ok {
+			p.prefs[id] = map[string]storedPreference{}
+		}
+		p.prefs[id][user] = storedPreference{NotificationPreference: pref, Expires: p.expiry()}
+	}
+	return p.save()
+}
+
+/*
+expiry - Returns the expiry time of a preference set now.
+*/
+func (p *Preferences) expiry() int64 {
+	if p.config.TTL <= 0 {
+		return 0
+	}
+	return time.Now().Add(time.Duration(p.config.TTL) * time.Second).Unix()
+}
+
+/*
+expired - Returns whether a preference has expired.
+*/
+func (s storedPreference) expired(now time.Time) bool {
+	return s.Expires > 0 && s.Expires <= now.Unix()
+}
+
+/*
+prune - Remove all expired preferences. Must be called whilst holding the lock.
+*/
+func (p *Preferences) prune() {
+	now := time.Now()
+	for id, users := range p.prefs {
+		for user, pref := range users {
+			if pref.expired(now) {
+				delete(users, user)
+			}
+		}
+		if len(users) == 0 {
+			delete(p.prefs, id)
+		}
+	}
+}
+
+/*
+digestPeriod - Returns the period of activity digests in seconds, zero when there are none.
+*/
+func (p *Preferences) digestPeriod() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.config.DigestPeriod
+}
+
+/*
+save - Write all preferences to the configured path, the file is replaced atomically. Must be called
+whilst holding the lock.
+*/
+func (p *Preferences) save() error {
+	if len(p.config.Path) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(p.prefs, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p.config.Path), ".preferences")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p.config.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,125 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreferences(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_preferences")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewPreferencesConfig()
+	config.Path = filepath.Join(dir, "preferences.json")
+
+	prefs, err := NewPreferences(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := prefs.Get("user", "doc").Mode; mode != NotifyAll {
+		t.Errorf("Wrong default mode: %v", mode)
+	}
+	if err = prefs.Set("user", "doc", NotificationPreference{Mode: "loud"}); err != ErrInvalidNotificationMode {
+		t.Errorf("Expected invalid mode error, received: %v", err)
+	}
+	if err = prefs.Set("user", "doc", NotificationPreference{Mode: NotifyMute}); err != nil {
+		t.Fatal(err)
+	}
+	if err = prefs.Set("other", "doc", NotificationPreference{Mode: NotifyDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err = prefs.Set("other", "doc", NotificationPreference{Mode: NotifyAll}); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewPreferences(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := reloaded.Get("user", "doc").Mode; mode != NotifyMute {
+		t.Errorf("Preference was not persisted: %v", mode)
+	}
+	if mode := reloaded.Get("other", "doc").Mode; mode != NotifyAll {
+		t.Errorf("Preference was not reset: %v", mode)
+	}
+	if mode := reloaded.Get("user", "other_doc").Mode; mode != NotifyAll {
+		t.Errorf("Preference leaked across documents: %v", mode)
+	}
+}
+
+type tabIdentifier struct{}
+
+func (tabIdentifier) IdentifyUser(token string) (string, bool) {
+	if i := strings.Index(token, "/"); i > 0 {
+		return token[:i], true
+	}
+	return "", false
+}
+
+func TestPreferencesUsersAndExpiry(t *testing.T) {
+	prefs, err := NewPreferences(NewPreferencesConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs.UseIdentifier(tabIdentifier{})
+
+	if prefs.User("alice/tab1") != prefs.User("alice/tab2") {
+		t.Error("Tokens of the same user were given different keys")
+	}
+	if prefs.User("bob") == prefs.User("alice/tab1") {
+		t.Error("Tokens of different users were given the same key")
+	}
+	if user := prefs.User("secret"); strings.Contains(user, "secret") {
+		t.Errorf("Unidentified token was stored as is: %v", user)
+	}
+
+	if err = prefs.Set(prefs.User("alice/tab1"), "doc", NotificationPreference{Mode: NotifyMute}); err != nil {
+		t.Fatal(err)
+	}
+	if mode := prefs.Get(prefs.User("alice/tab2"), "doc").Mode; mode != NotifyMute {
+		t.Errorf("Preference did not follow the user: %v", mode)
+	}
+
+	expired := prefs.prefs["doc"][prefs.User("alice/tab1")]
+	expired.Expires = time.Now().Add(-time.Second).Unix()
+	prefs.prefs["doc"][prefs.User("alice/tab1")] = expired
+
+	if mode := prefs.Get(prefs.User("alice/tab1"), "doc").Mode; mode != NotifyAll {
+		t.Errorf("Expired preference was returned: %v", mode)
+	}
+	if err = prefs.Set(prefs.User("bob"), "other", NotificationPreference{Mode: NotifyDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := prefs.prefs["doc"]; ok {
+		t.Error("Expired preference was not removed")
+	}
+}
@@ -0,0 +1,314 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RecorderConfig - Holds configuration options for transform recording. When Directory is set the
+full transform stream of each document is recorded with timestamps into a file within it, which can
+later be played back with `leaps replay`.
+*/
+type RecorderConfig struct {
+	Directory string `json:"directory" yaml:"directory"`
+}
+
+/*
+NewRecorderConfig - Returns a default recorder configuration, recording is disabled.
+*/
+func NewRecorderConfig() RecorderConfig {
+	return RecorderConfig{
+		Directory: "",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Recorder type.
+var (
+	ErrNoRecorderDirectory = errors.New("recorder directory was not specified")
+	ErrEmptyRecording      = errors.New("recording does not contain a document")
+	ErrRecordingDisabled   = errors.New("documents are not being recorded")
+	ErrRecordingNoEpoch    = errors.New("recording does not contain a binding of that epoch")
+)
+
+/*
+RecordEntry - A single line of a recording. Each time a document is bound the recording gains an
+entry with the Document and its Version, which is followed by an entry for each applied Transform.
+Every entry carries the Epoch of the binding that recorded it, recordings made before epochs were
+recorded have none.
+*/
+type RecordEntry struct {
+	Time      time.Time       `json:"time" yaml:"time"`
+	Epoch     string          `json:"epoch,omitempty" yaml:"epoch,omitempty"`
+	Document  *store.Document `json:"document,omitempty" yaml:"document,omitempty"`
+	Version   int             `json:"version,omitempty" yaml:"version,omitempty"`
+	Transform *OTransform     `json:"transform,omitempty" yaml:"transform,omitempty"`
+}
+
+/*
+Recorder - Appends the transform stream of a single binding of a document to its recording file.
+*/
+type Recorder struct {
+	file  *os.File
+	epoch string
+	mutex sync.Mutex
+}
+
+/*
+RecordingPath - The path of the recording file of a document within a directory, IDs that would
+resolve to a path outside of the directory are rejected.
+*/
+func RecordingPath(directory, id string) (string, error) {
+	return store.DocumentPath(directory, id+".leapsrec")
+}
+
+/*
+NewRecorder - Opens the recording file of a document for appending, creating it if necessary.
+*/
+func NewRecorder(config RecorderConfig, id string) (*Recorder, error) {
+	if len(config.Directory) == 0 {
+		return nil, ErrNoRecorderDirectory
+	}
+	path, err := RecordingPath(config.Directory, id)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{file: file}, nil
+}
+
+/*
+Start - Record the document as it was when bound, along with the epoch of the binding and its version.
+*/
+func (r *Recorder) Start(doc store.Document, epoch string, version int) error {
+	r.mutex.Lock()
+	r.epoch = epoch
+	r.mutex.Unlock()
+	return r.write(RecordEntry{Time: time.Now(), Document: &doc, Version: version})
+}
+
+/*
+Record - Record a transform that was applied to the document.
+*/
+func (r *Recorder) Record(ot OTransform) error {
+	return r.write(RecordEntry{Time: time.Now(), Transform: &ot})
+}
+
+/*
+Close - Close the recording file.
+*/
+func (r *Recorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.file.Close()
+}
+
+func (r *Recorder) write(entry RecordEntry) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry.Epoch = r.epoch
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RecordingBinding - The part of a recording made by a single binding of the document, identified by
+its Epoch. Document and Version are those of the document as it was bound, and Transforms lists
+each transform applied whilst bound in the order they were applied. Versions begin again with each
+binding.
+*/
+type RecordingBinding struct {
+	Epoch      string         `json:"epoch,omitempty" yaml:"epoch,omitempty"`
+	Started    time.Time      `json:"started" yaml:"started"`
+	Document   store.Document `json:"document" yaml:"document"`
+	Version    int            `json:"version" yaml:"version"`
+	Transforms []RecordEntry  `json:"transforms" yaml:"transforms"`
+}
+
+/*
+Recording - A parsed recording, holding a binding for each time the document was bound in the order
+they were bound.
+*/
+type Recording struct {
+	Bindings []RecordingBinding `json:"bindings" yaml:"bindings"`
+}
+
+/*
+ReadRecording - Parse the recording file at a path. Each document entry begins a binding, and each
+transform belongs to the binding of its epoch, as the binders of a document on different nodes may
+record to the same file at once. Transforms recorded without an epoch belong to the binding begun
+last. A partially written final line, which can be left behind by a crash mid append, is ignored.
+*/
+func ReadRecording(path string) (Recording, error) {
+	recording := Recording{Bindings: []RecordingBinding{}}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return recording, err
+	}
+	defer file.Close()
+
+	// The index of the binding of each epoch, the bindings of later documents replace earlier ones
+	epochs := map[string]int{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024*64)
+	for scanner.Scan() {
+		var entry RecordEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		if entry.Document != nil {
+			epochs[entry.Epoch] = len(recording.Bindings)
+			recording.Bindings = append(recording.Bindings, RecordingBinding{
+				Epoch:      entry.Epoch,
+				Started:    entry.Time,
+				Document:   *entry.Document,
+				Version:    entry.Version,
+				Transforms: []RecordEntry{},
+			})
+			continue
+		}
+		if entry.Transform == nil {
+			continue
+		}
+		i, ok := epochs[entry.Epoch]
+		if len(entry.Epoch) == 0 {
+			i, ok = len(recording.Bindings)-1, len(recording.Bindings) > 0
+		}
+		if ok {
+			recording.Bindings[i].Transforms = append(recording.Bindings[i].Transforms, entry)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return recording, err
+	}
+	if len(recording.Bindings) == 0 {
+		return recording, ErrEmptyRecording
+	}
+	return recording, nil
+}
+
+/*
+Seek - Returns the recording as it continues from a version of the binding of an epoch, or of the
+first binding when the epoch is empty. Earlier bindings are dropped, and the binding is
+reconstructed at the version with Started becoming the time the version was recorded.
+*/
+func (r Recording) Seek(epoch string, version int) (Recording, error) {
+	if len(r.Bindings) == 0 {
+		return r, ErrEmptyRecording
+	}
+	first := -1
+	for i, binding := range r.Bindings {
+		if len(epoch) == 0 || binding.Epoch == epoch {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return r, ErrRecordingNoEpoch
+	}
+	binding := r.Bindings[first]
+	applied := []OTransform{}
+	i := 0
+	for ; i < len(binding.Transforms) && binding.Transforms[i].Transform.Version <= version; i++ {
+		applied = append(applied, *binding.Transforms[i].Transform)
+	}
+	if i > 0 {
+		content, err := replayTransforms(binding.Document.Type, binding.Document.Content, applied)
+		if err != nil {
+			return r, err
+		}
+		binding.Started = binding.Transforms[i-1].Time
+		binding.Document.Content = content
+		binding.Version = applied[i-1].Version
+		binding.Transforms = binding.Transforms[i:]
+	}
+
+	return Recording{Bindings: append([]RecordingBinding{binding}, r.Bindings[first+1:]...)}, nil
+}
+
+/*
+DocumentAt - Reconstruct the document as it was at a version of the latest binding of the recording.
+*/
+func (r Recording) DocumentAt(version int) (store.Document, error) {
+	if len(r.Bindings) == 0 {
+		return store.Document{}, ErrEmptyRecording
+	}
+	binding := r.Bindings[len(r.Bindings)-1]
+	if version < binding.Version {
+		return store.Document{}, ErrVersionNotRetained
+	}
+
+	reached, applied := binding.Version, []OTransform{}
+	for _, entry := range binding.Transforms {
+		if entry.Transform.Version > version {
+			break
+		}
+		reached = entry.Transform.Version
+		applied = append(applied, *entry.Transform)
+	}
+	if reached != version {
+		return store.Document{}, ErrVersionNotExist
+	}
+
+	doc := binding.Document
+	content, err := replayTransforms(doc.Type, doc.Content, applied)
+	if err != nil {
+		return doc, err
+	}
+	doc.Content = content
+	return doc, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
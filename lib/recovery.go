@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RecoveryFailure - A document whose transform log could not be recovered, the log is left in place
+so that nothing is lost while an operator investigates.
+*/
+type RecoveryFailure struct {
+	ID    string `json:"id" yaml:"id"`
+	Error string `json:"error" yaml:"error"`
+}
+
+/*
+RecoveryReport - The outcome of the startup recovery scan of the transform log.
+*/
+type RecoveryReport struct {
+	Started   time.Time         `json:"started" yaml:"started"`
+	Recovered []string          `json:"recovered" yaml:"recovered"`
+	Failed    []RecoveryFailure `json:"failed" yaml:"failed"`
+}
+
+/*
+recoverTransformLogs - Scans the transform log for documents with transforms that were never
+flushed, which means the process died before their binder could flush them. Each is replayed onto
+the stored document, flushed, and its log cleared. Transforms that a checkpoint shows were already
+flushed, with the process dying before the log was cleared, are skipped.
+*/
+func recoverTransformLogs(
+	documents store.Store,
+	transforms TransformStore,
+	logger *log.Logger,
+	stats *log.Stats,
+) RecoveryReport {
+	report := RecoveryReport{
+		Started:   time.Now(),
+		Recovered: []string{},
+		Failed:    []RecoveryFailure{},
+	}
+
+	lister, ok := transforms.(TransformLister)
+	if !ok {
+		return report
+	}
+	ids, err := lister.List()
+	if err != nil {
+		logger.Errorf("Failed to list transform logs: %v\n", err)
+		stats.Incr("curator.recovery.error", 1)
+		report.Failed = append(report.Failed, RecoveryFailure{Error: err.Error()})
+		return report
+	}
+
+	fail := func(id string, err error) {
+		logger.Errorf("Failed to recover document %v: %v\n", id, err)
+		stats.Incr("curator.recovery.error", 1)
+		report.Failed = append(report.Failed, RecoveryFailure{ID: id, Error: err.Error()})
+	}
+
+	for _, id := range ids {
+		pending, err := transforms.Read(id)
+		if err != nil {
+			fail(id, err)
+			continue
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		doc, err := documents.Read(id)
+		if err != nil {
+			fail(id, err)
+			continue
+		}
+		checkpoints, err := transforms.ReadCheckpoints(id)
+		if err != nil {
+			fail(id, err)
+			continue
+		}
+		if pending = unflushedTransforms(doc.Content, pending, checkpoints); len(pending) > 0 {
+			if doc.Content, err = replayTransforms(doc.Type, doc.Content, pending); err != nil {
+				fail(id, err)
+				continue
+			}
+			checkpoint := TransformCheckpoint{Version: pending[len(pending)-1].Version, Digest: contentDigest(doc.Content)}
+			if err = transforms.Checkpoint(id, checkpoint); err != nil {
+				fail(id, err)
+				continue
+			}
+			if err = documents.Update(doc); err != nil {
+				fail(id, err)
+				continue
+			}
+		}
+		if err = transforms.Clear(id); err != nil {
+			fail(id, err)
+			continue
+		}
+		logger.Infof("Recovered %v unflushed transforms for %v\n", len(pending), id)
+		stats.Incr("curator.recovery.success", 1)
+		report.Recovered = append(report.Recovered, id)
+	}
+	return report
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
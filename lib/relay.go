@@ -0,0 +1,371 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RelayRedisConfig - Holds configuration options specific to the redis pub/sub relay.
+*/
+type RelayRedisConfig struct {
+	URL       string            `json:"url" yaml:"url"`
+	Password  string            `json:"password" yaml:"password"`
+	KeyPrefix string            `json:"key_prefix" yaml:"key_prefix"`
+	Outbound  util.ClientConfig `json:"outbound" yaml:"outbound"`
+}
+
+/*
+RelayConfig - Holds configuration options for the relay that synchronises the binders of a document
+across the nodes of a cluster. The default type of none runs a single node. NodeID identifies this
+node within the cluster and is generated when empty. LeaseTTL is how long in milliseconds the node
+leading a document holds its lease without renewing it, which bounds how long a document is stuck
+after its leader disappears. When StaleLeaseTimeout is set a lease whose holder has stopped
+answering, and has not flushed the document for that many milliseconds, is broken automatically.
+When UpgradeCompatibility is set, as it is by default, nodes interoperate with peers speaking a
+RelayProtocol one version older or newer than their own so that a cluster can be upgraded one node
+at a time, otherwise only peers of the same protocol are accepted.
+
+Compression may be set to 'snappy' to compress relayed messages of at least CompressionThreshold
+bytes, such as the transforms of large pastes and the snapshots of documents. Nodes decompress
+messages regardless of their own setting, but nodes predating compression can't, and so it should
+only be enabled once every node of a cluster has been upgraded.
+*/
+type RelayConfig struct {
+	Type        string           `json:"type" yaml:"type"`
+	NodeID      string           `json:"node_id" yaml:"node_id"`
+	LeaseTTL    int64            `json:"lease_ttl_ms" yaml:"lease_ttl_ms"`
+	RedisConfig RelayRedisConfig `json:"redis" yaml:"redis"`
+
+	StaleLeaseTimeout    int64 `json:"stale_lease_timeout_ms" yaml:"stale_lease_timeout_ms"`
+	UpgradeCompatibility bool  `json:"upgrade_compatibility" yaml:"upgrade_compatibility"`
+
+	Compression          string `json:"compression" yaml:"compression"`
+	CompressionThreshold int    `json:"compression_threshold_bytes" yaml:"compression_threshold_bytes"`
+}
+
+/*
+DefaultRelayConfig - Returns a default relay configuration, which is disabled.
+*/
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		Type:     "none",
+		NodeID:   "",
+		LeaseTTL: 6000,
+		RedisConfig: RelayRedisConfig{
+			URL:       ":6379",
+			Password:  "",
+			KeyPrefix: "leaps:cluster:",
+			Outbound:  util.NewClientConfig(),
+		},
+		StaleLeaseTimeout:    0,
+		UpgradeCompatibility: true,
+		Compression:          "none",
+		CompressionThreshold: 1024,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Relay type.
+var (
+	ErrInvalidRelayType        = errors.New("invalid relay type")
+	ErrInvalidRelayCompression = errors.New("invalid relay compression, must be none or snappy")
+)
+
+// Kinds of the messages relayed between the binders of a document.
+const (
+	relaySubmit    = "submit"
+	relayTransform = "transform"
+	relayError     = "error"
+	relayMessage   = "message"
+	relaySync      = "sync"
+	relaySnapshot  = "snapshot"
+	relayAlive     = "alive"
+	relayClosed    = "closed"
+	relayRefused   = "refused"
+)
+
+/*
+RelayMessage - A message exchanged between the binders of a document on different nodes. Node is the
+node that sent the message, and Protocol the RelayProtocol it speaks. To is set on messages meant for
+a single node. Origin and Seq identify a transform submitted through a follower, so that the follower
+can respond to its client once the leader has applied it.
+*/
+type RelayMessage struct {
+	Kind      string            `json:"kind"`
+	Node      string            `json:"node"`
+	Protocol  int               `json:"protocol,omitempty"`
+	To        string            `json:"to,omitempty"`
+	Origin    string            `json:"origin,omitempty"`
+	Seq       int               `json:"seq,omitempty"`
+	Token     string            `json:"token,omitempty"`
+	Transform *OTransform       `json:"transform,omitempty"`
+	Message   *ClientMessage    `json:"message,omitempty"`
+	Content   string            `json:"content,omitempty"`
+	Type      string            `json:"type,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Version   int               `json:"version,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+/*
+Relay - Implemented by types able to carry messages between the binders of a document on different
+nodes, and to grant a single node at a time the lease over a document. The node holding the lease
+leads the document, which means it alone applies transforms and flushes them to the store, whilst
+the binders of other nodes follow it. Messages published for a document must be delivered to every
+subscriber of the document in the order they were published, including the publisher.
+
+Each lease granted over a document carries a fencing token greater than that of any lease granted
+over it before, which the leader writes to the store with its flushes so that a node that lost its
+lease without noticing cannot overwrite the flushes of the node now leading the document.
+*/
+type Relay interface {
+	// Node - Returns the ID of this node within the cluster.
+	Node() string
+	// LeaseTTL - Returns how long a lease is held for without being renewed.
+	LeaseTTL() time.Duration
+	// Lead - Take the lease over a document, returns false if another node holds it.
+	Lead(id string) (bool, error)
+	// Renew - Extend our lease over a document, returns false if we no longer hold it.
+	Renew(id string) (bool, error)
+	// Leader - Returns the node holding the lease over a document, or an empty string if none does.
+	Leader(id string) (string, error)
+	// Release - Give up our lease over a document.
+	Release(id string) error
+	// FencingToken - Returns the fencing token of our lease over a document, or an error if not held.
+	FencingToken(id string) (uint64, error)
+	// Break - Remove the lease over a document held by another node, provided it still holds it.
+	Break(id, node string) (bool, error)
+	// Subscribe - Receive the messages of a document until the returned function is called.
+	Subscribe(id string) (<-chan RelayMessage, func(), error)
+	// Publish - Send a message to all subscribers of a document.
+	Publish(id string, msg RelayMessage) error
+}
+
+/*
+RelayFactory - Returns a relay based on a configuration object, or nil if the relay is disabled.
+*/
+func RelayFactory(config RelayConfig) (Relay, error) {
+	if len(config.NodeID) == 0 {
+		config.NodeID = util.GenerateStampedUUID()
+	}
+	switch config.Compression {
+	case "none", "", "snappy":
+	default:
+		return nil, ErrInvalidRelayCompression
+	}
+	switch config.Type {
+	case "none", "":
+		return nil, nil
+	case "redis":
+		return NewRedisRelay(config), nil
+	}
+	return nil, ErrInvalidRelayType
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+memoryRelayHub - The leases and subscribers shared by the nodes of a memory relay.
+*/
+type memoryRelayHub struct {
+	leases      map[string]string
+	fences      map[string]uint64
+	subscribers map[string][]chan RelayMessage
+	mutex       sync.Mutex
+}
+
+/*
+MemoryRelay - Relays messages between nodes within the same process, each node being a MemoryRelay
+created with NewNode. Mostly useful for testing.
+*/
+type MemoryRelay struct {
+	node string
+	hub  *memoryRelayHub
+}
+
+/*
+NewMemoryRelay - Returns the first node of a fresh memory relay.
+*/
+func NewMemoryRelay() *MemoryRelay {
+	return &MemoryRelay{
+		node: util.GenerateStampedUUID(),
+		hub: &memoryRelayHub{
+			leases:      map[string]string{},
+			fences:      map[string]uint64{},
+			subscribers: map[string][]chan RelayMessage{},
+		},
+	}
+}
+
+/*
+NewNode - Returns another node of the same memory relay.
+*/
+func (m *MemoryRelay) NewNode() *MemoryRelay {
+	return &MemoryRelay{node: util.GenerateStampedUUID(), hub: m.hub}
+}
+
+/*
+Node - Returns the ID of this node.
+*/
+func (m *MemoryRelay) Node() string {
+	return m.node
+}
+
+/*
+LeaseTTL - Leases of a memory relay never expire, but are checked as often as those of other relays.
+*/
+func (m *MemoryRelay) LeaseTTL() time.Duration {
+	return time.Second
+}
+
+/*
+Lead - Take the lease over a document if it is free.
+*/
+func (m *MemoryRelay) Lead(id string) (bool, error) {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	if leader, ok := m.hub.leases[id]; ok {
+		return leader == m.node, nil
+	}
+	m.hub.leases[id] = m.node
+	m.hub.fences[id]++
+	return true, nil
+}
+
+/*
+Renew - Returns whether we still hold the lease over a document.
+*/
+func (m *MemoryRelay) Renew(id string) (bool, error) {
+	leader, err := m.Leader(id)
+	return leader == m.node, err
+}
+
+/*
+Leader - Returns the node holding the lease over a document.
+*/
+func (m *MemoryRelay) Leader(id string) (string, error) {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+	return m.hub.leases[id], nil
+}
+
+/*
+Release - Give up our lease over a document.
+*/
+func (m *MemoryRelay) Release(id string) error {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	if m.hub.leases[id] == m.node {
+		delete(m.hub.leases, id)
+	}
+	return nil
+}
+
+/*
+FencingToken - Returns the fencing token of our lease over a document.
+*/
+func (m *MemoryRelay) FencingToken(id string) (uint64, error) {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	if m.hub.leases[id] != m.node {
+		return 0, ErrRelayLeaseLost
+	}
+	return m.hub.fences[id], nil
+}
+
+/*
+Break - Remove the lease over a document, provided it is still held by a node.
+*/
+func (m *MemoryRelay) Break(id, node string) (bool, error) {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	if leader, ok := m.hub.leases[id]; !ok || leader != node {
+		return false, nil
+	}
+	delete(m.hub.leases, id)
+	return true, nil
+}
+
+/*
+Subscribe - Receive the messages of a document until the returned function is called.
+*/
+func (m *MemoryRelay) Subscribe(id string) (<-chan RelayMessage, func(), error) {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	c := make(chan RelayMessage, 1024)
+	m.hub.subscribers[id] = append(m.hub.subscribers[id], c)
+
+	unsubscribe := func() {
+		m.hub.mutex.Lock()
+		defer m.hub.mutex.Unlock()
+
+		subscribers := m.hub.subscribers[id]
+		for i, s := range subscribers {
+			if s == c {
+				m.hub.subscribers[id] = append(subscribers[:i:i], subscribers[i+1:]...)
+				break
+			}
+		}
+		if len(m.hub.subscribers[id]) == 0 {
+			delete(m.hub.subscribers, id)
+		}
+	}
+	return c, unsubscribe, nil
+}
+
+/*
+Publish - Send a message to all subscribers of a document, subscribers that are full miss the
+message.
+*/
+func (m *MemoryRelay) Publish(id string, msg RelayMessage) error {
+	m.hub.mutex.Lock()
+	defer m.hub.mutex.Unlock()
+
+	for _, s := range m.hub.subscribers[id] {
+		select {
+		case s <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,193 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RelayProtocol - The version of the protocol spoken between the binders of a document on different
+nodes, which is bumped whenever the messages they exchange change. Nodes interoperate with peers of
+an adjacent protocol during a rolling upgrade, and so a change must remain understood by nodes one
+version either side of it, branching on the Protocol of a RelayMessage where necessary.
+*/
+const RelayProtocol = 2
+
+/*
+relayLegacyProtocol - The protocol of nodes that predate the version gate, whose messages carry no
+protocol at all.
+*/
+const relayLegacyProtocol = 1
+
+// Errors for the protocol version gate of a Relay.
+var (
+	ErrRelayIncompatible = errors.New("node speaks an incompatible cluster protocol")
+)
+
+/*
+relayProtocol - Returns the protocol of the node that sent a message.
+*/
+func relayProtocol(msg RelayMessage) int {
+	if msg.Protocol == 0 {
+		return relayLegacyProtocol
+	}
+	return msg.Protocol
+}
+
+/*
+relayCompatible - Returns whether we interoperate with a node of a protocol, which must match our own
+unless adjacent protocols are allowed.
+*/
+func relayCompatible(protocol int, adjacent bool) bool {
+	if protocol == RelayProtocol {
+		return true
+	}
+	return adjacent && protocol >= RelayProtocol-1 && protocol <= RelayProtocol+1
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RelayPeer - A node recently heard from over the relay, the protocol it speaks and whether we
+interoperate with it.
+*/
+type RelayPeer struct {
+	Node       string    `json:"node"`
+	Protocol   int       `json:"protocol"`
+	Compatible bool      `json:"compatible"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+/*
+ClusterStatus - The protocol of this node and of the peers it has recently heard from. Mixed is set
+whilst any peer speaks a different protocol, which is expected only during a rolling upgrade.
+*/
+type ClusterStatus struct {
+	Node     string      `json:"node"`
+	Protocol int         `json:"protocol"`
+	Mixed    bool        `json:"mixed"`
+	Peers    []RelayPeer `json:"peers"`
+}
+
+/*
+relayObserver - Implemented by relays that vet the protocol of the nodes messages are received from.
+*/
+type relayObserver interface {
+	// observe - Record the node that sent a message, returns whether we interoperate with it.
+	observe(msg RelayMessage) bool
+}
+
+/*
+trackedRelay - Wraps the relay of a curator in order to keep track of the protocols spoken by the
+nodes its binders hear from. Peers are forgotten once they have not been heard from for peerExpiry
+lease periods.
+*/
+type trackedRelay struct {
+	Relay
+	adjacent bool
+
+	peers map[string]RelayPeer
+	mutex sync.Mutex
+}
+
+const peerExpiry = 10
+
+/*
+newTrackedRelay - Wrap a relay in order to track its peers.
+*/
+func newTrackedRelay(relay Relay, adjacent bool) *trackedRelay {
+	return &trackedRelay{
+		Relay:    relay,
+		adjacent: adjacent,
+		peers:    map[string]RelayPeer{},
+	}
+}
+
+/*
+trackRelay - Wrap a relay in order to track its peers, a nil relay remains nil.
+*/
+func trackRelay(relay Relay, adjacent bool) Relay {
+	if relay == nil {
+		return nil
+	}
+	return newTrackedRelay(relay, adjacent)
+}
+
+/*
+observe - Record the node that sent a message, returns whether we interoperate with it.
+*/
+func (t *trackedRelay) observe(msg RelayMessage) bool {
+	protocol := relayProtocol(msg)
+	compatible := relayCompatible(protocol, t.adjacent)
+
+	t.mutex.Lock()
+	t.peers[msg.Node] = RelayPeer{
+		Node:       msg.Node,
+		Protocol:   protocol,
+		Compatible: compatible,
+		LastSeen:   time.Now(),
+	}
+	t.mutex.Unlock()
+	return compatible
+}
+
+/*
+status - Returns the peers heard from recently, ordered by node.
+*/
+func (t *trackedRelay) status() ClusterStatus {
+	status := ClusterStatus{
+		Node:     t.Node(),
+		Protocol: RelayProtocol,
+		Peers:    []RelayPeer{},
+	}
+	expired := time.Now().Add(-peerExpiry * t.LeaseTTL())
+
+	t.mutex.Lock()
+	for node, peer := range t.peers {
+		if peer.LastSeen.Before(expired) {
+			delete(t.peers, node)
+			continue
+		}
+		status.Peers = append(status.Peers, peer)
+		if peer.Protocol != RelayProtocol {
+			status.Mixed = true
+		}
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].Node < status.Peers[j].Node
+	})
+	return status
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
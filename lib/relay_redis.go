@@ -0,0 +1,343 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/golang/snappy"
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Takes a lease if it is free, and returns the fencing token of the new lease or 0 if not taken.
+var redisLeadScript = redis.NewScript(2, `
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0
+`)
+
+// Renews a lease only if it is still held by the node given.
+var redisRenewScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// Releases or breaks a lease only if it is still held by the node given.
+var redisReleaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+/*
+RedisRelay - Relays messages between nodes through a redis pub/sub channel per document, and holds
+the lease over a document as a key that expires unless renewed. The fencing tokens of leases are
+drawn from a counter kept per document, which outlives the leases themselves.
+*/
+type RedisRelay struct {
+	config RelayConfig
+	pool   *redis.Pool
+
+	// The fencing tokens of the leases we hold
+	fences     map[string]uint64
+	fenceMutex sync.Mutex
+}
+
+/*
+NewRedisRelay - Returns a RedisRelay using the provided configuration.
+*/
+func NewRedisRelay(config RelayConfig) *RedisRelay {
+	redisConfig := config.RedisConfig
+	return &RedisRelay{
+		config: config,
+		fences: map[string]uint64{},
+		pool: &redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				dial, err := util.NewDialer(redisConfig.Outbound)
+				if err != nil {
+					return nil, err
+				}
+				tlsConfig, err := util.NewClientTLS(redisConfig.Outbound)
+				if err != nil {
+					return nil, err
+				}
+				c, err := redis.Dial("tcp", redisConfig.URL,
+					redis.DialNetDial(dial),
+					redis.DialUseTLS(redisConfig.Outbound.TLS.Enabled),
+					redis.DialTLSConfig(tlsConfig),
+				)
+				if err != nil {
+					return nil, err
+				}
+				if 0 != len(redisConfig.Password) {
+					if _, err := c.Do("AUTH", redisConfig.Password); err != nil {
+						c.Close()
+						return nil, err
+					}
+				}
+				return c, err
+			},
+		},
+	}
+}
+
+func (r *RedisRelay) leaseKey(id string) string {
+	return r.config.RedisConfig.KeyPrefix + "lease:" + id
+}
+
+func (r *RedisRelay) fenceKey(id string) string {
+	return r.config.RedisConfig.KeyPrefix + "fence:" + id
+}
+
+func (r *RedisRelay) channel(id string) string {
+	return r.config.RedisConfig.KeyPrefix + "doc:" + id
+}
+
+/*
+Node - Returns the ID of this node.
+*/
+func (r *RedisRelay) Node() string {
+	return r.config.NodeID
+}
+
+/*
+LeaseTTL - Returns how long a lease is held for without being renewed.
+*/
+func (r *RedisRelay) LeaseTTL() time.Duration {
+	return time.Duration(r.config.LeaseTTL) * time.Millisecond
+}
+
+/*
+Lead - Take the lease over a document if no other node holds it. A lease we already hold, such as
+one taken by this node before a restart, is granted a new fencing token.
+*/
+func (r *RedisRelay) Lead(id string) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	token, err := redis.Uint64(redisLeadScript.Do(conn, r.leaseKey(id), r.fenceKey(id), r.config.NodeID, r.config.LeaseTTL))
+	if err != nil {
+		return false, err
+	}
+	if token == 0 {
+		leader, err := r.Leader(id)
+		if err != nil || leader != r.config.NodeID {
+			return false, err
+		}
+		if token, err = redis.Uint64(conn.Do("INCR", r.fenceKey(id))); err != nil {
+			return false, err
+		}
+	}
+	r.fenceMutex.Lock()
+	r.fences[id] = token
+	r.fenceMutex.Unlock()
+	return true, nil
+}
+
+/*
+Renew - Extend our lease over a document, returns false if we no longer hold it.
+*/
+func (r *RedisRelay) Renew(id string) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	renewed, err := redis.Int(redisRenewScript.Do(conn, r.leaseKey(id), r.config.NodeID, r.config.LeaseTTL))
+	return renewed == 1, err
+}
+
+/*
+Leader - Returns the node holding the lease over a document.
+*/
+func (r *RedisRelay) Leader(id string) (string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	leader, err := redis.String(conn.Do("GET", r.leaseKey(id)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return leader, err
+}
+
+/*
+Release - Give up our lease over a document.
+*/
+func (r *RedisRelay) Release(id string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	r.fenceMutex.Lock()
+	delete(r.fences, id)
+	r.fenceMutex.Unlock()
+
+	_, err := redisReleaseScript.Do(conn, r.leaseKey(id), r.config.NodeID)
+	return err
+}
+
+/*
+FencingToken - Returns the fencing token of the lease we took over a document.
+*/
+func (r *RedisRelay) FencingToken(id string) (uint64, error) {
+	r.fenceMutex.Lock()
+	defer r.fenceMutex.Unlock()
+
+	token, ok := r.fences[id]
+	if !ok {
+		return 0, ErrRelayLeaseLost
+	}
+	return token, nil
+}
+
+/*
+Break - Remove the lease over a document, provided it is still held by a node.
+*/
+func (r *RedisRelay) Break(id, node string) (bool, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	broken, err := redis.Int(redisReleaseScript.Do(conn, r.leaseKey(id), node))
+	return broken == 1, err
+}
+
+/*
+Subscribe - Receive the messages of a document over a dedicated connection, the returned channel is
+closed if the connection is lost.
+*/
+func (r *RedisRelay) Subscribe(id string) (<-chan RelayMessage, func(), error) {
+	conn, err := r.pool.Dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	if err = psc.Subscribe(r.channel(id)); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	// Wait for the subscription to be confirmed so that nothing published afterwards is missed.
+	switch v := psc.Receive().(type) {
+	case redis.Subscription:
+	case error:
+		conn.Close()
+		return nil, nil, v
+	}
+
+	messages := make(chan RelayMessage, 100)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(messages)
+		for {
+			switch v := psc.Receive().(type) {
+			case redis.Message:
+				msg, err := decodeRelayMessage(v.Data)
+				if err != nil {
+					continue
+				}
+				select {
+				case messages <- msg:
+				case <-done:
+					return
+				}
+			case error:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			close(done)
+			conn.Close()
+		})
+	}
+	return messages, unsubscribe, nil
+}
+
+/*
+Publish - Send a message to all subscribers of a document.
+*/
+func (r *RedisRelay) Publish(id string, msg RelayMessage) error {
+	bytes, err := encodeRelayMessage(msg, r.config)
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("PUBLISH", r.channel(id), bytes)
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Relayed messages are JSON objects, and so compressed messages are told apart by a leading byte that
+can't begin one.
+*/
+const relaySnappyMarker = 's'
+
+/*
+encodeRelayMessage - Returns the encoding of a message to relay, compressed if the config asks for it
+and the message is large enough.
+*/
+func encodeRelayMessage(msg RelayMessage, config RelayConfig) ([]byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if config.Compression != "snappy" || len(data) < config.CompressionThreshold {
+		return data, nil
+	}
+	return append([]byte{relaySnappyMarker}, snappy.Encode(nil, data)...), nil
+}
+
+/*
+decodeRelayMessage - Returns the message of a relayed encoding, which may be compressed.
+*/
+func decodeRelayMessage(data []byte) (RelayMessage, error) {
+	var msg RelayMessage
+	if len(data) > 0 && data[0] == relaySnappyMarker {
+		var err error
+		if data, err = snappy.Decode(nil, data[1:]); err != nil {
+			return msg, err
+		}
+	}
+	err := json.Unmarshal(data, &msg)
+	return msg, err
+}
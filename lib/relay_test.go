@@ -0,0 +1,363 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestMemoryRelayLeases(t *testing.T) {
+	first := NewMemoryRelay()
+	second := first.NewNode()
+
+	if leading, err := first.Lead("doc"); err != nil || !leading {
+		t.Fatalf("First node failed to lead: %v, %v", leading, err)
+	}
+	if leading, err := second.Lead("doc"); err != nil || leading {
+		t.Fatalf("Second node took a held lease: %v, %v", leading, err)
+	}
+	if leader, _ := second.Leader("doc"); leader != first.Node() {
+		t.Errorf("Wrong leader: %v != %v", leader, first.Node())
+	}
+	if renewed, _ := second.Renew("doc"); renewed {
+		t.Error("Second node renewed a lease it does not hold")
+	}
+	if err := second.Release("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if renewed, _ := first.Renew("doc"); !renewed {
+		t.Error("Lease was released by a node that does not hold it")
+	}
+	if err := first.Release("doc"); err != nil {
+		t.Fatal(err)
+	}
+	if leading, _ := second.Lead("doc"); !leading {
+		t.Error("Second node failed to lead a released document")
+	}
+
+	// Each lease granted carries a greater fencing token
+	if token, err := second.FencingToken("doc"); err != nil || token != 2 {
+		t.Errorf("Wrong fencing token: %v, %v", token, err)
+	}
+	if _, err := first.FencingToken("doc"); err != ErrRelayLeaseLost {
+		t.Errorf("Wrong error for fencing token of a lost lease: %v", err)
+	}
+}
+
+func TestMemoryRelayPublish(t *testing.T) {
+	first := NewMemoryRelay()
+	second := first.NewNode()
+
+	firstMsgs, firstStop, _ := first.Subscribe("doc")
+	secondMsgs, secondStop, _ := second.Subscribe("doc")
+	defer secondStop()
+
+	if err := first.Publish("doc", RelayMessage{Kind: relayAlive, Node: first.Node()}); err != nil {
+		t.Fatal(err)
+	}
+	for _, msgs := range []<-chan RelayMessage{firstMsgs, secondMsgs} {
+		select {
+		case msg := <-msgs:
+			if msg.Node != first.Node() {
+				t.Errorf("Wrong sender: %v", msg.Node)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for message")
+		}
+	}
+
+	firstStop()
+	second.Publish("doc", RelayMessage{Kind: relayAlive, Node: second.Node()})
+	select {
+	case msg := <-firstMsgs:
+		t.Errorf("Unsubscribed node received message: %v", msg)
+	default:
+	}
+}
+
+func TestRelayCompression(t *testing.T) {
+	config := DefaultRelayConfig()
+	msg := RelayMessage{Kind: relaySync, Node: "a", Content: strings.Repeat("large ", 1000)}
+
+	plain, err := encodeRelayMessage(msg, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Compression = "snappy"
+	compressed, err := encodeRelayMessage(msg, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed[0] != relaySnappyMarker || len(compressed) >= len(plain) {
+		t.Errorf("Message was not compressed: %v >= %v", len(compressed), len(plain))
+	}
+	small, _ := encodeRelayMessage(RelayMessage{Kind: relayAlive, Node: "a"}, config)
+	if small[0] != '{' {
+		t.Errorf("Small message was compressed: %s", small)
+	}
+
+	for _, data := range [][]byte{plain, compressed} {
+		if decoded, err := decodeRelayMessage(data); err != nil || decoded.Content != msg.Content {
+			t.Errorf("Wrong decoded message: %v", err)
+		}
+	}
+
+	config.Compression = "lz4"
+	if _, err := RelayFactory(config); err != ErrInvalidRelayCompression {
+		t.Errorf("Wrong error for unknown compression: %v", err)
+	}
+}
+
+func TestBinderRelay(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	relay := NewMemoryRelay()
+
+	leader, err := newBinder(doc.ID, docStore, nil, relay, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaderPortal := leader.Subscribe("leader")
+	if _, err = leaderPortal.SendTransform(OTransform{Insert: "a", Version: leaderPortal.Version + 1}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	follower, err := newBinder(doc.ID, docStore, nil, relay.NewNode(), nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	followerPortal := follower.Subscribe("follower")
+	if exp, act := "ahello world", followerPortal.Document.Content; exp != act {
+		t.Errorf("Follower did not sync: %v != %v", exp, act)
+	}
+
+	// Transforms submitted to the follower are applied by the leader and relayed back.
+	version, err := followerPortal.SendTransform(OTransform{Insert: "b", Version: followerPortal.Version + 1}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := followerPortal.Version + 1; version != exp {
+		t.Errorf("Wrong follower version: %v != %v", version, exp)
+	}
+	select {
+	case ot := <-leaderPortal.TransformRcvChan:
+		if ot.Insert != "b" || ot.Version != leaderPortal.Version+2 {
+			t.Errorf("Wrong transform at leader: %v", ot)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for transform at leader")
+	}
+
+	// Transforms submitted to the leader reach the clients of the follower at follower versions.
+	if _, err = leaderPortal.SendTransform(OTransform{Insert: "c", Version: leaderPortal.Version + 3}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ot := <-followerPortal.TransformRcvChan:
+		if ot.Insert != "c" || ot.Version != followerPortal.Version+2 {
+			t.Errorf("Wrong transform at follower: %v", ot)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for transform at follower")
+	}
+
+	leader.Close()
+	if stored, _ := docStore.Read(doc.ID); stored.Content != "cbahello world" {
+		t.Errorf("Wrong stored content: %v", stored.Content)
+	}
+	select {
+	case binderErr := <-errChan:
+		if binderErr.Err != ErrRelayLeaderLost {
+			t.Errorf("Wrong follower error: %v", binderErr.Err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for follower to shut down")
+	}
+	if node, _ := relay.Leader(doc.ID); node != "" {
+		t.Errorf("Lease was not released: %v", node)
+	}
+}
+
+func TestBinderRelayFencing(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 60000
+
+	memStore, _ := store.GetMemoryStore(store.NewConfig())
+	memStore.Create(*doc)
+	relay := NewMemoryRelay()
+
+	leader, err := newBinder(doc.ID, fencedStore{Store: memStore, source: relay}, nil, relay, nil, nil, config, nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	leaderPortal := leader.Subscribe("leader")
+	if _, err = leaderPortal.SendTransform(OTransform{Insert: "a", Version: leaderPortal.Version + 1}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// Followers are sent the current content without the leader flushing it
+	follower, err := newBinder(doc.ID, memStore, nil, relay.NewNode(), nil, nil, config, nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer follower.Close()
+
+	if exp, act := "ahello world", follower.Subscribe("follower").Document.Content; exp != act {
+		t.Errorf("Follower did not sync: %v != %v", exp, act)
+	}
+	if stored, _ := memStore.Read(doc.ID); stored.Content != "hello world" {
+		t.Errorf("Snapshot flushed the document: %v", stored.Content)
+	}
+
+	// Another node breaks the lease and flushes, after which the flushes of the old leader must fail
+	usurper := relay.NewNode()
+	if broken, _ := usurper.Break(doc.ID, relay.Node()); !broken {
+		t.Fatal("Failed to break lease")
+	}
+	if leading, _ := usurper.Lead(doc.ID); !leading {
+		t.Fatal("Failed to take lease")
+	}
+	if err = (fencedStore{Store: memStore, source: usurper}).Update(store.Document{ID: doc.ID, Content: "taken over"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = leader.Snapshot(time.Second); err == nil {
+		t.Error("Expected flush of old leader to fail")
+	}
+	if stored, _ := memStore.Read(doc.ID); stored.Content != "taken over" {
+		t.Errorf("Stale flush was written: %v", stored.Content)
+	}
+}
+
+func TestRelayProtocolGate(t *testing.T) {
+	for _, test := range []struct {
+		protocol   int
+		adjacent   bool
+		compatible bool
+	}{
+		{RelayProtocol, false, true},
+		{RelayProtocol - 1, true, true},
+		{RelayProtocol + 1, true, true},
+		{RelayProtocol + 1, false, false},
+		{RelayProtocol + 2, true, false},
+	} {
+		if act := relayCompatible(test.protocol, test.adjacent); act != test.compatible {
+			t.Errorf("Wrong compatibility of %v with adjacent %v: %v", test.protocol, test.adjacent, act)
+		}
+	}
+	if relayProtocol(RelayMessage{}) != relayLegacyProtocol {
+		t.Error("Messages without a protocol should be of the legacy protocol")
+	}
+
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	memory := NewMemoryRelay()
+	relay := newTrackedRelay(memory, true)
+
+	leader, err := newBinder(doc.ID, docStore, nil, relay, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer leader.Close()
+
+	peer := memory.NewNode()
+	messages, unsubscribe, err := peer.Subscribe(doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	// A node of a legacy protocol is adjacent and gets its snapshot, a far newer node is refused
+	peer.Publish(doc.ID, RelayMessage{Kind: relaySync, Node: "legacy", To: relay.Node()})
+	peer.Publish(doc.ID, RelayMessage{Kind: relaySync, Node: "future", To: relay.Node(), Protocol: RelayProtocol + 2})
+
+	replies := map[string]string{}
+	for len(replies) < 2 {
+		select {
+		case msg := <-messages:
+			if msg.Node == relay.Node() && len(msg.To) > 0 {
+				replies[msg.To] = msg.Kind
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for replies: %v", replies)
+		}
+	}
+	if replies["legacy"] != relaySnapshot || replies["future"] != relayRefused {
+		t.Errorf("Wrong replies: %v", replies)
+	}
+
+	status := relay.status()
+	if !status.Mixed || len(status.Peers) != 2 {
+		t.Fatalf("Wrong cluster status: %+v", status)
+	}
+	if peer := status.Peers[0]; peer.Node != "future" || peer.Protocol != RelayProtocol+2 || peer.Compatible {
+		t.Errorf("Wrong future peer: %+v", peer)
+	}
+	if peer := status.Peers[1]; peer.Node != "legacy" || peer.Protocol != relayLegacyProtocol || !peer.Compatible {
+		t.Errorf("Wrong legacy peer: %+v", peer)
+	}
+
+	// A strict node refuses to follow a leader of an adjacent protocol
+	other, _ := store.NewDocument("hello world")
+	docStore.Create(*other)
+	if _, err = peer.Lead(other.ID); err != nil {
+		t.Fatal(err)
+	}
+	otherMessages, otherUnsubscribe, err := peer.Subscribe(other.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer otherUnsubscribe()
+
+	strict := newTrackedRelay(memory.NewNode(), false)
+	go func() {
+		for msg := range otherMessages {
+			if msg.Kind == relaySync && msg.Node == strict.Node() {
+				peer.Publish(other.ID, RelayMessage{
+					Kind: relaySnapshot, Node: peer.Node(), To: msg.Node, Protocol: RelayProtocol - 1,
+				})
+				return
+			}
+		}
+	}()
+	if _, err = newBinder(other.ID, docStore, nil, strict, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats); err != ErrRelayIncompatible {
+		t.Errorf("Expected incompatible error: %v", err)
+	}
+}
@@ -0,0 +1,433 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/lib/util"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ReplicaConfig - Options for the read replicas of documents. When ViewersPerReplica is above zero the
+read only clients of a document are subscribed to replicas rather than to the binder, which keeps
+the subscriber list of the binder small for documents with large audiences. Viewers that block a
+send for longer than KickPeriod are kicked from their replica.
+*/
+type ReplicaConfig struct {
+	ViewersPerReplica int   `json:"viewers_per_replica" yaml:"viewers_per_replica"`
+	KickPeriod        int64 `json:"kick_period_ms" yaml:"kick_period_ms"`
+}
+
+/*
+NewReplicaConfig - Returns a default ReplicaConfig, replicas are disabled.
+*/
+func NewReplicaConfig() ReplicaConfig {
+	return ReplicaConfig{
+		ViewersPerReplica: 0,
+		KickPeriod:        50,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Replica type.
+var (
+	ErrReplicaClosed = errors.New("replica is closed")
+)
+
+/*
+Replica - Holds a single read only subscription to a document, through any BinderPortal, and fans
+the transforms and messages it receives out to its own read only viewers. The replica keeps its own
+copy of the document for subscribing viewers. Messages sent by viewers are dropped, spectators are
+not announced to the writers of a document, instead the replica reports its number of viewers to the
+source so that they are included in its count of spectators.
+
+A replica closes itself once its last viewer leaves, or when its source is closed.
+*/
+type Replica struct {
+	ID      string
+	config  ReplicaConfig
+	source  BinderPortal
+	doc     store.Document
+	version int
+	log     *log.Logger
+	stats   *log.Stats
+
+	// Presence, cursors and spectators of the source document, passed on to subscribing viewers
+	present    map[string]ClientMessage
+	cursors    map[string]ClientMessage
+	spectators int
+
+	viewers     map[string]BinderClient
+	viewerCount int32
+
+	// Set once the source has sent its shut down notice, after which new viewers are refused
+	draining bool
+
+	subscribeChan chan BinderSubscribeBundle
+	messageChan   chan MessageSubmission
+	exitChan      chan string
+	kickChan      chan string
+	closeChan     chan struct{}
+	closedChan    chan struct{}
+}
+
+/*
+NewReplica - Creates a replica from a read only portal to a document, and launches its internal
+loop. The replica takes ownership of the portal.
+*/
+func NewReplica(source BinderPortal, config ReplicaConfig, log *log.Logger, stats *log.Stats) *Replica {
+	replica := Replica{
+		ID:            source.Document.ID,
+		config:        config,
+		source:        source,
+		doc:           source.Document,
+		version:       source.Version,
+		spectators:    source.Spectators,
+		log:           log.NewModule(":replica"),
+		stats:         stats,
+		present:       map[string]ClientMessage{},
+		cursors:       map[string]ClientMessage{},
+		viewers:       map[string]BinderClient{},
+		subscribeChan: make(chan BinderSubscribeBundle),
+		messageChan:   make(chan MessageSubmission),
+		exitChan:      make(chan string),
+		kickChan:      make(chan string),
+		closeChan:     make(chan struct{}),
+		closedChan:    make(chan struct{}),
+	}
+	for _, msg := range source.Present {
+		replica.present[msg.UserID] = msg
+	}
+	for _, msg := range source.Cursors {
+		replica.cursors[msg.UserID] = msg
+	}
+	go replica.loop()
+
+	stats.Incr("replica.new", 1)
+	return &replica
+}
+
+/*
+Subscribe - Returns a read only BinderPortal to the replica. If the subscription was unsuccessful
+the BinderPortal will contain an error.
+*/
+func (r *Replica) Subscribe(token string) BinderPortal {
+	return r.SubscribeWith(BinderSubscribeBundle{Token: token})
+}
+
+/*
+SubscribeWith - Returns a read only BinderPortal to the replica for a subscription described by a
+bundle, of which only the token and user ID are used. If the subscription was unsuccessful the
+BinderPortal will contain an error.
+*/
+func (r *Replica) SubscribeWith(bundle BinderSubscribeBundle) BinderPortal {
+	if len(bundle.Token) == 0 {
+		bundle.Token = util.GenerateStampedUUID()
+	}
+	retChan := make(chan BinderPortal, 1)
+	request := BinderSubscribeBundle{
+		Token:         bundle.Token,
+		UserID:        bundle.UserID,
+		ReadOnly:      true,
+		PortalRcvChan: retChan,
+	}
+	select {
+	case r.subscribeChan <- request:
+	case <-r.closedChan:
+		return BinderPortal{Error: ErrReplicaClosed}
+	}
+	return <-retChan
+}
+
+/*
+Viewers - Returns the number of viewers currently subscribed to the replica.
+*/
+func (r *Replica) Viewers() int {
+	return int(atomic.LoadInt32(&r.viewerCount))
+}
+
+/*
+Closed - Returns whether the replica has closed.
+*/
+func (r *Replica) Closed() bool {
+	select {
+	case <-r.closedChan:
+		return true
+	default:
+	}
+	return false
+}
+
+/*
+Kick - Remove the viewers of a token or user ID from the replica, it is not an error for the viewers
+to be absent or for the replica to have closed.
+*/
+func (r *Replica) Kick(token string, timeout time.Duration) error {
+	select {
+	case r.kickChan <- token:
+	case <-r.closedChan:
+	case <-time.After(timeout):
+		return ErrTimeout
+	}
+	return nil
+}
+
+/*
+Close - Close the replica along with its viewers, and leave the source document.
+*/
+func (r *Replica) Close() {
+	select {
+	case r.closeChan <- struct{}{}:
+	case <-r.closedChan:
+	}
+	<-r.closedChan
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+processSubscriber - Enrol a viewer with the current copy of the document.
+*/
+func (r *Replica) processSubscriber(request BinderSubscribeBundle) {
+	if r.draining {
+		r.stats.Incr("replica.rejected_viewer", 1)
+		request.PortalRcvChan <- BinderPortal{Token: request.Token, Error: ErrBinderDraining}
+		return
+	}
+	clientID := util.GenerateStampedUUID()
+	userID := request.UserID
+	if len(userID) == 0 {
+		userID = clientID
+	}
+
+	transformSndChan := make(chan OTransform, 1)
+	messageSndChan := make(chan ClientMessage, 1)
+
+	portal := BinderPortal{
+		Token:            request.Token,
+		ClientID:         clientID,
+		UserID:           userID,
+		Document:         r.doc,
+		Version:          r.version,
+		Cursors:          []ClientMessage{},
+		Present:          []ClientMessage{},
+		Spectators:       r.spectators,
+		TransformRcvChan: transformSndChan,
+		MessageRcvChan:   messageSndChan,
+		MessageSndChan:   r.messageChan,
+		ExitChan:         r.exitChan,
+	}
+	for _, msg := range r.present {
+		portal.Present = append(portal.Present, msg)
+	}
+	for _, msg := range r.cursors {
+		portal.Cursors = append(portal.Cursors, msg)
+	}
+
+	// The portal channel is buffered and only ever written to once.
+	request.PortalRcvChan <- portal
+
+	r.viewers[clientID] = BinderClient{
+		Token:         request.Token,
+		UserID:        userID,
+		ReadOnly:      true,
+		TransformChan: transformSndChan,
+		MessageChan:   messageSndChan,
+	}
+	atomic.AddInt32(&r.viewerCount, 1)
+	r.stats.Incr("replica.subscribed_viewers", 1)
+}
+
+/*
+removeViewer - Remove a viewer by the ID of its portal and close its channels.
+*/
+func (r *Replica) removeViewer(clientID string) {
+	if c, ok := r.viewers[clientID]; ok {
+		delete(r.viewers, clientID)
+		close(c.TransformChan)
+		close(c.MessageChan)
+		atomic.AddInt32(&r.viewerCount, -1)
+		r.stats.Decr("replica.subscribed_viewers", 1)
+	}
+}
+
+/*
+kickBlockedViewer - Remove a viewer that failed to receive a send within the kick period.
+*/
+func (r *Replica) kickBlockedViewer(key, send string) {
+	r.stats.Incr("replica.viewers_kicked", 1)
+	r.log.Debugf("Kicking viewer (%v) for blocked %v send\n", key, send)
+	r.removeViewer(key)
+}
+
+/*
+processTransform - Apply a transform from the source to our copy of the document and send it out to
+all viewers.
+*/
+func (r *Replica) processTransform(ot OTransform) error {
+	content, err := replayTransforms(r.doc.Type, r.doc.Content, []OTransform{ot})
+	if err != nil {
+		return err
+	}
+	r.doc.Content, r.version = content, ot.Version
+
+	kickPeriod := time.Duration(r.config.KickPeriod) * time.Millisecond
+	for key, c := range r.viewers {
+		select {
+		case c.TransformChan <- ot:
+		case <-time.After(kickPeriod):
+			r.kickBlockedViewer(key, "transform")
+		}
+	}
+	return nil
+}
+
+/*
+processMessage - Track the presence and cursors of the source document and send a message out to all
+viewers.
+*/
+func (r *Replica) processMessage(msg ClientMessage) {
+	switch {
+	case msg.Presence == "leave":
+		delete(r.present, msg.UserID)
+		delete(r.cursors, msg.UserID)
+	case msg.Presence == "join":
+		r.present[msg.UserID] = msg
+	case msg.Position != nil:
+		r.cursors[msg.UserID] = msg
+	case msg.Spectators != nil:
+		r.spectators = *msg.Spectators
+	case msg.Shutdown:
+		r.draining = true
+	}
+
+	kickPeriod := time.Duration(r.config.KickPeriod) * time.Millisecond
+	for key, c := range r.viewers {
+		select {
+		case c.MessageChan <- msg:
+		case <-time.After(kickPeriod):
+			r.kickBlockedViewer(key, "message")
+		}
+	}
+}
+
+/*
+loop - The internal loop of the replica, which relays the source document to viewers until the
+source closes, the replica is closed, or the last viewer leaves.
+*/
+func (r *Replica) loop() {
+	sourceOpen := true
+	defer func() {
+		for key := range r.viewers {
+			r.removeViewer(key)
+		}
+		if sourceOpen {
+			r.source.Exit(time.Second)
+		}
+		close(r.closedChan)
+		r.stats.Incr("replica.closed", 1)
+	}()
+
+	// The number of viewers last reported to the source, a read only subscription counts as one.
+	reported := 1
+	for {
+		// Only attempt to report our viewers when the number has changed
+		var reportChan chan<- MessageSubmission
+		viewers := len(r.viewers)
+		if viewers != reported {
+			reportChan = r.source.MessageSndChan
+		}
+		report := MessageSubmission{
+			Token:    r.source.Token,
+			ClientID: r.source.ClientID,
+			Message:  ClientMessage{Spectators: &viewers},
+		}
+
+		select {
+		case reportChan <- report:
+			reported = viewers
+		case ot, open := <-r.source.TransformRcvChan:
+			if !open {
+				r.log.Infof("Source of replica %v closed, shutting down\n", r.ID)
+				sourceOpen = false
+				return
+			}
+			if err := r.processTransform(ot); err != nil {
+				r.stats.Incr("replica.transform.error", 1)
+				r.log.Errorf("Replica %v failed to apply transform: %v, shutting down\n", r.ID, err)
+				return
+			}
+		case ots := <-r.source.BatchRcvChan:
+			for _, ot := range ots {
+				if err := r.processTransform(ot); err != nil {
+					r.stats.Incr("replica.transform.error", 1)
+					r.log.Errorf("Replica %v failed to apply transform: %v, shutting down\n", r.ID, err)
+					return
+				}
+			}
+		case msg, open := <-r.source.MessageRcvChan:
+			if !open {
+				r.log.Infof("Source of replica %v closed, shutting down\n", r.ID)
+				sourceOpen = false
+				return
+			}
+			r.processMessage(msg)
+		case request := <-r.subscribeChan:
+			r.processSubscriber(request)
+		case <-r.messageChan:
+			r.stats.Incr("replica.viewer_message.dropped", 1)
+		case token := <-r.kickChan:
+			for key, c := range r.viewers {
+				if c.Token == token || c.UserID == token {
+					r.removeViewer(key)
+				}
+			}
+			if len(r.viewers) == 0 {
+				r.log.Debugf("Last viewer of replica %v was kicked, shutting down\n", r.ID)
+				return
+			}
+		case clientID := <-r.exitChan:
+			r.removeViewer(clientID)
+			if len(r.viewers) == 0 {
+				r.log.Debugf("Last viewer of replica %v left, shutting down\n", r.ID)
+				return
+			}
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,227 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+SchedulerConfig - Holds configuration options for a scheduler shared across namespaces.
+*/
+type SchedulerConfig struct {
+	MaxConcurrentFlushes int `json:"max_concurrent_flushes" yaml:"max_concurrent_flushes"`
+}
+
+/*
+DefaultSchedulerConfig - Returns a fully defined scheduler configuration with the default values for
+each field.
+*/
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		MaxConcurrentFlushes: 8,
+	}
+}
+
+/*
+NamespaceConfig - Holds the resource caps of a single namespace. A zero value for a field means that
+resource is not capped beyond the limits of the scheduler.
+*/
+type NamespaceConfig struct {
+	MaxConcurrentFlushes int   `json:"max_concurrent_flushes" yaml:"max_concurrent_flushes"`
+	MaxBroadcastBytes    int64 `json:"max_broadcast_bytes_per_s" yaml:"max_broadcast_bytes_per_s"`
+}
+
+/*
+DefaultNamespaceConfig - Returns a fully defined namespace configuration with the default values for
+each field.
+*/
+func DefaultNamespaceConfig() NamespaceConfig {
+	return NamespaceConfig{
+		MaxConcurrentFlushes: 2,
+		MaxBroadcastBytes:    0,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Scheduler - Shares a pool of flush slots between any number of namespaces. When the pool is
+exhausted waiting namespaces are served in round robin order, meaning a single namespace with many
+busy documents cannot starve the others.
+*/
+type Scheduler struct {
+	config     SchedulerConfig
+	active     int
+	namespaces []*Namespace
+	next       int
+	mutex      sync.Mutex
+}
+
+/*
+NewScheduler - Creates a new Scheduler.
+*/
+func NewScheduler(config SchedulerConfig) *Scheduler {
+	return &Scheduler{config: config}
+}
+
+/*
+NewNamespace - Create a namespace that draws from the resources of this scheduler, the namespace
+should be given to a curator with Curator.UseNamespace.
+*/
+func (s *Scheduler) NewNamespace(name string, config NamespaceConfig) *Namespace {
+	ns := &Namespace{
+		name:      name,
+		config:    config,
+		scheduler: s,
+	}
+	if config.MaxBroadcastBytes > 0 {
+		ns.bandwidth = newTokenBucket(float64(config.MaxBroadcastBytes), float64(config.MaxBroadcastBytes))
+	}
+
+	s.mutex.Lock()
+	s.namespaces = append(s.namespaces, ns)
+	s.mutex.Unlock()
+
+	return ns
+}
+
+/*
+hasCapacity - Whether the scheduler has free flush slots, must be called whilst locked.
+*/
+func (s *Scheduler) hasCapacity() bool {
+	return s.config.MaxConcurrentFlushes <= 0 || s.active < s.config.MaxConcurrentFlushes
+}
+
+/*
+dispatch - Hands free flush slots to waiting namespaces in round robin order, must be called whilst
+locked.
+*/
+func (s *Scheduler) dispatch() {
+	for granted := true; granted && s.hasCapacity(); {
+		granted = false
+		for i := 0; i < len(s.namespaces); i++ {
+			index := (s.next + i) % len(s.namespaces)
+			ns := s.namespaces[index]
+			if len(ns.waiting) > 0 && ns.hasCapacity() {
+				s.active++
+				ns.active++
+				close(ns.waiting[0])
+				ns.waiting = ns.waiting[1:]
+				s.next = (index + 1) % len(s.namespaces)
+				granted = true
+				break
+			}
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Namespace - A handle on the resources available to the documents of a single tenant. All methods
+are safe to call on a nil Namespace, in which case resources are unlimited.
+*/
+type Namespace struct {
+	name      string
+	config    NamespaceConfig
+	scheduler *Scheduler
+	active    int
+	waiting   []chan struct{}
+	bandwidth *tokenBucket
+}
+
+/*
+Name - Returns the name of the namespace.
+*/
+func (n *Namespace) Name() string {
+	if n == nil {
+		return ""
+	}
+	return n.name
+}
+
+/*
+hasCapacity - Whether the namespace is below its own flush cap, must be called whilst the scheduler
+is locked.
+*/
+func (n *Namespace) hasCapacity() bool {
+	return n.config.MaxConcurrentFlushes <= 0 || n.active < n.config.MaxConcurrentFlushes
+}
+
+/*
+acquireFlush - Blocks until the namespace is permitted to perform a flush.
+*/
+func (n *Namespace) acquireFlush() {
+	if n == nil {
+		return
+	}
+	s := n.scheduler
+	s.mutex.Lock()
+	if len(n.waiting) == 0 && n.hasCapacity() && s.hasCapacity() {
+		s.active++
+		n.active++
+		s.mutex.Unlock()
+		return
+	}
+	waitChan := make(chan struct{})
+	n.waiting = append(n.waiting, waitChan)
+	s.mutex.Unlock()
+
+	<-waitChan
+}
+
+/*
+releaseFlush - Returns a flush slot obtained with acquireFlush.
+*/
+func (n *Namespace) releaseFlush() {
+	if n == nil {
+		return
+	}
+	s := n.scheduler
+	s.mutex.Lock()
+	s.active--
+	n.active--
+	s.dispatch()
+	s.mutex.Unlock()
+}
+
+/*
+broadcastDelay - Reserve bandwidth for a broadcast of a number of bytes, returns the duration the
+caller should wait before sending in order to remain within the bandwidth cap.
+*/
+func (n *Namespace) broadcastDelay(bytes int) time.Duration {
+	if n == nil || n.bandwidth == nil {
+		return 0
+	}
+	return n.bandwidth.reserve(float64(bytes))
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
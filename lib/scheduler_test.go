@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestSchedulerNamespaceCap(t *testing.T) {
+	scheduler := NewScheduler(SchedulerConfig{MaxConcurrentFlushes: 4})
+	nsA := scheduler.NewNamespace("a", NamespaceConfig{MaxConcurrentFlushes: 1})
+	nsB := scheduler.NewNamespace("b", NamespaceConfig{MaxConcurrentFlushes: 1})
+
+	nsA.acquireFlush()
+
+	acquiredA, acquiredB := make(chan struct{}), make(chan struct{})
+	go func() {
+		nsA.acquireFlush()
+		close(acquiredA)
+	}()
+	go func() {
+		nsB.acquireFlush()
+		close(acquiredB)
+	}()
+
+	select {
+	case <-acquiredB:
+	case <-time.After(time.Second):
+		t.Errorf("Namespace b was starved by namespace a")
+	}
+	select {
+	case <-acquiredA:
+		t.Errorf("Namespace a exceeded its flush cap")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	nsA.releaseFlush()
+	select {
+	case <-acquiredA:
+	case <-time.After(time.Second):
+		t.Errorf("Namespace a was not granted a released slot")
+	}
+}
+
+func TestSchedulerRoundRobin(t *testing.T) {
+	scheduler := NewScheduler(SchedulerConfig{MaxConcurrentFlushes: 1})
+	nsA := scheduler.NewNamespace("a", NamespaceConfig{})
+	nsB := scheduler.NewNamespace("b", NamespaceConfig{})
+
+	nsA.acquireFlush()
+
+	order := make(chan string, 4)
+	waitFor := func(ns *Namespace) {
+		ns.acquireFlush()
+		order <- ns.Name()
+		ns.releaseFlush()
+	}
+
+	go waitFor(nsA)
+	go waitFor(nsA)
+	<-time.After(time.Millisecond * 20)
+	go waitFor(nsB)
+	<-time.After(time.Millisecond * 20)
+
+	nsA.releaseFlush()
+
+	first, second := <-order, <-order
+	if first == second {
+		t.Errorf("Expected namespaces to alternate, received: %v, %v", first, second)
+	}
+	<-order
+}
+
+func TestNamespaceBandwidth(t *testing.T) {
+	scheduler := NewScheduler(DefaultSchedulerConfig())
+	ns := scheduler.NewNamespace("a", NamespaceConfig{MaxBroadcastBytes: 100})
+
+	if delay := ns.broadcastDelay(100); delay != 0 {
+		t.Errorf("Expected no delay within burst, received: %v", delay)
+	}
+	if delay := ns.broadcastDelay(50); delay < time.Millisecond*400 {
+		t.Errorf("Expected a delay once over budget, received: %v", delay)
+	}
+	if delay := ns.broadcastDelay(-1000); delay != 0 {
+		t.Errorf("Expected no delay for nothing broadcast, received: %v", delay)
+	}
+	if delay := ns.broadcastDelay(1); delay < time.Millisecond*400 {
+		t.Errorf("Expected bandwidth to remain over budget, received: %v", delay)
+	}
+
+	var nilNamespace *Namespace
+	if delay := nilNamespace.broadcastDelay(1000000); delay != 0 {
+		t.Errorf("Expected nil namespace to be unlimited, received: %v", delay)
+	}
+}
+
+func TestBinderNamespaceBandwidth(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	ns := NewScheduler(DefaultSchedulerConfig()).NewNamespace("a", NamespaceConfig{MaxBroadcastBytes: 100})
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	binder, err := newBinder(doc.ID, docStore, nil, nil, nil, nil, DefaultBinderConfig(), ns, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	sender := binder.Subscribe("sender")
+	receiver := binder.Subscribe("receiver")
+
+	// Broadcasts over the bandwidth are held back without holding up the sender
+	before := time.Now()
+	long := strings.Repeat("a", 150)
+	if _, err = sender.SendTransform(OTransform{Position: 0, Insert: long, Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sender.SendTransform(OTransform{Position: 0, Insert: "b", Version: 3}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(before); waited > 200*time.Millisecond {
+		t.Errorf("Sender waited on bandwidth for %v", waited)
+	}
+
+	received := []OTransform{}
+	for len(received) < 2 {
+		select {
+		case ot := <-receiver.TransformRcvChan:
+			received = append(received, ot)
+		case batch := <-receiver.BatchRcvChan:
+			received = append(received, batch...)
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for held back transforms")
+		}
+	}
+	if received[0].Insert != long || received[1].Insert != "b" {
+		t.Errorf("Wrong transforms received: %v", received)
+	}
+	if waited := time.Since(before); waited < 300*time.Millisecond {
+		t.Errorf("Transforms were not held back: %v", waited)
+	}
+}
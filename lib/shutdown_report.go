@@ -0,0 +1,107 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+UnflushedDocument - A document whose changes could not be flushed while draining.
+*/
+type UnflushedDocument struct {
+	ID    string `json:"id" yaml:"id"`
+	Error string `json:"error" yaml:"error"`
+}
+
+/*
+ShutdownReport - The outcome of draining a curator ahead of shutting down. Flushed lists the
+documents that were open and safely stored, Unflushed those that failed to flush and may have lost
+changes, and DisconnectedClients the clients of each document that were still connected when the
+grace period ended and were therefore cut off.
+*/
+type ShutdownReport struct {
+	Started             time.Time           `json:"started" yaml:"started"`
+	Finished            time.Time           `json:"finished" yaml:"finished"`
+	DrainDuration       int64               `json:"drain_duration_ms" yaml:"drain_duration_ms"`
+	Flushed             []string            `json:"flushed" yaml:"flushed"`
+	Unflushed           []UnflushedDocument `json:"unflushed" yaml:"unflushed"`
+	DisconnectedClients map[string][]string `json:"disconnected_clients" yaml:"disconnected_clients"`
+}
+
+/*
+newShutdownReport - Returns an empty report of a drain starting now.
+*/
+func newShutdownReport() ShutdownReport {
+	return ShutdownReport{
+		Started:             time.Now(),
+		Flushed:             []string{},
+		Unflushed:           []UnflushedDocument{},
+		DisconnectedClients: map[string][]string{},
+	}
+}
+
+/*
+finish - Mark the drain of the report as finished.
+*/
+func (r *ShutdownReport) finish() {
+	r.Finished = time.Now()
+	r.DrainDuration = int64(r.Finished.Sub(r.Started) / time.Millisecond)
+}
+
+/*
+WriteFile - Write the report as JSON to a file, the file is replaced atomically so that readers
+never observe a partial report.
+*/
+func (r ShutdownReport) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".shutdown_report")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,212 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+StatsdConfig - Holds configuration options for emitting metrics to a statsd server at Address, which
+disables the emitter when empty. With DogStatsD metrics are tagged in the DogStatsD format, where
+Tags are added to every metric, otherwise the values of tags are appended to metric names. Metrics
+are sent in packets of at most MaxPacketSize bytes every FlushPeriod milliseconds.
+*/
+type StatsdConfig struct {
+	Address       string   `json:"address" yaml:"address"`
+	Network       string   `json:"network" yaml:"network"`
+	Prefix        string   `json:"prefix" yaml:"prefix"`
+	DogStatsD     bool     `json:"dogstatsd" yaml:"dogstatsd"`
+	Tags          []string `json:"tags" yaml:"tags"`
+	FlushPeriod   int64    `json:"flush_period_ms" yaml:"flush_period_ms"`
+	MaxPacketSize int      `json:"max_packet_size" yaml:"max_packet_size"`
+}
+
+/*
+NewStatsdConfig - Returns a default statsd configuration, the emitter is disabled.
+*/
+func NewStatsdConfig() StatsdConfig {
+	return StatsdConfig{
+		Address:       "",
+		Network:       "udp",
+		Prefix:        "leaps.",
+		DogStatsD:     false,
+		Tags:          []string{},
+		FlushPeriod:   100,
+		MaxPacketSize: 1432,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Statsd type.
+var (
+	ErrStatsdNoAddress = errors.New("statsd emitter requires an address")
+)
+
+// The number of metrics that may be queued before further metrics are dropped.
+const statsdQueueSize = 4096
+
+/*
+Statsd - Emits counters and timings to a statsd server. Metrics are queued and sent in batches by a
+background loop, and dropped rather than blocking the caller when the queue is full. A nil Statsd
+ignores everything.
+*/
+type Statsd struct {
+	config StatsdConfig
+	conn   net.Conn
+
+	lines      chan string
+	closeChan  chan struct{}
+	closedChan chan struct{}
+}
+
+/*
+NewStatsd - Create a statsd emitter and launch its loop.
+*/
+func NewStatsd(config StatsdConfig) (*Statsd, error) {
+	if len(config.Address) == 0 {
+		return nil, ErrStatsdNoAddress
+	}
+	conn, err := net.Dial(config.Network, config.Address)
+	if err != nil {
+		return nil, err
+	}
+	s := &Statsd{
+		config:     config,
+		conn:       conn,
+		lines:      make(chan string, statsdQueueSize),
+		closeChan:  make(chan struct{}),
+		closedChan: make(chan struct{}),
+	}
+	go s.loop()
+	return s, nil
+}
+
+/*
+Count - Add to a counter, tags are of the form key:value.
+*/
+func (s *Statsd) Count(name string, value int64, tags ...string) {
+	s.emit(name, fmt.Sprintf("%v|c", value), tags)
+}
+
+/*
+Timing - Record a duration in milliseconds, tags are of the form key:value.
+*/
+func (s *Statsd) Timing(name string, duration time.Duration, tags ...string) {
+	s.emit(name, fmt.Sprintf("%v|ms", float64(duration)/float64(time.Millisecond)), tags)
+}
+
+/*
+Close - Send any queued metrics and shut the emitter down.
+*/
+func (s *Statsd) Close() {
+	if s == nil {
+		return
+	}
+	close(s.closeChan)
+	<-s.closedChan
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+emit - Format a metric and queue it for the loop.
+*/
+func (s *Statsd) emit(name, value string, tags []string) {
+	if s == nil {
+		return
+	}
+	var line string
+	if s.config.DogStatsD {
+		line = s.config.Prefix + name + ":" + value
+		if all := append(append([]string{}, s.config.Tags...), tags...); len(all) > 0 {
+			line += "|#" + strings.Join(all, ",")
+		}
+	} else {
+		for _, tag := range tags {
+			name += "." + tag[strings.Index(tag, ":")+1:]
+		}
+		line = s.config.Prefix + name + ":" + value
+	}
+	select {
+	case s.lines <- line:
+	default:
+	}
+}
+
+/*
+loop - Batch queued metrics into packets and send them each flush period until closed.
+*/
+func (s *Statsd) loop() {
+	ticker := time.NewTicker(time.Duration(s.config.FlushPeriod) * time.Millisecond)
+	defer ticker.Stop()
+
+	var packet bytes.Buffer
+	send := func() {
+		if packet.Len() > 0 {
+			// Statsd is fire and forget, a lost packet is only a gap in the graphs.
+			s.conn.Write(packet.Bytes())
+			packet.Reset()
+		}
+	}
+	add := func(line string) {
+		if packet.Len() > 0 && packet.Len()+len(line)+1 > s.config.MaxPacketSize {
+			send()
+		}
+		if packet.Len() > 0 {
+			packet.WriteByte('\n')
+		}
+		packet.WriteString(line)
+	}
+
+	for {
+		select {
+		case line := <-s.lines:
+			add(line)
+		case <-ticker.C:
+			send()
+		case <-s.closeChan:
+			for len(s.lines) > 0 {
+				add(<-s.lines)
+			}
+			send()
+			s.conn.Close()
+			close(s.closedChan)
+			return
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
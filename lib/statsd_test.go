@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdMetrics(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, dogstatsd := range []bool{false, true} {
+		config := NewStatsdConfig()
+		config.Address = conn.LocalAddr().String()
+		config.DogStatsD = dogstatsd
+		config.Tags = []string{"env:test"}
+
+		statsd, err := NewStatsd(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		metrics := NewMetrics()
+		metrics.EmitTo(statsd)
+
+		b := &Binder{ID: "foo"}
+		metrics.binderOpened(b)
+		metrics.setSubscribers(b, 2)
+		metrics.transformApplied()
+		metrics.flushed(1500 * time.Microsecond)
+		metrics.clientKicked("admin")
+		metrics.setSubscribers(b, 1)
+		statsd.Close()
+
+		buf := make([]byte, 2048)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(string(buf[:n]), "\n")
+		sort.Strings(lines)
+
+		exp := []string{
+			"leaps.clients.joined:2|c",
+			"leaps.clients.kicked.admin:1|c",
+			"leaps.clients.left:1|c",
+			"leaps.flush.duration:1.5|ms",
+			"leaps.transforms.applied:1|c",
+		}
+		if dogstatsd {
+			exp = []string{
+				"leaps.clients.joined:2|c|#env:test",
+				"leaps.clients.kicked:1|c|#env:test,reason:admin",
+				"leaps.clients.left:1|c|#env:test",
+				"leaps.flush.duration:1.5|ms|#env:test",
+				"leaps.transforms.applied:1|c|#env:test",
+			}
+		}
+		if strings.Join(exp, "\n") != strings.Join(lines, "\n") {
+			t.Errorf("Wrong statsd metrics with dogstatsd %v: %v != %v", dogstatsd, exp, lines)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Codec types.
+var (
+	ErrInvalidCodec  = errors.New("invalid document codec")
+	ErrCodecMismatch = errors.New("stored data is not in the format of the configured codec")
+	ErrCodecTextOnly = errors.New("the raw codec only stores text documents, use the json codec")
+)
+
+/*
+Codec - Implemented by types able to serialise a document into the bytes persisted by a store, and
+back again. Binary reports whether the encoded form may contain bytes that are not valid UTF-8, in
+which case stores backed by text columns need a binary column type (BLOB, BYTEA) instead.
+*/
+type Codec interface {
+	// Name - The configuration name of the codec.
+	Name() string
+
+	// Binary - Whether the encoded form may be arbitrary bytes.
+	Binary() bool
+
+	// Encode - Serialise a document.
+	Encode(doc Document) ([]byte, error)
+
+	// Decode - Deserialise a document, the ID is that used to locate the stored data.
+	Decode(id string, data []byte) (Document, error)
+}
+
+/*
+CodecFactory - Returns a codec based on its configured name. An empty name gives the raw codec.
+*/
+func CodecFactory(name string) (Codec, error) {
+	switch name {
+	case "", "raw":
+		return rawCodec{}, nil
+	case "json":
+		return jsonCodec{}, nil
+	case "msgpack":
+		return msgpackCodec{}, nil
+	case "protobuf":
+		return protobufCodec{}, nil
+	}
+	return nil, fmt.Errorf("%v: %v", ErrInvalidCodec, name)
+}
+
+/*
+codecKeepsMetadata - Whether documents serialised by a codec keep their metadata, which is only lost
+by the raw codec.
+*/
+func codecKeepsMetadata(c Codec) bool {
+	_, raw := c.(rawCodec)
+	return !raw
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+rawCodec - Stores only the document content, untouched. This is the original format of the stores.
+The document type and metadata are not stored, so documents read back are always text documents
+without metadata, and documents of other types are refused rather than stored as text.
+*/
+type rawCodec struct{}
+
+func (c rawCodec) Name() string { return "raw" }
+func (c rawCodec) Binary() bool { return false }
+
+func (c rawCodec) Encode(doc Document) ([]byte, error) {
+	if doc.Type != "" && doc.Type != "text" {
+		return nil, ErrCodecTextOnly
+	}
+	return []byte(doc.Content), nil
+}
+
+func (c rawCodec) Decode(id string, data []byte) (Document, error) {
+	return Document{ID: id, Content: string(data)}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+jsonCodec - Stores the document as a JSON object of the form {"id":"...","content":"..."}, with a
+"type" field for documents that are not plain text and a "metadata" object when the document has
+metadata.
+*/
+type jsonCodec struct{}
+
+func (c jsonCodec) Name() string { return "json" }
+func (c jsonCodec) Binary() bool { return false }
+
+func (c jsonCodec) Encode(doc Document) ([]byte, error) {
+	return json.Marshal(doc)
+}
+
+func (c jsonCodec) Decode(id string, data []byte) (Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return Document{}, fmt.Errorf("%v: %v", ErrCodecMismatch, err)
+	}
+	doc.ID = id
+	return doc, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+msgpackCodec - Stores the document as a msgpack map with the string keys "id", "content" and, for
+documents that are not plain text, "type", which any msgpack library can read. Documents with
+metadata also carry a "metadata" key holding a map of strings.
+*/
+type msgpackCodec struct{}
+
+func (c msgpackCodec) Name() string { return "msgpack" }
+func (c msgpackCodec) Binary() bool { return true }
+
+func msgpackAppendStr(b []byte, s string) []byte {
+	l := len(s)
+	switch {
+	case l < 32:
+		b = append(b, 0xa0|byte(l))
+	case l < 1<<8:
+		b = append(b, 0xd9, byte(l))
+	case l < 1<<16:
+		b = append(b, 0xda, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(l))
+	default:
+		b = append(b, 0xdb, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], uint32(l))
+	}
+	return append(b, s...)
+}
+
+func msgpackReadStr(data []byte) (string, []byte, error) {
+	if len(data) == 0 {
+		return "", nil, ErrCodecMismatch
+	}
+	var l, head int
+	switch t := data[0]; {
+	case t&0xe0 == 0xa0:
+		l, head = int(t&0x1f), 1
+	case t == 0xd9 && len(data) >= 2:
+		l, head = int(data[1]), 2
+	case t == 0xda && len(data) >= 3:
+		l, head = int(binary.BigEndian.Uint16(data[1:])), 3
+	case t == 0xdb && len(data) >= 5:
+		l, head = int(binary.BigEndian.Uint32(data[1:])), 5
+	default:
+		return "", nil, ErrCodecMismatch
+	}
+	if len(data) < head+l {
+		return "", nil, ErrCodecMismatch
+	}
+	return string(data[head : head+l]), data[head+l:], nil
+}
+
+func msgpackAppendMapHeader(b []byte, l int) []byte {
+	switch {
+	case l < 16:
+		b = append(b, 0x80|byte(l))
+	case l < 1<<16:
+		b = append(b, 0xde, 0, 0)
+		binary.BigEndian.PutUint16(b[len(b)-2:], uint16(l))
+	default:
+		b = append(b, 0xdf, 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(b[len(b)-4:], uint32(l))
+	}
+	return b
+}
+
+func msgpackReadMapHeader(data []byte) (int, []byte, error) {
+	if len(data) == 0 {
+		return 0, nil, ErrCodecMismatch
+	}
+	switch t := data[0]; {
+	case t&0xf0 == 0x80:
+		return int(t & 0x0f), data[1:], nil
+	case t == 0xde && len(data) >= 3:
+		return int(binary.BigEndian.Uint16(data[1:])), data[3:], nil
+	case t == 0xdf && len(data) >= 5:
+		return int(binary.BigEndian.Uint32(data[1:])), data[5:], nil
+	}
+	return 0, nil, ErrCodecMismatch
+}
+
+/*
+sortedMetadataKeys - Returns the keys of a metadata map in order, giving codecs a stable output.
+*/
+func sortedMetadataKeys(metadata map[string]string) []string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (c msgpackCodec) Encode(doc Document) ([]byte, error) {
+	b := make([]byte, 0, len(doc.ID)+len(doc.Content)+len(doc.Type)+26)
+	entries := 2
+	if len(doc.Type) > 0 {
+		entries++
+	}
+	if len(doc.Metadata) > 0 {
+		entries++
+	}
+	b = msgpackAppendMapHeader(b, entries)
+	b = msgpackAppendStr(b, "id")
+	b = msgpackAppendStr(b, doc.ID)
+	b = msgpackAppendStr(b, "content")
+	b = msgpackAppendStr(b, doc.Content)
+	if len(doc.Type) > 0 {
+		b = msgpackAppendStr(b, "type")
+		b = msgpackAppendStr(b, doc.Type)
+	}
+	if len(doc.Metadata) > 0 {
+		b = msgpackAppendStr(b, "metadata")
+		b = msgpackAppendMapHeader(b, len(doc.Metadata))
+		for _, k := range sortedMetadataKeys(doc.Metadata) {
+			b = msgpackAppendStr(b, k)
+			b = msgpackAppendStr(b, doc.Metadata[k])
+		}
+	}
+	return b, nil
+}
+
+func msgpackReadStrMap(data []byte) (map[string]string, []byte, error) {
+	entries, rest, err := msgpackReadMapHeader(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	m := make(map[string]string, entries)
+	for i := 0; i < entries; i++ {
+		var key, value string
+		if key, rest, err = msgpackReadStr(rest); err != nil {
+			return nil, nil, err
+		}
+		if value, rest, err = msgpackReadStr(rest); err != nil {
+			return nil, nil, err
+		}
+		m[key] = value
+	}
+	return m, rest, nil
+}
+
+func (c msgpackCodec) Decode(id string, data []byte) (Document, error) {
+	doc := Document{ID: id}
+	entries, rest, err := msgpackReadMapHeader(data)
+	if err != nil {
+		return Document{}, err
+	}
+	for i := 0; i < entries; i++ {
+		var key, value string
+		if key, rest, err = msgpackReadStr(rest); err != nil {
+			return Document{}, err
+		}
+		if key == "metadata" {
+			if doc.Metadata, rest, err = msgpackReadStrMap(rest); err != nil {
+				return Document{}, err
+			}
+			continue
+		}
+		if value, rest, err = msgpackReadStr(rest); err != nil {
+			return Document{}, err
+		}
+		switch key {
+		case "content":
+			doc.Content = value
+		case "type":
+			doc.Type = value
+		}
+	}
+	return doc, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+protobufCodec - Stores the document in the protobuf wire format of the message:
+
+	message Document {
+		string id = 1;
+		string content = 2;
+		string type = 3;
+		map<string, string> metadata = 4;
+	}
+*/
+type protobufCodec struct{}
+
+func (c protobufCodec) Name() string { return "protobuf" }
+func (c protobufCodec) Binary() bool { return true }
+
+func protobufAppendStr(b []byte, tag byte, value string) []byte {
+	b = append(b, tag)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func (c protobufCodec) Encode(doc Document) ([]byte, error) {
+	b := make([]byte, 0, len(doc.ID)+len(doc.Content)+len(doc.Type)+18)
+	for _, field := range []struct {
+		tag   byte
+		value string
+	}{{0x0a, doc.ID}, {0x12, doc.Content}, {0x1a, doc.Type}} {
+		if len(field.value) == 0 {
+			continue
+		}
+		b = protobufAppendStr(b, field.tag, field.value)
+	}
+
+	// Map fields are encoded as repeated entry messages of {key = 1, value = 2}.
+	for _, k := range sortedMetadataKeys(doc.Metadata) {
+		entry := protobufAppendStr(nil, 0x0a, k)
+		entry = protobufAppendStr(entry, 0x12, doc.Metadata[k])
+		b = protobufAppendStr(b, 0x22, string(entry))
+	}
+	return b, nil
+}
+
+/*
+protobufReadFields - Walks the length delimited fields of a protobuf message, calling fn with the
+field number and value of each.
+*/
+func protobufReadFields(data []byte, fn func(field uint64, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrCodecMismatch
+		}
+		data = data[n:]
+
+		// Only length delimited fields are part of the schema.
+		if key&0x07 != 2 {
+			return ErrCodecMismatch
+		}
+		l, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < l {
+			return ErrCodecMismatch
+		}
+		value := data[n : n+int(l)]
+		data = data[n+int(l):]
+
+		if err := fn(key>>3, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c protobufCodec) Decode(id string, data []byte) (Document, error) {
+	doc := Document{ID: id}
+	err := protobufReadFields(data, func(field uint64, value []byte) error {
+		switch field {
+		case 2:
+			doc.Content = string(value)
+		case 3:
+			doc.Type = string(value)
+		case 4:
+			var k, v string
+			if err := protobufReadFields(value, func(field uint64, value []byte) error {
+				switch field {
+				case 1:
+					k = string(value)
+				case 2:
+					v = string(value)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if doc.Metadata == nil {
+				doc.Metadata = map[string]string{}
+			}
+			doc.Metadata[k] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return Document{}, err
+	}
+	return doc, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
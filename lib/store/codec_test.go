@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	docs := []Document{
+		{ID: "empty", Content: ""},
+		{ID: "short", Content: "hello world"},
+		{ID: "unicode", Content: "hello 世界   \"quoted\" <tag>"},
+		{ID: "medium", Content: strings.Repeat("a", 200)},
+		{ID: "long", Content: strings.Repeat("hello world ", 10000)},
+	}
+
+	for _, name := range []string{"raw", "json", "msgpack", "protobuf"} {
+		codec, err := CodecFactory(name)
+		if err != nil {
+			t.Errorf("Failed to create codec %v: %v", name, err)
+			continue
+		}
+		if codec.Name() != name {
+			t.Errorf("Wrong codec name: %v != %v", codec.Name(), name)
+		}
+		for _, doc := range docs {
+			data, err := codec.Encode(doc)
+			if err != nil {
+				t.Errorf("%v: failed to encode %v: %v", name, doc.ID, err)
+				continue
+			}
+			result, err := codec.Decode(doc.ID, data)
+			if err != nil {
+				t.Errorf("%v: failed to decode %v: %v", name, doc.ID, err)
+				continue
+			}
+			if !reflect.DeepEqual(result, doc) {
+				t.Errorf("%v: round trip mismatch for %v", name, doc.ID)
+			}
+		}
+	}
+
+	// The raw codec stores content only and so refuses typed documents, the others keep the type.
+	typed := Document{ID: "typed", Content: `{"key":"value"}`, Type: "json"}
+	if raw, _ := CodecFactory("raw"); raw != nil {
+		if _, err := raw.Encode(typed); err != ErrCodecTextOnly {
+			t.Errorf("Wrong error encoding typed document with the raw codec: %v", err)
+		}
+	}
+	for _, name := range []string{"json", "msgpack", "protobuf"} {
+		codec, _ := CodecFactory(name)
+		data, _ := codec.Encode(typed)
+		if result, err := codec.Decode(typed.ID, data); err != nil || !reflect.DeepEqual(result, typed) {
+			t.Errorf("%v: round trip mismatch for typed document: %v, %v", name, result, err)
+		}
+	}
+
+	described := Document{ID: "described", Content: "hello", Metadata: map[string]string{
+		"title":    "Greeting",
+		"language": "en",
+		"tags":     strings.Repeat("tag,", 20),
+	}}
+	for _, name := range []string{"json", "msgpack", "protobuf"} {
+		codec, _ := CodecFactory(name)
+		data, _ := codec.Encode(described)
+		if result, err := codec.Decode(described.ID, data); err != nil || !reflect.DeepEqual(result, described) {
+			t.Errorf("%v: round trip mismatch for document metadata: %v, %v", name, result, err)
+		}
+	}
+
+	if _, err := CodecFactory("xml"); err == nil {
+		t.Error("Expected error from unknown codec")
+	}
+}
+
+func TestCodecMismatch(t *testing.T) {
+	for _, name := range []string{"json", "msgpack", "protobuf"} {
+		codec, _ := CodecFactory(name)
+		if _, err := codec.Decode("test", []byte("\xffnot encoded")); err == nil {
+			t.Errorf("%v: expected error decoding foreign data", name)
+		}
+	}
+}
+
+func TestFileStoreCodec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_codec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+	config.Codec = "json"
+
+	fileStore, err := GetFileStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = fileStore.Create(Document{ID: "test", Content: "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(dir + "/test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"id":"test","content":"hello world"}`; string(raw) != expected {
+		t.Errorf("Unexpected stored format: %s != %s", raw, expected)
+	}
+
+	doc, err := fileStore.Read("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Content != "hello world" {
+		t.Errorf("Unexpected content: %v", doc.Content)
+	}
+}
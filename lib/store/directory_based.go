@@ -0,0 +1,356 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the DirectoryStore type.
+var (
+	ErrInvalidDocumentPath = errors.New("document ID does not resolve to a path within the directory")
+	ErrDocumentExists      = errors.New("a file already exists for the document")
+)
+
+/*
+DocumentPath - Returns the path of a document within a root directory, where the ID is a slash
+separated path relative to the root. IDs that are absolute or that climb out of the root, such as
+../../etc/passwd, are rejected.
+*/
+func DocumentPath(root, id string) (string, error) {
+	if len(id) == 0 || strings.ContainsRune(id, 0) || filepath.IsAbs(filepath.FromSlash(id)) {
+		return "", ErrInvalidDocumentPath
+	}
+	p := filepath.Join(root, filepath.FromSlash(id))
+	if p == root || !withinDirectory(root, p) {
+		return "", ErrInvalidDocumentPath
+	}
+	return p, nil
+}
+
+/*
+withinDirectory - Whether the path p is the root directory or lies beneath it.
+*/
+func withinDirectory(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+DirectoryStore - Maps documents onto the real files of an existing directory, such as the checkout
+of a codebase, so that leaps can be used to edit them collaboratively. The ID of a document is the
+path of its file relative to StoreDirectory. Content is loaded from the file when a document is
+first joined and flushes are written back to it, with no encoding, so the files remain usable by any
+other tool.
+
+Unlike the FileStore, the directory must already exist, hidden files and directories (such as .git)
+are neither listed nor editable, and symbolic links are only followed when they resolve to a target
+within the directory. Flushes replace the file atomically and keep its permissions. Files modified by
+other tools can be watched for, see Watch.
+*/
+type DirectoryStore struct {
+	root string
+}
+
+/*
+resolve - Returns the path of a document, ensuring that neither the ID nor any symbolic link along
+the way leads outside of the root directory.
+*/
+func (s *DirectoryStore) resolve(id string) (string, error) {
+	p, err := DocumentPath(s.root, id)
+	if err != nil {
+		return "", err
+	}
+	for _, segment := range strings.Split(filepath.ToSlash(id), "/") {
+		if strings.HasPrefix(segment, ".") {
+			return "", ErrInvalidDocumentPath
+		}
+	}
+
+	// Resolve the deepest part of the path that exists, a missing file may still be created.
+	existing := p
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			if !withinDirectory(s.root, real) {
+				return "", ErrInvalidDocumentPath
+			}
+			return p, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", ErrInvalidDocumentPath
+		}
+		existing = parent
+	}
+}
+
+/*
+Create - Create the file of a new document, failing if a file already exists at that path.
+*/
+func (s *DirectoryStore) Create(doc Document) error {
+	p, err := s.resolve(doc.ID)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return fmt.Errorf("cannot create file path for document: %v, err: %v", doc.ID, err)
+	}
+	file, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return ErrDocumentExists
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteString(doc.Content); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+Update - Write the content of a document back to its file. The content is written to a temporary
+file which then replaces the original, so that other readers never observe a partial flush.
+*/
+func (s *DirectoryStore) Update(doc Document) error {
+	p, err := s.resolve(doc.ID)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return ErrDocumentNotExist
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return ErrInvalidDocumentPath
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".leaps_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.WriteString(doc.Content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+/*
+Read - Read the content of a document from its file.
+*/
+func (s *DirectoryStore) Read(id string) (Document, error) {
+	p, err := s.resolve(id)
+	if err != nil {
+		return Document{}, err
+	}
+	info, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return Document{}, ErrDocumentNotExist
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return Document{}, ErrInvalidDocumentPath
+	}
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return Document{}, fmt.Errorf("failed to read content from document file: %v", err)
+	}
+	return Document{ID: id, Content: string(data)}, nil
+}
+
+/*
+Delete - Remove the file of a document.
+*/
+func (s *DirectoryStore) Delete(id string) error {
+	p, err := s.resolve(id)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+KeepsMetadata - Files hold only the content of documents, so metadata is never kept.
+*/
+func (s *DirectoryStore) KeepsMetadata() bool {
+	return false
+}
+
+/*
+List - Walk the directory and return the relative path of each regular file that is not hidden.
+*/
+func (s *DirectoryStore) List() ([]string, error) {
+	ids := []string{}
+	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != s.root && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		id, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, filepath.ToSlash(id))
+		return nil
+	})
+	return ids, err
+}
+
+/*
+Watch - Watch the directory for files being written, created, renamed or removed, and send the ID of
+each such file. New directories are watched as they appear, hidden directories are not watched.
+*/
+func (s *DirectoryStore) Watch(stop <-chan struct{}) (<-chan string, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.watchTree(watcher, s.root); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	changes := make(chan string)
+	go func() {
+		defer close(changes)
+		defer watcher.Close()
+		for {
+			select {
+			case event, open := <-watcher.Events:
+				if !open {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if event.Op&fsnotify.Create != 0 {
+						s.watchTree(watcher, event.Name)
+					}
+					continue
+				}
+				rel, err := filepath.Rel(s.root, event.Name)
+				if err != nil {
+					continue
+				}
+				id := filepath.ToSlash(rel)
+				if _, err = s.resolve(id); err != nil {
+					continue
+				}
+				select {
+				case changes <- id:
+				case <-stop:
+					return
+				}
+			case _, open := <-watcher.Errors:
+				if !open {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return changes, nil
+}
+
+/*
+watchTree - Add a directory and each of the directories beneath it that are not hidden to a watcher.
+*/
+func (s *DirectoryStore) watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		if path != s.root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+/*
+GetDirectoryStore - Returns a DirectoryStore for the existing directory at StoreDirectory.
+*/
+func GetDirectoryStore(config Config) (Store, error) {
+	if len(config.StoreDirectory) == 0 {
+		return nil, ErrInvalidDirectory
+	}
+	root, err := filepath.Abs(config.StoreDirectory)
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, fmt.Errorf("cannot open directory for documents: %v", err)
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		return nil, ErrInvalidDirectory
+	}
+	return &DirectoryStore{root: root}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,209 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestDirectoryStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	outside, err := ioutil.TempDir("", "leaps_outside")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".git"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main"), 0640)
+	ioutil.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref: refs/heads/master"), 0644)
+	ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("hunter2"), 0644)
+	if err = os.Symlink(outside, filepath.Join(dir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	config := NewConfig()
+	config.Type = "directory"
+	config.StoreDirectory = filepath.Join(dir, "does_not_exist")
+	if _, err = Factory(config); err == nil {
+		t.Error("Expected error for missing directory")
+	}
+
+	config.StoreDirectory = dir
+	store, err := Factory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := store.Read("src/main.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "package main", doc.Content; exp != act {
+		t.Errorf("Wrong content: %v != %v", exp, act)
+	}
+
+	doc.Content = "package main\n\nfunc main() {}\n"
+	if err = store.Update(doc); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "src", "main.go"))
+	if string(data) != doc.Content {
+		t.Errorf("Flush was not written to the file: %s", data)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, "src", "main.go")); info.Mode().Perm() != 0640 {
+		t.Errorf("File permissions were not kept: %v", info.Mode())
+	}
+
+	if err = store.Create(Document{ID: "src/util.go", Content: "package main"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Create(Document{ID: "src/util.go", Content: "clobbered"}); err != ErrDocumentExists {
+		t.Errorf("Expected existing file error, received: %v", err)
+	}
+	if err = store.Update(Document{ID: "src/missing.go"}); err != ErrDocumentNotExist {
+		t.Errorf("Expected missing file error, received: %v", err)
+	}
+
+	for _, id := range []string{
+		"../secret", "src/../../secret", "/etc/passwd", "escape/secret", "escape/new", ".git/HEAD", "src", "",
+	} {
+		if _, err = store.Read(id); err != ErrInvalidDocumentPath {
+			t.Errorf("Expected invalid path error for read of %q, received: %v", id, err)
+		}
+		if err = store.Update(Document{ID: id, Content: "pwned"}); err != ErrInvalidDocumentPath {
+			t.Errorf("Expected invalid path error for update of %q, received: %v", id, err)
+		}
+	}
+	if err = store.Create(Document{ID: "escape/new", Content: "pwned"}); err != ErrInvalidDocumentPath {
+		t.Errorf("Expected invalid path error for create through symlink, received: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(outside, "new")); !os.IsNotExist(err) {
+		t.Error("File was created outside of the directory")
+	}
+
+	ids, err := store.(Lister).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(ids)
+	if exp := []string{"src/main.go", "src/util.go"}; !reflect.DeepEqual(exp, ids) {
+		t.Errorf("Wrong documents listed: %v != %v", exp, ids)
+	}
+}
+
+func TestFileStorePathTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Type = "file"
+	config.StoreDirectory = filepath.Join(dir, "docs")
+	store, err := Factory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Update(Document{ID: "../outside", Content: "pwned"}); err != ErrInvalidDocumentPath {
+		t.Errorf("Expected invalid path error, received: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "outside")); !os.IsNotExist(err) {
+		t.Error("File was written outside of the store directory")
+	}
+}
+
+func TestDirectoryStoreWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Type = "directory"
+	config.StoreDirectory = dir
+	store, err := Factory(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	changes, err := store.(Watcher).Watch(stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	awaitChange := func(exp string) {
+		deadline := time.After(5 * time.Second)
+		for {
+			select {
+			case id := <-changes:
+				if id == exp {
+					return
+				}
+				if id == ".hidden" || id == "src" {
+					t.Errorf("Unexpected change: %v", id)
+				}
+			case <-deadline:
+				t.Fatalf("Timed out waiting for change to %v", exp)
+			}
+		}
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, ".hidden"), []byte("ignored"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), 0644)
+	awaitChange("main.go")
+
+	// Directories created after watching began are watched too
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	for settled := false; !settled; {
+		select {
+		case id := <-changes:
+			if id == "src" {
+				t.Errorf("Unexpected change: %v", id)
+			}
+		case <-time.After(100 * time.Millisecond):
+			settled = true
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "src", "util.go"), []byte("package main"), 0644)
+	awaitChange("src/util.go")
+
+	close(stop)
+	for range changes {
+	}
+}
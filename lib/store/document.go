@@ -28,11 +28,36 @@ import "github.com/jeffail/leaps/lib/util"
  */
 
 /*
-Document - A representation of a leap document.
+Document - A representation of a leap document. Type selects the transform model of the document,
+either text (the default when empty), json or rich. Metadata holds free form string properties of
+the document such as a title, language or tags.
 */
 type Document struct {
-	ID      string `json:"id" yaml:"id"`
-	Content string `json:"content" yaml:"content"`
+	ID       string            `json:"id" yaml:"id"`
+	Content  string            `json:"content" yaml:"content"`
+	Type     string            `json:"type,omitempty" yaml:"type,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+sameDocument - Whether two documents hold the same fields, treating nil and empty metadata alike.
+*/
+func sameDocument(a, b Document) bool {
+	if a.ID != b.ID || a.Content != b.Content || a.Type != b.Type {
+		return false
+	}
+	if len(a.Metadata) != len(b.Metadata) {
+		return false
+	}
+	for k, v := range a.Metadata {
+		if bv, ok := b.Metadata[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
 }
 
 /*--------------------------------------------------------------------------------------------------
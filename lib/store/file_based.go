@@ -48,6 +48,7 @@ create and edit the file /var/www/css/main.css
 */
 type FileStore struct {
 	config Config
+	codec  Codec
 }
 
 /*
@@ -61,29 +62,77 @@ func (s *FileStore) Create(doc Document) error {
 Update - Update a document in its file location.
 */
 func (s *FileStore) Update(doc Document) error {
-	filePath := filepath.Join(s.config.StoreDirectory, doc.ID)
+	filePath, err := DocumentPath(s.config.StoreDirectory, doc.ID)
+	if err != nil {
+		return err
+	}
 	fileDir := filepath.Dir(filePath)
 
-	if _, err := os.Stat(fileDir); os.IsNotExist(err) {
+	if _, err = os.Stat(fileDir); os.IsNotExist(err) {
 		if err = os.MkdirAll(fileDir, os.ModePerm); err != nil {
 			return fmt.Errorf("cannot create file path for document: %v, err: %v", doc.ID, err)
 		}
 	}
-	return ioutil.WriteFile(filePath, []byte(doc.Content), 0666)
+	data, err := s.codec.Encode(doc)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filePath, data, 0666)
 }
 
 /*
 Read - Read document from its file location.
 */
 func (s *FileStore) Read(id string) (Document, error) {
-	bytes, err := ioutil.ReadFile(filepath.Join(s.config.StoreDirectory, id))
+	filePath, err := DocumentPath(s.config.StoreDirectory, id)
+	if err != nil {
+		return Document{}, err
+	}
+	bytes, err := ioutil.ReadFile(filePath)
 	if err != nil {
 		return Document{}, fmt.Errorf("failed to read content from document file: %v", err)
 	}
-	return Document{
-		Content: string(bytes),
-		ID:      id,
-	}, nil
+	return s.codec.Decode(id, bytes)
+}
+
+/*
+Delete - Remove the file of a document.
+*/
+func (s *FileStore) Delete(id string) error {
+	filePath, err := DocumentPath(s.config.StoreDirectory, id)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+/*
+KeepsMetadata - Whether metadata is kept, which depends on the codec of the store.
+*/
+func (s *FileStore) KeepsMetadata() bool {
+	return codecKeepsMetadata(s.codec)
+}
+
+/*
+List - Walk the store directory and return the relative path of each file as a document ID.
+*/
+func (s *FileStore) List() ([]string, error) {
+	ids := []string{}
+	err := filepath.Walk(s.config.StoreDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		id, err := filepath.Rel(s.config.StoreDirectory, path)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, filepath.ToSlash(id))
+		return nil
+	})
+	return ids, err
 }
 
 /*
@@ -98,7 +147,11 @@ func GetFileStore(config Config) (Store, error) {
 			return nil, fmt.Errorf("cannot create file store for documents: %v", err)
 		}
 	}
-	return &FileStore{config: config}, nil
+	codec, err := CodecFactory(config.Codec)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStore{config: config, codec: codec}, nil
 }
 
 /*--------------------------------------------------------------------------------------------------
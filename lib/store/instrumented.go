@@ -0,0 +1,296 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"time"
+
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+MetricsConfig - Holds configuration options for instrumenting a store. When enabled the latency and
+outcome of every store operation is recorded in the stats, and operations that take at least
+SlowThreshold milliseconds are logged as warnings.
+*/
+type MetricsConfig struct {
+	Enabled       bool  `json:"enabled" yaml:"enabled"`
+	SlowThreshold int64 `json:"slow_threshold_ms" yaml:"slow_threshold_ms"`
+}
+
+/*
+NewMetricsConfig - Returns a default metrics configuration, instrumentation is disabled.
+*/
+func NewMetricsConfig() MetricsConfig {
+	return MetricsConfig{
+		Enabled:       false,
+		SlowThreshold: 500,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Instrument - Wrap a store so that the latency, errors and slow calls of each of its operations are
+recorded, or return the store as it is when instrumentation is disabled. The wrapped store
+implements the same optional interfaces (FencedUpdater, Deleter, Lister and Watcher) as the original,
+and closing it closes the original.
+*/
+func Instrument(store Store, config MetricsConfig, logger *log.Logger, stats *log.Stats) Store {
+	if !config.Enabled {
+		return store
+	}
+	s := &instrumentedStore{
+		store:  store,
+		config: config,
+		log:    logger.NewModule(":store"),
+		stats:  stats,
+	}
+
+	var (
+		_, fenced   = store.(FencedUpdater)
+		_, deleter  = store.(Deleter)
+		_, lister   = store.(Lister)
+		_, watching = store.(Watcher)
+	)
+	f, d, l, w := instrumentedFenced{s}, instrumentedDeleter{s}, instrumentedLister{s}, instrumentedWatcher{s}
+
+	// Each combination of optional interfaces needs its own type for type assertions to hold.
+	switch {
+	case fenced && deleter && lister && watching:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+			instrumentedDeleter
+			instrumentedLister
+			instrumentedWatcher
+		}{s, f, d, l, w}
+	case fenced && deleter && lister:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+			instrumentedDeleter
+			instrumentedLister
+		}{s, f, d, l}
+	case fenced && deleter && watching:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+			instrumentedDeleter
+			instrumentedWatcher
+		}{s, f, d, w}
+	case fenced && lister && watching:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+			instrumentedLister
+			instrumentedWatcher
+		}{s, f, l, w}
+	case deleter && lister && watching:
+		return struct {
+			*instrumentedStore
+			instrumentedDeleter
+			instrumentedLister
+			instrumentedWatcher
+		}{s, d, l, w}
+	case fenced && deleter:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+			instrumentedDeleter
+		}{s, f, d}
+	case fenced && lister:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+			instrumentedLister
+		}{s, f, l}
+	case fenced && watching:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+			instrumentedWatcher
+		}{s, f, w}
+	case deleter && lister:
+		return struct {
+			*instrumentedStore
+			instrumentedDeleter
+			instrumentedLister
+		}{s, d, l}
+	case deleter && watching:
+		return struct {
+			*instrumentedStore
+			instrumentedDeleter
+			instrumentedWatcher
+		}{s, d, w}
+	case lister && watching:
+		return struct {
+			*instrumentedStore
+			instrumentedLister
+			instrumentedWatcher
+		}{s, l, w}
+	case fenced:
+		return struct {
+			*instrumentedStore
+			instrumentedFenced
+		}{s, f}
+	case deleter:
+		return struct {
+			*instrumentedStore
+			instrumentedDeleter
+		}{s, d}
+	case lister:
+		return struct {
+			*instrumentedStore
+			instrumentedLister
+		}{s, l}
+	case watching:
+		return struct {
+			*instrumentedStore
+			instrumentedWatcher
+		}{s, w}
+	}
+	return s
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+instrumentedStore - Records the latency and outcome of the operations of a store.
+*/
+type instrumentedStore struct {
+	store  Store
+	config MetricsConfig
+	log    *log.Logger
+	stats  *log.Stats
+}
+
+/*
+observe - Record the outcome of an operation on a document that began at a particular time.
+*/
+func (s *instrumentedStore) observe(op, id string, started time.Time, err error) {
+	took := time.Since(started)
+	s.stats.Timing("store."+op+".latency", took.Seconds())
+	if err != nil {
+		s.stats.Incr("store."+op+".error", 1)
+	} else {
+		s.stats.Incr("store."+op+".success", 1)
+	}
+	if threshold := time.Duration(s.config.SlowThreshold) * time.Millisecond; threshold > 0 && took >= threshold {
+		s.stats.Incr("store."+op+".slow", 1)
+		s.log.Warnf("Slow store %v of document '%v' took %v, error: %v\n", op, id, took, err)
+	}
+}
+
+/*
+Create - Create a document in the wrapped store.
+*/
+func (s *instrumentedStore) Create(doc Document) error {
+	started := time.Now()
+	err := s.store.Create(doc)
+	s.observe("create", doc.ID, started, err)
+	return err
+}
+
+/*
+Update - Update a document of the wrapped store.
+*/
+func (s *instrumentedStore) Update(doc Document) error {
+	started := time.Now()
+	err := s.store.Update(doc)
+	s.observe("update", doc.ID, started, err)
+	return err
+}
+
+/*
+Read - Read a document from the wrapped store.
+*/
+func (s *instrumentedStore) Read(id string) (Document, error) {
+	started := time.Now()
+	doc, err := s.store.Read(id)
+	s.observe("read", id, started, err)
+	return doc, err
+}
+
+/*
+KeepsMetadata - Whether the wrapped store reads back the metadata of documents.
+*/
+func (s *instrumentedStore) KeepsMetadata() bool {
+	return KeepsMetadata(s.store)
+}
+
+/*
+Close - Closes the wrapped store when it implements Closer.
+*/
+func (s *instrumentedStore) Close() error {
+	return Close(s.store)
+}
+
+type instrumentedFenced struct {
+	s *instrumentedStore
+}
+
+func (f instrumentedFenced) UpdateFenced(doc Document, token uint64) error {
+	started := time.Now()
+	err := f.s.store.(FencedUpdater).UpdateFenced(doc, token)
+	f.s.observe("update_fenced", doc.ID, started, err)
+	return err
+}
+
+type instrumentedDeleter struct {
+	s *instrumentedStore
+}
+
+func (d instrumentedDeleter) Delete(id string) error {
+	started := time.Now()
+	err := d.s.store.(Deleter).Delete(id)
+	d.s.observe("delete", id, started, err)
+	return err
+}
+
+type instrumentedLister struct {
+	s *instrumentedStore
+}
+
+func (l instrumentedLister) List() ([]string, error) {
+	started := time.Now()
+	ids, err := l.s.store.(Lister).List()
+	l.s.observe("list", "", started, err)
+	return ids, err
+}
+
+type instrumentedWatcher struct {
+	s *instrumentedStore
+}
+
+func (w instrumentedWatcher) Watch(stop <-chan struct{}) (<-chan string, error) {
+	return w.s.store.(Watcher).Watch(stop)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,92 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jeffail/util/log"
+)
+
+func TestInstrumentedStore(t *testing.T) {
+	logConf := log.DefaultLoggerConfig()
+	logConf.LogLevel = "OFF"
+	logger, stats := log.NewLogger(os.Stdout, logConf), log.NewStats(log.DefaultStatsConfig())
+
+	config := NewConfig()
+	config.StoreDirectory = t.TempDir()
+
+	metricsConf := NewMetricsConfig()
+	if memStore, _ := GetMemoryStore(config); Instrument(memStore, metricsConf, logger, stats) != memStore {
+		t.Error("Disabled instrumentation wrapped the store")
+	}
+	metricsConf.Enabled = true
+
+	for _, storeType := range []string{"memory", "directory"} {
+		config.Type = storeType
+		inner, err := Factory(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrapped := Instrument(inner, metricsConf, logger, stats)
+
+		for name, check := range map[string]func(Store) bool{
+			"FencedUpdater": func(s Store) bool { _, ok := s.(FencedUpdater); return ok },
+			"Deleter":       func(s Store) bool { _, ok := s.(Deleter); return ok },
+			"Lister":        func(s Store) bool { _, ok := s.(Lister); return ok },
+			"Watcher":       func(s Store) bool { _, ok := s.(Watcher); return ok },
+		} {
+			if exp, act := check(inner), check(wrapped); exp != act {
+				t.Errorf("Wrong %v support of instrumented %v store: %v != %v", name, storeType, act, exp)
+			}
+		}
+
+		if err = wrapped.Create(Document{ID: "foo", Content: "hello world"}); err != nil {
+			t.Fatal(err)
+		}
+		if err = wrapped.Update(Document{ID: "foo", Content: "hello universe"}); err != nil {
+			t.Fatal(err)
+		}
+		doc, err := inner.Read("foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Content != "hello universe" {
+			t.Errorf("Wrong content in %v store: %v", storeType, doc.Content)
+		}
+		ids, err := wrapped.(Lister).List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) != 1 || ids[0] != "foo" {
+			t.Errorf("Wrong list of %v store: %v", storeType, ids)
+		}
+		if err = wrapped.(Deleter).Delete("foo"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = wrapped.Read("foo"); err == nil {
+			t.Errorf("Document of %v store was not deleted", storeType)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Migrate func.
+var (
+	ErrStoreNotListable = errors.New("source store is unable to list its documents")
+)
+
+/*
+Lister - Implemented by stores able to enumerate the IDs of every document they hold, which is
+required of the source store of a migration.
+*/
+type Lister interface {
+	// List - Return the IDs of all stored documents.
+	List() ([]string, error)
+}
+
+/*
+MigrationIssue - A problem found with a single document during a migration.
+*/
+type MigrationIssue struct {
+	ID    string `json:"id" yaml:"id"`
+	Issue string `json:"issue" yaml:"issue"`
+}
+
+/*
+MigrationReport - The outcome of a migration, Migrated is always zero for a dry run.
+*/
+type MigrationReport struct {
+	Checked  int              `json:"checked" yaml:"checked"`
+	Migrated int              `json:"migrated" yaml:"migrated"`
+	Issues   []MigrationIssue `json:"issues" yaml:"issues"`
+}
+
+/*
+Migrate - Reads every document of the source store and validates that it is well formed, that it
+round trips through the target codec unchanged and that it would not overwrite a document already
+in the target store. Unless dryRun is set, each document that passes is then written to the target.
+Documents with issues are never written, and are listed in the report instead.
+*/
+func Migrate(source, target Store, targetCodec Codec, dryRun bool) (MigrationReport, error) {
+	report := MigrationReport{Issues: []MigrationIssue{}}
+
+	lister, ok := source.(Lister)
+	if !ok {
+		return report, ErrStoreNotListable
+	}
+	ids, err := lister.List()
+	if err != nil {
+		return report, err
+	}
+
+	for _, id := range ids {
+		report.Checked++
+		doc, err := source.Read(id)
+		if err == nil {
+			err = validateMigration(doc, target, targetCodec)
+		}
+		if err != nil {
+			report.Issues = append(report.Issues, MigrationIssue{ID: id, Issue: err.Error()})
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		if err = target.Create(doc); err != nil {
+			report.Issues = append(report.Issues, MigrationIssue{
+				ID:    id,
+				Issue: fmt.Sprintf("failed to write to target: %v", err),
+			})
+			continue
+		}
+		report.Migrated++
+	}
+	return report, nil
+}
+
+/*
+validateMigration - Check that a document read from the source is safe to write to the target.
+*/
+func validateMigration(doc Document, target Store, targetCodec Codec) error {
+	if len(doc.ID) == 0 {
+		return errors.New("document has an empty ID")
+	}
+	if !utf8.ValidString(doc.Content) {
+		return errors.New("document content is not valid UTF-8")
+	}
+	data, err := targetCodec.Encode(doc)
+	if err != nil {
+		return fmt.Errorf("failed to encode with %v codec: %v", targetCodec.Name(), err)
+	}
+	result, err := targetCodec.Decode(doc.ID, data)
+	if err != nil {
+		return fmt.Errorf("failed to decode with %v codec: %v", targetCodec.Name(), err)
+	}
+	if !sameDocument(result, doc) {
+		return fmt.Errorf("document does not round trip through %v codec", targetCodec.Name())
+	}
+	if _, err = target.Read(doc.ID); err == nil {
+		return errors.New("document already exists in the target store")
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,121 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestMigrateDryRun(t *testing.T) {
+	source, _ := GetMemoryStore(NewConfig())
+	target, _ := GetMemoryStore(NewConfig())
+
+	source.Create(Document{ID: "first", Content: "hello world"})
+	source.Create(Document{ID: "second", Content: "foo bar"})
+	source.Create(Document{ID: "broken", Content: "bad \xff bytes"})
+	source.Create(Document{ID: "taken", Content: "new content"})
+
+	target.Create(Document{ID: "taken", Content: "existing content"})
+
+	codec, _ := CodecFactory("json")
+
+	report, err := Migrate(source, target, codec, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 {
+		t.Errorf("Wrong checked count: %v != %v", report.Checked, 4)
+	}
+	if report.Migrated != 0 {
+		t.Errorf("Dry run migrated documents: %v", report.Migrated)
+	}
+
+	issues := map[string]bool{}
+	for _, issue := range report.Issues {
+		issues[issue.ID] = true
+	}
+	if len(issues) != 2 || !issues["broken"] || !issues["taken"] {
+		t.Errorf("Wrong issues reported: %v", report.Issues)
+	}
+	if _, err = target.Read("first"); err == nil {
+		t.Error("Dry run wrote to the target store")
+	}
+
+	report, err = Migrate(source, target, codec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Migrated != 2 {
+		t.Errorf("Wrong migrated count: %v != %v", report.Migrated, 2)
+	}
+	if doc, err := target.Read("second"); err != nil || doc.Content != "foo bar" {
+		t.Errorf("Migrated document mismatch: %v, %v", doc, err)
+	}
+	if doc, _ := target.Read("taken"); doc.Content != "existing content" {
+		t.Errorf("Migration overwrote existing document: %v", doc.Content)
+	}
+}
+
+func TestMigrateFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.StoreDirectory = dir
+
+	source, err := GetFileStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.Create(Document{ID: "top", Content: "top level"})
+	source.Create(Document{ID: "nested/doc", Content: "nested"})
+
+	target, _ := GetMemoryStore(NewConfig())
+	codec, _ := CodecFactory("msgpack")
+
+	report, err := Migrate(source, target, codec, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Migrated != 2 || len(report.Issues) != 0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+	if doc, err := target.Read("nested/doc"); err != nil || doc.Content != "nested" {
+		t.Errorf("Nested document mismatch: %v, %v", doc, err)
+	}
+
+	if _, err = Migrate(notListable{}, target, codec, true); err != ErrStoreNotListable {
+		t.Errorf("Wrong error for unlistable store: %v", err)
+	}
+}
+
+type notListable struct{}
+
+func (n notListable) Create(Document) error         { return nil }
+func (n notListable) Update(Document) error         { return nil }
+func (n notListable) Read(string) (Document, error) { return Document{}, ErrDocumentNotExist }
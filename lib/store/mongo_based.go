@@ -0,0 +1,403 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/jeffail/leaps/lib/util"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+WriteConcernConfig - The acknowledgement MongoDB must give before a write is considered successful.
+W is the number of members that must acknowledge the write, or when WMode is set a mode such as
+'majority' takes its place. Journal waits for the write to reach the journal, and Timeout is the
+number of milliseconds to wait for acknowledgement before failing, where zero waits forever.
+*/
+type WriteConcernConfig struct {
+	W       int    `json:"w" yaml:"w"`
+	WMode   string `json:"w_mode" yaml:"w_mode"`
+	Journal bool   `json:"journal" yaml:"journal"`
+	Timeout int64  `json:"timeout_ms" yaml:"timeout_ms"`
+}
+
+/*
+NewWriteConcernConfig - Returns a default write concern, acknowledged by the primary only.
+*/
+func NewWriteConcernConfig() WriteConcernConfig {
+	return WriteConcernConfig{
+		W:       1,
+		WMode:   "",
+		Journal: false,
+		Timeout: 0,
+	}
+}
+
+/*
+MongoConfig - The configuration fields for a MongoDB document store solution. URL is a standard
+mongodb:// connection string, Database overrides the database named in the URL, and documents are
+held in Collection with a unique index on their ID. Connections are established through the outbound
+proxy settings, and use TLS when Outbound.TLS is enabled. A non-zero Outbound.Timeout bounds both
+connecting and each operation.
+*/
+type MongoConfig struct {
+	URL          string             `json:"url" yaml:"url"`
+	Database     string             `json:"database" yaml:"database"`
+	Collection   string             `json:"collection" yaml:"collection"`
+	WriteConcern WriteConcernConfig `json:"write_concern" yaml:"write_concern"`
+	Outbound     util.ClientConfig  `json:"outbound" yaml:"outbound"`
+}
+
+/*
+NewMongoConfig - A default MongoDB configuration.
+*/
+func NewMongoConfig() MongoConfig {
+	return MongoConfig{
+		URL:          "",
+		Database:     "",
+		Collection:   "leaps_documents",
+		WriteConcern: NewWriteConcernConfig(),
+		Outbound:     util.NewClientConfig(),
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the MongoStore type.
+var (
+	ErrMongoNoURL = errors.New("attempted to connect to mongo database without a valid URL")
+)
+
+/*
+mongoDocument - The form of a document within the collection, Content is a string for text codecs
+and binary for binary codecs.
+*/
+type mongoDocument struct {
+	ID      string      `bson:"document_id"`
+	Content interface{} `bson:"content"`
+	Fence   uint64      `bson:"fence,omitempty"`
+}
+
+/*
+MongoStore - A document store implementation for a MongoDB collection.
+*/
+type MongoStore struct {
+	config     MongoConfig
+	codec      Codec
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+/*
+encode - Serialise a document into its form within the collection.
+*/
+func (m *MongoStore) encode(doc Document) (mongoDocument, error) {
+	data, err := m.codec.Encode(doc)
+	if err != nil {
+		return mongoDocument{}, err
+	}
+	if m.codec.Binary() {
+		return mongoDocument{ID: doc.ID, Content: data}, nil
+	}
+	return mongoDocument{ID: doc.ID, Content: string(data)}, nil
+}
+
+/*
+decode - Deserialise a document from its form within the collection.
+*/
+func (m *MongoStore) decode(stored mongoDocument) (Document, error) {
+	switch content := stored.Content.(type) {
+	case string:
+		return m.codec.Decode(stored.ID, []byte(content))
+	case primitive.Binary:
+		return m.codec.Decode(stored.ID, content.Data)
+	case []byte:
+		return m.codec.Decode(stored.ID, content)
+	case nil:
+		return m.codec.Decode(stored.ID, nil)
+	}
+	return Document{}, ErrCodecMismatch
+}
+
+/*
+Create - Create a new document in the collection.
+*/
+func (m *MongoStore) Create(doc Document) error {
+	stored, err := m.encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = m.collection.InsertOne(context.Background(), stored)
+	return err
+}
+
+/*
+Update - Update a document in the collection.
+*/
+func (m *MongoStore) Update(doc Document) error {
+	stored, err := m.encode(doc)
+	if err != nil {
+		return err
+	}
+	res, err := m.collection.UpdateOne(
+		context.Background(),
+		bson.M{"document_id": doc.ID},
+		bson.M{"$set": bson.M{"content": stored.Content}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrDocumentNotExist
+	}
+	return nil
+}
+
+/*
+fencedSelector - Selects a document that has not yet accepted a write with a greater fencing token.
+*/
+func fencedSelector(id string, token uint64) bson.M {
+	return bson.M{
+		"document_id": id,
+		"$or": []bson.M{
+			{"fence": bson.M{"$lte": int64(token)}},
+			{"fence": bson.M{"$exists": false}},
+		},
+	}
+}
+
+/*
+UpdateFenced - Update a document in the collection, provided no write with a greater fencing token
+has been accepted for it.
+*/
+func (m *MongoStore) UpdateFenced(doc Document, token uint64) error {
+	stored, err := m.encode(doc)
+	if err != nil {
+		return err
+	}
+	res, err := m.collection.UpdateOne(context.Background(), fencedSelector(doc.ID, token), bson.M{
+		"$set": bson.M{"content": stored.Content, "fence": int64(token)},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	// Nothing matched, either the document does not exist or the token is stale.
+	n, err := m.collection.CountDocuments(context.Background(), bson.M{"document_id": doc.ID})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDocumentNotExist
+	}
+	return ErrStaleFencingToken
+}
+
+/*
+Read - Read a document from the collection.
+*/
+func (m *MongoStore) Read(id string) (Document, error) {
+	var stored mongoDocument
+	err := m.collection.FindOne(context.Background(), bson.M{"document_id": id}).Decode(&stored)
+
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return Document{}, ErrDocumentNotExist
+	case err != nil:
+		return Document{}, err
+	}
+	return m.decode(stored)
+}
+
+/*
+Delete - Remove a document from the collection.
+*/
+func (m *MongoStore) Delete(id string) error {
+	_, err := m.collection.DeleteMany(context.Background(), bson.M{"document_id": id})
+	return err
+}
+
+/*
+KeepsMetadata - Whether metadata is kept, which depends on the codec of the store.
+*/
+func (m *MongoStore) KeepsMetadata() bool {
+	return codecKeepsMetadata(m.codec)
+}
+
+/*
+List - Return the IDs of all documents in the collection.
+*/
+func (m *MongoStore) List() ([]string, error) {
+	ctx := context.Background()
+	cursor, err := m.collection.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"document_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ids := []string{}
+	for cursor.Next(ctx) {
+		var stored mongoDocument
+		if err = cursor.Decode(&stored); err != nil {
+			return nil, err
+		}
+		ids = append(ids, stored.ID)
+	}
+	return ids, cursor.Err()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+mongoWriteConcern - Converts the write concern of the config into that of the driver.
+*/
+func mongoWriteConcern(config WriteConcernConfig) *writeconcern.WriteConcern {
+	concern := &writeconcern.WriteConcern{
+		W:        config.W,
+		WTimeout: time.Duration(config.Timeout) * time.Millisecond,
+	}
+	if len(config.WMode) > 0 {
+		concern.W = config.WMode
+	}
+	if config.Journal {
+		journal := true
+		concern.Journal = &journal
+	}
+	return concern
+}
+
+/*
+mongoDialer - Dials the servers of a deployment through the outbound settings, the driver then
+negotiates TLS over the connections when it is enabled.
+*/
+type mongoDialer struct {
+	dial util.Dialer
+}
+
+/*
+DialContext - Dial a server, giving up once the deadline of the context passes.
+*/
+func (d mongoDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return util.DialTimeout(d.dial, network, addr, time.Until(deadline))
+	}
+	return d.dial(network, addr)
+}
+
+/*
+mongoClientOptions - Parse the URL of the config into the options of a client that establishes
+connections through the outbound settings, and return the database named by the URL.
+*/
+func mongoClientOptions(config MongoConfig) (*options.ClientOptions, string, error) {
+	connString, err := connstring.ParseAndValidate(config.URL)
+	if err != nil {
+		return nil, "", err
+	}
+	opts := options.Client().ApplyURI(config.URL).SetWriteConcern(mongoWriteConcern(config.WriteConcern))
+	if timeout := time.Duration(config.Outbound.Timeout) * time.Millisecond; timeout > 0 {
+		opts.SetConnectTimeout(timeout).SetTimeout(timeout)
+	}
+
+	dial, err := util.NewDialer(config.Outbound)
+	if err != nil {
+		return nil, "", err
+	}
+	opts.SetDialer(mongoDialer{dial: dial})
+
+	tlsConfig, err := util.NewClientTLS(config.Outbound)
+	if err != nil {
+		return nil, "", err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	} else if config.Outbound.TLS.Enabled {
+		opts.SetTLSConfig(&tls.Config{})
+	}
+	return opts, connString.Database, nil
+}
+
+/*
+GetMongoStore - Just a func that returns a MongoStore
+*/
+func GetMongoStore(config Config) (Store, error) {
+	mongoConfig := config.MongoConfig
+	if len(mongoConfig.URL) == 0 {
+		return nil, ErrMongoNoURL
+	}
+	codec, err := CodecFactory(config.Codec)
+	if err != nil {
+		return nil, err
+	}
+	opts, database, err := mongoClientOptions(mongoConfig)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(context.Background(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mongoConfig.Database) > 0 {
+		database = mongoConfig.Database
+	}
+	if len(database) == 0 {
+		database = "leaps"
+	}
+	collection := client.Database(database).Collection(mongoConfig.Collection)
+
+	if _, err = collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "document_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+
+	return &MongoStore{
+		config:     mongoConfig,
+		codec:      codec,
+		client:     client,
+		collection: collection,
+	}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
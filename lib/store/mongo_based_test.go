@@ -0,0 +1,108 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMongoDocumentRoundTrip(t *testing.T) {
+	doc := Document{ID: "doc", Content: "hello 世界", Type: "text"}
+
+	for _, name := range []string{"raw", "json", "msgpack", "protobuf"} {
+		codec, _ := CodecFactory(name)
+		store := &MongoStore{config: NewMongoConfig(), codec: codec}
+
+		stored, err := store.encode(doc)
+		if err != nil {
+			t.Errorf("%v: failed to encode: %v", name, err)
+			continue
+		}
+		data, err := bson.Marshal(stored)
+		if err != nil {
+			t.Errorf("%v: failed to marshal: %v", name, err)
+			continue
+		}
+		var fetched mongoDocument
+		if err = bson.Unmarshal(data, &fetched); err != nil {
+			t.Errorf("%v: failed to unmarshal: %v", name, err)
+			continue
+		}
+		if _, isString := fetched.Content.(string); isString == codec.Binary() {
+			t.Errorf("%v: wrong content type stored: %T", name, fetched.Content)
+		}
+		result, err := store.decode(fetched)
+		if err != nil {
+			t.Errorf("%v: failed to decode: %v", name, err)
+		} else if name == "raw" && result.Content != doc.Content {
+			t.Errorf("%v: round trip mismatch: %v", name, result)
+		} else if name != "raw" && !sameDocument(result, doc) {
+			t.Errorf("%v: round trip mismatch: %v", name, result)
+		}
+	}
+
+	if _, err := (&MongoStore{codec: rawCodec{}}).decode(mongoDocument{Content: 5}); err != ErrCodecMismatch {
+		t.Errorf("Expected codec mismatch, received: %v", err)
+	}
+}
+
+func TestMongoConfig(t *testing.T) {
+	config := NewConfig()
+	config.Type = "mongo"
+	if _, err := Factory(config); err != ErrMongoNoURL {
+		t.Errorf("Expected missing URL error, received: %v", err)
+	}
+
+	concern := NewWriteConcernConfig()
+	concern.WMode, concern.Journal, concern.Timeout = "majority", true, 5000
+	wc := mongoWriteConcern(concern)
+	if wc.W != "majority" || wc.Journal == nil || !*wc.Journal || wc.WTimeout != 5*time.Second {
+		t.Errorf("Wrong write concern: %+v", wc)
+	}
+	if wc = mongoWriteConcern(NewWriteConcernConfig()); wc.W != 1 || wc.Journal != nil {
+		t.Errorf("Wrong default write concern: %+v", wc)
+	}
+
+	config.MongoConfig.URL = "mongodb://db1,db2:27018/docs"
+	opts, database, err := mongoClientOptions(config.MongoConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := 2, len(opts.Hosts); exp != act {
+		t.Errorf("Wrong number of addresses: %v != %v", exp, act)
+	}
+	if exp, act := "docs", database; exp != act {
+		t.Errorf("Wrong database: %v != %v", exp, act)
+	}
+	if _, ok := opts.Dialer.(mongoDialer); !ok {
+		t.Error("Connections are not dialed through the outbound settings")
+	}
+
+	config.MongoConfig.URL = "not a url"
+	if _, _, err = mongoClientOptions(config.MongoConfig); err == nil {
+		t.Error("Expected error from invalid URL")
+	}
+}
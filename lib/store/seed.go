@@ -0,0 +1,157 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+SeedConfig - Holds configuration options for seeding an empty store with documents on startup. Each
+file beneath Directory becomes a document, with its path relative to Directory as the document ID.
+Files larger than MaxFileSize bytes are left out, and hidden files and directories are ignored.
+*/
+type SeedConfig struct {
+	Directory   string `json:"directory" yaml:"directory"`
+	MaxFileSize int64  `json:"max_file_size" yaml:"max_file_size"`
+}
+
+/*
+NewSeedConfig - Returns a default seed configuration, which seeds nothing.
+*/
+func NewSeedConfig() SeedConfig {
+	return SeedConfig{
+		Directory:   "",
+		MaxFileSize: 10 * 1024 * 1024,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Seed func.
+var (
+	ErrStoreNotSeedable = errors.New("store is unable to list its documents, and so cannot be seeded")
+)
+
+/*
+SeedReport - The outcome of seeding a store. Skipped is set when the store already held documents,
+in which case nothing was seeded.
+*/
+type SeedReport struct {
+	Skipped bool             `json:"skipped" yaml:"skipped"`
+	Seeded  int              `json:"seeded" yaml:"seeded"`
+	Issues  []MigrationIssue `json:"issues" yaml:"issues"`
+}
+
+/*
+Seed - Imports every file beneath the seed directory as a document, provided the store holds no
+documents yet. Only stores able to list their documents can tell whether they are empty, and so
+others are refused. When validateID is not nil the ID of each document must pass it, which allows
+the ID policy of the curator to apply to seeded documents as well. Files that cannot be imported
+are listed in the report rather than failing the seed.
+*/
+func Seed(target Store, config SeedConfig, validateID func(id string) error) (SeedReport, error) {
+	report := SeedReport{Issues: []MigrationIssue{}}
+	if len(config.Directory) == 0 {
+		return report, nil
+	}
+
+	lister, ok := target.(Lister)
+	if !ok {
+		return report, ErrStoreNotSeedable
+	}
+	ids, err := lister.List()
+	if err != nil {
+		return report, err
+	}
+	if len(ids) > 0 {
+		report.Skipped = true
+		return report, nil
+	}
+
+	err = filepath.Walk(config.Directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != config.Directory && strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(config.Directory, path)
+		if err != nil {
+			return err
+		}
+		id := filepath.ToSlash(rel)
+		if validateID != nil {
+			if err := validateID(id); err != nil {
+				report.Issues = append(report.Issues, MigrationIssue{ID: id, Issue: err.Error()})
+				return nil
+			}
+		}
+		if err := seedFile(target, id, path, info, config); err != nil {
+			report.Issues = append(report.Issues, MigrationIssue{ID: id, Issue: err.Error()})
+			return nil
+		}
+		report.Seeded++
+		return nil
+	})
+	return report, err
+}
+
+/*
+seedFile - Create a document from the content of a file.
+*/
+func seedFile(target Store, id, path string, info os.FileInfo, config SeedConfig) error {
+	if config.MaxFileSize > 0 && info.Size() > config.MaxFileSize {
+		return fmt.Errorf("file exceeds the maximum size of %v bytes", config.MaxFileSize)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !utf8.Valid(content) {
+		return errors.New("file content is not valid UTF-8")
+	}
+	if err = target.Create(Document{ID: id, Content: string(content)}); err != nil {
+		return fmt.Errorf("failed to write to store: %v", err)
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"welcome.md":           "# Welcome",
+		"lessons/one/intro.go": "package main",
+		".hidden/secret":       "nope",
+		"binary.bin":           "\xff\xfe",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), os.ModePerm)
+		if err = ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target, _ := GetMemoryStore(NewConfig())
+	config := NewSeedConfig()
+	config.Directory = dir
+
+	errReserved := errors.New("reserved")
+	validateID := func(id string) error {
+		if strings.HasPrefix(id, "lessons/") {
+			return errReserved
+		}
+		return nil
+	}
+
+	report, err := Seed(target, config, validateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped || report.Seeded != 1 {
+		t.Errorf("Wrong report: %+v", report)
+	}
+	if len(report.Issues) != 2 {
+		t.Errorf("Wrong issues: %v", report.Issues)
+	}
+	if _, err = target.Read("lessons/one/intro.go"); err == nil {
+		t.Error("Expected document breaking the ID policy to be left out")
+	}
+
+	target, _ = GetMemoryStore(NewConfig())
+	if report, err = Seed(target, config, nil); err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped || report.Seeded != 2 {
+		t.Errorf("Wrong report: %+v", report)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].ID != "binary.bin" {
+		t.Errorf("Wrong issues: %v", report.Issues)
+	}
+	if doc, err := target.Read("lessons/one/intro.go"); err != nil || doc.Content != "package main" {
+		t.Errorf("Wrong seeded document: %v, %v", doc, err)
+	}
+	if _, err = target.Read(".hidden/secret"); err == nil {
+		t.Error("Expected hidden file to be ignored")
+	}
+
+	// Stores that already hold documents are left alone
+	ioutil.WriteFile(filepath.Join(dir, "later.txt"), []byte("later"), 0666)
+	if report, err = Seed(target, config, nil); err != nil || !report.Skipped || report.Seeded != 0 {
+		t.Errorf("Expected seed to be skipped: %+v, %v", report, err)
+	}
+}
@@ -23,12 +23,18 @@ THE SOFTWARE.
 package store
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	// Blank because SQL driver
-	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/lib/pq"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jeffail/leaps/lib/util"
+	"github.com/lib/pq"
 )
 
 /*--------------------------------------------------------------------------------------------------
@@ -41,6 +47,7 @@ type TableConfig struct {
 	Name       string `json:"table" yaml:"table"`
 	IDCol      string `json:"id_column" yaml:"id_column"`
 	ContentCol string `json:"content_column" yaml:"content_column"`
+	FenceCol   string `json:"fence_column" yaml:"fence_column"`
 }
 
 /*
@@ -51,15 +58,24 @@ func NewTableConfig() TableConfig {
 		Name:       "leaps_documents",
 		IDCol:      "ID",
 		ContentCol: "CONTENT",
+		FenceCol:   "FENCE",
 	}
 }
 
 /*
-SQLConfig - The configuration fields for an SQL document store solution.
+SQLConfig - The configuration fields for an SQL document store solution. When AutoMigrate is set the
+document table is created, and its schema kept up to date, on startup. The connection pool is
+bounded by MaxOpenConns and MaxIdleConns, where zero means unlimited and the database/sql default
+respectively, and connections are recycled after ConnMaxLifetime seconds when it is non-zero.
 */
 type SQLConfig struct {
-	DSN         string      `json:"dsn" yaml:"dsn"`
-	TableConfig TableConfig `json:"db_table" yaml:"db_table"`
+	DSN             string            `json:"dsn" yaml:"dsn"`
+	TableConfig     TableConfig       `json:"db_table" yaml:"db_table"`
+	Outbound        util.ClientConfig `json:"outbound" yaml:"outbound"`
+	AutoMigrate     bool              `json:"auto_migrate" yaml:"auto_migrate"`
+	MaxOpenConns    int               `json:"max_open_connections" yaml:"max_open_connections"`
+	MaxIdleConns    int               `json:"max_idle_connections" yaml:"max_idle_connections"`
+	ConnMaxLifetime int64             `json:"connection_max_lifetime_s" yaml:"connection_max_lifetime_s"`
 }
 
 /*
@@ -67,8 +83,13 @@ NewSQLConfig - A default SQL configuration.
 */
 func NewSQLConfig() SQLConfig {
 	return SQLConfig{
-		DSN:         "",
-		TableConfig: NewTableConfig(),
+		DSN:             "",
+		TableConfig:     NewTableConfig(),
+		Outbound:        util.NewClientConfig(),
+		AutoMigrate:     false,
+		MaxOpenConns:    0,
+		MaxIdleConns:    0,
+		ConnMaxLifetime: 0,
 	}
 }
 
@@ -80,17 +101,30 @@ SQLStore - A document store implementation for an SQL database.
 */
 type SQLStore struct {
 	config     Config
+	codec      Codec
 	db         *sql.DB
 	createStmt *sql.Stmt
 	updateStmt *sql.Stmt
 	readStmt   *sql.Stmt
+	listStmt   *sql.Stmt
+	deleteStmt *sql.Stmt
+
+	// The fenced update statement is prepared on first use, as only the binders of clustered
+	// deployments issue fenced updates. The fence column itself is part of every migrated schema.
+	fencedOnce sync.Once
+	fencedStmt *sql.Stmt
+	fencedErr  error
 }
 
 /*
 Create - Create a new document in a database table.
 */
 func (m *SQLStore) Create(doc Document) error {
-	_, err := m.createStmt.Exec(doc.ID, doc.Content)
+	content, err := m.encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = m.createStmt.Exec(doc.ID, content)
 	return err
 }
 
@@ -98,18 +132,76 @@ func (m *SQLStore) Create(doc Document) error {
 Update - Update document in a database table.
 */
 func (m *SQLStore) Update(doc Document) error {
-	_, err := m.updateStmt.Exec(doc.Content, doc.ID)
+	content, err := m.encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = m.updateStmt.Exec(content, doc.ID)
 	return err
 }
 
+/*
+UpdateFenced - Update document in a database table, provided the fencing token is not stale. The
+fence column of the table holds the greatest token accepted for each document.
+*/
+func (m *SQLStore) UpdateFenced(doc Document, token uint64) error {
+	m.fencedOnce.Do(func() {
+		updateStr := "UPDATE %v SET %v = ?, %v = ? WHERE %v = ? AND %v <= ?"
+		if m.config.Type == "postgres" {
+			updateStr = "UPDATE %v SET %v = $1, %v = $2 WHERE %v = $3 AND %v <= $4"
+		}
+		table := m.config.SQLConfig.TableConfig
+		m.fencedStmt, m.fencedErr = m.db.Prepare(fmt.Sprintf(updateStr,
+			table.Name, table.ContentCol, table.FenceCol, table.IDCol, table.FenceCol,
+		))
+		if m.fencedErr != nil {
+			m.fencedErr = fmt.Errorf("failed to prepare fenced update statement: %v", m.fencedErr)
+		}
+	})
+	if m.fencedErr != nil {
+		return m.fencedErr
+	}
+
+	content, err := m.encode(doc)
+	if err != nil {
+		return err
+	}
+	res, err := m.fencedStmt.Exec(content, int64(token), doc.ID, int64(token))
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows > 0 {
+		return err
+	}
+
+	/* No rows were affected, which means the document is gone, the token is stale, or on mysql that
+	 * the row already matched what we wrote.
+	 */
+	selectStr := "SELECT %v FROM %v WHERE %v = ?"
+	if m.config.Type == "postgres" {
+		selectStr = "SELECT %v FROM %v WHERE %v = $1"
+	}
+	table := m.config.SQLConfig.TableConfig
+	var fence int64
+	err = m.db.QueryRow(fmt.Sprintf(selectStr, table.FenceCol, table.Name, table.IDCol), doc.ID).Scan(&fence)
+	switch {
+	case err == sql.ErrNoRows:
+		return ErrDocumentNotExist
+	case err != nil:
+		return err
+	case uint64(fence) > token:
+		return ErrStaleFencingToken
+	}
+	return nil
+}
+
 /*
 Read - Read document from a database table.
 */
 func (m *SQLStore) Read(id string) (Document, error) {
-	var document Document
-	document.ID = id
+	var content []byte
 
-	err := m.readStmt.QueryRow(id).Scan(&document.Content)
+	err := m.readStmt.QueryRow(id).Scan(&content)
 
 	switch {
 	case err == sql.ErrNoRows:
@@ -117,7 +209,151 @@ func (m *SQLStore) Read(id string) (Document, error) {
 	case err != nil:
 		return Document{}, err
 	}
-	return document, nil
+	return m.codec.Decode(id, content)
+}
+
+/*
+Delete - Remove a document from a database table.
+*/
+func (m *SQLStore) Delete(id string) error {
+	_, err := m.deleteStmt.Exec(id)
+	return err
+}
+
+/*
+KeepsMetadata - Whether metadata is kept, which depends on the codec of the store.
+*/
+func (m *SQLStore) KeepsMetadata() bool {
+	return codecKeepsMetadata(m.codec)
+}
+
+/*
+List - Return the IDs of all documents in the database table.
+*/
+func (m *SQLStore) List() ([]string, error) {
+	rows, err := m.listStmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []string{}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+/*
+encode - Serialise a document for a content column. Text codecs are passed as strings so that text
+columns are unaffected, binary codecs are passed as bytes and require a BLOB or BYTEA column.
+*/
+func (m *SQLStore) encode(doc Document) (interface{}, error) {
+	data, err := m.codec.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	if m.codec.Binary() {
+		return data, nil
+	}
+	return string(data), nil
+}
+
+/*
+pqDialer - Adapts an outbound dialer to the dialer interface of the postgres driver.
+*/
+type pqDialer util.Dialer
+
+func (d pqDialer) Dial(network, address string) (net.Conn, error) {
+	return d(network, address)
+}
+
+func (d pqDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return util.DialTimeout(util.Dialer(d), network, address, timeout)
+}
+
+/*
+pqConnector - A postgres connector that opens connections through an outbound dialer.
+*/
+type pqConnector struct {
+	dsn    string
+	dialer pqDialer
+}
+
+func (c pqConnector) Connect(context.Context) (driver.Conn, error) {
+	return pq.DialOpen(c.dialer, c.dsn)
+}
+
+func (c pqConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+/*
+mysqlRegistrations - Counts the dial functions and TLS configs registered with the MySQL driver,
+which keeps them by name for the whole process, so that each store registers its own.
+*/
+var mysqlRegistrations int64
+
+/*
+mysqlDSN - Returns the DSN of a MySQL store with its connections established through the outbound
+dialer, and secured with the outbound TLS settings unless the DSN names its own tls parameter.
+*/
+func mysqlDSN(config Config, dial util.Dialer) (string, error) {
+	dsnConfig, err := mysql.ParseDSN(config.SQLConfig.DSN)
+	if err != nil {
+		return "", err
+	}
+	tlsConfig, err := util.NewClientTLS(config.SQLConfig.Outbound)
+	if err != nil {
+		return "", err
+	}
+	if tlsConfig != nil && len(dsnConfig.TLSConfig) == 0 {
+		name := fmt.Sprintf("leaps_outbound_%v", atomic.AddInt64(&mysqlRegistrations, 1))
+		if err = mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+			return "", err
+		}
+		dsnConfig.TLSConfig = name
+	}
+	// Unix sockets are local and have no use for a proxy.
+	if dsnConfig.Net == "tcp" {
+		name := fmt.Sprintf("leaps_outbound_%v", atomic.AddInt64(&mysqlRegistrations, 1))
+		mysql.RegisterDial(name, func(addr string) (net.Conn, error) {
+			return dial("tcp", addr)
+		})
+		dsnConfig.Net = name
+	}
+	return dsnConfig.FormatDSN(), nil
+}
+
+/*
+openSQLDB - Opens a database handle, where connections are established through the outbound proxy
+settings of the config. MySQL connections also use the outbound TLS settings unless the DSN names a
+tls parameter of its own, whereas postgres reads its certificates from the sslcert, sslkey and
+sslrootcert parameters of the DSN on each connection.
+*/
+func openSQLDB(config Config) (*sql.DB, error) {
+	dial, err := util.NewDialer(config.SQLConfig.Outbound)
+	if err != nil {
+		return nil, err
+	}
+
+	switch config.Type {
+	case "postgres":
+		return sql.OpenDB(pqConnector{dsn: config.SQLConfig.DSN, dialer: pqDialer(dial)}), nil
+	case "mysql":
+		dsn, err := mysqlDSN(config, dial)
+		if err != nil {
+			return nil, err
+		}
+		return sql.Open(config.Type, dsn)
+	case "sqlite":
+		return sql.Open("sqlite3", config.SQLConfig.DSN)
+	}
+	return sql.Open(config.Type, config.SQLConfig.DSN)
 }
 
 /*
@@ -125,19 +361,35 @@ GetSQLStore - Just a func that returns an SQLStore
 */
 func GetSQLStore(config Config) (Store, error) {
 	var (
-		db                            *sql.DB
-		createStr, updateStr, readStr string
-		create, update, read          *sql.Stmt
-		err                           error
+		db                                       *sql.DB
+		createStr, updateStr, readStr, deleteStr string
+		create, update, read, list, del          *sql.Stmt
+		err                                      error
 	)
 	if len(config.SQLConfig.DSN) == 0 {
 		return nil, fmt.Errorf("attempted to connect to %v database without a valid DSN", config.Type)
 	}
-	db, err = sql.Open(config.Type, config.SQLConfig.DSN)
+	codec, err := CodecFactory(config.Codec)
+	if err != nil {
+		return nil, err
+	}
+	db, err = openSQLDB(config)
 	if err != nil {
 		return nil, err
 	}
 
+	db.SetMaxOpenConns(config.SQLConfig.MaxOpenConns)
+	if config.SQLConfig.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.SQLConfig.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(time.Duration(config.SQLConfig.ConnMaxLifetime) * time.Second)
+
+	if config.SQLConfig.AutoMigrate {
+		if err = migrateSQLSchema(db, config, codec); err != nil {
+			return nil, err
+		}
+	}
+
 	/* Now we set up prepared statements. This ensures at initialization that we can successfully
 	 * connect to the database.
 	 */
@@ -147,10 +399,12 @@ func GetSQLStore(config Config) (Store, error) {
 		createStr = "INSERT INTO %v (%v, %v) VALUES ($1, $2)"
 		updateStr = "UPDATE %v SET %v = $1 WHERE %v = $2"
 		readStr = "SELECT %v FROM %v WHERE %v = $1"
+		deleteStr = "DELETE FROM %v WHERE %v = $1"
 	default:
 		createStr = "INSERT INTO %v (%v, %v) VALUES (?, ?)"
 		updateStr = "UPDATE %v SET %v = ? WHERE %v = ?"
 		readStr = "SELECT %v FROM %v WHERE %v = ?"
+		deleteStr = "DELETE FROM %v WHERE %v = ?"
 	}
 
 	create, err = db.Prepare(fmt.Sprintf(createStr,
@@ -177,13 +431,30 @@ func GetSQLStore(config Config) (Store, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare get statement: %v", err)
 	}
+	list, err = db.Prepare(fmt.Sprintf("SELECT %v FROM %v",
+		config.SQLConfig.TableConfig.IDCol,
+		config.SQLConfig.TableConfig.Name,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare list statement: %v", err)
+	}
+	del, err = db.Prepare(fmt.Sprintf(deleteStr,
+		config.SQLConfig.TableConfig.Name,
+		config.SQLConfig.TableConfig.IDCol,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare delete statement: %v", err)
+	}
 
 	return &SQLStore{
 		db:         db,
 		config:     config,
+		codec:      codec,
 		createStmt: create,
 		updateStmt: update,
 		readStmt:   read,
+		listStmt:   list,
+		deleteStmt: del,
 	}, nil
 }
 
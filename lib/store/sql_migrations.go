@@ -0,0 +1,123 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+sqlSchemaMigrations - Returns the ordered schema migrations for the configured database, where the
+version of each migration is its index plus one. Migrations are append only, never edit or remove
+an existing entry since databases in the wild have already applied it.
+*/
+func sqlSchemaMigrations(config Config, codec Codec) []string {
+	table := config.SQLConfig.TableConfig
+
+	idType, contentType := "VARCHAR(255)", "TEXT"
+	switch config.Type {
+	case "postgres":
+		if codec.Binary() {
+			contentType = "BYTEA"
+		}
+	case "mysql":
+		contentType = "LONGTEXT"
+		if codec.Binary() {
+			contentType = "LONGBLOB"
+		}
+	case "sqlite":
+		if codec.Binary() {
+			contentType = "BLOB"
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v %v PRIMARY KEY, %v %v NOT NULL)",
+			table.Name, table.IDCol, idType, table.ContentCol, contentType),
+		// Holds the greatest fencing token accepted for each document, see UpdateFenced.
+		fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v BIGINT NOT NULL DEFAULT 0",
+			table.Name, table.FenceCol),
+	}
+}
+
+/*
+sqlVersionTable - Returns the name of the table used to track applied schema migrations.
+*/
+func sqlVersionTable(config Config) string {
+	return config.SQLConfig.TableConfig.Name + "_schema_version"
+}
+
+/*
+migrateSQLSchema - Brings the schema of the database up to date by applying each migration that has
+not yet been recorded in the version table. Each migration and its version record are applied
+within a transaction, which on postgres also covers the schema change itself.
+*/
+func migrateSQLSchema(db *sql.DB, config Config, codec Codec) error {
+	versionTable := sqlVersionTable(config)
+
+	if _, err := db.Exec(fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %v (version INTEGER NOT NULL)", versionTable,
+	)); err != nil {
+		return fmt.Errorf("failed to create schema version table: %v", err)
+	}
+
+	var current sql.NullInt64
+	if err := db.QueryRow(fmt.Sprintf("SELECT MAX(version) FROM %v", versionTable)).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+
+	insertStr := "INSERT INTO %v (version) VALUES (?)"
+	if config.Type == "postgres" {
+		insertStr = "INSERT INTO %v (version) VALUES ($1)"
+	}
+
+	for i, migration := range sqlSchemaMigrations(config, codec) {
+		version := int64(i + 1)
+		if version <= current.Int64 {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec(migration); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply schema migration %v: %v", version, err)
+		}
+		if _, err = tx.Exec(fmt.Sprintf(insertStr, versionTable), version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record schema migration %v: %v", version, err)
+		}
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit schema migration %v: %v", version, err)
+		}
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestSQLSchemaMigrations(t *testing.T) {
+	config := NewConfig()
+	config.Type = "postgres"
+	config.SQLConfig.TableConfig.Name = "docs"
+	config.SQLConfig.TableConfig.IDCol = "id"
+	config.SQLConfig.TableConfig.ContentCol = "content"
+
+	raw, _ := CodecFactory("raw")
+	proto, _ := CodecFactory("protobuf")
+
+	expected := "CREATE TABLE IF NOT EXISTS docs (id VARCHAR(255) PRIMARY KEY, content TEXT NOT NULL)"
+	if migrations := sqlSchemaMigrations(config, raw); migrations[0] != expected {
+		t.Errorf("Wrong postgres migration: %v != %v", migrations[0], expected)
+	}
+	if migrations := sqlSchemaMigrations(config, proto); !strings.Contains(migrations[0], "content BYTEA") {
+		t.Errorf("Binary codec did not use a binary column: %v", migrations[0])
+	}
+
+	config.Type = "mysql"
+	if migrations := sqlSchemaMigrations(config, raw); !strings.Contains(migrations[0], "content LONGTEXT") {
+		t.Errorf("Wrong mysql content column: %v", migrations[0])
+	}
+	if migrations := sqlSchemaMigrations(config, proto); !strings.Contains(migrations[0], "content LONGBLOB") {
+		t.Errorf("Wrong mysql binary content column: %v", migrations[0])
+	}
+
+	config.Type = "sqlite"
+	if migrations := sqlSchemaMigrations(config, proto); !strings.Contains(migrations[0], "content BLOB") {
+		t.Errorf("Wrong sqlite binary content column: %v", migrations[0])
+	}
+
+	config.SQLConfig.TableConfig.FenceCol = "fence"
+	if migrations := sqlSchemaMigrations(config, raw); migrations[1] != "ALTER TABLE docs ADD COLUMN fence BIGINT NOT NULL DEFAULT 0" {
+		t.Errorf("Wrong fence column migration: %v", migrations[1])
+	}
+
+	if table := sqlVersionTable(config); table != "docs_schema_version" {
+		t.Errorf("Wrong version table: %v", table)
+	}
+}
+
+func TestMySQLDSN(t *testing.T) {
+	config := NewConfig()
+	config.Type = "mysql"
+	config.SQLConfig.Outbound.TLS.ServerName = "db.internal"
+
+	dial := func(network, addr string) (net.Conn, error) {
+		return nil, errors.New("not dialled")
+	}
+
+	config.SQLConfig.DSN = "leaps:pass@tcp(db:3306)/leaps"
+	first, err := mysqlDSN(config, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := mysqlDSN(config, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("Stores share a registered dialer and TLS config: %v", first)
+	}
+	if !strings.Contains(first, "tls=leaps_outbound_") {
+		t.Errorf("Outbound TLS config was not used: %v", first)
+	}
+
+	config.SQLConfig.DSN = "leaps:pass@tcp(db:3306)/leaps?tls=skip-verify"
+	dsn, err := mysqlDSN(config, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(dsn, "tls=skip-verify") {
+		t.Errorf("TLS parameter of the DSN was overridden: %v", dsn)
+	}
+}
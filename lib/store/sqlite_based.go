@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+SQLiteConfig - The configuration fields for an embedded SQLite document store, which keeps every
+document in the single database file at Path. The table is taken from the db_table fields of the SQL
+config and is always created on startup. BusyTimeout is how many milliseconds a write waits on a
+locked database before failing.
+*/
+type SQLiteConfig struct {
+	Path        string `json:"path" yaml:"path"`
+	BusyTimeout int64  `json:"busy_timeout_ms" yaml:"busy_timeout_ms"`
+}
+
+/*
+NewSQLiteConfig - A default SQLite configuration.
+*/
+func NewSQLiteConfig() SQLiteConfig {
+	return SQLiteConfig{
+		Path:        "leaps.db",
+		BusyTimeout: 5000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the SQLite store.
+var (
+	ErrSQLiteNoPath   = errors.New("attempted to open sqlite database without a file path")
+	ErrSQLiteNotBuilt = errors.New("sqlite store requires building with cgo and the sqlite tag")
+)
+
+/*
+sqliteDSN - Returns the connection string for a database file. The write-ahead log lets documents be
+read while another is being flushed, and full synchronisation means that a flush has reached the
+disk by the time it returns.
+*/
+func sqliteDSN(config SQLiteConfig) string {
+	params := url.Values{}
+	params.Set("_journal_mode", "WAL")
+	params.Set("_synchronous", "FULL")
+	params.Set("_busy_timeout", fmt.Sprintf("%v", config.BusyTimeout))
+	return fmt.Sprintf("file:%v?%v", config.Path, params.Encode())
+}
+
+/*
+GetSQLiteStore - Returns an SQLStore backed by an embedded SQLite database file. SQLite allows only a
+single writer at a time, and so the store holds one connection which all binders share. The driver
+requires cgo, and so is only built in with the sqlite build tag, otherwise ErrSQLiteNotBuilt is
+returned.
+*/
+func GetSQLiteStore(config Config) (Store, error) {
+	if len(config.SQLiteConfig.Path) == 0 {
+		return nil, ErrSQLiteNoPath
+	}
+	if !sqliteBuilt {
+		return nil, ErrSQLiteNotBuilt
+	}
+	config.SQLConfig.DSN = sqliteDSN(config.SQLiteConfig)
+	config.SQLConfig.AutoMigrate = true
+	config.SQLConfig.MaxOpenConns = 1
+	config.SQLConfig.MaxIdleConns = 1
+	config.SQLConfig.ConnMaxLifetime = 0
+	return GetSQLStore(config)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
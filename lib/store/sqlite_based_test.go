@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := NewConfig()
+	config.Type = "sqlite"
+	config.SQLiteConfig.Path = ""
+	if _, err = Factory(config); err != ErrSQLiteNoPath {
+		t.Errorf("Expected missing path error, received: %v", err)
+	}
+
+	config.SQLiteConfig.Path = filepath.Join(dir, "leaps.db")
+	store, err := Factory(config)
+	if !sqliteBuilt {
+		if err != ErrSQLiteNotBuilt {
+			t.Errorf("Expected sqlite not built error, received: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Create(Document{ID: "first", Content: "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Create(Document{ID: "second", Content: "goodbye"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Update(Document{ID: "first", Content: "hello, world"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.(FencedUpdater).UpdateFenced(Document{ID: "second", Content: "fenced"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.(FencedUpdater).UpdateFenced(Document{ID: "second", Content: "stale"}, 1); err != ErrStaleFencingToken {
+		t.Errorf("Expected stale fencing token, received: %v", err)
+	}
+	if err = store.(Deleter).Delete("second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.Read("second"); err != ErrDocumentNotExist {
+		t.Errorf("Expected deleted document to be gone, received: %v", err)
+	}
+	store.(*SQLStore).db.Close()
+
+	// Documents must survive the store being reopened
+	if store, err = Factory(config); err != nil {
+		t.Fatal(err)
+	}
+	defer store.(*SQLStore).db.Close()
+
+	doc, err := store.Read("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "hello, world", doc.Content; exp != act {
+		t.Errorf("Wrong content after reopening: %v != %v", exp, act)
+	}
+	ids, err := store.(Lister).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "first" {
+		t.Errorf("Wrong documents listed: %v", ids)
+	}
+}
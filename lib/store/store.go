@@ -23,21 +23,34 @@ THE SOFTWARE.
 package store
 
 import (
+	"bytes"
+	"compress/gzip"
 	"errors"
+	"io/ioutil"
 	"sync"
+	"time"
 )
 
 /*--------------------------------------------------------------------------------------------------
  */
 
 /*
-Config - Holds generic configuration options for a document storage solution.
+Config - Holds generic configuration options for a document storage solution. Codec selects how the
+persistent stores serialise documents, one of raw (content only), json, msgpack or protobuf. Seed
+provisions the store with documents from a directory on first start. Metrics instruments the
+operations of the store, see Instrument.
 */
 type Config struct {
-	Type           string    `json:"type" yaml:"type"`
-	Name           string    `json:"name" yaml:"name"`
-	StoreDirectory string    `json:"store_directory" yaml:"store_directory"`
-	SQLConfig      SQLConfig `json:"sql" yaml:"sql"`
+	Type           string        `json:"type" yaml:"type"`
+	Name           string        `json:"name" yaml:"name"`
+	StoreDirectory string        `json:"store_directory" yaml:"store_directory"`
+	Codec          string        `json:"codec" yaml:"codec"`
+	SQLConfig      SQLConfig     `json:"sql" yaml:"sql"`
+	MongoConfig    MongoConfig   `json:"mongo" yaml:"mongo"`
+	SQLiteConfig   SQLiteConfig  `json:"sqlite" yaml:"sqlite"`
+	MemoryConfig   MemoryConfig  `json:"memory" yaml:"memory"`
+	Seed           SeedConfig    `json:"seed" yaml:"seed"`
+	Metrics        MetricsConfig `json:"metrics" yaml:"metrics"`
 }
 
 /*
@@ -48,7 +61,13 @@ func NewConfig() Config {
 		Type:           "memory",
 		Name:           "",
 		StoreDirectory: "",
+		Codec:          "raw",
 		SQLConfig:      NewSQLConfig(),
+		MongoConfig:    NewMongoConfig(),
+		SQLiteConfig:   NewSQLiteConfig(),
+		MemoryConfig:   NewMemoryConfig(),
+		Seed:           NewSeedConfig(),
+		Metrics:        NewMetricsConfig(),
 	}
 }
 
@@ -58,6 +77,7 @@ func NewConfig() Config {
 // Errors for the  type.
 var (
 	ErrInvalidDocumentType = errors.New("invalid document store type")
+	ErrStaleFencingToken   = errors.New("write was rejected due to a stale fencing token")
 )
 
 /*
@@ -75,6 +95,76 @@ type Store interface {
 	Read(ID string) (Document, error)
 }
 
+/*
+FencedUpdater - Implemented by stores able to make conditional writes, which guard against a node
+that lost its lease over a document overwriting the flushes of the node that now holds it. Fencing
+tokens must increase each time a lease is granted, a store accepts a write carrying a token at least
+as great as the greatest it has accepted for the document so far and otherwise rejects it with
+ErrStaleFencingToken.
+*/
+type FencedUpdater interface {
+	// UpdateFenced - Update an existing document, provided the fencing token is not stale.
+	UpdateFenced(doc Document, token uint64) error
+}
+
+/*
+Deleter - Implemented by stores able to permanently remove a document.
+*/
+type Deleter interface {
+	// Delete - Remove a document, removing a document that does not exist is not an error.
+	Delete(ID string) error
+}
+
+/*
+Watcher - Implemented by stores able to notice documents being modified by something other than
+leaps, such as the files of a directory being edited by other tools.
+*/
+type Watcher interface {
+	/* Watch - Returns a channel that receives the ID of each document that may have been modified,
+	 * which includes modifications made through the store itself. Watching stops, and the channel is
+	 * closed, once stop is closed.
+	 */
+	Watch(stop <-chan struct{}) (<-chan string, error)
+}
+
+/*
+Closer - Implemented by stores that hold resources, such as background goroutines, which must be
+released once the store is no longer used.
+*/
+type Closer interface {
+	// Close - Release the resources of the store, the store must not be used afterwards.
+	Close() error
+}
+
+/*
+Close - Closes a store that implements Closer, other stores have nothing to release.
+*/
+func Close(s Store) error {
+	if closer, ok := s.(Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+/*
+MetadataKeeper - Implemented by stores that may be configured to drop the metadata of documents,
+such as those storing documents with the raw codec. Stores that do not implement it keep metadata.
+*/
+type MetadataKeeper interface {
+	// KeepsMetadata - Whether the metadata of a document written is read back again.
+	KeepsMetadata() bool
+}
+
+/*
+KeepsMetadata - Returns whether a store reads back the metadata of the documents written to it.
+*/
+func KeepsMetadata(s Store) bool {
+	if keeper, ok := s.(MetadataKeeper); ok {
+		return keeper.KeepsMetadata()
+	}
+	return true
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
 
@@ -85,16 +175,47 @@ func Factory(config Config) (Store, error) {
 	switch config.Type {
 	case "file":
 		return GetFileStore(config)
+	case "directory":
+		return GetDirectoryStore(config)
 	case "memory":
 		return GetMemoryStore(config)
 	case "mock":
 		return GetMockStore(config)
 	case "mysql", "postgres":
 		return GetSQLStore(config)
+	case "mongo":
+		return GetMongoStore(config)
+	case "sqlite":
+		return GetSQLiteStore(config)
 	}
 	return nil, ErrInvalidDocumentType
 }
 
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+MemoryConfig - Holds configuration options specific to the memory store. When compression is enabled
+documents that have not been touched for IdlePeriod seconds and are at least MinSize bytes long are
+gzipped in place, and transparently decompressed the next time they are read.
+*/
+type MemoryConfig struct {
+	Compress   bool  `json:"compress" yaml:"compress"`
+	MinSize    int   `json:"compress_min_size" yaml:"compress_min_size"`
+	IdlePeriod int64 `json:"compress_idle_period_s" yaml:"compress_idle_period_s"`
+}
+
+/*
+NewMemoryConfig - Returns a default memory store configuration, compression is disabled.
+*/
+func NewMemoryConfig() MemoryConfig {
+	return MemoryConfig{
+		Compress:   false,
+		MinSize:    4096,
+		IdlePeriod: 60,
+	}
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
 
@@ -103,13 +224,28 @@ var (
 	ErrDocumentNotExist = errors.New("attempting to fetch memory store that has not been initialized")
 )
 
+/*
+memoryDocument - A document held by the memory store, the content is either held in its plain form
+or, when compressed is non-nil, as a gzipped blob.
+*/
+type memoryDocument struct {
+	doc        Document
+	compressed []byte
+	touched    time.Time
+}
+
 /*
 MemoryStore - Most basic implementation of , simply keeps the document in memory. Has
 zero persistence across sessions.
 */
 type MemoryStore struct {
-	documents map[string]Document
+	config    MemoryConfig
+	documents map[string]*memoryDocument
+	fences    map[string]uint64
 	mutex     sync.RWMutex
+
+	closeChan chan struct{}
+	closeOnce sync.Once
 }
 
 /*
@@ -126,31 +262,203 @@ func (s *MemoryStore) Update(doc Document) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.documents[doc.ID] = doc
+	s.documents[doc.ID] = &memoryDocument{doc: doc, touched: time.Now()}
 	return nil
 }
 
 /*
-Read - Read document from memory.
+UpdateFenced - Update document in memory, provided the fencing token is not stale.
 */
-func (s *MemoryStore) Read(id string) (Document, error) {
+func (s *MemoryStore) UpdateFenced(doc Document, token uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if token < s.fences[doc.ID] {
+		return ErrStaleFencingToken
+	}
+	s.fences[doc.ID] = token
+	s.documents[doc.ID] = &memoryDocument{doc: doc, touched: time.Now()}
+	return nil
+}
+
+/*
+List - Return the IDs of all documents in memory.
+*/
+func (s *MemoryStore) List() ([]string, error) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	doc, ok := s.documents[id]
+	ids := make([]string, 0, len(s.documents))
+	for id := range s.documents {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+/*
+Delete - Remove a document from memory.
+*/
+func (s *MemoryStore) Delete(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.documents, id)
+	return nil
+}
+
+/*
+Read - Read document from memory.
+*/
+func (s *MemoryStore) Read(id string) (Document, error) {
+	s.mutex.RLock()
+	mDoc, ok := s.documents[id]
 	if !ok {
-		return doc, ErrDocumentNotExist
+		s.mutex.RUnlock()
+		return Document{}, ErrDocumentNotExist
+	}
+	if mDoc.compressed == nil {
+		defer s.mutex.RUnlock()
+		return mDoc.doc, nil
+	}
+	s.mutex.RUnlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Document may have been decompressed or replaced since we released the read lock.
+	if mDoc, ok = s.documents[id]; !ok {
+		return Document{}, ErrDocumentNotExist
+	}
+	if mDoc.compressed != nil {
+		content, err := DecompressContent(mDoc.compressed)
+		if err != nil {
+			return Document{}, err
+		}
+		mDoc.doc.Content = content
+		mDoc.compressed = nil
+	}
+	mDoc.touched = time.Now()
+	return mDoc.doc, nil
+}
+
+/*
+compressIdle - Walks the stored documents and compresses any that have been idle for longer than
+the configured period. Documents are picked under the read lock and compressed without holding the
+lock, and a compressed document is only swapped in when it has not changed in the meantime.
+*/
+func (s *MemoryStore) compressIdle() {
+	idleBefore := time.Now().Add(-time.Duration(s.config.IdlePeriod) * time.Second)
+
+	s.mutex.RLock()
+	idle := []*memoryDocument{}
+	for _, mDoc := range s.documents {
+		if mDoc.compressed != nil || len(mDoc.doc.Content) < s.config.MinSize {
+			continue
+		}
+		if mDoc.touched.After(idleBefore) {
+			continue
+		}
+		idle = append(idle, mDoc)
+	}
+	contents := make([]string, len(idle))
+	for i, mDoc := range idle {
+		contents[i] = mDoc.doc.Content
+	}
+	s.mutex.RUnlock()
+
+	for i, mDoc := range idle {
+		compressed, err := CompressContent(contents[i])
+		if err != nil || len(compressed) >= len(contents[i]) {
+			continue
+		}
+
+		s.mutex.Lock()
+		// Updates replace the document, and reads of a compressed document touch it.
+		if s.documents[mDoc.doc.ID] == mDoc && mDoc.compressed == nil && !mDoc.touched.After(idleBefore) {
+			mDoc.compressed = compressed
+			mDoc.doc.Content = ""
+		}
+		s.mutex.Unlock()
 	}
-	return doc, nil
+}
+
+/*
+loop - Periodically compresses idle documents until the store is closed.
+*/
+func (s *MemoryStore) loop() {
+	period := time.Duration(s.config.IdlePeriod) * time.Second
+	if period <= 0 {
+		period = time.Second
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.compressIdle()
+		case <-s.closeChan:
+			return
+		}
+	}
+}
+
+/*
+Close - Stops compressing idle documents, the documents themselves remain readable.
+*/
+func (s *MemoryStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+	return nil
+}
+
+/*
+CompressContent - Gzip the content of a document, for holding idle content in memory.
+*/
+func CompressContent(content string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write([]byte(content)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+/*
+DecompressContent - Restore content compressed with CompressContent.
+*/
+func DecompressContent(compressed []byte) (string, error) {
+	r, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
 }
 
 /*
 GetMemoryStore - Just a func that returns a MemoryStore
 */
 func GetMemoryStore(config Config) (Store, error) {
-	return &MemoryStore{
-		documents: make(map[string]Document),
-	}, nil
+	memStore := &MemoryStore{
+		config:    config.MemoryConfig,
+		documents: make(map[string]*memoryDocument),
+		fences:    make(map[string]uint64),
+		closeChan: make(chan struct{}),
+	}
+	if config.MemoryConfig.Compress {
+		go memStore.loop()
+	}
+	return memStore, nil
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -162,11 +470,20 @@ document has the ID of the config value 'Name'.
 */
 func GetMockStore(config Config) (Store, error) {
 	memStore := &MemoryStore{
-		documents: make(map[string]Document),
+		config:    config.MemoryConfig,
+		documents: make(map[string]*memoryDocument),
+		fences:    make(map[string]uint64),
+		closeChan: make(chan struct{}),
+	}
+	memStore.documents[config.Name] = &memoryDocument{
+		doc: Document{
+			ID:      config.Name,
+			Content: "Open this page multiple times to see the edits appear in all of them.",
+		},
+		touched: time.Now(),
 	}
-	memStore.documents[config.Name] = Document{
-		ID:      config.Name,
-		Content: "Open this page multiple times to see the edits appear in all of them.",
+	if config.MemoryConfig.Compress {
+		go memStore.loop()
 	}
 	return memStore, nil
 }
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package store
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMemoryStoreCompression(t *testing.T) {
+	config := NewConfig()
+	config.MemoryConfig.MinSize = 10
+	config.MemoryConfig.IdlePeriod = 0
+
+	content := strings.Repeat("hello world ", 1000)
+
+	memStore := &MemoryStore{
+		config:    config.MemoryConfig,
+		documents: map[string]*memoryDocument{},
+	}
+	if err := memStore.Create(Document{ID: "test", Content: content}); err != nil {
+		t.Errorf("Create error: %v", err)
+		return
+	}
+	if err := memStore.Create(Document{ID: "small", Content: "tiny"}); err != nil {
+		t.Errorf("Create error: %v", err)
+		return
+	}
+
+	memStore.compressIdle()
+
+	if memStore.documents["test"].compressed == nil {
+		t.Errorf("Expected document to be compressed")
+	}
+	if memStore.documents["small"].compressed != nil {
+		t.Errorf("Expected small document to remain uncompressed")
+	}
+
+	doc, err := memStore.Read("test")
+	if err != nil {
+		t.Errorf("Read error: %v", err)
+		return
+	}
+	if doc.Content != content {
+		t.Errorf("Decompressed content did not match original")
+	}
+	if memStore.documents["test"].compressed != nil {
+		t.Errorf("Expected document to be decompressed after read")
+	}
+}
+
+func TestMemoryStoreClose(t *testing.T) {
+	config := NewConfig()
+	config.MemoryConfig.Compress = true
+
+	memStore, err := GetMemoryStore(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Close(memStore); err != nil {
+		t.Errorf("Close error: %v", err)
+	}
+	if err = Close(memStore); err != nil {
+		t.Errorf("Second close error: %v", err)
+	}
+	if err = memStore.Create(Document{ID: "test", Content: "hello world"}); err != nil {
+		t.Errorf("Create error: %v", err)
+	}
+	if doc, err := memStore.Read("test"); err != nil || doc.Content != "hello world" {
+		t.Errorf("Wrong document after close: %v, %v", doc, err)
+	}
+}
+
+func TestMemoryStoreCompressionRace(t *testing.T) {
+	config := NewConfig()
+	config.MemoryConfig.MinSize = 10
+	config.MemoryConfig.IdlePeriod = 0
+
+	memStore, _ := GetMemoryStore(config)
+	content := strings.Repeat("hello world ", 1000)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			memStore.(*MemoryStore).compressIdle()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		updated := fmt.Sprintf("%v %v", content, i)
+		if err := memStore.Update(Document{ID: "test", Content: updated}); err != nil {
+			t.Fatal(err)
+		}
+		if doc, err := memStore.Read("test"); err != nil || doc.Content != updated {
+			t.Fatalf("Wrong content after update %v: %v", i, err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestMemoryStoreFencing(t *testing.T) {
+	memStore, _ := GetMemoryStore(NewConfig())
+	fenced := memStore.(FencedUpdater)
+
+	if err := memStore.Create(Document{ID: "test", Content: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fenced.UpdateFenced(Document{ID: "test", Content: "second"}, 5); err != nil {
+		t.Errorf("Update error: %v", err)
+	}
+	if err := fenced.UpdateFenced(Document{ID: "test", Content: "third"}, 5); err != nil {
+		t.Errorf("Update with the same token error: %v", err)
+	}
+	if err := fenced.UpdateFenced(Document{ID: "test", Content: "stale"}, 4); err != ErrStaleFencingToken {
+		t.Errorf("Wrong error for stale token: %v", err)
+	}
+	if doc, _ := memStore.Read("test"); doc.Content != "third" {
+		t.Errorf("Stale write was applied: %v", doc.Content)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"strings"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AnnouncementsDocument - The name of the system document whose content is shown as a banner to every
+connected client, its ID is the system document prefix followed by this name.
+*/
+const AnnouncementsDocument = "announcements"
+
+/*
+SystemDocumentsConfig - Holds configuration options for system documents, which are documents with
+IDs beginning with Prefix that hold state consumed by the server itself. They are edited like any
+other document, except that editing them requires admin access, and they are exempt from the ID
+policy. When PollPeriod is set the content of the announcements document is read every PollPeriod
+milliseconds, and when it changes it is sent as a banner to every connected client. An empty Prefix
+disables system documents, and a zero PollPeriod, the default, disables the banner.
+*/
+type SystemDocumentsConfig struct {
+	Prefix     string `json:"prefix" yaml:"prefix"`
+	PollPeriod int64  `json:"poll_period_ms" yaml:"poll_period_ms"`
+}
+
+/*
+NewSystemDocumentsConfig - Returns a default SystemDocumentsConfig.
+*/
+func NewSystemDocumentsConfig() SystemDocumentsConfig {
+	return SystemDocumentsConfig{
+		Prefix:     "_leaps/",
+		PollPeriod: 0,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+isSystemDocument - Whether a document ID belongs to a system document.
+*/
+func (c *Curator) isSystemDocument(id string) bool {
+	prefix := c.config.SystemDocuments.Prefix
+	return len(prefix) > 0 && strings.HasPrefix(id, prefix)
+}
+
+/*
+validateID - Validate the ID of a document about to be created against the ID policy, from which
+system documents are exempt.
+*/
+func (c *Curator) validateID(id string) error {
+	if c.isSystemDocument(id) {
+		return nil
+	}
+	return c.ids.Validate(id)
+}
+
+/*
+Banner - Returns the banner currently shown to every client, which is the content of the
+announcements system document, or an empty string if there is none.
+*/
+func (c *Curator) Banner() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.banner
+}
+
+/*
+Broadcast - Send a notice to every client of every open document, returns the first error
+encountered although every binder is notified regardless.
+*/
+func (c *Curator) Broadcast(notice ClientMessage, timeout time.Duration) error {
+	var err error
+	for _, b := range c.allBinders() {
+		if b.isClosed() {
+			continue
+		}
+		if nErr := b.Notify(notice, timeout); nErr != nil {
+			c.stats.Incr("curator.broadcast.error", 1)
+			c.log.Errorf("Failed to notify binder (%v): %v\n", b.ID, nErr)
+			if err == nil {
+				err = nErr
+			}
+		}
+	}
+	c.stats.Incr("curator.broadcast.success", 1)
+	return err
+}
+
+/*
+refreshBanner - Read the announcements document, and broadcast its content as the banner when it has
+changed. The live content is used whilst admins are editing it. Documents that cannot be read, such
+as when the announcements document does not exist, have no banner.
+*/
+func (c *Curator) refreshBanner(timeout time.Duration) {
+	banner := ""
+	id := c.config.SystemDocuments.Prefix + AnnouncementsDocument
+	if doc, err := c.GetLiveDocument(id, timeout); err == nil {
+		banner = strings.TrimSpace(doc.Content)
+	}
+
+	c.mutex.Lock()
+	changed := banner != c.banner
+	c.banner = banner
+	c.mutex.Unlock()
+
+	if changed {
+		c.log.Infof("Announcements changed, broadcasting banner: %q\n", banner)
+		c.stats.Incr("curator.banner.changed", 1)
+		c.Broadcast(ClientMessage{Banner: &banner}, timeout)
+	}
+}
+
+/*
+systemLoop - Polls the system documents consumed by the server until the curator is closed.
+*/
+func (c *Curator) systemLoop() {
+	period := time.Duration(c.config.SystemDocuments.PollPeriod) * time.Millisecond
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	c.refreshBanner(period)
+	for {
+		select {
+		case <-ticker.C:
+			c.refreshBanner(period)
+		case <-c.closedChan:
+			return
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestSystemDocuments(t *testing.T) {
+	log, stats := loggerAndStats()
+	_, storage := authAndStore(log, stats)
+
+	doc, _ := store.NewDocument("hello world")
+	if err := storage.Create(*doc); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultCuratorConfig()
+	config.SystemDocuments.PollPeriod = 10
+	config.IDPolicy.Pattern = "[0-9a-f]+"
+
+	levels := levelAuth{"writer": auth.AccessWrite, "admin": auth.AccessAdmin}
+	curator, err := NewCurator(config, log, stats, levels, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	portal, err := curator.EditDocument("writer", doc.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	announcements := "_leaps/" + AnnouncementsDocument
+	if err = curator.PutDocument(store.Document{ID: announcements, Content: " Maintenance at 5pm \n"}); err != nil {
+		t.Fatalf("System document was not exempt from the ID policy: %v", err)
+	}
+
+	expectBanner := func(exp string) {
+		for {
+			select {
+			case msg := <-portal.MessageRcvChan:
+				if msg.Banner == nil {
+					continue
+				}
+				if *msg.Banner != exp {
+					t.Errorf("Wrong banner: %q != %q", *msg.Banner, exp)
+				}
+				return
+			case <-time.After(time.Second):
+				t.Fatalf("Banner %q was not received", exp)
+			}
+		}
+	}
+	expectBanner("Maintenance at 5pm")
+	if banner := curator.Banner(); banner != "Maintenance at 5pm" {
+		t.Errorf("Wrong current banner: %q", banner)
+	}
+
+	if _, err = curator.EditDocument("writer", announcements); err != ErrUnauthorised {
+		t.Errorf("Wrong error for editing a system document without admin access: %v", err)
+	}
+	adminPortal, err := curator.EditDocument("admin", announcements)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = adminPortal.SendTransform(OTransform{Position: 0, Delete: 21, Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	expectBanner("")
+}
@@ -0,0 +1,266 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+TransformFileConfig - Holds configuration options specific to the file based transform store.
+*/
+type TransformFileConfig struct {
+	Directory string `json:"directory" yaml:"directory"`
+	Sync      bool   `json:"sync_writes" yaml:"sync_writes"`
+}
+
+/*
+TransformRedisConfig - Holds configuration options specific to the redis stream transform store.
+*/
+type TransformRedisConfig struct {
+	URL       string            `json:"url" yaml:"url"`
+	Password  string            `json:"password" yaml:"password"`
+	KeyPrefix string            `json:"key_prefix" yaml:"key_prefix"`
+	Outbound  util.ClientConfig `json:"outbound" yaml:"outbound"`
+}
+
+/*
+TransformStoreConfig - Holds generic configuration options for a transform store. The default type
+of none disables the transform log entirely.
+*/
+type TransformStoreConfig struct {
+	Type        string               `json:"type" yaml:"type"`
+	FileConfig  TransformFileConfig  `json:"file" yaml:"file"`
+	RedisConfig TransformRedisConfig `json:"redis" yaml:"redis"`
+}
+
+/*
+DefaultTransformStoreConfig - Returns a default transform store configuration, which is disabled.
+*/
+func DefaultTransformStoreConfig() TransformStoreConfig {
+	return TransformStoreConfig{
+		Type: "none",
+		FileConfig: TransformFileConfig{
+			Directory: "",
+			Sync:      true,
+		},
+		RedisConfig: TransformRedisConfig{
+			URL:       ":6379",
+			Password:  "",
+			KeyPrefix: "leaps:transforms:",
+			Outbound:  util.NewClientConfig(),
+		},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the TransformStore type.
+var (
+	ErrInvalidTransformStoreType = errors.New("invalid transform store type")
+)
+
+/*
+TransformCheckpoint - Logged by a binder ahead of flushing a document, Version is the version of the
+last transform being flushed and Digest that of the content being written to the store.
+*/
+type TransformCheckpoint struct {
+	Version int    `json:"version"`
+	Digest  string `json:"digest"`
+}
+
+/*
+TransformStore - Implemented by types able to durably log the transforms applied to documents. A
+binder appends each transform it accepts, and clears the log of a document once its transforms
+have been flushed to the document store. Any transforms remaining in a log when a binder opens the
+document are therefore unflushed, and are replayed onto the stored content.
+
+The log may fail to be cleared after a flush, or the process may die in between, and so a binder
+logs a checkpoint before each flush. Transforms up to the version of a checkpoint whose digest
+matches the stored content are already part of it, and are skipped when replaying.
+*/
+type TransformStore interface {
+	// Append - Append an applied transform to the log of a document.
+	Append(id string, ot OTransform) error
+	// Read - Read all logged transforms of a document in the order they were appended.
+	Read(id string) ([]OTransform, error)
+	// Checkpoint - Log that the transforms of a document up to a version are about to be flushed.
+	Checkpoint(id string, checkpoint TransformCheckpoint) error
+	// ReadCheckpoints - Read all logged checkpoints of a document in the order they were logged.
+	ReadCheckpoints(id string) ([]TransformCheckpoint, error)
+	// Clear - Remove all logged transforms and checkpoints of a document.
+	Clear(id string) error
+}
+
+/*
+unflushedTransforms - Returns the logged transforms of a document that are not yet part of its stored
+content, which are those beyond the latest checkpoint matching the content.
+*/
+func unflushedTransforms(content string, transforms []OTransform, checkpoints []TransformCheckpoint) []OTransform {
+	digest := contentDigest(content)
+	for i := len(checkpoints) - 1; i >= 0; i-- {
+		if checkpoints[i].Digest != digest {
+			continue
+		}
+		unflushed := []OTransform{}
+		for _, ot := range transforms {
+			if ot.Version > checkpoints[i].Version {
+				unflushed = append(unflushed, ot)
+			}
+		}
+		return unflushed
+	}
+	return transforms
+}
+
+/*
+TransformLister - Implemented by transform stores able to list the documents that have a log, which
+allows unflushed transforms to be recovered on startup rather than when a document is next opened.
+*/
+type TransformLister interface {
+	// List - Return the IDs of all documents with a non-empty transform log.
+	List() ([]string, error)
+}
+
+/*
+TransformStoreFactory - Returns a transform store based on a configuration object, or nil if the
+transform log is disabled.
+*/
+func TransformStoreFactory(config TransformStoreConfig) (TransformStore, error) {
+	switch config.Type {
+	case "none", "":
+		return nil, nil
+	case "memory":
+		return NewMemoryTransformStore(), nil
+	case "file":
+		return NewFileTransformStore(config.FileConfig)
+	case "redis":
+		return NewRedisTransformStore(config.RedisConfig), nil
+	}
+	return nil, ErrInvalidTransformStoreType
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+MemoryTransformStore - Keeps transform logs in memory, which only survives the loss of a binder and
+not the process. Mostly useful for testing.
+*/
+type MemoryTransformStore struct {
+	logs        map[string][]OTransform
+	checkpoints map[string][]TransformCheckpoint
+	mutex       sync.Mutex
+}
+
+/*
+NewMemoryTransformStore - Returns an empty MemoryTransformStore.
+*/
+func NewMemoryTransformStore() *MemoryTransformStore {
+	return &MemoryTransformStore{
+		logs:        map[string][]OTransform{},
+		checkpoints: map[string][]TransformCheckpoint{},
+	}
+}
+
+/*
+Append - Append a transform to the log of a document.
+*/
+func (m *MemoryTransformStore) Append(id string, ot OTransform) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.logs[id] = append(m.logs[id], ot)
+	return nil
+}
+
+/*
+Read - Read the logged transforms of a document.
+*/
+func (m *MemoryTransformStore) Read(id string) ([]OTransform, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	transforms := make([]OTransform, len(m.logs[id]))
+	copy(transforms, m.logs[id])
+	return transforms, nil
+}
+
+/*
+Checkpoint - Add a checkpoint to the log of a document.
+*/
+func (m *MemoryTransformStore) Checkpoint(id string, checkpoint TransformCheckpoint) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.checkpoints[id] = append(m.checkpoints[id], checkpoint)
+	return nil
+}
+
+/*
+ReadCheckpoints - Read the logged checkpoints of a document.
+*/
+func (m *MemoryTransformStore) ReadCheckpoints(id string) ([]TransformCheckpoint, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]TransformCheckpoint{}, m.checkpoints[id]...), nil
+}
+
+/*
+List - Return the IDs of all documents with logged transforms.
+*/
+func (m *MemoryTransformStore) List() ([]string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ids := []string{}
+	for id, log := range m.logs {
+		if len(log) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+/*
+Clear - Remove the logged transforms of a document.
+*/
+func (m *MemoryTransformStore) Clear(id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.logs, id)
+	delete(m.checkpoints, id)
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,270 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the FileTransformStore type.
+var (
+	ErrNoTransformDirectory = errors.New("transform store directory was not specified")
+)
+
+/*
+FileTransformStore - Logs transforms into files within a directory, one file per document holding a
+JSON encoded transform per line, with the checkpoints of the document in a second file alongside.
+When Sync is set each append is synced to disk before returning. The files of a document are kept
+open from its first append until it is cleared, and each document has a lock of its own so that
+appends to different documents never wait on each other.
+*/
+type FileTransformStore struct {
+	config TransformFileConfig
+	mutex  sync.Mutex
+	files  map[string]*transformFiles
+}
+
+/*
+transformFiles - The open log and checkpoint files of a document, guarded by the lock of the
+document. Removed is set once the document is cleared, after which it must be looked up again.
+*/
+type transformFiles struct {
+	mutex      sync.Mutex
+	log        *os.File
+	checkpoint *os.File
+	removed    bool
+}
+
+/*
+NewFileTransformStore - Returns a FileTransformStore writing to the configured directory.
+*/
+func NewFileTransformStore(config TransformFileConfig) (*FileTransformStore, error) {
+	if len(config.Directory) == 0 {
+		return nil, ErrNoTransformDirectory
+	}
+	if err := os.MkdirAll(config.Directory, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &FileTransformStore{
+		config: config,
+		files:  map[string]*transformFiles{},
+	}, nil
+}
+
+/*
+lock - Returns the locked files of a document, which must be unlocked by the caller.
+*/
+func (f *FileTransformStore) lock(id string) *transformFiles {
+	for {
+		f.mutex.Lock()
+		files, ok := f.files[id]
+		if !ok {
+			files = &transformFiles{}
+			f.files[id] = files
+		}
+		f.mutex.Unlock()
+
+		files.mutex.Lock()
+		if !files.removed {
+			return files
+		}
+		files.mutex.Unlock()
+	}
+}
+
+/*
+logPath - The path of the log file for a document.
+*/
+func (f *FileTransformStore) logPath(id string) string {
+	return filepath.Join(f.config.Directory, id+".tlog")
+}
+
+/*
+checkpointPath - The path of the checkpoint file for a document.
+*/
+func (f *FileTransformStore) checkpointPath(id string) string {
+	return filepath.Join(f.config.Directory, id+".tcheck")
+}
+
+/*
+Append - Append a transform to the log file of a document.
+*/
+func (f *FileTransformStore) Append(id string, ot OTransform) error {
+	files := f.lock(id)
+	defer files.mutex.Unlock()
+	return f.appendLine(id, f.logPath(id), &files.log, ot)
+}
+
+/*
+Checkpoint - Append a checkpoint to the checkpoint file of a document.
+*/
+func (f *FileTransformStore) Checkpoint(id string, checkpoint TransformCheckpoint) error {
+	files := f.lock(id)
+	defer files.mutex.Unlock()
+	return f.appendLine(id, f.checkpointPath(id), &files.checkpoint, checkpoint)
+}
+
+/*
+appendLine - Append a value as a line of JSON to a file of a document, opening the file if it is not
+yet open. The caller must hold the lock of the document.
+*/
+func (f *FileTransformStore) appendLine(id, path string, file **os.File, value interface{}) error {
+	line, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if *file == nil {
+		if err = os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return fmt.Errorf("cannot create transform log path for document: %v, err: %v", id, err)
+		}
+		if *file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666); err != nil {
+			return err
+		}
+	}
+
+	if _, err = (*file).Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if f.config.Sync {
+		return (*file).Sync()
+	}
+	return nil
+}
+
+/*
+Read - Read the transforms logged for a document. A partially written final line, which can be left
+behind by a crash mid append, is ignored.
+*/
+func (f *FileTransformStore) Read(id string) ([]OTransform, error) {
+	files := f.lock(id)
+	defer files.mutex.Unlock()
+
+	file, err := os.Open(f.logPath(id))
+	if os.IsNotExist(err) {
+		return []OTransform{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	transforms := []OTransform{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024*64)
+	for scanner.Scan() {
+		var ot OTransform
+		if err = json.Unmarshal(scanner.Bytes(), &ot); err != nil {
+			break
+		}
+		transforms = append(transforms, ot)
+	}
+	return transforms, scanner.Err()
+}
+
+/*
+ReadCheckpoints - Read the checkpoints logged for a document, ignoring a partially written final line.
+*/
+func (f *FileTransformStore) ReadCheckpoints(id string) ([]TransformCheckpoint, error) {
+	files := f.lock(id)
+	defer files.mutex.Unlock()
+
+	file, err := os.Open(f.checkpointPath(id))
+	if os.IsNotExist(err) {
+		return []TransformCheckpoint{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	checkpoints := []TransformCheckpoint{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var checkpoint TransformCheckpoint
+		if err = json.Unmarshal(scanner.Bytes(), &checkpoint); err != nil {
+			break
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	return checkpoints, scanner.Err()
+}
+
+/*
+List - Walk the log directory and return the ID of each document with a log file.
+*/
+func (f *FileTransformStore) List() ([]string, error) {
+	ids := []string{}
+	err := filepath.Walk(f.config.Directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".tlog") || info.Size() == 0 {
+			return err
+		}
+		rel, err := filepath.Rel(f.config.Directory, path)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, strings.TrimSuffix(filepath.ToSlash(rel), ".tlog"))
+		return nil
+	})
+	return ids, err
+}
+
+/*
+Clear - Close and remove the log and checkpoint files of a document, the log first so that its
+checkpoints are never missing whilst it remains.
+*/
+func (f *FileTransformStore) Clear(id string) error {
+	files := f.lock(id)
+	defer files.mutex.Unlock()
+
+	for _, file := range []*os.File{files.log, files.checkpoint} {
+		if file != nil {
+			file.Close()
+		}
+	}
+	files.log, files.checkpoint, files.removed = nil, nil, true
+
+	f.mutex.Lock()
+	delete(f.files, id)
+	f.mutex.Unlock()
+
+	for _, path := range []string{f.logPath(id), f.checkpointPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
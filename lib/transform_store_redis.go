@@ -0,0 +1,229 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RedisTransformStore - Logs transforms into a redis stream per document, requires redis 5 or above.
+Checkpoints are added to the same stream under a field of their own.
+*/
+type RedisTransformStore struct {
+	config TransformRedisConfig
+	pool   *redis.Pool
+}
+
+/*
+NewRedisTransformStore - Returns a RedisTransformStore using the provided configuration.
+*/
+func NewRedisTransformStore(config TransformRedisConfig) *RedisTransformStore {
+	return &RedisTransformStore{
+		config: config,
+		pool: &redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				dial, err := util.NewDialer(config.Outbound)
+				if err != nil {
+					return nil, err
+				}
+				tlsConfig, err := util.NewClientTLS(config.Outbound)
+				if err != nil {
+					return nil, err
+				}
+				c, err := redis.Dial("tcp", config.URL,
+					redis.DialNetDial(dial),
+					redis.DialUseTLS(config.Outbound.TLS.Enabled),
+					redis.DialTLSConfig(tlsConfig),
+				)
+				if err != nil {
+					return nil, err
+				}
+				if 0 != len(config.Password) {
+					if _, err := c.Do("AUTH", config.Password); err != nil {
+						c.Close()
+						return nil, err
+					}
+				}
+				return c, err
+			},
+		},
+	}
+}
+
+/*
+Append - Add a transform to the stream of a document.
+*/
+func (r *RedisTransformStore) Append(id string, ot OTransform) error {
+	return r.add(id, "transform", ot)
+}
+
+/*
+Checkpoint - Add a checkpoint to the stream of a document.
+*/
+func (r *RedisTransformStore) Checkpoint(id string, checkpoint TransformCheckpoint) error {
+	return r.add(id, "checkpoint", checkpoint)
+}
+
+/*
+add - Add a JSON encoded value to the stream of a document under a field.
+*/
+func (r *RedisTransformStore) add(id, field string, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("XADD", r.config.KeyPrefix+id, "*", field, bytes)
+	return err
+}
+
+/*
+Read - Read the full stream of transforms for a document.
+*/
+func (r *RedisTransformStore) Read(id string) ([]OTransform, error) {
+	transforms := []OTransform{}
+	err := r.scan(id, "transform", func(value []byte) error {
+		var ot OTransform
+		if err := json.Unmarshal(value, &ot); err != nil {
+			return err
+		}
+		transforms = append(transforms, ot)
+		return nil
+	})
+	return transforms, err
+}
+
+/*
+ReadCheckpoints - Read the checkpoints of the stream of a document.
+*/
+func (r *RedisTransformStore) ReadCheckpoints(id string) ([]TransformCheckpoint, error) {
+	checkpoints := []TransformCheckpoint{}
+	err := r.scan(id, "checkpoint", func(value []byte) error {
+		var checkpoint TransformCheckpoint
+		if err := json.Unmarshal(value, &checkpoint); err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+		return nil
+	})
+	return checkpoints, err
+}
+
+/*
+scan - Call fn with the value of each entry of the stream of a document under a field, in order.
+*/
+func (r *RedisTransformStore) scan(id, field string, fn func([]byte) error) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	entries, err := redis.Values(conn.Do("XRANGE", r.config.KeyPrefix+id, "-", "+"))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		// Each entry is a pair of the entry ID and its field/value list.
+		parts, err := redis.Values(entry, nil)
+		if err != nil {
+			return err
+		}
+		if len(parts) != 2 {
+			continue
+		}
+		fields, err := redis.ByteSlices(parts[1], nil)
+		if err != nil {
+			return err
+		}
+		for i := 0; i+1 < len(fields); i += 2 {
+			if string(fields[i]) != field {
+				continue
+			}
+			if err = fn(fields[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+List - Scan for the streams of all documents under the key prefix.
+*/
+func (r *RedisTransformStore) List() ([]string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	ids := []string{}
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", r.config.KeyPrefix+"*", "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		if len(reply) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply length: %v", len(reply))
+		}
+		if cursor, err = redis.String(reply[0], nil); err != nil {
+			return nil, err
+		}
+		keys, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			ids = append(ids, strings.TrimPrefix(key, r.config.KeyPrefix))
+		}
+		if cursor == "0" {
+			return ids, nil
+		}
+	}
+}
+
+/*
+Clear - Delete the stream of a document.
+*/
+func (r *RedisTransformStore) Clear(id string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", r.config.KeyPrefix+id)
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
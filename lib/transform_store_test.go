@@ -0,0 +1,287 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestFileTransformStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "leaps_tlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tStore, err := NewFileTransformStore(TransformFileConfig{Directory: dir, Sync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transforms := []OTransform{
+		{Position: 0, Insert: "hello", Version: 2},
+		{Position: 5, Insert: " world", Version: 3},
+	}
+	for _, ot := range transforms {
+		if err = tStore.Append("nested/doc", ot); err != nil {
+			t.Errorf("Append error: %v", err)
+		}
+	}
+
+	// Simulate a crash part way through an append
+	file, _ := os.OpenFile(filepath.Join(dir, "nested/doc.tlog"), os.O_APPEND|os.O_WRONLY, 0666)
+	file.WriteString(`{"position":11,"ins`)
+	file.Close()
+
+	read, err := tStore.Read("nested/doc")
+	if err != nil {
+		t.Errorf("Read error: %v", err)
+	}
+	if !reflect.DeepEqual(transforms, read) {
+		t.Errorf("Wrong transforms: %v != %v", transforms, read)
+	}
+
+	checkpoint := TransformCheckpoint{Version: 3, Digest: contentDigest("hello world")}
+	if err = tStore.Checkpoint("nested/doc", checkpoint); err != nil {
+		t.Errorf("Checkpoint error: %v", err)
+	}
+	if checkpoints, err := tStore.ReadCheckpoints("nested/doc"); err != nil || len(checkpoints) != 1 || checkpoints[0] != checkpoint {
+		t.Errorf("Wrong checkpoints: %v, %v", checkpoints, err)
+	}
+
+	if err = tStore.Clear("nested/doc"); err != nil {
+		t.Errorf("Clear error: %v", err)
+	}
+	if read, err = tStore.Read("nested/doc"); err != nil || len(read) != 0 {
+		t.Errorf("Expected empty log after clear: %v, %v", read, err)
+	}
+	if checkpoints, err := tStore.ReadCheckpoints("nested/doc"); err != nil || len(checkpoints) != 0 {
+		t.Errorf("Expected no checkpoints after clear: %v, %v", checkpoints, err)
+	}
+
+	// The log is reopened by the first append after a clear
+	if err = tStore.Append("nested/doc", transforms[0]); err != nil {
+		t.Errorf("Append error: %v", err)
+	}
+	if read, err = tStore.Read("nested/doc"); err != nil || !reflect.DeepEqual(transforms[:1], read) {
+		t.Errorf("Wrong transforms after clear: %v, %v", read, err)
+	}
+}
+
+func TestBinderTransformRecovery(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	tStore := NewMemoryTransformStore()
+
+	// Transforms accepted by a binder that died before flushing them
+	tStore.Append(doc.ID, OTransform{Position: 6, Delete: 5, Insert: "universe", Version: 2})
+	tStore.Append(doc.ID, OTransform{Position: 0, Insert: "super ", Version: 3})
+
+	binder, err := newBinder(doc.ID, docStore, tStore, nil, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	portal := binder.Subscribe("")
+	if exp, act := "super hello universe", portal.Document.Content; exp != act {
+		t.Errorf("Wrong recovered content: %v != %v", exp, act)
+	}
+	if pending, _ := tStore.Read(doc.ID); len(pending) != 0 {
+		t.Errorf("Expected transform log to be cleared after recovery: %v", pending)
+	}
+
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "oh ", Version: 2}, time.Second); err != nil {
+		t.Errorf("Send transform error: %v", err)
+	}
+	if pending, _ := tStore.Read(doc.ID); len(pending) != 1 {
+		t.Errorf("Expected transform to be logged: %v", pending)
+	}
+
+	binder.Close()
+
+	if pending, _ := tStore.Read(doc.ID); len(pending) != 0 {
+		t.Errorf("Expected transform log to be cleared after flush: %v", pending)
+	}
+	if exp, act := "oh super hello universe", docStore.documents[doc.ID].Content; exp != act {
+		t.Errorf("Wrong stored content: %v != %v", exp, act)
+	}
+}
+
+func TestRecoverTransformLogs(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	dir, err := ioutil.TempDir("", "leaps_tlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tStore, err := NewFileTransformStore(TransformFileConfig{Directory: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	docStore := &testStore{documents: map[string]store.Document{
+		"nested/good": {ID: "nested/good", Content: "hello world"},
+		"bad":         {ID: "bad", Content: "short"},
+	}}
+
+	tStore.Append("nested/good", OTransform{Position: 6, Delete: 5, Insert: "universe", Version: 2})
+
+	// The first two transforms were flushed before the process died, the third was not
+	docStore.documents["flushed"] = store.Document{ID: "flushed", Content: "hello world"}
+	tStore.Append("flushed", OTransform{Position: 0, Insert: "hello", Version: 2})
+	tStore.Append("flushed", OTransform{Position: 5, Insert: " world", Version: 3})
+	tStore.Checkpoint("flushed", TransformCheckpoint{Version: 3, Digest: contentDigest("hello world")})
+	tStore.Append("flushed", OTransform{Position: 11, Insert: "!", Version: 4})
+
+	tStore.Append("bad", OTransform{Position: 100, Insert: "out of bounds", Version: 2})
+	tStore.Append("missing", OTransform{Position: 0, Insert: "no document", Version: 2})
+
+	report := recoverTransformLogs(docStore, tStore, logger, stats)
+
+	sort.Strings(report.Recovered)
+	if !reflect.DeepEqual(report.Recovered, []string{"flushed", "nested/good"}) {
+		t.Errorf("Wrong recovered documents: %v", report.Recovered)
+	}
+	if exp, act := "hello world!", docStore.documents["flushed"].Content; exp != act {
+		t.Errorf("Wrong recovered content: %v != %v", exp, act)
+	}
+	if exp, act := "hello universe", docStore.documents["nested/good"].Content; exp != act {
+		t.Errorf("Wrong recovered content: %v != %v", exp, act)
+	}
+	if pending, _ := tStore.Read("nested/good"); len(pending) != 0 {
+		t.Errorf("Expected recovered log to be cleared: %v", pending)
+	}
+
+	failed := map[string]bool{}
+	for _, failure := range report.Failed {
+		failed[failure.ID] = true
+	}
+	if len(failed) != 2 || !failed["bad"] || !failed["missing"] {
+		t.Errorf("Wrong failed documents: %v", report.Failed)
+	}
+	if pending, _ := tStore.Read("bad"); len(pending) != 1 {
+		t.Errorf("Expected unrecoverable log to be kept: %v", pending)
+	}
+}
+
+/*
+unclearableTransformStore - A transform store that fails to clear its logs.
+*/
+type unclearableTransformStore struct {
+	*MemoryTransformStore
+}
+
+func (u unclearableTransformStore) Clear(id string) error {
+	return errors.New("clear failed")
+}
+
+func TestBinderTransformRecoveryAfterFlush(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+	tStore := NewMemoryTransformStore()
+
+	// The transforms are flushed, but the log is not cleared afterwards
+	binder, err := newBinder(doc.ID, docStore, unclearableTransformStore{tStore}, nil, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	portal := binder.Subscribe("")
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "oh ", Version: portal.Version + 1}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	binder.Close()
+	if pending, _ := tStore.Read(doc.ID); len(pending) != 1 {
+		t.Fatalf("Expected transform to remain logged: %v", pending)
+	}
+
+	// Another transform is logged by a binder that died before flushing it
+	tStore.Append(doc.ID, OTransform{Position: 0, Insert: "well ", Version: portal.Version + 2})
+
+	if binder, err = newBinder(doc.ID, docStore, tStore, nil, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats); err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	if exp, act := "well oh hello world", binder.Subscribe("").Document.Content; exp != act {
+		t.Errorf("Wrong recovered content: %v != %v", exp, act)
+	}
+	if pending, _ := tStore.Read(doc.ID); len(pending) != 0 {
+		t.Errorf("Expected transform log to be cleared after recovery: %v", pending)
+	}
+}
+
+/*
+unwritableTransformStore - A transform store that fails to log transforms.
+*/
+type unwritableTransformStore struct {
+	*MemoryTransformStore
+}
+
+func (u unwritableTransformStore) Append(id string, ot OTransform) error {
+	return errors.New("append failed")
+}
+
+func TestBinderTransformLogFailure(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc, _ := store.NewDocument("hello world")
+	logger, stats := loggerAndStats()
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: *doc}}
+
+	binder, err := newBinder(doc.ID, docStore, unwritableTransformStore{NewMemoryTransformStore()}, nil, nil, nil, DefaultBinderConfig(), nil, nil, nil, nil, nil, nil, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	portal := binder.Subscribe("")
+	if _, err = portal.SendTransform(OTransform{Position: 0, Insert: "oh ", Version: portal.Version + 1}, time.Second); err == nil {
+		t.Error("Expected transform to be refused")
+	}
+
+	// The refused transform must not hold on to its version
+	other := binder.Subscribe("")
+	if exp, act := portal.Version, other.Version; exp != act {
+		t.Errorf("Wrong version after refused transform: %v != %v", exp, act)
+	}
+	if exp, act := "hello world", other.Document.Content; exp != act {
+		t.Errorf("Wrong content after refused transform: %v != %v", exp, act)
+	}
+}
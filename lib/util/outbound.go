@@ -0,0 +1,265 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ClientConfig - Holds configuration options for outbound connections made by leaps, such as those to
+stores, authorisers and other integrations. ProxyURL may use the http, https or socks5 scheme, and
+when left empty the standard proxy environment variables are honoured if ProxyFromEnv is set, which
+it is not by default. CABundle is the path of a PEM file containing additional certificate
+authorities to trust.
+*/
+type ClientConfig struct {
+	ProxyURL     string          `json:"proxy_url" yaml:"proxy_url"`
+	ProxyFromEnv bool            `json:"proxy_from_env" yaml:"proxy_from_env"`
+	CABundle     string          `json:"ca_bundle" yaml:"ca_bundle"`
+	TLS          ClientTLSConfig `json:"tls" yaml:"tls"`
+	Timeout      int64           `json:"timeout_ms" yaml:"timeout_ms"`
+}
+
+/*
+NewClientConfig - Returns a default outbound client configuration, which connects directly and
+trusts the system certificate authorities.
+*/
+func NewClientConfig() ClientConfig {
+	return ClientConfig{
+		ProxyURL:     "",
+		ProxyFromEnv: false,
+		CABundle:     "",
+		TLS:          NewClientTLSConfig(),
+		Timeout:      10000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for outbound clients.
+var (
+	ErrInvalidCABundle    = errors.New("CA bundle contained no valid certificates")
+	ErrUnsupportedProxy   = errors.New("unsupported proxy scheme")
+	ErrProxyConnectFailed = errors.New("proxy refused CONNECT request")
+	ErrDialTimeout        = errors.New("timed out establishing connection")
+)
+
+/*
+Dialer - A function for establishing raw outbound connections, compatible with most drivers that
+allow a custom dial function.
+*/
+type Dialer func(network, addr string) (net.Conn, error)
+
+/*
+NewHTTPClient - Returns an HTTP client that routes requests through the configured proxy and trusts
+the configured CA bundle in addition to the system authorities.
+*/
+func NewHTTPClient(config ClientConfig) (*http.Client, error) {
+	tlsConfig, err := NewClientTLS(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		TLSClientConfig: tlsConfig,
+	}
+	if len(config.ProxyURL) > 0 {
+		proxyURL, err := parseProxyURL(config.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	} else if config.ProxyFromEnv {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   time.Duration(config.Timeout) * time.Millisecond,
+	}, nil
+}
+
+/*
+NewDialer - Returns a Dialer that establishes raw TCP connections through the configured proxy. SOCKS5
+proxies are dialed natively and HTTP proxies are tunnelled through with a CONNECT request. HTTPS
+proxies are only supported for HTTP clients.
+*/
+func NewDialer(config ClientConfig) (Dialer, error) {
+	direct := &net.Dialer{Timeout: time.Duration(config.Timeout) * time.Millisecond}
+
+	if len(config.ProxyURL) == 0 {
+		if config.ProxyFromEnv {
+			// Raw connections follow ALL_PROXY and NO_PROXY rather than the HTTP specific variables.
+			return proxy.FromEnvironmentUsing(direct).Dial, nil
+		}
+		return direct.Dial, nil
+	}
+
+	proxyURL, err := parseProxyURL(config.ProxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, direct)
+		if err != nil {
+			return nil, err
+		}
+		return socks.Dial, nil
+	case "http":
+		return func(network, addr string) (net.Conn, error) {
+			return dialConnect(direct, proxyURL, addr)
+		}, nil
+	}
+	return nil, ErrUnsupportedProxy
+}
+
+/*
+DialTimeout - Establish a connection with a Dialer, giving up once the timeout has passed. Dialers
+of proxies do not accept a timeout themselves, so a connection established after giving up is closed.
+A timeout of zero or less waits on the Dialer alone.
+*/
+func DialTimeout(dial Dialer, network, addr string, timeout time.Duration) (net.Conn, error) {
+	if timeout <= 0 {
+		return dial(network, addr)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	resultChan := make(chan dialResult, 1)
+	go func() {
+		conn, err := dial(network, addr)
+		resultChan <- dialResult{conn: conn, err: err}
+	}()
+
+	select {
+	case result := <-resultChan:
+		return result.conn, result.err
+	case <-time.After(timeout):
+	}
+	go func() {
+		if result := <-resultChan; result.conn != nil {
+			result.conn.Close()
+		}
+	}()
+	return nil, ErrDialTimeout
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+bufferedConn - A connection that is first read from a buffer, which holds data the proxy tunnelled
+through along with its response to the CONNECT request.
+*/
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
+
+/*
+parseProxyURL - Parse a proxy URL and validate its scheme.
+*/
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+		return proxyURL, nil
+	}
+	return nil, ErrUnsupportedProxy
+}
+
+/*
+dialConnect - Open a tunnel to addr through an HTTP proxy using the CONNECT method.
+*/
+func dialConnect(direct *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := direct.Dial("tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		req.SetBasicAuth(proxyURL.User.Username(), password)
+		req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+		req.Header.Del("Authorization")
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("%v: %v", ErrProxyConnectFailed, res.Status)
+	}
+	// Servers that speak first, such as MySQL, may have their greeting read along with the response.
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,210 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDialerHTTPConnect(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	var requested string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		requested = r.Host
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, _ := w.(http.Hijacker).Hijack()
+		defer conn.Close()
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}))
+	defer proxyServer.Close()
+
+	config := NewClientConfig()
+	config.ProxyURL = proxyServer.URL
+
+	dial, err := NewDialer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial("tcp", target.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if exp, act := target.Addr().String(), requested; exp != act {
+		t.Errorf("Wrong CONNECT target: %v != %v", exp, act)
+	}
+
+	conn.Write([]byte("hello\n"))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "hello\n", line; exp != act {
+		t.Errorf("Wrong echo through tunnel: %v != %v", exp, act)
+	}
+}
+
+func TestHTTPClientProxy(t *testing.T) {
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proxied " + r.URL.String()))
+	}))
+	defer proxyServer.Close()
+
+	config := NewClientConfig()
+	config.ProxyURL = proxyServer.URL
+
+	client, err := NewHTTPClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get("http://leaps.invalid/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := ioutil.ReadAll(res.Body)
+	if exp, act := "proxied http://leaps.invalid/foo", string(body); exp != act {
+		t.Errorf("Wrong response: %v != %v", exp, act)
+	}
+}
+
+func TestClientConfigErrors(t *testing.T) {
+	config := NewClientConfig()
+	config.ProxyURL = "ftp://localhost:21"
+	if _, err := NewDialer(config); err != ErrUnsupportedProxy {
+		t.Errorf("Expected unsupported proxy, received: %v", err)
+	}
+
+	config = NewClientConfig()
+	bundle, _ := ioutil.TempFile("", "leaps_ca")
+	bundle.WriteString("not a certificate")
+	bundle.Close()
+	defer os.Remove(bundle.Name())
+	config.CABundle = bundle.Name()
+	if _, err := NewHTTPClient(config); err != ErrInvalidCABundle {
+		t.Errorf("Expected invalid CA bundle, received: %v", err)
+	}
+}
+
+func TestDialerBufferedGreeting(t *testing.T) {
+	proxyServer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyServer.Close()
+
+	// The proxy answers the CONNECT request along with the greeting of the server in one write
+	go func() {
+		conn, err := proxyServer.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		http.ReadRequest(bufio.NewReader(conn))
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\ngreeting\n"))
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	config := NewClientConfig()
+	config.ProxyURL = "http://" + proxyServer.Addr().String()
+
+	dial, err := NewDialer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dial("tcp", "leaps.invalid:3306")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "greeting\n", line; exp != act {
+		t.Errorf("Wrong greeting: %v != %v", exp, act)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	closed := make(chan struct{}, 2)
+	slow := func(network, addr string) (net.Conn, error) {
+		time.Sleep(100 * time.Millisecond)
+		client, server := net.Pipe()
+		go func() {
+			server.Read(make([]byte, 1))
+			closed <- struct{}{}
+		}()
+		return client, nil
+	}
+
+	if _, err := DialTimeout(slow, "tcp", "localhost:1", 10*time.Millisecond); err != ErrDialTimeout {
+		t.Errorf("Wrong error: %v", err)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("Connection established after the timeout was not closed")
+	}
+
+	conn, err := DialTimeout(slow, "tcp", "localhost:1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ResourceLimits - The CPU and memory available to this process as imposed by a container runtime.
+CPUs is fractional in the same way as a kubernetes limit (500m is 0.5), and a zero value for either
+field means that no limit was found.
+*/
+type ResourceLimits struct {
+	CPUs   float64 `json:"cpus" yaml:"cpus"`
+	Memory int64   `json:"memory_bytes" yaml:"memory_bytes"`
+}
+
+/*
+Limited - Whether any limit was detected.
+*/
+func (r ResourceLimits) Limited() bool {
+	return r.CPUs > 0 || r.Memory > 0
+}
+
+/*
+Processes - The number of processes that the CPU limit allows for, rounded up, and falling back to
+the number of CPUs of the host when there is no limit.
+*/
+func (r ResourceLimits) Processes() int {
+	if r.CPUs <= 0 {
+		return runtime.NumCPU()
+	}
+	procs := int(math.Ceil(r.CPUs))
+	if hostCPUs := runtime.NumCPU(); procs > hostCPUs {
+		procs = hostCPUs
+	}
+	return procs
+}
+
+/*
+DetectResourceLimits - Reads the cgroup limits of this process from /sys/fs/cgroup, supporting both
+the unified (v2) and legacy (v1) hierarchies. On systems without cgroups no limits are returned.
+*/
+func DetectResourceLimits() ResourceLimits {
+	return detectResourceLimits("/sys/fs/cgroup")
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+cgroupUnlimited - Cgroup v1 reports an unset memory limit as a page aligned max int64, anything
+beyond this is treated as no limit at all.
+*/
+const cgroupUnlimited = int64(1) << 60
+
+func detectResourceLimits(root string) ResourceLimits {
+	limits := ResourceLimits{}
+
+	// Unified hierarchy
+	if fields := readCgroupFields(filepath.Join(root, "cpu.max")); len(fields) == 2 {
+		limits.CPUs = cpuQuota(fields[0], fields[1])
+	}
+	if fields := readCgroupFields(filepath.Join(root, "memory.max")); len(fields) == 1 {
+		limits.Memory = memoryLimit(fields[0])
+	}
+
+	// Legacy hierarchy, where cpu may be mounted jointly with cpuacct
+	if limits.CPUs == 0 {
+		for _, dir := range []string{"cpu", "cpu,cpuacct", "cpuacct,cpu"} {
+			quota := readCgroupFields(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+			period := readCgroupFields(filepath.Join(root, dir, "cpu.cfs_period_us"))
+			if len(quota) == 1 && len(period) == 1 {
+				limits.CPUs = cpuQuota(quota[0], period[0])
+				break
+			}
+		}
+	}
+	if limits.Memory == 0 {
+		if fields := readCgroupFields(filepath.Join(root, "memory", "memory.limit_in_bytes")); len(fields) == 1 {
+			limits.Memory = memoryLimit(fields[0])
+		}
+	}
+	return limits
+}
+
+func readCgroupFields(path string) []string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+func cpuQuota(quota, period string) float64 {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return float64(q) / float64(p)
+}
+
+func memoryLimit(limit string) int64 {
+	m, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil || m <= 0 || m >= cgroupUnlimited {
+		return 0
+	}
+	return m
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
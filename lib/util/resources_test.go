@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "leaps_cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestResourceLimits(t *testing.T) {
+	type testCase struct {
+		files map[string]string
+		exp   ResourceLimits
+	}
+
+	tests := []testCase{
+		{
+			files: map[string]string{},
+			exp:   ResourceLimits{},
+		},
+		{
+			files: map[string]string{
+				"cpu.max":    "150000 100000\n",
+				"memory.max": "536870912\n",
+			},
+			exp: ResourceLimits{CPUs: 1.5, Memory: 536870912},
+		},
+		{
+			files: map[string]string{
+				"cpu.max":    "max 100000\n",
+				"memory.max": "max\n",
+			},
+			exp: ResourceLimits{},
+		},
+		{
+			files: map[string]string{
+				"cpu,cpuacct/cpu.cfs_quota_us":  "50000\n",
+				"cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+				"memory/memory.limit_in_bytes":  "268435456\n",
+			},
+			exp: ResourceLimits{CPUs: 0.5, Memory: 268435456},
+		},
+		{
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+			exp: ResourceLimits{},
+		},
+	}
+
+	for i, test := range tests {
+		root := writeCgroupFiles(t, test.files)
+		defer os.RemoveAll(root)
+
+		if act := detectResourceLimits(root); act != test.exp {
+			t.Errorf("Wrong limits for test %v: %+v != %+v", i, test.exp, act)
+		}
+	}
+
+	if exp, act := 1, (ResourceLimits{CPUs: 0.5}).Processes(); exp != act {
+		t.Errorf("Wrong processes for fractional limit: %v != %v", exp, act)
+	}
+}
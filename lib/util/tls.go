@@ -0,0 +1,189 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ClientTLSConfig - Holds TLS options for outbound connections. Enabled is only required for protocols
+that do not otherwise signal TLS (such as redis), and setting CertFile and KeyFile presents a
+client certificate for mutual TLS. Certificates are reloaded whenever the files change on disk.
+*/
+type ClientTLSConfig struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	CertFile   string `json:"cert_file" yaml:"cert_file"`
+	KeyFile    string `json:"key_file" yaml:"key_file"`
+	ServerName string `json:"server_name" yaml:"server_name"`
+}
+
+/*
+NewClientTLSConfig - Returns a default ClientTLSConfig, which is disabled.
+*/
+func NewClientTLSConfig() ClientTLSConfig {
+	return ClientTLSConfig{
+		Enabled:    false,
+		CertFile:   "",
+		KeyFile:    "",
+		ServerName: "",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+CertReloader - Holds a certificate and key pair loaded from files, and reloads the pair whenever
+either file is modified. This allows certificates to be rotated without restarting the service. If
+a reload fails the previously loaded pair continues to be used.
+*/
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	mutex    sync.Mutex
+}
+
+/*
+NewCertReloader - Loads a certificate and key pair and returns a CertReloader for it.
+*/
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	c := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+/*
+reload - Load the pair from disk if either file has changed since the last load.
+*/
+func (c *CertReloader) reload() error {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	if c.cert != nil && !latest.After(c.modTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, latest
+	return nil
+}
+
+/*
+Certificate - Returns the current certificate, reloading it first if the files have changed.
+*/
+func (c *CertReloader) Certificate() *tls.Certificate {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reload()
+	return c.cert
+}
+
+/*
+GetCertificate - Suitable for the GetCertificate field of a server tls.Config.
+*/
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.Certificate(), nil
+}
+
+/*
+GetClientCertificate - Suitable for the GetClientCertificate field of a client tls.Config.
+*/
+func (c *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.Certificate(), nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+LoadCertPool - Returns the system certificate pool with the certificates of a PEM file appended.
+*/
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, ErrInvalidCABundle
+	}
+	return pool, nil
+}
+
+/*
+NewClientTLS - Returns a TLS config for outbound connections, which trusts the CA bundle of the
+config and presents its client certificate. Returns nil when there is nothing to configure.
+*/
+func NewClientTLS(config ClientConfig) (*tls.Config, error) {
+	tlsConf := config.TLS
+	if len(config.CABundle) == 0 && len(tlsConf.CertFile) == 0 && len(tlsConf.ServerName) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{ServerName: tlsConf.ServerName}
+	if len(config.CABundle) > 0 {
+		pool, err := LoadCertPool(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(tlsConf.CertFile) > 0 {
+		reloader, err := NewCertReloader(tlsConf.CertFile, tlsConf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+	return tlsConfig, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,281 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ValidatorConfig - Holds configuration options for a content validator. Type is the name of the
+validator, the built in validators are 'json' (content must parse as JSON), 'yaml' (content must be
+free of common YAML syntax errors) and 'line_length' (no line may exceed MaxLineLength characters).
+*/
+type ValidatorConfig struct {
+	Type          string `json:"type" yaml:"type"`
+	MaxLineLength int    `json:"max_line_length" yaml:"max_line_length"`
+}
+
+/*
+NewValidatorConfig - Returns a default validator configuration.
+*/
+func NewValidatorConfig() ValidatorConfig {
+	return ValidatorConfig{
+		Type:          "json",
+		MaxLineLength: 120,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for validators.
+var (
+	ErrInvalidValidatorType = errors.New("invalid validator type")
+)
+
+/*
+Validator - Checks the content of a document, returning an error describing the problem when the
+content is not valid.
+*/
+type Validator func(content string) error
+
+/*
+ValidatorConstructor - Creates a validator from its configuration.
+*/
+type ValidatorConstructor func(config ValidatorConfig) (Validator, error)
+
+var (
+	validatorTypes = map[string]ValidatorConstructor{
+		"json":        func(ValidatorConfig) (Validator, error) { return validateJSON, nil },
+		"yaml":        func(ValidatorConfig) (Validator, error) { return validateYAML, nil },
+		"line_length": newLineLengthValidator,
+	}
+	validatorTypesMutex sync.RWMutex
+)
+
+/*
+RegisterValidator - Make a custom validator type available to validator configs, registering a
+type that already exists replaces it. Must be called before any binders are created.
+*/
+func RegisterValidator(validatorType string, constructor ValidatorConstructor) {
+	validatorTypesMutex.Lock()
+	validatorTypes[validatorType] = constructor
+	validatorTypesMutex.Unlock()
+}
+
+/*
+ValidatorFactory - Returns the validator of a configuration.
+*/
+func ValidatorFactory(config ValidatorConfig) (Validator, error) {
+	validatorTypesMutex.RLock()
+	constructor, ok := validatorTypes[config.Type]
+	validatorTypesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidValidatorType, config.Type)
+	}
+	return constructor(config)
+}
+
+/*
+DiagnosticEvent - Describes a document that failed validation when it was flushed, Problems lists
+the error of each failed validator prefixed with its type. A change made to the document in the
+store that could not be merged into it is also reported as a problem.
+*/
+type DiagnosticEvent struct {
+	ID       string    `json:"id" yaml:"id"`
+	Version  int       `json:"version" yaml:"version"`
+	Problems []string  `json:"problems" yaml:"problems"`
+	Flushed  time.Time `json:"flushed" yaml:"flushed"`
+}
+
+/*
+DiagnosticHook - A function called by a curator each time a flushed document fails validation.
+*/
+type DiagnosticHook func(event DiagnosticEvent)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+namedValidator - A validator along with the type it was created from, for use in diagnostics.
+*/
+type namedValidator struct {
+	name     string
+	validate Validator
+}
+
+/*
+createValidators - Create the validators that apply to a document, which are those configured for
+its type (text when empty) followed by those configured for the extension of its ID.
+*/
+func createValidators(configs map[string][]ValidatorConfig, id, docType string) ([]namedValidator, error) {
+	if len(docType) == 0 {
+		docType = "text"
+	}
+	matched := configs[docType]
+	if ext := path.Ext(id); len(ext) > 0 {
+		matched = append(append([]ValidatorConfig{}, matched...), configs[ext]...)
+	}
+
+	validators := []namedValidator{}
+	for _, config := range matched {
+		validator, err := ValidatorFactory(config)
+		if err != nil {
+			return nil, err
+		}
+		validators = append(validators, namedValidator{name: config.Type, validate: validator})
+	}
+	return validators, nil
+}
+
+/*
+runValidators - Run validators against content and return a description of each problem found.
+*/
+func runValidators(validators []namedValidator, content string) []string {
+	problems := []string{}
+	for _, v := range validators {
+		if err := v.validate(content); err != nil {
+			problems = append(problems, fmt.Sprintf("%v: %v", v.name, err))
+		}
+	}
+	return problems
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+validateJSON - Content must parse as JSON.
+*/
+func validateJSON(content string) error {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			line := strings.Count(content[:syntaxErr.Offset], "\n") + 1
+			return fmt.Errorf("line %v: %v", line, err)
+		}
+		return err
+	}
+	return nil
+}
+
+/*
+validateYAML - A lightweight check for the YAML mistakes most often made by hand, which are tabs
+within indentation and unbalanced quotes or flow collections. This is not a full YAML parse, and
+quoted strings spanning multiple lines are not supported.
+*/
+func validateYAML(content string) error {
+	depth, blockIndent := 0, -1
+	for i, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := len(line) - len(trimmed)
+
+		// Lines of block scalars are content, and end when the indentation returns
+		if blockIndent >= 0 {
+			if len(strings.TrimSpace(trimmed)) == 0 || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if len(trimmed) == 0 || trimmed[0] == '#' {
+			continue
+		}
+		if strings.ContainsRune(line[:indent], '\t') {
+			return fmt.Errorf("line %v: tabs are not allowed in indentation", i+1)
+		}
+
+		var quote byte
+		code := trimmed
+	scan:
+		for j := 0; j < len(trimmed); j++ {
+			c := trimmed[j]
+			afterSpace := j == 0 || trimmed[j-1] == ' ' || trimmed[j-1] == '\t'
+			switch {
+			case quote != 0:
+				if c == quote {
+					quote = 0
+				}
+			case c == '#' && afterSpace:
+				code = trimmed[:j]
+				break scan
+			case c == '"' || c == '\'':
+				if afterSpace || strings.IndexByte(":[{,", trimmed[j-1]) >= 0 {
+					quote = c
+				}
+			case c == '[' || c == '{':
+				depth++
+			case c == ']' || c == '}':
+				if depth--; depth < 0 {
+					return fmt.Errorf("line %v: unexpected '%c'", i+1, c)
+				}
+			}
+		}
+		if quote != 0 {
+			return fmt.Errorf("line %v: unterminated quoted string", i+1)
+		}
+
+		// A header such as '|', '>-' or '|2' starts a block scalar on the following lines
+		if fields := strings.Fields(code); len(fields) > 0 {
+			if last := fields[len(fields)-1]; (last[0] == '|' || last[0] == '>') &&
+				len(strings.Trim(last[1:], "+-0123456789")) == 0 {
+				blockIndent = indent
+			}
+		}
+	}
+	if depth > 0 {
+		return errors.New("unterminated flow collection")
+	}
+	return nil
+}
+
+/*
+newLineLengthValidator - Creates a validator requiring that no line exceeds the configured length.
+*/
+func newLineLengthValidator(config ValidatorConfig) (Validator, error) {
+	if config.MaxLineLength <= 0 {
+		return nil, errors.New("max line length must be greater than zero")
+	}
+	return func(content string) error {
+		for i, line := range strings.Split(content, "\n") {
+			if length := utf8.RuneCountInString(line); length > config.MaxLineLength {
+				return fmt.Errorf("line %v: length %v exceeds %v", i+1, length, config.MaxLineLength)
+			}
+		}
+		return nil
+	}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestValidators(t *testing.T) {
+	lineLength := NewValidatorConfig()
+	lineLength.Type = "line_length"
+	lineLength.MaxLineLength = 10
+
+	for _, test := range []struct {
+		config  ValidatorConfig
+		content string
+		valid   bool
+	}{
+		{ValidatorConfig{Type: "json"}, `{"a":[1,2]}`, true},
+		{ValidatorConfig{Type: "json"}, "{\n\"a\":[1,2}", false},
+		{ValidatorConfig{Type: "yaml"}, "a:\n  - b\n  - 'c'\nd: {e: [1, 2]}\nf: don't # it's fine\n", true},
+		{ValidatorConfig{Type: "yaml"}, "a: |\n  \tindented \"text\n  [more\nb: c\n", true},
+		{ValidatorConfig{Type: "yaml"}, "a:\n\t- b\n", false},
+		{ValidatorConfig{Type: "yaml"}, "a: \"b\n", false},
+		{ValidatorConfig{Type: "yaml"}, "a: [b, c\n", false},
+		{lineLength, "short\nlines", true},
+		{lineLength, "short\nthis line is too long", false},
+	} {
+		validator, err := ValidatorFactory(test.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = validator(test.content); (err == nil) != test.valid {
+			t.Errorf("Wrong %v validation of %q: %v", test.config.Type, test.content, err)
+		}
+	}
+
+	if _, err := ValidatorFactory(ValidatorConfig{Type: "nope"}); err == nil {
+		t.Error("Expected invalid validator type to be rejected")
+	}
+}
+
+func TestBinderDiagnostics(t *testing.T) {
+	errChan := make(chan BinderError, 10)
+	doc := store.Document{ID: "config.json", Content: `{"a":1}`}
+	logger, stats := loggerAndStats()
+
+	config := DefaultBinderConfig()
+	config.FlushPeriod = 10
+	config.Validators = map[string][]ValidatorConfig{
+		".json": {{Type: "json"}},
+	}
+
+	docStore := &testStore{documents: map[string]store.Document{doc.ID: doc}}
+	binder, err := NewBinder(doc.ID, docStore, config, errChan, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binder.Close()
+
+	writer := binder.Subscribe("")
+	reader := binder.Subscribe("")
+	if _, err = writer.SendTransform(OTransform{Position: 6, Delete: 1, Version: 2}, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	<-reader.TransformRcvChan
+
+	select {
+	case msg := <-reader.MessageRcvChan:
+		if len(msg.Diagnostics) != 1 {
+			t.Errorf("Wrong diagnostics: %v", msg.Diagnostics)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for diagnostics")
+	}
+
+	select {
+	case err := <-errChan:
+		if err.Diagnostic == nil || err.Diagnostic.ID != doc.ID || err.Diagnostic.Version != 2 {
+			t.Errorf("Wrong diagnostic event: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for diagnostic event")
+	}
+
+	// The invalid content is flushed regardless
+	if stored, _ := docStore.Read(doc.ID); stored.Content != `{"a":1` {
+		t.Errorf("Invalid content was not flushed: %v", stored.Content)
+	}
+}
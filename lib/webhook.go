@@ -0,0 +1,158 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jeffail/leaps/lib/util"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+WebhookConfig - Holds configuration options for a webhook, events are posted as JSON to URL when it
+is set. Failed posts are retried up to Retries times, waiting RetryBackoff milliseconds before the
+first retry and doubling the wait before each subsequent one. Failed posts are not retried by
+default.
+*/
+type WebhookConfig struct {
+	URL          string            `json:"url" yaml:"url"`
+	Retries      int               `json:"retries" yaml:"retries"`
+	RetryBackoff int64             `json:"retry_backoff_ms" yaml:"retry_backoff_ms"`
+	Outbound     util.ClientConfig `json:"outbound" yaml:"outbound"`
+}
+
+/*
+NewWebhookConfig - Returns a default webhook configuration, the webhook is disabled.
+*/
+func NewWebhookConfig() WebhookConfig {
+	return WebhookConfig{
+		URL:          "",
+		Retries:      0,
+		RetryBackoff: 500,
+		Outbound:     util.NewClientConfig(),
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// webhookQueueSize - The most events a webhook holds while earlier events are being posted.
+const webhookQueueSize = 1000
+
+/*
+Webhook - Posts events as JSON to a configured URL. Events are posted in order in the background so
+that the caller never waits on the receiving end, including while failed posts are retried. Events
+arriving whilst the queue of the webhook is full are dropped.
+*/
+type Webhook struct {
+	config WebhookConfig
+	client *http.Client
+	queue  chan []byte
+	log    *log.Logger
+	stats  *log.Stats
+}
+
+/*
+NewWebhook - Creates a webhook for a configuration.
+*/
+func NewWebhook(config WebhookConfig, logger *log.Logger, stats *log.Stats) (*Webhook, error) {
+	client, err := util.NewHTTPClient(config.Outbound)
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		config: config,
+		client: client,
+		queue:  make(chan []byte, webhookQueueSize),
+		log:    logger.NewModule(":webhook"),
+		stats:  stats,
+	}
+	go w.loop()
+	return w, nil
+}
+
+/*
+Post - Post an event to the webhook in the background.
+*/
+func (w *Webhook) Post(event interface{}) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		w.stats.Incr("webhook.post.error", 1)
+		w.log.Errorf("Failed to encode event: %v\n", err)
+		return
+	}
+	select {
+	case w.queue <- body:
+	default:
+		w.stats.Incr("webhook.post.dropped", 1)
+		w.log.Errorf("Dropped event for %v, too many events are queued\n", w.config.URL)
+	}
+}
+
+/*
+loop - Post queued events one at a time, so that a retried event is never overtaken by later ones.
+*/
+func (w *Webhook) loop() {
+	for body := range w.queue {
+		backoff := time.Duration(w.config.RetryBackoff) * time.Millisecond
+		for attempt := 0; ; attempt++ {
+			err := w.post(body)
+			if err == nil {
+				w.stats.Incr("webhook.post.success", 1)
+				break
+			}
+			if attempt >= w.config.Retries {
+				w.stats.Incr("webhook.post.error", 1)
+				w.log.Errorf("Failed to post event to %v: %v\n", w.config.URL, err)
+				break
+			}
+			w.stats.Incr("webhook.post.retry", 1)
+			w.log.Warnf("Failed to post event to %v, retrying in %v: %v\n", w.config.URL, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	res, err := w.client.Post(w.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status: %v", res.Status)
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,118 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+MigrateConfig - Configuration for the migrate command, which copies every document from the
+configured storage into the Target store.
+*/
+type MigrateConfig struct {
+	Target store.Config `json:"target" yaml:"target"`
+}
+
+/*
+NewMigrateConfig - Returns a default migrate configuration.
+*/
+func NewMigrateConfig() MigrateConfig {
+	return MigrateConfig{
+		Target: store.NewConfig(),
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var (
+	migrateDryRun *bool
+)
+
+func init() {
+	migrateDryRun = flag.Bool("dry-run", false, "Validate a migration without writing to the target store")
+}
+
+/*
+isMigrateCommand - Checks whether leaps was run as `leaps migrate`, and if so removes the command
+from the arguments so that the remaining flags are parsed as normal.
+*/
+func isMigrateCommand() bool {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		return true
+	}
+	return false
+}
+
+/*
+runMigration - Migrates documents from the configured storage to the migration target, printing a
+report as JSON. Returns the exit code, which is non-zero if any document had issues.
+*/
+func runMigration(leapsConfig LeapsConfig) int {
+	source, err := store.Factory(leapsConfig.StoreConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Source store error: %v\n", err))
+		return 1
+	}
+	target, err := store.Factory(leapsConfig.MigrateConfig.Target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Target store error: %v\n", err))
+		return 1
+	}
+	codec, err := store.CodecFactory(leapsConfig.MigrateConfig.Target.Codec)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Target codec error: %v\n", err))
+		return 1
+	}
+
+	report, err := store.Migrate(source, target, codec, *migrateDryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Migration error: %v\n", err))
+		return 1
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Report error: %v\n", err))
+		return 1
+	}
+	fmt.Println(string(reportBytes))
+
+	if len(report.Issues) > 0 {
+		return 1
+	}
+	return 0
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AdaptiveConfig - Options for adapting how often each websocket client is sent updates to how well
+its connection keeps up. A connection is struggling when writing a message to it takes longer than
+SlowSendMS on average, which happens once the network buffers towards a slow client are full, or
+when more than BacklogLimit transforms are queued for it, or when the round trip time measured by
+the pings of the heartbeat extension exceeds SlowRTTMS. Transforms and cursor updates for a
+struggling client are held back and sent together at the end of a coalescing window, which doubles
+from MinWindowMS up to MaxWindowMS for as long as the connection struggles, and halves back to
+nothing once it recovers. Clients with healthy connections are sent everything as it happens.
+*/
+type AdaptiveConfig struct {
+	Enabled      bool  `json:"enabled" yaml:"enabled"`
+	SlowSendMS   int64 `json:"slow_send_ms" yaml:"slow_send_ms"`
+	SlowRTTMS    int64 `json:"slow_rtt_ms" yaml:"slow_rtt_ms"`
+	BacklogLimit int   `json:"backlog_limit" yaml:"backlog_limit"`
+	MinWindowMS  int64 `json:"min_window_ms" yaml:"min_window_ms"`
+	MaxWindowMS  int64 `json:"max_window_ms" yaml:"max_window_ms"`
+}
+
+/*
+NewAdaptiveConfig - Returns a default AdaptiveConfig.
+*/
+func NewAdaptiveConfig() AdaptiveConfig {
+	return AdaptiveConfig{
+		Enabled:      true,
+		SlowSendMS:   100,
+		SlowRTTMS:    500,
+		BacklogLimit: 16,
+		MinWindowMS:  50,
+		MaxWindowMS:  1000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+sendHealth - Tracks how well the connection of a websocket client keeps up with the messages sent
+to it, and the coalescing window this calls for.
+*/
+type sendHealth struct {
+	config  AdaptiveConfig
+	latency time.Duration
+	rtt     time.Duration
+	window  time.Duration
+}
+
+func newSendHealth(config AdaptiveConfig) *sendHealth {
+	return &sendHealth{config: config}
+}
+
+/*
+observe - Record the time taken to write a message to the client and the number of transforms
+queued behind it, and adjust the coalescing window accordingly.
+*/
+func (h *sendHealth) observe(took time.Duration, backlog int) {
+	if !h.config.Enabled {
+		return
+	}
+	// Smooth the latency so that a single slow write doesn't flip the window.
+	h.latency += (took - h.latency) / 4
+	h.adjust(backlog)
+}
+
+/*
+observeRTT - Record a round trip time measured by a heartbeat, and adjust the coalescing window
+accordingly.
+*/
+func (h *sendHealth) observeRTT(rtt time.Duration, backlog int) {
+	if !h.config.Enabled {
+		return
+	}
+	if h.rtt == 0 {
+		h.rtt = rtt
+	} else {
+		h.rtt += (rtt - h.rtt) / 2
+	}
+	h.adjust(backlog)
+}
+
+/*
+adjust - Grow the coalescing window while the connection struggles, and shrink it once it recovers.
+*/
+func (h *sendHealth) adjust(backlog int) {
+	slow := time.Duration(h.config.SlowSendMS) * time.Millisecond
+	slowRTT := time.Duration(h.config.SlowRTTMS) * time.Millisecond
+	minWindow := time.Duration(h.config.MinWindowMS) * time.Millisecond
+	maxWindow := time.Duration(h.config.MaxWindowMS) * time.Millisecond
+
+	switch {
+	case h.latency > slow || (slowRTT > 0 && h.rtt > slowRTT) || backlog > h.config.BacklogLimit:
+		if h.window *= 2; h.window < minWindow {
+			h.window = minWindow
+		}
+		if h.window > maxWindow {
+			h.window = maxWindow
+		}
+	case h.window > 0 && h.latency < slow/2 && h.rtt <= slowRTT/2 && backlog == 0:
+		if h.window /= 2; h.window < minWindow {
+			h.window = 0
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+coalescedMessages - The transforms and cursor updates held back from a struggling client until the
+end of its coalescing window.
+*/
+type coalescedMessages struct {
+	transforms []lib.OTransform
+	updates    []lib.ClientMessage
+}
+
+/*
+addUpdate - Hold back an update, a cursor update replaces any earlier cursor update from the same
+user still being held back as it is no longer of interest.
+*/
+func (c *coalescedMessages) addUpdate(msg lib.ClientMessage) {
+	if len(msg.Message) == 0 {
+		for i, held := range c.updates {
+			if held.UserID == msg.UserID && len(held.Message) == 0 {
+				c.updates[i] = msg
+				return
+			}
+		}
+	}
+	c.updates = append(c.updates, msg)
+}
+
+func (c *coalescedMessages) empty() bool {
+	return len(c.transforms) == 0 && len(c.updates) == 0
+}
+
+/*
+isUpdate - Returns whether a message from the binder is a plain update of a users status, rather than
+a notice with its own response type.
+*/
+func isUpdate(msg lib.ClientMessage) bool {
+	return msg.Spectators == nil && len(msg.Diagnostics) == 0 && len(msg.Presence) == 0 &&
+		msg.Signal == nil && msg.Digest == nil && !msg.Kicked && !msg.Shutdown && msg.Degraded == nil &&
+		msg.DocumentMetadata == nil && msg.Comment == nil && msg.Banner == nil &&
+		msg.Announcement == nil
+}
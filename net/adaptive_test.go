@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+func TestSendHealthWindow(t *testing.T) {
+	config := NewAdaptiveConfig()
+	health := newSendHealth(config)
+
+	for i := 0; i < 10; i++ {
+		health.observe(time.Millisecond, 0)
+	}
+	if health.window != 0 {
+		t.Errorf("Healthy connection was given a window: %v", health.window)
+	}
+
+	health.observe(time.Millisecond, config.BacklogLimit+1)
+	if exp := 50 * time.Millisecond; health.window != exp {
+		t.Errorf("Wrong window for backlog: %v != %v", health.window, exp)
+	}
+	for i := 0; i < 20; i++ {
+		health.observe(time.Second, 0)
+	}
+	if exp := time.Second; health.window != exp {
+		t.Errorf("Window was not capped: %v != %v", health.window, exp)
+	}
+
+	for i := 0; i < 40 && health.window > 0; i++ {
+		health.observe(0, 0)
+	}
+	if health.window != 0 {
+		t.Errorf("Window did not recover: %v", health.window)
+	}
+
+	config.Enabled = false
+	disabled := newSendHealth(config)
+	disabled.observe(time.Second, 100)
+	if disabled.window != 0 {
+		t.Errorf("Disabled health was given a window: %v", disabled.window)
+	}
+}
+
+func TestCoalescedUpdates(t *testing.T) {
+	position := func(p int64) *int64 { return &p }
+
+	var held coalescedMessages
+	held.addUpdate(lib.ClientMessage{UserID: "a", Position: position(1)})
+	held.addUpdate(lib.ClientMessage{UserID: "b", Position: position(2)})
+	held.addUpdate(lib.ClientMessage{UserID: "a", Message: "hello"})
+	held.addUpdate(lib.ClientMessage{UserID: "a", Position: position(3)})
+
+	if exp, act := 3, len(held.updates); exp != act {
+		t.Fatalf("Wrong count of held updates: %v != %v", exp, act)
+	}
+	if *held.updates[0].Position != 3 {
+		t.Errorf("Cursor update was not replaced: %v", *held.updates[0].Position)
+	}
+	if held.updates[2].Message != "hello" {
+		t.Errorf("Message update was lost: %v", held.updates[2])
+	}
+	if !isUpdate(held.updates[0]) || isUpdate(lib.ClientMessage{Kicked: true}) {
+		t.Error("Wrong update classification")
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AffinityConfig - Options for emitting an affinity key during the websocket upgrade. The key is a
+hash of the document ID found in the QueryParam of the socket URL, and is written to the response
+Header and set as a Cookie so that layer-7 load balancers can route every client of a document to
+the same node. Empty Header or Cookie values disable that part of the hint.
+*/
+type AffinityConfig struct {
+	Enabled    bool   `json:"enabled" yaml:"enabled"`
+	QueryParam string `json:"query_param" yaml:"query_param"`
+	Header     string `json:"header" yaml:"header"`
+	Cookie     string `json:"cookie" yaml:"cookie"`
+}
+
+/*
+NewAffinityConfig - Creates a new AffinityConfig object with default values, affinity hints are
+disabled.
+*/
+func NewAffinityConfig() AffinityConfig {
+	return AffinityConfig{
+		Enabled:    false,
+		QueryParam: "document_id",
+		Header:     "X-Leaps-Affinity",
+		Cookie:     "leaps_affinity",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AffinityKey - Returns the affinity key of a document ID, the hex encoded 64 bit FNV-1a hash of the
+ID. The key is the same on every node, so it can also be computed by the load balancer itself.
+*/
+func AffinityKey(documentID string) string {
+	h := fnv.New64a()
+	h.Write([]byte(documentID))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+/*
+affinityHandshake - Returns a websocket handshake func that performs the same origin check as the
+default websocket handler, and then adds the affinity hints to the upgrade response.
+*/
+func affinityHandshake(config AffinityConfig) func(*websocket.Config, *http.Request) error {
+	return func(wsConfig *websocket.Config, r *http.Request) (err error) {
+		if wsConfig.Origin, err = websocket.Origin(wsConfig, r); err == nil && wsConfig.Origin == nil {
+			return fmt.Errorf("null origin")
+		}
+		if err != nil || !config.Enabled {
+			return err
+		}
+
+		documentID := r.URL.Query().Get(config.QueryParam)
+		if len(documentID) == 0 {
+			return nil
+		}
+		key := AffinityKey(documentID)
+
+		if wsConfig.Header == nil {
+			wsConfig.Header = http.Header{}
+		}
+		if len(config.Header) > 0 {
+			wsConfig.Header.Set(config.Header, key)
+		}
+		if len(config.Cookie) > 0 {
+			cookie := http.Cookie{Name: config.Cookie, Value: key, Path: "/"}
+			wsConfig.Header.Add("Set-Cookie", cookie.String())
+		}
+		return nil
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
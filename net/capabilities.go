@@ -0,0 +1,67 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"github.com/jeffail/leaps/lib"
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ServerCapabilities - The features and limits of a server, which are advertised to websocket clients
+in a 'hello' message as soon as they connect when AdvertiseCapabilities is set. Along with the
+document capabilities of the locator this lists the init Commands accepted, the protocol Extensions
+that may be agreed on, the largest websocket message in bytes the server accepts, and whether the
+playback endpoint is served.
+*/
+type ServerCapabilities struct {
+	lib.Capabilities `yaml:",inline"`
+	Commands         []string `json:"commands" yaml:"commands"`
+	Extensions       []string `json:"extensions" yaml:"extensions"`
+	MaxMessageSize   int      `json:"max_message_size" yaml:"max_message_size"`
+	Playback         bool     `json:"playback" yaml:"playback"`
+}
+
+/*
+capabilities - Returns the capabilities of the server and its locator. Presence is only advertised
+when the presence extension is enabled, as clients cannot subscribe to it otherwise.
+*/
+func (h *HTTPServer) capabilities() ServerCapabilities {
+	caps := ServerCapabilities{
+		Commands:       []string{"create", "find", "read"},
+		Extensions:     append([]string{}, h.config.Extensions...),
+		MaxMessageSize: websocket.DefaultMaxPayloadBytes,
+		Playback:       len(h.config.Playback.Path) > 0,
+	}
+	if reporter, ok := h.locator.(CapabilityReporter); ok {
+		caps.Capabilities = reporter.Capabilities()
+	}
+	caps.Presence = caps.Presence && hasExtension(h.config.Extensions, ExtensionPresence)
+	return caps
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,51 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"testing"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+func TestCapabilitiesPresence(t *testing.T) {
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	config := DefaultHTTPServerConfig()
+	h := &HTTPServer{config: config, locator: curator}
+	if caps := h.capabilities(); !caps.Presence {
+		t.Error("Presence was not advertised with the presence extension enabled")
+	}
+
+	h.config.Extensions = withoutExtension(config.Extensions, ExtensionPresence)
+	if caps := h.capabilities(); caps.Presence {
+		t.Error("Presence was advertised with the presence extension disabled")
+	}
+}
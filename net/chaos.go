@@ -0,0 +1,148 @@
+//go:build chaos
+// +build chaos
+
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ChaosAdmin - An optional extension of LeapAdmin for injecting session faults, only available in
+builds with the chaos tag.
+*/
+type ChaosAdmin interface {
+	// Kick up to count random users from open documents.
+	KillRandomSessions(count int, timeout time.Duration) ([]string, error)
+}
+
+/*
+chaosHandler - Wraps a chaos endpoint handler with method checking, the handler is given a func for
+parsing the JSON body of the request into its request object.
+*/
+func (i *InternalServer) chaosHandler(
+	name string, handler func(w http.ResponseWriter, decode func(interface{}) error) error,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			i.stats.Incr("http_admin.chaos.error", 1)
+			i.logger.Warnf("%v: Wrong method %v\n", name, r.Method)
+			http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+			return
+		}
+		var decodeErr error
+		decode := func(reqObj interface{}) error {
+			bodyBytes, err := ioutil.ReadAll(r.Body)
+			if err == nil {
+				err = json.Unmarshal(bodyBytes, reqObj)
+			}
+			decodeErr = err
+			return err
+		}
+		if err := handler(w, decode); err != nil {
+			i.stats.Incr("http_admin.chaos.error", 1)
+			i.logger.Errorf("%v: %v\n", name, err)
+			if decodeErr != nil {
+				http.Error(w, "Bad data", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to inject fault", http.StatusInternalServerError)
+			return
+		}
+		i.stats.Incr("http_admin.chaos.success", 1)
+		i.logger.Warnf("%v: Injected fault\n", name)
+	}
+}
+
+/*
+registerChaosEndpoints - Register the fault injection endpoints of a chaos build.
+*/
+func (i *InternalServer) registerChaosEndpoints() {
+	i.logger.Warnln("This is a chaos build, fault injection endpoints are enabled")
+
+	i.Register("/chaos/flush_delay", `<POST> Delay every periodic document flush {"delay_ms":<ms>}`,
+		i.chaosHandler("/chaos/flush_delay", func(w http.ResponseWriter, decode func(interface{}) error) error {
+			flushReq := struct {
+				DelayMS int64 `json:"delay_ms"`
+			}{}
+			if err := decode(&flushReq); err != nil {
+				return err
+			}
+			lib.SetChaosFlushDelay(time.Duration(flushReq.DelayMS) * time.Millisecond)
+			w.Write([]byte("Success"))
+			return nil
+		}))
+
+	i.Register("/chaos/drop_broadcasts", `<POST> Drop a ratio of broadcasts to clients {"rate":<0-1>}`,
+		i.chaosHandler("/chaos/drop_broadcasts", func(w http.ResponseWriter, decode func(interface{}) error) error {
+			dropReq := struct {
+				Rate float64 `json:"rate"`
+			}{}
+			if err := decode(&dropReq); err != nil {
+				return err
+			}
+			lib.SetChaosDropRate(dropReq.Rate)
+			w.Write([]byte("Success"))
+			return nil
+		}))
+
+	chaosAdmin, ok := i.admin.(ChaosAdmin)
+	if !ok {
+		return
+	}
+	i.Register("/chaos/kill_sessions", `<POST> Kick random users from open documents {"count":<n>}`,
+		i.chaosHandler("/chaos/kill_sessions", func(w http.ResponseWriter, decode func(interface{}) error) error {
+			killReq := struct {
+				Count int `json:"count"`
+			}{}
+			if err := decode(&killReq); err != nil {
+				return err
+			}
+			killed, err := chaosAdmin.KillRandomSessions(
+				killReq.Count, time.Second*time.Duration(i.config.RequestTimeout),
+			)
+			if err != nil {
+				return err
+			}
+			resultBytes, err := json.Marshal(killed)
+			if err != nil {
+				return err
+			}
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+			return nil
+		}))
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
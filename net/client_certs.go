@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"crypto/x509"
+	"net/http"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+CertificateAuthenticator - Implemented by authenticators that identify clients by the certificates of
+their TLS connections rather than by the tokens they send. The certificate of a connection is
+exchanged for a token and the identity of the client, which take the place of those in the init
+message of the client, and the token is released once the connection closes. Tokens sent by clients
+themselves are verified against the certificate of their connection, so that the token of one
+connection cannot be presented by another.
+*/
+type CertificateAuthenticator interface {
+	// AuthenticateCertificate - Exchange a verified client certificate for a token and user ID.
+	AuthenticateCertificate(cert *x509.Certificate) (token, userID string, err error)
+
+	// ReleaseToken - Forget the token of a closed connection.
+	ReleaseToken(token string)
+
+	// VerifyToken - Check that a token may be presented by a connection with a certificate, which
+	// is nil for connections without one.
+	VerifyToken(token string, cert *x509.Certificate) bool
+}
+
+/*
+SetCertificateAuthenticator - Identify clients that connect with a certificate verified against the
+client CA of the SSL config through an authenticator, must be called before Listen.
+*/
+func (h *HTTPServer) SetCertificateAuthenticator(certAuth CertificateAuthenticator) {
+	h.certAuth = certAuth
+}
+
+/*
+authenticateCertificate - Returns the token and user ID of a client connection with a verified
+client certificate, or empty strings if the connection has none or it could not be authenticated.
+*/
+func (h *HTTPServer) authenticateCertificate(t Transport) (string, string) {
+	if h.certAuth == nil {
+		return "", ""
+	}
+	req := transportRequest(t)
+	if req == nil || req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return "", ""
+	}
+	token, userID, err := h.certAuth.AuthenticateCertificate(req.TLS.VerifiedChains[0][0])
+	if err != nil {
+		h.logger.Warnf("Rejected client certificate: %v\n", err)
+		h.stats.Incr("http.websocket.certificate.rejected", 1)
+		return "", ""
+	}
+	return token, userID
+}
+
+/*
+verifyClientToken - Returns false when a token sent by a client was issued for the certificate of
+another connection.
+*/
+func (h *HTTPServer) verifyClientToken(req *http.Request, token string) bool {
+	if h.certAuth == nil || len(token) == 0 {
+		return true
+	}
+	var cert *x509.Certificate
+	if req != nil && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		cert = req.TLS.VerifiedChains[0][0]
+	}
+	if !h.certAuth.VerifyToken(token, cert) {
+		h.logger.Warnln("Rejected a token issued for another connection")
+		h.stats.Incr("http.certificate.token_rejected", 1)
+		return false
+	}
+	return true
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
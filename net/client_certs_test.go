@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+	"golang.org/x/net/websocket"
+)
+
+func TestCertificateAuthenticator(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDer)
+
+	clientKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	clientDer, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "alice"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, stats := loggerAndStats()
+
+	authConfig := auth.NewConfig()
+	authConfig.MTLSConfig.Rules = []auth.MTLSRule{{Identity: "alice", Access: "write"}}
+	mtls, err := auth.NewMTLS(authConfig, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, _ := store.Factory(store.NewConfig())
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, mtls, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	h := HTTPServer{config: DefaultHTTPServerConfig(), locator: curator, logger: logger, stats: stats}
+	h.SetCertificateAuthenticator(mtls)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	server := httptest.NewUnstartedServer(websocket.Handler(h.websocketHandler))
+	server.TLS = &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	server.StartTLS()
+	defer server.Close()
+
+	serverPool := x509.NewCertPool()
+	serverPool.AddCert(server.Certificate())
+
+	create := func(certs []tls.Certificate, token, userID string) LeapServerMessage {
+		config, err := websocket.NewConfig("wss"+strings.TrimPrefix(server.URL, "https"), "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.TlsConfig = &tls.Config{RootCAs: serverPool, Certificates: certs}
+		ws, err := websocket.DialConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+
+		websocket.JSON.Send(ws, LeapClientMessage{
+			Command:  "create",
+			Token:    token,
+			UserID:   userID,
+			Document: &store.Document{Content: "hello world"},
+		})
+		var res LeapServerMessage
+		if err = websocket.JSON.Receive(ws, &res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	res := create([]tls.Certificate{{Certificate: [][]byte{clientDer}, PrivateKey: clientKey}}, "ignored", "mallory")
+	if res.Type != "document" {
+		t.Errorf("Client with certificate failed to create: %v", res.Error)
+	}
+
+	res = create(nil, "ignored", "mallory")
+	if res.Type != "error" || res.ErrorInfo == nil || res.ErrorInfo.Code != ErrorCodeAuthFailed {
+		t.Errorf("Client without certificate was not refused: %v %v", res.Type, res.ErrorInfo)
+	}
+
+	// The token of a connection with a certificate is refused from any other connection.
+	clientCert, _ := x509.ParseCertificate(clientDer)
+	stolen, _, err := mtls.AuthenticateCertificate(clientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mtls.ReleaseToken(stolen)
+
+	res = create(nil, stolen, "alice")
+	if res.Type != "error" || res.ErrorInfo == nil || res.ErrorInfo.Code != ErrorCodeAuthFailed {
+		t.Errorf("Token of another connection was not refused: %v %v", res.Type, res.ErrorInfo)
+	}
+}
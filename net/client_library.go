@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+//go:generate go run gen_jsclient.go
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ClientLibraryConfig - Options for serving the JS client that is embedded within the leaps binary.
+The client is served at Path when set, where Path/leaps.js redirects to a filename carrying the hash
+of the client content, and Path/manifest.json maps leaps.js to that filename. The hashed filename is
+cached by browsers indefinitely, since a server upgrade that changes the client also changes it.
+*/
+type ClientLibraryConfig struct {
+	Path string `json:"path" yaml:"path"`
+}
+
+/*
+NewClientLibraryConfig - Creates a new ClientLibraryConfig object with default values, the client is
+not served.
+*/
+func NewClientLibraryConfig() ClientLibraryConfig {
+	return ClientLibraryConfig{
+		Path: "",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+jsClientName - The content hashed filename of the embedded JS client.
+*/
+func jsClientName() string {
+	return "leaps-" + jsClientHash + ".js"
+}
+
+/*
+clientLibraryHandler - Serves the embedded JS client. Requests for the unhashed name, or for the
+hashed name of any other build of the client, are redirected to the current one and must be
+revalidated on each use, whereas the current client is immutable.
+*/
+func (h *HTTPServer) clientLibraryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "Supports GET verb only", http.StatusMethodNotAllowed)
+		return
+	}
+	root := strings.TrimSuffix(h.config.ClientLibrary.Path, "/")
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, root), "/")
+
+	switch {
+	case name == jsClientName():
+		w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("ETag", `"`+jsClientHash+`"`)
+		http.ServeContent(w, r, name, time.Time{}, strings.NewReader(jsClientSource))
+	case name == "leaps.js" || (strings.HasPrefix(name, "leaps-") && strings.HasSuffix(name, ".js")):
+		h.stats.Incr("http.client_library.redirected", 1)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, path.Join(root, jsClientName()), http.StatusFound)
+	case name == "manifest.json":
+		js, err := json.Marshal(map[string]string{"leaps.js": jsClientName()})
+		if err != nil {
+			http.Error(w, "Internal server issue", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(js)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
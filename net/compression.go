@@ -0,0 +1,210 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+CompressionConfig - Options for compressing the messages sent to websocket clients that agree on
+the deflate extension of the leaps protocol. Messages of at least ThresholdBytes of JSON are
+compressed with raw deflate at Level (1 for the fastest through 9 for the smallest) and sent as
+binary frames, smaller messages are sent as text frames as usual. Large pastes fan out to every
+client of a document, and so compress well worth the effort.
+
+This is not the permessage-deflate extension of RFC 7692, which is negotiated in the websocket
+handshake and marks compressed frames with the RSV1 bit. The websocket library of leaps supports
+neither, and so clients decompress binary frames themselves.
+*/
+type CompressionConfig struct {
+	Enabled        bool `json:"enabled" yaml:"enabled"`
+	ThresholdBytes int  `json:"threshold_bytes" yaml:"threshold_bytes"`
+	Level          int  `json:"level" yaml:"level"`
+}
+
+/*
+NewCompressionConfig - Returns a default CompressionConfig.
+*/
+func NewCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Enabled:        true,
+		ThresholdBytes: 4096,
+		Level:          flate.BestSpeed,
+	}
+}
+
+// Errors for message compression.
+var (
+	ErrInvalidCompressionLevel = errors.New("compression level must be between 1 and 9")
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+recentDeflations - The number of recently compressed messages a messageDeflater holds on to.
+*/
+const recentDeflations = 16
+
+/*
+deflation - A message being compressed, ready is closed once data is set.
+*/
+type deflation struct {
+	ready chan struct{}
+	data  []byte
+}
+
+/*
+messageDeflater - Sends messages to websocket clients, compressing those that are large enough. The
+most recent compressions are shared, so that a message broadcast to every client of a document is
+compressed once rather than once per client. A nil messageDeflater sends every message uncompressed.
+*/
+type messageDeflater struct {
+	threshold int
+	writers   sync.Pool
+	mutex     sync.Mutex
+	recent    map[string]*deflation
+	order     []string
+}
+
+/*
+newMessageDeflater - Returns a messageDeflater for a config, or nil when compression is disabled.
+*/
+func newMessageDeflater(config CompressionConfig) (*messageDeflater, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if config.Level < flate.BestSpeed || config.Level > flate.BestCompression {
+		return nil, ErrInvalidCompressionLevel
+	}
+	level := config.Level
+	return &messageDeflater{
+		threshold: config.ThresholdBytes,
+		recent:    map[string]*deflation{},
+		writers: sync.Pool{New: func() interface{} {
+			w, _ := flate.NewWriter(nil, level)
+			return w
+		}},
+	}, nil
+}
+
+/*
+compress - Returns the raw deflate compression of data.
+*/
+func (d *messageDeflater) compress(data []byte) []byte {
+	var buf bytes.Buffer
+	w := d.writers.Get().(*flate.Writer)
+	w.Reset(&buf)
+	w.Write(data)
+	w.Close()
+	d.writers.Put(w)
+	return buf.Bytes()
+}
+
+/*
+send - Send a message to a client, as a compressed binary frame if it is large enough and the client
+is connected by websocket.
+*/
+func (d *messageDeflater) send(t Transport, msg interface{}) error {
+	if _, ok := t.(*websocketTransport); d == nil || !ok {
+		return t.Send(msg)
+	}
+	frame, err := d.frame(msg)
+	if err != nil {
+		return err
+	}
+	return frame.send(t)
+}
+
+/*
+frame - Returns the JSON of a message as a websocket frame, compressed if it is large enough.
+*/
+func (d *messageDeflater) frame(msg interface{}) (wireFrame, error) {
+	data, err := json.Marshal(msg)
+	if err != nil || d == nil || len(data) < d.threshold {
+		return wireFrame{data: data}, err
+	}
+	return wireFrame{data: d.compressShared(data), binary: true}, nil
+}
+
+/*
+compressShared - Returns the raw deflate compression of data, reusing that of a recent call with
+the same data. Concurrent calls for the same data wait for the first to compress it.
+*/
+func (d *messageDeflater) compressShared(data []byte) []byte {
+	key := string(data)
+
+	d.mutex.Lock()
+	if entry, exists := d.recent[key]; exists {
+		d.mutex.Unlock()
+		<-entry.ready
+		return entry.data
+	}
+	entry := &deflation{ready: make(chan struct{})}
+	d.recent[key] = entry
+	if d.order = append(d.order, key); len(d.order) > recentDeflations {
+		delete(d.recent, d.order[0])
+		d.order = d.order[1:]
+	}
+	d.mutex.Unlock()
+
+	entry.data = d.compress(data)
+	close(entry.ready)
+	return entry.data
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+wireFrame - A message encoded ready to send, either JSON in a text frame or JSON compressed by the
+deflate extension in a binary frame.
+*/
+type wireFrame struct {
+	data   []byte
+	binary bool
+}
+
+/*
+send - Send the frame to a client. Binary frames may only be sent to websocket clients.
+*/
+func (f wireFrame) send(t Transport) error {
+	ws, ok := t.(*websocketTransport)
+	if !ok {
+		return t.Send(json.RawMessage(f.data))
+	}
+	if f.binary {
+		return websocket.Message.Send(ws.Conn, f.data)
+	}
+	return websocket.Message.Send(ws.Conn, string(f.data))
+}
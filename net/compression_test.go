@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestMessageDeflater(t *testing.T) {
+	config := NewCompressionConfig()
+	config.Level = 0
+	if _, err := newMessageDeflater(config); err != ErrInvalidCompressionLevel {
+		t.Errorf("Wrong error for invalid level: %v", err)
+	}
+	config.Enabled = false
+	if d, err := newMessageDeflater(config); d != nil || err != nil {
+		t.Errorf("Disabled config returned deflater: %v, %v", d, err)
+	}
+
+	deflater, err := newMessageDeflater(NewCompressionConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := strings.Repeat("paste ", 10000)
+	server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		deflater.send(newWebsocketTransport(ws), LeapSocketServerMessage{Type: "update", Notice: "small"})
+		deflater.send(newWebsocketTransport(ws), LeapSocketServerMessage{Type: "update", Notice: large})
+	}))
+	defer server.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	var small string
+	if err = websocket.Message.Receive(ws, &small); err != nil {
+		t.Fatal(err)
+	}
+	var msg LeapSocketServerMessage
+	if err = json.Unmarshal([]byte(small), &msg); err != nil || msg.Notice != "small" {
+		t.Errorf("Wrong small message: %v, %v", small, err)
+	}
+
+	var compressed []byte
+	if err = websocket.Message.Receive(ws, &compressed); err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(large) {
+		t.Errorf("Large message was not compressed: %v bytes", len(compressed))
+	}
+	data, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(data, &msg); err != nil || msg.Notice != large {
+		t.Errorf("Wrong large message: %v", err)
+	}
+}
+
+func TestMessageDeflaterSharesCompression(t *testing.T) {
+	deflater, err := newMessageDeflater(NewCompressionConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broadcast := LeapSocketServerMessage{Type: "update", Notice: strings.Repeat("paste ", 10000)}
+	first, err := deflater.frame(broadcast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := deflater.frame(broadcast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.binary || &first.data[0] != &second.data[0] {
+		t.Error("Expected a broadcast message to be compressed once")
+	}
+
+	for i := 0; i < recentDeflations+1; i++ {
+		deflater.frame(LeapSocketServerMessage{Type: "update", Notice: strings.Repeat("paste ", 1000+i)})
+	}
+	if len(deflater.recent) != recentDeflations || len(deflater.order) != recentDeflations {
+		t.Errorf("Wrong number of recent compressions: %v", len(deflater.recent))
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/test/conformance"
+	"golang.org/x/net/websocket"
+)
+
+func TestProtocolConformance(t *testing.T) {
+	fixtures, err := conformance.ProtocolFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	h := HTTPServer{config: DefaultHTTPServerConfig(), locator: curator, logger: logger, stats: stats}
+	server := httptest.NewServer(websocket.Handler(h.websocketHandler))
+	defer server.Close()
+
+	for _, fixture := range fixtures {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, step := range fixture.Steps {
+			if len(step.Send) > 0 {
+				if err = websocket.Message.Send(ws, string(step.Send)); err != nil {
+					t.Errorf("%v step %v: send error: %v", fixture.Name, i, err)
+					break
+				}
+				continue
+			}
+
+			ws.SetReadDeadline(time.Now().Add(time.Second))
+			var received []byte
+			if err = websocket.Message.Receive(ws, &received); err != nil {
+				t.Errorf("%v step %v: receive error: %v", fixture.Name, i, err)
+				break
+			}
+			if !conformance.Matches(step.Expect, received) {
+				t.Errorf("%v step %v: expected %s, received %s", fixture.Name, i, step.Expect, received)
+			}
+		}
+		ws.Close()
+	}
+}
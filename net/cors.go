@@ -0,0 +1,215 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jeffail/util/log"
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the CORSMiddleware type.
+var (
+	ErrInvalidOriginPattern = errors.New("invalid config value for allowed origin pattern")
+	ErrOriginNotAllowed     = errors.New("origin not allowed")
+	ErrWildcardCredentials  = errors.New("allowing credentials from any origin is not permitted")
+)
+
+/*
+CORSConfig - Holds configuration options for the CORSMiddleware, which decides which origins may
+open websockets and make cross origin requests to the HTTP endpoints. AllowedOrigins lists origins
+such as 'https://docs.example.com', where '*' matches any part of a host name such as in
+'https://*.example.com', or matches any origin on its own, in which case AllowCredentials may not be
+set. AllowedOriginPatterns lists regular expressions that must match the whole origin. When both are
+empty websockets may be opened from any origin and no CORS headers are sent, leaving cross origin
+requests to the same origin policy of the browser.
+*/
+type CORSConfig struct {
+	AllowedOrigins        []string `json:"allowed_origins" yaml:"allowed_origins"`
+	AllowedOriginPatterns []string `json:"allowed_origin_patterns" yaml:"allowed_origin_patterns"`
+	AllowedMethods        []string `json:"allowed_methods" yaml:"allowed_methods"`
+	AllowedHeaders        []string `json:"allowed_headers" yaml:"allowed_headers"`
+	AllowCredentials      bool     `json:"allow_credentials" yaml:"allow_credentials"`
+	MaxAge                int      `json:"max_age_s" yaml:"max_age_s"`
+}
+
+/*
+NewCORSConfig - Returns a CORSMiddleware configuration with the default values, where any origin may
+open websockets.
+*/
+func NewCORSConfig() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins:        []string{},
+		AllowedOriginPatterns: []string{},
+		AllowedMethods:        []string{"GET", "POST", "OPTIONS"},
+		AllowedHeaders:        []string{"Content-Type", "Authorization"},
+		AllowCredentials:      false,
+		MaxAge:                600,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+CORSMiddleware - Checks the Origin of websocket upgrades against an allow-list, and adds CORS headers
+to the responses of HTTP endpoints for allowed origins, answering their preflight requests.
+*/
+type CORSMiddleware struct {
+	config   CORSConfig
+	patterns []*regexp.Regexp
+	logger   *log.Logger
+	stats    *log.Stats
+}
+
+/*
+NewCORSMiddleware - Create a new leaps CORSMiddleware.
+*/
+func NewCORSMiddleware(config CORSConfig, logger *log.Logger, stats *log.Stats) (*CORSMiddleware, error) {
+	cors := CORSMiddleware{
+		config: config,
+		logger: logger.NewModule(":cors"),
+		stats:  stats,
+	}
+	for _, origin := range config.AllowedOrigins {
+		if _, err := path.Match(origin, ""); err != nil {
+			return nil, ErrInvalidOriginPattern
+		}
+		// Any origin could then read responses made with the cookies of a user
+		if origin == "*" && config.AllowCredentials {
+			return nil, ErrWildcardCredentials
+		}
+	}
+	for _, pattern := range config.AllowedOriginPatterns {
+		exp, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, ErrInvalidOriginPattern
+		}
+		cors.patterns = append(cors.patterns, exp)
+	}
+	return &cors, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+restricted - Whether origins are checked at all.
+*/
+func (c *CORSMiddleware) restricted() bool {
+	return c != nil && (len(c.config.AllowedOrigins) > 0 || len(c.patterns) > 0)
+}
+
+/*
+allowed - Whether an origin matches the allow-list.
+*/
+func (c *CORSMiddleware) allowed(origin string) bool {
+	for _, allowed := range c.config.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+		if match, _ := path.Match(strings.ToLower(allowed), strings.ToLower(origin)); match {
+			return true
+		}
+	}
+	for _, exp := range c.patterns {
+		if exp.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+WrapHandshake - Wrap a websocket handshake func so that upgrades from origins outside of the
+allow-list are refused.
+*/
+func (c *CORSMiddleware) WrapHandshake(handshake func(*websocket.Config, *http.Request) error) func(*websocket.Config, *http.Request) error {
+	if !c.restricted() {
+		return handshake
+	}
+	return func(wsConfig *websocket.Config, r *http.Request) error {
+		if origin := r.Header.Get("Origin"); !c.allowed(origin) {
+			c.stats.Incr("http.cors.websocket.rejected", 1)
+			c.logger.Debugf("Refused websocket from origin %v\n", origin)
+			return ErrOriginNotAllowed
+		}
+		return handshake(wsConfig, r)
+	}
+}
+
+/*
+WrapHandlerFunc - Wrap an http request HandlerFunc with CORS headers for allowed origins. Preflight
+requests are answered directly, and refused with a 403 when their origin is not allowed.
+*/
+func (c *CORSMiddleware) WrapHandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
+	if !c.restricted() {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			handler(w, r)
+			return
+		}
+		preflight := r.Method == "OPTIONS" && len(r.Header.Get("Access-Control-Request-Method")) > 0
+
+		w.Header().Add("Vary", "Origin")
+		if !c.allowed(origin) {
+			c.stats.Incr("http.cors.rejected", 1)
+			if preflight {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			handler(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if c.config.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if !preflight {
+			handler(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(c.config.AllowedMethods, ", "))
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.config.AllowedHeaders, ", "))
+		if c.config.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.config.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestCORSOrigins(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewCORSConfig()
+	config.AllowedOrigins = []string{"https://docs.example.com", "https://*.leaps.io"}
+	config.AllowedOriginPatterns = []string{`http://localhost(:\d+)?`}
+
+	cors, err := NewCORSMiddleware(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for origin, exp := range map[string]bool{
+		"https://docs.example.com":      true,
+		"https://DOCS.example.com":      true,
+		"https://evil.example.com":      false,
+		"https://team.leaps.io":         true,
+		"https://leaps.io":              false,
+		"http://localhost":              true,
+		"http://localhost:8080":         true,
+		"http://localhost.evil.com":     false,
+		"http://localhost:8080/foo/bar": false,
+		"":                              false,
+	} {
+		if act := cors.allowed(origin); act != exp {
+			t.Errorf("Wrong result for %v: %v != %v", origin, act, exp)
+		}
+	}
+
+	config.AllowedOriginPatterns = []string{"("}
+	if _, err = NewCORSMiddleware(config, logger, stats); err != ErrInvalidOriginPattern {
+		t.Errorf("Wrong error for invalid pattern: %v", err)
+	}
+
+	config = NewCORSConfig()
+	config.AllowedOrigins = []string{"*"}
+	config.AllowCredentials = true
+	if _, err = NewCORSMiddleware(config, logger, stats); err != ErrWildcardCredentials {
+		t.Errorf("Wrong error for credentials from any origin: %v", err)
+	}
+
+	var nilCORS *CORSMiddleware
+	if nilCORS.restricted() {
+		t.Error("Nil middleware restricts origins")
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewCORSConfig()
+	config.AllowedOrigins = []string{"https://docs.example.com"}
+	config.AllowCredentials = true
+
+	cors, err := NewCORSMiddleware(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	called := 0
+	handler := cors.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		w.Write([]byte("hello"))
+	})
+
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/leaps/api", nil)
+		if len(origin) > 0 {
+			r.Header.Set("Origin", origin)
+		}
+		if method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	w := request("GET", "https://docs.example.com")
+	if w.Header().Get("Access-Control-Allow-Origin") != "https://docs.example.com" ||
+		w.Header().Get("Access-Control-Allow-Credentials") != "true" || called != 1 {
+		t.Errorf("Allowed request wrongly handled: %v %v", w.Header(), called)
+	}
+
+	w = request("OPTIONS", "https://docs.example.com")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "GET, POST, OPTIONS" ||
+		w.Header().Get("Access-Control-Max-Age") != "600" || called != 1 {
+		t.Errorf("Allowed preflight wrongly handled: %v %v %v", w.Code, w.Header(), called)
+	}
+
+	w = request("OPTIONS", "https://evil.example.com")
+	if w.Code != http.StatusForbidden || called != 1 {
+		t.Errorf("Refused preflight wrongly handled: %v %v", w.Code, called)
+	}
+
+	w = request("GET", "https://evil.example.com")
+	if len(w.Header().Get("Access-Control-Allow-Origin")) > 0 || called != 2 {
+		t.Errorf("Refused request wrongly handled: %v %v", w.Header(), called)
+	}
+
+	w = request("GET", "")
+	if len(w.Header().Get("Access-Control-Allow-Origin")) > 0 || called != 3 {
+		t.Errorf("Same origin request wrongly handled: %v %v", w.Header(), called)
+	}
+}
+
+func TestCORSWebsocket(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewCORSConfig()
+	config.AllowedOrigins = []string{"https://docs.example.com"}
+
+	cors, err := NewCORSMiddleware(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(websocket.Server{
+		Handler: func(ws *websocket.Conn) {
+			websocket.Message.Send(ws, "hello")
+		},
+		Handshake: cors.WrapHandshake(affinityHandshake(NewAffinityConfig())),
+	})
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	ws, err := websocket.Dial(url, "", "https://docs.example.com")
+	if err != nil {
+		t.Fatalf("Allowed origin refused: %v", err)
+	}
+	ws.Close()
+
+	if _, err = websocket.Dial(url, "", "https://evil.example.com"); err == nil {
+		t.Error("Refused origin was allowed")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Stable codes of the errors sent to clients. Unlike message codes, which select the wording of a user
+facing message, error codes describe the kind of failure so that clients can react to it without
+parsing error text.
+*/
+const (
+	ErrorCodeAuthFailed      = "AUTH_FAILED"
+	ErrorCodeDocNotFound     = "DOC_NOT_FOUND"
+	ErrorCodeVersionMismatch = "VERSION_MISMATCH"
+	ErrorCodeRateLimited     = "RATE_LIMITED"
+	ErrorCodeInvalidRequest  = "INVALID_REQUEST"
+	ErrorCodeUnavailable     = "UNAVAILABLE"
+	ErrorCodeKicked          = "KICKED"
+	ErrorCodeInternal        = "INTERNAL"
+)
+
+/*
+ErrorInfo - The structured form of an error sent to a client. Code is one of the stable error codes,
+and Retryable hints whether repeating the same request (after reconnecting where the socket was
+closed) may succeed.
+*/
+type ErrorInfo struct {
+	Code      string `json:"code" yaml:"code"`
+	Retryable bool   `json:"retryable" yaml:"retryable"`
+}
+
+/*
+errorCodes - The error codes of errors that clients may encounter, and whether they are retryable.
+*/
+var errorCodes = map[error]ErrorInfo{
+	lib.ErrUnauthorised:       {ErrorCodeAuthFailed, false},
+	lib.ErrClientBanned:       {ErrorCodeAuthFailed, false},
+	lib.ErrReadOnlyPortal:     {ErrorCodeAuthFailed, false},
+	lib.ErrPortalClosed:       {ErrorCodeAuthFailed, false},
+	store.ErrDocumentNotExist: {ErrorCodeDocNotFound, false},
+	ErrNoRoute:                {ErrorCodeDocNotFound, false},
+	lib.ErrBinderNotFound:     {ErrorCodeDocNotFound, false},
+
+	lib.ErrTransformTooOld:    {ErrorCodeVersionMismatch, false},
+	lib.ErrEpochMismatch:      {ErrorCodeVersionMismatch, false},
+	lib.ErrVersionNotExist:    {ErrorCodeVersionMismatch, false},
+	lib.ErrVersionNotRetained: {ErrorCodeVersionMismatch, false},
+
+	lib.ErrRateLimited: {ErrorCodeRateLimited, true},
+
+	ErrInvalidDocument:           {ErrorCodeInvalidRequest, false},
+	lib.ErrDocumentTooLarge:      {ErrorCodeInvalidRequest, false},
+	lib.ErrTransformTooLarge:     {ErrorCodeInvalidRequest, false},
+	lib.ErrTransformTooLong:      {ErrorCodeInvalidRequest, false},
+	lib.ErrTransformNegDelete:    {ErrorCodeInvalidRequest, false},
+	lib.ErrTransformInvalidOp:    {ErrorCodeInvalidRequest, false},
+	lib.ErrTransformInvalidPath:  {ErrorCodeInvalidRequest, false},
+	lib.ErrTransformInvalidValue: {ErrorCodeInvalidRequest, false},
+	lib.ErrInvalidModelType:      {ErrorCodeInvalidRequest, false},
+	lib.ErrIDPolicy:              {ErrorCodeInvalidRequest, false},
+	ErrVersionsNotSupported:      {ErrorCodeInvalidRequest, false},
+
+	lib.ErrTimeout:          {ErrorCodeUnavailable, true},
+	lib.ErrCuratorDraining:  {ErrorCodeUnavailable, true},
+	lib.ErrTooManyBinders:   {ErrorCodeUnavailable, true},
+	lib.ErrBinderDraining:   {ErrorCodeUnavailable, true},
+	lib.ErrBinderClosed:     {ErrorCodeUnavailable, true},
+	lib.ErrRelayClosed:      {ErrorCodeUnavailable, true},
+	lib.ErrRelayNoLeader:    {ErrorCodeUnavailable, true},
+	lib.ErrRelaySyncTimeout: {ErrorCodeUnavailable, true},
+	lib.ErrRelayLeaderLost:  {ErrorCodeUnavailable, true},
+	lib.ErrRelayLeaseLost:   {ErrorCodeUnavailable, true},
+	ErrNoUpstreams:          {ErrorCodeUnavailable, true},
+	ErrRouterClosed:         {ErrorCodeUnavailable, true},
+	ErrUpstreamClosed:       {ErrorCodeUnavailable, true},
+	ErrJoinQueueFull:        {ErrorCodeUnavailable, true},
+	ErrJoinQueueTimeout:     {ErrorCodeUnavailable, true},
+}
+
+/*
+messageErrorCodes - The error codes of user facing messages that are sent without an underlying
+error.
+*/
+var messageErrorCodes = map[string]ErrorInfo{
+	MessageServerClosing:      {ErrorCodeUnavailable, true},
+	MessageServerShuttingDown: {ErrorCodeUnavailable, true},
+	MessageTransformMissing:   {ErrorCodeInvalidRequest, false},
+	MessagePositionMissing:    {ErrorCodeInvalidRequest, false},
+	MessageMetadataMissing:    {ErrorCodeInvalidRequest, false},
+	MessageCommentMissing:     {ErrorCodeInvalidRequest, false},
+	MessageUnknownCommand:     {ErrorCodeInvalidRequest, false},
+	MessageKicked:             {ErrorCodeKicked, false},
+}
+
+/*
+upstreamError - An error reported by an upstream node to a router, which keeps the structured form
+the upstream classified it with so that it survives being proxied.
+*/
+type upstreamError struct {
+	message string
+	info    *ErrorInfo
+}
+
+/*
+newUpstreamError - Returns the error of an error message sent by an upstream node.
+*/
+func newUpstreamError(message string, info *ErrorInfo) error {
+	return &upstreamError{message: message, info: info}
+}
+
+/*
+Error - Returns the error text sent by the upstream node.
+*/
+func (e *upstreamError) Error() string {
+	return e.message
+}
+
+/*
+classifyError - Returns the structured form of an error sent to a client with a message code, err is
+the underlying error and may be nil. Errors are recognised through any wrapping, and errors proxied
+from an upstream node keep the code the upstream gave them. Errors that are not recognised are
+classed as internal.
+*/
+func classifyError(code string, err error) *ErrorInfo {
+	if err == nil {
+		if info, exists := messageErrorCodes[code]; exists {
+			return &info
+		}
+		return &ErrorInfo{Code: ErrorCodeInternal}
+	}
+	var upstream *upstreamError
+	if errors.As(err, &upstream) && upstream.info != nil {
+		info := *upstream.info
+		return &info
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		// Errors that cannot be compared would panic as a map key, and are never listed anyway.
+		if !reflect.TypeOf(e).Comparable() {
+			continue
+		}
+		if info, exists := errorCodes[e]; exists {
+			return &info
+		}
+	}
+	return &ErrorInfo{Code: ErrorCodeInternal}
+}
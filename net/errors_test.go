@@ -0,0 +1,59 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestClassifyError(t *testing.T) {
+	type testCase struct {
+		code string
+		err  error
+		exp  ErrorInfo
+	}
+	for _, c := range []testCase{
+		{MessageInitFailed, lib.ErrUnauthorised, ErrorInfo{ErrorCodeAuthFailed, false}},
+		{MessageInitFailed, store.ErrDocumentNotExist, ErrorInfo{ErrorCodeDocNotFound, false}},
+		{MessageInitFailed, lib.ErrEpochMismatch, ErrorInfo{ErrorCodeVersionMismatch, false}},
+		{MessageSubmitFailed, lib.ErrRateLimited, ErrorInfo{ErrorCodeRateLimited, true}},
+		{MessageSubmitFailed, lib.ErrTransformTooOld, ErrorInfo{ErrorCodeVersionMismatch, false}},
+		{MessageInitFailed, lib.ErrCuratorDraining, ErrorInfo{ErrorCodeUnavailable, true}},
+		{MessageInitFailed, errors.New("disk on fire"), ErrorInfo{ErrorCodeInternal, false}},
+		{MessageInitFailed, fmt.Errorf("find: %w", store.ErrDocumentNotExist), ErrorInfo{ErrorCodeDocNotFound, false}},
+		{MessageSubmitFailed, newUpstreamError("slow down", &ErrorInfo{ErrorCodeRateLimited, true}), ErrorInfo{ErrorCodeRateLimited, true}},
+		{MessageSubmitFailed, newUpstreamError("old upstream", nil), ErrorInfo{ErrorCodeInternal, false}},
+		{MessageServerClosing, nil, ErrorInfo{ErrorCodeUnavailable, true}},
+		{MessageKicked, nil, ErrorInfo{ErrorCodeKicked, false}},
+		{MessageUnknownCommand, nil, ErrorInfo{ErrorCodeInvalidRequest, false}},
+	} {
+		if act := classifyError(c.code, c.err); *act != c.exp {
+			t.Errorf("Wrong error info for %v (%v): %v != %v", c.code, c.err, *act, c.exp)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// The optional protocol extensions that clients and the server may agree on when joining.
+const (
+	ExtensionPresence    = "presence"
+	ExtensionSpectators  = "spectators"
+	ExtensionDiagnostics = "diagnostics"
+	ExtensionResume      = "resume"
+	ExtensionPeerAssist  = "peer_assist"
+	ExtensionHeartbeat   = "heartbeat"
+	ExtensionDeflate     = "deflate"
+	ExtensionBinary      = "binary"
+)
+
+/*
+SupportedExtensions - Lists every protocol extension this server is able to provide.
+*/
+var SupportedExtensions = []string{
+	ExtensionPresence,
+	ExtensionSpectators,
+	ExtensionDiagnostics,
+	ExtensionResume,
+	ExtensionPeerAssist,
+	ExtensionHeartbeat,
+	ExtensionDeflate,
+	ExtensionBinary,
+}
+
+/*
+ExperimentalExtensions - Lists the supported extensions that are experimental, which are only
+provided when enabled explicitly.
+*/
+var ExperimentalExtensions = []string{
+	ExtensionPeerAssist,
+}
+
+/*
+defaultExtensions - Returns the supported extensions that are not experimental.
+*/
+func defaultExtensions() []string {
+	extensions := []string{}
+	for _, ext := range SupportedExtensions {
+		if !hasExtension(ExperimentalExtensions, ext) {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
+
+// Errors for protocol extensions.
+var (
+	ErrUnknownExtension = errors.New("unknown protocol extension")
+)
+
+/*
+validateExtensions - Check that the extensions enabled for a server are all supported.
+*/
+func validateExtensions(enabled []string) error {
+	for _, ext := range enabled {
+		if !hasExtension(SupportedExtensions, ext) {
+			return fmt.Errorf("%v: %v", ErrUnknownExtension, ext)
+		}
+	}
+	return nil
+}
+
+/*
+hasExtension - Whether a list of extensions contains a particular extension.
+*/
+func hasExtension(extensions []string, ext string) bool {
+	for _, e := range extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+withoutExtension - Returns a list of extensions with a particular extension removed.
+*/
+func withoutExtension(extensions []string, ext string) []string {
+	if !hasExtension(extensions, ext) {
+		return extensions
+	}
+	remaining := []string{}
+	for _, e := range extensions {
+		if e != ext {
+			remaining = append(remaining, e)
+		}
+	}
+	return remaining
+}
+
+/*
+negotiateExtensions - Returns the extensions offered by a client that are also enabled for the
+server, in the order the client offered them and without duplicates. Unknown extensions are ignored
+so that clients may offer extensions of newer servers. A client that offers nothing is returned nil,
+which keeps the init response of older clients unchanged.
+*/
+func negotiateExtensions(offered, enabled []string) []string {
+	if offered == nil {
+		return nil
+	}
+	agreed := []string{}
+	for _, ext := range offered {
+		if hasExtension(enabled, ext) && !hasExtension(agreed, ext) {
+			agreed = append(agreed, ext)
+		}
+	}
+	return agreed
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
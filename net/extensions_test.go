@@ -0,0 +1,349 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+	"golang.org/x/net/websocket"
+)
+
+func TestNegotiateExtensions(t *testing.T) {
+	enabled := []string{ExtensionPresence, ExtensionDiagnostics}
+
+	for _, test := range []struct {
+		offered, expected []string
+	}{
+		{nil, nil},
+		{[]string{}, []string{}},
+		{[]string{"resume", ExtensionDiagnostics}, []string{ExtensionDiagnostics}},
+		{[]string{ExtensionSpectators, ExtensionPresence, ExtensionPresence}, []string{ExtensionPresence}},
+	} {
+		if agreed := negotiateExtensions(test.offered, enabled); !reflect.DeepEqual(agreed, test.expected) {
+			t.Errorf("Wrong extensions agreed for %v: %v != %v", test.offered, agreed, test.expected)
+		}
+	}
+
+	if err := validateExtensions([]string{ExtensionPresence, "telepathy"}); err == nil {
+		t.Error("Expected unknown extension to be rejected")
+	}
+}
+
+func TestPresenceOptionsLegacyFlags(t *testing.T) {
+	msg := LeapClientMessage{Presence: true, Spectators: true, Diagnostics: true}
+
+	opts := presenceOptions(msg, nil, []string{ExtensionPresence})
+	if !opts.Subscribe {
+		t.Error("Legacy presence flag of an enabled extension was ignored")
+	}
+	if opts.Spectators || opts.Diagnostics {
+		t.Errorf("Legacy flags of disabled extensions were honoured: %+v", opts)
+	}
+
+	opts = presenceOptions(LeapClientMessage{}, []string{ExtensionDiagnostics}, nil)
+	if !opts.Diagnostics {
+		t.Error("Agreed extension was ignored")
+	}
+}
+
+func TestBinaryCodec(t *testing.T) {
+	data, payloadType, err := binaryCodec.Marshal(LeapSocketServerMessage{
+		Type:       "transforms",
+		Version:    5,
+		Transforms: []lib.OTransform{{Position: 3, Insert: "hi", Version: 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payloadType != websocket.BinaryFrame {
+		t.Errorf("Wrong payload type: %v", payloadType)
+	}
+
+	var decoded LeapSocketServerMessage
+	if err = binaryCodec.Unmarshal(data, websocket.BinaryFrame, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Type != "transforms" || decoded.Version != 5 || len(decoded.Transforms) != 1 ||
+		decoded.Transforms[0].Insert != "hi" || decoded.Transforms[0].Position != 3 {
+		t.Errorf("Wrong decoded message: %+v", decoded)
+	}
+
+	var fromText LeapClientMessage
+	if err = binaryCodec.Unmarshal([]byte(`{"command":"find"}`), websocket.TextFrame, &fromText); err != nil {
+		t.Fatal(err)
+	}
+	if fromText.Command != "find" {
+		t.Errorf("Wrong command decoded from text frame: %v", fromText.Command)
+	}
+}
+
+func TestExtensionsHandshake(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8256"
+	httpServerConfig.Path = "/extensions/socket"
+	httpServerConfig.StaticFilePath = ""
+	httpServerConfig.Extensions = []string{ExtensionPresence}
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	origin, url := "http://localhost/", "ws://localhost:8256/extensions/socket"
+
+	wsA, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wsA.Close()
+
+	websocket.JSON.Send(wsA, LeapClientMessage{
+		Command:    "create",
+		Document:   &store.Document{Content: "hello world"},
+		Extensions: []string{ExtensionDiagnostics, ExtensionPresence},
+	})
+
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(wsA, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Fatalf("Init failed: %v, %v", err, initResponse.Error)
+	}
+	if exp := []string{ExtensionPresence}; !reflect.DeepEqual(initResponse.Extensions, exp) {
+		t.Errorf("Wrong extensions agreed: %v != %v", initResponse.Extensions, exp)
+	}
+
+	// An older client offers nothing and is told nothing.
+	wsB, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wsB.Close()
+
+	websocket.JSON.Send(wsB, LeapClientMessage{
+		Command: "find",
+		DocID:   initResponse.Document.ID,
+	})
+
+	var findResponse LeapServerMessage
+	if err = websocket.JSON.Receive(wsB, &findResponse); err != nil || findResponse.Type != "document" {
+		t.Fatalf("Find failed: %v, %v", err, findResponse.Error)
+	}
+	if findResponse.Extensions != nil {
+		t.Errorf("Older client was sent extensions: %v", findResponse.Extensions)
+	}
+
+	// The presence extension subscribes the first client to presence events.
+	joined := receivePresence(wsA, t)
+	if len(joined) != 1 || joined[0].Event != "join" {
+		t.Errorf("Wrong join event: %v", joined)
+	}
+}
+
+func TestBinaryHandshake(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8259"
+	httpServerConfig.Path = "/binary/socket"
+	httpServerConfig.StaticFilePath = ""
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ws, err := websocket.Dial("ws://localhost:8259/binary/socket", "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	websocket.JSON.Send(ws, LeapClientMessage{
+		Command:    "create",
+		Document:   &store.Document{Content: "hello world"},
+		Extensions: []string{ExtensionDeflate, ExtensionBinary},
+	})
+
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(ws, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Fatalf("Init failed: %v, %v", err, initResponse.Error)
+	}
+	if exp := []string{ExtensionBinary}; !reflect.DeepEqual(initResponse.Extensions, exp) {
+		t.Errorf("Wrong extensions agreed: %v != %v", initResponse.Extensions, exp)
+	}
+
+	binaryCodec.Send(ws, LeapSocketClientMessage{
+		Command:   "submit",
+		Transform: &lib.OTransform{Position: 11, Insert: "!", Version: 2},
+	})
+
+	var frame []byte
+	if err = websocket.Message.Receive(ws, &frame); err != nil {
+		t.Fatal(err)
+	}
+	var correction LeapSocketServerMessage
+	if err = binaryCodec.Unmarshal(frame, websocket.BinaryFrame, &correction); err != nil {
+		t.Fatal(err)
+	}
+	if correction.Type != "correction" || correction.Version != 2 {
+		t.Errorf("Wrong correction: %+v", correction)
+	}
+}
+
+func TestResumeHandshake(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8257"
+	httpServerConfig.Path = "/resume/socket"
+	httpServerConfig.StaticFilePath = ""
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curatorConfig := lib.DefaultCuratorConfig()
+	curatorConfig.BinderConfig.HistoryLength = 10
+
+	curator, err := lib.NewCurator(curatorConfig, logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	origin, url := "http://localhost/", "ws://localhost:8257/resume/socket"
+
+	wsA, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wsA.Close()
+
+	websocket.JSON.Send(wsA, LeapClientMessage{
+		Command:    "create",
+		Document:   &store.Document{Content: "hello world"},
+		Extensions: []string{ExtensionResume},
+	})
+
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(wsA, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Fatalf("Init failed: %v, %v", err, initResponse.Error)
+	}
+	if len(initResponse.Epoch) == 0 {
+		t.Fatal("Init response did not contain an epoch")
+	}
+
+	websocket.JSON.Send(wsA, LeapSocketClientMessage{
+		Command:   "submit",
+		Transform: &lib.OTransform{Position: 11, Insert: "!", Version: 2},
+	})
+	var correction LeapSocketServerMessage
+	if err = websocket.JSON.Receive(wsA, &correction); err != nil || correction.Type != "correction" {
+		t.Fatalf("Submit failed: %v, %v", err, correction.Error)
+	}
+
+	find := func(epoch string) LeapServerMessage {
+		ws, err := websocket.Dial(url, "", origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+
+		websocket.JSON.Send(ws, LeapClientMessage{
+			Command:       "find",
+			DocID:         initResponse.Document.ID,
+			Extensions:    []string{ExtensionResume},
+			ResumeEpoch:   epoch,
+			ResumeVersion: 1,
+		})
+		var response LeapServerMessage
+		if err = websocket.JSON.Receive(ws, &response); err != nil {
+			t.Fatal(err)
+		}
+		return response
+	}
+
+	resumed := find(initResponse.Epoch)
+	if resumed.Type != "resume" || resumed.Document != nil {
+		t.Fatalf("Expected resume response: %v", resumed)
+	}
+	if resumed.Version == nil || *resumed.Version != 1 {
+		t.Errorf("Wrong resume version: %v", resumed.Version)
+	}
+	if len(resumed.Transforms) != 1 || resumed.Transforms[0].Insert != "!" {
+		t.Errorf("Wrong missed transforms: %v", resumed.Transforms)
+	}
+
+	fallback := find("previous")
+	if fallback.Type != "document" || fallback.Document == nil {
+		t.Fatalf("Expected document response: %v", fallback)
+	}
+	if exp, act := "hello world!", fallback.Document.Content; exp != act {
+		t.Errorf("Wrong fallback content: %v != %v", exp, act)
+	}
+}
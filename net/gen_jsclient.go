@@ -0,0 +1,97 @@
+//go:build ignore
+// +build ignore
+
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*
+gen_jsclient - Generates js_client.go, which embeds the leaps JS client into the binary as it is
+built into bin/js/leaps.js by the Makefile: leapclient.js followed by each of the leap-bind-*.js
+bindings. The content hash of the client is embedded alongside it, giving browsers a filename that
+changes with every change to the client. Run with go generate from the net directory.
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	bindings, err := filepath.Glob(filepath.Join("..", "client", "leap-bind-*.js"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to find client bindings: %v\n", err)
+		os.Exit(1)
+	}
+	sort.Strings(bindings)
+
+	var client bytes.Buffer
+	for _, path := range append([]string{filepath.Join("..", "client", "leapclient.js")}, bindings...) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read client source: %v\n", err)
+			os.Exit(1)
+		}
+		client.Write(data)
+	}
+
+	sum := sha256.Sum256(client.Bytes())
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by gen_jsclient.go. DO NOT EDIT.\n\n")
+	out.WriteString("package net\n\n")
+	fmt.Fprintf(&out, "const jsClientHash = %q\n\n", hex.EncodeToString(sum[:])[:16])
+	out.WriteString("const jsClientSource = \"\" +\n")
+	lines := strings.SplitAfter(client.String(), "\n")
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		out.WriteString("\t" + strconv.Quote(line))
+		if i < len(lines)-1 && len(lines[i+1]) > 0 {
+			out.WriteString(" +")
+		}
+		out.WriteString("\n")
+	}
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to format generated code: %v\n", err)
+		os.Exit(1)
+	}
+	if err = ioutil.WriteFile("js_client.go", src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write generated code: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,245 @@
+//go:build ignore
+// +build ignore
+
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*
+gen_openapi - Generates openapi_spec.go, which embeds an OpenAPI 3 document describing the admin
+API. Endpoints are found from the calls to Register within the sources of the net package that are
+part of the default build, where the description takes the form "<METHODS> summary {example}". Run
+with go generate from the net directory.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type endpoint struct {
+	path        string
+	methods     []string
+	summary     string
+	description string
+	examples    []interface{}
+}
+
+/*
+parseDescription - Split an endpoint description into its methods, the remaining text, a summary
+and the examples within it, which are the parts of the text that are valid JSON objects.
+*/
+func parseDescription(desc string) ([]string, string, string, []interface{}) {
+	methods := []string{"GET"}
+	if strings.HasPrefix(desc, "<") {
+		if end := strings.Index(desc, ">"); end > 0 {
+			methods = strings.Split(desc[1:end], "|")
+			desc = strings.TrimSpace(desc[end+1:])
+		}
+	}
+	summary := ""
+	examples := []interface{}{}
+	for i := 0; i < len(desc); i++ {
+		// Only objects that begin a word are examples, rather than those nested within invalid ones.
+		if desc[i] != '{' || (i > 0 && desc[i-1] != ' ') {
+			continue
+		}
+		decoder := json.NewDecoder(strings.NewReader(desc[i:]))
+		var example map[string]interface{}
+		if err := decoder.Decode(&example); err != nil {
+			continue
+		}
+		if len(examples) == 0 {
+			summary = strings.TrimRight(strings.TrimSpace(desc[:i]), ",")
+		}
+		examples = append(examples, example)
+		i += int(decoder.InputOffset()) - 1
+	}
+	if len(examples) == 0 {
+		summary = desc
+	}
+	return methods, desc, summary, examples
+}
+
+/*
+findEndpoints - Parse the sources of the default build and collect the endpoints they register with
+literal paths and descriptions.
+*/
+func findEndpoints() ([]endpoint, error) {
+	paths, err := filepath.Glob("*.go")
+	if err != nil {
+		return nil, err
+	}
+	fset := token.NewFileSet()
+	endpoints := []endpoint{}
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// Files with build constraints, such as the generators and chaos endpoints, are skipped.
+		if bytes.HasPrefix(src, []byte("//go:build")) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, src, 0)
+		if err != nil {
+			return nil, err
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) < 2 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok || sel.Sel.Name != "Register" {
+				return true
+			}
+			args := []string{}
+			for _, arg := range call.Args[:2] {
+				lit, ok := arg.(*ast.BasicLit)
+				if !ok || lit.Kind != token.STRING {
+					return true
+				}
+				value, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					return true
+				}
+				args = append(args, value)
+			}
+			methods, description, summary, examples := parseDescription(args[1])
+			endpoints = append(endpoints, endpoint{
+				path:        args[0],
+				methods:     methods,
+				summary:     summary,
+				description: description,
+				examples:    examples,
+			})
+			return true
+		})
+	}
+	sort.Slice(endpoints, func(i, j int) bool { return endpoints[i].path < endpoints[j].path })
+	return endpoints, nil
+}
+
+/*
+buildSpec - Create the OpenAPI document of a set of endpoints. The first example is given as the
+request body of methods that take one, and the last as the response of GETs.
+*/
+func buildSpec(endpoints []endpoint) map[string]interface{} {
+	paths := map[string]interface{}{}
+	for _, e := range endpoints {
+		item := map[string]interface{}{}
+		for _, method := range e.methods {
+			op := map[string]interface{}{
+				"summary":     e.summary,
+				"description": e.description,
+				"responses": map[string]interface{}{
+					"default": map[string]interface{}{"description": "An error described in plain text"},
+				},
+			}
+			success := map[string]interface{}{"description": "Success"}
+			if n := len(e.examples); n > 0 {
+				example := func(v interface{}) map[string]interface{} {
+					return map[string]interface{}{
+						"application/json": map[string]interface{}{"example": v},
+					}
+				}
+				if method == "POST" || method == "PUT" {
+					op["requestBody"] = map[string]interface{}{"content": example(e.examples[0])}
+				} else if method == "GET" {
+					success["content"] = example(e.examples[n-1])
+				}
+			}
+			op["responses"].(map[string]interface{})["200"] = success
+			item[strings.ToLower(method)] = op
+		}
+		paths[e.path] = item
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Leaps admin API",
+			"description": "Administrative endpoints of a leaps service, relative to the configured admin path.",
+			"version":     "1",
+		},
+		"paths": paths,
+	}
+}
+
+func main() {
+	endpoints, err := findEndpoints()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse sources: %v\n", err)
+		os.Exit(1)
+	}
+	var spec bytes.Buffer
+	encoder := json.NewEncoder(&spec)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err = encoder.Encode(buildSpec(endpoints)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode spec: %v\n", err)
+		os.Exit(1)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by gen_openapi.go. DO NOT EDIT.\n\n")
+	out.WriteString("package net\n\n")
+	out.WriteString("const apiSpecSource = \"\" +\n")
+	lines := strings.SplitAfter(spec.String(), "\n")
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		out.WriteString("\t" + strconv.Quote(line))
+		if i < len(lines)-1 && len(lines[i+1]) > 0 {
+			out.WriteString(" +")
+		}
+		out.WriteString("\n")
+	}
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to format generated code: %v\n", err)
+		os.Exit(1)
+	}
+	if err = ioutil.WriteFile("openapi_spec.go", src, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write generated code: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
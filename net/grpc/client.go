@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package grpc
+
+import (
+	"context"
+
+	grpclib "google.golang.org/grpc"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ClientStream - A client side stream of the Leaps service, for Go programs that want to talk to leaps
+without generating code from leaps.proto.
+*/
+type ClientStream struct {
+	stream grpclib.ClientStream
+}
+
+/*
+Connect - Open a stream to the Leaps service over an existing client connection. The first message
+sent must bind the stream to a document.
+*/
+func Connect(ctx context.Context, conn *grpclib.ClientConn) (*ClientStream, error) {
+	stream, err := conn.NewStream(
+		ctx, &serviceDesc.Streams[0], "/leaps.Leaps/Connect", grpclib.ForceCodec(codec{}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientStream{stream: stream}, nil
+}
+
+/*
+Send - Send a message to the server.
+*/
+func (c *ClientStream) Send(msg ClientMessage) error {
+	return c.stream.SendMsg(&msg)
+}
+
+/*
+Recv - Block until the next message from the server arrives.
+*/
+func (c *ClientStream) Recv() (ServerMessage, error) {
+	var msg ServerMessage
+	err := c.stream.RecvMsg(&msg)
+	return msg, err
+}
+
+/*
+Close - Signal to the server that no more messages will be sent.
+*/
+func (c *ClientStream) Close() error {
+	return c.stream.CloseSend()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,386 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package grpc
+
+import (
+	"errors"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ClientMessage - A message from a client, see ClientMessage in leaps.proto.
+*/
+type ClientMessage struct {
+	Command   string
+	Token     string
+	DocID     string
+	UserID    string
+	Document  *store.Document
+	Transform *lib.OTransform
+	Position  *int64
+	Message   string
+}
+
+/*
+ServerMessage - A message to a client, see ServerMessage in leaps.proto.
+*/
+type ServerMessage struct {
+	Type       string
+	Document   *store.Document
+	Version    int
+	Transforms []lib.OTransform
+	Updates    []lib.ClientMessage
+	Error      string
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the message types.
+var (
+	ErrMalformedMessage = errors.New("malformed protobuf message")
+)
+
+/*
+protoMessage - Implemented by the message types, which are serialised in the protobuf wire format
+of their counterparts in leaps.proto.
+*/
+type protoMessage interface {
+	marshal() []byte
+	unmarshal(data []byte) error
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if len(s) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+/*
+parseFields - Walks the fields of a protobuf message, calling fn with the field number and the raw
+varint or bytes value of each. Fields of other wire types are skipped.
+*/
+func parseFields(data []byte, fn func(num protowire.Number, v uint64, b []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return ErrMalformedMessage
+		}
+		data = data[n:]
+
+		var err error
+		switch typ {
+		case protowire.VarintType:
+			var v uint64
+			if v, n = protowire.ConsumeVarint(data); n >= 0 {
+				err = fn(num, v, nil)
+			}
+		case protowire.BytesType:
+			var b []byte
+			if b, n = protowire.ConsumeBytes(data); n >= 0 {
+				err = fn(num, 0, b)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return ErrMalformedMessage
+		}
+		if err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+func marshalDocument(doc store.Document) []byte {
+	b := appendString(nil, 1, doc.ID)
+	b = appendString(b, 2, doc.Content)
+	return appendString(b, 3, doc.Type)
+}
+
+func unmarshalDocument(data []byte) (*store.Document, error) {
+	doc := &store.Document{}
+	return doc, parseFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			doc.ID = string(b)
+		case 2:
+			doc.Content = string(b)
+		case 3:
+			doc.Type = string(b)
+		}
+		return nil
+	})
+}
+
+func marshalTransform(ot lib.OTransform) []byte {
+	b := appendInt(nil, 1, int64(ot.Position))
+	b = appendInt(b, 2, int64(ot.Delete))
+	b = appendString(b, 3, ot.Insert)
+	b = appendInt(b, 4, int64(ot.Version))
+	b = appendString(b, 5, ot.Op)
+	for _, key := range ot.Path {
+		b = protowire.AppendTag(b, 6, protowire.BytesType)
+		b = protowire.AppendString(b, key)
+	}
+	b = appendString(b, 7, string(ot.Value))
+	b = appendInt(b, 8, int64(ot.To))
+	b = appendInt(b, 9, int64(ot.Retain))
+	for k, v := range ot.Attributes {
+		entry := appendString(nil, 1, k)
+		b = appendMessage(b, 10, appendString(entry, 2, v))
+	}
+	b = appendString(b, 11, ot.Author)
+	return b
+}
+
+func unmarshalTransform(data []byte) (*lib.OTransform, error) {
+	ot := &lib.OTransform{}
+	return ot, parseFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			ot.Position = int(v)
+		case 2:
+			ot.Delete = int(v)
+		case 3:
+			ot.Insert = string(b)
+		case 4:
+			ot.Version = int(v)
+		case 5:
+			ot.Op = string(b)
+		case 6:
+			ot.Path = append(ot.Path, string(b))
+		case 7:
+			ot.Value = append([]byte(nil), b...)
+		case 8:
+			ot.To = int(v)
+		case 9:
+			ot.Retain = int(v)
+		case 10:
+			var k, v string
+			if err := parseFields(b, func(num protowire.Number, _ uint64, b []byte) error {
+				switch num {
+				case 1:
+					k = string(b)
+				case 2:
+					v = string(b)
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if ot.Attributes == nil {
+				ot.Attributes = map[string]string{}
+			}
+			ot.Attributes[k] = v
+		case 11:
+			ot.Author = string(b)
+		}
+		return nil
+	})
+}
+
+func marshalUpdate(update lib.ClientMessage) []byte {
+	b := appendString(nil, 1, update.UserID)
+	b = appendString(b, 2, update.Message)
+	if update.Position != nil {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*update.Position))
+	}
+	if update.Active {
+		b = appendInt(b, 4, 1)
+	}
+	return b
+}
+
+func unmarshalUpdate(data []byte) (*lib.ClientMessage, error) {
+	update := &lib.ClientMessage{}
+	return update, parseFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			update.UserID = string(b)
+		case 2:
+			update.Message = string(b)
+		case 3:
+			position := int64(v)
+			update.Position = &position
+		case 4:
+			update.Active = v != 0
+		}
+		return nil
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+func (c *ClientMessage) marshal() []byte {
+	b := appendString(nil, 1, c.Command)
+	b = appendString(b, 2, c.Token)
+	b = appendString(b, 3, c.DocID)
+	b = appendString(b, 4, c.UserID)
+	if c.Document != nil {
+		b = appendMessage(b, 5, marshalDocument(*c.Document))
+	}
+	if c.Transform != nil {
+		b = appendMessage(b, 6, marshalTransform(*c.Transform))
+	}
+	if c.Position != nil {
+		b = protowire.AppendTag(b, 7, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*c.Position))
+	}
+	return appendString(b, 8, c.Message)
+}
+
+func (c *ClientMessage) unmarshal(data []byte) error {
+	*c = ClientMessage{}
+	return parseFields(data, func(num protowire.Number, v uint64, b []byte) (err error) {
+		switch num {
+		case 1:
+			c.Command = string(b)
+		case 2:
+			c.Token = string(b)
+		case 3:
+			c.DocID = string(b)
+		case 4:
+			c.UserID = string(b)
+		case 5:
+			c.Document, err = unmarshalDocument(b)
+		case 6:
+			c.Transform, err = unmarshalTransform(b)
+		case 7:
+			position := int64(v)
+			c.Position = &position
+		case 8:
+			c.Message = string(b)
+		}
+		return
+	})
+}
+
+func (s *ServerMessage) marshal() []byte {
+	b := appendString(nil, 1, s.Type)
+	if s.Document != nil {
+		b = appendMessage(b, 2, marshalDocument(*s.Document))
+	}
+	b = appendInt(b, 3, int64(s.Version))
+	for _, ot := range s.Transforms {
+		b = appendMessage(b, 4, marshalTransform(ot))
+	}
+	for _, update := range s.Updates {
+		b = appendMessage(b, 5, marshalUpdate(update))
+	}
+	return appendString(b, 6, s.Error)
+}
+
+func (s *ServerMessage) unmarshal(data []byte) error {
+	*s = ServerMessage{}
+	return parseFields(data, func(num protowire.Number, v uint64, b []byte) error {
+		switch num {
+		case 1:
+			s.Type = string(b)
+		case 2:
+			doc, err := unmarshalDocument(b)
+			if err != nil {
+				return err
+			}
+			s.Document = doc
+		case 3:
+			s.Version = int(v)
+		case 4:
+			ot, err := unmarshalTransform(b)
+			if err != nil {
+				return err
+			}
+			s.Transforms = append(s.Transforms, *ot)
+		case 5:
+			update, err := unmarshalUpdate(b)
+			if err != nil {
+				return err
+			}
+			s.Updates = append(s.Updates, *update)
+		case 6:
+			s.Error = string(b)
+		}
+		return nil
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+codec - A gRPC codec for the message types of this package. It is named proto since the messages
+are serialised in the protobuf wire format, and are therefore readable by clients generated from
+leaps.proto.
+*/
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(protoMessage)
+	if !ok {
+		return nil, ErrMalformedMessage
+	}
+	return msg.marshal(), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(protoMessage)
+	if !ok {
+		return ErrMalformedMessage
+	}
+	return msg.unmarshal(data)
+}
+
+func (codec) Name() string {
+	return "proto"
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,313 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package grpc
+
+import (
+	"encoding/json"
+	"errors"
+	gonet "net"
+	"sync"
+
+	"github.com/jeffail/leaps/net"
+	"github.com/jeffail/util/log"
+	grpclib "google.golang.org/grpc"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Config - Holds configuration options for the gRPC server. TLS is expected to be terminated by the
+service mesh, which is the intended home of this transport.
+*/
+type Config struct {
+	Address string `json:"address" yaml:"address"`
+}
+
+/*
+NewConfig - Returns a fully defined gRPC server configuration with the default values for each
+field, the server is disabled while the address is empty.
+*/
+func NewConfig() Config {
+	return Config{
+		Address: "",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Server type.
+var (
+	ErrInvalidAddress = errors.New("invalid config value for gRPC address")
+	ErrServerClosed   = errors.New("gRPC server is closed")
+)
+
+/*
+serviceDesc - The Leaps service of leaps.proto, written by hand in place of generated code.
+*/
+var serviceDesc = grpclib.ServiceDesc{
+	ServiceName: "leaps.Leaps",
+	HandlerType: (*interface{})(nil),
+	Methods:     []grpclib.MethodDesc{},
+	Streams: []grpclib.StreamDesc{
+		{
+			StreamName: "Connect",
+			Handler: func(srv interface{}, stream grpclib.ServerStream) error {
+				return srv.(*Server).connect(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "leaps.proto",
+}
+
+/*
+Server - Exposes the join, create and transform protocol of the websocket API as a bidirectional
+streaming gRPC service. Server is a net.TransportListener, each stream is accepted as a transport
+and served by an HTTPServer through ServeTransport, exactly like a websocket client.
+*/
+type Server struct {
+	config     Config
+	logger     *log.Logger
+	stats      *log.Stats
+	server     *grpclib.Server
+	acceptChan chan *streamTransport
+	closeChan  chan bool
+	closeOnce  sync.Once
+
+	wrapper ListenerWrapper
+}
+
+/*
+ListenerWrapper - Wraps the listener that a Server accepts connections from, such as the HTTPServer
+of the net package, which screens them with its IP guard.
+*/
+type ListenerWrapper interface {
+	WrapListener(listener gonet.Listener) gonet.Listener
+}
+
+/*
+NewServer - Create a new leaps gRPC server.
+*/
+func NewServer(config Config, logger *log.Logger, stats *log.Stats) *Server {
+	s := &Server{
+		config:     config,
+		logger:     logger.NewModule(":grpc"),
+		stats:      stats,
+		server:     grpclib.NewServer(grpclib.ForceServerCodec(codec{})),
+		acceptChan: make(chan *streamTransport),
+		closeChan:  make(chan bool),
+	}
+	s.server.RegisterService(&serviceDesc, s)
+	return s
+}
+
+/*
+Listen - Bind to the configured address and begin serving streams.
+*/
+func (s *Server) Listen() error {
+	if len(s.config.Address) == 0 {
+		return ErrInvalidAddress
+	}
+	listener, err := gonet.Listen("tcp", s.config.Address)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("Listening for gRPC streams at address: %v\n", s.config.Address)
+	return s.Serve(listener)
+}
+
+/*
+SetListenerWrapper - Wrap the listener of the server before serving from it, this must be set before
+calling Listen or Serve.
+*/
+func (s *Server) SetListenerWrapper(wrapper ListenerWrapper) {
+	s.wrapper = wrapper
+}
+
+/*
+Serve - Serve streams from an existing listener.
+*/
+func (s *Server) Serve(listener gonet.Listener) error {
+	if s.wrapper != nil {
+		listener = s.wrapper.WrapListener(listener)
+	}
+	return s.server.Serve(listener)
+}
+
+/*
+Accept - Block until a client opens a stream and return it as a transport, implements
+net.TransportListener.
+*/
+func (s *Server) Accept() (net.Transport, error) {
+	select {
+	case t := <-s.acceptChan:
+		return t, nil
+	case <-s.closeChan:
+		return nil, ErrServerClosed
+	}
+}
+
+/*
+Close - Stop serving, implements net.TransportListener.
+*/
+func (s *Server) Close() error {
+	s.Stop()
+	return nil
+}
+
+/*
+Stop - Close all open streams and stop serving.
+*/
+func (s *Server) Stop() {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+	s.server.Stop()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+connect - Hands a fresh stream to Accept and holds it open until its transport is closed or the
+client goes away.
+*/
+func (s *Server) connect(stream grpclib.ServerStream) error {
+	s.stats.Incr("grpc.open_streams", 1)
+	defer s.stats.Decr("grpc.open_streams", 1)
+
+	t := &streamTransport{stream: stream, closedChan: make(chan struct{})}
+	select {
+	case s.acceptChan <- t:
+	case <-s.closeChan:
+		return ErrServerClosed
+	case <-stream.Context().Done():
+		return stream.Context().Err()
+	}
+
+	select {
+	case <-t.closedChan:
+	case <-stream.Context().Done():
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+streamTransport - The net.Transport of a gRPC stream, converting between the messages of the leaps
+protocol and those of leaps.proto. Messages that are not part of the gRPC contract, such as
+presence, spectators, diagnostics, comments and banners, are dropped.
+*/
+type streamTransport struct {
+	stream     grpclib.ServerStream
+	closeOnce  sync.Once
+	closedChan chan struct{}
+}
+
+/*
+Send - Send a net.LeapServerMessage or net.LeapSocketServerMessage to the client. A
+json.RawMessage is taken to be an encoded net.LeapServerMessage, as sent by the snapshot cache.
+*/
+func (t *streamTransport) Send(msg interface{}) error {
+	var out *ServerMessage
+	switch m := msg.(type) {
+	case json.RawMessage:
+		var serverMsg net.LeapServerMessage
+		if err := json.Unmarshal(m, &serverMsg); err != nil {
+			return err
+		}
+		return t.Send(serverMsg)
+	case net.LeapServerMessage:
+		switch m.Type {
+		case "document", "error":
+			out = &ServerMessage{Type: m.Type, Document: m.Document, Error: m.Error}
+			if m.Version != nil {
+				out.Version = *m.Version
+			}
+		}
+	case net.LeapSocketServerMessage:
+		switch m.Type {
+		case "transforms", "correction", "update", "error":
+			out = &ServerMessage{
+				Type:       m.Type,
+				Version:    m.Version,
+				Transforms: m.Transforms,
+				Updates:    m.Updates,
+				Error:      m.Error,
+			}
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return t.stream.SendMsg(out)
+}
+
+/*
+Receive - Block until the client sends a message and decode it into a net.LeapClientMessage or a
+net.LeapSocketClientMessage.
+*/
+func (t *streamTransport) Receive(msg interface{}) error {
+	var in ClientMessage
+	if err := t.stream.RecvMsg(&in); err != nil {
+		return err
+	}
+	switch m := msg.(type) {
+	case *net.LeapClientMessage:
+		*m = net.LeapClientMessage{
+			Command:  in.Command,
+			Token:    in.Token,
+			DocID:    in.DocID,
+			UserID:   in.UserID,
+			Document: in.Document,
+		}
+	case *net.LeapSocketClientMessage:
+		*m = net.LeapSocketClientMessage{
+			Command:   in.Command,
+			Transform: in.Transform,
+			Position:  in.Position,
+			Message:   in.Message,
+		}
+	default:
+		return ErrMalformedMessage
+	}
+	return nil
+}
+
+/*
+Close - End the stream, which completes once the stream handler returns.
+*/
+func (t *streamTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closedChan)
+	})
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,412 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	gonet "net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/net"
+	"github.com/jeffail/util/log"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func loggerAndStats() (*log.Logger, *log.Stats) {
+	logConf := log.DefaultLoggerConfig()
+	logConf.LogLevel = "OFF"
+
+	logger := log.NewLogger(os.Stdout, logConf)
+	stats := log.NewStats(log.DefaultStatsConfig())
+
+	return logger, stats
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	position := int64(-4)
+	clientMsg := ClientMessage{
+		Command:   "submit",
+		Token:     "token",
+		DocID:     "doc",
+		UserID:    "user",
+		Document:  &store.Document{ID: "doc", Content: "hello 世界"},
+		Transform: &lib.OTransform{Position: 3, Delete: 2, Insert: "abc", Version: 7},
+		Position:  &position,
+		Message:   "hi",
+	}
+	var clientResult ClientMessage
+	if err := clientResult.unmarshal(clientMsg.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(clientMsg, clientResult) {
+		t.Errorf("Client message mismatch: %+v != %+v", clientMsg, clientResult)
+	}
+
+	zero := int64(0)
+	serverMsg := ServerMessage{
+		Type:     "update",
+		Document: &store.Document{ID: "doc", Content: "[]", Type: "rich"},
+		Version:  3,
+		Transforms: []lib.OTransform{
+			{Position: 1, Insert: "a", Version: 2},
+			{Position: 0, Delete: 1, Version: 3, Author: "formatter"},
+			{Op: "set", Path: []string{"list", "0"}, Value: json.RawMessage(`{"a":1}`), Version: 4},
+			{Op: "format", Position: 2, Retain: 3, Attributes: map[string]string{"bold": "true"}, Version: 5},
+		},
+		Updates: []lib.ClientMessage{
+			{UserID: "user1", Position: &zero, Active: true},
+			{UserID: "user2", Message: "hello"},
+		},
+		Error: "none",
+	}
+	var serverResult ServerMessage
+	if err := serverResult.unmarshal(serverMsg.marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(serverMsg, serverResult) {
+		t.Errorf("Server message mismatch: %+v != %+v", serverMsg, serverResult)
+	}
+
+	if err := serverResult.unmarshal([]byte{0x0a, 0xff}); err != ErrMalformedMessage {
+		t.Errorf("Wrong error for malformed message: %v", err)
+	}
+}
+
+func TestServerStreams(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	authConf := auth.NewConfig()
+	authConf.AllowCreate = true
+	authenticator, _ := auth.Factory(authConf, logger, stats)
+	storage, _ := store.Factory(store.NewConfig())
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, authenticator, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	listener, err := gonet.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpServer, err := net.CreateHTTPServer(curator, net.DefaultHTTPServerConfig(), logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpServer.Stop()
+
+	server := NewServer(NewConfig(), logger, stats)
+	go server.Serve(listener)
+	go httpServer.ServeTransport(server)
+	defer server.Stop()
+
+	conn, err := grpclib.NewClient(
+		listener.Addr().String(), grpclib.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	creator, err := Connect(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = creator.Send(ClientMessage{
+		Command:  "create",
+		UserID:   "creator",
+		Document: &store.Document{Content: "hello world"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	created, err := creator.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Type != "document" || created.Document == nil || created.Document.Content != "hello world" {
+		t.Fatalf("Unexpected create response: %+v", created)
+	}
+
+	joiner, err := Connect(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = joiner.Send(ClientMessage{Command: "find", DocID: created.Document.ID}); err != nil {
+		t.Fatal(err)
+	}
+	joined, err := joiner.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if joined.Type != "document" || joined.Document.ID != created.Document.ID {
+		t.Fatalf("Unexpected find response: %+v", joined)
+	}
+
+	if err = creator.Send(ClientMessage{
+		Command:   "submit",
+		Transform: &lib.OTransform{Position: 5, Insert: " there", Version: created.Version + 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	correction, err := creator.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if correction.Type != "correction" || correction.Version != created.Version+1 {
+		t.Errorf("Unexpected correction: %+v", correction)
+	}
+
+	for {
+		msg, err := joiner.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == "update" {
+			continue
+		}
+		if msg.Type != "transforms" || len(msg.Transforms) != 1 || msg.Transforms[0].Insert != " there" {
+			t.Errorf("Unexpected broadcast: %+v", msg)
+		}
+		break
+	}
+
+	bad, err := Connect(ctx, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = bad.Send(ClientMessage{Command: "submit"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := bad.Recv(); err != nil || msg.Type != "error" {
+		t.Errorf("Expected init error: %+v, %v", msg, err)
+	}
+}
+
+/*
+stalledListener - Accepts a single client that finds a document and then stalls while its init
+response is sent, holding on to its join of the document until released.
+*/
+type stalledListener struct {
+	docID      string
+	acceptChan chan *stalledListener
+	sentChan   chan struct{}
+	release    chan struct{}
+	received   bool
+}
+
+func (s *stalledListener) Accept() (net.Transport, error) {
+	if t, open := <-s.acceptChan; open {
+		return t, nil
+	}
+	return nil, io.EOF
+}
+
+func (s *stalledListener) Send(msg interface{}) error {
+	data, _ := json.Marshal(msg)
+	var m net.LeapServerMessage
+	if json.Unmarshal(data, &m); m.Type == "document" {
+		close(s.sentChan)
+		<-s.release
+	}
+	return nil
+}
+
+func (s *stalledListener) Receive(msg interface{}) error {
+	if m, ok := msg.(*net.LeapClientMessage); ok && !s.received {
+		s.received = true
+		*m = net.LeapClientMessage{Command: "find", DocID: s.docID}
+		return nil
+	}
+	<-s.release
+	return io.EOF
+}
+
+func (s *stalledListener) Close() error {
+	return nil
+}
+
+func TestServerListenerWrapper(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	authenticator, _ := auth.Factory(auth.NewConfig(), logger, stats)
+	storage, _ := store.Factory(store.NewConfig())
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, authenticator, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	httpConf := net.DefaultHTTPServerConfig()
+	httpConf.Path = "/guarded/socket"
+	httpConf.IPGuard.Deny = []string{"127.0.0.0/8", "::1"}
+	httpServer, err := net.CreateHTTPServer(curator, httpConf, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpServer.Stop()
+
+	listener, err := gonet.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewServer(NewConfig(), logger, stats)
+	server.SetListenerWrapper(httpServer)
+	go server.Serve(listener)
+	go httpServer.ServeTransport(server)
+	defer server.Stop()
+
+	conn, err := grpclib.NewClient(
+		listener.Addr().String(), grpclib.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if client, err := Connect(ctx, conn); err == nil {
+		if err = client.Send(ClientMessage{Command: "find", DocID: "doc"}); err == nil {
+			_, err = client.Recv()
+		}
+		if err == nil {
+			t.Error("Expected connection from a denied address to be refused")
+		}
+	}
+}
+
+func TestServerJoinLimit(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	authConf := auth.NewConfig()
+	authConf.AllowCreate = true
+	authenticator, _ := auth.Factory(authConf, logger, stats)
+	storage, _ := store.Factory(store.NewConfig())
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, authenticator, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	doc := store.Document{ID: "limited", Content: "hello world"}
+	if err = storage.Create(doc); err != nil {
+		t.Fatal(err)
+	}
+
+	httpConf := net.DefaultHTTPServerConfig()
+	httpConf.Path = "/limited/socket"
+	httpConf.Binder.Joins.MaxConcurrent = 1
+	httpConf.Binder.Joins.QueueLength = 0
+
+	httpServer, err := net.CreateHTTPServer(curator, httpConf, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpServer.Stop()
+
+	listener, err := gonet.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(NewConfig(), logger, stats)
+	go server.Serve(listener)
+	go httpServer.ServeTransport(server)
+	defer server.Stop()
+
+	stalled := &stalledListener{
+		docID:      doc.ID,
+		acceptChan: make(chan *stalledListener, 1),
+		sentChan:   make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	stalled.acceptChan <- stalled
+	close(stalled.acceptChan)
+	go httpServer.ServeTransport(stalled)
+
+	select {
+	case <-stalled.sentChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stalled client never joined")
+	}
+
+	conn, err := grpclib.NewClient(
+		listener.Addr().String(), grpclib.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	find := func() ServerMessage {
+		stream, err := Connect(ctx, conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = stream.Send(ClientMessage{Command: "find", DocID: doc.ID}); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	if msg := find(); msg.Type != "error" {
+		t.Errorf("Expected join to be turned away while another is in progress: %+v", msg)
+	}
+
+	close(stalled.release)
+
+	// The slot is freed shortly after the stalled init response completes
+	for i := 0; ; i++ {
+		msg := find()
+		if msg.Type == "document" {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("Expected join to be admitted once the other finished: %+v", msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
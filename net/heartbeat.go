@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+HeartbeatConfig - Options for detecting websocket clients that have gone without closing their
+connection. A client that sends nothing for TimeoutMS is disconnected, which releases its place in
+the document. Clients that agree on the heartbeat extension are sent a 'ping' every IntervalMS and
+answer with a 'pong', which also measures the round trip time of their connection. Older clients
+send their own pings every few seconds, and so are kept alive regardless. Setting either option to
+zero disables it.
+*/
+type HeartbeatConfig struct {
+	IntervalMS int64 `json:"interval_ms" yaml:"interval_ms"`
+	TimeoutMS  int64 `json:"timeout_ms" yaml:"timeout_ms"`
+}
+
+/*
+NewHeartbeatConfig - Returns a default HeartbeatConfig.
+*/
+func NewHeartbeatConfig() HeartbeatConfig {
+	return HeartbeatConfig{
+		IntervalMS: 15000,
+		TimeoutMS:  60000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+pingTime - Returns the time carried by a ping, which the client echoes back in its pong.
+*/
+func pingTime(now time.Time) int64 {
+	return now.UnixNano() / int64(time.Millisecond)
+}
+
+/*
+pongRTT - Returns the round trip time of a pong that echoes the time of its ping, or false if the
+echoed time is not plausible.
+*/
+func pongRTT(echoed int64, now time.Time) (time.Duration, bool) {
+	rtt := time.Duration(pingTime(now)-echoed) * time.Millisecond
+	if echoed <= 0 || rtt < 0 || rtt > time.Hour {
+		return 0, false
+	}
+	return rtt, true
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+	"golang.org/x/net/websocket"
+)
+
+func TestHeartbeat(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8258"
+	httpServerConfig.Path = "/heartbeat/socket"
+	httpServerConfig.StaticFilePath = ""
+	httpServerConfig.Binder.Heartbeat = HeartbeatConfig{IntervalMS: 20, TimeoutMS: 200}
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	origin, url := "http://localhost/", "ws://localhost:8258/heartbeat/socket"
+
+	join := func(extensions []string) (*websocket.Conn, string) {
+		ws, err := websocket.Dial(url, "", origin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		websocket.JSON.Send(ws, LeapClientMessage{
+			Command:    "create",
+			Document:   &store.Document{Content: "hello world"},
+			Extensions: extensions,
+		})
+		var initResponse LeapServerMessage
+		if err = websocket.JSON.Receive(ws, &initResponse); err != nil || initResponse.Type != "document" {
+			t.Fatalf("Init failed: %v, %v", err, initResponse.Error)
+		}
+		return ws, initResponse.Document.ID
+	}
+
+	// A client answering heartbeats is kept connected well beyond the timeout
+	wsA, _ := join([]string{ExtensionHeartbeat})
+	defer wsA.Close()
+
+	pings := 0
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		wsA.SetReadDeadline(time.Now().Add(time.Second))
+
+		var msg LeapSocketServerMessage
+		if err := websocket.JSON.Receive(wsA, &msg); err != nil {
+			t.Fatalf("Heartbeat client was disconnected: %v", err)
+		}
+		if msg.Type != "ping" || msg.Ping <= 0 {
+			t.Fatalf("Unexpected message: %v", msg)
+		}
+		pings++
+		websocket.JSON.Send(wsA, LeapSocketClientMessage{Command: "pong", Ping: msg.Ping})
+	}
+	if pings < 5 {
+		t.Errorf("Too few pings: %v", pings)
+	}
+
+	// A silent client is disconnected and leaves the document
+	wsB, docID := join(nil)
+	defer wsB.Close()
+
+	wsB.SetReadDeadline(time.Now().Add(time.Second))
+	var msg LeapSocketServerMessage
+	if err := websocket.JSON.Receive(wsB, &msg); err == nil {
+		t.Errorf("Silent client received message: %v", msg)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if users, err := curator.GetUsers(time.Second); err != nil || len(users[docID]) != 0 {
+		t.Errorf("Silent client was not removed: %v, %v", users[docID], err)
+	}
+}
+
+func TestPongRTT(t *testing.T) {
+	now := time.Now()
+	if rtt, ok := pongRTT(pingTime(now.Add(-30*time.Millisecond)), now); !ok || rtt != 30*time.Millisecond {
+		t.Errorf("Wrong rtt: %v, %v", rtt, ok)
+	}
+	if _, ok := pongRTT(0, now); ok {
+		t.Error("Missing ping was accepted")
+	}
+	if _, ok := pongRTT(pingTime(now.Add(time.Second)), now); ok {
+		t.Error("Ping from the future was accepted")
+	}
+}
@@ -23,14 +23,25 @@ THE SOFTWARE.
 package net
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	gonet "net"
 	"net/http"
 	"path"
+	"sync"
+	"time"
 
+	"github.com/jeffail/leaps/lib"
 	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/lib/util"
 	"github.com/jeffail/util/log"
 	binpath "github.com/jeffail/util/path"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 	"golang.org/x/net/websocket"
 )
 
@@ -38,12 +49,74 @@ import (
  */
 
 /*
-SSLConfig - Options for setting an SSL certificate
+AutocertConfig - Options for obtaining and renewing certificates automatically from Let's Encrypt,
+or another ACME certificate authority given by its DirectoryURL. Certificates are only issued for
+the listed Domains, and are kept in CacheDir across restarts. When HTTPAddress is set, which must be
+reachable on port 80 by the authority, ACME HTTP challenges are answered there and all other plain
+HTTP requests are redirected to HTTPS, otherwise the TLS-ALPN challenge of the HTTPS port is used.
+*/
+type AutocertConfig struct {
+	Enabled      bool     `json:"enabled" yaml:"enabled"`
+	Domains      []string `json:"domains" yaml:"domains"`
+	Email        string   `json:"email" yaml:"email"`
+	CacheDir     string   `json:"cache_dir" yaml:"cache_dir"`
+	DirectoryURL string   `json:"directory_url" yaml:"directory_url"`
+	HTTPAddress  string   `json:"http_address" yaml:"http_address"`
+}
+
+/*
+NewAutocertConfig - Creates a new AutocertConfig object with default values
+*/
+func NewAutocertConfig() AutocertConfig {
+	return AutocertConfig{
+		Enabled:      false,
+		Domains:      []string{},
+		Email:        "",
+		CacheDir:     "autocert_cache",
+		DirectoryURL: "",
+		HTTPAddress:  ":80",
+	}
+}
+
+/*
+manager - Validate the config, resolve the cache directory from the location of the binary and
+create an autocert manager from the result.
+*/
+func (a *AutocertConfig) manager() (*autocert.Manager, error) {
+	if len(a.Domains) == 0 {
+		return nil, ErrInvalidAutocertConfig
+	}
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(a.Domains...),
+		Email:      a.Email,
+	}
+	if len(a.CacheDir) > 0 {
+		if err := binpath.FromBinaryIfRelative(&a.CacheDir); err != nil {
+			return nil, fmt.Errorf("relative path for autocert cache could not be resolved: %v", err)
+		}
+		manager.Cache = autocert.DirCache(a.CacheDir)
+	}
+	if len(a.DirectoryURL) > 0 {
+		manager.Client = &acme.Client{DirectoryURL: a.DirectoryURL}
+	}
+	return manager, nil
+}
+
+/*
+SSLConfig - Options for setting an SSL certificate, either from files or obtained automatically
+through Autocert. The certificate files are reloaded whenever they change, and when ClientCAPath is
+set clients must present a certificate signed by one of the authorities it contains (mutual TLS).
+Setting ClientAuth to 'verify_if_given' rather than 'require' lets clients without a certificate
+connect as well, in which case they authenticate with tokens.
 */
 type SSLConfig struct {
-	Enabled         bool   `json:"enabled" yaml:"enabled"`
-	CertificatePath string `json:"certificate_path" yaml:"certificate_path"`
-	PrivateKeyPath  string `json:"private_key_path" yaml:"private_key_path"`
+	Enabled         bool           `json:"enabled" yaml:"enabled"`
+	CertificatePath string         `json:"certificate_path" yaml:"certificate_path"`
+	PrivateKeyPath  string         `json:"private_key_path" yaml:"private_key_path"`
+	Autocert        AutocertConfig `json:"autocert" yaml:"autocert"`
+	ClientCAPath    string         `json:"client_ca_path" yaml:"client_ca_path"`
+	ClientAuth      string         `json:"client_auth" yaml:"client_auth"`
 }
 
 /*
@@ -54,27 +127,121 @@ func NewSSLConfig() SSLConfig {
 		Enabled:         false,
 		CertificatePath: "",
 		PrivateKeyPath:  "",
+		Autocert:        NewAutocertConfig(),
+		ClientCAPath:    "",
+		ClientAuth:      "require",
 	}
 }
 
 /*
-HTTPBinderConfig - Options for individual binders (one for each socket connection)
+serverTLS - Validate the config, resolve relative paths from the location of the binary and build a
+server TLS config from the result. When certificates are obtained automatically the handler of ACME
+HTTP challenges is also returned, which is otherwise nil.
+*/
+func (s *SSLConfig) serverTLS() (*tls.Config, http.Handler, error) {
+	var tlsConfig *tls.Config
+	var challengeHandler http.Handler
+
+	if s.Autocert.Enabled {
+		manager, err := s.Autocert.manager()
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig = manager.TLSConfig()
+		challengeHandler = manager.HTTPHandler(nil)
+	} else {
+		if len(s.CertificatePath) == 0 || len(s.PrivateKeyPath) == 0 {
+			return nil, nil, ErrInvalidSSLConfig
+		}
+		if err := binpath.FromBinaryIfRelative(&s.CertificatePath); err != nil {
+			return nil, nil, fmt.Errorf("relative path for certificate could not be resolved: %v", err)
+		}
+		if err := binpath.FromBinaryIfRelative(&s.PrivateKeyPath); err != nil {
+			return nil, nil, fmt.Errorf("relative path for private key could not be resolved: %v", err)
+		}
+
+		reloader, err := util.NewCertReloader(s.CertificatePath, s.PrivateKeyPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig = &tls.Config{GetCertificate: reloader.GetCertificate}
+	}
+
+	if len(s.ClientCAPath) > 0 {
+		if err := binpath.FromBinaryIfRelative(&s.ClientCAPath); err != nil {
+			return nil, nil, fmt.Errorf("relative path for client CA could not be resolved: %v", err)
+		}
+		pemBytes, err := ioutil.ReadFile(s.ClientCAPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemBytes) {
+			return nil, nil, util.ErrInvalidCABundle
+		}
+		tlsConfig.ClientCAs = pool
+		switch s.ClientAuth {
+		case "require", "":
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		case "verify_if_given":
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, nil, ErrInvalidClientAuth
+		}
+	}
+	return tlsConfig, challengeHandler, nil
+}
+
+/*
+HTTPBinderConfig - Options for individual binders (one for each socket connection), Adaptive
+controls how often clients with slow connections are sent updates, Heartbeat how clients that have
+gone silently are detected, Compression how large messages are compressed, Snapshots how large init
+responses are compressed and Joins how many clients may join a document at once.
 */
 type HTTPBinderConfig struct {
-	BindSendTimeout int `json:"bind_send_timeout_ms" yaml:"bind_send_timeout_ms"`
+	BindSendTimeout int               `json:"bind_send_timeout_ms" yaml:"bind_send_timeout_ms"`
+	Adaptive        AdaptiveConfig    `json:"adaptive" yaml:"adaptive"`
+	Heartbeat       HeartbeatConfig   `json:"heartbeat" yaml:"heartbeat"`
+	Compression     CompressionConfig `json:"compression" yaml:"compression"`
+	Snapshots       SnapshotConfig    `json:"snapshots" yaml:"snapshots"`
+	Joins           JoinLimitConfig   `json:"joins" yaml:"joins"`
 }
 
 /*
-HTTPServerConfig - Holds configuration options for the HTTPServer.
+HTTPServerConfig - Holds configuration options for the HTTPServer. Extensions lists the protocol
+extensions that clients may agree on when joining, and defaults to all SupportedExtensions that are
+not experimental. ICEServers lists the STUN and TURN server URLs given to clients that agree on the
+experimental peer_assist extension, for setting up WebRTC data channels between each other.
+DrainPeriod is the number of seconds that connected clients are given to leave when draining. When
+AdvertiseCapabilities is set clients are sent a 'hello' message listing the ServerCapabilities as
+soon as they connect, clients that predate the message report it as an error. Messages holds the
+localised templates of the user facing messages sent to clients. PreferencesPath, when set, serves
+the endpoint through which users manage their notification preferences for documents, and
+VersionsPath the endpoint through which documents are read as they were at past versions.
 */
 type HTTPServerConfig struct {
-	StaticPath     string               `json:"static_path" yaml:"static_path"`
-	Path           string               `json:"socket_path" yaml:"socket_path"`
-	Address        string               `json:"address" yaml:"address"`
-	StaticFilePath string               `json:"www_dir" yaml:"www_dir"`
-	Binder         HTTPBinderConfig     `json:"binder" yaml:"binder"`
-	SSL            SSLConfig            `json:"ssl" yaml:"ssl"`
-	HTTPAuth       AuthMiddlewareConfig `json:"basic_auth" yaml:"basic_auth"`
+	StaticPath     string                    `json:"static_path" yaml:"static_path"`
+	Path           string                    `json:"socket_path" yaml:"socket_path"`
+	Address        string                    `json:"address" yaml:"address"`
+	StaticFilePath string                    `json:"www_dir" yaml:"www_dir"`
+	Binder         HTTPBinderConfig          `json:"binder" yaml:"binder"`
+	SSL            SSLConfig                 `json:"ssl" yaml:"ssl"`
+	HTTPAuth       AuthMiddlewareConfig      `json:"basic_auth" yaml:"basic_auth"`
+	RateLimit      RateLimitMiddlewareConfig `json:"rate_limit" yaml:"rate_limit"`
+	CORS           CORSConfig                `json:"cors" yaml:"cors"`
+	IPGuard        IPGuardConfig             `json:"ip_guard" yaml:"ip_guard"`
+	Signing        SigningConfig             `json:"signing" yaml:"signing"`
+	Affinity       AffinityConfig            `json:"affinity" yaml:"affinity"`
+	Playback       PlaybackConfig            `json:"playback" yaml:"playback"`
+	ClientLibrary  ClientLibraryConfig       `json:"client_library" yaml:"client_library"`
+	Extensions     []string                  `json:"extensions" yaml:"extensions"`
+	ICEServers     []string                  `json:"ice_servers" yaml:"ice_servers"`
+	DrainPeriod    int                       `json:"drain_period_s" yaml:"drain_period_s"`
+	Messages       MessagesConfig            `json:"messages" yaml:"messages"`
+
+	PreferencesPath       string `json:"preferences_path" yaml:"preferences_path"`
+	VersionsPath          string `json:"versions_path" yaml:"versions_path"`
+	AdvertiseCapabilities bool   `json:"advertise_capabilities" yaml:"advertise_capabilities"`
 }
 
 /*
@@ -89,9 +256,29 @@ func DefaultHTTPServerConfig() HTTPServerConfig {
 		StaticFilePath: "",
 		Binder: HTTPBinderConfig{
 			BindSendTimeout: 100,
+			Adaptive:        NewAdaptiveConfig(),
+			Heartbeat:       NewHeartbeatConfig(),
+			Compression:     NewCompressionConfig(),
+			Snapshots:       NewSnapshotConfig(),
+			Joins:           NewJoinLimitConfig(),
 		},
-		SSL:      NewSSLConfig(),
-		HTTPAuth: NewAuthMiddlewareConfig(),
+		SSL:           NewSSLConfig(),
+		HTTPAuth:      NewAuthMiddlewareConfig(),
+		RateLimit:     NewRateLimitMiddlewareConfig(),
+		CORS:          NewCORSConfig(),
+		IPGuard:       NewIPGuardConfig(),
+		Signing:       NewSigningConfig(),
+		Affinity:      NewAffinityConfig(),
+		Playback:      NewPlaybackConfig(),
+		ClientLibrary: NewClientLibraryConfig(),
+		Extensions:    defaultExtensions(),
+		ICEServers:    []string{},
+		DrainPeriod:   10,
+		Messages:      NewMessagesConfig(),
+
+		PreferencesPath:       "",
+		VersionsPath:          "",
+		AdvertiseCapabilities: false,
 	}
 }
 
@@ -100,25 +287,68 @@ func DefaultHTTPServerConfig() HTTPServerConfig {
 
 /*
 LeapClientMessage - A structure that defines a message format to expect from clients. Commands can
-be 'create' (init with new document) or 'find' (init with existing document).
+be 'create' (init with new document), 'find' (init with existing document) or 'read' (init with
+existing document in read only mode). Clients set Presence to subscribe to users joining and leaving
+the document, and can describe themselves to other users with Metadata. Clients set Spectators to
+receive the number of read only clients of the document, and Diagnostics to receive the validation
+problems of the document. Clients may instead offer a list of Extensions, of which the server agrees
+to those it supports. Read only clients may set Throttle in order to receive at most one batch of
+transforms every Throttle milliseconds. Clients that agree on the resume extension and reconnect to
+a document they already hold may 'find' it with the ResumeEpoch and ResumeVersion of their copy.
+Clients may set Locale to receive user facing messages in their language rather than that of the
+Accept-Language header of their connection. Clients list the SnapshotCodecs they can decode, in
+order of preference, to receive large init responses compressed. Before init, clients may send
+'version' with a DocID and Version to be sent the document as it was at that version in a 'document'
+message.
 */
 type LeapClientMessage struct {
-	Command  string          `json:"command" yaml:"command"`
-	Token    string          `json:"token" yaml:"token"`
-	DocID    string          `json:"document_id,omitempty" yaml:"document_id,omitempty"`
-	UserID   string          `json:"user_id,omitempty" yaml:"user_id,omitempty"`
-	Document *store.Document `json:"leap_document,omitempty" yaml:"leap_document,omitempty"`
+	Command       string            `json:"command" yaml:"command"`
+	Token         string            `json:"token" yaml:"token"`
+	DocID         string            `json:"document_id,omitempty" yaml:"document_id,omitempty"`
+	UserID        string            `json:"user_id,omitempty" yaml:"user_id,omitempty"`
+	Document      *store.Document   `json:"leap_document,omitempty" yaml:"leap_document,omitempty"`
+	Presence      bool              `json:"presence,omitempty" yaml:"presence,omitempty"`
+	Spectators    bool              `json:"spectators,omitempty" yaml:"spectators,omitempty"`
+	Diagnostics   bool              `json:"diagnostics,omitempty" yaml:"diagnostics,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Extensions    []string          `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	Throttle      int64             `json:"throttle_ms,omitempty" yaml:"throttle_ms,omitempty"`
+	ResumeEpoch   string            `json:"resume_epoch,omitempty" yaml:"resume_epoch,omitempty"`
+	ResumeVersion int               `json:"resume_version,omitempty" yaml:"resume_version,omitempty"`
+	Version       int               `json:"version,omitempty" yaml:"version,omitempty"`
+	Locale        string            `json:"locale,omitempty" yaml:"locale,omitempty"`
+
+	SnapshotCodecs []string `json:"snapshot_codecs,omitempty" yaml:"snapshot_codecs,omitempty"`
 }
 
 /*
 LeapServerMessage - A structure that defines a response message from the server to a client. Type
-can be 'document' (init response) or 'error' (an error message to display to the client).
+can be 'hello' (sent on connect with the Capabilities of the server), 'document' (init response, or
+the response to 'version'), 'resume' (init response to a resumed client, carrying the Transforms
+missed since its version rather than the document) or 'error' (an error message to display to the
+client, localised, with the Code of the message for clients that render their own text, and
+ErrorInfo). The init response lists the agreed Extensions when the client offered any, and the Epoch
+of the document when the resume extension is agreed. When the peer_assist extension is agreed the
+init response also carries the UserID of the client, by which other clients address their signals,
+and the ICEServers to use. When a snapshot codec is agreed a large Document or Transforms is
+replaced with its EncodedPayload.
 */
 type LeapServerMessage struct {
-	Type     string          `json:"response_type" yaml:"response_type"`
-	Document *store.Document `json:"leap_document,omitempty" yaml:"leap_document,omitempty"`
-	Version  *int            `json:"version,omitempty" yaml:"version,omitempty"`
-	Error    string          `json:"error,omitempty" yaml:"error,omitempty"`
+	Type       string           `json:"response_type" yaml:"response_type"`
+	Document   *store.Document  `json:"leap_document,omitempty" yaml:"leap_document,omitempty"`
+	Version    *int             `json:"version,omitempty" yaml:"version,omitempty"`
+	Epoch      string           `json:"epoch,omitempty" yaml:"epoch,omitempty"`
+	Transforms []lib.OTransform `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+	Extensions []string         `json:"extensions,omitempty" yaml:"extensions,omitempty"`
+	UserID     string           `json:"user_id,omitempty" yaml:"user_id,omitempty"`
+	ICEServers []string         `json:"ice_servers,omitempty" yaml:"ice_servers,omitempty"`
+	Payload    *EncodedPayload  `json:"payload,omitempty" yaml:"payload,omitempty"`
+	Error      string           `json:"error,omitempty" yaml:"error,omitempty"`
+	Code       string           `json:"code,omitempty" yaml:"code,omitempty"`
+	ErrorInfo  *ErrorInfo       `json:"error_info,omitempty" yaml:"error_info,omitempty"`
+	Signature  string           `json:"signature,omitempty" yaml:"signature,omitempty"`
+
+	Capabilities *ServerCapabilities `json:"capabilities,omitempty" yaml:"capabilities,omitempty"`
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -126,8 +356,10 @@ type LeapServerMessage struct {
 
 // Errors for the HTTPServer type.
 var (
-	ErrInvalidSocketPath = errors.New("invalid config value for socket path")
-	ErrInvalidDocument   = errors.New("invalid document structure")
+	ErrInvalidSocketPath    = errors.New("invalid config value for socket path")
+	ErrInvalidDocument      = errors.New("invalid document structure")
+	ErrInitExpected         = errors.New("first message must be init")
+	ErrVersionsNotSupported = errors.New("reading past versions is not supported")
 )
 
 /*
@@ -139,8 +371,20 @@ type HTTPServer struct {
 	logger    *log.Logger
 	stats     *log.Stats
 	auth      *AuthMiddleware
+	limits    *RateLimitMiddleware
+	cors      *CORSMiddleware
+	guard     *IPGuard
+	signer    *Signer
+	messages  *Messages
+	deflater  *messageDeflater
+	initCache *snapshotCache
+	joins     *joinLimiter
 	locator   LeapLocator
+	certAuth  CertificateAuthenticator
 	closeChan chan bool
+	drainChan chan struct{}
+	drainOnce sync.Once
+	closeOnce sync.Once
 }
 
 /*
@@ -156,21 +400,74 @@ func CreateHTTPServer(
 	if err != nil {
 		return nil, err
 	}
+	signer, err := NewSigner(config.Signing)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := NewMessages(config.Messages)
+	if err != nil {
+		return nil, err
+	}
+	cors, err := NewCORSMiddleware(config.CORS, logger, stats)
+	if err != nil {
+		return nil, err
+	}
+	guard, err := NewIPGuard(config.IPGuard, logger, stats)
+	if err != nil {
+		return nil, err
+	}
 	httpServer := HTTPServer{
 		config:    config,
 		locator:   locator,
 		logger:    logger.NewModule(":http"),
 		stats:     stats,
 		auth:      auth,
+		limits:    NewRateLimitMiddleware(config.RateLimit, logger, stats),
+		cors:      cors,
+		guard:     guard,
+		signer:    signer,
+		messages:  messages,
+		joins:     newJoinLimiter(config.Binder.Joins, stats),
+		initCache: newSnapshotCache(config.Binder.Snapshots, stats),
 		closeChan: make(chan bool),
+		drainChan: make(chan struct{}),
 	}
 	if len(httpServer.config.Path) == 0 {
 		return nil, ErrInvalidSocketPath
 	}
-	http.Handle(
-		httpServer.config.Path,
-		httpServer.auth.WrapWSHandler(websocket.Handler(httpServer.websocketHandler)),
-	)
+	if err = validateExtensions(httpServer.config.Extensions); err != nil {
+		return nil, err
+	}
+	if httpServer.deflater, err = newMessageDeflater(config.Binder.Compression); err != nil {
+		return nil, err
+	}
+	if err = validateSnapshotCodecs(config.Binder.Snapshots.Codecs); err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		http.HandleFunc(httpServer.config.Signing.KeyPath, httpServer.wrapHandlerFunc(signer.ServeKey))
+	}
+	// Sockets are limited by their handshake, as headers cannot be sent once upgraded.
+	http.Handle(httpServer.config.Path, httpServer.limits.WrapHandler(websocket.Server{
+		Handler:   httpServer.auth.WrapWSHandler(websocket.Handler(httpServer.websocketHandler)),
+		Handshake: httpServer.cors.WrapHandshake(affinityHandshake(httpServer.config.Affinity)),
+	}))
+	if len(httpServer.config.Playback.Path) > 0 {
+		http.Handle(httpServer.config.Playback.Path, httpServer.wrapHandlerFunc(
+			httpServer.auth.WrapHandlerFunc(httpServer.playbackHandler)))
+	}
+	if len(httpServer.config.PreferencesPath) > 0 {
+		http.Handle(httpServer.config.PreferencesPath, httpServer.wrapHandlerFunc(
+			httpServer.auth.WrapHandlerFunc(httpServer.preferencesHandler)))
+	}
+	if len(httpServer.config.VersionsPath) > 0 {
+		http.Handle(httpServer.config.VersionsPath, httpServer.wrapHandlerFunc(
+			httpServer.auth.WrapHandlerFunc(httpServer.versionsHandler)))
+	}
+	if len(httpServer.config.ClientLibrary.Path) > 0 {
+		http.Handle(httpServer.config.ClientLibrary.Path, httpServer.wrapHandlerFunc(
+			httpServer.auth.WrapHandlerFunc(httpServer.clientLibraryHandler)))
+	}
 	if len(httpServer.config.StaticFilePath) > 0 {
 		if len(httpServer.config.StaticPath) == 0 {
 			return nil, ErrInvalidStaticPath
@@ -179,10 +476,10 @@ func CreateHTTPServer(
 		if err := binpath.FromBinaryIfRelative(&httpServer.config.StaticFilePath); err != nil {
 			return nil, fmt.Errorf("relative path for static files could not be resolved: %v", err)
 		}
-		http.Handle(httpServer.config.StaticPath,
+		http.Handle(httpServer.config.StaticPath, httpServer.wrapHandlerFunc( // CORS and rate limit wrap
 			httpServer.auth.WrapHandler( // Auth wrap
 				http.StripPrefix(httpServer.config.StaticPath, // File strip prefix wrap
-					http.FileServer(http.Dir(httpServer.config.StaticFilePath))))) // File serve handler
+					http.FileServer(http.Dir(httpServer.config.StaticFilePath)))))) // File serve handler
 	}
 	return &httpServer, nil
 }
@@ -194,15 +491,234 @@ func CreateHTTPServer(
 Register - Register your handler func to an endpoint of the public user API.
 */
 func (h *HTTPServer) Register(endpoint, description string, handler http.HandlerFunc) {
-	http.HandleFunc(path.Join(h.config.StaticPath, endpoint), handler)
+	http.HandleFunc(path.Join(h.config.StaticPath, endpoint), h.wrapHandlerFunc(handler))
+}
+
+/*
+wrapHandlerFunc - Wrap a handler of the public API with CORS headers and rate limits, such that
+preflight requests are answered before they count against the limits of a client.
+*/
+func (h *HTTPServer) wrapHandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
+	return h.cors.WrapHandlerFunc(h.limits.WrapHandlerFunc(handler))
+}
+
+/*
+send - Sign a message if signing is enabled, and send it to a client.
+*/
+func (h *HTTPServer) send(t Transport, msg LeapServerMessage) error {
+	msg.Signature = h.signer.Sign(msg)
+	return t.Send(msg)
+}
+
+/*
+sendError - Send a user facing error message to a client in its locale, along with the structured
+form of the underlying error, which may be nil.
+*/
+func (h *HTTPServer) sendError(t Transport, locale, code string, err error) error {
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	return h.send(t, LeapServerMessage{
+		Type:      "error",
+		Error:     h.messages.Format(locale, code, detail),
+		Code:      code,
+		ErrorInfo: classifyError(code, err),
+	})
+}
+
+/*
+encodeInitPayload - Replace the document or missed transforms of an init response with their
+encoded form, provided the client offered a snapshot codec and they are large enough.
+*/
+func (h *HTTPServer) encodeInitPayload(initMsg *LeapServerMessage, offered []string) {
+	snapshots := h.config.Binder.Snapshots
+	codec := negotiateSnapshotCodec(offered, snapshots.Codecs)
+	if len(codec) == 0 {
+		return
+	}
+
+	var payload interface{} = initMsg.Document
+	if initMsg.Document == nil {
+		payload = initMsg.Transforms
+	}
+	encoded, err := encodePayload(payload, codec, snapshots.ThresholdBytes)
+	if err != nil {
+		h.logger.Errorf("Failed to encode init payload with %v: %v\n", codec, err)
+		return
+	}
+	if encoded == nil {
+		return
+	}
+	h.stats.Incr("http.websocket.encoded_payload."+codec, 1)
+	initMsg.Payload = encoded
+	if initMsg.Document != nil {
+		initMsg.Document = nil
+	} else {
+		initMsg.Transforms = nil
+	}
+}
+
+/*
+initCacheKey - Returns the key under which an init response is shared with other clients joining the
+same version of the document, which covers everything but the content of the document. Responses
+that are particular to a client, such as resumes and those addressing the client by ID, are not
+shared.
+*/
+func initCacheKey(initMsg LeapServerMessage, codec string) (string, bool) {
+	if initMsg.Document == nil || len(initMsg.UserID) > 0 {
+		return "", false
+	}
+	envelope := initMsg
+	envelope.Document = &store.Document{
+		ID:       initMsg.Document.ID,
+		Type:     initMsg.Document.Type,
+		Metadata: initMsg.Document.Metadata,
+	}
+	envelopeBytes, err := json.Marshal(envelope)
+	if err != nil {
+		return "", false
+	}
+	return codec + ":" + string(envelopeBytes), true
+}
+
+/*
+launchSocket - Send the init response to a client bound to a document, and route its transport to
+its binder until either side closes. Joined is called once the init response has been sent, and may
+be nil.
+*/
+func (h *HTTPServer) launchSocket(
+	t Transport,
+	binder lib.BinderPortal,
+	clientMsg LeapClientMessage,
+	locale string,
+	joined func(),
+) {
+	extensions := negotiateExtensions(clientMsg.Extensions, h.config.Extensions)
+	ws, isWebsocket := t.(*websocketTransport)
+	if !isWebsocket {
+		extensions = withoutExtension(extensions, ExtensionBinary)
+	}
+	if !isWebsocket || h.deflater == nil || hasExtension(extensions, ExtensionBinary) {
+		// MessagePack frames are not compressed, binary clients are expected to enable websocket
+		// compression instead.
+		extensions = withoutExtension(extensions, ExtensionDeflate)
+	}
+
+	initMsg := LeapServerMessage{
+		Type:       "document",
+		Document:   &binder.Document,
+		Version:    &binder.Version,
+		Extensions: extensions,
+	}
+	if hasExtension(extensions, ExtensionResume) {
+		initMsg.Epoch = binder.Epoch
+		if binder.ResumedFrom > 0 {
+			h.stats.Incr("http.websocket.resumed", 1)
+			initMsg.Type = "resume"
+			initMsg.Document = nil
+			initMsg.Version = &binder.ResumedFrom
+			initMsg.Transforms = binder.Missed
+		}
+	}
+	binder.Missed = nil
+	if hasExtension(extensions, ExtensionPeerAssist) {
+		initMsg.UserID = binder.UserID
+		initMsg.ICEServers = h.config.ICEServers
+	}
+
+	deflate := hasExtension(extensions, ExtensionDeflate)
+	encode := func() (wireFrame, error) {
+		h.encodeInitPayload(&initMsg, clientMsg.SnapshotCodecs)
+		initMsg.Signature = h.signer.Sign(initMsg)
+		if deflate {
+			// The init response carries the whole document, and so benefits the most from compression.
+			return h.deflater.frame(initMsg)
+		}
+		data, err := json.Marshal(initMsg)
+		return wireFrame{data: data}, err
+	}
+
+	var frame wireFrame
+	var err error
+	codec := negotiateSnapshotCodec(clientMsg.SnapshotCodecs, h.config.Binder.Snapshots.Codecs)
+	if key, ok := initCacheKey(initMsg, codec); ok {
+		frame, err = h.initCache.frame(binder.Document.ID, binder.Epoch, binder.Version, key, encode)
+	} else {
+		frame, err = encode()
+	}
+	if err == nil {
+		err = frame.send(t)
+	}
+	if err != nil {
+		h.logger.Errorf("Failed to send init response: %v\n", err)
+	}
+	if hasExtension(extensions, ExtensionBinary) {
+		// The init response is always JSON, as the client only learns of the agreement from it.
+		ws.useBinary()
+	}
+
+	tracer.trace(binder.Token, binder.Document.ID, "in", clientMsg)
+	tracer.trace(binder.Token, binder.Document.ID, "out", initMsg)
+
+	socketRouter := NewTransportServer(h.config.Binder, t, binder, h.closeChan, h.signer, h.logger, h.stats)
+	if deflate {
+		socketRouter.setDeflater(h.deflater)
+	}
+	if joined != nil {
+		joined()
+	}
+	socketRouter.SetPresence(presenceOptions(clientMsg, extensions, h.config.Extensions))
+	socketRouter.SetMessages(h.messages, locale)
+	if banners, ok := h.locator.(BannerLocator); ok {
+		socketRouter.SetBanner(banners.Banner())
+	}
+	socketRouter.Launch()
+}
+
+/*
+readDocument - Bind a read only client to a document, with throttled delivery if it asked for it.
+*/
+func (h *HTTPServer) readDocument(clientMsg LeapClientMessage) (lib.BinderPortal, error) {
+	if clientMsg.Throttle <= 0 {
+		return h.locator.ReadDocument(clientMsg.Token, clientMsg.DocID)
+	}
+	throttled, ok := h.locator.(ThrottledLocator)
+	if !ok {
+		return lib.BinderPortal{}, lib.ErrThrottleNotSupported
+	}
+	period := time.Duration(clientMsg.Throttle) * time.Millisecond
+	return throttled.ReadDocumentThrottled(clientMsg.Token, clientMsg.DocID, period)
+}
+
+/*
+editDocument - Bind a client to a document, resuming from the version it already holds if it asked
+to and the resume extension is agreed.
+*/
+func (h *HTTPServer) editDocument(clientMsg LeapClientMessage) (lib.BinderPortal, error) {
+	if clientMsg.ResumeVersion > 0 &&
+		hasExtension(negotiateExtensions(clientMsg.Extensions, h.config.Extensions), ExtensionResume) {
+		if resumer, ok := h.locator.(ResumeLocator); ok {
+			return resumer.ResumeDocument(clientMsg.Token, clientMsg.DocID, clientMsg.ResumeEpoch, clientMsg.ResumeVersion)
+		}
+	}
+	return h.locator.EditDocument(clientMsg.Token, clientMsg.DocID)
 }
 
 /*
 websocketHandler - The method for creating fresh websocket clients.
 */
 func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
+	h.serveTransport(newWebsocketTransport(ws))
+}
+
+/*
+serveTransport - Serve a fresh client until it closes, binding it to a document according to its
+init message.
+*/
+func (h *HTTPServer) serveTransport(t Transport) {
 	defer func() {
-		if err := ws.Close(); err != nil {
+		if err := t.Close(); err != nil {
 			h.logger.Errorf("Failed to close socket: %v\n", err)
 		}
 		h.stats.Decr("http.open_websockets", 1)
@@ -211,29 +727,54 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 	h.stats.Incr("http.websocket.opened", 1)
 	h.stats.Incr("http.open_websockets", 1)
 
+	acceptLanguage := ""
+	if req := transportRequest(t); req != nil {
+		acceptLanguage = req.Header.Get("Accept-Language")
+	}
+	locale := h.messages.Negotiate("", acceptLanguage)
+
 	select {
 	case <-h.closeChan:
-		websocket.JSON.Send(ws, LeapServerMessage{
-			Type:  "error",
-			Error: "target server node is closing",
-		})
+		h.sendError(t, locale, MessageServerClosing, nil)
+		return
+	case <-h.drainChan:
+		h.sendError(t, locale, MessageServerShuttingDown, nil)
 		return
 	default:
 	}
 
-	h.logger.Infoln("Fresh client connected via websocket")
+	h.logger.Infoln("Fresh client connected")
+
+	if h.config.AdvertiseCapabilities {
+		capabilities := h.capabilities()
+		h.send(t, LeapServerMessage{
+			Type:         "hello",
+			Capabilities: &capabilities,
+		})
+	}
 
 	handleInitError := func(err error) {
 		h.logger.Infof("Client failed to init: %v\n", err)
-		websocket.JSON.Send(ws, LeapServerMessage{
-			Type:  "error",
-			Error: fmt.Sprintf("socket initialization failed: %v", err),
-		})
+		h.sendError(t, locale, MessageInitFailed, err)
+	}
+
+	certToken, certUser := h.authenticateCertificate(t)
+	if len(certToken) > 0 {
+		defer h.certAuth.ReleaseToken(certToken)
 	}
 
 	for {
 		var clientMsg LeapClientMessage
-		websocket.JSON.Receive(ws, &clientMsg)
+		t.Receive(&clientMsg)
+		if len(clientMsg.Locale) > 0 {
+			locale = h.messages.Negotiate(clientMsg.Locale, acceptLanguage)
+		}
+		if len(certToken) > 0 {
+			clientMsg.Token, clientMsg.UserID = certToken, certUser
+		} else if !h.verifyClientToken(transportRequest(t), clientMsg.Token) {
+			handleInitError(lib.ErrUnauthorised)
+			return
+		}
 
 		switch clientMsg.Command {
 		case "create":
@@ -246,13 +787,7 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 				clientMsg.Token, clientMsg.UserID, *clientMsg.Document); err == nil {
 				h.logger.Infof("Client bound to document %v\n", binder.Document.ID)
 
-				websocket.JSON.Send(ws, LeapServerMessage{
-					Type:     "document",
-					Document: &binder.Document,
-					Version:  &binder.Version,
-				})
-				socketRouter := NewWebsocketServer(h.config.Binder, ws, binder, h.closeChan, h.logger, h.stats)
-				socketRouter.Launch()
+				h.launchSocket(t, binder, clientMsg, locale, nil)
 			} else {
 				handleInitError(err)
 			}
@@ -263,17 +798,17 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 				return
 			}
 			h.logger.Infof("Attempting to read only bind to document: %v\n", clientMsg.DocID)
-			if binder, err := h.locator.ReadDocument(clientMsg.Token, clientMsg.DocID); err == nil {
+			joined, err := h.joins.acquire(clientMsg.DocID)
+			if err != nil {
+				handleInitError(err)
+				return
+			}
+			if binder, err := h.readDocument(clientMsg); err == nil {
 				h.logger.Infof("Client read only bound to document %v\n", binder.Document.ID)
 
-				websocket.JSON.Send(ws, LeapServerMessage{
-					Type:     "document",
-					Document: &binder.Document,
-					Version:  &binder.Version,
-				})
-				socketRouter := NewWebsocketServer(h.config.Binder, ws, binder, h.closeChan, h.logger, h.stats)
-				socketRouter.Launch()
+				h.launchSocket(t, binder, clientMsg, locale, joined)
 			} else {
+				joined()
 				handleInitError(err)
 			}
 			return
@@ -283,64 +818,104 @@ func (h *HTTPServer) websocketHandler(ws *websocket.Conn) {
 				return
 			}
 			h.logger.Infof("Attempting to bind to document: %v\n", clientMsg.DocID)
-			if binder, err := h.locator.EditDocument(clientMsg.Token, clientMsg.DocID); err == nil {
+			joined, err := h.joins.acquire(clientMsg.DocID)
+			if err != nil {
+				handleInitError(err)
+				return
+			}
+			if binder, err := h.editDocument(clientMsg); err == nil {
 				h.logger.Infof("Client bound to document %v\n", binder.Document.ID)
 
-				websocket.JSON.Send(ws, LeapServerMessage{
-					Type:     "document",
-					Document: &binder.Document,
-					Version:  &binder.Version,
-				})
-				socketRouter := NewWebsocketServer(h.config.Binder, ws, binder, h.closeChan, h.logger, h.stats)
-				socketRouter.Launch()
+				h.launchSocket(t, binder, clientMsg, locale, joined)
 			} else {
+				joined()
 				handleInitError(err)
 			}
 			return
+		case "version":
+			if docMsg, err := h.documentVersion(clientMsg.Token, clientMsg.DocID, clientMsg.Version); err == nil {
+				h.send(t, docMsg)
+			} else {
+				h.sendError(t, locale, MessageVersionFailed, err)
+			}
 		case "ping":
 			// Ignore
 		default:
-			handleInitError(fmt.Errorf("first message must be init, client sent: %v", clientMsg.Command))
+			h.logger.Infof("Client sent %v before init\n", clientMsg.Command)
+			handleInitError(ErrInitExpected)
 			return
 		}
 	}
 }
 
 /*
-Listen - Bind to the http endpoint as per configured address, and begin serving requests. This is
-simply a helper function that calls http.ListenAndServe
+WrapListener - Wrap a listener such that its connections are screened by the IP guard of the server,
+which allows other servers such as the gRPC server to share its allow and deny lists and its limit
+of connections per IP.
+*/
+func (h *HTTPServer) WrapListener(listener gonet.Listener) gonet.Listener {
+	return h.guard.WrapListener(listener)
+}
+
+/*
+Listen - Bind to the http endpoint as per configured address, and begin serving requests. Connections
+are screened by the IP guard before any request is read.
 */
 func (h *HTTPServer) Listen() error {
 	if len(h.config.Address) == 0 {
 		return ErrInvalidURLAddr
 	}
+	server := &http.Server{Addr: h.config.Address}
 	if h.config.SSL.Enabled {
-		if len(h.config.SSL.CertificatePath) == 0 || len(h.config.SSL.PrivateKeyPath) == 0 {
-			return ErrInvalidSSLConfig
-		}
-		// If the static paths are relative then we use the location of the binary to resolve it.
-		if err := binpath.FromBinaryIfRelative(&h.config.SSL.CertificatePath); err != nil {
-			return fmt.Errorf("relative path for certificate could not be resolved: %v", err)
+		tlsConfig, challengeHandler, err := h.config.SSL.serverTLS()
+		if err != nil {
+			return err
 		}
-		if err := binpath.FromBinaryIfRelative(&h.config.SSL.PrivateKeyPath); err != nil {
-			return fmt.Errorf("relative path for private key could not be resolved: %v", err)
+		server.TLSConfig = tlsConfig
+		if challengeAddr := h.config.SSL.Autocert.HTTPAddress; challengeHandler != nil && len(challengeAddr) > 0 {
+			h.logger.Infof("Answering ACME challenges at address: %v\n", challengeAddr)
+			go func() {
+				if err := http.ListenAndServe(challengeAddr, challengeHandler); err != nil {
+					h.logger.Errorf("Failed to serve ACME challenges: %v\n", err)
+				}
+			}()
 		}
 	}
+	listener, err := gonet.Listen("tcp", h.config.Address)
+	if err != nil {
+		return err
+	}
+	listener = h.guard.WrapListener(listener)
+
 	h.logger.Infof("Listening for websockets at address: %v%v\n", h.config.Address, h.config.Path)
 	if len(h.config.StaticPath) > 0 {
 		h.logger.Infof("Serving static file requests at address: %v%v\n", h.config.Address, h.config.StaticPath)
 	}
-	var err error
 	if h.config.SSL.Enabled {
-		err = http.ListenAndServeTLS(
-			h.config.Address,
-			h.config.SSL.CertificatePath,
-			h.config.SSL.PrivateKeyPath,
-			nil,
-		)
-	} else {
-		err = http.ListenAndServe(h.config.Address, nil)
+		return server.ServeTLS(listener, "", "")
 	}
+	return server.Serve(listener)
+}
+
+/*
+Drain - Gracefully shut the HTTPServer down. New clients are turned away, and if the locator
+supports draining then connected clients are notified and given the configured drain period to
+leave before their documents are flushed and closed. The HTTPServer is stopped afterwards, and so
+the locator is closed once this returns.
+*/
+func (h *HTTPServer) Drain() error {
+	var err error
+	h.drainOnce.Do(func() {
+		h.logger.Infoln("Draining, refusing new clients")
+		close(h.drainChan)
+
+		if drainer, ok := h.locator.(Drainer); ok {
+			err = drainer.Drain(time.Duration(h.config.DrainPeriod) * time.Second)
+		} else {
+			h.locator.Close()
+		}
+		h.Stop()
+	})
 	return err
 }
 
@@ -348,7 +923,9 @@ func (h *HTTPServer) Listen() error {
 Stop - Stop serving web requests and close the HTTPServer.
 */
 func (h *HTTPServer) Stop() {
-	close(h.closeChan)
+	h.closeOnce.Do(func() {
+		close(h.closeChan)
+	})
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -23,10 +23,17 @@ THE SOFTWARE.
 package net
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -34,19 +41,21 @@ import (
 	"github.com/jeffail/leaps/lib"
 	"github.com/jeffail/leaps/lib/auth"
 	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/test/conformance"
 	"github.com/jeffail/util/log"
 	"golang.org/x/net/websocket"
 )
 
-type binderStory struct {
-	Content    string           `json:"content" yaml:"content"`
-	Transforms []lib.OTransform `json:"transforms" yaml:"transforms"`
-	TCorrected []lib.OTransform `json:"corrected_transforms" yaml:"corrected_transforms"`
-	Result     string           `json:"result" yaml:"result"`
-}
-
-type binderStoriesContainer struct {
-	Stories []binderStory `json:"binder_stories" yaml:"binder_stories"`
+/*
+fixtureTransform - Converts a transform of the conformance fixtures into an OTransform.
+*/
+func fixtureTransform(tform conformance.Transform) lib.OTransform {
+	return lib.OTransform{
+		Position: tform.Position,
+		Delete:   tform.Delete,
+		Insert:   tform.Insert,
+		Version:  tform.Version,
+	}
 }
 
 func findDocument(id string, ws *websocket.Conn) error {
@@ -141,7 +150,7 @@ func senderClient(id string, feeds <-chan lib.OTransform, t *testing.T) {
 		}
 	}
 }
-func goodStoryClient(id string, bstory *binderStory, wg *sync.WaitGroup, t *testing.T) {
+func goodStoryClient(id string, bstory *conformance.BinderStory, wg *sync.WaitGroup, t *testing.T) {
 	origin := "http://localhost/"
 	url := "ws://localhost:8254/leaps/socket"
 
@@ -182,7 +191,7 @@ func goodStoryClient(id string, bstory *binderStory, wg *sync.WaitGroup, t *test
 		}
 	}()
 
-	tformIndex, lenCorrected := 0, len(bstory.TCorrected)
+	tformIndex, lenCorrected := 0, len(bstory.CorrectedTransforms)
 	for {
 		select {
 		case ret, open := <-rcvChan:
@@ -192,12 +201,12 @@ func goodStoryClient(id string, bstory *binderStory, wg *sync.WaitGroup, t *test
 				return
 			}
 			for _, tform := range ret {
-				if tform.Version != bstory.TCorrected[tformIndex].Version ||
-					tform.Insert != bstory.TCorrected[tformIndex].Insert ||
-					tform.Delete != bstory.TCorrected[tformIndex].Delete ||
-					tform.Position != bstory.TCorrected[tformIndex].Position {
+				if tform.Version != bstory.CorrectedTransforms[tformIndex].Version ||
+					tform.Insert != bstory.CorrectedTransforms[tformIndex].Insert ||
+					tform.Delete != bstory.CorrectedTransforms[tformIndex].Delete ||
+					tform.Position != bstory.CorrectedTransforms[tformIndex].Position {
 					t.Errorf("Transform (%v) not expected, %v != %v",
-						tformIndex, tform, bstory.TCorrected[tformIndex])
+						tformIndex, tform, bstory.CorrectedTransforms[tformIndex])
 				}
 				tformIndex++
 				if tformIndex == lenCorrected {
@@ -230,15 +239,9 @@ func authAndStore(logger *log.Logger, stats *log.Stats) (auth.Authenticator, sto
 }
 
 func TestHttpServer(t *testing.T) {
-	bytes, err := ioutil.ReadFile("../test/stories/binder_stories.js")
+	stories, err := conformance.BinderStories()
 	if err != nil {
-		t.Errorf("Read file error: %v", err)
-		return
-	}
-
-	var scont binderStoriesContainer
-	if err := json.Unmarshal(bytes, &scont); err != nil {
-		t.Errorf("Story parse error: %v", err)
+		t.Errorf("Story load error: %v", err)
 		return
 	}
 
@@ -269,7 +272,7 @@ func TestHttpServer(t *testing.T) {
 	origin := "http://localhost/"
 	url := "ws://localhost:8254/leaps/socket"
 
-	for _, story := range scont.Stories {
+	for _, story := range stories {
 
 		ws, err := websocket.Dial(url, "", origin)
 		if err != nil {
@@ -313,7 +316,7 @@ func TestHttpServer(t *testing.T) {
 		time.Sleep(50 * time.Millisecond)
 
 		for j := 0; j < len(story.Transforms); j++ {
-			feeds <- story.Transforms[j]
+			feeds <- fixtureTransform(story.Transforms[j])
 		}
 
 		wg.Wait()
@@ -321,3 +324,288 @@ func TestHttpServer(t *testing.T) {
 
 	curator.Close()
 }
+
+func TestAffinityHandshake(t *testing.T) {
+	config := NewAffinityConfig()
+	config.Enabled = true
+
+	req, _ := http.NewRequest("GET", "http://localhost/leaps/socket?document_id=doc1", nil)
+	req.Header.Set("Origin", "http://localhost/")
+
+	wsConfig := &websocket.Config{Version: websocket.ProtocolVersionHybi13}
+	if err := affinityHandshake(config)(wsConfig, req); err != nil {
+		t.Fatal(err)
+	}
+	if key := wsConfig.Header.Get("X-Leaps-Affinity"); key != AffinityKey("doc1") {
+		t.Errorf("Wrong affinity header: %v != %v", key, AffinityKey("doc1"))
+	}
+	if cookie := wsConfig.Header.Get("Set-Cookie"); cookie != "leaps_affinity="+AffinityKey("doc1")+"; Path=/" {
+		t.Errorf("Wrong affinity cookie: %v", cookie)
+	}
+	if AffinityKey("doc1") == AffinityKey("doc2") {
+		t.Error("Different documents share an affinity key")
+	}
+
+	req.Header.Del("Origin")
+	if err := affinityHandshake(config)(&websocket.Config{Version: websocket.ProtocolVersionHybi13}, req); err == nil {
+		t.Error("Expected null origin to be rejected")
+	}
+}
+
+type playbackLocator struct {
+	LeapLocator
+	recording lib.Recording
+}
+
+func (p playbackLocator) ReadRecording(token, id string) (lib.Recording, error) {
+	if token != "reader" {
+		return lib.Recording{}, lib.ErrUnauthorised
+	}
+	return p.recording, nil
+}
+
+func TestPlaybackHandler(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	started := time.Now()
+	recording := lib.Recording{Bindings: []lib.RecordingBinding{
+		{
+			Epoch:    "first",
+			Started:  started,
+			Document: store.Document{ID: "doc", Content: "hello world"},
+			Version:  1,
+			Transforms: []lib.RecordEntry{
+				{Time: started.Add(time.Second), Transform: &lib.OTransform{Position: 5, Insert: ",", Version: 2}},
+				{Time: started.Add(2 * time.Second), Transform: &lib.OTransform{Position: 12, Insert: "!", Version: 3}},
+			},
+		},
+		{
+			Epoch:    "second",
+			Started:  started.Add(3 * time.Second),
+			Document: store.Document{ID: "doc", Content: "hello, world!"},
+			Version:  1,
+			Transforms: []lib.RecordEntry{
+				{Time: started.Add(4 * time.Second), Transform: &lib.OTransform{Position: 0, Insert: "oh ", Version: 2}},
+			},
+		},
+	}}
+
+	h := HTTPServer{
+		config:  DefaultHTTPServerConfig(),
+		locator: playbackLocator{recording: recording},
+		logger:  logger,
+		stats:   stats,
+	}
+
+	req := httptest.NewRequest("GET", "/playback?token=reader&document_id=doc&version=2&speed=100", nil)
+	res := httptest.NewRecorder()
+	h.playbackHandler(res, req)
+
+	if ct := res.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Wrong content type: %v", ct)
+	}
+	exp := `data: {"response_type":"document","leap_document":{"id":"doc","content":"hello, world"},"version":2,"epoch":"first"}` + "\n\n" +
+		`data: {"response_type":"transforms","transforms":[{"position":12,"num_delete":0,"insert":"!","version":3}]}` + "\n\n" +
+		`data: {"response_type":"document","leap_document":{"id":"doc","content":"hello, world!"},"version":1,"epoch":"second"}` + "\n\n" +
+		`data: {"response_type":"transforms","transforms":[{"position":0,"num_delete":0,"insert":"oh ","version":2}]}` + "\n\n" +
+		`data: {"response_type":"end"}` + "\n\n"
+	if act := res.Body.String(); act != exp {
+		t.Errorf("Wrong playback stream:\n%v\n!=\n%v", act, exp)
+	}
+
+	// Versions are those of the binding of the epoch given
+	req = httptest.NewRequest("GET", "/playback?token=reader&document_id=doc&epoch=second&version=2&speed=100", nil)
+	res = httptest.NewRecorder()
+	h.playbackHandler(res, req)
+
+	exp = `data: {"response_type":"document","leap_document":{"id":"doc","content":"oh hello, world!"},"version":2,"epoch":"second"}` + "\n\n" +
+		`data: {"response_type":"end"}` + "\n\n"
+	if act := res.Body.String(); act != exp {
+		t.Errorf("Wrong playback stream:\n%v\n!=\n%v", act, exp)
+	}
+
+	for target, status := range map[string]int{
+		"/playback?token=reader&document_id=doc&epoch=third": http.StatusNotFound,
+		"/playback?token=writer&document_id=doc":             http.StatusForbidden,
+	} {
+		res = httptest.NewRecorder()
+		h.playbackHandler(res, httptest.NewRequest("GET", target, nil))
+		if res.Code != status {
+			t.Errorf("Wrong status for %v: %v != %v", target, res.Code, status)
+		}
+	}
+}
+
+func TestClientLibraryGenerated(t *testing.T) {
+	bindings, err := filepath.Glob(filepath.Join("..", "client", "leap-bind-*.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(bindings)
+
+	client := []byte{}
+	for _, path := range append([]string{filepath.Join("..", "client", "leapclient.js")}, bindings...) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client = append(client, data...)
+	}
+	if string(client) != jsClientSource {
+		t.Error("Embedded JS client is stale, run go generate ./net")
+	}
+	sum := sha256.Sum256(client)
+	if exp, act := hex.EncodeToString(sum[:])[:16], jsClientHash; exp != act {
+		t.Errorf("Wrong JS client hash: %v != %v", exp, act)
+	}
+}
+
+func TestClientLibraryHandler(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := DefaultHTTPServerConfig()
+	config.ClientLibrary.Path = "/leaps/js/"
+	h := HTTPServer{
+		config: config,
+		logger: logger,
+		stats:  stats,
+	}
+
+	request := func(target, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		if len(etag) > 0 {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res := httptest.NewRecorder()
+		h.clientLibraryHandler(res, req)
+		return res
+	}
+
+	hashed := "/leaps/js/leaps-" + jsClientHash + ".js"
+
+	res := request(hashed, "")
+	if res.Code != http.StatusOK || res.Body.String() != jsClientSource {
+		t.Errorf("Wrong response for client: %v", res.Code)
+	}
+	if exp, act := "public, max-age=31536000, immutable", res.Header().Get("Cache-Control"); exp != act {
+		t.Errorf("Wrong cache control: %v != %v", exp, act)
+	}
+	if res = request(hashed, `"`+jsClientHash+`"`); res.Code != http.StatusNotModified {
+		t.Errorf("Wrong status for matching etag: %v", res.Code)
+	}
+
+	for _, target := range []string{"/leaps/js/leaps.js", "/leaps/js/leaps-0123456789abcdef.js"} {
+		res = request(target, "")
+		if res.Code != http.StatusFound || res.Header().Get("Location") != hashed {
+			t.Errorf("Wrong redirect for %v: %v %v", target, res.Code, res.Header().Get("Location"))
+		}
+		if exp, act := "no-cache", res.Header().Get("Cache-Control"); exp != act {
+			t.Errorf("Wrong cache control for %v: %v != %v", target, exp, act)
+		}
+	}
+
+	res = request("/leaps/js/manifest.json", "")
+	if exp, act := `{"leaps.js":"leaps-`+jsClientHash+`.js"}`, res.Body.String(); exp != act {
+		t.Errorf("Wrong manifest: %v != %v", exp, act)
+	}
+
+	if res = request("/leaps/js/other.js", ""); res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for unknown file: %v", res.Code)
+	}
+}
+
+func TestCapabilitiesHello(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	locator := &fakeCapabilityLocator{capabilities: lib.Capabilities{
+		DocumentTypes: []string{"text"}, MaxDocumentSize: 1000, HistoryLength: 10, Presence: true,
+	}}
+	config := DefaultHTTPServerConfig()
+	config.Extensions = []string{ExtensionPresence}
+
+	h := HTTPServer{
+		config:  config,
+		locator: locator,
+		logger:  logger,
+		stats:   stats,
+	}
+	server := httptest.NewServer(websocket.Handler(h.websocketHandler))
+	defer server.Close()
+
+	dial := func() *websocket.Conn {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ws
+	}
+
+	// Without advertising the first message received is the response to init
+	ws := dial()
+	websocket.JSON.Send(ws, LeapClientMessage{Command: "nope"})
+	var first LeapServerMessage
+	if err := websocket.JSON.Receive(ws, &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Type != "error" {
+		t.Errorf("Wrong first message: %v", first)
+	}
+	ws.Close()
+
+	h.config.AdvertiseCapabilities = true
+	ws = dial()
+	defer ws.Close()
+
+	var hello LeapServerMessage
+	if err := websocket.JSON.Receive(ws, &hello); err != nil {
+		t.Fatal(err)
+	}
+	if hello.Type != "hello" || hello.Capabilities == nil {
+		t.Fatalf("Wrong first message: %v", hello)
+	}
+	exp := ServerCapabilities{
+		Capabilities:   locator.capabilities,
+		Commands:       []string{"create", "find", "read"},
+		Extensions:     []string{ExtensionPresence},
+		MaxMessageSize: websocket.DefaultMaxPayloadBytes,
+	}
+	if !reflect.DeepEqual(exp, *hello.Capabilities) {
+		t.Errorf("Wrong capabilities: %v != %v", exp, *hello.Capabilities)
+	}
+}
+
+func TestSSLConfigAutocert(t *testing.T) {
+	config := NewSSLConfig()
+	config.Enabled = true
+	if _, _, err := config.serverTLS(); err != ErrInvalidSSLConfig {
+		t.Errorf("Wrong error without certificate: %v", err)
+	}
+
+	config.Autocert.Enabled = true
+	if _, _, err := config.serverTLS(); err != ErrInvalidAutocertConfig {
+		t.Errorf("Wrong error without domains: %v", err)
+	}
+
+	config.Autocert.Domains = []string{"leaps.example.com"}
+	config.Autocert.CacheDir = t.TempDir()
+	tlsConfig, challengeHandler, err := config.serverTLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig.GetCertificate == nil || challengeHandler == nil {
+		t.Fatal("Autocert did not provide certificates and challenges")
+	}
+	alpn := false
+	for _, proto := range tlsConfig.NextProtos {
+		alpn = alpn || proto == "acme-tls/1"
+	}
+	if !alpn {
+		t.Errorf("TLS-ALPN challenges not supported: %v", tlsConfig.NextProtos)
+	}
+
+	rec := httptest.NewRecorder()
+	challengeHandler.ServeHTTP(rec, httptest.NewRequest("GET", "http://leaps.example.com/editor", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://leaps.example.com/editor" {
+		t.Errorf("Plain HTTP not redirected: %v %v", rec.Code, rec.Header().Get("Location"))
+	}
+}
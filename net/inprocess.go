@@ -0,0 +1,182 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the InProcessListener type.
+var (
+	ErrListenerClosed  = errors.New("listener closed")
+	ErrTransportClosed = errors.New("transport closed")
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+InProcessListener - A TransportListener for clients within the same process, such as bots and
+importers of an application that embeds leaps. Serve it with HTTPServer.ServeTransport and connect
+clients with Dial, after which they speak the same protocol as websocket clients without touching
+the network. Messages are copied through their JSON encoding, so that neither side shares memory
+with the other.
+*/
+type InProcessListener struct {
+	acceptChan chan Transport
+	closeChan  chan struct{}
+	closeOnce  sync.Once
+}
+
+/*
+NewInProcessListener - Create a new InProcessListener.
+*/
+func NewInProcessListener() *InProcessListener {
+	return &InProcessListener{
+		acceptChan: make(chan Transport),
+		closeChan:  make(chan struct{}),
+	}
+}
+
+/*
+Accept - Block until a client dials the listener and return the server end of its connection.
+*/
+func (l *InProcessListener) Accept() (Transport, error) {
+	select {
+	case t := <-l.acceptChan:
+		return t, nil
+	case <-l.closeChan:
+		return nil, ErrListenerClosed
+	}
+}
+
+/*
+Dial - Connect a client to the listener, blocking until the connection is accepted, and return the
+client end of the connection.
+*/
+func (l *InProcessListener) Dial() (Transport, error) {
+	client, server := newInProcessPipe()
+	select {
+	case l.acceptChan <- server:
+		return client, nil
+	case <-l.closeChan:
+		return nil, ErrListenerClosed
+	}
+}
+
+/*
+Close - Stop accepting clients, connections already accepted remain open.
+*/
+func (l *InProcessListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+	})
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+inProcessTransport - One end of an in process connection. Closing either end closes both, although
+messages sent before then can still be received.
+*/
+type inProcessTransport struct {
+	in        <-chan []byte
+	out       chan<- []byte
+	closeChan chan struct{}
+	closeOnce *sync.Once
+}
+
+/*
+newInProcessPipe - Returns both ends of a fresh in process connection.
+*/
+func newInProcessPipe() (*inProcessTransport, *inProcessTransport) {
+	aToB, bToA := make(chan []byte, 16), make(chan []byte, 16)
+	closeChan, closeOnce := make(chan struct{}), &sync.Once{}
+	return &inProcessTransport{in: bToA, out: aToB, closeChan: closeChan, closeOnce: closeOnce},
+		&inProcessTransport{in: aToB, out: bToA, closeChan: closeChan, closeOnce: closeOnce}
+}
+
+/*
+Send - Send the JSON encoding of a message to the other end, blocking while its buffer is full.
+*/
+func (t *inProcessTransport) Send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-t.closeChan:
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case t.out <- data:
+		return nil
+	case <-t.closeChan:
+		return ErrTransportClosed
+	}
+}
+
+/*
+Receive - Block until the other end sends a message and decode it into msg, returning io.EOF once
+the connection is closed and every message sent before has been received.
+*/
+func (t *inProcessTransport) Receive(msg interface{}) error {
+	select {
+	case data := <-t.in:
+		return json.Unmarshal(data, msg)
+	default:
+	}
+	select {
+	case data := <-t.in:
+		return json.Unmarshal(data, msg)
+	case <-t.closeChan:
+		select {
+		case data := <-t.in:
+			return json.Unmarshal(data, msg)
+		default:
+		}
+		return io.EOF
+	}
+}
+
+/*
+Close - Close the connection for both ends.
+*/
+func (t *inProcessTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closeChan)
+	})
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
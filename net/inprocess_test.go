@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"io"
+	"testing"
+)
+
+func TestInProcessTransport(t *testing.T) {
+	listener := NewInProcessListener()
+
+	acceptedChan := make(chan Transport)
+	go func() {
+		server, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+		}
+		acceptedChan <- server
+	}()
+
+	client, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-acceptedChan
+
+	if err = client.Send(LeapClientMessage{Command: "create", Token: "foo"}); err != nil {
+		t.Fatal(err)
+	}
+	var clientMsg LeapClientMessage
+	if err = server.Receive(&clientMsg); err != nil || clientMsg.Command != "create" || clientMsg.Token != "foo" {
+		t.Errorf("Wrong message received: %v, %v", clientMsg, err)
+	}
+
+	// Messages sent before closing must still arrive.
+	server.Send(LeapServerMessage{Type: "error", Code: MessageInitFailed})
+	server.Close()
+
+	var serverMsg LeapServerMessage
+	if err = client.Receive(&serverMsg); err != nil || serverMsg.Type != "error" || serverMsg.Code != MessageInitFailed {
+		t.Errorf("Wrong message received: %v, %v", serverMsg, err)
+	}
+	if err = client.Receive(&serverMsg); err != io.EOF {
+		t.Errorf("Wrong error after close: %v", err)
+	}
+	if err = client.Send(LeapClientMessage{Command: "ping"}); err != ErrTransportClosed {
+		t.Errorf("Wrong error sending after close: %v", err)
+	}
+
+	listener.Close()
+	if _, err = listener.Dial(); err != ErrListenerClosed {
+		t.Errorf("Wrong error dialing closed listener: %v", err)
+	}
+	if _, err = listener.Accept(); err != ErrListenerClosed {
+		t.Errorf("Wrong error accepting from closed listener: %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+aliasListItem - An alias and the document it points to, as an item of a list endpoint.
+*/
+type aliasListItem struct {
+	Alias      string `json:"alias"`
+	DocumentID string `json:"document_id"`
+}
+
+func (a aliasListItem) ListKey() string { return a.Alias }
+
+func (a aliasListItem) ListField(name string) (string, bool) {
+	switch name {
+	case "alias":
+		return a.Alias, true
+	case "document_id":
+		return a.DocumentID, true
+	}
+	return "", false
+}
+
+/*
+registerAliasesEndpoint - Registers the REST endpoint for managing the vanity aliases of documents
+if our admin supports it and a documents token is configured, which requests must carry. A GET
+without an alias lists the aliases, with the pagination, filtering and sorting of ListQuery over the
+fields alias and document_id.
+*/
+func (i *InternalServer) registerAliasesEndpoint() {
+	admin, ok := i.admin.(AliasAdmin)
+	if !ok || len(i.config.DocumentsToken) == 0 {
+		return
+	}
+
+	prefix := path.Join(i.config.Path, "/aliases")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if i.rejectUnauthorised(w, r, "aliases") {
+			return
+		}
+
+		alias := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if len(alias) == 0 && r.Method == "GET" {
+			aliases := admin.ListAliases()
+			items := make([]ListItem, 0, len(aliases))
+			for alias, id := range aliases {
+				items = append(items, aliasListItem{Alias: alias, DocumentID: id})
+			}
+			i.writeListPage(w, r, "aliases", []string{"alias", "document_id"}, items)
+			return
+		}
+		if len(alias) == 0 {
+			i.stats.Incr("http_admin.aliases.error", 1)
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case "PUT":
+			var item aliasListItem
+			body, err := ioutil.ReadAll(r.Body)
+			if err == nil {
+				err = json.Unmarshal(body, &item)
+			}
+			if err != nil || len(item.DocumentID) == 0 {
+				i.stats.Incr("http_admin.aliases.error", 1)
+				http.Error(w, "Bad data", http.StatusBadRequest)
+				return
+			}
+			if err = admin.SetAlias(alias, item.DocumentID); err != nil {
+				i.stats.Incr("http_admin.aliases.error", 1)
+				i.logger.Warnf("/aliases: Failed to set %v: %v\n", alias, err)
+				if errors.Is(err, lib.ErrIDPolicy) || err == ErrAliasRoute {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else if err == lib.ErrAliasTaken {
+					http.Error(w, "Alias is the ID of a document", http.StatusConflict)
+				} else if err == store.ErrDocumentNotExist || err == ErrNoRoute {
+					http.Error(w, "Document not found", http.StatusNotFound)
+				} else {
+					http.Error(w, "Error writing alias", http.StatusInternalServerError)
+				}
+				return
+			}
+			i.logger.Infof("/aliases: Pointed %v at document %v\n", alias, item.DocumentID)
+			resultBytes, _ := json.Marshal(aliasListItem{Alias: alias, DocumentID: item.DocumentID})
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		case "DELETE":
+			if err := admin.RemoveAlias(alias); err != nil {
+				i.stats.Incr("http_admin.aliases.error", 1)
+				i.logger.Warnf("/aliases: Failed to remove %v: %v\n", alias, err)
+				if err == lib.ErrAliasNotExist || err == ErrNoRoute {
+					http.Error(w, "Alias not found", http.StatusNotFound)
+				} else {
+					http.Error(w, "Error removing alias", http.StatusInternalServerError)
+				}
+				return
+			}
+			i.logger.Infof("/aliases: Removed %v\n", alias)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			i.stats.Incr("http_admin.aliases.error", 1)
+			i.logger.Warnf("/aliases: Wrong method %v\n", r.Method)
+			http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+			return
+		}
+		i.stats.Incr("http_admin.aliases.success", 1)
+	}
+
+	// Register /aliases endpoint for listing aliases, and /aliases/<alias> for the rest
+	i.Register(
+		"/aliases",
+		`<GET|PUT|DELETE> List aliases, or point an alias at a document with a PUT to /aliases/<alias> {"document_id":"<id>"} and remove it with a DELETE, clients may join a document by its alias {"items":[{"alias":"<alias>","document_id":"<id>"}],"total":1,"next_cursor":"<cursor>"}`,
+		handler,
+	)
+	i.mux.HandleFunc(prefix+"/", i.limits.WrapHandlerFunc(handler))
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,241 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+registerBindersEndpoint - Registers the binder inspection endpoint if our admin supports it and a
+documents token is configured, which requests must carry as with the documents endpoint.
+*/
+func (i *InternalServer) registerBindersEndpoint() {
+	inspector, ok := i.admin.(BinderInspector)
+	if !ok || len(i.config.DocumentsToken) == 0 {
+		return
+	}
+
+	// Register /binders endpoint for inspecting the live state of open documents
+	i.Register(
+		"/binders",
+		`<GET> Get a page of the live state of open documents, or of one with ?doc_id=<id> {"items":[{"id":"<id>","version":0,"flushed_version":0,"last_flush":"<time>","subscribers":0,"memory_estimate_bytes":0}],"total":1}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if i.rejectUnauthorised(w, r, "binders") {
+				return
+			}
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.binders.error", 1)
+				i.logger.Warnf("/binders: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			timeout := time.Second * time.Duration(i.config.RequestTimeout)
+
+			docID := r.URL.Query().Get("doc_id")
+			if len(docID) == 0 {
+				infos, err := inspector.InspectBinders(timeout)
+				if err != nil {
+					i.stats.Incr("http_admin.binders.error", 1)
+					i.logger.Errorf("/binders: %v\n", err)
+					http.Error(w, err.Error(), binderErrorStatus(err))
+					return
+				}
+				items := make([]ListItem, len(infos))
+				for j, info := range infos {
+					items[j] = binderListItem(info)
+				}
+				i.writeListPage(w, r, "binders", []string{"id", "degraded", "draining"}, items)
+				return
+			}
+
+			info, err := inspector.InspectBinder(docID, timeout)
+			if err != nil {
+				i.stats.Incr("http_admin.binders.error", 1)
+				i.logger.Errorf("/binders: %v\n", err)
+				http.Error(w, err.Error(), binderErrorStatus(err))
+				return
+			}
+
+			resultBytes, err := json.Marshal(info)
+			if err != nil {
+				i.stats.Incr("http_admin.binders.error", 1)
+				i.logger.Errorf("/binders: %v\n", err)
+				http.Error(w, "Error encoding binders", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.binders.success", 1)
+
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		})
+}
+
+/*
+binderListItem - A binder summary as an item of a list endpoint.
+*/
+type binderListItem lib.BinderInfo
+
+func (b binderListItem) ListKey() string { return b.ID }
+
+func (b binderListItem) ListField(name string) (string, bool) {
+	switch name {
+	case "id":
+		return b.ID, true
+	case "degraded":
+		return strconv.FormatBool(b.Degraded), true
+	case "draining":
+		return strconv.FormatBool(b.Draining), true
+	}
+	return "", false
+}
+
+/*
+registerBinderControlEndpoints - Registers the endpoints for flushing and closing documents if our
+admin supports them and a documents token is configured, which requests must carry.
+*/
+func (i *InternalServer) registerBinderControlEndpoints() {
+	controller, ok := i.admin.(BinderController)
+	if !ok || len(i.config.DocumentsToken) == 0 {
+		return
+	}
+
+	// Register /flush_document endpoint for writing the pending changes of a document to its store
+	i.Register(
+		"/flush_document",
+		`<POST> Flush the pending changes of an open document to its store {"doc_id":"<id>"}, returns {"doc_id":"<id>","version":0}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if i.rejectUnauthorised(w, r, "flush_document") {
+				return
+			}
+			if r.Method != "POST" {
+				i.stats.Incr("http_admin.flush_document.error", 1)
+				i.logger.Warnf("/flush_document: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			dataObj := struct {
+				DocID string `json:"doc_id"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&dataObj); err != nil {
+				i.stats.Incr("http_admin.flush_document.error", 1)
+				i.logger.Errorf("/flush_document: %v\n", err)
+				http.Error(w, "Bad data", http.StatusBadRequest)
+				return
+			}
+
+			version, err := controller.FlushDocument(
+				dataObj.DocID,
+				time.Second*time.Duration(i.config.RequestTimeout),
+			)
+			if err != nil {
+				i.stats.Incr("http_admin.flush_document.error", 1)
+				i.logger.Errorf("/flush_document: %v\n", err)
+				http.Error(w, err.Error(), binderErrorStatus(err))
+				return
+			}
+
+			resultBytes, err := json.Marshal(struct {
+				DocID   string `json:"doc_id"`
+				Version int    `json:"version"`
+			}{dataObj.DocID, version})
+			if err != nil {
+				i.stats.Incr("http_admin.flush_document.error", 1)
+				i.logger.Errorf("/flush_document: %v\n", err)
+				http.Error(w, "Error encoding result", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.flush_document.success", 1)
+			i.logger.Infof("/flush_document: Flushed %v at version %v\n", dataObj.DocID, version)
+
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		})
+
+	// Register /close_document endpoint for closing a document and disconnecting its clients
+	i.Register(
+		"/close_document",
+		`<POST> Flush and close an open document, disconnecting its clients {"doc_id":"<id>"}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if i.rejectUnauthorised(w, r, "close_document") {
+				return
+			}
+			if r.Method != "POST" {
+				i.stats.Incr("http_admin.close_document.error", 1)
+				i.logger.Warnf("/close_document: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			dataObj := struct {
+				DocID string `json:"doc_id"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&dataObj); err != nil {
+				i.stats.Incr("http_admin.close_document.error", 1)
+				i.logger.Errorf("/close_document: %v\n", err)
+				http.Error(w, "Bad data", http.StatusBadRequest)
+				return
+			}
+
+			if err := controller.CloseDocument(dataObj.DocID); err != nil {
+				i.stats.Incr("http_admin.close_document.error", 1)
+				i.logger.Errorf("/close_document: %v\n", err)
+				http.Error(w, err.Error(), binderErrorStatus(err))
+				return
+			}
+
+			i.stats.Incr("http_admin.close_document.success", 1)
+			i.logger.Infof("/close_document: Closed %v\n", dataObj.DocID)
+
+			fmt.Fprintf(w, "Success")
+		})
+}
+
+/*
+binderErrorStatus - Returns the HTTP status that best describes an error from a binder operation.
+*/
+func binderErrorStatus(err error) int {
+	switch err {
+	case lib.ErrBinderNotFound, ErrNoRoute:
+		return http.StatusNotFound
+	case lib.ErrTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
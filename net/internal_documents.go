@@ -0,0 +1,270 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+registerDocumentsEndpoint - Registers the REST endpoint for reading, creating and deleting documents
+if our admin supports it and a documents token is configured. Requests must carry the token as an
+'Authorization: Bearer <token>' header. A PUT with '?merge=true' merges into an existing document
+rather than overwriting it, when our admin supports it. A GET without an ID lists the documents
+when our admin supports it, with the pagination, filtering and sorting of ListQuery over the fields
+id and open.
+*/
+func (i *InternalServer) registerDocumentsEndpoint() {
+	admin, ok := i.admin.(DocumentAdmin)
+	if !ok || len(i.config.DocumentsToken) == 0 {
+		return
+	}
+
+	prefix := path.Join(i.config.Path, "/documents")
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if i.rejectUnauthorised(w, r, "documents") {
+			return
+		}
+
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+		if lister, ok := admin.(DocumentLister); ok && len(id) == 0 && r.Method == "GET" {
+			i.listDocuments(w, r, lister)
+			return
+		}
+
+		// Only new documents are posted without an ID
+		if (len(id) == 0) != (r.Method == "POST") {
+			i.stats.Incr("http_admin.documents.error", 1)
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case "GET":
+			doc, err := i.readDocument(admin, id)
+			if err != nil {
+				i.stats.Incr("http_admin.documents.error", 1)
+				i.logger.Warnf("/documents: Failed to read %v: %v\n", id, err)
+				http.Error(w, "Document not found", http.StatusNotFound)
+				return
+			}
+			i.writeDocument(w, http.StatusOK, doc)
+		case "PUT", "POST":
+			doc, err := readDocumentBody(r)
+			if err != nil {
+				i.stats.Incr("http_admin.documents.error", 1)
+				i.logger.Errorf("/documents: %v\n", err)
+				http.Error(w, "Bad data", http.StatusBadRequest)
+				return
+			}
+			status := http.StatusOK
+			if r.Method == "POST" {
+				newDoc, _ := store.NewDocument(doc.Content)
+				doc.ID, status = newDoc.ID, http.StatusCreated
+			} else {
+				doc.ID = id
+			}
+			if merger, ok := admin.(DocumentMerger); ok && r.Method == "PUT" && r.URL.Query().Get("merge") == "true" {
+				doc, err = merger.MergeDocument(doc, time.Second*time.Duration(i.config.RequestTimeout))
+			} else {
+				err = admin.PutDocument(doc)
+			}
+			if err != nil {
+				i.stats.Incr("http_admin.documents.error", 1)
+				i.logger.Errorf("/documents: Failed to write %v: %v\n", doc.ID, err)
+				if err == lib.ErrDocumentOpen {
+					http.Error(w, "Document is open", http.StatusConflict)
+				} else if err == ErrNoRoute {
+					http.Error(w, "Document not found", http.StatusNotFound)
+				} else if err == lib.ErrMergeNotSupported {
+					http.Error(w, "Document cannot be merged", http.StatusConflict)
+				} else if errors.Is(err, lib.ErrIDPolicy) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else if errors.Is(err, lib.ErrRateLimited) {
+					// The merge exceeded the transform limits of its binder, which tell us when to retry.
+					retry := time.Second
+					var limited *lib.RateLimitedError
+					if errors.As(err, &limited) && limited.RetryAfter > retry {
+						retry = limited.RetryAfter
+					}
+					w.Header().Set("Retry-After", headerSeconds(retry))
+					http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				} else {
+					http.Error(w, "Error writing document", http.StatusInternalServerError)
+				}
+				return
+			}
+			i.renders.Invalidate(doc.ID)
+			i.logger.Infof("/documents: Wrote document %v\n", doc.ID)
+			i.writeDocument(w, status, doc)
+		case "DELETE":
+			if err := admin.DeleteDocument(id); err != nil {
+				i.stats.Incr("http_admin.documents.error", 1)
+				i.logger.Errorf("/documents: Failed to delete %v: %v\n", id, err)
+				if err == lib.ErrStoreNotDeletable {
+					http.Error(w, "Documents cannot be deleted", http.StatusNotImplemented)
+				} else if err == ErrNoRoute {
+					http.Error(w, "Document not found", http.StatusNotFound)
+				} else {
+					http.Error(w, "Error deleting document", http.StatusInternalServerError)
+				}
+				return
+			}
+			i.renders.Invalidate(id)
+			i.logger.Infof("/documents: Deleted document %v\n", id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			i.stats.Incr("http_admin.documents.error", 1)
+			i.logger.Warnf("/documents: Wrong method %v\n", r.Method)
+			http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+			return
+		}
+		i.stats.Incr("http_admin.documents.success", 1)
+	}
+
+	// Register /documents endpoint for creating documents, and /documents/<id> for the rest
+	i.Register(
+		"/documents",
+		`<GET|PUT|POST|DELETE> Read, write, create or delete documents at /documents/<id>, PUT with ?merge=true to merge {"id":"<id>","content":"<content>","type":"<type>"}, GET /documents to list them {"items":[{"id":"<id>","open":true}],"total":1,"next_cursor":"<cursor>"}`,
+		handler,
+	)
+	i.mux.HandleFunc(prefix+"/", i.limits.WrapHandlerFunc(handler))
+}
+
+/*
+documentListItem - A document summary as an item of a list endpoint.
+*/
+type documentListItem lib.DocumentSummary
+
+func (d documentListItem) ListKey() string { return d.ID }
+
+func (d documentListItem) ListField(name string) (string, bool) {
+	switch name {
+	case "id":
+		return d.ID, true
+	case "open":
+		return strconv.FormatBool(d.Open), true
+	}
+	return "", false
+}
+
+/*
+listDocuments - Respond with a page of the stored documents.
+*/
+func (i *InternalServer) listDocuments(w http.ResponseWriter, r *http.Request, lister DocumentLister) {
+	summaries, err := lister.ListDocuments()
+	if err != nil {
+		i.stats.Incr("http_admin.documents.error", 1)
+		i.logger.Errorf("/documents: Failed to list documents: %v\n", err)
+		if err == lib.ErrStoreNotListable {
+			http.Error(w, "Documents cannot be listed", http.StatusNotImplemented)
+		} else {
+			http.Error(w, "Error listing documents", http.StatusInternalServerError)
+		}
+		return
+	}
+	items := make([]ListItem, len(summaries))
+	for j, summary := range summaries {
+		items[j] = documentListItem(summary)
+	}
+	i.writeListPage(w, r, "documents", []string{"id", "open"}, items)
+}
+
+/*
+readDocument - Read a document for a GET request, live if configured and supported by our admin.
+*/
+func (i *InternalServer) readDocument(admin DocumentAdmin, id string) (store.Document, error) {
+	if live, ok := admin.(LiveDocumentReader); ok && i.config.ReadYourWrites {
+		return live.GetLiveDocument(id, time.Second*time.Duration(i.config.RequestTimeout))
+	}
+	return admin.GetDocument(id)
+}
+
+/*
+authoriseDocumentsRequest - Checks that a request carries the configured documents token.
+*/
+func (i *InternalServer) authoriseDocumentsRequest(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(i.config.DocumentsToken)) == 1
+}
+
+/*
+rejectUnauthorised - Responds with a 401 and returns true when a request to an endpoint does not
+carry the configured documents token.
+*/
+func (i *InternalServer) rejectUnauthorised(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	if i.authoriseDocumentsRequest(r) {
+		return false
+	}
+	i.stats.Incr("http_admin."+endpoint+".rejected", 1)
+	i.logger.Warnf("/%v: Rejected request with a bad token\n", endpoint)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return true
+}
+
+/*
+writeDocument - Write a document as a JSON response.
+*/
+func (i *InternalServer) writeDocument(w http.ResponseWriter, status int, doc store.Document) {
+	resultBytes, err := json.Marshal(doc)
+	if err != nil {
+		i.stats.Incr("http_admin.documents.error", 1)
+		i.logger.Errorf("/documents: %v\n", err)
+		http.Error(w, "Error encoding document", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resultBytes)
+}
+
+/*
+readDocumentBody - Parse the JSON document of a request body, the ID of the body is ignored.
+*/
+func readDocumentBody(r *http.Request) (store.Document, error) {
+	var doc store.Document
+
+	bodyBytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return doc, err
+	}
+	err = json.Unmarshal(bodyBytes, &doc)
+	return doc, err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
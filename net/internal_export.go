@@ -0,0 +1,187 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/russross/blackfriday"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for document exports.
+var (
+	ErrUnknownExportFormat = errors.New("unknown export format, expected text, html or json")
+	ErrExportNotRenderable = errors.New("only text documents can be rendered as html")
+)
+
+/*
+Markdown is rendered without any raw HTML or unsafe links of the document, as exports may well be
+served to browsers.
+*/
+const (
+	exportHTMLFlags = blackfriday.HTML_USE_XHTML |
+		blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_DASHES |
+		blackfriday.HTML_SKIP_HTML |
+		blackfriday.HTML_SKIP_STYLE |
+		blackfriday.HTML_SAFELINK |
+		blackfriday.HTML_NOFOLLOW_LINKS |
+		blackfriday.HTML_NOREFERRER_LINKS |
+		blackfriday.HTML_COMPLETE_PAGE
+
+	exportMarkdownExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_TABLES |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS |
+		blackfriday.EXTENSION_HEADER_IDS |
+		blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
+		blackfriday.EXTENSION_DEFINITION_LISTS
+)
+
+/*
+renderExport - Render an export of a document to a format, being text for the plain content, html
+for the content rendered as Markdown, or json for the content along with its metadata and version.
+*/
+func renderExport(export lib.DocumentExport, format string) (Rendered, error) {
+	switch format {
+	case "text":
+		return Rendered{
+			Data:        []byte(export.Document.Content),
+			ContentType: "text/plain; charset=utf-8",
+		}, nil
+	case "html":
+		if docType := export.Document.Type; len(docType) > 0 && docType != "text" {
+			return Rendered{}, ErrExportNotRenderable
+		}
+		renderer := blackfriday.HtmlRenderer(exportHTMLFlags, export.Document.ID, "")
+		return Rendered{
+			Data:        blackfriday.Markdown([]byte(export.Document.Content), renderer, exportMarkdownExtensions),
+			ContentType: "text/html; charset=utf-8",
+		}, nil
+	case "json":
+		data, err := json.Marshal(export)
+		if err != nil {
+			return Rendered{}, err
+		}
+		return Rendered{Data: data, ContentType: "application/json"}, nil
+	}
+	return Rendered{}, ErrUnknownExportFormat
+}
+
+/*
+registerExportEndpoint - Registers the document export endpoint if our admin supports it and a
+documents token is configured, which requests must carry. Text and html renders of open documents
+are held in the render cache, those of documents that are not open are rendered for each request as
+the store may be changed by others. JSON exports carry the metadata of the document, which changes
+without the version, and so are cached by the digest of their metadata as well.
+*/
+func (i *InternalServer) registerExportEndpoint() {
+	exporter, ok := i.admin.(DocumentExporter)
+	if !ok || len(i.config.DocumentsToken) == 0 {
+		return
+	}
+
+	// Register /export endpoint for reading documents without speaking the OT protocol
+	i.Register(
+		"/export",
+		`<GET> Export the current content of a document with ?doc_id=<id>&format=<text|html|json>, html renders the content as Markdown and json is the default {"document":{"id":"<id>","content":"<content>"},"version":0,"open":true}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if i.rejectUnauthorised(w, r, "export") {
+				return
+			}
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.export.error", 1)
+				i.logger.Warnf("/export: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			docID, format := r.URL.Query().Get("doc_id"), r.URL.Query().Get("format")
+			if len(format) == 0 {
+				format = "json"
+			}
+
+			export, err := exporter.ExportDocument(docID, time.Second*time.Duration(i.config.RequestTimeout))
+			if err != nil {
+				i.stats.Incr("http_admin.export.error", 1)
+				i.logger.Warnf("/export: Failed to read %v: %v\n", docID, err)
+				switch err {
+				case store.ErrDocumentNotExist, ErrNoRoute:
+					http.Error(w, "Document not found", http.StatusNotFound)
+				case lib.ErrTimeout:
+					http.Error(w, err.Error(), http.StatusGatewayTimeout)
+				default:
+					http.Error(w, "Error reading document", http.StatusInternalServerError)
+				}
+				return
+			}
+
+			render := func() (Rendered, error) {
+				return renderExport(export, format)
+			}
+			var rendered Rendered
+			if export.Open {
+				key := RenderKey{
+					ID:      docID,
+					Format:  format,
+					Epoch:   export.Epoch,
+					Version: export.Version,
+				}
+				if format == "json" {
+					key.Metadata = MetadataDigest(export.Document.Metadata)
+				}
+				rendered, err = i.renders.Render(key, render)
+			} else {
+				rendered, err = render()
+			}
+			if err != nil {
+				i.stats.Incr("http_admin.export.error", 1)
+				if err == ErrUnknownExportFormat || err == ErrExportNotRenderable {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else {
+					i.logger.Errorf("/export: Failed to render %v: %v\n", docID, err)
+					http.Error(w, "Error rendering document", http.StatusInternalServerError)
+				}
+				return
+			}
+
+			i.stats.Incr("http_admin.export.success", 1)
+
+			w.Header().Add("Content-Type", rendered.ContentType)
+			w.Write(rendered.Data)
+		})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
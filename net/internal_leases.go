@@ -0,0 +1,159 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+registerLeaseEndpoint - Registers the lease breaking endpoint if our admin supports it. Breaking a
+lease takes two steps, a GET to inspect the lease and a POST that confirms the break with the node
+and fingerprint returned by the inspection.
+*/
+func (i *InternalServer) registerLeaseEndpoint() {
+	breaker, ok := i.admin.(LeaseBreaker)
+	if !ok {
+		return
+	}
+
+	// Register /break_lease endpoint for recovering documents from nodes that failed holding them
+	i.Register(
+		"/break_lease",
+		`<GET|POST> Inspect the lease over a document with ?doc_id=<id>, then break it if stale {"doc_id":"<id>","node":"<node>","fingerprint":"<fingerprint>"}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "GET":
+				info, err := breaker.InspectLease(r.URL.Query().Get("doc_id"))
+				if err != nil {
+					i.stats.Incr("http_admin.break_lease.error", 1)
+					i.logger.Errorf("/break_lease: %v\n", err)
+					http.Error(w, err.Error(), leaseErrorStatus(err))
+					return
+				}
+				resultBytes, err := json.Marshal(info)
+				if err != nil {
+					i.stats.Incr("http_admin.break_lease.error", 1)
+					i.logger.Errorf("/break_lease: %v\n", err)
+					http.Error(w, "Error encoding lease", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Add("Content-Type", "application/json")
+				w.Write(resultBytes)
+			case "POST":
+				dataObj := struct {
+					DocID       string `json:"doc_id"`
+					Node        string `json:"node"`
+					Fingerprint string `json:"fingerprint"`
+				}{}
+				if err := json.NewDecoder(r.Body).Decode(&dataObj); err != nil {
+					i.stats.Incr("http_admin.break_lease.error", 1)
+					i.logger.Errorf("/break_lease: %v\n", err)
+					http.Error(w, "Bad data", http.StatusBadRequest)
+					return
+				}
+				if err := breaker.BreakLease(dataObj.DocID, dataObj.Node, dataObj.Fingerprint); err != nil {
+					i.stats.Incr("http_admin.break_lease.error", 1)
+					i.logger.Errorf("/break_lease: %v\n", err)
+					http.Error(w, err.Error(), leaseErrorStatus(err))
+					return
+				}
+				i.stats.Incr("http_admin.break_lease.success", 1)
+				i.logger.Infof("/break_lease: Broke lease of node %v over %v\n", dataObj.Node, dataObj.DocID)
+
+				fmt.Fprintf(w, "Success")
+			default:
+				i.stats.Incr("http_admin.break_lease.error", 1)
+				i.logger.Warnf("/break_lease: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+			}
+		})
+}
+
+/*
+registerClusterEndpoint - Registers the cluster status endpoint if our admin supports it.
+*/
+func (i *InternalServer) registerClusterEndpoint() {
+	reporter, ok := i.admin.(ClusterReporter)
+	if !ok {
+		return
+	}
+
+	// Register /cluster endpoint for watching the protocols of nodes during a rolling upgrade
+	i.Register(
+		"/cluster",
+		`<GET> Get the cluster protocol of this node and its peers {"node":"<node>","protocol":2,"mixed":false,"peers":[{"node":"<node>","protocol":2,"compatible":true,"last_seen":"<time>"}]}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.cluster.error", 1)
+				i.logger.Warnf("/cluster: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			status, err := reporter.GetClusterStatus()
+			if err != nil {
+				i.stats.Incr("http_admin.cluster.error", 1)
+				i.logger.Errorf("/cluster: %v\n", err)
+				http.Error(w, err.Error(), leaseErrorStatus(err))
+				return
+			}
+			resultBytes, err := json.Marshal(status)
+			if err != nil {
+				i.stats.Incr("http_admin.cluster.error", 1)
+				i.logger.Errorf("/cluster: %v\n", err)
+				http.Error(w, "Error encoding status", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.cluster.success", 1)
+
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		})
+}
+
+/*
+leaseErrorStatus - Returns the HTTP status for an error inspecting or breaking a lease.
+*/
+func leaseErrorStatus(err error) int {
+	switch err {
+	case lib.ErrClusterDisabled:
+		return http.StatusNotImplemented
+	case lib.ErrLeaseNotHeld:
+		return http.StatusNotFound
+	case lib.ErrLeaseChanged, lib.ErrLeaseHeldLocally:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
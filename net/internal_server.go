@@ -23,13 +23,16 @@ THE SOFTWARE.
 package net
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path"
 	"time"
 
+	"github.com/jeffail/leaps/lib"
 	"github.com/jeffail/util/log"
 	binpath "github.com/jeffail/util/path"
 )
@@ -38,15 +41,28 @@ import (
  */
 
 /*
-InternalServerConfig - Holds configuration options for the InternalServer.
+InternalServerConfig - Holds configuration options for the InternalServer. The documents, binders,
+flush_document, close_document and export endpoints are only served when DocumentsToken is set, and
+require requests to carry it as a bearer token. With ReadYourWrites a GET of an open document
+returns its live content rather than the copy last flushed to the store, so that a read following a
+write always observes it. The live content is read without flushing the document. The server keeps
+serving for ShutdownReportLinger seconds after draining, or until a second signal, so that the
+shutdown report can be fetched before the process exits.
 */
 type InternalServerConfig struct {
-	Path           string               `json:"path" yaml:"path"`
-	Address        string               `json:"address" yaml:"address"`
-	StaticFilePath string               `json:"www_dir" yaml:"www_dir"`
-	SSL            SSLConfig            `json:"ssl" yaml:"ssl"`
-	HTTPAuth       AuthMiddlewareConfig `json:"basic_auth" yaml:"basic_auth"`
-	RequestTimeout int                  `json:"request_timeout_s" yaml:"request_timeout_s"`
+	Path           string                    `json:"path" yaml:"path"`
+	Address        string                    `json:"address" yaml:"address"`
+	StaticFilePath string                    `json:"www_dir" yaml:"www_dir"`
+	SSL            SSLConfig                 `json:"ssl" yaml:"ssl"`
+	HTTPAuth       AuthMiddlewareConfig      `json:"basic_auth" yaml:"basic_auth"`
+	RateLimit      RateLimitMiddlewareConfig `json:"rate_limit" yaml:"rate_limit"`
+	RequestTimeout int                       `json:"request_timeout_s" yaml:"request_timeout_s"`
+	DocumentsToken string                    `json:"documents_token" yaml:"documents_token"`
+	ReadYourWrites bool                      `json:"read_your_writes" yaml:"read_your_writes"`
+	RenderCache    RenderCacheConfig         `json:"render_cache" yaml:"render_cache"`
+	Tracing        TraceConfig               `json:"tracing" yaml:"tracing"`
+
+	ShutdownReportLinger int64 `json:"shutdown_report_linger_s" yaml:"shutdown_report_linger_s"`
 }
 
 /*
@@ -60,7 +76,14 @@ func NewInternalServerConfig() InternalServerConfig {
 		StaticFilePath: "",
 		SSL:            NewSSLConfig(),
 		HTTPAuth:       NewAuthMiddlewareConfig(),
+		RateLimit:      NewRateLimitMiddlewareConfig(),
 		RequestTimeout: 10,
+		DocumentsToken: "",
+		ReadYourWrites: true,
+		RenderCache:    NewRenderCacheConfig(),
+		Tracing:        NewTraceConfig(),
+
+		ShutdownReportLinger: 0,
 	}
 }
 
@@ -76,9 +99,13 @@ type InternalServer struct {
 	logger       *log.Logger
 	stats        *log.Stats
 	auth         *AuthMiddleware
+	limits       *RateLimitMiddleware
 	mux          *http.ServeMux
 	apiEndpoints []struct{ endpoint, desc string }
 	admin        LeapAdmin
+
+	// Rendered exports of documents, nil when disabled
+	renders *RenderCache
 }
 
 /*
@@ -101,7 +128,9 @@ func NewInternalServer(
 		stats:  stats,
 		mux:    http.NewServeMux(),
 		auth:   auth,
+		limits: NewRateLimitMiddleware(config.RateLimit, logger, stats),
 	}
+	httpServer.renders = NewRenderCache(config.RenderCache, stats)
 
 	// Register handling for static files
 	if len(httpServer.config.StaticFilePath) > 0 {
@@ -112,10 +141,22 @@ func NewInternalServer(
 		if err := binpath.FromBinaryIfRelative(&httpServer.config.StaticFilePath); err != nil {
 			return nil, fmt.Errorf("relative path for static files could not be resolved: %v", err)
 		}
-		httpServer.mux.Handle(httpServer.config.Path,
+		httpServer.mux.Handle(httpServer.config.Path, httpServer.limits.WrapHandlerFunc( // Rate limit wrap
 			httpServer.auth.WrapHandler( // Auth wrap
 				http.StripPrefix(httpServer.config.Path, // File strip prefix wrap
-					http.FileServer(http.Dir(httpServer.config.StaticFilePath))))) // File serve handler
+					http.FileServer(http.Dir(httpServer.config.StaticFilePath)))))) // File serve handler
+	}
+
+	// Protocol traces are written to a sink of their own
+	if len(config.Tracing.SinkPath) > 0 {
+		if err := binpath.FromBinaryIfRelative(&config.Tracing.SinkPath); err != nil {
+			return nil, fmt.Errorf("relative path for trace sink could not be resolved: %v", err)
+		}
+		sink, err := os.OpenFile(config.Tracing.SinkPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("trace sink could not be opened: %v", err)
+		}
+		tracer.setSink(sink)
 	}
 
 	httpServer.registerEndpoints()
@@ -213,11 +254,291 @@ func (i *InternalServer) registerEndpoints() {
 			i.stats.Incr("http_admin.get_users.success", 1)
 			i.logger.Debugf("/get_users: sending users for %v documents\n", len(resultObj))
 
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		})
+
+	i.registerBanEndpoint()
+	i.registerBindersEndpoint()
+	i.registerBinderControlEndpoints()
+	i.registerAnnounceEndpoint()
+	i.registerLeaseEndpoint()
+	i.registerClusterEndpoint()
+	i.registerChaosEndpoints()
+	i.registerRecoveryEndpoint()
+	i.registerShutdownEndpoint()
+	i.registerLatencyEndpoint()
+	i.registerMetricsEndpoint()
+	i.registerTraceEndpoint()
+	i.registerDocumentsEndpoint()
+	i.registerAliasesEndpoint()
+	i.registerExportEndpoint()
+	i.registerSpecEndpoint()
+}
+
+/*
+registerBanEndpoint - Registers the user ban endpoint if our admin supports it.
+*/
+func (i *InternalServer) registerBanEndpoint() {
+	banner, ok := i.admin.(UserBanner)
+	if !ok {
+		return
+	}
+
+	// Register /ban_user endpoint for kicking users from documents and refusing their return
+	i.Register(
+		"/ban_user",
+		`<POST> Ban a user from a document for a duration, zero lifts the ban {"user_id":"<id>","doc_id":"<id>","duration_s":0}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				i.stats.Incr("http_admin.ban_user.error", 1)
+				i.logger.Warnf("/ban_user: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			dataObj := struct {
+				UserID   string `json:"user_id"`
+				DocID    string `json:"doc_id"`
+				Duration int64  `json:"duration_s"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&dataObj); err != nil {
+				i.stats.Incr("http_admin.ban_user.error", 1)
+				i.logger.Errorf("/ban_user: %v\n", err)
+				http.Error(w, "Bad data", http.StatusBadRequest)
+				return
+			}
+
+			if err := banner.BanUser(
+				dataObj.DocID,
+				dataObj.UserID,
+				time.Duration(dataObj.Duration)*time.Second,
+				time.Second*time.Duration(i.config.RequestTimeout),
+			); err != nil {
+				i.stats.Incr("http_admin.ban_user.error", 1)
+				i.logger.Errorf("/ban_user: %v\n", err)
+				http.Error(w, "Error banning user", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.ban_user.success", 1)
+			i.logger.Infof("/ban_user: Banned user %v from %v for %vs\n", dataObj.UserID, dataObj.DocID, dataObj.Duration)
+
+			fmt.Fprintf(w, "Success")
+		})
+}
+
+/*
+registerAnnounceEndpoint - Registers the announcement endpoint if our admin supports it.
+*/
+func (i *InternalServer) registerAnnounceEndpoint() {
+	announcer, ok := i.admin.(Announcer)
+	if !ok {
+		return
+	}
+
+	// Register /announce endpoint for broadcasting a message to every connected client
+	i.Register(
+		"/announce",
+		`<POST> Send a one-off announcement to every connected client, severity is info, warning or critical and expires_in_s is optional {"message":"<text>","severity":"warning","expires_in_s":600}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" {
+				i.stats.Incr("http_admin.announce.error", 1)
+				i.logger.Warnf("/announce: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			dataObj := struct {
+				Message   string `json:"message"`
+				Severity  string `json:"severity"`
+				ExpiresIn int64  `json:"expires_in_s"`
+			}{}
+			if err := json.NewDecoder(r.Body).Decode(&dataObj); err != nil {
+				i.stats.Incr("http_admin.announce.error", 1)
+				i.logger.Errorf("/announce: %v\n", err)
+				http.Error(w, "Bad data", http.StatusBadRequest)
+				return
+			}
+
+			announcement := lib.Announcement{
+				Message:  dataObj.Message,
+				Severity: dataObj.Severity,
+			}
+			if dataObj.ExpiresIn > 0 {
+				announcement.Expires = time.Now().Unix() + dataObj.ExpiresIn
+			}
+
+			err := announcer.Announce(announcement, time.Second*time.Duration(i.config.RequestTimeout))
+			switch err {
+			case nil:
+			case lib.ErrEmptyAnnouncement, lib.ErrInvalidSeverity, lib.ErrAnnouncementExpired:
+				i.stats.Incr("http_admin.announce.error", 1)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			default:
+				i.stats.Incr("http_admin.announce.error", 1)
+				i.logger.Errorf("/announce: %v\n", err)
+				http.Error(w, "Error sending announcement", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.announce.success", 1)
+			i.logger.Infof("/announce: Sent announcement: %q\n", dataObj.Message)
+
+			fmt.Fprintf(w, "Success")
+		})
+}
+
+/*
+registerRecoveryEndpoint - Registers the recovery report endpoint if our admin supports it.
+*/
+func (i *InternalServer) registerRecoveryEndpoint() {
+	reporter, ok := i.admin.(RecoveryReporter)
+	if !ok {
+		return
+	}
+
+	// Register /recovery_report endpoint for inspecting the startup recovery of transform logs
+	i.Register(
+		"/recovery_report",
+		`<GET> Get the outcome of the startup transform log recovery {"recovered":["<id1>"],"failed":[{"id":"<id2>","error":"<err>"}]}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.recovery_report.error", 1)
+				i.logger.Warnf("/recovery_report: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			resultBytes, err := json.Marshal(reporter.GetRecoveryReport())
+			if err != nil {
+				i.stats.Incr("http_admin.recovery_report.error", 1)
+				i.logger.Errorf("/recovery_report: %v\n", err)
+				http.Error(w, "Error collecting report", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.recovery_report.success", 1)
+
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		})
+}
+
+/*
+registerShutdownEndpoint - Registers the shutdown report endpoint if our admin supports it.
+*/
+func (i *InternalServer) registerShutdownEndpoint() {
+	reporter, ok := i.admin.(ShutdownReporter)
+	if !ok {
+		return
+	}
+
+	// Register /shutdown_report endpoint for verifying that nothing was lost while draining
+	i.Register(
+		"/shutdown_report",
+		`<GET> Get the outcome of draining, 404 until finished {"flushed":["<id1>"],"unflushed":[{"id":"<id2>","error":"<err>"}],"disconnected_clients":{"<id1>":["<user>"]}}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.shutdown_report.error", 1)
+				i.logger.Warnf("/shutdown_report: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			report, ok := reporter.GetShutdownReport()
+			if !ok {
+				http.Error(w, "Not yet drained", http.StatusNotFound)
+				return
+			}
+			resultBytes, err := json.Marshal(report)
+			if err != nil {
+				i.stats.Incr("http_admin.shutdown_report.error", 1)
+				i.logger.Errorf("/shutdown_report: %v\n", err)
+				http.Error(w, "Error collecting report", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.shutdown_report.success", 1)
+
 			w.Header().Add("Content-Type", "application/json")
 			w.Write(resultBytes)
 		})
 }
 
+/*
+registerLatencyEndpoint - Registers the transform latency endpoint if our admin supports it.
+*/
+func (i *InternalServer) registerLatencyEndpoint() {
+	reporter, ok := i.admin.(LatencyReporter)
+	if !ok {
+		return
+	}
+
+	// Register /latency endpoint for inspecting rolling percentiles of transform latencies
+	i.Register(
+		"/latency",
+		`<GET> Get transform latency percentiles {"global":{"samples":0,"p50_ms":0,"p95_ms":0,"p99_ms":0},"documents":{"<id>":{...}}}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.latency.error", 1)
+				i.logger.Warnf("/latency: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			resultBytes, err := json.Marshal(reporter.GetLatencyReport())
+			if err != nil {
+				i.stats.Incr("http_admin.latency.error", 1)
+				i.logger.Errorf("/latency: %v\n", err)
+				http.Error(w, "Error collecting report", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.latency.success", 1)
+
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		})
+}
+
+/*
+registerMetricsEndpoint - Registers the Prometheus metrics endpoint if our admin supports it.
+*/
+func (i *InternalServer) registerMetricsEndpoint() {
+	reporter, ok := i.admin.(MetricsReporter)
+	if !ok {
+		return
+	}
+
+	// Register /metrics endpoint for scraping by Prometheus
+	i.Register(
+		"/metrics",
+		"<GET> Get operational metrics in the Prometheus text format",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.metrics.error", 1)
+				i.logger.Warnf("/metrics: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			var buf bytes.Buffer
+			if err := reporter.WriteMetrics(&buf); err != nil {
+				i.stats.Incr("http_admin.metrics.error", 1)
+				i.logger.Errorf("/metrics: %v\n", err)
+				http.Error(w, "Error collecting metrics", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.metrics.success", 1)
+
+			w.Header().Add("Content-Type", "text/plain; version=0.0.4")
+			w.Write(buf.Bytes())
+		})
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
 
@@ -230,7 +551,7 @@ func (i *InternalServer) Register(endpoint, description string, handler http.Han
 		fullPath,
 		description,
 	})
-	i.mux.HandleFunc(fullPath, handler)
+	i.mux.HandleFunc(fullPath, i.limits.WrapHandlerFunc(handler))
 }
 
 /*
@@ -241,31 +562,20 @@ func (i *InternalServer) Listen() error {
 	if len(i.config.Address) == 0 {
 		return ErrInvalidURLAddr
 	}
+	server := &http.Server{Addr: i.config.Address, Handler: i.mux}
 	if i.config.SSL.Enabled {
-		if len(i.config.SSL.CertificatePath) == 0 || len(i.config.SSL.PrivateKeyPath) == 0 {
-			return ErrInvalidSSLConfig
-		}
-		// If the static paths are relative then we use the location of the binary to resolve it.
-		if err := binpath.FromBinaryIfRelative(&i.config.SSL.CertificatePath); err != nil {
-			return fmt.Errorf("relative path for certificate could not be resolved: %v", err)
-		}
-		if err := binpath.FromBinaryIfRelative(&i.config.SSL.PrivateKeyPath); err != nil {
-			return fmt.Errorf("relative path for private key could not be resolved: %v", err)
+		// ACME HTTP challenges are answered by the public server, or through TLS-ALPN here.
+		tlsConfig, _, err := i.config.SSL.serverTLS()
+		if err != nil {
+			return err
 		}
+		server.TLSConfig = tlsConfig
 	}
 	i.logger.Infof("Serving internal admin requests at address: %v%v\n", i.config.Address, i.config.Path)
-	var err error
 	if i.config.SSL.Enabled {
-		err = http.ListenAndServeTLS(
-			i.config.Address,
-			i.config.SSL.CertificatePath,
-			i.config.SSL.PrivateKeyPath,
-			i.mux,
-		)
-	} else {
-		err = http.ListenAndServe(i.config.Address, i.mux)
+		return server.ListenAndServeTLS("", "")
 	}
-	return err
+	return server.ListenAndServe()
 }
 
 /*--------------------------------------------------------------------------------------------------
@@ -23,11 +23,19 @@ THE SOFTWARE.
 package net
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
 )
 
 /*--------------------------------------------------------------------------------------------------
@@ -112,7 +120,7 @@ func TestRegisterEndpoint(t *testing.T) {
 	for _, e := range endpointTests {
 		internalServer.Register("/"+e, "no", func(epnt string) http.HandlerFunc {
 			return func(w http.ResponseWriter, r *http.Request) {
-				fmt.Fprintf(w, epnt)
+				fmt.Fprint(w, epnt)
 			}
 		}(e))
 	}
@@ -138,5 +146,699 @@ func TestRegisterEndpoint(t *testing.T) {
 	}
 }
 
+type FakeDocumentAdmin struct {
+	FakeAdmin
+	documents map[string]store.Document
+}
+
+func (f FakeDocumentAdmin) GetDocument(id string) (store.Document, error) {
+	if doc, ok := f.documents[id]; ok {
+		return doc, nil
+	}
+	return store.Document{}, errors.New("not found")
+}
+
+func (f FakeDocumentAdmin) PutDocument(doc store.Document) error {
+	f.documents[doc.ID] = doc
+	return nil
+}
+
+func (f FakeDocumentAdmin) MergeDocument(doc store.Document, timeout time.Duration) (store.Document, error) {
+	doc.Content = f.documents[doc.ID].Content + doc.Content
+	f.documents[doc.ID] = doc
+	return doc, nil
+}
+
+func (f FakeDocumentAdmin) DeleteDocument(id string) error {
+	delete(f.documents, id)
+	return nil
+}
+
+func TestDocumentsEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+	config.DocumentsToken = "secret"
+
+	admin := FakeDocumentAdmin{documents: map[string]store.Document{}}
+
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		internalServer.mux.ServeHTTP(res, req)
+		return res
+	}
+
+	if res := request("PUT", "/internal/documents/css/main.css", "wrong", `{"content":"body {}"}`); res.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status for bad token: %v", res.Code)
+	}
+	if res := request("PUT", "/internal/documents/css/main.css", "secret", `{"content":"body {}"}`); res.Code != http.StatusOK {
+		t.Errorf("Wrong status for put: %v", res.Code)
+	}
+	if doc := admin.documents["css/main.css"]; doc.Content != "body {}" {
+		t.Errorf("Document was not written: %v", doc)
+	}
+
+	res := request("PUT", "/internal/documents/css/main.css?merge=true", "secret", `{"content":"\np {}"}`)
+	if exp, act := `{"id":"css/main.css","content":"body {}\np {}"}`, res.Body.String(); exp != act {
+		t.Errorf("Wrong merge response: %v != %v", exp, act)
+	}
+	request("PUT", "/internal/documents/css/main.css", "secret", `{"content":"body {}"}`)
+
+	res = request("POST", "/internal/documents", "secret", `{"content":"hello world"}`)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("Wrong status for post: %v", res.Code)
+	}
+	if !strings.Contains(res.Body.String(), `"content":"hello world"`) {
+		t.Errorf("Wrong post response: %v", res.Body.String())
+	}
+	if len(admin.documents) != 2 {
+		t.Errorf("Document was not created: %v", admin.documents)
+	}
+
+	res = request("GET", "/internal/documents/css/main.css", "secret", "")
+	if exp, act := `{"id":"css/main.css","content":"body {}"}`, res.Body.String(); exp != act {
+		t.Errorf("Wrong get response: %v != %v", exp, act)
+	}
+
+	if res = request("DELETE", "/internal/documents/css/main.css", "secret", ""); res.Code != http.StatusNoContent {
+		t.Errorf("Wrong status for delete: %v", res.Code)
+	}
+	if res = request("GET", "/internal/documents/css/main.css", "secret", ""); res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for deleted document: %v", res.Code)
+	}
+}
+
+type FakeAliasAdmin struct {
+	FakeDocumentAdmin
+	aliases map[string]string
+}
+
+func (f FakeAliasAdmin) SetAlias(alias, id string) error {
+	if _, exists := f.documents[alias]; exists {
+		return lib.ErrAliasTaken
+	}
+	if _, exists := f.documents[id]; !exists {
+		return store.ErrDocumentNotExist
+	}
+	f.aliases[alias] = id
+	return nil
+}
+
+func (f FakeAliasAdmin) RemoveAlias(alias string) error {
+	if _, exists := f.aliases[alias]; !exists {
+		return lib.ErrAliasNotExist
+	}
+	delete(f.aliases, alias)
+	return nil
+}
+
+func (f FakeAliasAdmin) ListAliases() map[string]string {
+	return f.aliases
+}
+
+func TestAliasesEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+	config.DocumentsToken = "secret"
+
+	admin := FakeAliasAdmin{
+		FakeDocumentAdmin: FakeDocumentAdmin{documents: map[string]store.Document{
+			"doc1": {ID: "doc1", Content: "hello world"},
+		}},
+		aliases: map[string]string{},
+	}
+
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, target, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res := httptest.NewRecorder()
+		internalServer.mux.ServeHTTP(res, req)
+		return res
+	}
+
+	if res := request("PUT", "/internal/aliases/welcome", "wrong", `{"document_id":"doc1"}`); res.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status for bad token: %v", res.Code)
+	}
+	if res := request("PUT", "/internal/aliases/welcome", "secret", `{"document_id":"nope"}`); res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for missing document: %v", res.Code)
+	}
+	if res := request("PUT", "/internal/aliases/doc1", "secret", `{"document_id":"doc1"}`); res.Code != http.StatusConflict {
+		t.Errorf("Wrong status for alias of a document ID: %v", res.Code)
+	}
+	if res := request("PUT", "/internal/aliases/welcome", "secret", `{"document_id":"doc1"}`); res.Code != http.StatusOK {
+		t.Errorf("Wrong status for put: %v", res.Code)
+	}
+	if id := admin.aliases["welcome"]; id != "doc1" {
+		t.Errorf("Alias was not set: %v", admin.aliases)
+	}
+
+	res := request("GET", "/internal/aliases", "secret", "")
+	if exp, act := `{"items":[{"alias":"welcome","document_id":"doc1"}],"total":1}`, res.Body.String(); exp != act {
+		t.Errorf("Wrong list response: %v != %v", exp, act)
+	}
+
+	if res = request("DELETE", "/internal/aliases/welcome", "secret", ""); res.Code != http.StatusNoContent {
+		t.Errorf("Wrong status for delete: %v", res.Code)
+	}
+	if res = request("DELETE", "/internal/aliases/welcome", "secret", ""); res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for removed alias: %v", res.Code)
+	}
+}
+
+type FakeLiveDocumentAdmin struct {
+	FakeDocumentAdmin
+	live map[string]string
+}
+
+func (f FakeLiveDocumentAdmin) GetLiveDocument(id string, timeout time.Duration) (store.Document, error) {
+	doc, err := f.GetDocument(id)
+	if content, ok := f.live[id]; ok {
+		doc.Content = content
+	}
+	return doc, err
+}
+
+func TestDocumentsReadYourWrites(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	admin := FakeLiveDocumentAdmin{
+		FakeDocumentAdmin: FakeDocumentAdmin{documents: map[string]store.Document{
+			"notes": {ID: "notes", Content: "flushed"},
+		}},
+		live: map[string]string{"notes": "unflushed"},
+	}
+
+	for _, readYourWrites := range []bool{true, false} {
+		config := NewInternalServerConfig()
+		config.Path = "/internal"
+		config.DocumentsToken = "secret"
+		config.ReadYourWrites = readYourWrites
+
+		internalServer, err := NewInternalServer(admin, config, log, stats)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest("GET", "/internal/documents/notes", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		res := httptest.NewRecorder()
+		internalServer.mux.ServeHTTP(res, req)
+
+		exp := `{"id":"notes","content":"flushed"}`
+		if readYourWrites {
+			exp = `{"id":"notes","content":"unflushed"}`
+		}
+		if act := res.Body.String(); exp != act {
+			t.Errorf("Wrong response with read your writes %v: %v != %v", readYourWrites, exp, act)
+		}
+	}
+}
+
+type FakeBanAdmin struct {
+	FakeAdmin
+	bans map[string]time.Duration
+}
+
+func (f FakeBanAdmin) BanUser(doc, user string, duration, timeout time.Duration) error {
+	f.bans[doc+"/"+user] = duration
+	return nil
+}
+
+func TestBanEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+
+	admin := FakeBanAdmin{bans: map[string]time.Duration{}}
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest(
+		"POST", "/internal/ban_user", strings.NewReader(`{"user_id":"troll","doc_id":"doc","duration_s":60}`),
+	))
+	if res.Code != http.StatusOK {
+		t.Errorf("Wrong status for ban: %v", res.Code)
+	}
+	if exp, act := time.Minute, admin.bans["doc/troll"]; exp != act {
+		t.Errorf("Wrong ban duration: %v != %v", exp, act)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("POST", "/internal/ban_user", strings.NewReader("nope")))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for bad data: %v", res.Code)
+	}
+}
+
+type FakeAnnounceAdmin struct {
+	FakeAdmin
+	announcements *[]lib.Announcement
+}
+
+func (f FakeAnnounceAdmin) Announce(announcement lib.Announcement, timeout time.Duration) error {
+	if len(announcement.Message) == 0 {
+		return lib.ErrEmptyAnnouncement
+	}
+	*f.announcements = append(*f.announcements, announcement)
+	return nil
+}
+
+func TestAnnounceEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+
+	admin := FakeAnnounceAdmin{announcements: &[]lib.Announcement{}}
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest(
+		"POST", "/internal/announce", strings.NewReader(`{"message":"Restarting soon","severity":"warning","expires_in_s":600}`),
+	))
+	if res.Code != http.StatusOK {
+		t.Errorf("Wrong status for announcement: %v", res.Code)
+	}
+	if len(*admin.announcements) != 1 {
+		t.Fatalf("Wrong number of announcements: %v", len(*admin.announcements))
+	}
+	announcement := (*admin.announcements)[0]
+	if announcement.Message != "Restarting soon" || announcement.Severity != "warning" {
+		t.Errorf("Wrong announcement: %+v", announcement)
+	}
+	if expires := announcement.Expires - time.Now().Unix(); expires < 590 || expires > 600 {
+		t.Errorf("Wrong announcement expiry: %v", expires)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("POST", "/internal/announce", strings.NewReader(`{}`)))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for empty announcement: %v", res.Code)
+	}
+}
+
+func bearer(req *http.Request) *http.Request {
+	req.Header.Set("Authorization", "Bearer secret")
+	return req
+}
+
+type FakeInspectAdmin struct {
+	FakeAdmin
+	binders []lib.BinderInfo
+}
+
+func (f FakeInspectAdmin) InspectBinders(timeout time.Duration) ([]lib.BinderInfo, error) {
+	return f.binders, nil
+}
+
+func (f FakeInspectAdmin) InspectBinder(doc string, timeout time.Duration) (lib.BinderInfo, error) {
+	for _, info := range f.binders {
+		if info.ID == doc {
+			return info, nil
+		}
+	}
+	return lib.BinderInfo{}, lib.ErrBinderNotFound
+}
+
+func TestBindersEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+	config.DocumentsToken = "secret"
+
+	admin := FakeInspectAdmin{binders: []lib.BinderInfo{
+		{ID: "doc1", Version: 4, Subscribers: 2},
+		{ID: "doc2", Version: 1},
+	}}
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("GET", "/internal/binders", nil))
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status without a token: %v", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest("GET", "/internal/binders", nil)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status for binders: %v", res.Code)
+	}
+	page := struct {
+		Items      []lib.BinderInfo `json:"items"`
+		Total      int              `json:"total"`
+		NextCursor string           `json:"next_cursor"`
+	}{}
+	if err := json.Unmarshal(res.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 2 || page.Items[0].ID != "doc1" || page.Items[0].Subscribers != 2 {
+		t.Errorf("Wrong binders: %+v", page)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest("GET", "/internal/binders?limit=1&sort=-id", nil)))
+	if err := json.Unmarshal(res.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 1 || page.Items[0].ID != "doc2" || len(page.NextCursor) == 0 {
+		t.Errorf("Wrong page of binders: %+v", page)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest("GET", "/internal/binders?doc_id=doc2", nil)))
+	info := lib.BinderInfo{}
+	if err := json.Unmarshal(res.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "doc2" || info.Version != 1 {
+		t.Errorf("Wrong binder: %+v", info)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest("GET", "/internal/binders?doc_id=doc3", nil)))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for closed document: %v", res.Code)
+	}
+}
+
+type FakeControlAdmin struct {
+	FakeAdmin
+	open map[string]int
+}
+
+func (f FakeControlAdmin) FlushDocument(doc string, timeout time.Duration) (int, error) {
+	version, ok := f.open[doc]
+	if !ok {
+		return 0, lib.ErrBinderNotFound
+	}
+	return version, nil
+}
+
+func (f FakeControlAdmin) CloseDocument(doc string) error {
+	if _, ok := f.open[doc]; !ok {
+		return lib.ErrBinderNotFound
+	}
+	delete(f.open, doc)
+	return nil
+}
+
+func TestBinderControlEndpoints(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+	config.DocumentsToken = "secret"
+
+	admin := FakeControlAdmin{open: map[string]int{"doc1": 5}}
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest(
+		"POST", "/internal/flush_document", strings.NewReader(`{"doc_id":"doc1"}`),
+	)))
+	if exp, act := `{"doc_id":"doc1","version":5}`, res.Body.String(); exp != act {
+		t.Errorf("Wrong flush result: %v != %v", exp, act)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest("GET", "/internal/close_document", nil)))
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status for GET: %v", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest(
+		"POST", "/internal/close_document", strings.NewReader(`{"doc_id":"doc1"}`),
+	)))
+	if res.Code != http.StatusOK {
+		t.Errorf("Wrong status for close: %v", res.Code)
+	}
+	if _, open := admin.open["doc1"]; open {
+		t.Error("Document was not closed")
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest(
+		"POST", "/internal/flush_document", strings.NewReader(`{"doc_id":"doc1"}`),
+	)))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for closed document: %v", res.Code)
+	}
+}
+
+type FakeExportAdmin struct {
+	FakeAdmin
+	exports map[string]lib.DocumentExport
+}
+
+func (f FakeExportAdmin) ExportDocument(doc string, timeout time.Duration) (lib.DocumentExport, error) {
+	export, ok := f.exports[doc]
+	if !ok {
+		return export, store.ErrDocumentNotExist
+	}
+	return export, nil
+}
+
+func TestExportEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+	config.DocumentsToken = "secret"
+
+	admin := FakeExportAdmin{exports: map[string]lib.DocumentExport{
+		"doc1": {
+			Document: store.Document{ID: "doc1", Content: "# Notes\n\nSome *text* <script>alert(1)</script>"},
+			Version:  3,
+			Epoch:    "epoch1",
+			Open:     true,
+		},
+		"doc2": {Document: store.Document{ID: "doc2", Content: "{}", Type: "json"}},
+	}}
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		internalServer.mux.ServeHTTP(res, bearer(httptest.NewRequest("GET", "/internal/export?"+query, nil)))
+		return res
+	}
+
+	res := get("doc_id=doc1&format=text")
+	if exp, act := admin.exports["doc1"].Document.Content, res.Body.String(); exp != act {
+		t.Errorf("Wrong text export: %v != %v", exp, act)
+	}
+
+	res = get("doc_id=doc1&format=html")
+	if ct := res.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Wrong content type: %v", ct)
+	}
+	html := res.Body.String()
+	if !strings.Contains(html, "<h1>Notes</h1>") || !strings.Contains(html, "<em>text</em>") {
+		t.Errorf("Markdown was not rendered: %v", html)
+	}
+	if strings.Contains(html, "<script>") {
+		t.Errorf("Raw HTML was not skipped: %v", html)
+	}
+
+	res = get("doc_id=doc1")
+	export := lib.DocumentExport{}
+	if err := json.Unmarshal(res.Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Version != 3 || export.Document.ID != "doc1" || !export.Open {
+		t.Errorf("Wrong json export: %+v", export)
+	}
+
+	// Renders of open documents are cached by version
+	if _, ok := internalServer.renders.Get(RenderKey{ID: "doc1", Format: "html", Epoch: "epoch1", Version: 3}); !ok {
+		t.Error("Expected html render to be cached")
+	}
+	jsonKey := RenderKey{ID: "doc1", Format: "json", Epoch: "epoch1", Version: 3, Metadata: MetadataDigest(nil)}
+	if _, ok := internalServer.renders.Get(jsonKey); !ok {
+		t.Error("Expected json export to be cached")
+	}
+
+	// Metadata changes without the version, which must not serve a stale json export
+	changed := admin.exports["doc1"]
+	changed.Document.Metadata = map[string]string{"title": "Notes"}
+	admin.exports["doc1"] = changed
+	export = lib.DocumentExport{}
+	if err := json.Unmarshal(get("doc_id=doc1").Body.Bytes(), &export); err != nil {
+		t.Fatal(err)
+	}
+	if export.Document.Metadata["title"] != "Notes" {
+		t.Errorf("Stale metadata was exported: %v", export.Document.Metadata)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("GET", "/internal/export?doc_id=doc1", nil))
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status without a token: %v", res.Code)
+	}
+
+	if res = get("doc_id=doc2&format=html"); res.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for rendering json document: %v", res.Code)
+	}
+	if res = get("doc_id=doc2&format=pdf"); res.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for unknown format: %v", res.Code)
+	}
+	if res = get("doc_id=doc3"); res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for missing document: %v", res.Code)
+	}
+}
+
+type FakeLeaseAdmin struct {
+	FakeAdmin
+	leases map[string]string
+}
+
+func (f FakeLeaseAdmin) InspectLease(doc string) (lib.LeaseInfo, error) {
+	node, ok := f.leases[doc]
+	if !ok {
+		return lib.LeaseInfo{}, lib.ErrLeaseNotHeld
+	}
+	return lib.LeaseInfo{DocumentID: doc, Node: node, Fingerprint: "abc"}, nil
+}
+
+func (f FakeLeaseAdmin) BreakLease(doc, node, fingerprint string) error {
+	if f.leases[doc] != node || fingerprint != "abc" {
+		return lib.ErrLeaseChanged
+	}
+	delete(f.leases, doc)
+	return nil
+}
+
+func (f FakeLeaseAdmin) GetClusterStatus() (lib.ClusterStatus, error) {
+	return lib.ClusterStatus{
+		Node:     "node1",
+		Protocol: lib.RelayProtocol,
+		Mixed:    true,
+		Peers:    []lib.RelayPeer{{Node: "node2", Protocol: lib.RelayProtocol - 1, Compatible: true}},
+	}, nil
+}
+
+func TestLeaseEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+
+	admin := FakeLeaseAdmin{leases: map[string]string{"doc": "node1"}}
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("GET", "/internal/break_lease?doc_id=doc", nil))
+	if exp, act := `{"document_id":"doc","node":"node1","local":false,"fingerprint":"abc"}`, res.Body.String(); exp != act {
+		t.Errorf("Wrong lease: %v != %v", exp, act)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest(
+		"POST", "/internal/break_lease", strings.NewReader(`{"doc_id":"doc","node":"node1","fingerprint":"old"}`),
+	))
+	if res.Code != http.StatusConflict {
+		t.Errorf("Wrong status for changed lease: %v", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest(
+		"POST", "/internal/break_lease", strings.NewReader(`{"doc_id":"doc","node":"node1","fingerprint":"abc"}`),
+	))
+	if res.Code != http.StatusOK {
+		t.Errorf("Wrong status for break: %v", res.Code)
+	}
+	if _, ok := admin.leases["doc"]; ok {
+		t.Error("Lease was not broken")
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("GET", "/internal/break_lease?doc_id=doc", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Wrong status for missing lease: %v", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("GET", "/internal/cluster", nil))
+	var status lib.ClusterStatus
+	if err := json.Unmarshal(res.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Mixed || len(status.Peers) != 1 || status.Peers[0].Node != "node2" {
+		t.Errorf("Wrong cluster status: %+v", status)
+	}
+}
+
+type FakeMetricsAdmin struct {
+	FakeAdmin
+}
+
+func (f FakeMetricsAdmin) WriteMetrics(w io.Writer) error {
+	_, err := io.WriteString(w, "leaps_active_binders 1\n")
+	return err
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+
+	internalServer, err := NewInternalServer(FakeMetricsAdmin{}, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("GET", "/internal/metrics", nil))
+	if exp, act := "leaps_active_binders 1\n", res.Body.String(); exp != act {
+		t.Errorf("Wrong metrics response: %v != %v", exp, act)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("POST", "/internal/metrics", nil))
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Wrong status for post: %v", res.Code)
+	}
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
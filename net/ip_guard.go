@@ -0,0 +1,261 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	gonet "net"
+	"sync"
+
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the IPGuard type.
+var (
+	ErrInvalidCIDR = errors.New("invalid config value for CIDR range")
+)
+
+/*
+IPGuardConfig - Holds configuration options for the IPGuard, which screens the connections accepted
+by a server by their remote address. Addresses within a range of Deny are refused, and when Allow is
+not empty only addresses within one of its ranges are accepted. Ranges are written in CIDR notation
+such as '10.0.0.0/8', or as a single address. MaxConnectionsPerIP caps the number of connections that
+a single address may hold open at once, where zero disables the cap. Connections are screened before
+any HTTP request is read, and so behind a reverse proxy every client shares the address of the
+proxy.
+*/
+type IPGuardConfig struct {
+	MaxConnectionsPerIP int      `json:"max_connections_per_ip" yaml:"max_connections_per_ip"`
+	Allow               []string `json:"allow" yaml:"allow"`
+	Deny                []string `json:"deny" yaml:"deny"`
+}
+
+/*
+NewIPGuardConfig - Returns an IPGuard configuration with the default values, where every connection
+is accepted.
+*/
+func NewIPGuardConfig() IPGuardConfig {
+	return IPGuardConfig{
+		MaxConnectionsPerIP: 0,
+		Allow:               []string{},
+		Deny:                []string{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+IPGuard - Screens the connections of a listener against allow and deny lists of address ranges, and
+caps the number of connections open from each address.
+*/
+type IPGuard struct {
+	config IPGuardConfig
+	allow  []*gonet.IPNet
+	deny   []*gonet.IPNet
+	logger *log.Logger
+	stats  *log.Stats
+
+	open  map[string]int
+	mutex sync.Mutex
+}
+
+/*
+NewIPGuard - Create a new leaps IPGuard.
+*/
+func NewIPGuard(config IPGuardConfig, logger *log.Logger, stats *log.Stats) (*IPGuard, error) {
+	guard := IPGuard{
+		config: config,
+		logger: logger.NewModule(":ip_guard"),
+		stats:  stats,
+		open:   map[string]int{},
+	}
+	var err error
+	if guard.allow, err = parseCIDRs(config.Allow); err != nil {
+		return nil, err
+	}
+	if guard.deny, err = parseCIDRs(config.Deny); err != nil {
+		return nil, err
+	}
+	return &guard, nil
+}
+
+/*
+parseCIDRs - Parse a list of CIDR ranges, where single addresses are taken as a range of their own.
+*/
+func parseCIDRs(ranges []string) ([]*gonet.IPNet, error) {
+	nets := []*gonet.IPNet{}
+	for _, r := range ranges {
+		if ip := gonet.ParseIP(r); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &gonet.IPNet{IP: ip, Mask: gonet.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := gonet.ParseCIDR(r)
+		if err != nil {
+			return nil, ErrInvalidCIDR
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+/*
+enabled - Whether the guard screens connections at all.
+*/
+func (g *IPGuard) enabled() bool {
+	return g.config.MaxConnectionsPerIP > 0 || len(g.allow) > 0 || len(g.deny) > 0
+}
+
+/*
+permitted - Whether an address is permitted by the allow and deny lists.
+*/
+func (g *IPGuard) permitted(ip gonet.IP) bool {
+	for _, ipNet := range g.deny {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if len(g.allow) == 0 {
+		return true
+	}
+	for _, ipNet := range g.allow {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+acquire - Count a fresh connection from a remote address, returning false if it must be refused.
+*/
+func (g *IPGuard) acquire(host string) bool {
+	if ip := gonet.ParseIP(host); ip != nil && !g.permitted(ip) {
+		g.stats.Incr("http.ip_guard.denied", 1)
+		g.logger.Debugf("Refused connection from denied address %v\n", host)
+		return false
+	}
+	if g.config.MaxConnectionsPerIP <= 0 {
+		return true
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.open[host] >= g.config.MaxConnectionsPerIP {
+		g.stats.Incr("http.ip_guard.limited", 1)
+		g.logger.Debugf("Refused connection from %v, which has too many open\n", host)
+		return false
+	}
+	g.open[host]++
+	return true
+}
+
+/*
+release - Forget a closed connection from a remote address.
+*/
+func (g *IPGuard) release(host string) {
+	if g.config.MaxConnectionsPerIP <= 0 {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.open[host]--; g.open[host] <= 0 {
+		delete(g.open, host)
+	}
+}
+
+/*
+WrapListener - Wrap a listener such that connections refused by the guard are closed as soon as they
+are accepted.
+*/
+func (g *IPGuard) WrapListener(listener gonet.Listener) gonet.Listener {
+	if g == nil || !g.enabled() {
+		return listener
+	}
+	return &guardedListener{Listener: listener, guard: g}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+guardedListener - A listener that screens the connections it accepts through an IPGuard.
+*/
+type guardedListener struct {
+	gonet.Listener
+	guard *IPGuard
+}
+
+/*
+Accept - Wait for the next connection permitted by the guard.
+*/
+func (l *guardedListener) Accept() (gonet.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		host, _, err := gonet.SplitHostPort(conn.RemoteAddr().String())
+		if err != nil {
+			host = conn.RemoteAddr().String()
+		}
+		if !l.guard.acquire(host) {
+			conn.Close()
+			continue
+		}
+		return &guardedConn{Conn: conn, guard: l.guard, host: host}, nil
+	}
+}
+
+/*
+guardedConn - A connection counted by an IPGuard until it is closed.
+*/
+type guardedConn struct {
+	gonet.Conn
+	guard       *IPGuard
+	host        string
+	releaseOnce sync.Once
+}
+
+/*
+Close - Close the connection and release it from the guard.
+*/
+func (c *guardedConn) Close() error {
+	c.releaseOnce.Do(func() {
+		c.guard.release(c.host)
+	})
+	return c.Conn.Close()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,135 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	gonet "net"
+	"testing"
+	"time"
+)
+
+func TestIPGuardLists(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewIPGuardConfig()
+	config.Allow = []string{"10.0.0.0/8", "192.168.1.5", "fd00::/8"}
+	config.Deny = []string{"10.0.13.0/24"}
+
+	guard, err := NewIPGuard(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, exp := range map[string]bool{
+		"10.1.2.3":    true,
+		"10.0.13.37":  false,
+		"192.168.1.5": true,
+		"192.168.1.6": false,
+		"fd00::1":     true,
+		"2001:db8::1": false,
+		"127.0.0.1":   false,
+	} {
+		if act := guard.permitted(gonet.ParseIP(addr)); act != exp {
+			t.Errorf("Wrong result for %v: %v != %v", addr, act, exp)
+		}
+	}
+
+	config.Deny = []string{"10.0.0.0/33"}
+	if _, err = NewIPGuard(config, logger, stats); err != ErrInvalidCIDR {
+		t.Errorf("Wrong error for invalid range: %v", err)
+	}
+}
+
+func TestIPGuardListener(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewIPGuardConfig()
+	config.MaxConnectionsPerIP = 2
+
+	guard, err := NewIPGuard(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inner, err := gonet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := guard.WrapListener(inner)
+	defer listener.Close()
+
+	acceptedChan := make(chan gonet.Conn, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			acceptedChan <- conn
+		}
+	}()
+
+	dial := func() gonet.Conn {
+		conn, err := gonet.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	accepted := func(wait time.Duration) gonet.Conn {
+		select {
+		case conn := <-acceptedChan:
+			return conn
+		case <-time.After(wait):
+			return nil
+		}
+	}
+
+	first, second := dial(), dial()
+	defer first.Close()
+	defer second.Close()
+	firstServer, secondServer := accepted(time.Second), accepted(time.Second)
+	if firstServer == nil || secondServer == nil {
+		t.Fatal("Connections within the limit were refused")
+	}
+
+	third := dial()
+	defer third.Close()
+	if accepted(100*time.Millisecond) != nil {
+		t.Error("Connection beyond the limit was accepted")
+	}
+	third.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err = third.Read(make([]byte, 1)); err == nil {
+		t.Error("Refused connection was not closed")
+	}
+
+	firstServer.Close()
+	fourth := dial()
+	defer fourth.Close()
+	if conn := accepted(time.Second); conn == nil {
+		t.Error("Connection was refused after another closed")
+	} else {
+		conn.Close()
+	}
+	secondServer.Close()
+}
@@ -0,0 +1,183 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+JoinLimitConfig - Options for limiting the number of clients joining a document at the same time. A
+join lasts from binding to the document until its init response, which carries the whole document,
+has been sent. At most MaxConcurrent clients join each document at once, and up to QueueLength more
+wait in line for up to QueueTimeout milliseconds before being turned away. Join storms, such as
+everyone opening a document as a meeting starts, are admitted at a controlled rate rather than all
+at once. The limit covers the clients of every transport served by the HTTPServer, including gRPC
+streams. A MaxConcurrent of zero disables the limit.
+*/
+type JoinLimitConfig struct {
+	MaxConcurrent int `json:"max_concurrent" yaml:"max_concurrent"`
+	QueueLength   int `json:"queue_length" yaml:"queue_length"`
+	QueueTimeout  int `json:"queue_timeout_ms" yaml:"queue_timeout_ms"`
+}
+
+/*
+NewJoinLimitConfig - Returns a default JoinLimitConfig, where the limit is disabled.
+*/
+func NewJoinLimitConfig() JoinLimitConfig {
+	return JoinLimitConfig{
+		MaxConcurrent: 0,
+		QueueLength:   32,
+		QueueTimeout:  5000,
+	}
+}
+
+// Errors for the join limiter.
+var (
+	ErrJoinQueueFull    = errors.New("too many clients are joining the document")
+	ErrJoinQueueTimeout = errors.New("timed out waiting to join the document")
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+joinSlots - The joins in progress of a document, and the number of joins using or waiting on them.
+*/
+type joinSlots struct {
+	active  chan struct{}
+	waiting int
+	users   int
+}
+
+/*
+joinLimiter - Admits clients joining documents according to a JoinLimitConfig. A nil joinLimiter
+admits every client immediately.
+*/
+type joinLimiter struct {
+	config    JoinLimitConfig
+	stats     *log.Stats
+	mutex     sync.Mutex
+	documents map[string]*joinSlots
+}
+
+/*
+newJoinLimiter - Returns a joinLimiter for a config, or nil when the limit is disabled.
+*/
+func newJoinLimiter(config JoinLimitConfig, stats *log.Stats) *joinLimiter {
+	if config.MaxConcurrent <= 0 {
+		return nil
+	}
+	return &joinLimiter{
+		config:    config,
+		stats:     stats,
+		documents: map[string]*joinSlots{},
+	}
+}
+
+/*
+acquire - Wait for a slot to join a document, returns a func that must be called once the join has
+finished. Fails when the queue of the document is full or the wait times out.
+*/
+func (l *joinLimiter) acquire(documentID string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	l.mutex.Lock()
+	slots, exists := l.documents[documentID]
+	if !exists {
+		slots = &joinSlots{active: make(chan struct{}, l.config.MaxConcurrent)}
+		l.documents[documentID] = slots
+	}
+	select {
+	case slots.active <- struct{}{}:
+		slots.users++
+		l.mutex.Unlock()
+		l.stats.Incr("http.joins.admitted", 1)
+		return func() { l.release(documentID, slots) }, nil
+	default:
+	}
+	if slots.waiting >= l.config.QueueLength {
+		l.mutex.Unlock()
+		l.stats.Incr("http.joins.rejected", 1)
+		return nil, ErrJoinQueueFull
+	}
+	slots.waiting++
+	slots.users++
+	l.mutex.Unlock()
+
+	l.stats.Incr("http.joins.queued", 1)
+
+	var err error
+	select {
+	case slots.active <- struct{}{}:
+	case <-time.After(time.Duration(l.config.QueueTimeout) * time.Millisecond):
+		err = ErrJoinQueueTimeout
+	}
+
+	l.mutex.Lock()
+	slots.waiting--
+	if err != nil {
+		l.leave(documentID, slots)
+	}
+	l.mutex.Unlock()
+
+	if err != nil {
+		l.stats.Incr("http.joins.timed_out", 1)
+		return nil, err
+	}
+	l.stats.Incr("http.joins.admitted", 1)
+	return func() { l.release(documentID, slots) }, nil
+}
+
+/*
+release - Free the slot of a finished join.
+*/
+func (l *joinLimiter) release(documentID string, slots *joinSlots) {
+	<-slots.active
+	l.mutex.Lock()
+	l.leave(documentID, slots)
+	l.mutex.Unlock()
+}
+
+/*
+leave - Stop counting a join against the slots of a document, which are dropped once unused. Must be
+called with the mutex held.
+*/
+func (l *joinLimiter) leave(documentID string, slots *joinSlots) {
+	slots.users--
+	if slots.users == 0 {
+		delete(l.documents, documentID)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJoinLimiter(t *testing.T) {
+	var disabled *joinLimiter
+	if release, err := disabled.acquire("doc"); err != nil {
+		t.Errorf("Disabled limiter refused join: %v", err)
+	} else {
+		release()
+	}
+
+	_, stats := loggerAndStats()
+
+	config := NewJoinLimitConfig()
+	config.MaxConcurrent = 1
+	config.QueueLength = 1
+	config.QueueTimeout = 2000
+
+	limiter := newJoinLimiter(config, stats)
+
+	first, err := limiter.acquire("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Other documents have slots of their own
+	other, err := limiter.acquire("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other()
+
+	admitted := make(chan error)
+	go func() {
+		second, err := limiter.acquire("doc")
+		if err == nil {
+			second()
+		}
+		admitted <- err
+	}()
+
+	for queued := 0; queued == 0; {
+		<-time.After(time.Millisecond)
+		limiter.mutex.Lock()
+		queued = limiter.documents["doc"].waiting
+		limiter.mutex.Unlock()
+	}
+
+	if _, err = limiter.acquire("doc"); err != ErrJoinQueueFull {
+		t.Errorf("Expected queue full error, received: %v", err)
+	}
+
+	first()
+	select {
+	case err = <-admitted:
+		if err != nil {
+			t.Errorf("Queued join failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for queued join")
+	}
+
+	limiter.mutex.Lock()
+	if len(limiter.documents) != 0 {
+		t.Errorf("Slots were not dropped: %v", limiter.documents)
+	}
+	limiter.mutex.Unlock()
+}
+
+func TestJoinLimiterTimeout(t *testing.T) {
+	_, stats := loggerAndStats()
+
+	config := NewJoinLimitConfig()
+	config.MaxConcurrent = 1
+	config.QueueTimeout = 10
+
+	limiter := newJoinLimiter(config, stats)
+
+	first, err := limiter.acquire("doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = limiter.acquire("doc"); err != ErrJoinQueueTimeout {
+		t.Errorf("Expected queue timeout error, received: %v", err)
+	}
+	first()
+
+	if second, err := limiter.acquire("doc"); err != nil {
+		t.Errorf("Join refused after release: %v", err)
+	} else {
+		second()
+	}
+}
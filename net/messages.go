@@ -0,0 +1,223 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Codes of the user facing messages sent to clients by the server.
+const (
+	MessageServerClosing      = "server_closing"
+	MessageServerShuttingDown = "server_shutting_down"
+	MessageInitFailed         = "init_failed"
+	MessageSubmitFailed       = "submit_failed"
+	MessageVersionFailed      = "version_failed"
+	MessageTransformMissing   = "transform_missing"
+	MessagePositionMissing    = "position_missing"
+	MessageMetadataMissing    = "metadata_missing"
+	MessageCommentMissing     = "comment_missing"
+	MessageUnknownCommand     = "unknown_command"
+	MessageKicked             = "kicked"
+	MessageDocumentClosing    = "document_closing"
+	MessageDocumentDegraded   = "document_degraded"
+	MessageDocumentRecovered  = "document_recovered"
+)
+
+/*
+defaultMessageTemplates - The English templates of each message code, used when neither the locale
+of a client nor the default locale have a template for a code.
+*/
+var defaultMessageTemplates = map[string]string{
+	MessageServerClosing:      "target server node is closing",
+	MessageServerShuttingDown: "target server node is shutting down",
+	MessageInitFailed:         "socket initialization failed: {detail}",
+	MessageSubmitFailed:       "submit error: {detail}",
+	MessageVersionFailed:      "version error: {detail}",
+	MessageTransformMissing:   "submit error: transform was nil",
+	MessagePositionMissing:    "cursor error: position was nil",
+	MessageMetadataMissing:    "metadata error: metadata was nil",
+	MessageCommentMissing:     "comment error: comment was nil",
+	MessageUnknownCommand:     "command not recognised",
+	MessageKicked:             "you were removed from the document",
+	MessageDocumentClosing:    "the document is closing as the server shuts down",
+	MessageDocumentDegraded:   "changes to the document are being saved slowly",
+	MessageDocumentRecovered:  "changes to the document are being saved normally again",
+}
+
+/*
+MessagesConfig - Holds the localised templates of the user facing messages sent by the server.
+Templates maps locales (such as 'fr' or 'pt-br') to templates for message codes, where '{detail}' in
+a template is replaced with the English detail of the message, such as the underlying error. The
+locale of a client is taken from its init message, or otherwise the Accept-Language header of its
+connection, and DefaultLocale is used when neither has templates. Messages without a template in
+either locale fall back to English.
+*/
+type MessagesConfig struct {
+	DefaultLocale string                       `json:"default_locale" yaml:"default_locale"`
+	Templates     map[string]map[string]string `json:"templates" yaml:"templates"`
+}
+
+/*
+NewMessagesConfig - Create a new messages config with default values.
+*/
+func NewMessagesConfig() MessagesConfig {
+	return MessagesConfig{
+		DefaultLocale: "en",
+		Templates:     map[string]map[string]string{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Messages type.
+var (
+	ErrUnknownMessageCode = errors.New("template given for unknown message code")
+)
+
+/*
+Messages - Formats the user facing messages of the server in the locale of each client.
+*/
+type Messages struct {
+	defaultLocale string
+	templates     map[string]map[string]string
+}
+
+/*
+NewMessages - Create the messages of a config, templates for codes that the server never sends are
+rejected as they are most likely a typo.
+*/
+func NewMessages(config MessagesConfig) (*Messages, error) {
+	messages := Messages{
+		defaultLocale: normaliseLocale(config.DefaultLocale),
+		templates:     map[string]map[string]string{},
+	}
+	for locale, templates := range config.Templates {
+		for code := range templates {
+			if _, ok := defaultMessageTemplates[code]; !ok {
+				return nil, fmt.Errorf("%v: %v (%v)", ErrUnknownMessageCode, code, locale)
+			}
+		}
+		messages.templates[normaliseLocale(locale)] = templates
+	}
+	return &messages, nil
+}
+
+/*
+normaliseLocale - Locales are compared in lower case, with dashes rather than underscores.
+*/
+func normaliseLocale(locale string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(locale), "_", "-", -1))
+}
+
+/*
+Negotiate - Returns the locale to use for a client, preferring an explicitly requested locale and
+then the locales of an Accept-Language header in order of their weights. Locales without templates
+are matched by their language alone, so 'fr-ca' is served 'fr' templates. Returns the default locale
+if nothing matches.
+*/
+func (m *Messages) Negotiate(requested, acceptLanguage string) string {
+	if m == nil {
+		return ""
+	}
+
+	type weighted struct {
+		locale string
+		weight float64
+	}
+	candidates := []weighted{{locale: requested, weight: 2}}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		candidate := weighted{locale: fields[0], weight: 1}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					candidate.weight = q
+				}
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].weight > candidates[j].weight
+	})
+
+	for _, candidate := range candidates {
+		if candidate.weight <= 0 {
+			continue
+		}
+		if locale, ok := m.match(normaliseLocale(candidate.locale)); ok {
+			return locale
+		}
+	}
+	return m.defaultLocale
+}
+
+/*
+match - Returns the locale with templates that best matches a normalised locale.
+*/
+func (m *Messages) match(locale string) (string, bool) {
+	if len(locale) == 0 {
+		return "", false
+	}
+	if _, ok := m.templates[locale]; ok {
+		return locale, true
+	}
+	if i := strings.Index(locale, "-"); i > 0 {
+		if _, ok := m.templates[locale[:i]]; ok {
+			return locale[:i], true
+		}
+	}
+	return "", false
+}
+
+/*
+Format - Returns the text of a message code in a locale, with any detail filled in. Messages may be
+nil, in which case the English text is returned.
+*/
+func (m *Messages) Format(locale, code, detail string) string {
+	template, ok := "", false
+	if m != nil {
+		if template, ok = m.templates[locale][code]; !ok {
+			template, ok = m.templates[m.defaultLocale][code]
+		}
+	}
+	if !ok {
+		if template, ok = defaultMessageTemplates[code]; !ok {
+			template = code
+		}
+	}
+	return strings.Replace(template, "{detail}", detail, -1)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,155 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+func TestMessages(t *testing.T) {
+	config := NewMessagesConfig()
+	config.Templates = map[string]map[string]string{
+		"fr": {
+			MessageUnknownCommand: "commande inconnue",
+			MessageSubmitFailed:   "échec de l'envoi : {detail}",
+		},
+		"PT_BR": {
+			MessageUnknownCommand: "comando desconhecido",
+		},
+	}
+	messages, err := NewMessages(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type negotiateTest struct {
+		requested, acceptLanguage, locale string
+	}
+	for _, test := range []negotiateTest{
+		{"", "", "en"},
+		{"", "fr-CA,fr;q=0.9,en;q=0.8", "fr"},
+		{"", "en;q=0.5,pt-BR", "pt-br"},
+		{"", "de,fr;q=0", "en"},
+		{"pt_BR", "fr", "pt-br"},
+		{"de", "fr", "fr"},
+	} {
+		if act := messages.Negotiate(test.requested, test.acceptLanguage); act != test.locale {
+			t.Errorf("Wrong locale for %v, %v: %v != %v", test.requested, test.acceptLanguage, act, test.locale)
+		}
+	}
+
+	type formatTest struct {
+		locale, code, detail, text string
+	}
+	for _, test := range []formatTest{
+		{"fr", MessageUnknownCommand, "", "commande inconnue"},
+		{"fr", MessageSubmitFailed, "rate limited", "échec de l'envoi : rate limited"},
+		{"fr", MessagePositionMissing, "", "cursor error: position was nil"},
+		{"en", MessageInitFailed, "nope", "socket initialization failed: nope"},
+		{"fr", "not_a_code", "", "not_a_code"},
+	} {
+		if act := messages.Format(test.locale, test.code, test.detail); act != test.text {
+			t.Errorf("Wrong text for %v in %v: %v != %v", test.code, test.locale, act, test.text)
+		}
+	}
+
+	var nilMessages *Messages
+	if act, exp := nilMessages.Format("fr", MessageUnknownCommand, ""), "command not recognised"; act != exp {
+		t.Errorf("Wrong text without messages: %v != %v", act, exp)
+	}
+
+	config.Templates["fr"]["unknown_comand"] = "typo"
+	if _, err = NewMessages(config); err == nil || !strings.Contains(err.Error(), ErrUnknownMessageCode.Error()) {
+		t.Errorf("Wrong error for unknown code: %v", err)
+	}
+}
+
+func TestLocalisedInitError(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := DefaultHTTPServerConfig()
+	config.Messages.Templates = map[string]map[string]string{
+		"fr": {MessageInitFailed: "échec de l'initialisation : {detail}"},
+		"de": {MessageInitFailed: "Initialisierung fehlgeschlagen: {detail}"},
+	}
+	messages, err := NewMessages(config.Messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := HTTPServer{
+		config:   config,
+		locator:  &fakeLocator{},
+		messages: messages,
+		logger:   logger,
+		stats:    stats,
+	}
+	server := httptest.NewServer(websocket.Handler(h.websocketHandler))
+	defer server.Close()
+
+	initError := func(acceptLanguage, locale string) LeapServerMessage {
+		wsConfig, err := websocket.NewConfig("ws"+strings.TrimPrefix(server.URL, "http"), "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		wsConfig.Header.Set("Accept-Language", acceptLanguage)
+		ws, err := websocket.DialConfig(wsConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ws.Close()
+
+		websocket.JSON.Send(ws, LeapClientMessage{Command: "find", Locale: locale})
+		var msg LeapServerMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	msg := initError("fr-FR,en;q=0.5", "")
+	if exp := "échec de l'initialisation : " + ErrInvalidDocument.Error(); msg.Error != exp {
+		t.Errorf("Wrong localised error: %v != %v", msg.Error, exp)
+	}
+	if msg.Code != MessageInitFailed {
+		t.Errorf("Wrong error code: %v", msg.Code)
+	}
+	if exp := (ErrorInfo{Code: ErrorCodeInvalidRequest}); msg.ErrorInfo == nil || *msg.ErrorInfo != exp {
+		t.Errorf("Wrong error info: %v", msg.ErrorInfo)
+	}
+
+	// A locale requested by the client wins over its headers
+	msg = initError("fr-FR,en;q=0.5", "de")
+	if exp := "Initialisierung fehlgeschlagen: " + ErrInvalidDocument.Error(); msg.Error != exp {
+		t.Errorf("Wrong requested error: %v != %v", msg.Error, exp)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
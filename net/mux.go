@@ -0,0 +1,1043 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Mux type.
+var (
+	ErrDuplicatePrefix = errors.New("prefix has already been registered")
+	ErrNoRoute         = errors.New("no locator registered for document prefix")
+	ErrAliasRoute      = errors.New("alias does not route to the locator of its document")
+)
+
+// muxLabelEscaper escapes route prefixes for use as Prometheus label values.
+var muxLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+/*
+muxRoute - A single prefix and the locator it routes to.
+*/
+type muxRoute struct {
+	prefix  string
+	locator LeapLocator
+}
+
+/*
+Mux - A LeapLocator that routes documents by their ID prefix to independent LeapLocators. This
+allows an application to embed multiple leaps instances, each with their own curator, store and
+authentication configuration, behind a single HTTPServer.
+
+The routing prefix is stripped from document IDs before they reach the underlying locator and is
+added back onto the IDs of documents returned, this means the underlying curators remain unaware of
+the Mux. When creating a document the ID of the submitted document is used purely as a routing hint,
+documents created without an ID are routed to the locator registered with an empty prefix.
+*/
+type Mux struct {
+	routes []muxRoute
+	mutex  sync.RWMutex
+
+	// The routes as they were before draining, for reporting on the outcome
+	drained []muxRoute
+}
+
+/*
+NewMux - Create a new Mux with no routes.
+*/
+func NewMux() *Mux {
+	return &Mux{}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Handle - Register a LeapLocator to handle all documents with an ID beginning with prefix. When
+multiple prefixes match a document the longest is chosen.
+*/
+func (m *Mux) Handle(prefix string, locator LeapLocator) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, route := range m.routes {
+		if route.prefix == prefix {
+			return ErrDuplicatePrefix
+		}
+	}
+	m.routes = append(m.routes, muxRoute{prefix: prefix, locator: locator})
+	sort.Sort(byPrefixLength(m.routes))
+	return nil
+}
+
+/*
+route - Find the route for a document ID.
+*/
+func (m *Mux) route(id string) (muxRoute, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	for _, route := range m.routes {
+		if strings.HasPrefix(id, route.prefix) {
+			return route, nil
+		}
+	}
+	return muxRoute{}, ErrNoRoute
+}
+
+/*
+prefixPortal - Add the route prefix back onto the document ID of a portal.
+*/
+func prefixPortal(prefix string, portal lib.BinderPortal) lib.BinderPortal {
+	portal.Document.ID = prefix + portal.Document.ID
+	return portal
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+EditDocument - Route an edit request to the locator responsible for the document.
+*/
+func (m *Mux) EditDocument(token, id string) (lib.BinderPortal, error) {
+	route, err := m.route(id)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	portal, err := route.locator.EditDocument(token, strings.TrimPrefix(id, route.prefix))
+	if err != nil {
+		return portal, err
+	}
+	return prefixPortal(route.prefix, portal), nil
+}
+
+/*
+ReadDocument - Route a read only request to the locator responsible for the document.
+*/
+func (m *Mux) ReadDocument(token, id string) (lib.BinderPortal, error) {
+	route, err := m.route(id)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	portal, err := route.locator.ReadDocument(token, strings.TrimPrefix(id, route.prefix))
+	if err != nil {
+		return portal, err
+	}
+	return prefixPortal(route.prefix, portal), nil
+}
+
+/*
+ReadDocumentThrottled - Route a throttled read only request to the locator responsible for the
+document, the locator must also implement ThrottledLocator.
+*/
+func (m *Mux) ReadDocumentThrottled(token, id string, period time.Duration) (lib.BinderPortal, error) {
+	route, err := m.route(id)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	throttled, ok := route.locator.(ThrottledLocator)
+	if !ok {
+		return lib.BinderPortal{}, lib.ErrThrottleNotSupported
+	}
+	portal, err := throttled.ReadDocumentThrottled(token, strings.TrimPrefix(id, route.prefix), period)
+	if err != nil {
+		return portal, err
+	}
+	return prefixPortal(route.prefix, portal), nil
+}
+
+/*
+ResumeDocument - Route a resumed edit request to the locator responsible for the document, locators
+that do not implement ResumeLocator are edited from scratch.
+*/
+func (m *Mux) ResumeDocument(token, id, epoch string, version int) (lib.BinderPortal, error) {
+	route, err := m.route(id)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	var portal lib.BinderPortal
+	if resumer, ok := route.locator.(ResumeLocator); ok {
+		portal, err = resumer.ResumeDocument(token, strings.TrimPrefix(id, route.prefix), epoch, version)
+	} else {
+		portal, err = route.locator.EditDocument(token, strings.TrimPrefix(id, route.prefix))
+	}
+	if err != nil {
+		return portal, err
+	}
+	return prefixPortal(route.prefix, portal), nil
+}
+
+/*
+CreateDocument - Route a create request to the locator matching the ID of the submitted document.
+*/
+func (m *Mux) CreateDocument(token, userID string, doc store.Document) (lib.BinderPortal, error) {
+	route, err := m.route(doc.ID)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	doc.ID = strings.TrimPrefix(doc.ID, route.prefix)
+
+	portal, err := route.locator.CreateDocument(token, userID, doc)
+	if err != nil {
+		return portal, err
+	}
+	return prefixPortal(route.prefix, portal), nil
+}
+
+/*
+Close - Close all registered locators.
+*/
+func (m *Mux) Close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, route := range m.routes {
+		route.locator.Close()
+	}
+	m.routes = nil
+}
+
+/*
+Drain - Drain all registered locators concurrently, closing those that do not support draining.
+Returns the first error encountered.
+*/
+func (m *Mux) Drain(grace time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	errChan := make(chan error, len(m.routes))
+	for _, route := range m.routes {
+		go func(locator LeapLocator) {
+			if drainer, ok := locator.(Drainer); ok {
+				errChan <- drainer.Drain(grace)
+				return
+			}
+			locator.Close()
+			errChan <- nil
+		}(route.locator)
+	}
+
+	var err error
+	for range m.routes {
+		if rErr := <-errChan; rErr != nil && err == nil {
+			err = rErr
+		}
+	}
+	m.drained, m.routes = m.routes, nil
+	return err
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+KickUser - Route a kick request to the locator responsible for the document, the locator must also
+implement LeapAdmin.
+*/
+func (m *Mux) KickUser(documentID, userID string, timeout time.Duration) error {
+	route, err := m.route(documentID)
+	if err != nil {
+		return err
+	}
+	admin, ok := route.locator.(LeapAdmin)
+	if !ok {
+		return ErrNoRoute
+	}
+	return admin.KickUser(strings.TrimPrefix(documentID, route.prefix), userID, timeout)
+}
+
+/*
+BanUser - Route a ban request to the locator responsible for the document, the locator must also
+implement UserBanner.
+*/
+func (m *Mux) BanUser(documentID, userID string, duration, timeout time.Duration) error {
+	route, err := m.route(documentID)
+	if err != nil {
+		return err
+	}
+	banner, ok := route.locator.(UserBanner)
+	if !ok {
+		return ErrNoRoute
+	}
+	return banner.BanUser(strings.TrimPrefix(documentID, route.prefix), userID, duration, timeout)
+}
+
+/*
+Announce - Send an announcement through every registered locator that implements Announcer. Returns
+the first error encountered, although every locator is announced to regardless.
+*/
+func (m *Mux) Announce(announcement lib.Announcement, timeout time.Duration) error {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	var err error
+	for _, route := range routes {
+		announcer, ok := route.locator.(Announcer)
+		if !ok {
+			continue
+		}
+		if aErr := announcer.Announce(announcement, timeout); aErr != nil && err == nil {
+			err = aErr
+		}
+	}
+	return err
+}
+
+/*
+InspectBinders - Collect the binder summaries of every registered locator that implements
+BinderInspector, with document IDs carrying the prefix of their route.
+*/
+func (m *Mux) InspectBinders(timeout time.Duration) ([]lib.BinderInfo, error) {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	infos := []lib.BinderInfo{}
+	for _, route := range routes {
+		inspector, ok := route.locator.(BinderInspector)
+		if !ok {
+			continue
+		}
+		routeInfos, err := inspector.InspectBinders(timeout)
+		if err != nil {
+			return infos, err
+		}
+		for _, info := range routeInfos {
+			info.ID = route.prefix + info.ID
+			infos = append(infos, info)
+		}
+	}
+	return infos, nil
+}
+
+/*
+InspectBinder - Route a binder inspection to the locator responsible for the document, the locator
+must also implement BinderInspector.
+*/
+func (m *Mux) InspectBinder(documentID string, timeout time.Duration) (lib.BinderInfo, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return lib.BinderInfo{}, err
+	}
+	inspector, ok := route.locator.(BinderInspector)
+	if !ok {
+		return lib.BinderInfo{}, ErrNoRoute
+	}
+	info, err := inspector.InspectBinder(strings.TrimPrefix(documentID, route.prefix), timeout)
+	if err == nil {
+		info.ID = documentID
+	}
+	return info, err
+}
+
+/*
+FlushDocument - Route a flush to the locator responsible for the document, the locator must also
+implement BinderController.
+*/
+func (m *Mux) FlushDocument(documentID string, timeout time.Duration) (int, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return 0, err
+	}
+	controller, ok := route.locator.(BinderController)
+	if !ok {
+		return 0, ErrNoRoute
+	}
+	return controller.FlushDocument(strings.TrimPrefix(documentID, route.prefix), timeout)
+}
+
+/*
+CloseDocument - Route the closing of a document to the locator responsible for it, the locator must
+also implement BinderController.
+*/
+func (m *Mux) CloseDocument(documentID string) error {
+	route, err := m.route(documentID)
+	if err != nil {
+		return err
+	}
+	controller, ok := route.locator.(BinderController)
+	if !ok {
+		return ErrNoRoute
+	}
+	return controller.CloseDocument(strings.TrimPrefix(documentID, route.prefix))
+}
+
+/*
+ExportDocument - Route an export to the locator responsible for the document, the locator must also
+implement DocumentExporter.
+*/
+func (m *Mux) ExportDocument(documentID string, timeout time.Duration) (lib.DocumentExport, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return lib.DocumentExport{}, err
+	}
+	exporter, ok := route.locator.(DocumentExporter)
+	if !ok {
+		return lib.DocumentExport{}, ErrNoRoute
+	}
+	export, err := exporter.ExportDocument(strings.TrimPrefix(documentID, route.prefix), timeout)
+	if err == nil {
+		export.Document.ID = documentID
+	}
+	return export, err
+}
+
+/*
+InspectLease - Route a lease inspection to the locator responsible for the document, the locator
+must also implement LeaseBreaker.
+*/
+func (m *Mux) InspectLease(documentID string) (lib.LeaseInfo, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return lib.LeaseInfo{}, err
+	}
+	breaker, ok := route.locator.(LeaseBreaker)
+	if !ok {
+		return lib.LeaseInfo{}, ErrNoRoute
+	}
+	info, err := breaker.InspectLease(strings.TrimPrefix(documentID, route.prefix))
+	info.DocumentID = documentID
+	return info, err
+}
+
+/*
+BreakLease - Route a lease break to the locator responsible for the document, the locator must also
+implement LeaseBreaker.
+*/
+func (m *Mux) BreakLease(documentID, node, fingerprint string) error {
+	route, err := m.route(documentID)
+	if err != nil {
+		return err
+	}
+	breaker, ok := route.locator.(LeaseBreaker)
+	if !ok {
+		return ErrNoRoute
+	}
+	return breaker.BreakLease(strings.TrimPrefix(documentID, route.prefix), node, fingerprint)
+}
+
+/*
+GetPreference - Route a preference request to the locator responsible for the document, the locator
+must also implement PreferenceLocator.
+*/
+func (m *Mux) GetPreference(token, documentID string) (lib.NotificationPreference, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return lib.NotificationPreference{}, err
+	}
+	locator, ok := route.locator.(PreferenceLocator)
+	if !ok {
+		return lib.NotificationPreference{}, ErrNoRoute
+	}
+	return locator.GetPreference(token, strings.TrimPrefix(documentID, route.prefix))
+}
+
+/*
+SetPreference - Route a preference update to the locator responsible for the document, the locator
+must also implement PreferenceLocator.
+*/
+func (m *Mux) SetPreference(token, documentID string, pref lib.NotificationPreference) error {
+	route, err := m.route(documentID)
+	if err != nil {
+		return err
+	}
+	locator, ok := route.locator.(PreferenceLocator)
+	if !ok {
+		return ErrNoRoute
+	}
+	return locator.SetPreference(token, strings.TrimPrefix(documentID, route.prefix), pref)
+}
+
+/*
+GetDocumentVersion - Route a version request to the locator responsible for the document, the
+locator must also implement VersionLocator.
+*/
+func (m *Mux) GetDocumentVersion(token, documentID string, version int, timeout time.Duration) (store.Document, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return store.Document{}, err
+	}
+	locator, ok := route.locator.(VersionLocator)
+	if !ok {
+		return store.Document{}, ErrNoRoute
+	}
+	doc, err := locator.GetDocumentVersion(token, strings.TrimPrefix(documentID, route.prefix), version, timeout)
+	if err == nil {
+		doc.ID = documentID
+	}
+	return doc, err
+}
+
+/*
+GetDocument - Route a document read to the locator responsible for the document, the locator must
+also implement DocumentAdmin.
+*/
+func (m *Mux) GetDocument(documentID string) (store.Document, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return store.Document{}, err
+	}
+	admin, ok := route.locator.(DocumentAdmin)
+	if !ok {
+		return store.Document{}, ErrNoRoute
+	}
+	doc, err := admin.GetDocument(strings.TrimPrefix(documentID, route.prefix))
+	if err == nil {
+		doc.ID = documentID
+	}
+	return doc, err
+}
+
+/*
+GetLiveDocument - Route a live document read to the locator responsible for the document, the
+locator must also implement LiveDocumentReader.
+*/
+func (m *Mux) GetLiveDocument(documentID string, timeout time.Duration) (store.Document, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return store.Document{}, err
+	}
+	reader, ok := route.locator.(LiveDocumentReader)
+	if !ok {
+		return store.Document{}, ErrNoRoute
+	}
+	doc, err := reader.GetLiveDocument(strings.TrimPrefix(documentID, route.prefix), timeout)
+	if err == nil {
+		doc.ID = documentID
+	}
+	return doc, err
+}
+
+/*
+PutDocument - Route a document write to the locator responsible for the document, the locator must
+also implement DocumentAdmin.
+*/
+func (m *Mux) PutDocument(doc store.Document) error {
+	route, err := m.route(doc.ID)
+	if err != nil {
+		return err
+	}
+	admin, ok := route.locator.(DocumentAdmin)
+	if !ok {
+		return ErrNoRoute
+	}
+	doc.ID = strings.TrimPrefix(doc.ID, route.prefix)
+	return admin.PutDocument(doc)
+}
+
+/*
+DeleteDocument - Route a document deletion to the locator responsible for the document, the locator
+must also implement DocumentAdmin.
+*/
+func (m *Mux) DeleteDocument(documentID string) error {
+	route, err := m.route(documentID)
+	if err != nil {
+		return err
+	}
+	admin, ok := route.locator.(DocumentAdmin)
+	if !ok {
+		return ErrNoRoute
+	}
+	return admin.DeleteDocument(strings.TrimPrefix(documentID, route.prefix))
+}
+
+/*
+MergeDocument - Route a merge to the locator responsible for the document, the locator must also
+implement DocumentMerger.
+*/
+func (m *Mux) MergeDocument(doc store.Document, timeout time.Duration) (store.Document, error) {
+	route, err := m.route(doc.ID)
+	if err != nil {
+		return store.Document{}, err
+	}
+	merger, ok := route.locator.(DocumentMerger)
+	if !ok {
+		return store.Document{}, ErrNoRoute
+	}
+	id := doc.ID
+	doc.ID = strings.TrimPrefix(doc.ID, route.prefix)
+	merged, err := merger.MergeDocument(doc, timeout)
+	if err == nil {
+		merged.ID = id
+	}
+	return merged, err
+}
+
+/*
+SetAlias - Route an alias to the locator responsible for the document, the locator must also
+implement AliasAdmin. Clients join by alias in place of the document ID, and so the alias must
+route to the same locator as the document.
+*/
+func (m *Mux) SetAlias(alias, documentID string) error {
+	route, err := m.route(documentID)
+	if err != nil {
+		return err
+	}
+	if aliasRoute, err := m.route(alias); err != nil || aliasRoute.prefix != route.prefix {
+		return ErrAliasRoute
+	}
+	admin, ok := route.locator.(AliasAdmin)
+	if !ok {
+		return ErrNoRoute
+	}
+	return admin.SetAlias(
+		strings.TrimPrefix(alias, route.prefix), strings.TrimPrefix(documentID, route.prefix),
+	)
+}
+
+/*
+RemoveAlias - Route the removal of an alias to the locator it routes to, the locator must also
+implement AliasAdmin.
+*/
+func (m *Mux) RemoveAlias(alias string) error {
+	route, err := m.route(alias)
+	if err != nil {
+		return err
+	}
+	admin, ok := route.locator.(AliasAdmin)
+	if !ok {
+		return ErrNoRoute
+	}
+	return admin.RemoveAlias(strings.TrimPrefix(alias, route.prefix))
+}
+
+/*
+ReadRecording - Route a recording request to the locator responsible for the document, the locator
+must also implement RecordingLocator.
+*/
+func (m *Mux) ReadRecording(token, documentID string) (lib.Recording, error) {
+	route, err := m.route(documentID)
+	if err != nil {
+		return lib.Recording{}, err
+	}
+	locator, ok := route.locator.(RecordingLocator)
+	if !ok {
+		return lib.Recording{}, ErrNoRoute
+	}
+	recording, err := locator.ReadRecording(token, strings.TrimPrefix(documentID, route.prefix))
+	for i := range recording.Bindings {
+		recording.Bindings[i].Document.ID = documentID
+	}
+	return recording, err
+}
+
+/*
+GetUsers - Collect the users of all registered locators that implement LeapAdmin, document IDs are
+returned with their route prefixes. The timeout is shared by all locators, and lib.ErrTimeout is
+returned along with the users collected so far once it runs out.
+*/
+func (m *Mux) GetUsers(timeout time.Duration) (map[string][]string, error) {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	started := time.Now()
+
+	list := map[string][]string{}
+	for _, route := range routes {
+		admin, ok := route.locator.(LeapAdmin)
+		if !ok {
+			continue
+		}
+		remaining := timeout - time.Since(started)
+		if remaining <= 0 {
+			return list, lib.ErrTimeout
+		}
+		users, err := admin.GetUsers(remaining)
+		if err != nil {
+			return list, err
+		}
+		for id, docUsers := range users {
+			list[route.prefix+id] = docUsers
+		}
+	}
+	return list, nil
+}
+
+/*
+ListDocuments - Collect the documents of all registered locators that implement DocumentLister,
+document IDs are returned with their route prefixes. Locators whose stores cannot list documents are
+skipped, and lib.ErrStoreNotListable is returned when none of them can.
+*/
+func (m *Mux) ListDocuments() ([]lib.DocumentSummary, error) {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	listed := false
+	summaries := []lib.DocumentSummary{}
+	for _, route := range routes {
+		lister, ok := route.locator.(DocumentLister)
+		if !ok {
+			continue
+		}
+		docs, err := lister.ListDocuments()
+		if err == lib.ErrStoreNotListable {
+			continue
+		}
+		if err != nil {
+			return summaries, err
+		}
+		listed = true
+		for _, doc := range docs {
+			doc.ID = route.prefix + doc.ID
+			summaries = append(summaries, doc)
+		}
+	}
+	if !listed {
+		return nil, lib.ErrStoreNotListable
+	}
+	return summaries, nil
+}
+
+/*
+ListAliases - Collect the aliases of all registered locators that implement AliasAdmin, aliases and
+document IDs are returned with their route prefixes.
+*/
+func (m *Mux) ListAliases() map[string]string {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	aliases := map[string]string{}
+	for _, route := range routes {
+		admin, ok := route.locator.(AliasAdmin)
+		if !ok {
+			continue
+		}
+		for alias, id := range admin.ListAliases() {
+			aliases[route.prefix+alias] = route.prefix + id
+		}
+	}
+	return aliases
+}
+
+/*
+GetLatencyReport - Merge the latency reports of all registered locators that implement
+LatencyReporter, document IDs are returned with their route prefixes. Percentiles cannot be combined
+exactly, and so the global percentiles are the highest of any locator, over all of their samples.
+*/
+func (m *Mux) GetLatencyReport() lib.LatencyReport {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	merged := lib.LatencyReport{Documents: map[string]lib.LatencyPercentiles{}}
+	for _, route := range routes {
+		reporter, ok := route.locator.(LatencyReporter)
+		if !ok {
+			continue
+		}
+		report := reporter.GetLatencyReport()
+		merged.Global.Samples += report.Global.Samples
+		merged.Global.P50 = math.Max(merged.Global.P50, report.Global.P50)
+		merged.Global.P95 = math.Max(merged.Global.P95, report.Global.P95)
+		merged.Global.P99 = math.Max(merged.Global.P99, report.Global.P99)
+		for id, percentiles := range report.Documents {
+			merged.Documents[route.prefix+id] = percentiles
+		}
+	}
+	return merged
+}
+
+/*
+WriteMetrics - Write the metrics of all registered locators that implement MetricsReporter in the
+Prometheus text exposition format. The samples of each metric family are grouped beneath a single
+set of HELP and TYPE lines, with a route label added to tell apart the locators they came from.
+*/
+func (m *Mux) WriteMetrics(w io.Writer) error {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	type metricFamily struct {
+		headers []string
+		samples []string
+	}
+	families := map[string]*metricFamily{}
+	order := []string{}
+	getFamily := func(name string) *metricFamily {
+		family, exists := families[name]
+		if !exists {
+			family = &metricFamily{}
+			families[name] = family
+			order = append(order, name)
+		}
+		return family
+	}
+
+	for _, route := range routes {
+		reporter, ok := route.locator.(MetricsReporter)
+		if !ok {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := reporter.WriteMetrics(&buf); err != nil {
+			return err
+		}
+		label := fmt.Sprintf("route=\"%v\"", muxLabelEscaper.Replace(route.prefix))
+		described := map[string]bool{}
+
+		current := ""
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if len(strings.TrimSpace(line)) == 0 {
+				continue
+			}
+			if strings.HasPrefix(line, "#") {
+				fields := strings.Fields(line)
+				if len(fields) >= 3 && (fields[1] == "HELP" || fields[1] == "TYPE") {
+					current = fields[2]
+					family := getFamily(current)
+					// Only the first locator to describe a family has its headers kept.
+					if len(family.headers) == 0 || described[current] {
+						family.headers = append(family.headers, line)
+						described[current] = true
+					}
+				}
+				continue
+			}
+			family := getFamily(current)
+			if i := strings.IndexAny(line, "{ "); i >= 0 && line[i] == '{' {
+				if strings.HasPrefix(line[i+1:], "}") {
+					line = line[:i+1] + label + line[i+1:]
+				} else {
+					line = line[:i+1] + label + "," + line[i+1:]
+				}
+			} else if i >= 0 {
+				line = line[:i] + "{" + label + "}" + line[i:]
+			}
+			family.samples = append(family.samples, line)
+		}
+	}
+
+	for _, name := range order {
+		family := families[name]
+		for _, line := range append(family.headers, family.samples...) {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/*
+GetClusterStatus - Merge the cluster status of all registered locators that implement
+ClusterReporter and are part of a cluster. The node and protocol are those of the first such locator,
+peers heard by several locators are listed once with their most recent sighting, and the cluster is
+mixed when any peer or locator speaks a different protocol.
+*/
+func (m *Mux) GetClusterStatus() (lib.ClusterStatus, error) {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	var merged lib.ClusterStatus
+	reported := false
+	peers := map[string]lib.RelayPeer{}
+	for _, route := range routes {
+		reporter, ok := route.locator.(ClusterReporter)
+		if !ok {
+			continue
+		}
+		status, err := reporter.GetClusterStatus()
+		if err == lib.ErrClusterDisabled {
+			continue
+		}
+		if err != nil {
+			return lib.ClusterStatus{}, err
+		}
+		if !reported {
+			merged.Node, merged.Protocol = status.Node, status.Protocol
+			reported = true
+		}
+		if status.Mixed || status.Protocol != merged.Protocol {
+			merged.Mixed = true
+		}
+		for _, peer := range status.Peers {
+			if existing, exists := peers[peer.Node]; !exists || peer.LastSeen.After(existing.LastSeen) {
+				peers[peer.Node] = peer
+			}
+		}
+	}
+	if !reported {
+		return lib.ClusterStatus{}, lib.ErrClusterDisabled
+	}
+
+	merged.Peers = make([]lib.RelayPeer, 0, len(peers))
+	for _, peer := range peers {
+		merged.Peers = append(merged.Peers, peer)
+	}
+	sort.Slice(merged.Peers, func(i, j int) bool {
+		return merged.Peers[i].Node < merged.Peers[j].Node
+	})
+	return merged, nil
+}
+
+/*
+GetRecoveryReport - Merge the recovery reports of all registered locators that implement
+RecoveryReporter, document IDs are returned with their route prefixes.
+*/
+func (m *Mux) GetRecoveryReport() lib.RecoveryReport {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	merged := lib.RecoveryReport{
+		Recovered: []string{},
+		Failed:    []lib.RecoveryFailure{},
+	}
+	for _, route := range routes {
+		reporter, ok := route.locator.(RecoveryReporter)
+		if !ok {
+			continue
+		}
+		report := reporter.GetRecoveryReport()
+		if merged.Started.IsZero() || (!report.Started.IsZero() && report.Started.Before(merged.Started)) {
+			merged.Started = report.Started
+		}
+		for _, id := range report.Recovered {
+			merged.Recovered = append(merged.Recovered, route.prefix+id)
+		}
+		for _, failure := range report.Failed {
+			failure.ID = route.prefix + failure.ID
+			merged.Failed = append(merged.Failed, failure)
+		}
+	}
+	return merged
+}
+
+/*
+Capabilities - The capabilities that all registered locators implementing CapabilityReporter have in
+common, since a client cannot know ahead of time which of them its document is routed to.
+*/
+func (m *Mux) Capabilities() lib.Capabilities {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.routes))
+	copy(routes, m.routes)
+	m.mutex.RUnlock()
+
+	var merged *lib.Capabilities
+	for _, route := range routes {
+		reporter, ok := route.locator.(CapabilityReporter)
+		if !ok {
+			continue
+		}
+		capabilities := reporter.Capabilities()
+		if merged != nil {
+			capabilities = merged.Restrict(capabilities)
+		}
+		merged = &capabilities
+	}
+	if merged == nil {
+		return lib.Capabilities{}
+	}
+	return *merged
+}
+
+/*
+GetShutdownReport - Merge the shutdown reports of all registered locators that implement
+ShutdownReporter, document IDs are returned with their route prefixes. The boolean is false until
+the Mux has been drained.
+*/
+func (m *Mux) GetShutdownReport() (lib.ShutdownReport, bool) {
+	m.mutex.RLock()
+	routes := make([]muxRoute, len(m.drained))
+	copy(routes, m.drained)
+	m.mutex.RUnlock()
+
+	merged := lib.ShutdownReport{
+		Flushed:             []string{},
+		Unflushed:           []lib.UnflushedDocument{},
+		DisconnectedClients: map[string][]string{},
+	}
+	drained := false
+	for _, route := range routes {
+		reporter, ok := route.locator.(ShutdownReporter)
+		if !ok {
+			continue
+		}
+		report, ok := reporter.GetShutdownReport()
+		if !ok {
+			return lib.ShutdownReport{}, false
+		}
+		drained = true
+		if merged.Started.IsZero() || report.Started.Before(merged.Started) {
+			merged.Started = report.Started
+		}
+		if report.Finished.After(merged.Finished) {
+			merged.Finished = report.Finished
+		}
+		for _, id := range report.Flushed {
+			merged.Flushed = append(merged.Flushed, route.prefix+id)
+		}
+		for _, doc := range report.Unflushed {
+			doc.ID = route.prefix + doc.ID
+			merged.Unflushed = append(merged.Unflushed, doc)
+		}
+		for id, users := range report.DisconnectedClients {
+			merged.DisconnectedClients[route.prefix+id] = users
+		}
+	}
+	merged.DrainDuration = int64(merged.Finished.Sub(merged.Started) / time.Millisecond)
+	return merged, drained
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+byPrefixLength - Sorts routes so that the longest prefixes come first.
+*/
+type byPrefixLength []muxRoute
+
+func (b byPrefixLength) Len() int           { return len(b) }
+func (b byPrefixLength) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byPrefixLength) Less(i, j int) bool { return len(b[i].prefix) > len(b[j].prefix) }
+
+/*--------------------------------------------------------------------------------------------------
+ */
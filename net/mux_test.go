@@ -0,0 +1,561 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+type fakeLocator struct {
+	name   string
+	closed bool
+}
+
+func (f *fakeLocator) EditDocument(token, id string) (lib.BinderPortal, error) {
+	return lib.BinderPortal{Token: f.name, Document: store.Document{ID: id}}, nil
+}
+
+func (f *fakeLocator) ReadDocument(token, id string) (lib.BinderPortal, error) {
+	return lib.BinderPortal{Token: f.name, Document: store.Document{ID: id}}, nil
+}
+
+func (f *fakeLocator) CreateDocument(token, userID string, doc store.Document) (lib.BinderPortal, error) {
+	return lib.BinderPortal{Token: f.name, Document: store.Document{ID: "generated"}}, nil
+}
+
+func (f *fakeLocator) Close() {
+	f.closed = true
+}
+
+func TestMuxRouting(t *testing.T) {
+	mux := NewMux()
+
+	root, app, appSub := &fakeLocator{name: "root"}, &fakeLocator{name: "app"}, &fakeLocator{name: "appSub"}
+	if err := mux.Handle("", root); err != nil {
+		t.Errorf("Handle error: %v", err)
+	}
+	if err := mux.Handle("app/", app); err != nil {
+		t.Errorf("Handle error: %v", err)
+	}
+	if err := mux.Handle("app/sub/", appSub); err != nil {
+		t.Errorf("Handle error: %v", err)
+	}
+	if err := mux.Handle("app/", app); err != ErrDuplicatePrefix {
+		t.Errorf("Expected duplicate prefix error, received: %v", err)
+	}
+
+	type routeTest struct {
+		id, locator, resultID string
+	}
+	for _, test := range []routeTest{
+		{"foo", "root", "foo"},
+		{"app/foo", "app", "app/foo"},
+		{"app/sub/foo", "appSub", "app/sub/foo"},
+	} {
+		portal, err := mux.EditDocument("", test.id)
+		if err != nil {
+			t.Errorf("Edit error: %v", err)
+			continue
+		}
+		if portal.Token != test.locator {
+			t.Errorf("Wrong locator for %v: %v != %v", test.id, portal.Token, test.locator)
+		}
+		if portal.Document.ID != test.resultID {
+			t.Errorf("Wrong document ID: %v != %v", portal.Document.ID, test.resultID)
+		}
+	}
+
+	portal, err := mux.CreateDocument("", "", store.Document{ID: "app/"})
+	if err != nil {
+		t.Errorf("Create error: %v", err)
+	} else if portal.Document.ID != "app/generated" {
+		t.Errorf("Wrong created document ID: %v", portal.Document.ID)
+	}
+
+	mux.Close()
+	if !root.closed || !app.closed || !appSub.closed {
+		t.Errorf("Not all locators were closed")
+	}
+}
+
+type fakeDrainLocator struct {
+	fakeLocator
+	grace time.Duration
+}
+
+func (f *fakeDrainLocator) Drain(grace time.Duration) error {
+	f.grace = grace
+	return errors.New("flush failed")
+}
+
+func (f *fakeDrainLocator) GetShutdownReport() (lib.ShutdownReport, bool) {
+	if f.grace == 0 {
+		return lib.ShutdownReport{}, false
+	}
+	return lib.ShutdownReport{
+		Flushed:             []string{"foo"},
+		Unflushed:           []lib.UnflushedDocument{{ID: "bar", Error: "flush failed"}},
+		DisconnectedClients: map[string][]string{"foo": {"user"}},
+	}, true
+}
+
+func TestMuxDrain(t *testing.T) {
+	mux := NewMux()
+
+	root, app := &fakeLocator{name: "root"}, &fakeDrainLocator{fakeLocator: fakeLocator{name: "app"}}
+	if err := mux.Handle("", root); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Handle("app/", app); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := mux.GetShutdownReport(); ok {
+		t.Error("Shutdown report was available before draining")
+	}
+	if err := mux.Drain(time.Second); err == nil || err.Error() != "flush failed" {
+		t.Errorf("Wrong drain error: %v", err)
+	}
+	if !root.closed {
+		t.Errorf("Locator without drain support was not closed")
+	}
+	if app.grace != time.Second || app.closed {
+		t.Errorf("Locator was not drained: %v %v", app.grace, app.closed)
+	}
+
+	report, ok := mux.GetShutdownReport()
+	if !ok {
+		t.Fatal("Shutdown report was not available after draining")
+	}
+	if exp, act := []string{"app/foo"}, report.Flushed; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong flushed documents: %v != %v", exp, act)
+	}
+	if len(report.Unflushed) != 1 || report.Unflushed[0].ID != "app/bar" {
+		t.Errorf("Wrong unflushed documents: %v", report.Unflushed)
+	}
+	if _, ok := report.DisconnectedClients["app/foo"]; !ok {
+		t.Errorf("Wrong disconnected clients: %v", report.DisconnectedClients)
+	}
+}
+
+type fakeCapabilityLocator struct {
+	fakeLocator
+	capabilities lib.Capabilities
+}
+
+func (f *fakeCapabilityLocator) Capabilities() lib.Capabilities {
+	return f.capabilities
+}
+
+func TestMuxCapabilities(t *testing.T) {
+	mux := NewMux()
+
+	if exp, act := (lib.Capabilities{}), mux.Capabilities(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong capabilities without reporters: %v != %v", exp, act)
+	}
+
+	root := &fakeCapabilityLocator{capabilities: lib.Capabilities{
+		DocumentTypes: []string{"text", "json"}, MaxDocumentSize: 1000, HistoryLength: 10, Presence: true,
+	}}
+	app := &fakeCapabilityLocator{capabilities: lib.Capabilities{
+		DocumentTypes: []string{"text"}, MaxDocumentSize: 500, HistoryLength: 20, Presence: true,
+	}}
+	for prefix, locator := range map[string]LeapLocator{"": root, "app/": app, "other/": &fakeLocator{}} {
+		if err := mux.Handle(prefix, locator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exp := lib.Capabilities{
+		DocumentTypes: []string{"text"}, MaxDocumentSize: 500, HistoryLength: 10, Presence: true,
+	}
+	if act := mux.Capabilities(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong capabilities: %v != %v", exp, act)
+	}
+}
+
+type fakeDocumentLocator struct {
+	fakeLocator
+	docs map[string]store.Document
+}
+
+func (f *fakeDocumentLocator) GetDocument(id string) (store.Document, error) {
+	doc, ok := f.docs[id]
+	if !ok {
+		return doc, store.ErrDocumentNotExist
+	}
+	return doc, nil
+}
+
+func (f *fakeDocumentLocator) PutDocument(doc store.Document) error {
+	f.docs[doc.ID] = doc
+	return nil
+}
+
+func (f *fakeDocumentLocator) DeleteDocument(id string) error {
+	delete(f.docs, id)
+	return nil
+}
+
+func (f *fakeDocumentLocator) ListDocuments() ([]lib.DocumentSummary, error) {
+	summaries := []lib.DocumentSummary{}
+	for id := range f.docs {
+		summaries = append(summaries, lib.DocumentSummary{ID: id})
+	}
+	return summaries, nil
+}
+
+func (f *fakeDocumentLocator) MergeDocument(doc store.Document, timeout time.Duration) (store.Document, error) {
+	doc.Content = f.docs[doc.ID].Content + doc.Content
+	f.docs[doc.ID] = doc
+	return doc, nil
+}
+
+func (f *fakeDocumentLocator) GetLiveDocument(id string, timeout time.Duration) (store.Document, error) {
+	doc, err := f.GetDocument(id)
+	doc.Content += " (live)"
+	return doc, err
+}
+
+func TestMuxDocumentAdmin(t *testing.T) {
+	mux := NewMux()
+
+	app := &fakeDocumentLocator{docs: map[string]store.Document{}}
+	for prefix, locator := range map[string]LeapLocator{"": &fakeLocator{}, "app/": app} {
+		if err := mux.Handle(prefix, locator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.PutDocument(store.Document{ID: "app/doc", Content: "hello world"}); err != nil {
+		t.Fatal(err)
+	}
+	if doc, ok := app.docs["doc"]; !ok || doc.ID != "doc" {
+		t.Errorf("Document was not put without the route prefix: %v", app.docs)
+	}
+	if doc, err := mux.GetDocument("app/doc"); err != nil || doc.ID != "app/doc" || doc.Content != "hello world" {
+		t.Errorf("Wrong document: %v, %v", doc, err)
+	}
+	if doc, err := mux.GetLiveDocument("app/doc", time.Second); err != nil || doc.ID != "app/doc" || doc.Content != "hello world (live)" {
+		t.Errorf("Wrong live document: %v, %v", doc, err)
+	}
+	if _, err := mux.GetDocument("doc"); err != ErrNoRoute {
+		t.Errorf("Expected no route to a locator without DocumentAdmin, received: %v", err)
+	}
+	if doc, err := mux.MergeDocument(store.Document{ID: "app/doc", Content: "!"}, time.Second); err != nil ||
+		doc.ID != "app/doc" || doc.Content != "hello world!" {
+		t.Errorf("Wrong merged document: %v, %v", doc, err)
+	}
+	if docs, err := mux.ListDocuments(); err != nil || len(docs) != 1 || docs[0].ID != "app/doc" {
+		t.Errorf("Wrong listed documents: %v, %v", docs, err)
+	}
+	if err := mux.DeleteDocument("app/doc"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mux.GetDocument("app/doc"); err != store.ErrDocumentNotExist {
+		t.Errorf("Expected deleted document, received: %v", err)
+	}
+}
+
+type fakeRecordingLocator struct {
+	fakeLocator
+}
+
+func (f *fakeRecordingLocator) ReadRecording(token, id string) (lib.Recording, error) {
+	return lib.Recording{Bindings: []lib.RecordingBinding{
+		{Document: store.Document{ID: id}},
+	}}, nil
+}
+
+func TestMuxReadRecording(t *testing.T) {
+	mux := NewMux()
+	if err := mux.Handle("app/", &fakeRecordingLocator{}); err != nil {
+		t.Fatal(err)
+	}
+
+	recording, err := mux.ReadRecording("", "app/doc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := "app/doc", recording.Bindings[0].Document.ID; exp != act {
+		t.Errorf("Wrong recorded document ID: %v != %v", exp, act)
+	}
+	if _, err = mux.ReadRecording("", "doc"); err != ErrNoRoute {
+		t.Errorf("Expected no route, received: %v", err)
+	}
+}
+
+type fakeAliasLocator struct {
+	fakeLocator
+	aliases map[string]string
+}
+
+func (f *fakeAliasLocator) SetAlias(alias, id string) error {
+	f.aliases[alias] = id
+	return nil
+}
+
+func (f *fakeAliasLocator) RemoveAlias(alias string) error {
+	delete(f.aliases, alias)
+	return nil
+}
+
+func (f *fakeAliasLocator) ListAliases() map[string]string {
+	return f.aliases
+}
+
+func TestMuxAliases(t *testing.T) {
+	mux := NewMux()
+
+	app := &fakeAliasLocator{aliases: map[string]string{}}
+	for prefix, locator := range map[string]LeapLocator{"": &fakeLocator{}, "app/": app} {
+		if err := mux.Handle(prefix, locator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.SetAlias("app/nice", "app/doc"); err != nil {
+		t.Fatal(err)
+	}
+	if exp, act := map[string]string{"nice": "doc"}, app.aliases; !reflect.DeepEqual(exp, act) {
+		t.Errorf("Alias was not set without the route prefix: %v != %v", exp, act)
+	}
+	if exp, act := map[string]string{"app/nice": "app/doc"}, mux.ListAliases(); !reflect.DeepEqual(exp, act) {
+		t.Errorf("Wrong aliases: %v != %v", exp, act)
+	}
+	if err := mux.SetAlias("nice", "app/doc"); err != ErrAliasRoute {
+		t.Errorf("Expected alias of another route to be refused, received: %v", err)
+	}
+	if err := mux.RemoveAlias("app/nice"); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.aliases) != 0 {
+		t.Errorf("Alias was not removed: %v", app.aliases)
+	}
+}
+
+type fakeLatencyLocator struct {
+	fakeLocator
+	report lib.LatencyReport
+}
+
+func (f *fakeLatencyLocator) GetLatencyReport() lib.LatencyReport {
+	return f.report
+}
+
+func TestMuxLatencyReport(t *testing.T) {
+	mux := NewMux()
+
+	root := &fakeLatencyLocator{report: lib.LatencyReport{
+		Global:    lib.LatencyPercentiles{Samples: 10, P50: 1, P95: 8, P99: 9},
+		Documents: map[string]lib.LatencyPercentiles{"doc": {Samples: 10, P50: 1, P95: 8, P99: 9}},
+	}}
+	app := &fakeLatencyLocator{report: lib.LatencyReport{
+		Global:    lib.LatencyPercentiles{Samples: 5, P50: 2, P95: 3, P99: 4},
+		Documents: map[string]lib.LatencyPercentiles{"doc": {Samples: 5, P50: 2, P95: 3, P99: 4}},
+	}}
+	for prefix, locator := range map[string]LeapLocator{"": root, "app/": app, "other/": &fakeLocator{}} {
+		if err := mux.Handle(prefix, locator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := mux.GetLatencyReport()
+	if exp, act := (lib.LatencyPercentiles{Samples: 15, P50: 2, P95: 8, P99: 9}), report.Global; exp != act {
+		t.Errorf("Wrong global percentiles: %v != %v", exp, act)
+	}
+	if exp, act := app.report.Documents["doc"], report.Documents["app/doc"]; exp != act {
+		t.Errorf("Wrong document percentiles: %v != %v", exp, act)
+	}
+	if len(report.Documents) != 2 {
+		t.Errorf("Wrong documents: %v", report.Documents)
+	}
+}
+
+type fakeMetricsLocator struct {
+	fakeLocator
+	metrics string
+}
+
+func (f *fakeMetricsLocator) WriteMetrics(w io.Writer) error {
+	_, err := io.WriteString(w, f.metrics)
+	return err
+}
+
+func TestMuxWriteMetrics(t *testing.T) {
+	mux := NewMux()
+
+	root := &fakeMetricsLocator{metrics: `# HELP leaps_documents Open documents.
+# TYPE leaps_documents gauge
+leaps_documents 2
+# HELP leaps_kicked Kicked clients.
+# TYPE leaps_kicked counter
+leaps_kicked{reason="admin"} 1
+`}
+	app := &fakeMetricsLocator{metrics: `# HELP leaps_documents Open documents.
+# TYPE leaps_documents gauge
+leaps_documents 3
+# HELP leaps_kicked Kicked clients.
+# TYPE leaps_kicked counter
+leaps_kicked{} 4
+`}
+	for prefix, locator := range map[string]LeapLocator{"": root, "a\"pp/": app, "other/": &fakeLocator{}} {
+		if err := mux.Handle(prefix, locator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := mux.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `# HELP leaps_documents Open documents.
+# TYPE leaps_documents gauge
+leaps_documents{route="a\"pp/"} 3
+leaps_documents{route=""} 2
+# HELP leaps_kicked Kicked clients.
+# TYPE leaps_kicked counter
+leaps_kicked{route="a\"pp/"} 4
+leaps_kicked{route="",reason="admin"} 1
+`
+	if act := buf.String(); exp != act {
+		t.Errorf("Wrong metrics: %v != %v", exp, act)
+	}
+}
+
+type fakeClusterLocator struct {
+	fakeLocator
+	status lib.ClusterStatus
+	err    error
+}
+
+func (f *fakeClusterLocator) GetClusterStatus() (lib.ClusterStatus, error) {
+	return f.status, f.err
+}
+
+func TestMuxClusterStatus(t *testing.T) {
+	mux := NewMux()
+
+	disabled := &fakeClusterLocator{err: lib.ErrClusterDisabled}
+	if err := mux.Handle("disabled/", disabled); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mux.GetClusterStatus(); err != lib.ErrClusterDisabled {
+		t.Errorf("Wrong error: %v != %v", lib.ErrClusterDisabled, err)
+	}
+
+	earlier, later := time.Unix(100, 0), time.Unix(200, 0)
+	root := &fakeClusterLocator{status: lib.ClusterStatus{
+		Node:     "node1",
+		Protocol: 2,
+		Peers: []lib.RelayPeer{
+			{Node: "node3", Protocol: 2, Compatible: true, LastSeen: earlier},
+		},
+	}}
+	app := &fakeClusterLocator{status: lib.ClusterStatus{
+		Node:     "node1",
+		Protocol: 2,
+		Peers: []lib.RelayPeer{
+			{Node: "node3", Protocol: 2, Compatible: true, LastSeen: later},
+			{Node: "node2", Protocol: 2, Compatible: true, LastSeen: earlier},
+		},
+	}}
+	for prefix, locator := range map[string]LeapLocator{"": root, "app/": app, "other/": &fakeLocator{}} {
+		if err := mux.Handle(prefix, locator); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := mux.GetClusterStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := lib.ClusterStatus{
+		Node:     "node1",
+		Protocol: 2,
+		Peers: []lib.RelayPeer{
+			{Node: "node2", Protocol: 2, Compatible: true, LastSeen: earlier},
+			{Node: "node3", Protocol: 2, Compatible: true, LastSeen: later},
+		},
+	}
+	if !reflect.DeepEqual(exp, status) {
+		t.Errorf("Wrong status: %v != %v", exp, status)
+	}
+
+	app.status.Protocol = 3
+	if status, err = mux.GetClusterStatus(); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Mixed {
+		t.Error("Expected cluster of mixed protocols")
+	}
+}
+
+type slowUsersLocator struct {
+	fakeLocator
+	delay time.Duration
+	calls int
+}
+
+func (f *slowUsersLocator) KickUser(documentID, userID string, timeout time.Duration) error {
+	return nil
+}
+
+func (f *slowUsersLocator) GetUsers(timeout time.Duration) (map[string][]string, error) {
+	f.calls++
+	time.Sleep(f.delay)
+	return map[string][]string{"doc": {"user"}}, nil
+}
+
+func TestMuxGetUsersTimeout(t *testing.T) {
+	mux := NewMux()
+
+	first := &slowUsersLocator{delay: 20 * time.Millisecond}
+	second := &slowUsersLocator{delay: 20 * time.Millisecond}
+	if err := mux.Handle("first/", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := mux.Handle("second/", second); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := mux.GetUsers(10 * time.Millisecond)
+	if err != lib.ErrTimeout {
+		t.Errorf("Wrong error: %v != %v", lib.ErrTimeout, err)
+	}
+	if calls := first.calls + second.calls; calls != 1 {
+		t.Errorf("Expected only one locator to be asked, asked %v", calls)
+	}
+	if len(users) != 1 {
+		t.Errorf("Expected users of the first locator: %v", users)
+	}
+}
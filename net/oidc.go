@@ -0,0 +1,862 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/register"
+	"github.com/jeffail/leaps/lib/util"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+OIDCConfig - Options for logging users in through an OpenID Connect provider such as Google, Okta or
+Keycloak with the authorization code flow. Issuer is the URL the provider publishes its discovery
+document under and RedirectURL must be the absolute URL of the callback endpoint, which is served
+at Path/callback of the public HTTP server.
+
+Logged in users are given a session cookie signed with CookieSecret, which pages exchange at
+Path/session for a token to join documents with. Each exchange issues a new token that expires
+shortly after and may be bound to one document, so that the session cookie itself never leaves the
+browser. Sessions are identified by the UserClaim of the ID token, falling back to its subject, and
+are granted Access to every document, or admin access if the user is listed in Admins. Logging out
+revokes the session along with every token issued for it.
+*/
+type OIDCConfig struct {
+	Enabled      bool              `json:"enabled" yaml:"enabled"`
+	Issuer       string            `json:"issuer" yaml:"issuer"`
+	ClientID     string            `json:"client_id" yaml:"client_id"`
+	ClientSecret string            `json:"client_secret" yaml:"client_secret"`
+	RedirectURL  string            `json:"redirect_url" yaml:"redirect_url"`
+	Scopes       []string          `json:"scopes" yaml:"scopes"`
+	Path         string            `json:"path" yaml:"path"`
+	CookieName   string            `json:"cookie_name" yaml:"cookie_name"`
+	CookieSecret string            `json:"cookie_secret" yaml:"cookie_secret"`
+	SessionTTL   int64             `json:"session_ttl_s" yaml:"session_ttl_s"`
+	UserClaim    string            `json:"user_claim" yaml:"user_claim"`
+	Access       string            `json:"access" yaml:"access"`
+	Admins       []string          `json:"admins" yaml:"admins"`
+	AllowCreate  bool              `json:"allow_creation" yaml:"allow_creation"`
+	Leeway       int64             `json:"leeway_s" yaml:"leeway_s"`
+	Outbound     util.ClientConfig `json:"outbound" yaml:"outbound"`
+}
+
+/*
+NewOIDCConfig - Returns a default OIDCConfig, OIDC logins are disabled.
+*/
+func NewOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		Enabled:      false,
+		Issuer:       "",
+		ClientID:     "",
+		ClientSecret: "",
+		RedirectURL:  "",
+		Scopes:       []string{"openid", "email", "profile"},
+		Path:         "oidc",
+		CookieName:   "leaps_oidc",
+		CookieSecret: "",
+		SessionTTL:   28800,
+		UserClaim:    "email",
+		Access:       "write",
+		Admins:       []string{},
+		AllowCreate:  true,
+		Leeway:       5,
+		Outbound:     util.NewClientConfig(),
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the OIDC type.
+var (
+	ErrOIDCNotConfigured = errors.New(
+		"OIDC requires an issuer, client_id, redirect_url and cookie_secret",
+	)
+	ErrOIDCInvalidAccess = errors.New("OIDC access must be one of 'read', 'write' or 'admin'")
+	ErrOIDCDiscovery     = errors.New("OIDC provider returned an invalid discovery document")
+	ErrOIDCExchange      = errors.New("OIDC provider rejected the authorization code")
+	ErrOIDCMalformed     = errors.New("ID token is not a well formed JWT")
+	ErrOIDCUnknownKey    = errors.New("ID token is signed by an unknown key")
+	ErrOIDCSignature     = errors.New("ID token signature is invalid")
+	ErrOIDCClaims        = errors.New("ID token claims are invalid")
+	ErrOIDCCookie        = errors.New("cookie is invalid or has expired")
+)
+
+const (
+	// oidcStateTTL - The longest a user may take to log in with the provider.
+	oidcStateTTL = 10 * time.Minute
+
+	// oidcJoinTTL - The longest a join token may be held before it is used to join a document.
+	oidcJoinTTL = time.Minute
+
+	// oidcKeyRefetchPeriod - The shortest time between fetches of the key set of the provider, so
+	// that tokens naming unknown keys can't be used to flood it with requests.
+	oidcKeyRefetchPeriod = time.Minute
+)
+
+/*
+oidcProvider - The parts of a discovery document that the code flow depends on.
+*/
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+/*
+oidcCookie - The signed contents of the short lived state cookie, set when a login begins, the
+session cookie and the join tokens issued for a session. Kind prevents one from being presented in
+place of another. Session is the ID of the session, which the join tokens issued for it share,
+Document is the only document a join token may be used with when set and Verifier is the PKCE code
+verifier of a login.
+*/
+type oidcCookie struct {
+	Kind     string `json:"k"`
+	User     string `json:"u,omitempty"`
+	Session  string `json:"i,omitempty"`
+	Document string `json:"d,omitempty"`
+	State    string `json:"s,omitempty"`
+	Nonce    string `json:"n,omitempty"`
+	Verifier string `json:"v,omitempty"`
+	Return   string `json:"r,omitempty"`
+	Expires  int64  `json:"e"`
+}
+
+/*
+OIDC - Performs the OpenID Connect authorization code flow for users of the public HTTP server and
+acts as an auth.Authenticator for the sessions it creates, wrapping the configured authenticator
+which handles every other token. The discovery document and signing keys of the provider are
+fetched when first needed, so leaps can start whilst the provider is down. Revoked sessions are held
+in memory until they would have expired.
+*/
+type OIDC struct {
+	config   OIDCConfig
+	access   auth.AccessLevel
+	admins   map[string]struct{}
+	fallback auth.Authenticator
+	client   *http.Client
+	logger   *log.Logger
+	stats    *log.Stats
+
+	mutex       sync.Mutex
+	provider    *oidcProvider
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+	revoked     map[string]int64
+}
+
+/*
+NewOIDC - Creates an OIDC from a config, tokens that are not of an OIDC session are passed on to
+fallback, which may be nil.
+*/
+func NewOIDC(
+	config OIDCConfig, fallback auth.Authenticator, logger *log.Logger, stats *log.Stats,
+) (*OIDC, error) {
+	if len(config.Issuer) == 0 || len(config.ClientID) == 0 ||
+		len(config.RedirectURL) == 0 || len(config.CookieSecret) == 0 {
+		return nil, ErrOIDCNotConfigured
+	}
+
+	o := OIDC{
+		config:   config,
+		admins:   map[string]struct{}{},
+		fallback: fallback,
+		logger:   logger.NewModule(":oidc"),
+		stats:    stats,
+		keys:     map[string]*rsa.PublicKey{},
+		revoked:  map[string]int64{},
+	}
+	switch config.Access {
+	case "read":
+		o.access = auth.AccessRead
+	case "write":
+		o.access = auth.AccessWrite
+	case "admin":
+		o.access = auth.AccessAdmin
+	default:
+		return nil, ErrOIDCInvalidAccess
+	}
+	for _, admin := range config.Admins {
+		o.admins[admin] = struct{}{}
+	}
+
+	var err error
+	if o.client, err = util.NewHTTPClient(config.Outbound); err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+getJSON - GET a URL from the provider and decode its JSON response.
+*/
+func (o *OIDC) getJSON(target string, into interface{}) error {
+	res, err := o.client.Get(target)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status from %v: %v", target, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(into)
+}
+
+/*
+discover - Returns the discovery document of the provider, fetching it if this is the first time.
+*/
+func (o *OIDC) discover() (*oidcProvider, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.provider != nil {
+		return o.provider, nil
+	}
+
+	var provider oidcProvider
+	if err := o.getJSON(
+		strings.TrimSuffix(o.config.Issuer, "/")+"/.well-known/openid-configuration", &provider,
+	); err != nil {
+		return nil, err
+	}
+	if len(provider.AuthorizationEndpoint) == 0 || len(provider.TokenEndpoint) == 0 ||
+		len(provider.JWKSURI) == 0 || strings.TrimSuffix(provider.Issuer, "/") !=
+		strings.TrimSuffix(o.config.Issuer, "/") {
+		return nil, ErrOIDCDiscovery
+	}
+	o.provider = &provider
+	return o.provider, nil
+}
+
+/*
+key - Returns the RSA key of the provider with an ID, the key set is fetched again when a key is not
+recognised as providers rotate their keys, although no more than once per oidcKeyRefetchPeriod.
+*/
+func (o *OIDC) key(provider *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	o.mutex.Lock()
+	key, ok := o.keys[kid]
+	recent := time.Since(o.keysFetched) < oidcKeyRefetchPeriod
+	if !ok && !recent {
+		o.keysFetched = time.Now()
+	}
+	o.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+	if recent {
+		o.stats.Incr("oidc.keys.throttled", 1)
+		return nil, ErrOIDCUnknownKey
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(provider.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	o.mutex.Lock()
+	o.keys = keys
+	o.mutex.Unlock()
+
+	if key, ok = keys[kid]; !ok {
+		return nil, ErrOIDCUnknownKey
+	}
+	return key, nil
+}
+
+/*
+verifyIDToken - Verify the signature and claims of an ID token and return the user it identifies.
+*/
+func (o *OIDC) verifyIDToken(provider *oidcProvider, token, nonce string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrOIDCMalformed
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrOIDCMalformed
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err = json.Unmarshal(headerBytes, &header); err != nil {
+		return "", ErrOIDCMalformed
+	}
+	// RS256 is the one algorithm every provider is required to support.
+	if header.Algorithm != "RS256" {
+		return "", ErrOIDCSignature
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrOIDCMalformed
+	}
+	key, err := o.key(provider, header.KeyID)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return "", ErrOIDCSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrOIDCMalformed
+	}
+	var claims map[string]interface{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return "", ErrOIDCMalformed
+	}
+
+	if iss, _ := claims["iss"].(string); iss != provider.Issuer {
+		return "", ErrOIDCClaims
+	}
+	audOK := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audOK = aud == o.config.ClientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == o.config.ClientID {
+				audOK = true
+			}
+		}
+	}
+	if !audOK {
+		return "", ErrOIDCClaims
+	}
+	exp, _ := claims["exp"].(float64)
+	if time.Now().Unix() > int64(exp)+o.config.Leeway {
+		return "", ErrOIDCClaims
+	}
+	if n, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(n), []byte(nonce)) != 1 {
+		return "", ErrOIDCClaims
+	}
+
+	if user, _ := claims[o.config.UserClaim].(string); len(user) > 0 {
+		return user, nil
+	}
+	if sub, _ := claims["sub"].(string); len(sub) > 0 {
+		return sub, nil
+	}
+	return "", ErrOIDCClaims
+}
+
+/*
+exchange - Exchange an authorization code for an ID token at the token endpoint of the provider,
+proving with the PKCE code verifier that this server began the login.
+*/
+func (o *OIDC) exchange(provider *oidcProvider, code, verifier string) (string, error) {
+	res, err := o.client.PostForm(provider.TokenEndpoint, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.config.RedirectURL},
+		"client_id":     {o.config.ClientID},
+		"client_secret": {o.config.ClientSecret},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", ErrOIDCExchange
+	}
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil || len(body.IDToken) == 0 {
+		return "", ErrOIDCExchange
+	}
+	return body.IDToken, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+signCookie - Encode and sign the contents of a cookie.
+*/
+func (o *OIDC) signCookie(c oidcCookie) string {
+	payload, _ := json.Marshal(c)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(o.config.CookieSecret))
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+/*
+readCookie - Verify the signature, kind and expiry of a cookie value and return its contents.
+*/
+func (o *OIDC) readCookie(value, kind string) (oidcCookie, error) {
+	var c oidcCookie
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return c, ErrOIDCCookie
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return c, ErrOIDCCookie
+	}
+	mac := hmac.New(sha256.New, []byte(o.config.CookieSecret))
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(mac.Sum(nil), signature) {
+		return c, ErrOIDCCookie
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return c, ErrOIDCCookie
+	}
+	if err = json.Unmarshal(payload, &c); err != nil {
+		return c, ErrOIDCCookie
+	}
+	if c.Kind != kind || time.Now().Unix() > c.Expires {
+		return c, ErrOIDCCookie
+	}
+	if len(c.Session) > 0 {
+		o.mutex.Lock()
+		_, revoked := o.revoked[c.Session]
+		o.mutex.Unlock()
+		if revoked {
+			return c, ErrOIDCCookie
+		}
+	}
+	return c, nil
+}
+
+/*
+revoke - Revoke a session and every join token issued for it until the session would expire.
+*/
+func (o *OIDC) revoke(session oidcCookie) {
+	now := time.Now().Unix()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	for id, expires := range o.revoked {
+		if now > expires {
+			delete(o.revoked, id)
+		}
+	}
+	o.revoked[session.Session] = session.Expires
+}
+
+/*
+randomString - Returns a random hex string suitable for a state, nonce or session ID.
+*/
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+/*
+codeVerifier - Returns a random PKCE code verifier along with its S256 code challenge.
+*/
+func codeVerifier() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(b)
+	challenge := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(challenge[:]), nil
+}
+
+/*
+safeReturn - Only allow users to be sent back to paths of this server after logging in, so that the
+login endpoint can't be used as an open redirect.
+*/
+func safeReturn(target string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") ||
+		strings.HasPrefix(target, "/\\") {
+		return "/"
+	}
+	return target
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+loginHandler - Begins a login by redirecting to the provider, the optional return query parameter is
+the path the user is sent back to once logged in.
+*/
+func (o *OIDC) loginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := o.discover()
+	if err != nil {
+		o.logger.Errorf("Failed to discover OIDC provider: %v\n", err)
+		http.Error(w, "Login provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, "Failed to begin login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, "Failed to begin login", http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := codeVerifier()
+	if err != nil {
+		http.Error(w, "Failed to begin login", http.StatusInternalServerError)
+		return
+	}
+
+	expires := time.Now().Add(oidcStateTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name: o.config.CookieName + "_state",
+		Value: o.signCookie(oidcCookie{
+			Kind:     "state",
+			State:    state,
+			Nonce:    nonce,
+			Verifier: verifier,
+			Return:   safeReturn(r.URL.Query().Get("return")),
+			Expires:  expires.Unix(),
+		}),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		// Lax is required for the cookie to survive the redirect back from the provider.
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {o.config.ClientID},
+		"redirect_uri":          {o.config.RedirectURL},
+		"scope":                 {strings.Join(o.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+separator+query.Encode(), http.StatusFound)
+}
+
+/*
+callbackHandler - Completes a login by exchanging the authorization code for an ID token and setting
+the session cookie.
+*/
+func (o *OIDC) callbackHandler(w http.ResponseWriter, r *http.Request) {
+	stateCookie, err := r.Cookie(o.config.CookieName + "_state")
+	if err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	pending, err := o.readCookie(stateCookie.Value, "state")
+	if err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   o.config.CookieName + "_state",
+		Path:   "/",
+		MaxAge: -1,
+	})
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(pending.State)) != 1 {
+		o.stats.Incr("oidc.login.rejected", 1)
+		http.Error(w, "Login state mismatch", http.StatusBadRequest)
+		return
+	}
+	if e := query.Get("error"); len(e) > 0 {
+		o.stats.Incr("oidc.login.rejected", 1)
+		http.Error(w, "Login refused by provider: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	provider, err := o.discover()
+	if err != nil {
+		o.logger.Errorf("Failed to discover OIDC provider: %v\n", err)
+		http.Error(w, "Login provider unavailable", http.StatusBadGateway)
+		return
+	}
+	idToken, err := o.exchange(provider, query.Get("code"), pending.Verifier)
+	if err != nil {
+		o.logger.Errorf("Failed to exchange authorization code: %v\n", err)
+		o.stats.Incr("oidc.login.error", 1)
+		http.Error(w, "Failed to complete login", http.StatusBadGateway)
+		return
+	}
+	user, err := o.verifyIDToken(provider, idToken, pending.Nonce)
+	if err != nil {
+		o.logger.Warnf("Rejected ID token: %v\n", err)
+		o.stats.Incr("oidc.login.rejected", 1)
+		http.Error(w, "Failed to complete login", http.StatusUnauthorized)
+		return
+	}
+	sessionID, err := randomString()
+	if err != nil {
+		http.Error(w, "Failed to complete login", http.StatusInternalServerError)
+		return
+	}
+
+	expires := time.Now().Add(time.Second * time.Duration(o.config.SessionTTL))
+	http.SetCookie(w, &http.Cookie{
+		Name: o.config.CookieName,
+		Value: o.signCookie(oidcCookie{
+			Kind:    "session",
+			User:    user,
+			Session: sessionID,
+			Expires: expires.Unix(),
+		}),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	o.stats.Incr("oidc.login.success", 1)
+
+	http.Redirect(w, r, pending.Return, http.StatusFound)
+}
+
+/*
+sessionHandler - Issues a new join token for the session cookie and returns it along with the user
+ID, which pages pass on to the leaps client. Join tokens expire after a minute, or along with their
+session if sooner, and are bound to the document given by the document_id query parameter.
+*/
+func (o *OIDC) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(o.config.CookieName)
+	if err != nil {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	session, err := o.readCookie(cookie.Value, "session")
+	if err != nil {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(oidcJoinTTL).Unix()
+	if expires > session.Expires {
+		expires = session.Expires
+	}
+
+	resBytes, err := json.Marshal(struct {
+		Token     string `json:"token"`
+		UserID    string `json:"user_id"`
+		ExpiresAt int64  `json:"expires_at"`
+	}{
+		Token: o.signCookie(oidcCookie{
+			Kind:     "join",
+			User:     session.User,
+			Session:  session.Session,
+			Document: r.URL.Query().Get("document_id"),
+			Nonce:    nonce,
+			Expires:  expires,
+		}),
+		UserID:    session.User,
+		ExpiresAt: expires,
+	})
+	if err != nil {
+		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(resBytes)
+}
+
+/*
+logoutHandler - Revokes the session along with its join tokens and clears the session cookie.
+*/
+func (o *OIDC) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(o.config.CookieName); err == nil {
+		if session, err := o.readCookie(cookie.Value, "session"); err == nil {
+			o.revoke(session)
+			o.stats.Incr("oidc.logout.success", 1)
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   o.config.CookieName,
+		Path:   "/",
+		MaxAge: -1,
+	})
+	http.Redirect(w, r, safeReturn(r.URL.Query().Get("return")), http.StatusFound)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+AuthoriseCreate - Checks that the token is a valid join token of the user that is not bound to a
+document, other tokens are checked by the wrapped authenticator.
+*/
+func (o *OIDC) AuthoriseCreate(token, userID string) bool {
+	session, err := o.readCookie(token, "join")
+	if err != nil {
+		return o.fallback != nil && o.fallback.AuthoriseCreate(token, userID)
+	}
+	return o.config.AllowCreate && session.User == userID && len(session.Document) == 0
+}
+
+/*
+IdentifyUser - Returns the user of a valid join token, other tokens are identified by the wrapped
+authenticator when it is able to.
+*/
+func (o *OIDC) IdentifyUser(token string) (string, bool) {
+	session, err := o.readCookie(token, "join")
+	if err != nil {
+		if identifier, ok := o.fallback.(auth.UserIdentifier); ok {
+			return identifier.IdentifyUser(token)
+		}
+		return "", false
+	}
+	return session.User, true
+}
+
+/*
+Authorise - Grants a valid join token the configured level of access to the document it is bound
+to, or any document when unbound, or admin access if the user is an admin. Other tokens are checked
+by the wrapped authenticator.
+*/
+func (o *OIDC) Authorise(token, documentID string) auth.AccessLevel {
+	session, err := o.readCookie(token, "join")
+	if err != nil {
+		if o.fallback != nil {
+			return o.fallback.Authorise(token, documentID)
+		}
+		return auth.AccessNone
+	}
+	if len(session.Document) > 0 && session.Document != documentID {
+		return auth.AccessNone
+	}
+	if _, ok := o.admins[session.User]; ok {
+		return auth.AccessAdmin
+	}
+	return o.access
+}
+
+/*
+RegisterHandlers - Register the login flow endpoints on the public HTTP server, along with those of
+the wrapped authenticator.
+*/
+func (o *OIDC) RegisterHandlers(register register.PubPrivEndpointRegister) error {
+	if o.fallback != nil {
+		if err := o.fallback.RegisterHandlers(register); err != nil {
+			return err
+		}
+	}
+	if err := register.RegisterPublic(
+		path.Join(o.config.Path, "login"),
+		"Begin logging in with the OpenID Connect provider, GET: ?return=<path>",
+		o.loginHandler,
+	); err != nil {
+		return err
+	}
+	if err := register.RegisterPublic(
+		path.Join(o.config.Path, "callback"),
+		"Complete logging in with the OpenID Connect provider",
+		o.callbackHandler,
+	); err != nil {
+		return err
+	}
+	if err := register.RegisterPublic(
+		path.Join(o.config.Path, "session"),
+		"Get a new token of the current session, GET: ?document_id=<id>",
+		o.sessionHandler,
+	); err != nil {
+		return err
+	}
+	return register.RegisterPublic(
+		path.Join(o.config.Path, "logout"),
+		"End the current session, GET: ?return=<path>",
+		o.logoutHandler,
+	)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
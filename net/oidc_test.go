@@ -0,0 +1,393 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/register"
+)
+
+type levelAuthenticator map[string]auth.AccessLevel
+
+func (l levelAuthenticator) AuthoriseCreate(token, userID string) bool {
+	return false
+}
+
+func (l levelAuthenticator) Authorise(token, documentID string) auth.AccessLevel {
+	return l[token]
+}
+
+func (l levelAuthenticator) RegisterHandlers(register.PubPrivEndpointRegister) error {
+	return nil
+}
+
+type oidcRegister map[string]http.HandlerFunc
+
+func (o oidcRegister) RegisterPublic(endpoint, description string, handler http.HandlerFunc) error {
+	o[endpoint] = handler
+	return nil
+}
+
+func (o oidcRegister) RegisterPrivate(endpoint, description string, handler http.HandlerFunc) error {
+	o[endpoint] = handler
+	return nil
+}
+
+/*
+fakeProvider - Serves the endpoints of an OpenID Connect provider that issues an ID token for the
+last nonce it was sent, provided the code verifier matches the last code challenge.
+*/
+func fakeProvider(t *testing.T, key *rsa.PrivateKey, user string) *httptest.Server {
+	var server *httptest.Server
+	var nonce, challenge string
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		nonce = r.URL.Query().Get("nonce")
+		challenge = r.URL.Query().Get("code_challenge")
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("code") != "good_code" || r.FormValue("client_secret") != "shh" {
+			http.Error(w, "bad code", http.StatusBadRequest)
+			return
+		}
+		verified := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(verified[:]) != challenge {
+			http.Error(w, "bad code verifier", http.StatusBadRequest)
+			return
+		}
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+		payload, _ := json.Marshal(map[string]interface{}{
+			"iss":   server.URL,
+			"aud":   "leaps",
+			"sub":   "12345",
+			"email": user,
+			"nonce": nonce,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		})
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+			base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": signed + "." + base64.RawURLEncoding.EncodeToString(sig),
+		})
+	})
+
+	server = httptest.NewServer(mux)
+	return server
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := fakeProvider(t, key, "alice@example.com")
+	defer provider.Close()
+
+	config := NewOIDCConfig()
+	config.Enabled = true
+	config.Issuer = provider.URL
+	config.ClientID = "leaps"
+	config.ClientSecret = "shh"
+	config.RedirectURL = "http://leaps.example.com/oidc/callback"
+	config.CookieSecret = "secret"
+	config.Admins = []string{"bob@example.com"}
+
+	logger, stats := loggerAndStats()
+	o, err := NewOIDC(config, nil, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := oidcRegister{}
+	if err = o.RegisterHandlers(reg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Begin the login, which should redirect to the provider.
+	w := httptest.NewRecorder()
+	reg["oidc/login"](w, httptest.NewRequest("GET", "/oidc/login?return=//evil.com", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("Wrong status from login: %v", w.Code)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authURL.Path != "/authorize" || authURL.Query().Get("client_id") != "leaps" {
+		t.Errorf("Wrong redirect: %v", authURL)
+	}
+	if method := authURL.Query().Get("code_challenge_method"); method != "S256" {
+		t.Errorf("Wrong code challenge method: %v", method)
+	}
+	state := authURL.Query().Get("state")
+	stateCookies := w.Result().Cookies()
+
+	// Visit the provider so that it learns the nonce.
+	res, err := http.Get(authURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/oidc/callback?"+query, nil)
+		for _, c := range stateCookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		reg["oidc/callback"](rec, req)
+		return rec
+	}
+
+	if w = callback("code=good_code&state=wrong"); w.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status from mismatched state: %v", w.Code)
+	}
+	if w = callback("code=bad_code&state=" + state); w.Code != http.StatusBadGateway {
+		t.Errorf("Wrong status from bad code: %v", w.Code)
+	}
+	if w = callback("code=good_code&state=" + state); w.Code != http.StatusFound {
+		t.Fatalf("Wrong status from callback: %v: %v", w.Code, w.Body.String())
+	}
+	if loc := w.Header().Get("Location"); loc != "/" {
+		t.Errorf("Unsafe return path was not replaced: %v", loc)
+	}
+
+	var session *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == config.CookieName {
+			session = c
+		}
+	}
+	if session == nil {
+		t.Fatal("No session cookie was set")
+	}
+
+	type sessionBody struct {
+		Token     string `json:"token"`
+		UserID    string `json:"user_id"`
+		ExpiresAt int64  `json:"expires_at"`
+	}
+	getToken := func() sessionBody {
+		req := httptest.NewRequest("GET", "/oidc/session", nil)
+		req.AddCookie(session)
+		rec := httptest.NewRecorder()
+		reg["oidc/session"](rec, req)
+
+		var body sessionBody
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body
+	}
+
+	body := getToken()
+	if body.UserID != "alice@example.com" {
+		t.Errorf("Wrong user: %v", body.UserID)
+	}
+	if body.Token == session.Value {
+		t.Error("Session cookie was handed out as a token")
+	}
+	if level := o.Authorise(session.Value, "anydoc"); level != auth.AccessNone {
+		t.Errorf("Session cookie granted access: %v", level)
+	}
+	if other := getToken(); other.Token == body.Token {
+		t.Error("Join tokens were not unique")
+	}
+
+	if level := o.Authorise(body.Token, "anydoc"); level != auth.AccessWrite {
+		t.Errorf("Wrong access level: %v", level)
+	}
+	if !o.AuthoriseCreate(body.Token, "alice@example.com") {
+		t.Error("Session not allowed to create")
+	}
+	if o.AuthoriseCreate(body.Token, "bob@example.com") {
+		t.Error("Session allowed to create for another user")
+	}
+	if level := o.Authorise(body.Token+"x", "anydoc"); level != auth.AccessNone {
+		t.Errorf("Tampered token granted access: %v", level)
+	}
+	if userID, ok := o.IdentifyUser(body.Token); !ok || userID != "alice@example.com" {
+		t.Errorf("Wrong user identified: %v", userID)
+	}
+	if body.ExpiresAt > time.Now().Add(oidcJoinTTL).Unix() {
+		t.Errorf("Join token outlives its TTL: %v", body.ExpiresAt)
+	}
+
+	// Tokens fetched for a document can only join that document.
+	req := httptest.NewRequest("GET", "/oidc/session?document_id=mydoc", nil)
+	req.AddCookie(session)
+	w = httptest.NewRecorder()
+	reg["oidc/session"](w, req)
+	var bound sessionBody
+	if err := json.Unmarshal(w.Body.Bytes(), &bound); err != nil {
+		t.Fatal(err)
+	}
+	if level := o.Authorise(bound.Token, "mydoc"); level != auth.AccessWrite {
+		t.Errorf("Wrong access level for bound document: %v", level)
+	}
+	if level := o.Authorise(bound.Token, "anydoc"); level != auth.AccessNone {
+		t.Errorf("Bound token granted access to another document: %v", level)
+	}
+	if o.AuthoriseCreate(bound.Token, "alice@example.com") {
+		t.Error("Bound token allowed to create")
+	}
+
+	// A state cookie must never be accepted as a session.
+	if level := o.Authorise(stateCookies[0].Value, "anydoc"); level != auth.AccessNone {
+		t.Errorf("State cookie granted access: %v", level)
+	}
+
+	// Logging out revokes the session and every token issued for it.
+	req = httptest.NewRequest("GET", "/oidc/logout", nil)
+	req.AddCookie(session)
+	w = httptest.NewRecorder()
+	reg["oidc/logout"](w, req)
+	if level := o.Authorise(body.Token, "anydoc"); level != auth.AccessNone {
+		t.Errorf("Token of a revoked session granted access: %v", level)
+	}
+	req = httptest.NewRequest("GET", "/oidc/session", nil)
+	req.AddCookie(session)
+	w = httptest.NewRecorder()
+	reg["oidc/session"](w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong status for a revoked session: %v", w.Code)
+	}
+}
+
+func TestOIDCKeyRefetch(t *testing.T) {
+	var fetches int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer server.Close()
+
+	config := NewOIDCConfig()
+	config.Issuer = server.URL
+	config.ClientID = "leaps"
+	config.RedirectURL = "http://localhost/oidc/callback"
+	config.CookieSecret = "secret"
+
+	logger, stats := loggerAndStats()
+	o, err := NewOIDC(config, nil, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &oidcProvider{JWKSURI: server.URL}
+	for i := 0; i < 5; i++ {
+		if _, err = o.key(provider, "unknown"); err != ErrOIDCUnknownKey {
+			t.Errorf("Wrong error for an unknown key: %v", err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Unknown keys fetched the key set %v times", fetches)
+	}
+}
+
+func TestOIDCSessionCookies(t *testing.T) {
+	config := NewOIDCConfig()
+	config.Issuer = "http://localhost"
+	config.ClientID = "leaps"
+	config.RedirectURL = "http://localhost/oidc/callback"
+	config.CookieSecret = "secret"
+	config.Admins = []string{"bob"}
+
+	logger, stats := loggerAndStats()
+	o, err := NewOIDC(config, nil, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	admin := o.signCookie(oidcCookie{Kind: "join", User: "bob", Expires: time.Now().Add(time.Minute).Unix()})
+	if level := o.Authorise(admin, "doc"); level != auth.AccessAdmin {
+		t.Errorf("Wrong access level for admin: %v", level)
+	}
+
+	bound := o.signCookie(oidcCookie{Kind: "join", User: "bob", Document: "doc", Expires: time.Now().Add(time.Minute).Unix()})
+	if level := o.Authorise(bound, "otherdoc"); level != auth.AccessNone {
+		t.Errorf("Admin token bound to a document granted access to another: %v", level)
+	}
+
+	expired := o.signCookie(oidcCookie{Kind: "join", User: "bob", Expires: time.Now().Add(-time.Minute).Unix()})
+	if level := o.Authorise(expired, "doc"); level != auth.AccessNone {
+		t.Errorf("Expired session granted access: %v", level)
+	}
+
+	config.CookieSecret = "other"
+	other, _ := NewOIDC(config, nil, logger, stats)
+	if level := other.Authorise(admin, "doc"); level != auth.AccessNone {
+		t.Errorf("Session signed with another secret granted access: %v", level)
+	}
+
+	// Tokens of other kinds are checked by the wrapped authenticator.
+	wrapping, _ := NewOIDC(config, levelAuthenticator{"reader": auth.AccessRead}, logger, stats)
+	if level := wrapping.Authorise("reader", "doc"); level != auth.AccessRead {
+		t.Errorf("Wrong access level from wrapped authenticator: %v", level)
+	}
+	if level := wrapping.Authorise("stranger", "doc"); level != auth.AccessNone {
+		t.Errorf("Unknown token granted access: %v", level)
+	}
+
+	config.Access = "owner"
+	if _, err = NewOIDC(config, nil, logger, stats); err != ErrOIDCInvalidAccess {
+		t.Errorf("Wrong error from invalid access: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+//go:generate go run gen_openapi.go
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+apiSpecPath - The path at which the OpenAPI document of the admin API is served.
+*/
+const apiSpecPath = "/api/spec"
+
+/*
+registerSpecEndpoint - Serve the OpenAPI document of the admin API, which is generated from the
+endpoint definitions at build time. The paths of the document are relative to the admin path, which
+is given as its server.
+*/
+func (i *InternalServer) registerSpecEndpoint() {
+	var spec map[string]interface{}
+	if err := json.Unmarshal([]byte(apiSpecSource), &spec); err != nil {
+		i.logger.Errorf("Embedded API spec is invalid: %v\n", err)
+		return
+	}
+	spec["servers"] = []map[string]string{{"url": i.config.Path}}
+
+	var specBuf bytes.Buffer
+	encoder := json.NewEncoder(&specBuf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(spec); err != nil {
+		i.logger.Errorf("Failed to encode API spec: %v\n", err)
+		return
+	}
+	specBytes := specBuf.Bytes()
+
+	i.mux.HandleFunc(apiSpecPath, i.limits.WrapHandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				i.stats.Incr("http_admin.api_spec.error", 1)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+			i.stats.Incr("http_admin.api_spec.success", 1)
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(specBytes)
+		}))
+}
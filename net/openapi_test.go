@@ -0,0 +1,82 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestAPISpecEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+	config.DocumentsToken = "secret"
+
+	admin := FakeDocumentAdmin{documents: map[string]store.Document{}}
+	internalServer, err := NewInternalServer(admin, config, log, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("GET", apiSpecPath, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status for spec: %v", res.Code)
+	}
+
+	var spec struct {
+		OpenAPI string                            `json:"openapi"`
+		Servers []struct{ URL string }            `json:"servers"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err = json.Unmarshal(res.Body.Bytes(), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(spec.OpenAPI, "3.") {
+		t.Errorf("Wrong OpenAPI version: %v", spec.OpenAPI)
+	}
+	if len(spec.Servers) != 1 || spec.Servers[0].URL != "/internal" {
+		t.Errorf("Wrong servers: %v", spec.Servers)
+	}
+
+	for _, e := range internalServer.apiEndpoints {
+		path := strings.TrimPrefix(e.endpoint, config.Path)
+		// Fault injection endpoints only exist in chaos builds, which the spec does not describe.
+		if strings.HasPrefix(path, "/chaos/") {
+			continue
+		}
+		if _, exists := spec.Paths[path]; !exists {
+			t.Errorf("Endpoint %v missing from spec, run go generate ./net", path)
+		}
+	}
+	if _, exists := spec.Paths["/documents"]["put"]; !exists {
+		t.Errorf("Documents PUT missing from spec: %v", spec.Paths["/documents"])
+	}
+}
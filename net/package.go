@@ -30,7 +30,9 @@ import "errors"
 
 // Errors used throughout the package.
 var (
-	ErrInvalidStaticPath = errors.New("invalid config value for static path")
-	ErrInvalidURLAddr    = errors.New("invalid config value for server address")
-	ErrInvalidSSLConfig  = errors.New("invalid config value for certificate path and/or private key path")
+	ErrInvalidStaticPath     = errors.New("invalid config value for static path")
+	ErrInvalidURLAddr        = errors.New("invalid config value for server address")
+	ErrInvalidSSLConfig      = errors.New("invalid config value for certificate path and/or private key path")
+	ErrInvalidClientAuth     = errors.New("invalid config value for client auth, must be 'require' or 'verify_if_given'")
+	ErrInvalidAutocertConfig = errors.New("invalid config value for autocert, at least one domain is required")
 )
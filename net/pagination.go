@@ -0,0 +1,298 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+const (
+	// defaultListLimit - The number of items of a page when a request does not ask for a limit.
+	defaultListLimit = 50
+
+	// maxListLimit - The largest number of items a request may ask for in a page.
+	maxListLimit = 500
+)
+
+// Errors for the ListQuery type.
+var (
+	ErrInvalidListLimit  = errors.New("limit must be a positive integer")
+	ErrInvalidListCursor = errors.New("cursor is malformed or belongs to a different sort order")
+	ErrInvalidListFilter = errors.New("filter must be of the form <field>:<value>")
+	ErrInvalidListSort   = errors.New("sort must name a sortable field, prefixed with - to reverse")
+)
+
+/*
+ListItem - Implemented by the items of list endpoints. ListKey uniquely and stably identifies the
+item, and breaks ties between items sorted by other fields. ListField returns the value of a named
+field for filtering and sorting, and false if the item has no such field. Values are compared as
+strings, so fields such as times must be given in a form that sorts, such as RFC 3339.
+*/
+type ListItem interface {
+	ListKey() string
+	ListField(name string) (string, bool)
+}
+
+/*
+ListFilter - Matches items whose field equals a value, or begins with it when Prefix is set.
+*/
+type ListFilter struct {
+	Field  string
+	Value  string
+	Prefix bool
+}
+
+/*
+ListQuery - The pagination, filtering and sorting of a request to a list endpoint, shared by every
+list endpoint so that clients get the same semantics from each. Requests are of the form:
+
+	?limit=<n>&cursor=<cursor>&filter=<field>:<value>&filter=<field>:<prefix>*&sort=-<field>
+
+Filters must all match, a value ending in * matches by prefix. Sort names a field to sort by, with a
+leading - for descending order, and items are otherwise sorted by their key. Pages are requested by
+passing the next_cursor of the previous page, cursors point past the last item seen rather than at an
+offset, and so items added or removed between requests do not shift the pages that follow.
+*/
+type ListQuery struct {
+	Limit      int
+	Cursor     string
+	Filters    []ListFilter
+	Sort       string
+	Descending bool
+}
+
+/*
+ListPage - A page of items from a list endpoint. Total is the number of items that match the filters
+across all pages, and NextCursor is empty on the last page.
+*/
+type ListPage struct {
+	Items      []ListItem `json:"items"`
+	Total      int        `json:"total"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+/*
+listCursor - The position of the last item of a page, along with the sort order it belongs to.
+*/
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Key   string `json:"k"`
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ParseListQuery - Parse the list query of a request, fields lists the fields items may be filtered
+and sorted by.
+*/
+func ParseListQuery(values url.Values, fields []string) (ListQuery, error) {
+	query := ListQuery{Limit: defaultListLimit, Cursor: values.Get("cursor")}
+
+	if limitStr := values.Get("limit"); len(limitStr) > 0 {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			return query, ErrInvalidListLimit
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+		query.Limit = limit
+	}
+
+	known := func(field string) bool {
+		for _, f := range fields {
+			if f == field {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, filterStr := range values["filter"] {
+		split := strings.SplitN(filterStr, ":", 2)
+		if len(split) != 2 || !known(split[0]) {
+			return query, fmt.Errorf("%v: %v", ErrInvalidListFilter, filterStr)
+		}
+		filter := ListFilter{Field: split[0], Value: split[1]}
+		if strings.HasSuffix(filter.Value, "*") {
+			filter.Value, filter.Prefix = strings.TrimSuffix(filter.Value, "*"), true
+		}
+		query.Filters = append(query.Filters, filter)
+	}
+
+	if sortStr := values.Get("sort"); len(sortStr) > 0 {
+		query.Sort = strings.TrimPrefix(sortStr, "-")
+		query.Descending = query.Sort != sortStr
+		if !known(query.Sort) {
+			return query, fmt.Errorf("%v: %v", ErrInvalidListSort, sortStr)
+		}
+	}
+	return query, nil
+}
+
+/*
+sortSpec - The sort order of the query in the form it is requested.
+*/
+func (q ListQuery) sortSpec() string {
+	if q.Descending {
+		return "-" + q.Sort
+	}
+	return q.Sort
+}
+
+/*
+sortValue - The value of an item that the query sorts by.
+*/
+func (q ListQuery) sortValue(item ListItem) string {
+	if len(q.Sort) == 0 {
+		return item.ListKey()
+	}
+	value, _ := item.ListField(q.Sort)
+	return value
+}
+
+/*
+matches - Whether an item matches every filter of the query.
+*/
+func (q ListQuery) matches(item ListItem) bool {
+	for _, filter := range q.Filters {
+		value, ok := item.ListField(filter.Field)
+		if !ok {
+			return false
+		}
+		if filter.Prefix && !strings.HasPrefix(value, filter.Value) {
+			return false
+		}
+		if !filter.Prefix && value != filter.Value {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+less - Whether the position a is ordered before the position b, by sort value and then by key.
+*/
+func (q ListQuery) less(aValue, aKey, bValue, bKey string) bool {
+	if aValue != bValue {
+		return (aValue < bValue) != q.Descending
+	}
+	if aKey != bKey {
+		return (aKey < bKey) != q.Descending
+	}
+	return false
+}
+
+/*
+Apply - Filter, sort and page a list of items according to the query.
+*/
+func (q ListQuery) Apply(items []ListItem) (ListPage, error) {
+	var cursor *listCursor
+	if len(q.Cursor) > 0 {
+		data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+		if err != nil {
+			return ListPage{}, ErrInvalidListCursor
+		}
+		cursor = &listCursor{}
+		if err = json.Unmarshal(data, cursor); err != nil || cursor.Sort != q.sortSpec() {
+			return ListPage{}, ErrInvalidListCursor
+		}
+	}
+
+	matched := []ListItem{}
+	for _, item := range items {
+		if q.matches(item) {
+			matched = append(matched, item)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return q.less(q.sortValue(matched[i]), matched[i].ListKey(), q.sortValue(matched[j]), matched[j].ListKey())
+	})
+
+	page := ListPage{Items: []ListItem{}, Total: len(matched)}
+	start := 0
+	if cursor != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return q.less(cursor.Value, cursor.Key, q.sortValue(matched[i]), matched[i].ListKey())
+		})
+	}
+	end := start + q.Limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	page.Items = append(page.Items, matched[start:end]...)
+
+	if end < len(matched) {
+		last := matched[end-1]
+		data, _ := json.Marshal(listCursor{Sort: q.sortSpec(), Value: q.sortValue(last), Key: last.ListKey()})
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	}
+	return page, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+writeListPage - Respond to a request of a list endpoint with a page of items, or with a bad request
+when the list query of the request is invalid.
+*/
+func (i *InternalServer) writeListPage(w http.ResponseWriter, r *http.Request, endpoint string, fields []string, items []ListItem) {
+	query, err := ParseListQuery(r.URL.Query(), fields)
+	var page ListPage
+	if err == nil {
+		page, err = query.Apply(items)
+	}
+	if err != nil {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Warnf("/%v: %v\n", endpoint, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resultBytes, err := json.Marshal(page)
+	if err != nil {
+		i.stats.Incr("http_admin."+endpoint+".error", 1)
+		i.logger.Errorf("/%v: %v\n", endpoint, err)
+		http.Error(w, "Error encoding list", http.StatusInternalServerError)
+		return
+	}
+	i.stats.Incr("http_admin."+endpoint+".success", 1)
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(resultBytes)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
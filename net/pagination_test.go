@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type testListItem struct {
+	key, colour string
+}
+
+func (t testListItem) ListKey() string { return t.key }
+
+func (t testListItem) ListField(name string) (string, bool) {
+	if name == "colour" {
+		return t.colour, true
+	}
+	return "", false
+}
+
+func listKeys(page ListPage) []string {
+	keys := []string{}
+	for _, item := range page.Items {
+		keys = append(keys, item.ListKey())
+	}
+	return keys
+}
+
+func TestListQuery(t *testing.T) {
+	items := []ListItem{
+		testListItem{"e", "red"},
+		testListItem{"a", "blue"},
+		testListItem{"d", "red"},
+		testListItem{"b", "green"},
+		testListItem{"c", "red"},
+	}
+	fields := []string{"colour"}
+
+	// Walk every page, which must visit each item once in order
+	query, err := ParseListQuery(url.Values{"limit": {"2"}, "sort": {"-colour"}}, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	walked := []string{}
+	for {
+		page, err := query.Apply(items)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 5 {
+			t.Errorf("Wrong total: %v != %v", page.Total, 5)
+		}
+		walked = append(walked, listKeys(page)...)
+		if len(page.NextCursor) == 0 {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if exp, act := "edcba", strings.Join(walked, ""); exp != act {
+		t.Errorf("Wrong walk: %v != %v", act, exp)
+	}
+
+	// Cursors point past an item rather than at an offset
+	query, _ = ParseListQuery(url.Values{"limit": {"2"}}, fields)
+	page, _ := query.Apply(items)
+	query.Cursor = page.NextCursor
+	page, _ = query.Apply(append(items, testListItem{"aa", "blue"}))
+	if exp, act := "cd", strings.Join(listKeys(page), ""); exp != act {
+		t.Errorf("Wrong page after insert: %v != %v", act, exp)
+	}
+
+	query, _ = ParseListQuery(url.Values{"filter": {"colour:r*"}, "sort": {"colour"}}, fields)
+	page, _ = query.Apply(items)
+	if exp, act := "cde", strings.Join(listKeys(page), ""); exp != act {
+		t.Errorf("Wrong filtered page: %v != %v", act, exp)
+	}
+
+	// A cursor of one sort order is refused by another
+	query, _ = ParseListQuery(url.Values{"limit": {"1"}, "sort": {"colour"}}, fields)
+	page, _ = query.Apply(items)
+	query.Descending, query.Cursor = true, page.NextCursor
+	if _, err = query.Apply(items); err != ErrInvalidListCursor {
+		t.Errorf("Wrong error: %v != %v", err, ErrInvalidListCursor)
+	}
+
+	for _, values := range []url.Values{
+		{"limit": {"0"}},
+		{"filter": {"size:big"}},
+		{"filter": {"colour"}},
+		{"sort": {"-size"}},
+	} {
+		if _, err = ParseListQuery(values, fields); err == nil {
+			t.Errorf("Expected error from query: %v", values)
+		}
+	}
+}
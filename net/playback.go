@@ -0,0 +1,191 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+PlaybackConfig - Options for the playback endpoint, which streams the recording of a document to a
+client as timed transforms. The endpoint is served at Path when set, and the pauses between
+transforms are never longer than MaxPause milliseconds.
+*/
+type PlaybackConfig struct {
+	Path     string `json:"path" yaml:"path"`
+	MaxPause int64  `json:"max_pause_ms" yaml:"max_pause_ms"`
+}
+
+/*
+NewPlaybackConfig - Creates a new PlaybackConfig object with default values, playback is disabled.
+*/
+func NewPlaybackConfig() PlaybackConfig {
+	return PlaybackConfig{
+		Path:     "",
+		MaxPause: 5000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+playbackHandler - Streams the recording of a document as server-sent events. Clients provide the
+document_id, a token with read only access and optionally an epoch and version to start from and a
+playback speed, where the epoch defaults to that of the first binding recorded. Each binding of the
+document begins with a 'document' message holding the document at the starting version along with
+the epoch of the binding, which is followed by a 'transforms' message for each transform recorded
+whilst bound. Versions begin again with each binding, and the stream closes with an 'end' message.
+Failing authorisation results in a 403, and a recording that cannot be found in a 404.
+*/
+func (h *HTTPServer) playbackHandler(w http.ResponseWriter, r *http.Request) {
+	locator, ok := h.locator.(RecordingLocator)
+	flusher, canFlush := w.(http.Flusher)
+	if !ok || !canFlush {
+		http.Error(w, "Playback is not supported", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	if !h.verifyClientToken(r, query.Get("token")) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	speed, version := 1.0, 0
+	var err error
+	if s := query.Get("speed"); len(s) > 0 {
+		if speed, err = strconv.ParseFloat(s, 64); err != nil || speed <= 0 {
+			http.Error(w, "Bad speed", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("version"); len(v) > 0 {
+		if version, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "Bad version", http.StatusBadRequest)
+			return
+		}
+	}
+
+	recording, err := locator.ReadRecording(query.Get("token"), query.Get("document_id"))
+	if err == nil {
+		recording, err = recording.Seek(query.Get("epoch"), version)
+	}
+	if err != nil {
+		h.stats.Incr("http.playback.error", 1)
+		h.logger.Errorf("Playback of %v failed: %v\n", query.Get("document_id"), err)
+		switch {
+		case err == lib.ErrUnauthorised:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case err == store.ErrInvalidDocumentPath:
+			http.Error(w, "Bad document_id", http.StatusBadRequest)
+		case err == lib.ErrRecordingDisabled:
+			http.Error(w, "Playback is not supported", http.StatusNotImplemented)
+		case err == lib.ErrEmptyRecording, err == lib.ErrRecordingNoEpoch, os.IsNotExist(err):
+			http.Error(w, "Recording not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to read recording", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.stats.Incr("http.playback.started", 1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(msg interface{}) error {
+		data, err := json.Marshal(msg)
+		if err == nil {
+			_, err = fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+		flusher.Flush()
+		return err
+	}
+
+	maxPause := time.Duration(h.config.Playback.MaxPause) * time.Millisecond
+
+	last := recording.Bindings[0].Started
+	wait := func(at time.Time) bool {
+		pause := time.Duration(float64(at.Sub(last)) / speed)
+		if pause > maxPause {
+			pause = maxPause
+		}
+		last = at
+
+		select {
+		case <-time.After(pause):
+			return true
+		case <-r.Context().Done():
+			h.stats.Incr("http.playback.cancelled", 1)
+			return false
+		}
+	}
+
+	for _, binding := range recording.Bindings {
+		if !wait(binding.Started) {
+			return
+		}
+		version := binding.Version
+		docMsg := LeapServerMessage{
+			Type:     "document",
+			Document: &binding.Document,
+			Version:  &version,
+			Epoch:    binding.Epoch,
+		}
+		docMsg.Signature = h.signer.Sign(docMsg)
+		if err = send(docMsg); err != nil {
+			return
+		}
+
+		for _, entry := range binding.Transforms {
+			if !wait(entry.Time) {
+				return
+			}
+			msg := LeapSocketServerMessage{
+				Type:       "transforms",
+				Transforms: []lib.OTransform{*entry.Transform},
+			}
+			msg.Signature = h.signer.Sign(msg)
+			if err = send(msg); err != nil {
+				return
+			}
+		}
+	}
+
+	send(LeapSocketServerMessage{Type: "end"})
+	h.stats.Incr("http.playback.finished", 1)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+preferencesHandler - Reads or sets the notification preference of a user for a document. Clients
+provide the document_id and their token, from which the user is identified. A GET responds with the current
+preference as JSON, and a PUT or POST sets it from a JSON body such as {"mode":"mute"} and responds
+with the preference as set.
+*/
+func (h *HTTPServer) preferencesHandler(w http.ResponseWriter, r *http.Request) {
+	locator, ok := h.locator.(PreferenceLocator)
+	if !ok {
+		http.Error(w, "Notification preferences are not supported", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	token, id := query.Get("token"), query.Get("document_id")
+	if !h.verifyClientToken(r, token) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var pref lib.NotificationPreference
+	var err error
+
+	switch r.Method {
+	case "GET":
+		pref, err = locator.GetPreference(token, id)
+	case "PUT", "POST":
+		var body []byte
+		if body, err = ioutil.ReadAll(r.Body); err == nil {
+			err = json.Unmarshal(body, &pref)
+		}
+		if err != nil {
+			h.stats.Incr("http.preferences.error", 1)
+			http.Error(w, "Bad data", http.StatusBadRequest)
+			return
+		}
+		err = locator.SetPreference(token, id, pref)
+	default:
+		http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		h.stats.Incr("http.preferences.error", 1)
+		if err == lib.ErrInvalidNotificationMode {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.logger.Warnf("Preferences request for %v failed: %v\n", id, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	h.stats.Incr("http.preferences.success", 1)
+
+	resultBytes, err := json.Marshal(pref)
+	if err != nil {
+		http.Error(w, "Error encoding preference", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultBytes)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
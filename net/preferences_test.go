@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+type fakePreferenceLocator struct {
+	fakeLocator
+	prefs *lib.Preferences
+}
+
+func (f *fakePreferenceLocator) GetPreference(token, id string) (lib.NotificationPreference, error) {
+	if token != "good" {
+		return lib.NotificationPreference{}, errors.New("not authorised")
+	}
+	return f.prefs.Get(token, id), nil
+}
+
+func (f *fakePreferenceLocator) SetPreference(token, id string, pref lib.NotificationPreference) error {
+	if token != "good" {
+		return errors.New("not authorised")
+	}
+	return f.prefs.Set(token, id, pref)
+}
+
+func TestPreferencesEndpoint(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	prefs, _ := lib.NewPreferences(lib.NewPreferencesConfig())
+	mux := NewMux()
+	if err := mux.Handle("app/", &fakePreferenceLocator{prefs: prefs}); err != nil {
+		t.Fatal(err)
+	}
+	h := HTTPServer{
+		config:  DefaultHTTPServerConfig(),
+		locator: mux,
+		logger:  logger,
+		stats:   stats,
+	}
+
+	request := func(method, token, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/preferences?document_id=app/doc&token="+token, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		h.preferencesHandler(w, r)
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) lib.NotificationPreference {
+		var pref lib.NotificationPreference
+		if err := json.Unmarshal(w.Body.Bytes(), &pref); err != nil {
+			t.Fatalf("Failed to decode %q: %v", w.Body.String(), err)
+		}
+		return pref
+	}
+
+	if w := request("GET", "good", ""); w.Code != http.StatusOK || decode(w).Mode != lib.NotifyAll {
+		t.Errorf("Wrong default preference: %v %v", w.Code, w.Body.String())
+	}
+	if w := request("PUT", "good", `{"mode":"mute"}`); w.Code != http.StatusOK || decode(w).Mode != lib.NotifyMute {
+		t.Errorf("Wrong response to update: %v %v", w.Code, w.Body.String())
+	}
+	if mode := prefs.Get("good", "doc").Mode; mode != lib.NotifyMute {
+		t.Errorf("Preference was not set without the route prefix: %v", mode)
+	}
+	if w := request("PUT", "good", `{"mode":"loud"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected bad request for invalid mode, received: %v", w.Code)
+	}
+	if w := request("GET", "bad", ""); w.Code != http.StatusForbidden {
+		t.Errorf("Expected forbidden, received: %v", w.Code)
+	}
+	if w := request("DELETE", "good", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected wrong method, received: %v", w.Code)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"github.com/jeffail/leaps/lib"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+PresenceEvent - Describes a user joining or leaving a document. Event is either 'join' or 'leave',
+and Metadata optionally carries details provided by the user such as a display name or colour.
+*/
+type PresenceEvent struct {
+	Event    string            `json:"event" yaml:"event"`
+	UserID   string            `json:"user_id" yaml:"user_id"`
+	Metadata map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+}
+
+/*
+PresenceOptions - The presence preferences of a websocket client. Clients that subscribe receive
+'presence' messages as users join and leave, and all clients may attach metadata which is shared
+with subscribed clients. Clients that set Spectators receive 'spectators' messages with the number
+of read only clients of the document, when the binder counts them. Clients that set Diagnostics
+receive 'diagnostics' messages when the document fails validation. Clients that set PeerAssist
+exchange 'signal' messages with other clients in order to share their cursors peer-to-peer.
+Extensions lists the protocol extensions agreed with the client when it joined.
+*/
+type PresenceOptions struct {
+	Subscribe   bool
+	Spectators  bool
+	Diagnostics bool
+	PeerAssist  bool
+	Metadata    map[string]string
+	Extensions  []string
+}
+
+/*
+presenceOptions - Returns the presence preferences of a joining client, agreeing on an extension is
+equivalent to setting its respective flag. The flags of older clients are only honoured when their
+extension is enabled for the server.
+*/
+func presenceOptions(msg LeapClientMessage, extensions, enabled []string) PresenceOptions {
+	legacy := func(flag bool, ext string) bool {
+		return (flag && hasExtension(enabled, ext)) || hasExtension(extensions, ext)
+	}
+	return PresenceOptions{
+		Subscribe:   legacy(msg.Presence, ExtensionPresence),
+		Spectators:  legacy(msg.Spectators, ExtensionSpectators),
+		Diagnostics: legacy(msg.Diagnostics, ExtensionDiagnostics),
+		PeerAssist:  hasExtension(extensions, ExtensionPeerAssist),
+		Metadata:    msg.Metadata,
+		Extensions:  extensions,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+presenceEvents - Converts client messages that announce presence into presence events.
+*/
+func presenceEvents(msgs ...lib.ClientMessage) []PresenceEvent {
+	events := []PresenceEvent{}
+	for _, msg := range msgs {
+		if len(msg.Presence) == 0 {
+			continue
+		}
+		events = append(events, PresenceEvent{
+			Event:    msg.Presence,
+			UserID:   msg.UserID,
+			Metadata: msg.Metadata,
+		})
+	}
+	return events
+}
+
+/*
+announceJoin - Let the other clients of the binder know that we have arrived, and if subscribed let
+our client know who is already here.
+*/
+func (w *WebsocketServer) announceJoin() {
+	w.binder.SendMessage(lib.ClientMessage{
+		Active:   true,
+		Presence: "join",
+		Metadata: w.presence.Metadata,
+	})
+	if w.presence.Subscribe && len(w.binder.Present) > 0 {
+		w.send(LeapSocketServerMessage{
+			Type:     "presence",
+			Presence: presenceEvents(w.binder.Present...),
+		})
+	}
+	w.binder.Present = nil
+
+	if w.presence.Spectators && w.binder.Spectators > 0 {
+		w.forwardSpectators(w.binder.Spectators)
+	}
+}
+
+/*
+announceLeave - Let the other clients of the binder know that we have left.
+*/
+func (w *WebsocketServer) announceLeave() {
+	w.binder.SendMessage(lib.ClientMessage{
+		Active:   false,
+		Presence: "leave",
+	})
+}
+
+/*
+forwardPresence - Forward a presence announcement from another client. Clients that have not
+subscribed to presence still receive departures as user updates, as they always have.
+*/
+func (w *WebsocketServer) forwardPresence(msg lib.ClientMessage) {
+	if w.presence.Subscribe {
+		w.send(LeapSocketServerMessage{
+			Type:     "presence",
+			Presence: presenceEvents(msg),
+		})
+	}
+	if msg.Presence == "leave" {
+		w.send(LeapSocketServerMessage{
+			Type:    "update",
+			Updates: []lib.ClientMessage{msg},
+		})
+	}
+}
+
+/*
+forwardDiagnostics - Send the validation problems of the document to clients that asked for them.
+*/
+func (w *WebsocketServer) forwardDiagnostics(problems []string) {
+	if w.presence.Diagnostics {
+		w.send(LeapSocketServerMessage{
+			Type:        "diagnostics",
+			Diagnostics: problems,
+		})
+	}
+}
+
+/*
+forwardSpectators - Send the number of spectators of the document to clients that asked for it.
+*/
+func (w *WebsocketServer) forwardSpectators(count int) {
+	if w.presence.Spectators {
+		w.send(LeapSocketServerMessage{
+			Type:       "spectators",
+			Spectators: &count,
+		})
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+forwardSignal - Send a signal from the client to the client it is addressed to, provided the client
+agreed on the peer_assist extension. Signals carry WebRTC session descriptions and ICE candidates,
+and are limited to maxSignalSize bytes of data.
+*/
+func (w *WebsocketServer) forwardSignal(signal *lib.PeerSignal) {
+	if !w.presence.PeerAssist {
+		w.sendError(MessageUnknownCommand, nil)
+		return
+	}
+	if signal == nil || len(signal.To) == 0 || len(signal.Data) > maxSignalSize {
+		w.stats.Incr("http.websocket.signal.rejected", 1)
+		return
+	}
+	w.binder.SendMessage(lib.ClientMessage{
+		Signal: &lib.PeerSignal{To: signal.To, Data: signal.Data},
+	})
+	w.stats.Incr("http.websocket.signal.success", 1)
+}
+
+// The largest amount of data accepted in a single signal, session descriptions are a few kilobytes.
+const maxSignalSize = 65536
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,228 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+	"golang.org/x/net/websocket"
+)
+
+func receivePresence(ws *websocket.Conn, t *testing.T) []PresenceEvent {
+	for {
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+
+		var serverMsg LeapSocketServerMessage
+		if err := websocket.JSON.Receive(ws, &serverMsg); err != nil {
+			t.Errorf("Receive error: %v", err)
+			return nil
+		}
+		switch serverMsg.Type {
+		case "presence":
+			return serverMsg.Presence
+		case "document", "update":
+		default:
+			t.Errorf("unexpected message type from server: %v", serverMsg.Type)
+			return nil
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+	httpServerConfig := DefaultHTTPServerConfig()
+	httpServerConfig.Address = "localhost:8255"
+	httpServerConfig.Path = "/presence/socket"
+	httpServerConfig.StaticFilePath = ""
+
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	go func() {
+		http, err := CreateHTTPServer(curator, httpServerConfig, logger, stats)
+		if err != nil {
+			t.Errorf("Create HTTP error: %v", err)
+			return
+		}
+		if err = http.Listen(); err != nil {
+			t.Errorf("Listen error: %v", err)
+		}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	origin, url := "http://localhost/", "ws://localhost:8255/presence/socket"
+
+	wsA, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wsA.Close()
+
+	websocket.JSON.Send(wsA, LeapClientMessage{
+		Command:  "create",
+		Token:    "alice_token",
+		Document: &store.Document{Content: "hello world"},
+		Presence: true,
+		Metadata: map[string]string{"name": "alice"},
+	})
+
+	var initResponse LeapServerMessage
+	if err = websocket.JSON.Receive(wsA, &initResponse); err != nil || initResponse.Type != "document" {
+		t.Fatalf("Init failed: %v, %v", err, initResponse.Error)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	wsB, err := websocket.Dial(url, "", origin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	websocket.JSON.Send(wsB, LeapClientMessage{
+		Command:  "find",
+		Token:    "bob_token",
+		DocID:    initResponse.Document.ID,
+		Presence: true,
+		Metadata: map[string]string{"name": "bob"},
+	})
+
+	present := receivePresence(wsB, t)
+	if len(present) != 1 || present[0].Event != "join" || present[0].Metadata["name"] != "alice" {
+		t.Errorf("Wrong users present: %v", present)
+	} else if present[0].UserID == "alice_token" {
+		t.Error("Token of a present user was sent to another client")
+	}
+
+	joined := receivePresence(wsA, t)
+	if len(joined) != 1 || joined[0].Event != "join" || joined[0].Metadata["name"] != "bob" {
+		t.Errorf("Wrong join event: %v", joined)
+	} else if joined[0].UserID == "bob_token" || len(joined[0].UserID) == 0 {
+		t.Errorf("Wrong user ID of joined user: %v", joined[0].UserID)
+	}
+
+	wsB.Close()
+
+	left := receivePresence(wsA, t)
+	if len(left) != 1 || left[0].Event != "leave" || left[0].UserID != joined[0].UserID {
+		t.Errorf("Wrong leave event: %v", left)
+	}
+}
+
+func TestPeerSignals(t *testing.T) {
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	config := DefaultHTTPServerConfig()
+	if hasExtension(config.Extensions, ExtensionPeerAssist) {
+		t.Error("Experimental extension enabled by default")
+	}
+	config.Extensions = append(config.Extensions, ExtensionPeerAssist)
+	config.ICEServers = []string{"stun:stun.example.com"}
+
+	h := HTTPServer{config: config, locator: curator, logger: logger, stats: stats}
+	server := httptest.NewServer(websocket.Handler(h.websocketHandler))
+	defer server.Close()
+
+	join := func(msg LeapClientMessage) (*websocket.Conn, LeapServerMessage) {
+		ws, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", "http://localhost/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		websocket.JSON.Send(ws, msg)
+		var init LeapServerMessage
+		if err = websocket.JSON.Receive(ws, &init); err != nil || init.Type != "document" {
+			t.Fatalf("Init failed: %v, %v", err, init.Error)
+		}
+		return ws, init
+	}
+
+	wsA, initA := join(LeapClientMessage{
+		Command:    "create",
+		Document:   &store.Document{Content: "hello world"},
+		Extensions: []string{ExtensionPeerAssist},
+	})
+	defer wsA.Close()
+	if len(initA.UserID) == 0 || len(initA.ICEServers) != 1 {
+		t.Errorf("Missing peer assist details: %+v", initA)
+	}
+
+	wsB, initB := join(LeapClientMessage{
+		Command:    "find",
+		DocID:      initA.Document.ID,
+		Extensions: []string{ExtensionPeerAssist},
+	})
+	defer wsB.Close()
+
+	wsC, initC := join(LeapClientMessage{Command: "find", DocID: initA.Document.ID})
+	defer wsC.Close()
+	if len(initC.UserID) > 0 {
+		t.Errorf("User ID sent without peer assist: %v", initC.UserID)
+	}
+
+	websocket.JSON.Send(wsA, LeapSocketClientMessage{
+		Command: "signal",
+		Signal:  &lib.PeerSignal{To: initB.UserID, Data: []byte(`{"type":"hello"}`)},
+	})
+
+	for {
+		wsB.SetReadDeadline(time.Now().Add(time.Second))
+		var msg LeapSocketServerMessage
+		if err := websocket.JSON.Receive(wsB, &msg); err != nil {
+			t.Fatalf("Receive error: %v", err)
+		}
+		if msg.Type != "signal" {
+			continue
+		}
+		if msg.Signal.From != initA.UserID || string(msg.Signal.Data) != `{"type":"hello"}` {
+			t.Errorf("Wrong signal: %+v", msg.Signal)
+		}
+		break
+	}
+
+	// Clients without the extension may not signal
+	websocket.JSON.Send(wsC, LeapSocketClientMessage{
+		Command: "signal",
+		Signal:  &lib.PeerSignal{To: initA.UserID, Data: []byte(`{}`)},
+	})
+	wsC.SetReadDeadline(time.Now().Add(time.Second))
+	var msg LeapSocketServerMessage
+	if err := websocket.JSON.Receive(wsC, &msg); err != nil || msg.Type != "error" || msg.Code != MessageUnknownCommand {
+		t.Errorf("Expected unknown command error: %v, %+v", err, msg)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"math"
+	gonet "net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RateLimitMiddlewareConfig - Holds configuration options for the RateLimitMiddleware, which limits
+the HTTP requests of each client address. A zero rate disables the limit, and Burst is the number
+of requests a client may make at once.
+*/
+type RateLimitMiddlewareConfig struct {
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"`
+	Burst             float64 `json:"burst" yaml:"burst"`
+}
+
+/*
+NewRateLimitMiddlewareConfig - Returns a RateLimitMiddleware configuration with the default values,
+where rate limiting is disabled.
+*/
+func NewRateLimitMiddlewareConfig() RateLimitMiddlewareConfig {
+	return RateLimitMiddlewareConfig{
+		RequestsPerSecond: 0,
+		Burst:             10,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RateLimitMiddleware - Limits the rate of HTTP requests from each client address. Every limited
+response carries the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers, and rejected
+requests receive a 429 with a Retry-After header so that clients are able to back off.
+*/
+type RateLimitMiddleware struct {
+	config  RateLimitMiddlewareConfig
+	limiter *lib.KeyedRateLimiter
+	logger  *log.Logger
+	stats   *log.Stats
+}
+
+/*
+NewRateLimitMiddleware - Create a new leaps RateLimitMiddleware.
+*/
+func NewRateLimitMiddleware(
+	config RateLimitMiddlewareConfig,
+	logger *log.Logger,
+	stats *log.Stats,
+) *RateLimitMiddleware {
+	limits := RateLimitMiddleware{
+		config: config,
+		logger: logger.NewModule(":rate_limit"),
+		stats:  stats,
+	}
+	if config.RequestsPerSecond > 0 {
+		limits.limiter = lib.NewKeyedRateLimiter(config.RequestsPerSecond, config.Burst)
+	}
+	return &limits
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+WrapHandler - Wrap an http request Handler with the RateLimitMiddleware limits.
+*/
+func (l *RateLimitMiddleware) WrapHandler(handler http.Handler) http.HandlerFunc {
+	return l.WrapHandlerFunc(handler.ServeHTTP)
+}
+
+/*
+WrapHandlerFunc - Wrap an http request HandlerFunc with the RateLimitMiddleware limits.
+*/
+func (l *RateLimitMiddleware) WrapHandlerFunc(handler http.HandlerFunc) http.HandlerFunc {
+	if l.limiter == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		state, allowed := l.limiter.Allow(clientAddress(r))
+		if !allowed {
+			l.stats.Incr("http.rate_limit.rejected", 1)
+			l.logger.Debugf("Rejected request from %v to %v\n", clientAddress(r), r.URL.Path)
+			writeRateLimited(w, state)
+			return
+		}
+		setRateLimitHeaders(w, state)
+		handler(w, r)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+clientAddress - The address a request was made from without its port.
+*/
+func clientAddress(r *http.Request) string {
+	host, _, err := gonet.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+/*
+headerSeconds - Format a duration as whole seconds for a header, rounding up so that clients never
+retry too soon.
+*/
+func headerSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+/*
+setRateLimitHeaders - Set the standard RateLimit headers of a response from the state of a limiter.
+*/
+func setRateLimitHeaders(w http.ResponseWriter, state lib.RateLimitState) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(state.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(state.Remaining))
+	w.Header().Set("RateLimit-Reset", headerSeconds(state.Reset))
+}
+
+/*
+writeRateLimited - Respond to a rejected request with a 429 carrying the RateLimit headers and a
+Retry-After header.
+*/
+func writeRateLimited(w http.ResponseWriter, state lib.RateLimitState) {
+	setRateLimitHeaders(w, state)
+	retry := state.RetryAfter
+	if retry < time.Second {
+		retry = time.Second
+	}
+	w.Header().Set("Retry-After", headerSeconds(retry))
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewRateLimitMiddlewareConfig()
+	config.RequestsPerSecond = 0.5
+	config.Burst = 2
+	limits := NewRateLimitMiddleware(config, logger, stats)
+
+	handler := limits.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	request := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		rec := request("10.0.0.1:1000")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Request %v was rejected: %v", i, rec.Code)
+		}
+		if exp, act := "2", rec.Header().Get("RateLimit-Limit"); exp != act {
+			t.Errorf("Wrong limit header: %v != %v", act, exp)
+		}
+		if act := rec.Header().Get("RateLimit-Remaining"); remaining != act {
+			t.Errorf("Wrong remaining header: %v != %v", act, remaining)
+		}
+	}
+
+	// The port of a client does not matter.
+	rec := request("10.0.0.1:2000")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Wrong status for limited request: %v", rec.Code)
+	}
+	if exp, act := "2", rec.Header().Get("Retry-After"); exp != act {
+		t.Errorf("Wrong retry after header: %v != %v", act, exp)
+	}
+	if exp, act := "4", rec.Header().Get("RateLimit-Reset"); exp != act {
+		t.Errorf("Wrong reset header: %v != %v", act, exp)
+	}
+
+	if rec = request("10.0.0.2:1000"); rec.Code != http.StatusOK {
+		t.Errorf("Other client was limited: %v", rec.Code)
+	}
+
+	unlimited := NewRateLimitMiddleware(NewRateLimitMiddlewareConfig(), logger, stats)
+	rec = httptest.NewRecorder()
+	unlimited.WrapHandlerFunc(func(w http.ResponseWriter, r *http.Request) {})(rec, httptest.NewRequest("GET", "/test", nil))
+	if len(rec.Header().Get("RateLimit-Limit")) > 0 {
+		t.Error("Disabled middleware set headers")
+	}
+}
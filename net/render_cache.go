@@ -0,0 +1,246 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RenderCacheConfig - Holds the limits of the cache of rendered exports of documents. MaxBytes bounds
+the total size of the rendered output held in memory and MaxEntries the number of renders, the least
+recently used renders are evicted beyond either. A MaxBytes of zero disables the cache.
+*/
+type RenderCacheConfig struct {
+	MaxBytes   int `json:"max_bytes" yaml:"max_bytes"`
+	MaxEntries int `json:"max_entries" yaml:"max_entries"`
+}
+
+/*
+NewRenderCacheConfig - Returns a RenderCacheConfig with default values.
+*/
+func NewRenderCacheConfig() RenderCacheConfig {
+	return RenderCacheConfig{
+		MaxBytes:   16 * 1024 * 1024,
+		MaxEntries: 1024,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RenderKey - Identifies a render of a document, being the format it was rendered to (such as html) and
+the version of the document it was rendered from. Epoch identifies the binding of the document that
+Version belongs to, as versions begin afresh each time a document is opened. Metadata changes without
+bumping the version, and so renders that include it carry its digest in Metadata, see
+MetadataDigest.
+*/
+type RenderKey struct {
+	ID       string
+	Format   string
+	Epoch    string
+	Version  int
+	Metadata string
+}
+
+/*
+MetadataDigest - Returns a digest of the metadata of a document for use in a RenderKey, which is the
+same for equal metadata regardless of the order of its keys.
+*/
+func MetadataDigest(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, k := range keys {
+		// Lengths prefix each key and value so that no two maps share an encoding.
+		fmt.Fprintf(hash, "%d:%s%d:%s", len(k), k, len(metadata[k]), metadata[k])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+/*
+Rendered - The output of rendering a document, along with the content type to serve it with.
+*/
+type Rendered struct {
+	Data        []byte
+	ContentType string
+}
+
+/*
+renderEntry - A render held by the cache.
+*/
+type renderEntry struct {
+	key      RenderKey
+	rendered Rendered
+}
+
+/*
+RenderCache - Holds rendered exports of documents so that repeated exports of an unchanged document
+are served from memory. Only the latest render of each document and format is held, and a render is
+only served for the version it was rendered from, so renders are invalidated by the next transform
+of their document. Writes that bypass the binder of a document, such as those of the documents
+endpoint, must call Invalidate. A nil RenderCache caches nothing, and is safe to use.
+*/
+type RenderCache struct {
+	config RenderCacheConfig
+	stats  *log.Stats
+
+	mutex   sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]map[string]*list.Element
+}
+
+/*
+NewRenderCache - Create a render cache, returns nil when the cache is disabled.
+*/
+func NewRenderCache(config RenderCacheConfig, stats *log.Stats) *RenderCache {
+	if config.MaxBytes <= 0 {
+		return nil
+	}
+	return &RenderCache{
+		config:  config,
+		stats:   stats,
+		lru:     list.New(),
+		entries: map[string]map[string]*list.Element{},
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Get - Returns the render of a document, if one is held for the version of the key.
+*/
+func (c *RenderCache) Get(key RenderKey) (Rendered, bool) {
+	if c == nil {
+		return Rendered{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key.ID][key.Format]; ok {
+		if entry := elem.Value.(*renderEntry); entry.key == key {
+			c.lru.MoveToFront(elem)
+			c.stats.Incr("http_admin.render_cache.hit", 1)
+			return entry.rendered, true
+		}
+	}
+	c.stats.Incr("http_admin.render_cache.miss", 1)
+	return Rendered{}, false
+}
+
+/*
+Put - Hold the render of a document, replacing any render of an earlier version in the same format.
+Renders larger than the whole cache are not held.
+*/
+func (c *RenderCache) Put(key RenderKey, rendered Rendered) {
+	if c == nil || len(rendered.Data) > c.config.MaxBytes {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key.ID][key.Format]; ok {
+		// A slow render must not replace that of a later version
+		if existing := elem.Value.(*renderEntry).key; existing.Epoch == key.Epoch && existing.Version > key.Version {
+			return
+		}
+		c.remove(elem)
+	}
+	formats, ok := c.entries[key.ID]
+	if !ok {
+		formats = map[string]*list.Element{}
+		c.entries[key.ID] = formats
+	}
+	formats[key.Format] = c.lru.PushFront(&renderEntry{key: key, rendered: rendered})
+	c.size += len(rendered.Data)
+
+	for c.size > c.config.MaxBytes || (c.config.MaxEntries > 0 && c.lru.Len() > c.config.MaxEntries) {
+		c.remove(c.lru.Back())
+		c.stats.Incr("http_admin.render_cache.evicted", 1)
+	}
+}
+
+/*
+Render - Returns the render of a document from the cache, or calls render and holds its output when
+the cache does not have it.
+*/
+func (c *RenderCache) Render(key RenderKey, render func() (Rendered, error)) (Rendered, error) {
+	if rendered, ok := c.Get(key); ok {
+		return rendered, nil
+	}
+	rendered, err := render()
+	if err != nil {
+		return rendered, err
+	}
+	c.Put(key, rendered)
+	return rendered, nil
+}
+
+/*
+Invalidate - Drop every render of a document.
+*/
+func (c *RenderCache) Invalidate(id string) {
+	if c == nil {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, elem := range c.entries[id] {
+		c.remove(elem)
+	}
+}
+
+/*
+remove - Drop a render from the cache, the mutex must be held.
+*/
+func (c *RenderCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*renderEntry)
+	c.size -= len(entry.rendered.Data)
+	if formats := c.entries[entry.key.ID]; formats != nil {
+		delete(formats, entry.key.Format)
+		if len(formats) == 0 {
+			delete(c.entries, entry.key.ID)
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRenderCache(t *testing.T) {
+	_, stats := loggerAndStats()
+	cache := NewRenderCache(RenderCacheConfig{MaxBytes: 10, MaxEntries: 3}, stats)
+
+	renders := 0
+	render := func(data string) func() (Rendered, error) {
+		return func() (Rendered, error) {
+			renders++
+			return Rendered{Data: []byte(data), ContentType: "text/html"}, nil
+		}
+	}
+
+	key := RenderKey{ID: "doc", Format: "html", Epoch: "a", Version: 1}
+	for i := 0; i < 2; i++ {
+		if rendered, err := cache.Render(key, render("<p>1</p>")); err != nil || string(rendered.Data) != "<p>1</p>" {
+			t.Errorf("Wrong render: %s, %v", rendered.Data, err)
+		}
+	}
+	if renders != 1 {
+		t.Errorf("Wrong count of renders: %v != %v", renders, 1)
+	}
+
+	// A new version is rendered again, and a late render of an old version is not held
+	newer := key
+	newer.Version = 2
+	cache.Render(newer, render("<p>2</p>"))
+	cache.Put(key, Rendered{Data: []byte("<p>1</p>")})
+	if rendered, ok := cache.Get(newer); !ok || string(rendered.Data) != "<p>2</p>" {
+		t.Errorf("Wrong render of new version: %s, %v", rendered.Data, ok)
+	}
+	if _, ok := cache.Get(key); ok {
+		t.Error("Expected render of old version to be gone")
+	}
+
+	// Renders of different metadata at the same version are distinct
+	withMeta := newer
+	withMeta.Metadata = MetadataDigest(map[string]string{"title": "a"})
+	if _, ok := cache.Get(withMeta); ok {
+		t.Error("Expected render of other metadata to be missed")
+	}
+	if MetadataDigest(map[string]string{"a": "bc"}) == MetadataDigest(map[string]string{"ab": "c"}) {
+		t.Error("Expected digests of different metadata to differ")
+	}
+
+	cache.Invalidate("doc")
+	if _, ok := cache.Get(newer); ok {
+		t.Error("Expected invalidated render to be gone")
+	}
+
+	// Least recently used renders are evicted beyond the size limit
+	cache.Put(RenderKey{ID: "one", Format: "txt"}, Rendered{Data: []byte("aaaa")})
+	cache.Put(RenderKey{ID: "two", Format: "txt"}, Rendered{Data: []byte("bbbb")})
+	cache.Get(RenderKey{ID: "one", Format: "txt"})
+	cache.Put(RenderKey{ID: "three", Format: "txt"}, Rendered{Data: []byte("cccc")})
+	if _, ok := cache.Get(RenderKey{ID: "two", Format: "txt"}); ok {
+		t.Error("Expected least recently used render to be evicted")
+	}
+	if _, ok := cache.Get(RenderKey{ID: "one", Format: "txt"}); !ok {
+		t.Error("Expected recently used render to be held")
+	}
+
+	errRender := errors.New("failed")
+	if _, err := cache.Render(RenderKey{ID: "bad"}, func() (Rendered, error) {
+		return Rendered{}, errRender
+	}); err != errRender {
+		t.Errorf("Wrong error: %v != %v", err, errRender)
+	}
+
+	var disabled *RenderCache
+	disabled.Put(key, Rendered{Data: []byte("x")})
+	if _, ok := disabled.Get(key); ok {
+		t.Error("Expected disabled cache to hold nothing")
+	}
+}
@@ -0,0 +1,671 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	gonet "net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/util/log"
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+RouterConfig - Options for a Router. Upstreams are the websocket URLs of the leaps nodes to route
+between, such as ws://node1:8001/leaps/socket, and each is placed on the hash ring VirtualNodes
+times in order to spread documents evenly. Origin is sent with the websocket handshake to every
+upstream.
+*/
+type RouterConfig struct {
+	Upstreams     []string `json:"upstreams" yaml:"upstreams"`
+	Origin        string   `json:"origin" yaml:"origin"`
+	VirtualNodes  int      `json:"virtual_nodes" yaml:"virtual_nodes"`
+	DialTimeout   int64    `json:"dial_timeout_ms" yaml:"dial_timeout_ms"`
+	BufferedItems int      `json:"buffered_items" yaml:"buffered_items"`
+}
+
+/*
+NewRouterConfig - Creates a new RouterConfig object with default values, no upstreams are set and
+therefore routing is disabled.
+*/
+func NewRouterConfig() RouterConfig {
+	return RouterConfig{
+		Upstreams:     []string{},
+		Origin:        "http://localhost/",
+		VirtualNodes:  64,
+		DialTimeout:   5000,
+		BufferedItems: 100,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Router type.
+var (
+	ErrNoUpstreams      = errors.New("router has no upstream nodes configured")
+	ErrRouterClosed     = errors.New("router is closed")
+	ErrUpstreamClosed   = errors.New("connection to upstream node was closed")
+	ErrUpstreamResponse = errors.New("unexpected response from upstream node")
+)
+
+/*
+ringPoint - A single point on the hash ring of a Router, and the upstream that owns it.
+*/
+type ringPoint struct {
+	hash     uint64
+	upstream string
+}
+
+type byRingHash []ringPoint
+
+func (b byRingHash) Len() int           { return len(b) }
+func (b byRingHash) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byRingHash) Less(i, j int) bool { return b[i].hash < b[j].hash }
+
+/*
+ringHash - Returns the position of a key on the hash ring, the first 64 bits of the SHA-256 hash of
+the key. FNV is cheaper but spreads the similar keys of virtual nodes too unevenly.
+*/
+func ringHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+/*
+Router - A LeapLocator that load balances documents between a set of upstream leaps nodes. Document
+IDs are hashed onto a consistent hash ring of the upstreams, so that every client of a document is
+routed to the same node, and adding or removing a node only moves the documents it owns. Clients are
+proxied to the owning node over its websocket API, which means the upstreams are unaware of the
+Router and authenticate the tokens of clients themselves.
+
+New documents are given their ID by the upstream that creates them, so a document is created on the
+upstream owning the submitted ID, or the token when there is none, and should the new ID belong to
+another upstream the client is then bound to the document on its owner instead. Presence, spectator
+and diagnostic messages are not requested from upstreams.
+
+When given an authenticator able to issue tokens the Router checks the token of a client itself, and
+then connects to upstreams with a short lived token issued for that connection alone, which means
+single use tokens still work when a new document is moved to its owner.
+*/
+type Router struct {
+	config RouterConfig
+	logger *log.Logger
+	stats  *log.Stats
+	ring   []ringPoint
+
+	authenticator auth.Authenticator
+	issuer        auth.TokenIssuer
+
+	bindings  map[*upstreamBinding]struct{}
+	mutex     sync.Mutex
+	closeChan chan struct{}
+	closeOnce sync.Once
+}
+
+/*
+NewRouter - Create a new Router across the upstreams of the config.
+*/
+func NewRouter(config RouterConfig, logger *log.Logger, stats *log.Stats) (*Router, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, ErrNoUpstreams
+	}
+	virtualNodes := config.VirtualNodes
+	if virtualNodes <= 0 {
+		virtualNodes = 1
+	}
+	ring := []ringPoint{}
+	for _, upstream := range config.Upstreams {
+		for i := 0; i < virtualNodes; i++ {
+			ring = append(ring, ringPoint{
+				hash:     ringHash(fmt.Sprintf("%v#%v", upstream, i)),
+				upstream: upstream,
+			})
+		}
+	}
+	sort.Sort(byRingHash(ring))
+
+	return &Router{
+		config:    config,
+		logger:    logger.NewModule(":router"),
+		stats:     stats,
+		ring:      ring,
+		bindings:  map[*upstreamBinding]struct{}{},
+		closeChan: make(chan struct{}),
+	}, nil
+}
+
+/*
+UseAuthenticator - Check the tokens of clients with an authenticator, which must also be able to
+issue the tokens that the Router then connects to upstreams with. The upstreams need to accept the
+tokens it issues, so they should share its configuration.
+*/
+func (r *Router) UseAuthenticator(authenticator auth.Authenticator) error {
+	issuer, ok := authenticator.(auth.TokenIssuer)
+	if !ok {
+		return auth.ErrIssueNotSupported
+	}
+	// Some authenticators only issue tokens in certain configurations, such as JWTs signed with HS256,
+	// which a token that expires immediately reveals.
+	if _, err := issuer.IssueToken(auth.TokenActionRead, "", time.Millisecond); err != nil {
+		return err
+	}
+	r.authenticator, r.issuer = authenticator, issuer
+	return nil
+}
+
+/*
+upstreamToken - Returns the token to connect to an upstream with for an action on a key. When the
+Router has no authenticator this is the token of the client, otherwise the client token is checked
+against the access the action requires and a new token is issued for the connection.
+*/
+func (r *Router) upstreamToken(token, action, key string) (string, error) {
+	if r.issuer == nil {
+		return token, nil
+	}
+	switch action {
+	case auth.TokenActionCreate:
+		if !r.authenticator.AuthoriseCreate(token, key) {
+			r.stats.Incr("router.auth.rejected", 1)
+			return "", lib.ErrUnauthorised
+		}
+	case auth.TokenActionJoin:
+		if !r.authenticator.Authorise(token, key).Grants(auth.AccessWrite) {
+			r.stats.Incr("router.auth.rejected", 1)
+			return "", lib.ErrUnauthorised
+		}
+	case auth.TokenActionRead:
+		if !r.authenticator.Authorise(token, key).Grants(auth.AccessRead) {
+			r.stats.Incr("router.auth.rejected", 1)
+			return "", lib.ErrUnauthorised
+		}
+	}
+	return r.issueToken(action, key)
+}
+
+/*
+issueToken - Issue a token for a single upstream connection, which only needs to live for as long
+as the connection takes to establish.
+*/
+func (r *Router) issueToken(action, key string) (string, error) {
+	ttl := time.Duration(r.config.DialTimeout) * time.Millisecond
+	issued, err := r.issuer.IssueToken(action, key, ttl)
+	if err != nil {
+		r.stats.Incr("router.auth.issue_error", 1)
+		r.logger.Errorf("Failed to issue %v token for upstream: %v\n", action, err)
+		return "", err
+	}
+	return issued, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Upstream - Returns the upstream that owns a document ID, which is the first point on the hash ring at
+or after the hash of the ID.
+*/
+func (r *Router) Upstream(id string) string {
+	hash := ringHash(id)
+	i := sort.Search(len(r.ring), func(i int) bool {
+		return r.ring[i].hash >= hash
+	})
+	if i == len(r.ring) {
+		i = 0
+	}
+	return r.ring[i].upstream
+}
+
+/*
+EditDocument - Bind to an existing document on the upstream that owns it.
+*/
+func (r *Router) EditDocument(token, id string) (lib.BinderPortal, error) {
+	upstreamToken, err := r.upstreamToken(token, auth.TokenActionJoin, id)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	initMsg := LeapClientMessage{Command: "find", Token: upstreamToken, DocID: id}
+	return r.bind(r.Upstream(id), token, initMsg, false)
+}
+
+/*
+ReadDocument - Bind to an existing document with read only privileges on the upstream that owns it.
+*/
+func (r *Router) ReadDocument(token, id string) (lib.BinderPortal, error) {
+	upstreamToken, err := r.upstreamToken(token, auth.TokenActionRead, id)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	initMsg := LeapClientMessage{Command: "read", Token: upstreamToken, DocID: id}
+	return r.bind(r.Upstream(id), token, initMsg, true)
+}
+
+/*
+CreateDocument - Create a new document on an upstream and bind to it on the upstream that owns its
+new ID. Without an authenticator the create token is also used to bind on the owner, and must
+therefore be accepted by it as a token for the new document.
+*/
+func (r *Router) CreateDocument(token, userID string, doc store.Document) (lib.BinderPortal, error) {
+	hint := doc.ID
+	if len(hint) == 0 {
+		hint = token
+	}
+	upstream := r.Upstream(hint)
+
+	upstreamToken, err := r.upstreamToken(token, auth.TokenActionCreate, userID)
+	if err != nil {
+		return lib.BinderPortal{}, err
+	}
+	portal, err := r.bind(upstream, token, LeapClientMessage{
+		Command:  "create",
+		Token:    upstreamToken,
+		UserID:   userID,
+		Document: &doc,
+	}, false)
+	if err != nil {
+		return portal, err
+	}
+
+	owner := r.Upstream(portal.Document.ID)
+	if owner == upstream {
+		return portal, nil
+	}
+	r.logger.Debugf("Moving new document %v from %v to its owner %v\n", portal.Document.ID, upstream, owner)
+	r.stats.Incr("router.create.moved", 1)
+
+	portal.Exit(time.Duration(r.config.DialTimeout) * time.Millisecond)
+
+	// The client was authorised to create the document, which entitles it to edit it on the owner.
+	id := portal.Document.ID
+	if r.issuer != nil {
+		if upstreamToken, err = r.issueToken(auth.TokenActionJoin, id); err != nil {
+			return lib.BinderPortal{}, err
+		}
+	}
+	initMsg := LeapClientMessage{Command: "find", Token: upstreamToken, DocID: id}
+	return r.bind(owner, token, initMsg, false)
+}
+
+/*
+Close - Close the Router, which disconnects all clients from their upstreams.
+*/
+func (r *Router) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closeChan)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for binding := range r.bindings {
+			binding.socket.Close()
+		}
+	})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+dial - Open a websocket connection to an upstream.
+*/
+func (r *Router) dial(upstream string) (*websocket.Conn, error) {
+	wsConfig, err := websocket.NewConfig(upstream, r.config.Origin)
+	if err != nil {
+		return nil, err
+	}
+	wsConfig.Dialer = &gonet.Dialer{Timeout: time.Duration(r.config.DialTimeout) * time.Millisecond}
+	return websocket.DialConfig(wsConfig)
+}
+
+/*
+bind - Send an init message to an upstream and, once it responds with the document, wrap the
+connection in a BinderPortal of the client token.
+*/
+func (r *Router) bind(
+	upstream, token string, initMsg LeapClientMessage, readOnly bool,
+) (lib.BinderPortal, error) {
+	select {
+	case <-r.closeChan:
+		return lib.BinderPortal{}, ErrRouterClosed
+	default:
+	}
+
+	socket, err := r.dial(upstream)
+	if err != nil {
+		r.stats.Incr("router.upstream.dial_error", 1)
+		r.logger.Errorf("Failed to connect to upstream %v: %v\n", upstream, err)
+		return lib.BinderPortal{}, err
+	}
+
+	// Upstreams disconnect clients that go quiet, heartbeats keep idle proxied clients connected.
+	initMsg.Extensions = []string{ExtensionHeartbeat}
+
+	socket.SetDeadline(time.Now().Add(time.Duration(r.config.DialTimeout) * time.Millisecond))
+	response, err := r.handshake(socket, initMsg)
+	if err != nil {
+		socket.Close()
+		r.stats.Incr("router.upstream.init_error", 1)
+		r.logger.Infof("Upstream %v failed to init client: %v\n", upstream, err)
+		return lib.BinderPortal{}, err
+	}
+	socket.SetDeadline(time.Time{})
+
+	binding := newUpstreamBinding(upstream, socket, token, readOnly, r.config.BufferedItems)
+
+	r.mutex.Lock()
+	select {
+	case <-r.closeChan:
+		r.mutex.Unlock()
+		socket.Close()
+		return lib.BinderPortal{}, ErrRouterClosed
+	default:
+	}
+	r.bindings[binding] = struct{}{}
+	r.mutex.Unlock()
+
+	go binding.loopIncoming(r.logger, r.stats)
+	go func() {
+		binding.loopOutgoing(r.logger, r.closeChan)
+
+		r.mutex.Lock()
+		delete(r.bindings, binding)
+		r.mutex.Unlock()
+	}()
+
+	r.stats.Incr("router.upstream.bound", 1)
+
+	portal := binding.portal()
+	portal.Document = *response.Document
+	portal.Version = *response.Version
+	return portal, nil
+}
+
+/*
+handshake - Send the init message to an upstream and wait for its init response, skipping any hello
+message that precedes it.
+*/
+func (r *Router) handshake(socket *websocket.Conn, initMsg LeapClientMessage) (LeapServerMessage, error) {
+	if err := websocket.JSON.Send(socket, initMsg); err != nil {
+		return LeapServerMessage{}, err
+	}
+	for {
+		var response LeapServerMessage
+		if err := websocket.JSON.Receive(socket, &response); err != nil {
+			return LeapServerMessage{}, err
+		}
+		switch response.Type {
+		case "hello":
+			continue
+		case "document":
+			if response.Document == nil || response.Version == nil {
+				return LeapServerMessage{}, ErrUpstreamResponse
+			}
+			return response, nil
+		case "error":
+			return LeapServerMessage{}, newUpstreamError(response.Error, response.ErrorInfo)
+		}
+		return LeapServerMessage{}, ErrUpstreamResponse
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+upstreamBinding - A client bound to a document on an upstream node, which translates between the
+channels of a BinderPortal and the websocket API of the upstream. Corrections from the upstream
+arrive in the order that transforms were submitted, and so are matched to the oldest pending
+submission.
+*/
+type upstreamBinding struct {
+	upstream string
+	socket   *websocket.Conn
+	token    string
+	readOnly bool
+
+	transformChan chan lib.OTransform
+	messageChan   chan lib.ClientMessage
+	submitChan    chan lib.TransformSubmission
+	updateChan    chan lib.MessageSubmission
+	exitChan      chan string
+	doneChan      chan struct{}
+
+	pending      []lib.TransformSubmission
+	pendingMutex sync.Mutex
+}
+
+/*
+newUpstreamBinding - Create an upstreamBinding around a websocket that has completed its init.
+*/
+func newUpstreamBinding(
+	upstream string, socket *websocket.Conn, token string, readOnly bool, buffered int,
+) *upstreamBinding {
+	return &upstreamBinding{
+		upstream:      upstream,
+		socket:        socket,
+		token:         token,
+		readOnly:      readOnly,
+		transformChan: make(chan lib.OTransform, buffered),
+		messageChan:   make(chan lib.ClientMessage, buffered),
+		submitChan:    make(chan lib.TransformSubmission),
+		updateChan:    make(chan lib.MessageSubmission),
+		exitChan:      make(chan string),
+		doneChan:      make(chan struct{}),
+	}
+}
+
+/*
+portal - Returns a BinderPortal to the binding, without a transform channel for read only bindings.
+*/
+func (u *upstreamBinding) portal() lib.BinderPortal {
+	portal := lib.BinderPortal{
+		Token:            u.token,
+		TransformRcvChan: u.transformChan,
+		MessageRcvChan:   u.messageChan,
+		MessageSndChan:   u.updateChan,
+		ExitChan:         u.exitChan,
+	}
+	if !u.readOnly {
+		portal.TransformSndChan = u.submitChan
+	}
+	return portal
+}
+
+/*
+pushPending - Add a submission to the back of the pending queue.
+*/
+func (u *upstreamBinding) pushPending(submission lib.TransformSubmission) {
+	u.pendingMutex.Lock()
+	defer u.pendingMutex.Unlock()
+	u.pending = append(u.pending, submission)
+}
+
+/*
+popPending - Remove and return the oldest pending submission, if there is one.
+*/
+func (u *upstreamBinding) popPending() (lib.TransformSubmission, bool) {
+	u.pendingMutex.Lock()
+	defer u.pendingMutex.Unlock()
+	if len(u.pending) == 0 {
+		return lib.TransformSubmission{}, false
+	}
+	submission := u.pending[0]
+	u.pending = u.pending[1:]
+	return submission, true
+}
+
+/*
+failPending - Respond to all pending submissions with an error.
+*/
+func (u *upstreamBinding) failPending(err error) {
+	for {
+		submission, ok := u.popPending()
+		if !ok {
+			return
+		}
+		submission.ErrorChan <- err
+	}
+}
+
+/*
+deliver - Send a transform or message to the portal, returning false if the client has gone.
+*/
+func (u *upstreamBinding) deliver(tform *lib.OTransform, msg *lib.ClientMessage) bool {
+	if tform != nil {
+		select {
+		case u.transformChan <- *tform:
+		case <-u.doneChan:
+			return false
+		}
+		return true
+	}
+	select {
+	case u.messageChan <- *msg:
+	case <-u.doneChan:
+		return false
+	}
+	return true
+}
+
+/*
+loopIncoming - Route messages from the upstream to the portal until the upstream closes, at which
+point the portal is closed.
+*/
+func (u *upstreamBinding) loopIncoming(logger *log.Logger, stats *log.Stats) {
+	defer func() {
+		u.failPending(ErrUpstreamClosed)
+		close(u.transformChan)
+		close(u.messageChan)
+	}()
+
+	for {
+		var msg LeapSocketServerMessage
+		if err := websocket.JSON.Receive(u.socket, &msg); err != nil {
+			logger.Debugf("Upstream %v closed: %v\n", u.upstream, err)
+			return
+		}
+
+		open := true
+		switch msg.Type {
+		case "transforms":
+			for i := 0; open && i < len(msg.Transforms); i++ {
+				open = u.deliver(&msg.Transforms[i], nil)
+			}
+		case "correction":
+			if submission, ok := u.popPending(); ok {
+				submission.VersionChan <- msg.Version
+			} else {
+				stats.Incr("router.upstream.unmatched_correction", 1)
+			}
+		case "update":
+			for i := 0; open && i < len(msg.Updates); i++ {
+				open = u.deliver(nil, &msg.Updates[i])
+			}
+		case "digest":
+			open = u.deliver(nil, &lib.ClientMessage{Digest: msg.Digest})
+		case "metadata":
+			open = u.deliver(nil, &lib.ClientMessage{DocumentMetadata: msg.Metadata})
+		case "comments":
+			for i := 0; open && i < len(msg.Comments); i++ {
+				open = u.deliver(nil, &lib.ClientMessage{Comment: &msg.Comments[i]})
+			}
+		case "shutdown":
+			open = u.deliver(nil, &lib.ClientMessage{Shutdown: true})
+		case "degraded":
+			open = u.deliver(nil, &lib.ClientMessage{Degraded: msg.Degraded})
+		case "ping":
+			websocket.JSON.Send(u.socket, LeapSocketClientMessage{Command: "pong", Ping: msg.Ping})
+		case "error":
+			if msg.Code == MessageKicked {
+				open = u.deliver(nil, &lib.ClientMessage{Kicked: true})
+				break
+			}
+			logger.Infof("Upstream %v sent error: %v\n", u.upstream, msg.Error)
+			if submission, ok := u.popPending(); ok {
+				submission.ErrorChan <- newUpstreamError(msg.Error, msg.ErrorInfo)
+			}
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+/*
+loopOutgoing - Route submissions from the portal to the upstream until the client exits or the
+router closes.
+*/
+func (u *upstreamBinding) loopOutgoing(logger *log.Logger, closeChan <-chan struct{}) {
+	defer func() {
+		close(u.doneChan)
+		u.socket.Close()
+	}()
+
+	for {
+		select {
+		case submission := <-u.submitChan:
+			u.pushPending(submission)
+			transform := submission.Transform
+			if err := websocket.JSON.Send(u.socket, LeapSocketClientMessage{
+				Command:   "submit",
+				Transform: &transform,
+			}); err != nil {
+				logger.Errorf("Failed to submit transform to upstream %v: %v\n", u.upstream, err)
+				u.failPending(err)
+			}
+		case submission := <-u.updateChan:
+			msg := submission.Message
+			update := LeapSocketClientMessage{
+				Command:  "update",
+				Position: msg.Position,
+				Message:  msg.Message,
+			}
+			if msg.DocumentMetadata != nil {
+				update = LeapSocketClientMessage{Command: "metadata", Metadata: msg.DocumentMetadata}
+			} else if msg.Comment != nil {
+				update = LeapSocketClientMessage{Command: "comment", Comment: msg.Comment}
+			}
+			if err := websocket.JSON.Send(u.socket, update); err != nil {
+				logger.Errorf("Failed to send update to upstream %v: %v\n", u.upstream, err)
+			}
+		case <-u.exitChan:
+			return
+		case <-closeChan:
+			return
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
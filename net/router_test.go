@@ -0,0 +1,298 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/auth"
+	"github.com/jeffail/leaps/lib/register"
+	"github.com/jeffail/leaps/lib/store"
+	"golang.org/x/net/websocket"
+)
+
+func TestRouterRing(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := NewRouterConfig()
+	if _, err := NewRouter(config, logger, stats); err != ErrNoUpstreams {
+		t.Errorf("Expected no upstreams error: %v", err)
+	}
+
+	config.Upstreams = []string{"ws://a/socket", "ws://b/socket", "ws://c/socket"}
+	router, err := NewRouter(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.Upstreams = append(config.Upstreams, "ws://d/socket")
+	grown, err := NewRouter(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	moved := 0
+	for i := 0; i < 3000; i++ {
+		id := fmt.Sprintf("doc%v", i)
+		upstream := router.Upstream(id)
+		if upstream != router.Upstream(id) {
+			t.Fatalf("Routing of %v is not stable", id)
+		}
+		counts[upstream]++
+		if after := grown.Upstream(id); after != upstream {
+			if after != "ws://d/socket" {
+				t.Errorf("Document %v moved between existing upstreams: %v -> %v", id, upstream, after)
+			}
+			moved++
+		}
+	}
+	for _, upstream := range []string{"ws://a/socket", "ws://b/socket", "ws://c/socket"} {
+		if counts[upstream] < 500 {
+			t.Errorf("Upstream %v owns too few documents: %v", upstream, counts[upstream])
+		}
+	}
+	if moved == 0 || moved > 1500 {
+		t.Errorf("Unexpected number of moved documents: %v", moved)
+	}
+}
+
+func TestRouterProxy(t *testing.T) {
+	logger, stats := loggerAndStats()
+	authenticator, storage := authAndStore(logger, stats)
+
+	upstreams := []string{}
+	for i := 0; i < 2; i++ {
+		curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, authenticator, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer curator.Close()
+
+		h := HTTPServer{
+			config:  DefaultHTTPServerConfig(),
+			locator: curator,
+			logger:  logger,
+			stats:   stats,
+		}
+		server := httptest.NewServer(websocket.Handler(h.websocketHandler))
+		defer server.Close()
+
+		upstreams = append(upstreams, "ws"+strings.TrimPrefix(server.URL, "http"))
+	}
+
+	config := NewRouterConfig()
+	config.Upstreams = upstreams
+	router, err := NewRouter(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+
+	creator, err := router.CreateDocument("token1", "user1", store.Document{Content: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creator.Document.Content != "hello" {
+		t.Errorf("Wrong content: %v", creator.Document.Content)
+	}
+	id := creator.Document.ID
+
+	editor, err := router.EditDocument("token2", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := router.ReadDocument("token3", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reader.TransformSndChan != nil {
+		t.Error("Read only portal has a transform channel")
+	}
+
+	version, err := editor.SendTransform(lib.OTransform{Position: 5, Insert: " world", Version: 2}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 2 {
+		t.Errorf("Wrong corrected version: %v", version)
+	}
+
+	for _, portal := range []lib.BinderPortal{creator, reader} {
+		select {
+		case tform := <-portal.TransformRcvChan:
+			if tform.Insert != " world" {
+				t.Errorf("Wrong transform received: %v", tform)
+			}
+		case <-time.After(time.Second):
+			t.Error("Timed out waiting for transform")
+		}
+	}
+
+	editor.SendMessage(lib.ClientMessage{Message: "hi"})
+	select {
+	case msg := <-creator.MessageRcvChan:
+		if msg.Message != "hi" {
+			t.Errorf("Wrong message received: %v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for message")
+	}
+
+	if _, err := router.EditDocument("token2", "nope"); err == nil {
+		t.Error("Expected error from missing document")
+	} else if info := classifyError(MessageInitFailed, err); info.Code != ErrorCodeDocNotFound {
+		t.Errorf("Proxied error lost its code: %v", info.Code)
+	}
+
+	router.Close()
+	select {
+	case _, open := <-editor.TransformRcvChan:
+		if open {
+			t.Error("Expected portal to close with the router")
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for portal to close")
+	}
+	if _, err := router.EditDocument("token2", id); err != ErrRouterClosed {
+		t.Errorf("Expected router closed error: %v", err)
+	}
+}
+
+/*
+singleUseAuth - Issues tokens that are accepted once, for the action and key they were issued for.
+*/
+type singleUseAuth struct {
+	tokens map[string]string
+	count  int
+	mutex  sync.Mutex
+}
+
+func (s *singleUseAuth) IssueToken(action, key string, ttl time.Duration) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count++
+	token := fmt.Sprintf("token%v", s.count)
+	s.tokens[token] = action + ":" + key
+	return token, nil
+}
+
+func (s *singleUseAuth) use(token, action, key string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.tokens[token] != action+":"+key {
+		return false
+	}
+	delete(s.tokens, token)
+	return true
+}
+
+func (s *singleUseAuth) AuthoriseCreate(token, userID string) bool {
+	return s.use(token, auth.TokenActionCreate, userID)
+}
+
+func (s *singleUseAuth) Authorise(token, documentID string) auth.AccessLevel {
+	if s.use(token, auth.TokenActionJoin, documentID) {
+		return auth.AccessWrite
+	}
+	if s.use(token, auth.TokenActionRead, documentID) {
+		return auth.AccessRead
+	}
+	return auth.AccessNone
+}
+
+func (s *singleUseAuth) RegisterHandlers(register register.PubPrivEndpointRegister) error {
+	return nil
+}
+
+func TestRouterIssuedTokens(t *testing.T) {
+	logger, stats := loggerAndStats()
+	_, storage := authAndStore(logger, stats)
+	authenticator := &singleUseAuth{tokens: map[string]string{}}
+
+	upstreams := []string{}
+	for i := 0; i < 2; i++ {
+		curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, authenticator, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer curator.Close()
+
+		h := HTTPServer{
+			config:  DefaultHTTPServerConfig(),
+			locator: curator,
+			logger:  logger,
+			stats:   stats,
+		}
+		server := httptest.NewServer(websocket.Handler(h.websocketHandler))
+		defer server.Close()
+
+		upstreams = append(upstreams, "ws"+strings.TrimPrefix(server.URL, "http"))
+	}
+
+	config := NewRouterConfig()
+	config.Upstreams = upstreams
+	router, err := NewRouter(config, logger, stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer router.Close()
+	if err = router.UseAuthenticator(authenticator); err != nil {
+		t.Fatal(err)
+	}
+
+	// Documents created on an upstream other than their owner are bound again, which must not
+	// require the single use token of the client a second time.
+	moved := 0
+	for i := 0; i < 16; i++ {
+		token, _ := authenticator.IssueToken(auth.TokenActionCreate, "user1", time.Minute)
+		portal, err := router.CreateDocument(token, "user1", store.Document{Content: "hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if portal.Token != token {
+			t.Errorf("Portal has the upstream token: %v != %v", portal.Token, token)
+		}
+		if router.Upstream(token) != router.Upstream(portal.Document.ID) {
+			moved++
+		}
+
+		if _, err = router.CreateDocument(token, "user1", store.Document{}); err != lib.ErrUnauthorised {
+			t.Errorf("Expected used create token to be rejected: %v", err)
+		}
+
+		readToken, _ := authenticator.IssueToken(auth.TokenActionRead, portal.Document.ID, time.Minute)
+		if _, err = router.EditDocument(readToken, portal.Document.ID); err != lib.ErrUnauthorised {
+			t.Errorf("Expected read token to be rejected for editing: %v", err)
+		}
+	}
+	if moved == 0 {
+		t.Error("Expected some documents to be moved to their owner")
+	}
+}
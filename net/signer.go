@@ -0,0 +1,197 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	binpath "github.com/jeffail/util/path"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+SigningConfig - Options for signing the messages sent from the server to clients. When enabled each
+message carries a base64 encoded ed25519 signature of its canonical form, and the public key is
+published at KeyPath. If PrivateKeyPath is empty a fresh key is generated at startup, which means
+clients must fetch the key again after each restart.
+*/
+type SigningConfig struct {
+	Enabled        bool   `json:"enabled" yaml:"enabled"`
+	PrivateKeyPath string `json:"private_key_path" yaml:"private_key_path"`
+	KeyPath        string `json:"key_path" yaml:"key_path"`
+}
+
+/*
+NewSigningConfig - Creates a new SigningConfig object with default values, signing is disabled.
+*/
+func NewSigningConfig() SigningConfig {
+	return SigningConfig{
+		Enabled:        false,
+		PrivateKeyPath: "",
+		KeyPath:        "/.well-known/leaps-signing-key",
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for the Signer type.
+var (
+	ErrInvalidSigningKey = errors.New("signing key file did not contain a PKCS8 ed25519 private key")
+)
+
+/*
+Signer - Signs messages sent to clients with an ed25519 key. A nil Signer is valid and produces empty
+signatures.
+
+The canonical form of a message is its JSON encoding without the signature field and without HTML
+escaping, with fields in the order they are sent. Clients verify a message by removing its
+signature field and serializing the remainder.
+*/
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+/*
+NewSigner - Creates a Signer from a config, returns nil if signing is disabled.
+*/
+func NewSigner(config SigningConfig) (*Signer, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	if len(config.PrivateKeyPath) == 0 {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &Signer{key: key}, nil
+	}
+
+	// If the key path is relative then we use the location of the binary to resolve it.
+	if err := binpath.FromBinaryIfRelative(&config.PrivateKeyPath); err != nil {
+		return nil, err
+	}
+	pemBytes, err := ioutil.ReadFile(config.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, ErrInvalidSigningKey
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidSigningKey
+	}
+	return &Signer{key: key}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+canonicalJSON - Encode a message into its canonical form.
+*/
+func canonicalJSON(msg interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(msg); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+/*
+Sign - Returns the base64 encoded signature of an unsigned message.
+*/
+func (s *Signer) Sign(msg interface{}) string {
+	if s == nil {
+		return ""
+	}
+	payload, err := canonicalJSON(msg)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload))
+}
+
+/*
+PublicKey - Returns the public half of the signing key.
+*/
+func (s *Signer) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+/*
+ServeKey - An HTTP handler that publishes the public key of the signer.
+*/
+func (s *Signer) ServeKey(w http.ResponseWriter, r *http.Request) {
+	resBytes, err := json.Marshal(struct {
+		Algorithm string `json:"algorithm"`
+		PublicKey string `json:"public_key"`
+	}{
+		Algorithm: "ed25519",
+		PublicKey: base64.StdEncoding.EncodeToString(s.PublicKey()),
+	})
+	if err != nil {
+		http.Error(w, "Failed to generate response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(resBytes)
+}
+
+/*
+VerifySignature - Verify the signature of a message, the message must have its signature field
+cleared.
+*/
+func VerifySignature(key ed25519.PublicKey, msg interface{}, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	payload, err := canonicalJSON(msg)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(key, payload, sig)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,102 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/jeffail/leaps/lib"
+)
+
+func TestSignerRoundTrip(t *testing.T) {
+	pub, key, _ := ed25519.GenerateKey(rand.Reader)
+	keyDer, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	keyFile, _ := ioutil.TempFile("", "leaps_signing")
+	keyFile.Write(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}))
+	keyFile.Close()
+	defer os.Remove(keyFile.Name())
+
+	config := NewSigningConfig()
+	config.Enabled = true
+	config.PrivateKeyPath = keyFile.Name()
+
+	signer, err := NewSigner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := LeapSocketServerMessage{
+		Type:       "transforms",
+		Transforms: []lib.OTransform{{Position: 3, Insert: "<b>&</b>", Version: 4}},
+	}
+	msg.Signature = signer.Sign(msg)
+
+	// Relay the message as a client would receive it
+	bytes, _ := json.Marshal(msg)
+	var received LeapSocketServerMessage
+	if err = json.Unmarshal(bytes, &received); err != nil {
+		t.Fatal(err)
+	}
+
+	signature := received.Signature
+	received.Signature = ""
+	if !VerifySignature(pub, received, signature) {
+		t.Error("Failed to verify relayed message")
+	}
+
+	received.Transforms[0].Insert = "<i>&</i>"
+	if VerifySignature(pub, received, signature) {
+		t.Error("Verified a tampered message")
+	}
+
+	recorder := httptest.NewRecorder()
+	signer.ServeKey(recorder, httptest.NewRequest("GET", config.KeyPath, nil))
+
+	var published struct {
+		PublicKey string `json:"public_key"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &published)
+	if exp, act := base64.StdEncoding.EncodeToString(pub), published.PublicKey; exp != act {
+		t.Errorf("Wrong published key: %v != %v", exp, act)
+	}
+}
+
+func TestSignerDisabled(t *testing.T) {
+	signer, err := NewSigner(NewSigningConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig := signer.Sign(LeapServerMessage{Type: "document"}); sig != "" {
+		t.Errorf("Expected no signature from a disabled signer, received: %v", sig)
+	}
+}
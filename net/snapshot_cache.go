@@ -0,0 +1,160 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jeffail/util/log"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+snapshotEntry - An init response being encoded, ready is closed once frame and err are set.
+*/
+type snapshotEntry struct {
+	ready   chan struct{}
+	frame   wireFrame
+	err     error
+	created time.Time
+}
+
+/*
+snapshotDocument - The cached init responses of the latest version of a document seen, keyed by
+everything else that varies between the responses of clients.
+*/
+type snapshotDocument struct {
+	epoch   string
+	version int
+	entries map[string]*snapshotEntry
+}
+
+/*
+snapshotCache - Shares the encoded init responses of documents between clients joining at the same
+version, so that a join storm encodes, signs and compresses each document once rather than once per
+client. Only the latest version of each document is held, and only for a short period after it was
+encoded. A nil snapshotCache encodes every response.
+*/
+type snapshotCache struct {
+	ttl       time.Duration
+	stats     *log.Stats
+	mutex     sync.Mutex
+	documents map[string]*snapshotDocument
+}
+
+/*
+newSnapshotCache - Returns a snapshotCache for a config, or nil when caching is disabled.
+*/
+func newSnapshotCache(config SnapshotConfig, stats *log.Stats) *snapshotCache {
+	if config.CacheTTL <= 0 {
+		return nil
+	}
+	return &snapshotCache{
+		ttl:       time.Duration(config.CacheTTL) * time.Millisecond,
+		stats:     stats,
+		documents: map[string]*snapshotDocument{},
+	}
+}
+
+/*
+frame - Returns the init response of a document at a version of a binding, identified by key, calling
+encode unless it is already cached. Concurrent calls for the same response wait for the first to
+encode it. Bindings without an epoch are never cached, as their versions may be reused.
+*/
+func (c *snapshotCache) frame(
+	id, epoch string,
+	version int,
+	key string,
+	encode func() (wireFrame, error),
+) (wireFrame, error) {
+	if c == nil || len(epoch) == 0 {
+		return encode()
+	}
+	now := time.Now()
+
+	c.mutex.Lock()
+	doc, exists := c.documents[id]
+	if exists && doc.epoch == epoch {
+		if doc.version > version {
+			// A join that raced with a newer one, not worth holding onto
+			c.mutex.Unlock()
+			return encode()
+		}
+		entry, cached := doc.entries[key]
+		if cached && doc.version == version && now.Sub(entry.created) <= c.ttl {
+			c.mutex.Unlock()
+			<-entry.ready
+			c.stats.Incr("http.snapshot_cache.hit", 1)
+			return entry.frame, entry.err
+		}
+	}
+
+	c.sweep(now)
+	if doc, exists = c.documents[id]; !exists || doc.epoch != epoch || doc.version != version {
+		doc = &snapshotDocument{
+			epoch:   epoch,
+			version: version,
+			entries: map[string]*snapshotEntry{},
+		}
+		c.documents[id] = doc
+	}
+	entry := &snapshotEntry{ready: make(chan struct{}), created: now}
+	doc.entries[key] = entry
+	c.mutex.Unlock()
+
+	c.stats.Incr("http.snapshot_cache.miss", 1)
+	entry.frame, entry.err = encode()
+	close(entry.ready)
+
+	if entry.err != nil {
+		c.mutex.Lock()
+		if doc.entries[key] == entry {
+			delete(doc.entries, key)
+		}
+		c.mutex.Unlock()
+	}
+	return entry.frame, entry.err
+}
+
+/*
+sweep - Drop the responses encoded longer than the TTL ago, and the documents left without any. Must
+be called with the mutex held.
+*/
+func (c *snapshotCache) sweep(now time.Time) {
+	for id, doc := range c.documents {
+		for key, entry := range doc.entries {
+			if now.Sub(entry.created) > c.ttl {
+				delete(doc.entries, key)
+			}
+		}
+		if len(doc.entries) == 0 {
+			delete(c.documents, id)
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
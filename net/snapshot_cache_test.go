@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestSnapshotCache(t *testing.T) {
+	_, stats := loggerAndStats()
+
+	config := NewSnapshotConfig()
+	config.CacheTTL = 0
+	if c := newSnapshotCache(config, stats); c != nil {
+		t.Error("Disabled config returned cache")
+	}
+
+	cache := newSnapshotCache(NewSnapshotConfig(), stats)
+
+	var encoded int32
+	encoder := func(data string) func() (wireFrame, error) {
+		return func() (wireFrame, error) {
+			atomic.AddInt32(&encoded, 1)
+			return wireFrame{data: []byte(data)}, nil
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			frame, err := cache.frame("doc", "epoch1", 2, "key", encoder("v2"))
+			if err != nil || string(frame.data) != "v2" {
+				t.Errorf("Wrong frame: %s, %v", frame.data, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if encoded != 1 {
+		t.Errorf("Expected a single encode, received: %v", encoded)
+	}
+
+	// Other keys are encoded separately
+	if frame, _ := cache.frame("doc", "epoch1", 2, "other", encoder("v2 other")); string(frame.data) != "v2 other" {
+		t.Errorf("Wrong frame: %s", frame.data)
+	}
+
+	// Newer versions replace older ones, and older versions are no longer cached
+	cache.frame("doc", "epoch1", 3, "key", encoder("v3"))
+	if frame, _ := cache.frame("doc", "epoch1", 2, "key", encoder("v2 again")); string(frame.data) != "v2 again" {
+		t.Errorf("Wrong frame: %s", frame.data)
+	}
+	if len(cache.documents["doc"].entries) != 1 {
+		t.Errorf("Wrong number of entries: %v", len(cache.documents["doc"].entries))
+	}
+
+	// A new binding starts over
+	if frame, _ := cache.frame("doc", "epoch2", 1, "key", encoder("epoch2")); string(frame.data) != "epoch2" {
+		t.Errorf("Wrong frame: %s", frame.data)
+	}
+
+	// Bindings without an epoch are never cached
+	atomic.StoreInt32(&encoded, 0)
+	cache.frame("other", "", 1, "key", encoder("a"))
+	cache.frame("other", "", 1, "key", encoder("b"))
+	if encoded != 2 {
+		t.Errorf("Expected uncached encodes, received: %v", encoded)
+	}
+}
+
+func TestInitCacheKey(t *testing.T) {
+	version := 5
+	doc := store.Document{ID: "doc", Content: "hello world"}
+	msg := LeapServerMessage{Type: "document", Document: &doc, Version: &version}
+
+	key, ok := initCacheKey(msg, "gzip")
+	if !ok {
+		t.Fatal("Expected document response to be cacheable")
+	}
+
+	doc.Content = "changed content"
+	if changed, _ := initCacheKey(msg, "gzip"); changed != key {
+		t.Error("Key should not depend on content")
+	}
+	if other, _ := initCacheKey(msg, "zstd"); other == key {
+		t.Error("Key should depend on codec")
+	}
+	doc.Metadata = map[string]string{"title": "hi"}
+	if other, _ := initCacheKey(msg, "gzip"); other == key {
+		t.Error("Key should depend on metadata")
+	}
+
+	msg.UserID = "user1"
+	if _, ok = initCacheKey(msg, "gzip"); ok {
+		t.Error("Responses addressing a client should not be cacheable")
+	}
+	msg.UserID, msg.Document = "", nil
+	if _, ok = initCacheKey(msg, "gzip"); ok {
+		t.Error("Responses without a document should not be cacheable")
+	}
+}
@@ -0,0 +1,247 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+SnapshotCodec - Compresses the snapshots of documents and the transforms of resyncs sent to clients
+that agree on the codec when joining.
+*/
+type SnapshotCodec interface {
+	// Encode - Returns the compressed form of data.
+	Encode(data []byte) ([]byte, error)
+
+	// Decode - Returns the data of a compressed form.
+	Decode(data []byte) ([]byte, error)
+}
+
+/*
+SnapshotConfig - Options for compressing the large payloads of init responses, being the document of
+a 'document' response and the missed transforms of a 'resume' response. Clients list the codecs
+they can decode in their init message, and the server uses the first of them that is also listed in
+Codecs once the payload is at least ThresholdBytes of JSON. This is independent of the deflate
+extension, and suits clients that would rather not have every message compressed. The encoded
+'document' responses of each version of a document are shared by the clients joining within
+CacheTTL milliseconds of each other, zero disables the cache.
+*/
+type SnapshotConfig struct {
+	Codecs         []string `json:"codecs" yaml:"codecs"`
+	ThresholdBytes int      `json:"threshold_bytes" yaml:"threshold_bytes"`
+	CacheTTL       int      `json:"cache_ttl_ms" yaml:"cache_ttl_ms"`
+}
+
+/*
+NewSnapshotConfig - Returns a default SnapshotConfig, which enables the built in codecs.
+*/
+func NewSnapshotConfig() SnapshotConfig {
+	return SnapshotConfig{
+		Codecs:         []string{"zstd", "brotli", "gzip"},
+		ThresholdBytes: 16384,
+		CacheTTL:       1000,
+	}
+}
+
+/*
+EncodedPayload - The compressed JSON of a payload in an init response, which the client decodes with
+Codec.
+*/
+type EncodedPayload struct {
+	Codec string `json:"codec" yaml:"codec"`
+	Data  []byte `json:"data" yaml:"data"`
+}
+
+// Errors for snapshot codecs.
+var (
+	ErrUnknownSnapshotCodec   = errors.New("unknown snapshot codec")
+	ErrDuplicateSnapshotCodec = errors.New("snapshot codec has already been registered")
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var (
+	snapshotCodecs = map[string]SnapshotCodec{
+		"gzip":   gzipCodec{},
+		"zstd":   newZstdCodec(),
+		"brotli": brotliCodec{},
+	}
+	snapshotCodecsMutex sync.RWMutex
+)
+
+/*
+RegisterSnapshotCodec - Make a codec available under a name, which may then be listed in the Codecs
+of a SnapshotConfig.
+*/
+func RegisterSnapshotCodec(name string, codec SnapshotCodec) error {
+	snapshotCodecsMutex.Lock()
+	defer snapshotCodecsMutex.Unlock()
+
+	if _, exists := snapshotCodecs[name]; exists {
+		return ErrDuplicateSnapshotCodec
+	}
+	snapshotCodecs[name] = codec
+	return nil
+}
+
+/*
+GetSnapshotCodec - Returns the codec registered under a name.
+*/
+func GetSnapshotCodec(name string) (SnapshotCodec, error) {
+	snapshotCodecsMutex.RLock()
+	defer snapshotCodecsMutex.RUnlock()
+
+	if codec, exists := snapshotCodecs[name]; exists {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("%v: %v", ErrUnknownSnapshotCodec, name)
+}
+
+/*
+validateSnapshotCodecs - Check that the codecs enabled for a server are all registered.
+*/
+func validateSnapshotCodecs(enabled []string) error {
+	for _, name := range enabled {
+		if _, err := GetSnapshotCodec(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+negotiateSnapshotCodec - Returns the first codec offered by a client that is enabled for the server,
+or an empty string if there are none.
+*/
+func negotiateSnapshotCodec(offered, enabled []string) string {
+	for _, name := range offered {
+		for _, e := range enabled {
+			if e == name {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+/*
+encodePayload - Returns the encoded form of a payload when it is large enough to be worth it, or nil
+otherwise.
+*/
+func encodePayload(payload interface{}, codecName string, threshold int) (*EncodedPayload, error) {
+	if len(codecName) == 0 {
+		return nil, nil
+	}
+	codec, err := GetSnapshotCodec(codecName)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil || len(data) < threshold {
+		return nil, err
+	}
+	if data, err = codec.Encode(data); err != nil {
+		return nil, err
+	}
+	return &EncodedPayload{Codec: codecName, Data: data}, nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+type gzipCodec struct{}
+
+func (gzipCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+/*
+zstdCodec - Encoders and decoders of zstd are safe to share, and expensive to create.
+*/
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec() zstdCodec {
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+	return zstdCodec{encoder: encoder, decoder: decoder}
+}
+
+func (z zstdCodec) Encode(data []byte) ([]byte, error) {
+	return z.encoder.EncodeAll(data, nil), nil
+}
+
+func (z zstdCodec) Decode(data []byte) ([]byte, error) {
+	return z.decoder.DecodeAll(data, nil)
+}
+
+type brotliCodec struct{}
+
+func (brotliCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := brotli.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (brotliCodec) Decode(data []byte) ([]byte, error) {
+	return ioutil.ReadAll(brotli.NewReader(bytes.NewReader(data)))
+}
@@ -0,0 +1,100 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestSnapshotCodecs(t *testing.T) {
+	data := []byte(strings.Repeat("hello world ", 1000))
+	for _, name := range NewSnapshotConfig().Codecs {
+		codec, err := GetSnapshotCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := codec.Encode(data)
+		if err != nil {
+			t.Fatalf("%v encode error: %v", name, err)
+		}
+		if len(encoded) >= len(data) {
+			t.Errorf("%v did not compress: %v", name, len(encoded))
+		}
+		decoded, err := codec.Decode(encoded)
+		if err != nil || string(decoded) != string(data) {
+			t.Errorf("%v failed to round trip: %v", name, err)
+		}
+	}
+
+	if err := RegisterSnapshotCodec("gzip", gzipCodec{}); err != ErrDuplicateSnapshotCodec {
+		t.Errorf("Wrong error for duplicate codec: %v", err)
+	}
+	if err := validateSnapshotCodecs([]string{"gzip", "lzma"}); err == nil {
+		t.Error("Expected error for unknown codec")
+	}
+	if exp, act := "gzip", negotiateSnapshotCodec([]string{"lzma", "gzip", "zstd"}, []string{"zstd", "gzip"}); exp != act {
+		t.Errorf("Wrong negotiated codec: %v != %v", act, exp)
+	}
+}
+
+func TestEncodeInitPayload(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	config := DefaultHTTPServerConfig()
+	config.Binder.Snapshots.ThresholdBytes = 100
+	h := HTTPServer{config: config, logger: logger, stats: stats}
+
+	doc := store.Document{ID: "doc", Content: strings.Repeat("content ", 100)}
+	msg := LeapServerMessage{Type: "document", Document: &doc}
+	h.encodeInitPayload(&msg, []string{"gzip"})
+	if msg.Document != nil || msg.Payload == nil || msg.Payload.Codec != "gzip" {
+		t.Fatalf("Document was not encoded: %v", msg)
+	}
+	codec, _ := GetSnapshotCodec("gzip")
+	data, err := codec.Decode(msg.Payload.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded store.Document
+	if err = json.Unmarshal(data, &decoded); err != nil || !reflect.DeepEqual(decoded, doc) {
+		t.Errorf("Wrong decoded document: %v, %v", decoded, err)
+	}
+
+	small := LeapServerMessage{Type: "resume", Transforms: []lib.OTransform{{Insert: "a", Version: 2}}}
+	h.encodeInitPayload(&small, []string{"zstd"})
+	if small.Payload != nil || len(small.Transforms) != 1 {
+		t.Errorf("Small payload was encoded: %v", small)
+	}
+
+	unoffered := LeapServerMessage{Type: "document", Document: &doc}
+	h.encodeInitPayload(&unoffered, nil)
+	if unoffered.Payload != nil {
+		t.Errorf("Payload was encoded without a codec: %v", unoffered)
+	}
+}
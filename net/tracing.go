@@ -0,0 +1,304 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+TraceConfig - Options for the protocol tracing of individual users or documents, which is switched
+on through the trace endpoint of the admin API when Enabled is set. Traced messages are written as
+JSON lines to the file at SinkPath, or stderr when empty, so that they don't mix with the regular
+logs. Traces switch themselves off after at most MaxDuration seconds.
+*/
+type TraceConfig struct {
+	Enabled     bool   `json:"enabled" yaml:"enabled"`
+	SinkPath    string `json:"sink_path" yaml:"sink_path"`
+	MaxDuration int64  `json:"max_duration_s" yaml:"max_duration_s"`
+}
+
+/*
+NewTraceConfig - Returns a default TraceConfig.
+*/
+func NewTraceConfig() TraceConfig {
+	return TraceConfig{
+		Enabled:     false,
+		SinkPath:    "",
+		MaxDuration: 3600,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Errors for protocol tracing.
+var (
+	ErrTraceNoTarget = errors.New("a trace requires a user_id or doc_id")
+)
+
+/*
+TraceEntry - A user or document currently being traced.
+*/
+type TraceEntry struct {
+	UserID  string `json:"user_id,omitempty"`
+	DocID   string `json:"doc_id,omitempty"`
+	Expires int64  `json:"expires_at"`
+}
+
+/*
+traceLine - A single traced message as written to the sink. Direction is 'in' for messages received
+from a client and 'out' for those sent to it.
+*/
+type traceLine struct {
+	Time      string      `json:"time"`
+	UserID    string      `json:"user_id"`
+	DocID     string      `json:"doc_id"`
+	Direction string      `json:"direction"`
+	Message   interface{} `json:"message"`
+}
+
+/*
+protocolTracer - Tracks which users and documents are traced, and writes their messages to a sink.
+*/
+type protocolTracer struct {
+	// Number of traces enabled, allows untraced connections to skip the lock.
+	enabled int32
+
+	mutex     sync.RWMutex
+	users     map[string]time.Time
+	documents map[string]time.Time
+
+	sinkMutex sync.Mutex
+	sink      io.Writer
+}
+
+// The tracer of this process, shared between the admin API and the websocket servers.
+var tracer = &protocolTracer{
+	users:     map[string]time.Time{},
+	documents: map[string]time.Time{},
+	sink:      os.Stderr,
+}
+
+/*
+setSink - Set where traced messages are written.
+*/
+func (t *protocolTracer) setSink(sink io.Writer) {
+	t.sinkMutex.Lock()
+	t.sink = sink
+	t.sinkMutex.Unlock()
+}
+
+/*
+set - Enable tracing of a user and/or document until a time, or disable it if the time is zero.
+*/
+func (t *protocolTracer) set(userID, docID string, until time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for _, target := range []struct {
+		id      string
+		targets map[string]time.Time
+	}{{userID, t.users}, {docID, t.documents}} {
+		if len(target.id) == 0 {
+			continue
+		}
+		if until.IsZero() {
+			delete(target.targets, target.id)
+		} else {
+			target.targets[target.id] = until
+		}
+	}
+	atomic.StoreInt32(&t.enabled, int32(len(t.users)+len(t.documents)))
+}
+
+/*
+active - Returns whether messages of a user on a document are traced.
+*/
+func (t *protocolTracer) active(userID, docID string) bool {
+	if atomic.LoadInt32(&t.enabled) == 0 {
+		return false
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	now := time.Now()
+	if until, ok := t.users[userID]; ok && until.After(now) {
+		return true
+	}
+	if until, ok := t.documents[docID]; ok && until.After(now) {
+		return true
+	}
+	return false
+}
+
+/*
+list - Returns the traces that have not yet expired, removing those that have.
+*/
+func (t *protocolTracer) list() []TraceEntry {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now, entries := time.Now(), []TraceEntry{}
+	for id, until := range t.users {
+		if !until.After(now) {
+			delete(t.users, id)
+			continue
+		}
+		entries = append(entries, TraceEntry{UserID: id, Expires: until.Unix()})
+	}
+	for id, until := range t.documents {
+		if !until.After(now) {
+			delete(t.documents, id)
+			continue
+		}
+		entries = append(entries, TraceEntry{DocID: id, Expires: until.Unix()})
+	}
+	atomic.StoreInt32(&t.enabled, int32(len(t.users)+len(t.documents)))
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].UserID != entries[j].UserID {
+			return entries[i].UserID < entries[j].UserID
+		}
+		return entries[i].DocID < entries[j].DocID
+	})
+	return entries
+}
+
+/*
+trace - Write a message of a user on a document to the sink if either is traced.
+*/
+func (t *protocolTracer) trace(userID, docID, direction string, msg interface{}) {
+	if !t.active(userID, docID) {
+		return
+	}
+	lineBytes, err := json.Marshal(traceLine{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		UserID:    userID,
+		DocID:     docID,
+		Direction: direction,
+		Message:   msg,
+	})
+	if err != nil {
+		return
+	}
+
+	t.sinkMutex.Lock()
+	t.sink.Write(append(lineBytes, '\n'))
+	t.sinkMutex.Unlock()
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+registerTraceEndpoint - Registers the endpoint for switching protocol tracing on and off, if tracing
+is enabled.
+*/
+func (i *InternalServer) registerTraceEndpoint() {
+	if !i.config.Tracing.Enabled {
+		return
+	}
+
+	// Register /trace endpoint for tracing the protocol messages of a user or document
+	i.Register(
+		"/trace",
+		`<GET|POST> List traced users and documents, or POST to trace one, a duration of zero stops tracing {"user_id":"<id>","doc_id":"<id>","duration_s":600}, traces are listed as {"user_id":"<id>","doc_id":"<id>","expires_at":1700000000}`,
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "GET":
+			case "POST":
+				bodyBytes, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					i.stats.Incr("http_admin.trace.error", 1)
+					i.logger.Errorf("/trace: %v\n", err)
+					http.Error(w, "Bad data", http.StatusBadRequest)
+					return
+				}
+				traceReq := struct {
+					UserID   string `json:"user_id"`
+					DocID    string `json:"doc_id"`
+					Duration int64  `json:"duration_s"`
+				}{}
+				if err = json.Unmarshal(bodyBytes, &traceReq); err != nil {
+					i.stats.Incr("http_admin.trace.error", 1)
+					i.logger.Errorf("/trace: %v\n", err)
+					http.Error(w, "Bad data", http.StatusBadRequest)
+					return
+				}
+				if len(traceReq.UserID) == 0 && len(traceReq.DocID) == 0 {
+					i.stats.Incr("http_admin.trace.error", 1)
+					http.Error(w, ErrTraceNoTarget.Error(), http.StatusBadRequest)
+					return
+				}
+
+				var until time.Time
+				if traceReq.Duration > 0 {
+					if max := i.config.Tracing.MaxDuration; max > 0 && traceReq.Duration > max {
+						traceReq.Duration = max
+					}
+					until = time.Now().Add(time.Duration(traceReq.Duration) * time.Second)
+					i.logger.Infof("/trace: Tracing user '%v' document '%v' for %vs\n",
+						traceReq.UserID, traceReq.DocID, traceReq.Duration)
+				} else {
+					i.logger.Infof("/trace: Stopped tracing user '%v' document '%v'\n",
+						traceReq.UserID, traceReq.DocID)
+				}
+				tracer.set(traceReq.UserID, traceReq.DocID, until)
+			default:
+				i.stats.Incr("http_admin.trace.error", 1)
+				i.logger.Warnf("/trace: Wrong method %v\n", r.Method)
+				http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+				return
+			}
+
+			resultBytes, err := json.Marshal(tracer.list())
+			if err != nil {
+				i.stats.Incr("http_admin.trace.error", 1)
+				i.logger.Errorf("/trace: %v\n", err)
+				http.Error(w, "Error listing traces", http.StatusInternalServerError)
+				return
+			}
+
+			i.stats.Incr("http_admin.trace.success", 1)
+
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(resultBytes)
+		})
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
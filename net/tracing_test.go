@@ -0,0 +1,141 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestProtocolTracer(t *testing.T) {
+	var sink bytes.Buffer
+	pt := &protocolTracer{
+		users:     map[string]time.Time{},
+		documents: map[string]time.Time{},
+		sink:      &sink,
+	}
+
+	pt.trace("alice", "doc1", "in", LeapSocketClientMessage{Command: "ping"})
+	if sink.Len() > 0 {
+		t.Errorf("Untraced message was written: %s", sink.String())
+	}
+
+	pt.set("alice", "", time.Now().Add(time.Minute))
+	pt.set("", "doc2", time.Now().Add(time.Minute))
+	pt.set("bob", "", time.Now().Add(-time.Minute))
+
+	if !pt.active("alice", "doc1") || !pt.active("bob", "doc2") || pt.active("bob", "doc1") {
+		t.Error("Wrong traces active")
+	}
+
+	pt.trace("alice", "doc1", "in", LeapSocketClientMessage{Command: "ping"})
+	pt.trace("bob", "doc1", "out", LeapSocketServerMessage{Type: "ping"})
+
+	lines := strings.Split(strings.TrimSpace(sink.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Wrong number of traced lines: %v", lines)
+	}
+	var line struct {
+		Time      string                  `json:"time"`
+		UserID    string                  `json:"user_id"`
+		Direction string                  `json:"direction"`
+		Message   LeapSocketClientMessage `json:"message"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, line.Time); err != nil {
+		t.Errorf("Bad timestamp: %v", err)
+	}
+	if line.UserID != "alice" || line.Direction != "in" || line.Message.Command != "ping" {
+		t.Errorf("Wrong traced line: %v", lines[0])
+	}
+
+	if entries := pt.list(); len(entries) != 2 {
+		t.Errorf("Expired trace was listed: %v", entries)
+	}
+
+	pt.set("alice", "doc2", time.Time{})
+	if entries := pt.list(); len(entries) != 0 {
+		t.Errorf("Stopped traces were listed: %v", entries)
+	}
+	if pt.enabled != 0 {
+		t.Errorf("Wrong count of enabled traces: %v", pt.enabled)
+	}
+}
+
+func TestTraceEndpoint(t *testing.T) {
+	log, stats := loggerAndStats()
+
+	config := NewInternalServerConfig()
+	config.Path = "/internal"
+	config.Tracing.Enabled = true
+	config.Tracing.MaxDuration = 60
+
+	internalServer, err := NewInternalServer(
+		FakeDocumentAdmin{documents: map[string]store.Document{}}, config, log, stats,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.set("carol", "", time.Time{})
+
+	res := httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("POST", "/internal/trace",
+		bytes.NewBufferString(`{"duration_s":10}`)))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Wrong status for trace without a target: %v", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("POST", "/internal/trace",
+		bytes.NewBufferString(`{"user_id":"carol","duration_s":100000}`)))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Wrong status for trace: %v", res.Code)
+	}
+
+	var entries []TraceEntry
+	if err = json.Unmarshal(res.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].UserID != "carol" {
+		t.Fatalf("Wrong traces: %v", entries)
+	}
+	if limit := time.Now().Add(61 * time.Second).Unix(); entries[0].Expires > limit {
+		t.Errorf("Trace duration was not capped: %v > %v", entries[0].Expires, limit)
+	}
+
+	res = httptest.NewRecorder()
+	internalServer.mux.ServeHTTP(res, httptest.NewRequest("POST", "/internal/trace",
+		bytes.NewBufferString(`{"user_id":"carol","duration_s":0}`)))
+	if res.Code != http.StatusOK || strings.TrimSpace(res.Body.String()) != "[]" {
+		t.Errorf("Trace was not stopped: %v %v", res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Transport - A bidirectional connection of a single leaps client, over which the messages of the
+leaps protocol are exchanged. Receive decodes the next LeapClientMessage sent by the client into
+msg, Send encodes a LeapServerMessage or LeapSocketServerMessage for the client, and Close ends the
+connection. Send may be called concurrently with Receive, but never concurrently with itself.
+
+Transports are free to choose their encoding, although leaps clients expect the JSON encoding of the
+messages. A Transport that implements SetReadDeadline(time.Time) error has clients reaped once they
+fall silent for longer than the heartbeat timeout, and one that implements Request() *http.Request
+has the request it was opened with used for locale negotiation and client certificates.
+*/
+type Transport interface {
+	// Send - Send a message to the client.
+	Send(msg interface{}) error
+
+	// Receive - Block until the client sends a message and decode it into msg.
+	Receive(msg interface{}) error
+
+	// Close - Close the connection to the client.
+	Close() error
+}
+
+/*
+TransportListener - A source of fresh client connections, much like a net.Listener. Accept blocks
+until a client connects, and returns an error once the listener is closed.
+*/
+type TransportListener interface {
+	// Accept - Block until a client connects and return its transport.
+	Accept() (Transport, error)
+
+	// Close - Stop accepting clients, unblocking any pending call to Accept.
+	Close() error
+}
+
+/*
+readDeadliner - Implemented by transports that support read deadlines.
+*/
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+/*
+requestTransport - Implemented by transports that were opened with an HTTP request.
+*/
+type requestTransport interface {
+	Request() *http.Request
+}
+
+/*
+transportRequest - Returns the HTTP request a transport was opened with, or nil.
+*/
+func transportRequest(t Transport) *http.Request {
+	if r, ok := t.(requestTransport); ok {
+		return r.Request()
+	}
+	return nil
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+websocketTransport - The Transport of websocket clients, exchanging JSON encoded text frames, or
+MessagePack encoded binary frames once the client has agreed on the binary extension.
+*/
+type websocketTransport struct {
+	*websocket.Conn
+	binary bool
+}
+
+/*
+newWebsocketTransport - Wraps a websocket connection as a Transport.
+*/
+func newWebsocketTransport(ws *websocket.Conn) *websocketTransport {
+	return &websocketTransport{Conn: ws}
+}
+
+/*
+useBinary - Send all further messages as MessagePack binary frames, which must only be called once
+the client has agreed on the binary extension and before the transport is shared.
+*/
+func (w *websocketTransport) useBinary() {
+	w.binary = true
+}
+
+/*
+Send - Send a message to the client as a JSON text frame, or as a MessagePack binary frame.
+*/
+func (w *websocketTransport) Send(msg interface{}) error {
+	if w.binary {
+		return binaryCodec.Send(w.Conn, msg)
+	}
+	return websocket.JSON.Send(w.Conn, msg)
+}
+
+/*
+Receive - Decode the next frame of the client into msg, which is JSON when sent as a text frame and
+MessagePack when sent as a binary frame.
+*/
+func (w *websocketTransport) Receive(msg interface{}) error {
+	return binaryCodec.Receive(w.Conn, msg)
+}
+
+/*
+binaryCodec - Encodes messages as MessagePack binary frames and decodes frames of either encoding.
+Messages are converted through their JSON form, so that both encodings carry exactly the same
+fields.
+*/
+var binaryCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, websocket.BinaryFrame, err
+		}
+		var generic interface{}
+		if err = json.Unmarshal(data, &generic); err != nil {
+			return nil, websocket.BinaryFrame, err
+		}
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.UseCompactInts(true)
+		enc.UseCompactFloats(true)
+		err = enc.Encode(generic)
+		return buf.Bytes(), websocket.BinaryFrame, err
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		if payloadType != websocket.BinaryFrame {
+			return json.Unmarshal(data, v)
+		}
+		var generic interface{}
+		if err := msgpack.Unmarshal(data, &generic); err != nil {
+			return err
+		}
+		jsonData, err := json.Marshal(generic)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(jsonData, v)
+	},
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ServeTransport - Serve the clients accepted from a listener with the same protocol as websocket
+clients, blocking until the listener fails or the HTTPServer is stopped, at which point the listener
+is closed. This allows leaps to be served over transports other than websockets.
+*/
+func (h *HTTPServer) ServeTransport(listener TransportListener) error {
+	stoppedChan := make(chan struct{})
+	defer close(stoppedChan)
+
+	go func() {
+		select {
+		case <-h.closeChan:
+			listener.Close()
+		case <-stoppedChan:
+		}
+	}()
+
+	for {
+		transport, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-h.closeChan:
+				return nil
+			default:
+			}
+			return err
+		}
+		go h.serveTransport(transport)
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
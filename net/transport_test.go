@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+func TestServeTransport(t *testing.T) {
+	logger, stats := loggerAndStats()
+	auth, storage := authAndStore(logger, stats)
+
+	curator, err := lib.NewCurator(lib.DefaultCuratorConfig(), logger, stats, auth, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer curator.Close()
+
+	h := HTTPServer{
+		config:    DefaultHTTPServerConfig(),
+		locator:   curator,
+		logger:    logger,
+		stats:     stats,
+		closeChan: make(chan bool),
+	}
+
+	listener := NewInProcessListener()
+	servedChan := make(chan error)
+	go func() {
+		servedChan <- h.ServeTransport(listener)
+	}()
+
+	client, err := listener.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = client.Send(LeapClientMessage{
+		Command:  "create",
+		Token:    "transport_token",
+		UserID:   "transport_user",
+		Document: &store.Document{Content: "hello world"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var initMsg LeapServerMessage
+	if err = client.Receive(&initMsg); err != nil {
+		t.Fatal(err)
+	}
+	if initMsg.Type != "document" || initMsg.Document == nil || initMsg.Document.Content != "hello world" {
+		t.Fatalf("Wrong init response: %v", initMsg)
+	}
+
+	if err = client.Send(LeapSocketClientMessage{
+		Command:   "submit",
+		Transform: &lib.OTransform{Position: 5, Insert: " there", Version: 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var correction LeapSocketServerMessage
+	if err = client.Receive(&correction); err != nil {
+		t.Fatal(err)
+	}
+	if correction.Type != "correction" || correction.Version != 2 {
+		t.Errorf("Wrong correction: %v", correction)
+	}
+
+	client.Close()
+	h.Stop()
+
+	select {
+	case err = <-servedChan:
+		if err != nil {
+			t.Errorf("ServeTransport failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("ServeTransport did not return after stopping")
+	}
+}
@@ -23,6 +23,7 @@ THE SOFTWARE.
 package net
 
 import (
+	"io"
 	"time"
 
 	"github.com/jeffail/leaps/lib"
@@ -64,5 +65,246 @@ type LeapAdmin interface {
 	GetUsers(timeout time.Duration) (map[string][]string, error)
 }
 
+/*
+BannerLocator - An optional extension of LeapLocator for showing a server wide banner to clients,
+which is sent to clients as they join, and to connected clients as it changes.
+*/
+type BannerLocator interface {
+	// Banner - Return the current banner, or an empty string if there is none.
+	Banner() string
+}
+
+/*
+ThrottledLocator - An optional extension of LeapLocator for read only clients that receive at most
+one batch of transforms per period.
+*/
+type ThrottledLocator interface {
+	// ReadDocumentThrottled - Find and return a throttled binder portal to an existing document with
+	// read only priviledges
+	ReadDocumentThrottled(token, id string, period time.Duration) (lib.BinderPortal, error)
+}
+
+/*
+ResumeLocator - An optional extension of LeapLocator for clients that reconnect already holding a
+document at a particular version of an epoch, who receive only the transforms they missed when
+these are still available.
+*/
+type ResumeLocator interface {
+	// ResumeDocument - Find and return a binder portal to an existing document, resumed from a
+	// version where possible
+	ResumeDocument(token, id, epoch string, version int) (lib.BinderPortal, error)
+}
+
+/*
+UserBanner - An optional extension of LeapAdmin for banning users from documents.
+*/
+type UserBanner interface {
+	// Kick a user from a document and refuse their return for a duration, a duration of zero or
+	// less lifts the ban.
+	BanUser(documentID, userID string, duration, timeout time.Duration) error
+}
+
+/*
+Announcer - An optional extension of LeapAdmin for broadcasting announcements to every connected
+client.
+*/
+type Announcer interface {
+	// Send a one-off announcement to every client of every open document.
+	Announce(announcement lib.Announcement, timeout time.Duration) error
+}
+
+/*
+BinderInspector - An optional extension of LeapAdmin for inspecting the live state of open
+documents.
+*/
+type BinderInspector interface {
+	// Summarise every open binder.
+	InspectBinders(timeout time.Duration) ([]lib.BinderInfo, error)
+
+	// Summarise the binder of a single open document.
+	InspectBinder(documentID string, timeout time.Duration) (lib.BinderInfo, error)
+}
+
+/*
+BinderController - An optional extension of LeapAdmin for flushing and closing open documents on
+demand.
+*/
+type BinderController interface {
+	// Flush the pending changes of an open document, returning the version flushed.
+	FlushDocument(documentID string, timeout time.Duration) (int, error)
+
+	// Close an open document, disconnecting its clients.
+	CloseDocument(documentID string) error
+}
+
+/*
+LeaseBreaker - An optional extension of LeapAdmin for breaking the stale leases of failed nodes over
+documents when clustered.
+*/
+type LeaseBreaker interface {
+	// Return the node holding the lease over a document and the fingerprint of the stored document.
+	InspectLease(documentID string) (lib.LeaseInfo, error)
+
+	// Break the lease over a document, provided the node and fingerprint are unchanged.
+	BreakLease(documentID, node, fingerprint string) error
+}
+
+/*
+ClusterReporter - An optional extension of LeapAdmin for reporting the cluster protocol spoken by the
+node and its peers, which shows when nodes of different versions are mixed.
+*/
+type ClusterReporter interface {
+	// Get the protocol of this node and of the peers it has recently heard from.
+	GetClusterStatus() (lib.ClusterStatus, error)
+}
+
+/*
+RecoveryReporter - An optional extension of LeapAdmin for reporting the outcome of the transform log
+recovery scan performed on startup.
+*/
+type RecoveryReporter interface {
+	// Get the report of recovered and unrecoverable documents.
+	GetRecoveryReport() lib.RecoveryReport
+}
+
+/*
+CapabilityReporter - An optional extension of LeapLocator for advertising the document features and
+limits of the locator to clients.
+*/
+type CapabilityReporter interface {
+	// Get the document features and limits of the locator.
+	Capabilities() lib.Capabilities
+}
+
+/*
+ShutdownReporter - An optional extension of LeapAdmin for reporting the outcome of draining ahead of
+shutting down.
+*/
+type ShutdownReporter interface {
+	// Get the report of flushed and unflushed documents, false until draining has finished.
+	GetShutdownReport() (lib.ShutdownReport, bool)
+}
+
+/*
+DocumentAdmin - An optional extension of LeapAdmin for reading, writing and deleting stored
+documents directly.
+*/
+type DocumentAdmin interface {
+	// Read the latest flushed content of a document.
+	GetDocument(documentID string) (store.Document, error)
+
+	// Create or overwrite a document that is not open.
+	PutDocument(doc store.Document) error
+
+	// Delete a document, closing it first if it is open.
+	DeleteDocument(documentID string) error
+}
+
+/*
+LiveDocumentReader - An optional extension of DocumentAdmin for reading the live content of open
+documents, including the changes that have not yet been flushed to the store.
+*/
+type LiveDocumentReader interface {
+	// Read the latest content of a document, open or not.
+	GetLiveDocument(documentID string, timeout time.Duration) (store.Document, error)
+}
+
+/*
+DocumentExporter - An optional extension of LeapAdmin for exporting the content of documents.
+*/
+type DocumentExporter interface {
+	// Read the latest content of a document, open or not, along with its version when open.
+	ExportDocument(documentID string, timeout time.Duration) (lib.DocumentExport, error)
+}
+
+/*
+DocumentLister - An optional extension of DocumentAdmin for listing stored documents.
+*/
+type DocumentLister interface {
+	// Return a summary of every stored document.
+	ListDocuments() ([]lib.DocumentSummary, error)
+}
+
+/*
+Drainer - An optional extension of LeapLocator for shutting down gracefully, where connected clients
+are notified and given a grace period to leave before documents are flushed and closed.
+*/
+type Drainer interface {
+	// Refuse new clients, notify existing ones and close after the grace period.
+	Drain(grace time.Duration) error
+}
+
+/*
+DocumentMerger - An optional extension of DocumentAdmin for writing to existing documents by
+merging the difference as transforms, which is allowed even while the document is open.
+*/
+type DocumentMerger interface {
+	// Create a document, or merge its content into the existing document of the same ID.
+	MergeDocument(doc store.Document, timeout time.Duration) (store.Document, error)
+}
+
+/*
+AliasAdmin - An optional extension of DocumentAdmin for managing the vanity aliases by which clients
+may join documents in place of their IDs.
+*/
+type AliasAdmin interface {
+	// Point an alias at an existing document.
+	SetAlias(alias, documentID string) error
+
+	// Remove an alias.
+	RemoveAlias(alias string) error
+
+	// Return every alias along with the ID of the document it points to.
+	ListAliases() map[string]string
+}
+
+/*
+RecordingLocator - An optional extension of LeapLocator for reading the recorded transform stream of
+a document, which is required for playback.
+*/
+type RecordingLocator interface {
+	// ReadRecording - Find and return the recording of a document with read only priviledges
+	ReadRecording(string, string) (lib.Recording, error)
+}
+
+/*
+PreferenceLocator - An optional extension of LeapLocator for managing the notification preferences
+of users, who are identified by their tokens.
+*/
+type PreferenceLocator interface {
+	// GetPreference - Return the notification preference of a user for a document
+	GetPreference(token, documentID string) (lib.NotificationPreference, error)
+
+	// SetPreference - Set the notification preference of a user for a document
+	SetPreference(token, documentID string, pref lib.NotificationPreference) error
+}
+
+/*
+VersionLocator - An optional extension of LeapLocator for reading documents as they were at past
+versions, which requires read only access to the document.
+*/
+type VersionLocator interface {
+	// GetDocumentVersion - Reconstruct a document as it was at a past version
+	GetDocumentVersion(token, documentID string, version int, timeout time.Duration) (store.Document, error)
+}
+
+/*
+LatencyReporter - An optional extension of LeapAdmin for reporting rolling percentiles of the time
+taken for transforms to be broadcast after submission.
+*/
+type LatencyReporter interface {
+	// Get the global and per document latency percentiles.
+	GetLatencyReport() lib.LatencyReport
+}
+
+/*
+MetricsReporter - An optional extension of LeapAdmin for exporting operational metrics in the
+Prometheus text exposition format.
+*/
+type MetricsReporter interface {
+	// Write the current metrics to a writer.
+	WriteMetrics(io.Writer) error
+}
+
 /*--------------------------------------------------------------------------------------------------
  */
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// versionTimeout - How long to wait for an open document to reconstruct a past version.
+const versionTimeout = time.Second
+
+/*
+documentVersion - Read a document as it was at a past version, as a 'document' message.
+*/
+func (h *HTTPServer) documentVersion(token, id string, version int) (LeapServerMessage, error) {
+	locator, ok := h.locator.(VersionLocator)
+	if !ok {
+		return LeapServerMessage{}, ErrVersionsNotSupported
+	}
+	if len(id) == 0 {
+		return LeapServerMessage{}, ErrInvalidDocument
+	}
+	doc, err := locator.GetDocumentVersion(token, id, version, versionTimeout)
+	if err != nil {
+		h.stats.Incr("http.version.error", 1)
+		return LeapServerMessage{}, err
+	}
+	h.stats.Incr("http.version.success", 1)
+	return LeapServerMessage{Type: "document", Document: &doc, Version: &version}, nil
+}
+
+/*
+versionsHandler - Responds with a document as it was at a past version. Clients provide the
+document_id, a token with read only access and the version, and receive a 'document' message as
+JSON. Failing authorisation results in a 403, and a version that is not retained or does not yet
+exist in a 404.
+*/
+func (h *HTTPServer) versionsHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.locator.(VersionLocator); !ok {
+		http.Error(w, "Versions are not supported", http.StatusNotImplemented)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Wrong method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	token, id := query.Get("token"), query.Get("document_id")
+	if !h.verifyClientToken(r, token) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	version, err := strconv.Atoi(query.Get("version"))
+	if err != nil {
+		http.Error(w, "Bad version", http.StatusBadRequest)
+		return
+	}
+
+	docMsg, err := h.documentVersion(token, id, version)
+	if err != nil {
+		h.logger.Warnf("Version %v of %v could not be read: %v\n", version, id, err)
+		switch {
+		case err == lib.ErrUnauthorised:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case err == ErrInvalidDocument, err == store.ErrInvalidDocumentPath:
+			http.Error(w, "Bad document_id", http.StatusBadRequest)
+		case err == lib.ErrTimeout:
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		case err == lib.ErrVersionNotRetained, err == lib.ErrVersionNotExist, err == lib.ErrBinderNotFound,
+			err == lib.ErrEmptyRecording, os.IsNotExist(err):
+			http.Error(w, "Version not found", http.StatusNotFound)
+		default:
+			http.Error(w, "Failed to read version", http.StatusInternalServerError)
+		}
+		return
+	}
+	docMsg.Signature = h.signer.Sign(docMsg)
+
+	resultBytes, err := json.Marshal(docMsg)
+	if err != nil {
+		http.Error(w, "Error encoding document", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resultBytes)
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
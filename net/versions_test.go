@@ -0,0 +1,96 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package net
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+)
+
+type fakeVersionLocator struct {
+	fakeLocator
+}
+
+func (f *fakeVersionLocator) GetDocumentVersion(token, id string, version int, timeout time.Duration) (store.Document, error) {
+	if token != "good" {
+		return store.Document{}, lib.ErrUnauthorised
+	}
+	if version > 3 {
+		return store.Document{}, lib.ErrVersionNotExist
+	}
+	return store.Document{ID: id, Content: "version of " + id}, nil
+}
+
+func TestVersionsEndpoint(t *testing.T) {
+	logger, stats := loggerAndStats()
+
+	mux := NewMux()
+	if err := mux.Handle("app/", &fakeVersionLocator{}); err != nil {
+		t.Fatal(err)
+	}
+	h := HTTPServer{
+		config:  DefaultHTTPServerConfig(),
+		locator: mux,
+		logger:  logger,
+		stats:   stats,
+	}
+
+	request := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/versions?"+query, nil)
+		w := httptest.NewRecorder()
+		h.versionsHandler(w, r)
+		return w
+	}
+
+	w := request("document_id=app/doc&token=good&version=2")
+	var msg LeapServerMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &msg); err != nil {
+		t.Fatalf("Failed to decode %q: %v", w.Body.String(), err)
+	}
+	if msg.Type != "document" || msg.Document == nil || msg.Version == nil || *msg.Version != 2 {
+		t.Fatalf("Wrong version response: %v", w.Body.String())
+	}
+	if exp, act := "version of doc", msg.Document.Content; exp != act {
+		t.Errorf("Version was not read without the route prefix: %v != %v", exp, act)
+	}
+	if exp, act := "app/doc", msg.Document.ID; exp != act {
+		t.Errorf("Wrong document ID: %v != %v", exp, act)
+	}
+
+	for query, code := range map[string]int{
+		"document_id=app/doc&token=bad&version=2":  http.StatusForbidden,
+		"document_id=app/doc&token=good&version=9": http.StatusNotFound,
+		"document_id=app/doc&token=good&version=x": http.StatusBadRequest,
+		"token=good&version=2":                     http.StatusBadRequest,
+	} {
+		if w := request(query); w.Code != code {
+			t.Errorf("Wrong status for %v: %v != %v", query, w.Code, code)
+		}
+	}
+}
@@ -23,7 +23,8 @@ THE SOFTWARE.
 package net
 
 import (
-	"fmt"
+	gonet "net"
+	"sync"
 	"time"
 
 	"github.com/jeffail/leaps/lib"
@@ -37,53 +38,116 @@ import (
 
 /*
 LeapSocketClientMessage - A structure that defines a message format to expect from clients connected
-to a text model. Commands can currently be 'submit' (submit a transform to a bound document), or
-'update' (submit an update to the users cursor position).
+to a text model. Commands can currently be 'submit' (submit a transform to a bound document),
+'update' (submit a message and/or an update to the users cursor position), 'cursor' (submit an
+update to the users cursor position only), 'signal' (send a Signal to another client, only for
+clients that agreed on the peer_assist extension), 'metadata' (change the Metadata of the document,
+where an empty value removes a key), 'comment' (send a chat message, or a comment when it has an
+anchor), 'ping' (keeps the connection alive) or 'pong' (the answer to a heartbeat 'ping', echoing
+its Ping).
 */
 type LeapSocketClientMessage struct {
-	Command   string          `json:"command" yaml:"command"`
-	Transform *lib.OTransform `json:"transform,omitempty" yaml:"transform,omitempty"`
-	Position  *int64          `json:"position,omitempty" yaml:"position,omitempty"`
-	Message   string          `json:"message,omitempty" yaml:"message,omitempty"`
+	Command   string            `json:"command" yaml:"command"`
+	Transform *lib.OTransform   `json:"transform,omitempty" yaml:"transform,omitempty"`
+	Position  *int64            `json:"position,omitempty" yaml:"position,omitempty"`
+	Message   string            `json:"message,omitempty" yaml:"message,omitempty"`
+	Signal    *lib.PeerSignal   `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Metadata  map[string]string `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Comment   *lib.Comment      `json:"comment,omitempty" yaml:"comment,omitempty"`
+	Ping      int64             `json:"ping,omitempty" yaml:"ping,omitempty"`
 }
 
 /*
 LeapSocketServerMessage - A structure that defines a response message from a text model to a client.
 Type can be 'transforms' (continuous delivery), 'correction' (actual version of a submitted
-transform), 'update' (an update to a users status) or 'error' (an error message to display to the
-client).
+transform), 'update' (an update to a users status), 'presence' (users joining or leaving, only sent
+to clients that subscribe), 'spectators' (the number of read only clients, only sent to clients that
+ask for it), 'diagnostics' (validation problems of the document, only sent to clients that ask for
+them), 'shutdown' (the document is about to close as the server shuts down), 'degraded' (the store
+of the document has become slow, or Degraded is false once it recovers), 'digest' (a summary of the
+activity held back from a client that prefers digests), 'signal' (a Signal from another client of
+the peer_assist extension), 'metadata' (the full Metadata of the document after a client changed
+it), 'comments' (chat messages and comments of other clients, along with the recent comments of the
+document when joining), 'banner' (the server wide Banner to show, which is empty once it is removed,
+also sent when joining), 'announcement' (a one-off Announcement from the operators of the server),
+'ping' (a heartbeat of the heartbeat extension, answered with a 'pong' command that echoes its
+Ping) or 'error' (an error message to display to the client). User facing errors and notices are
+localised, and carry the Code of the message for clients that render their own text. Errors also
+carry ErrorInfo, a stable error code and whether the failed request may be retried.
 */
 type LeapSocketServerMessage struct {
-	Type       string              `json:"response_type" yaml:"response_type"`
-	Transforms []lib.OTransform    `json:"transforms,omitempty" yaml:"transforms,omitempty"`
-	Updates    []lib.ClientMessage `json:"user_updates,omitempty" yaml:"user_updates,omitempty"`
-	Version    int                 `json:"version,omitempty" yaml:"version,omitempty"`
-	Error      string              `json:"error,omitempty" yaml:"error,omitempty"`
+	Type         string              `json:"response_type" yaml:"response_type"`
+	Transforms   []lib.OTransform    `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+	Updates      []lib.ClientMessage `json:"user_updates,omitempty" yaml:"user_updates,omitempty"`
+	Version      int                 `json:"version,omitempty" yaml:"version,omitempty"`
+	Error        string              `json:"error,omitempty" yaml:"error,omitempty"`
+	Presence     []PresenceEvent     `json:"presence,omitempty" yaml:"presence,omitempty"`
+	Spectators   *int                `json:"spectators,omitempty" yaml:"spectators,omitempty"`
+	Diagnostics  []string            `json:"diagnostics,omitempty" yaml:"diagnostics,omitempty"`
+	Degraded     *bool               `json:"degraded,omitempty" yaml:"degraded,omitempty"`
+	Digest       *lib.ActivityDigest `json:"digest,omitempty" yaml:"digest,omitempty"`
+	Signal       *lib.PeerSignal     `json:"signal,omitempty" yaml:"signal,omitempty"`
+	Metadata     map[string]string   `json:"metadata,omitempty" yaml:"metadata,omitempty"`
+	Comments     []lib.Comment       `json:"comments,omitempty" yaml:"comments,omitempty"`
+	Banner       *string             `json:"banner,omitempty" yaml:"banner,omitempty"`
+	Announcement *lib.Announcement   `json:"announcement,omitempty" yaml:"announcement,omitempty"`
+	Ping         int64               `json:"ping,omitempty" yaml:"ping,omitempty"`
+	Notice       string              `json:"notice,omitempty" yaml:"notice,omitempty"`
+	Code         string              `json:"code,omitempty" yaml:"code,omitempty"`
+	ErrorInfo    *ErrorInfo          `json:"error_info,omitempty" yaml:"error_info,omitempty"`
+	Signature    string              `json:"signature,omitempty" yaml:"signature,omitempty"`
 }
 
 /*--------------------------------------------------------------------------------------------------
  */
 
 /*
-WebsocketServer - A websocket client that connects a binder of a document to a websocket client.
+WebsocketServer - Connects a binder of a document to a client, over a websocket or any other
+Transport.
 */
 type WebsocketServer struct {
 	config    HTTPBinderConfig
 	logger    *log.Logger
 	stats     *log.Stats
-	socket    *websocket.Conn
+	socket    Transport
 	binder    lib.BinderPortal
+	docID     string
+	signer    *Signer
+	messages  *Messages
+	locale    string
+	presence  PresenceOptions
+	banner    string
+	deflater  *messageDeflater
+	rttChan   chan time.Duration
 	closeChan <-chan bool
+	sendMutex sync.Mutex
 }
 
 /*
-NewWebsocketServer - Creates a new HTTP websocket client.
+NewWebsocketServer - Creates a new HTTP websocket client, the signer may be nil.
 */
 func NewWebsocketServer(
 	config HTTPBinderConfig,
 	socket *websocket.Conn,
 	binder lib.BinderPortal,
 	closeChan <-chan bool,
+	signer *Signer,
+	logger *log.Logger,
+	stats *log.Stats,
+) *WebsocketServer {
+	return NewTransportServer(config, newWebsocketTransport(socket), binder, closeChan, signer, logger, stats)
+}
+
+/*
+NewTransportServer - Creates a new client of a binder connected over a Transport, the signer may be
+nil.
+*/
+func NewTransportServer(
+	config HTTPBinderConfig,
+	socket Transport,
+	binder lib.BinderPortal,
+	closeChan <-chan bool,
+	signer *Signer,
 	logger *log.Logger,
 	stats *log.Stats,
 ) *WebsocketServer {
@@ -91,6 +155,9 @@ func NewWebsocketServer(
 		config:    config,
 		socket:    socket,
 		binder:    binder,
+		docID:     binder.Document.ID,
+		signer:    signer,
+		rttChan:   make(chan time.Duration, 1),
 		closeChan: closeChan,
 		logger:    logger.NewModule(":socket"),
 		stats:     stats,
@@ -100,6 +167,79 @@ func NewWebsocketServer(
 /*--------------------------------------------------------------------------------------------------
  */
 
+/*
+SetPresence - Set the presence preferences of the client, must be called before Launch.
+*/
+func (w *WebsocketServer) SetPresence(options PresenceOptions) {
+	w.presence = options
+}
+
+/*
+SetMessages - Set the messages and locale used for the user facing messages sent to the client, must
+be called before Launch. Messages are sent in English otherwise.
+*/
+func (w *WebsocketServer) SetMessages(messages *Messages, locale string) {
+	w.messages = messages
+	w.locale = locale
+}
+
+/*
+SetBanner - Set the server wide banner sent to the client when it joins, must be called before
+Launch.
+*/
+func (w *WebsocketServer) SetBanner(banner string) {
+	w.banner = banner
+}
+
+/*
+setDeflater - Compress large messages sent to the client, which must have agreed on the deflate
+extension. Must be called before Launch.
+*/
+func (w *WebsocketServer) setDeflater(deflater *messageDeflater) {
+	w.deflater = deflater
+}
+
+/*
+sendError - Send a user facing error message to the client in its locale, along with the structured
+form of the underlying error, which may be nil.
+*/
+func (w *WebsocketServer) sendError(code string, err error) error {
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	return w.send(LeapSocketServerMessage{
+		Type:      "error",
+		Error:     w.messages.Format(w.locale, code, detail),
+		Code:      code,
+		ErrorInfo: classifyError(code, err),
+	})
+}
+
+/*
+notice - Returns a user facing notice in the locale of the client, which accompanies a message type.
+*/
+func (w *WebsocketServer) notice(msgType, code string) LeapSocketServerMessage {
+	return LeapSocketServerMessage{
+		Type:   msgType,
+		Notice: w.messages.Format(w.locale, code, ""),
+		Code:   code,
+	}
+}
+
+/*
+send - Sign a message if signing is enabled, and send it to the client, compressed if it is large and
+the client agreed on the deflate extension. Sends are serialised, since both the incoming and the
+outgoing routers reply to the client and a Transport must never be sent to concurrently.
+*/
+func (w *WebsocketServer) send(msg LeapSocketServerMessage) error {
+	w.sendMutex.Lock()
+	defer w.sendMutex.Unlock()
+	msg.Signature = w.signer.Sign(msg)
+	tracer.trace(w.binder.Token, w.docID, "out", msg)
+	return w.deflater.send(w.socket, msg)
+}
+
 /*
 Launch - Launches the client, wrapping two goroutines around a connected websocket and a
 BinderPortal. This call spawns two goroutines and blocks until both are closed. One goroutine
@@ -112,6 +252,30 @@ func (w *WebsocketServer) Launch() {
 	// TODO: Preserve reference of doc ID?
 	w.binder.Document = store.Document{}
 
+	// Send the cursor positions of existing clients
+	if len(w.binder.Cursors) > 0 {
+		w.send(LeapSocketServerMessage{
+			Type:    "update",
+			Updates: w.binder.Cursors,
+		})
+		w.binder.Cursors = nil
+	}
+	if len(w.binder.Comments) > 0 {
+		w.send(LeapSocketServerMessage{
+			Type:     "comments",
+			Comments: w.binder.Comments,
+		})
+		w.binder.Comments = nil
+	}
+	if len(w.banner) > 0 {
+		w.send(LeapSocketServerMessage{Type: "banner", Banner: &w.banner})
+	}
+	if w.binder.Degraded {
+		msg := w.notice("degraded", MessageDocumentDegraded)
+		msg.Degraded = &w.binder.Degraded
+		w.send(msg)
+	}
+
 	defer func() {
 		w.binder.Exit(bindTOut)
 	}()
@@ -127,22 +291,19 @@ func (w *WebsocketServer) Launch() {
 	go w.loopIncoming(incomingClosedChan, incomingCloseChan)
 	go w.loopOutgoing(outgoingClosedChan, outgoingCloseChan)
 
+	// Announce ourselves only once we are ready to receive the responses of other clients
+	w.announceJoin()
+
 	// If one channel closes, close the other, if the socket is being closed then close both.
 	select {
 	case <-incomingClosedChan:
 		close(outgoingCloseChan)
 		<-outgoingClosedChan
-		w.binder.SendMessage(lib.ClientMessage{
-			Active: false,
-			Token:  w.binder.Token,
-		})
+		w.announceLeave()
 	case <-outgoingClosedChan:
 		close(incomingCloseChan)
 		<-incomingClosedChan
-		w.binder.SendMessage(lib.ClientMessage{
-			Active: false,
-			Token:  w.binder.Token,
-		})
+		w.announceLeave()
 	case <-w.closeChan:
 		close(incomingCloseChan)
 		close(outgoingCloseChan)
@@ -153,6 +314,7 @@ func (w *WebsocketServer) Launch() {
 
 func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmdChan <-chan struct{}) {
 	bindTOut := time.Duration(w.config.BindSendTimeout) * time.Millisecond
+	heartbeatTOut := time.Duration(w.config.Heartbeat.TimeoutMS) * time.Millisecond
 
 	for {
 		select {
@@ -163,9 +325,15 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 		default:
 		}
 
+		// Clients that go quiet for longer than the heartbeat timeout are assumed gone.
+		if deadliner, ok := w.socket.(readDeadliner); ok && heartbeatTOut > 0 {
+			deadliner.SetReadDeadline(time.Now().Add(heartbeatTOut))
+		}
+
 		var msg LeapSocketClientMessage
-		if err := websocket.JSON.Receive(w.socket, &msg); err == nil {
+		if err := w.socket.Receive(&msg); err == nil {
 			w.logger.Tracef("Received %v command from client\n", msg.Command)
+			tracer.trace(w.binder.Token, w.docID, "in", msg)
 
 			timeStarted := time.Now()
 
@@ -173,17 +341,14 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 			case "submit":
 				if msg.Transform == nil {
 					w.logger.Errorln("Client submit contained nil transform")
-					websocket.JSON.Send(w.socket, LeapSocketServerMessage{
-						Type:  "error",
-						Error: "submit error: transform was nil",
-					})
+					w.sendError(MessageTransformMissing, nil)
 					w.logger.Debugln("Closing websocket due to nil transform")
 					closeSignalChan <- struct{}{}
 					return
 				}
 				if ver, err := w.binder.SendTransform(*msg.Transform, bindTOut); err == nil {
 					w.logger.Traceln("Sending correction to client")
-					websocket.JSON.Send(w.socket, LeapSocketServerMessage{
+					w.send(LeapSocketServerMessage{
 						Type:    "correction",
 						Version: ver,
 					})
@@ -191,10 +356,7 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 					w.stats.Timing("http.websocket.submit.timer", time.Since(timeStarted).Seconds())
 				} else {
 					w.logger.Errorf("Transform request failed %v\n", err)
-					websocket.JSON.Send(w.socket, LeapSocketServerMessage{
-						Type:  "error",
-						Error: fmt.Sprintf("submit error: %v", err),
-					})
+					w.sendError(MessageSubmitFailed, err)
 					w.logger.Debugln("Closing websocket due to failed transform send")
 					w.stats.Incr("http.websocket.submit.error", 1)
 					closeSignalChan <- struct{}{}
@@ -206,17 +368,45 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 						Message:  msg.Message,
 						Position: msg.Position,
 						Active:   true,
-						Token:    w.binder.Token,
 					})
 				}
+			case "cursor":
+				if msg.Position != nil {
+					w.binder.SendCursor(*msg.Position)
+				} else {
+					w.sendError(MessagePositionMissing, nil)
+				}
+			case "signal":
+				w.forwardSignal(msg.Signal)
+			case "metadata":
+				if msg.Metadata != nil {
+					w.binder.SendDocumentMetadata(msg.Metadata)
+				} else {
+					w.sendError(MessageMetadataMissing, nil)
+				}
+			case "comment":
+				if msg.Comment != nil {
+					w.binder.SendComment(*msg.Comment)
+				} else {
+					w.sendError(MessageCommentMissing, nil)
+				}
 			case "ping":
 				// Do nothing
+			case "pong":
+				if rtt, ok := pongRTT(msg.Ping, time.Now()); ok {
+					select {
+					case w.rttChan <- rtt:
+					default:
+					}
+				}
 			default:
-				websocket.JSON.Send(w.socket, LeapSocketServerMessage{
-					Type:  "error",
-					Error: "command not recognised",
-				})
+				w.sendError(MessageUnknownCommand, nil)
 			}
+		} else if netErr, ok := err.(gonet.Error); ok && netErr.Timeout() {
+			w.logger.Infof("Closing websocket of client silent for %v\n", heartbeatTOut)
+			w.stats.Incr("http.websocket.reaped", 1)
+			closeSignalChan <- struct{}{}
+			return
 		} else {
 			w.logger.Traceln("Websocket closed, closing client")
 			closeSignalChan <- struct{}{}
@@ -225,35 +415,178 @@ func (w *WebsocketServer) loopIncoming(closeSignalChan chan<- struct{}, closeCmd
 	}
 }
 
+/*
+backlog - Returns the number of transforms waiting to be sent to the client.
+*/
+func (w *WebsocketServer) backlog() int {
+	return len(w.binder.TransformRcvChan) + len(w.binder.BatchRcvChan)
+}
+
 func (w *WebsocketServer) loopOutgoing(closeSignalChan chan<- struct{}, closeCmdChan <-chan struct{}) {
+	health := newSendHealth(w.config.Adaptive)
+
+	var pingChan <-chan time.Time
+	if interval := w.config.Heartbeat.IntervalMS; interval > 0 &&
+		hasExtension(w.presence.Extensions, ExtensionHeartbeat) {
+		ticker := time.NewTicker(time.Duration(interval) * time.Millisecond)
+		defer ticker.Stop()
+		pingChan = ticker.C
+	}
+
+	// Messages held back from a struggling client, sent once windowChan fires
+	var held coalescedMessages
+	var windowChan <-chan time.Time
+
+	sendTransforms := func(tforms []lib.OTransform) {
+		started := time.Now()
+		w.send(LeapSocketServerMessage{
+			Type:       "transforms",
+			Transforms: tforms,
+		})
+		health.observe(time.Since(started), w.backlog())
+	}
+	flushHeld := func() {
+		if len(held.transforms) > 0 {
+			w.logger.Tracef("Sending %v coalesced transforms to client\n", len(held.transforms))
+			sendTransforms(held.transforms)
+		}
+		if len(held.updates) > 0 {
+			w.send(LeapSocketServerMessage{
+				Type:    "update",
+				Updates: held.updates,
+			})
+		}
+		held, windowChan = coalescedMessages{}, nil
+	}
+	hold := func() bool {
+		if health.window == 0 {
+			return false
+		}
+		if held.empty() {
+			w.stats.Incr("http.websocket.coalesced_window", 1)
+			windowChan = time.After(health.window)
+		}
+		return true
+	}
+
 	for {
 		select {
 		case <-closeCmdChan:
 			w.logger.Debugln("Closing websocket outgoing router")
 			closeSignalChan <- struct{}{}
 			return
+		case <-windowChan:
+			flushHeld()
+		case now := <-pingChan:
+			w.send(LeapSocketServerMessage{Type: "ping", Ping: pingTime(now)})
+		case rtt := <-w.rttChan:
+			w.stats.Timing("http.websocket.rtt", rtt.Seconds())
+			health.observeRTT(rtt, w.backlog())
 		case tform, open := <-w.binder.TransformRcvChan:
 			if !open {
 				w.logger.Debugln("Closing websocket due to closed transform channel")
+				flushHeld()
 				closeSignalChan <- struct{}{}
 				return
 			}
+			if hold() {
+				held.transforms = append(held.transforms, tform)
+				continue
+			}
 			w.logger.Traceln("Sending transform to client")
-			websocket.JSON.Send(w.socket, LeapSocketServerMessage{
-				Type:       "transforms",
-				Transforms: []lib.OTransform{tform},
-			})
+			sendTransforms([]lib.OTransform{tform})
+		case tforms := <-w.binder.BatchRcvChan:
+			if hold() {
+				held.transforms = append(held.transforms, tforms...)
+				continue
+			}
+			w.logger.Traceln("Sending transform batch to client")
+			sendTransforms(tforms)
 		case msg, open := <-w.binder.MessageRcvChan:
 			if !open {
 				w.logger.Debugln("Closing websocket due to closed message channel")
+				flushHeld()
 				closeSignalChan <- struct{}{}
 				return
 			}
-			w.logger.Traceln("Sending update to client")
-			websocket.JSON.Send(w.socket, LeapSocketServerMessage{
-				Type:    "update",
-				Updates: []lib.ClientMessage{msg},
-			})
+			if isUpdate(msg) {
+				if hold() {
+					held.addUpdate(msg)
+					continue
+				}
+				w.logger.Traceln("Sending update to client")
+				w.send(LeapSocketServerMessage{
+					Type:    "update",
+					Updates: []lib.ClientMessage{msg},
+				})
+				continue
+			}
+			// Notices must not overtake the transforms held back before them.
+			flushHeld()
+			if msg.Spectators != nil {
+				w.forwardSpectators(*msg.Spectators)
+				continue
+			}
+			if len(msg.Diagnostics) > 0 {
+				w.forwardDiagnostics(msg.Diagnostics)
+				continue
+			}
+			if len(msg.Presence) > 0 {
+				w.forwardPresence(msg)
+				continue
+			}
+			if msg.Signal != nil {
+				if w.presence.PeerAssist {
+					w.logger.Traceln("Sending peer signal to client")
+					w.send(LeapSocketServerMessage{Type: "signal", Signal: msg.Signal})
+				}
+				continue
+			}
+			if msg.DocumentMetadata != nil {
+				w.logger.Traceln("Sending document metadata to client")
+				w.send(LeapSocketServerMessage{Type: "metadata", Metadata: msg.DocumentMetadata})
+				continue
+			}
+			if msg.Comment != nil {
+				w.logger.Traceln("Sending comment to client")
+				w.send(LeapSocketServerMessage{Type: "comments", Comments: []lib.Comment{*msg.Comment}})
+				continue
+			}
+			if msg.Banner != nil {
+				w.logger.Traceln("Sending banner to client")
+				w.send(LeapSocketServerMessage{Type: "banner", Banner: msg.Banner})
+				continue
+			}
+			if msg.Announcement != nil {
+				w.logger.Traceln("Sending announcement to client")
+				w.send(LeapSocketServerMessage{Type: "announcement", Announcement: msg.Announcement})
+				continue
+			}
+			if msg.Digest != nil {
+				w.logger.Traceln("Sending activity digest to client")
+				w.send(LeapSocketServerMessage{Type: "digest", Digest: msg.Digest})
+				continue
+			}
+			if msg.Kicked {
+				w.logger.Debugln("Sending kick notice to client")
+				w.sendError(MessageKicked, nil)
+				continue
+			}
+			if msg.Shutdown {
+				w.logger.Debugln("Sending shutdown notice to client")
+				w.send(w.notice("shutdown", MessageDocumentClosing))
+				continue
+			}
+			if msg.Degraded != nil {
+				w.logger.Debugln("Sending degraded notice to client")
+				code := MessageDocumentRecovered
+				if *msg.Degraded {
+					code = MessageDocumentDegraded
+				}
+				notice := w.notice("degraded", code)
+				notice.Degraded = msg.Degraded
+				w.send(notice)
+			}
 		}
 	}
 }
@@ -0,0 +1,199 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jeffail/leaps/lib"
+	"github.com/jeffail/leaps/lib/store"
+	"github.com/jeffail/leaps/net"
+	"golang.org/x/net/websocket"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+ReplayConfig - Configuration for the replay command, which plays a recording back against a new
+document of the leaps server at URL. The pauses between transforms are divided by Speed, and are
+never longer than MaxPause milliseconds.
+*/
+type ReplayConfig struct {
+	URL      string  `json:"url" yaml:"url"`
+	Origin   string  `json:"origin" yaml:"origin"`
+	Speed    float64 `json:"speed" yaml:"speed"`
+	MaxPause int64   `json:"max_pause_ms" yaml:"max_pause_ms"`
+}
+
+/*
+NewReplayConfig - Returns a default replay configuration.
+*/
+func NewReplayConfig() ReplayConfig {
+	return ReplayConfig{
+		URL:      "ws://localhost:8080/leaps/socket",
+		Origin:   "http://localhost/",
+		Speed:    1,
+		MaxPause: 5000,
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+var (
+	replayRecording *string
+	replaySpeed     *float64
+)
+
+func init() {
+	replayRecording = flag.String("recording", "", "The recording to play back with the replay command")
+	replaySpeed = flag.Float64("speed", 0, "Override the playback speed of the replay command")
+}
+
+var (
+	errNoRecording  = errors.New("replay requires a recording, set it with -recording")
+	errInvalidSpeed = errors.New("replay speed must be greater than zero")
+)
+
+/*
+isReplayCommand - Checks whether leaps was run as `leaps replay`, and if so removes the command from
+the arguments so that the remaining flags are parsed as normal.
+*/
+func isReplayCommand() bool {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		return true
+	}
+	return false
+}
+
+/*
+runReplay - Plays a recording back against a new document, printing the ID of the document so that
+it can be watched. Returns the exit code, which is non-zero if the replay did not complete.
+*/
+func runReplay(leapsConfig LeapsConfig) int {
+	config := leapsConfig.ReplayConfig
+	if *replaySpeed > 0 {
+		config.Speed = *replaySpeed
+	}
+	if len(*replayRecording) == 0 {
+		fmt.Fprintln(os.Stderr, errNoRecording)
+		return 1
+	}
+	if config.Speed <= 0 {
+		fmt.Fprintln(os.Stderr, errInvalidSpeed)
+		return 1
+	}
+
+	recording, err := lib.ReadRecording(*replayRecording)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Recording error: %v\n", err))
+		return 1
+	}
+
+	ws, err := websocket.Dial(config.URL, "", config.Origin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Connection error: %v\n", err))
+		return 1
+	}
+	defer ws.Close()
+
+	first := recording.Bindings[0]
+	doc, _ := store.NewDocument(first.Document.Content)
+	doc.Type = first.Document.Type
+
+	if err = websocket.JSON.Send(ws, net.LeapClientMessage{Command: "create", Document: doc}); err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Create error: %v\n", err))
+		return 1
+	}
+	var created net.LeapServerMessage
+	if err = websocket.JSON.Receive(ws, &created); err != nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Create error: %v\n", err))
+		return 1
+	}
+	if created.Type != "document" || created.Document == nil || created.Version == nil {
+		fmt.Fprintln(os.Stderr, fmt.Sprintf("Create error: %v\n", created.Error))
+		return 1
+	}
+
+	fmt.Printf("Replaying %v bindings into document %v\n", len(recording.Bindings), created.Document.ID)
+
+	maxPause := time.Duration(config.MaxPause) * time.Millisecond
+	version := *created.Version
+
+	last := first.Started
+	for _, binding := range recording.Bindings {
+		// Versions begin again with each binding, and are shifted to follow on from the version the
+		// new document has reached.
+		offset := version - binding.Version
+		for _, entry := range binding.Transforms {
+			pause := time.Duration(float64(entry.Time.Sub(last)) / config.Speed)
+			if pause > maxPause {
+				pause = maxPause
+			}
+			time.Sleep(pause)
+			last = entry.Time
+
+			ot := *entry.Transform
+			ot.Version += offset
+			if err = submitReplayTransform(ws, ot); err != nil {
+				fmt.Fprintln(os.Stderr, fmt.Sprintf("Submit error: %v\n", err))
+				return 1
+			}
+			version = ot.Version
+		}
+	}
+
+	fmt.Println("Replay finished")
+	return 0
+}
+
+/*
+submitReplayTransform - Submit a transform and wait for its correction, skipping over any other
+messages sent to us in the meantime.
+*/
+func submitReplayTransform(ws *websocket.Conn, ot lib.OTransform) error {
+	if err := websocket.JSON.Send(ws, net.LeapSocketClientMessage{Command: "submit", Transform: &ot}); err != nil {
+		return err
+	}
+	for {
+		var msg net.LeapSocketServerMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			return err
+		}
+		switch msg.Type {
+		case "correction":
+			return nil
+		case "error":
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
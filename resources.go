@@ -0,0 +1,80 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package main
+
+import (
+	leapsutil "github.com/jeffail/leaps/lib/util"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+const (
+	// The memory set aside for each open binder when deriving a binder limit, this covers the
+	// document, its transform history and the buffers of a handful of clients.
+	binderMemoryBudget = 1 << 20
+
+	// Containers with less memory than this keep a proportionally shorter transform history.
+	historyMemoryThreshold = 512 << 20
+
+	// Lower bounds for derived values, regardless of how constrained the container is.
+	minOpenBinders   = 16
+	minHistoryLength = 100
+)
+
+/*
+applyResourceDefaults - Detects the CPU and memory limits of the container leaps is running in and
+derives defaults for the number of processes, the number of open binders, the transform history
+length and the SQL connection pool from them. This must be called before the config is loaded so
+that explicitly configured values take precedence. The detected limits are returned for logging.
+*/
+func applyResourceDefaults(config *LeapsConfig) leapsutil.ResourceLimits {
+	limits := leapsutil.DetectResourceLimits()
+	deriveResourceDefaults(config, limits)
+	return limits
+}
+
+func deriveResourceDefaults(config *LeapsConfig, limits leapsutil.ResourceLimits) {
+	if limits.CPUs > 0 {
+		config.NumProcesses = limits.Processes()
+		config.StoreConfig.SQLConfig.MaxOpenConns = 4 * config.NumProcesses
+	}
+	if limits.Memory > 0 {
+		maxBinders := int(limits.Memory / 4 * 3 / binderMemoryBudget)
+		if maxBinders < minOpenBinders {
+			maxBinders = minOpenBinders
+		}
+		config.CuratorConfig.MaxOpenBinders = maxBinders
+
+		if limits.Memory < historyMemoryThreshold {
+			history := int(int64(config.CuratorConfig.BinderConfig.HistoryLength) * limits.Memory / historyMemoryThreshold)
+			if history < minHistoryLength {
+				history = minHistoryLength
+			}
+			config.CuratorConfig.BinderConfig.HistoryLength = history
+		}
+	}
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
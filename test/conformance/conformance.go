@@ -0,0 +1,252 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+/*
+Package conformance - The fixtures that leaps is tested against, exported so that the authors of
+third party clients can run the exact same convergence and protocol suites. The fixtures are JSON
+files under a directory of their version, see README.md for their format, and this package embeds
+them for Go clients.
+
+The version is only increased when fixtures change in a way that a conforming client could fail,
+fixtures may be added within a version.
+*/
+package conformance
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+// Version - The version of the fixtures embedded in this package.
+const Version = 1
+
+//go:embed v1/*.json
+var fixtures embed.FS
+
+// Errors for loading fixtures.
+var (
+	ErrVersionMismatch = errors.New("fixture file is of a different version")
+)
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+Transform - A transform as sent over the wire, where Version is the version the transform is
+submitted for or, when received, the version it was applied as.
+*/
+type Transform struct {
+	Position int    `json:"position" yaml:"position"`
+	Delete   int    `json:"num_delete" yaml:"num_delete"`
+	Insert   string `json:"insert" yaml:"insert"`
+	Version  int    `json:"version,omitempty" yaml:"version,omitempty"`
+}
+
+/*
+TransformStory - A sequence of transforms applied directly to a document model, after which the
+content of the document is Result. Where CorrectedTransforms is given each transform is corrected
+against those before it into its counterpart, although many stories only check the Result. Flushes
+lists the indexes of the transforms after which the model is flushed, which must not change the
+outcome.
+*/
+type TransformStory struct {
+	Name                string      `json:"name" yaml:"name"`
+	Content             string      `json:"content" yaml:"content"`
+	Transforms          []Transform `json:"transforms" yaml:"transforms"`
+	CorrectedTransforms []Transform `json:"corrected_transforms" yaml:"corrected_transforms"`
+	Result              string      `json:"result" yaml:"result"`
+	Flushes             []int       `json:"flushes,omitempty" yaml:"flushes,omitempty"`
+}
+
+/*
+BinderStory - A sequence of transforms submitted by one client of a document, where every other
+client must receive exactly the CorrectedTransforms and end with the content Result.
+*/
+type BinderStory struct {
+	Name                string      `json:"name" yaml:"name"`
+	Content             string      `json:"content" yaml:"content"`
+	Transforms          []Transform `json:"transforms" yaml:"transforms"`
+	CorrectedTransforms []Transform `json:"corrected_transforms" yaml:"corrected_transforms"`
+	Result              string      `json:"result" yaml:"result"`
+}
+
+/*
+ClientEpoch - A round of a client story, the client sends its transforms and then receives the
+server messages, after which its local content must be Result.
+*/
+type ClientEpoch struct {
+	Send    []Transform     `json:"send" yaml:"send"`
+	Receive []ServerMessage `json:"receive" yaml:"receive"`
+	Result  string          `json:"result" yaml:"result"`
+}
+
+/*
+ServerMessage - The parts of a server message that client stories depend on.
+*/
+type ServerMessage struct {
+	Type       string      `json:"response_type" yaml:"response_type"`
+	Version    int         `json:"version,omitempty" yaml:"version,omitempty"`
+	Transforms []Transform `json:"transforms,omitempty" yaml:"transforms,omitempty"`
+}
+
+/*
+ClientStory - Exercises the transform correction of a client, which creates a document with Content
+and then plays each of the Epochs, ending with the content Result.
+*/
+type ClientStory struct {
+	Name    string        `json:"name" yaml:"name"`
+	Content string        `json:"content" yaml:"content"`
+	Epochs  []ClientEpoch `json:"epochs" yaml:"epochs"`
+	Result  string        `json:"result" yaml:"result"`
+}
+
+/*
+ProtocolStep - A step of a protocol fixture, which is either a message to Send to the server or a
+message to Expect from it. Expected messages match when every field they contain is present with the
+same value in the received message, which may contain other fields as well.
+*/
+type ProtocolStep struct {
+	Send   json.RawMessage `json:"send,omitempty" yaml:"send,omitempty"`
+	Expect json.RawMessage `json:"expect,omitempty" yaml:"expect,omitempty"`
+}
+
+/*
+ProtocolFixture - A conversation between a single client and a server over a fresh websocket
+connection.
+*/
+type ProtocolFixture struct {
+	Name        string         `json:"name" yaml:"name"`
+	Description string         `json:"description" yaml:"description"`
+	Steps       []ProtocolStep `json:"steps" yaml:"steps"`
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
+
+/*
+load - Parse a fixture file into an object, checking that it is of the embedded version.
+*/
+func load(name string, into interface{}) error {
+	fileBytes, err := fixtures.ReadFile(fmt.Sprintf("v%v/%v", Version, name))
+	if err != nil {
+		return err
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err = json.Unmarshal(fileBytes, &header); err != nil {
+		return fmt.Errorf("failed to parse %v: %v", name, err)
+	}
+	if header.Version != Version {
+		return ErrVersionMismatch
+	}
+	if err = json.Unmarshal(fileBytes, into); err != nil {
+		return fmt.Errorf("failed to parse %v: %v", name, err)
+	}
+	return nil
+}
+
+/*
+TransformStories - Returns the stories for document models.
+*/
+func TransformStories() ([]TransformStory, error) {
+	var container struct {
+		Stories []TransformStory `json:"stories"`
+	}
+	err := load("transform_stories.json", &container)
+	return container.Stories, err
+}
+
+/*
+BinderStories - Returns the stories for clients of a shared document.
+*/
+func BinderStories() ([]BinderStory, error) {
+	var container struct {
+		Stories []BinderStory `json:"binder_stories"`
+	}
+	err := load("binder_stories.json", &container)
+	return container.Stories, err
+}
+
+/*
+ClientStories - Returns the stories for client transform correction.
+*/
+func ClientStories() ([]ClientStory, error) {
+	var container struct {
+		Stories []ClientStory `json:"client_stories"`
+	}
+	err := load("client_stories.json", &container)
+	return container.Stories, err
+}
+
+/*
+ProtocolFixtures - Returns the fixtures of the websocket protocol.
+*/
+func ProtocolFixtures() ([]ProtocolFixture, error) {
+	var container struct {
+		Fixtures []ProtocolFixture `json:"protocol_fixtures"`
+	}
+	err := load("protocol_fixtures.json", &container)
+	return container.Fixtures, err
+}
+
+/*
+Matches - Returns whether a received message matches an expected one, meaning that every field of
+the expected message is present in the received message with the same value. Objects are matched
+in the same way recursively, whereas other values, including arrays, must be equal.
+*/
+func Matches(expected, received json.RawMessage) bool {
+	var e, r interface{}
+	if json.Unmarshal(expected, &e) != nil || json.Unmarshal(received, &r) != nil {
+		return false
+	}
+	return matches(e, r)
+}
+
+func matches(expected, received interface{}) bool {
+	eObj, ok := expected.(map[string]interface{})
+	if !ok {
+		eBytes, _ := json.Marshal(expected)
+		rBytes, _ := json.Marshal(received)
+		return string(eBytes) == string(rBytes)
+	}
+	rObj, ok := received.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for key, value := range eObj {
+		rValue, exists := rObj[key]
+		if !exists || !matches(value, rValue) {
+			return false
+		}
+	}
+	return true
+}
+
+/*--------------------------------------------------------------------------------------------------
+ */
@@ -0,0 +1,120 @@
+/*
+Copyright (c) 2014 Ashley Jeffs
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+package conformance
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func apply(content string, tforms []Transform) string {
+	runes := []rune(content)
+	for _, t := range tforms {
+		end := t.Position + t.Delete
+		if end > len(runes) {
+			end = len(runes)
+		}
+		runes = append(runes[:t.Position], append([]rune(t.Insert), runes[end:]...)...)
+	}
+	return string(runes)
+}
+
+func TestStoriesConsistent(t *testing.T) {
+	binderStories, err := BinderStories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformStories, err := TransformStories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(binderStories) == 0 || len(transformStories) == 0 {
+		t.Fatal("No stories were loaded")
+	}
+
+	for _, story := range binderStories {
+		if result := apply(story.Content, story.CorrectedTransforms); result != story.Result {
+			t.Errorf("Binder story %v: %v != %v", story.Name, result, story.Result)
+		}
+	}
+	for _, story := range transformStories {
+		if len(story.CorrectedTransforms) != len(story.Transforms) {
+			continue
+		}
+		if result := apply(story.Content, story.CorrectedTransforms); result != story.Result {
+			t.Errorf("Transform story %v: %v != %v", story.Name, result, story.Result)
+		}
+	}
+
+	clientStories, err := ClientStories()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, story := range clientStories {
+		if len(story.Epochs) == 0 || story.Epochs[len(story.Epochs)-1].Result != story.Result {
+			t.Errorf("Client story %v does not end with its result", story.Name)
+		}
+	}
+}
+
+func TestProtocolFixtures(t *testing.T) {
+	fixtures, err := ProtocolFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("No fixtures were loaded")
+	}
+
+	names := map[string]struct{}{}
+	for _, fixture := range fixtures {
+		if _, exists := names[fixture.Name]; exists {
+			t.Errorf("Duplicate fixture name: %v", fixture.Name)
+		}
+		names[fixture.Name] = struct{}{}
+
+		for i, step := range fixture.Steps {
+			if (len(step.Send) == 0) == (len(step.Expect) == 0) {
+				t.Errorf("Step %v of %v must either send or expect", i, fixture.Name)
+			}
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for _, test := range []struct {
+		expected, received string
+		match              bool
+	}{
+		{`{"response_type":"correction"}`, `{"response_type":"correction","version":2}`, true},
+		{`{"response_type":"correction","version":2}`, `{"response_type":"correction"}`, false},
+		{`{"error_info":{"code":"X"}}`, `{"error_info":{"code":"X","retryable":true}}`, true},
+		{`{"error_info":{"code":"X"}}`, `{"error_info":{"code":"Y"}}`, false},
+		{`{"transforms":[{"position":1}]}`, `{"transforms":[{"position":1}]}`, true},
+		{`{"transforms":[{"position":1}]}`, `{"transforms":[{"position":1},{"position":2}]}`, false},
+	} {
+		if m := Matches(json.RawMessage(test.expected), json.RawMessage(test.received)); m != test.match {
+			t.Errorf("Wrong match of %v with %v: %v", test.expected, test.received, m)
+		}
+	}
+}